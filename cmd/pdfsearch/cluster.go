@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+
+	"github.com/peterwilliams97/pdf-search/doclib"
+)
+
+// runWorker runs an index worker that indexes the files a `pdfsearch cluster` coordinator sends
+// it into a store and serves searches of the store. See doclib/distributed_index.go.
+func runWorker(args []string) error {
+	fs, persistDir := newFlagSet("worker")
+	addr := ":8090"
+	var src string
+	opts := doclib.DefaultIndexOptions()
+	fs.StringVar(&addr, "addr", addr, "Address to listen on.")
+	fs.StringVar(&src, "src", "",
+		"Read the files from this cloud storage URL, e.g. s3://bucket/prefix, instead of locally.")
+	fs.IntVar(&opts.NumWorkers, "w", opts.NumWorkers, "Number of text extraction worker threads.")
+	fs.DurationVar(&opts.FileTimeout, "timeout", 0,
+		"Give up on files that take longer than this to extract. e.g. 5m")
+	fs.BoolVar(&opts.Isolate, "isolate", false,
+		"Extract each file in a child process so that files that crash the PDF library can't "+
+			"stop the run.")
+	parseArgs(fs, args, 0)
+
+	if src != "" {
+		source, err := cloudSource([]string{src})
+		if err != nil {
+			return err
+		}
+		if source == nil {
+			return fmt.Errorf("%q is not an s3:// or gs:// URL", src)
+		}
+		opts.Source = source
+	}
+	w := doclib.NewIndexWorker(*persistDir, opts)
+	defer w.Close()
+	fmt.Printf("Index worker for %q on %q\n", *persistDir, addr)
+	return http.ListenAndServe(addr, w)
+}
+
+// runCluster indexes the files in `args` with the index workers given by -workers and writes a
+// manifest of the workers' shard stores that `pdfsearch search -cluster` searches. It doesn't use
+// a store so it has no -s option.
+func runCluster(args []string) error {
+	fs := flag.NewFlagSet("cluster", flag.ExitOnError)
+	var workers string
+	manifestPath := "cluster.json"
+	fs.StringVar(&workers, "workers", "",
+		"Comma separated URLs of the index workers. e.g. http://host1:8090,http://host2:8090")
+	fs.StringVar(&manifestPath, "o", manifestPath, "Write the cluster manifest to this file.")
+	args = parseArgs(fs, args, 1)
+	if workers == "" {
+		return fmt.Errorf("No index workers. Use -workers")
+	}
+
+	// The workers read the files of a cloud source by their names in the source.
+	src, err := cloudSource(args)
+	if err != nil {
+		return err
+	}
+	var pathList []string
+	if src != nil {
+		if pathList, err = src.List(); err != nil {
+			return fmt.Errorf("Could not list %q. err=%v", args[0], err)
+		}
+	} else if pathList, err = doclib.PatternsToPaths(args, true); err != nil {
+		return fmt.Errorf("Could not find PDF files. args=%#q err=%v", args, err)
+	}
+	workerURLs := strings.Split(workers, ",")
+	fmt.Fprintf(os.Stderr, "Indexing %d PDF files with %d workers\n", len(pathList),
+		len(workerURLs))
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	m, err := doclib.IndexDistributed(ctx, pathList, workerURLs)
+	for _, shard := range m.Shards {
+		st := shard.Status
+		fmt.Printf("%s: %d files, %d indexed, %d duplicate, %d failed, %d skipped. %d documents\n",
+			shard.URL, shard.NumFiles, st.NumIndexed, st.NumDuplicate, st.NumFailed, st.NumSkipped,
+			st.NumDocs)
+	}
+	if err != nil {
+		return err
+	}
+	if err := doclib.SaveClusterManifest(manifestPath, m); err != nil {
+		return err
+	}
+	fmt.Printf("Wrote cluster manifest %q\n", manifestPath)
+	return nil
+}
@@ -0,0 +1,272 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"time"
+
+	"github.com/blevesearch/bleve"
+	"github.com/peterwilliams97/pdf-search/doclib"
+)
+
+// runIndex adds the PDF and image files in `args` to a store.
+func runIndex(args []string) error {
+	fs, persistDir := newFlagSet("index")
+	var forceCreate, allowAppend, useOCR bool
+	var reportPath, pageRanges, exclude, duplicates, labelsPath, tags, progressAddr string
+	var extractorName, fileExtractors, normalization, priorities, synonymsPath string
+	var stopAdd, stopRemove string
+	opts := doclib.DefaultIndexOptions()
+	fs.BoolVar(&forceCreate, "f", false, "Force creation of a new store.")
+	fs.BoolVar(&allowAppend, "a", false, "Allow an existing store to be appended to.")
+	fs.IntVar(&opts.NumWorkers, "w", opts.NumWorkers, "Number of text extraction worker threads.")
+	fs.DurationVar(&opts.FileTimeout, "timeout", 0,
+		"Give up on files that take longer than this to extract. e.g. 5m. Use with -isolate to "+
+			"stop files that hang.")
+	fs.BoolVar(&opts.Isolate, "isolate", false,
+		"Extract each file in a child process so that files that crash the PDF library can't "+
+			"stop the run.")
+	fs.Float64Var(&opts.MemoryBudgetMB, "mem", 0,
+		"Approximate memory in MB for the extraction workers. Big files wait for memory to be "+
+			"freed. (default no limit)")
+	fs.Float64Var(&opts.MaxBufferMB, "maxbuf", 0,
+		"Skip the rest of a file's pages once its extracted text uses this many MB. "+
+			"(default no limit)")
+	fs.BoolVar(&opts.SmallFirst, "small", false,
+		"Extract smaller files first so that most files are indexed early in the run.")
+	fs.StringVar(&priorities, "prio", "",
+		"Comma separated pattern=priority priorities. Higher priority files are extracted first. "+
+			"e.g. urgent/*=10")
+	fs.IntVar(&opts.MaxRetries, "retry", 0, "Retry files that fail to extract this many times.")
+	fs.IntVar(&opts.BatchSize, "b", 0,
+		"Number of pages to add to the bleve index in a batch. (default the store's config or 100)")
+	fs.BoolVar(&opts.Resume, "r", false, "Resume an indexing run that didn't complete.")
+	fs.BoolVar(&useOCR, "ocr", false,
+		"OCR pages with no text and index TIFF, PNG and JPEG files. Needs a build with -tags ocr.")
+	fs.StringVar(&extractorName, "extractor", "",
+		"Text extractor: "+strings.Join(doclib.TextExtractorNames(), ", ")+". (default unidoc)")
+	fs.StringVar(&fileExtractors, "fx", "",
+		"Comma separated pattern=extractor text extractors for matching files. e.g. scans/*=tesseract")
+	fs.StringVar(&normalization, "norm", "",
+		"Text normalization of a new store: none or a comma separated list of nfkc, ligatures, "+
+			"softhyphens and quotes. (default all)")
+	fs.StringVar(&opts.Stopwords.Lang, "stoplang", "",
+		"Language of the default stop words of a new store: en, fr, de, es, it, pt or none. "+
+			"(default en)")
+	fs.StringVar(&stopAdd, "stopadd", "", "Comma separated words to add to the stop words.")
+	fs.StringVar(&stopRemove, "stopremove", "",
+		"Comma separated words to remove from the stop words so that they can be searched for. "+
+			"e.g. it,no")
+	fs.BoolVar(&opts.RawTextOrder, "raworder", false,
+		"Keep the extractor's text order on multi-column pages. For debugging extraction.")
+	fs.Float64Var(&opts.MinTextQuality, "q", 0,
+		"With -ocr, also OCR pages whose extracted text has a quality score below this (0-1).")
+	fs.StringVar(&reportPath, "j", "", "Write a JSON report of the status of each file to this file.")
+	fs.StringVar(&pageRanges, "pages", "",
+		"Only index these pages of each file. e.g. 1-50,60,100- or 1-:2 for the odd pages.")
+	fs.Float64Var(&opts.MaxFileMB, "maxmb", 0, "Don't index files larger than this many MB.")
+	fs.StringVar(&exclude, "x", "", "Comma separated glob patterns of files that aren't indexed.")
+	fs.IntVar(&opts.Shards, "shards", 0,
+		"Split the bleve index of a new store into this many shards. For very big corpora.")
+	fs.StringVar(&duplicates, "dup", "alias",
+		"What to do with copies of files already in the store: alias, skip or error.")
+	fs.StringVar(&labelsPath, "labels", "",
+		"JSON file of {path: {\"uri\": ..., \"title\": ..., \"tags\": [...]}} for the files.")
+	fs.StringVar(&synonymsPath, "synonyms", "", "Text file of comma separated synonyms, one "+
+		"group per line, e.g. colour,color. It is saved in a new store and used by its searches.")
+	fs.StringVar(&tags, "tags", "",
+		"Comma separated key=value tags to add to every file. e.g. department=legal,year=2019")
+	fs.StringVar(&progressAddr, "progress", "",
+		"Stream progress as JSON over a WebSocket at ws://<this address>/progress and serve the "+
+			"extraction jobs at http://<this address>/jobs. e.g. :8081")
+	args = parseArgs(fs, args, 1)
+
+	dupPolicy, err := doclib.ParseDuplicatePolicy(duplicates)
+	if err != nil {
+		return err
+	}
+	opts.Duplicates = dupPolicy
+
+	if pageRanges != "" {
+		ranges, err := doclib.ParsePageRanges(pageRanges)
+		if err != nil {
+			return err
+		}
+		opts.PageRanges = ranges
+	}
+	if exclude != "" {
+		opts.Exclude = strings.Split(exclude, ",")
+	}
+	if opts.Tags, err = doclib.ParseTags(tags); err != nil {
+		return err
+	}
+	if labelsPath != "" {
+		labels, err := doclib.LoadLabelTable(labelsPath)
+		if err != nil {
+			return err
+		}
+		opts.Labels = labels
+	}
+	if stopAdd != "" {
+		opts.Stopwords.Add = strings.Split(stopAdd, ",")
+	}
+	if stopRemove != "" {
+		opts.Stopwords.Remove = strings.Split(stopRemove, ",")
+	}
+	if _, err := opts.Stopwords.Words(); err != nil {
+		return err
+	}
+	if synonymsPath != "" {
+		if opts.Synonyms, err = doclib.LoadSynonyms(synonymsPath); err != nil {
+			return err
+		}
+	}
+	if normalization != "" {
+		if opts.Normalization, err = doclib.ParseTextNormalization(normalization); err != nil {
+			return err
+		}
+	}
+	if extractorName != "" {
+		if opts.TextExtractor, err = doclib.NewTextExtractor(extractorName); err != nil {
+			return err
+		}
+	}
+	if opts.FileExtractors, err = doclib.ParseFileExtractors(fileExtractors); err != nil {
+		return err
+	}
+	if opts.Priorities, err = doclib.ParseFilePriorities(priorities); err != nil {
+		return err
+	}
+	if useOCR {
+		ocr, err := doclib.NewTesseractOCR("eng", 300)
+		if err != nil {
+			return fmt.Errorf("Could not start OCR. err=%v", err)
+		}
+		opts.OCR = ocr
+	}
+
+	src, err := cloudSource(args)
+	if err != nil {
+		return err
+	}
+	var pathList []string
+	if src != nil {
+		if pathList, err = src.List(); err != nil {
+			return fmt.Errorf("Could not list %q. err=%v", args[0], err)
+		}
+		opts.Source = src
+	} else {
+		if pathList, err = doclib.PatternsToPaths(args, true); err != nil {
+			return fmt.Errorf("Could not find PDF files. args=%#q err=%v", args, err)
+		}
+	}
+	fmt.Fprintf(os.Stderr, "Indexing %d PDF files into %q\n", len(pathList), *persistDir)
+	if queued, err := doclib.QueuedFiles(*persistDir); err == nil && len(queued) > 0 &&
+		!forceCreate {
+		fmt.Fprintf(os.Stderr, "Also indexing %d files queued by runs that didn't finish\n",
+			len(queued))
+	}
+
+	var report doclib.IndexReport
+	opts.Report = &report
+	var progress doclib.ProgressReporter = doclib.ProgressFunc(func(p doclib.Progress) {
+		fmt.Fprintf(os.Stderr, ">> %3d of %d files, %d pages, %.1f pages/sec, ETA %s: %q\n",
+			p.FilesDone, p.TotalFiles, p.PagesDone, p.PagesPerSec, p.ETA.Round(time.Second),
+			p.InPath)
+	})
+	if progressAddr != "" {
+		opts.Scheduler = doclib.NewExtractScheduler()
+		b, err := serveProgress(progressAddr, opts.Scheduler)
+		if err != nil {
+			return err
+		}
+		defer b.Close()
+		stderr := progress
+		progress = doclib.ProgressFunc(func(p doclib.Progress) {
+			stderr.Progress(p)
+			b.Progress(p)
+		})
+	}
+	opts.Progress = progress
+
+	// Ctrl-C stops indexing after the files that are being added to the store. They are kept.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	var index bleve.Index
+	var numPages int
+	if src != nil {
+		_, index, numPages, err = doclib.IndexPdfReadersContext(ctx, pathList, nil, *persistDir,
+			forceCreate, allowAppend, opts, nil)
+	} else {
+		_, index, numPages, err = doclib.IndexPdfFilesContext(ctx, pathList, *persistDir,
+			forceCreate, allowAppend, opts, nil)
+	}
+	if reportPath != "" {
+		if err := report.SaveJSON(reportPath); err != nil {
+			fmt.Fprintf(os.Stderr, "Could not write report %q. err=%v\n", reportPath, err)
+		}
+	}
+	if err == context.Canceled {
+		fmt.Println(report)
+		return fmt.Errorf("Interrupted. Run the same command without -f to index the rest of "+
+			"the files into %q", *persistDir)
+	}
+	if err != nil {
+		return err
+	}
+	defer index.Close()
+	fmt.Println(report)
+	fmt.Printf("Indexed %d pages into %q\n", numPages, *persistDir)
+	return nil
+}
+
+// serveProgress starts an HTTP server on `addr` that streams the progress published to the
+// returned ProgressBroadcaster to WebSocket clients at /progress and serves the jobs of `sched`
+// at /jobs.
+func serveProgress(addr string, sched *doclib.ExtractScheduler) (*doclib.ProgressBroadcaster,
+	error) {
+
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("Could not listen on %q. err=%v", addr, err)
+	}
+	b := doclib.NewProgressBroadcaster()
+	mux := http.NewServeMux()
+	mux.Handle("/progress", doclib.ProgressHandler(b))
+	mux.Handle("/jobs", doclib.JobsHandler(sched))
+	go func() {
+		if err := http.Serve(ln, mux); err != nil {
+			fmt.Fprintf(os.Stderr, "Progress server stopped. err=%v\n", err)
+		}
+	}()
+	fmt.Fprintf(os.Stderr, "Streaming progress on ws://%s/progress\n", ln.Addr())
+	return b, nil
+}
+
+// cloudSource returns the CorpusSource for `args` if it is a single cloud storage URL of the form
+// s3://<bucket>/<prefix> or gs://<bucket>/<prefix>. It returns nil for local file patterns.
+func cloudSource(args []string) (doclib.CorpusSource, error) {
+	if len(args) != 1 {
+		return nil, nil
+	}
+	for _, scheme := range []string{"s3://", "gs://"} {
+		if !strings.HasPrefix(args[0], scheme) {
+			continue
+		}
+		parts := strings.SplitN(strings.TrimPrefix(args[0], scheme), "/", 2)
+		bucket, prefix := parts[0], ""
+		if len(parts) == 2 {
+			prefix = parts[1]
+		}
+		if scheme == "s3://" {
+			return doclib.NewS3Source(bucket, prefix)
+		}
+		return doclib.NewGCSSource(bucket, prefix)
+	}
+	return nil, nil
+}
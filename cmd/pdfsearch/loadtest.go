@@ -0,0 +1,81 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"time"
+
+	"github.com/peterwilliams97/pdf-search/doclib"
+)
+
+// runLoadTest runs concurrent queries against a store or, with -u, a `pdfsearch serve` server and
+// reports latency percentiles and error rates. The queries are read from a query log or generated
+// from the terms in the store.
+func runLoadTest(args []string) error {
+	fs, persistDir := newFlagSet("loadtest")
+	var server, queryLog, reportPath string
+	numQueries := 1000
+	termsPerQuery := 2
+	seed := time.Now().UnixNano()
+	opts := doclib.LoadTestOptions{Concurrency: 8, Search: doclib.SearchOptions{MaxResults: 10}}
+	fs.StringVar(&server, "u", "", "If set, test the server at this URL instead of the store.")
+	fs.StringVar(&queryLog, "q", "", "Query log file. One query per line.")
+	fs.IntVar(&numQueries, "g", numQueries,
+		"Number of queries to generate from the store if there is no query log.")
+	fs.IntVar(&termsPerQuery, "t", termsPerQuery, "Number of terms in each generated query.")
+	fs.Int64Var(&seed, "seed", seed, "Random seed for generating queries.")
+	fs.IntVar(&opts.Concurrency, "c", opts.Concurrency, "Number of concurrent clients.")
+	fs.IntVar(&opts.Requests, "r", 0, "Number of queries to run. 0 to run each query once.")
+	fs.DurationVar(&opts.Duration, "d", 0, "Stop starting queries after this time. e.g. 30s")
+	fs.IntVar(&opts.Search.MaxResults, "n", opts.Search.MaxResults,
+		"Max number of results per query.")
+	fs.StringVar(&reportPath, "j", "", "Write a JSON report to this file.")
+	parseArgs(fs, args, 0)
+
+	// The store is needed to generate queries and to run them if there is no server.
+	var x *doclib.PdfIndex
+	if server == "" || queryLog == "" {
+		var err error
+		if x, err = doclib.OpenPdfIndex(*persistDir); err != nil {
+			return fmt.Errorf("Could not open %q. err=%v", *persistDir, err)
+		}
+		defer x.Close()
+	}
+
+	var queries []string
+	var err error
+	if queryLog != "" {
+		queries, err = doclib.ReadQueryLog(queryLog)
+	} else {
+		queries, err = x.SampleQueries(numQueries, termsPerQuery, seed)
+	}
+	if err != nil {
+		return fmt.Errorf("Could not get queries. err=%v", err)
+	}
+	if len(queries) == 0 {
+		return fmt.Errorf("No queries")
+	}
+
+	var searcher doclib.Searcher = x
+	target := *persistDir
+	if server != "" {
+		searcher = doclib.NewRemoteIndex(server)
+		target = server
+	}
+	fmt.Printf("Running %d queries against %q with %d clients.\n", len(queries), target,
+		opts.Concurrency)
+	report := doclib.RunLoadTest(searcher, queries, opts)
+	fmt.Printf("%s\n", report)
+
+	if reportPath != "" {
+		b, err := json.MarshalIndent(report, "", "\t")
+		if err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(reportPath, b, 0666); err != nil {
+			return fmt.Errorf("Could not write %q. err=%v", reportPath, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,145 @@
+// pdfsearch indexes PDF files for full text search, searches them and marks up the matches.
+//
+// Usage: pdfsearch [-d] [-e] <command> [OPTIONS] [ARGS]
+//
+// Run `pdfsearch help` to see the commands and `pdfsearch <command> -h` to see a command's options.
+// All commands use the store "store.position" unless -s is given.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/peterwilliams97/pdf-search/doclib"
+)
+
+const usage = `Usage: pdfsearch [-d] [-e] <command> [OPTIONS] [ARGS]
+Indexes PDF files for full text search, searches them and marks up the matches.
+Run "pdfsearch <command> -h" to see a command's options.
+
+Commands:`
+
+// defaultStore is the store directory that commands use if -s isn't given.
+const defaultStore = "store.position"
+
+// command is a pdfsearch subcommand.
+type command struct {
+	name    string
+	args    string // Arguments shown in the usage message.
+	summary string
+	run     func(args []string) error
+}
+
+// commands are the pdfsearch subcommands. They are set in init() because the commands refer to
+// commands for their usage messages.
+var commands []command
+
+func init() {
+	commands = []command{
+		{"index", "[OPTIONS] <PDF or image files>", "Add PDF and image files to a store.", runIndex},
+		{"search", "[OPTIONS] <query>", "Search a store.", runSearch},
+		{"repl", "[OPTIONS]", "Search a store interactively.", runRepl},
+		{"serve", "[OPTIONS]", "Serve searches of a store over HTTP.", runServe},
+		{"loadtest", "[OPTIONS]", "Measure the search latency of a store or a server.",
+			runLoadTest},
+		{"worker", "[OPTIONS]", "Index files sent by a cluster coordinator and serve searches.",
+			runWorker},
+		{"cluster", "[OPTIONS] -workers <URLs> <PDF files>",
+			"Index files with several index workers.", runCluster},
+		{"ls", "[OPTIONS]", "List the documents in a store.", runList},
+		{"rm", "[OPTIONS] <query>", "Delete the documents that match a query from a store.", runRemove},
+		{"stats", "[OPTIONS]", "Show a summary of a store.", runStats},
+		{"verify", "[OPTIONS]", "Check a store for damage and optionally repair it.", runVerify},
+		{"config", "[OPTIONS]", "Show or change the configuration of a store.", runConfig},
+		{"vocab", "[OPTIONS]", "List the most frequent terms in a store or a document.", runVocab},
+		{"dups", "[OPTIONS]", "List the near-duplicate pages or documents in a store.", runDups},
+		{"bad", "[OPTIONS]", "List, add or remove the known bad PDFs of a store.", runBad},
+		{"freeze", "[OPTIONS] <cold storage directory>",
+			"Move the positions data of old documents to cold storage.", runFreeze},
+		{"mv", "[OPTIONS] <new store directory>", "Move a store to a new directory.", runMove},
+		{"merge", "[OPTIONS] <source stores>", "Add the documents of other stores to a store.",
+			runMerge},
+		{"export", "[OPTIONS] <snapshot.tar.gz>", "Save a store as a snapshot archive.", runExport},
+		{"import", "[OPTIONS] <snapshot.tar.gz>", "Create a store from a snapshot archive.",
+			runImport},
+		{"markup", "[OPTIONS] <query>", "Search a store and mark up the matches in a PDF.", runMarkup},
+		{"selftest", "", "Check that indexing and searching work on this computer.", runSelfTest},
+	}
+}
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintln(os.Stderr, usage)
+		for _, c := range commands {
+			fmt.Fprintf(os.Stderr, "  %-8s %s\n", c.name, c.summary)
+		}
+		fmt.Fprintln(os.Stderr, "\nGlobal options:")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	doclib.SetLogging()
+	if flag.NArg() < 1 || flag.Arg(0) == "help" {
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	name := flag.Arg(0)
+	if name == doclib.ExtractWorkerCommand && flag.NArg() == 1 {
+		// index -isolate runs pdfsearch this way to extract each PDF in a child process.
+		if err := doclib.RunExtractWorker(); err != nil {
+			fmt.Fprintf(os.Stderr, "pdfsearch %s: %v\n", name, err)
+			os.Exit(1)
+		}
+		return
+	}
+	for _, c := range commands {
+		if c.name != name {
+			continue
+		}
+		if err := c.run(flag.Args()[1:]); err != nil {
+			fmt.Fprintf(os.Stderr, "pdfsearch %s: %v\n", name, err)
+			os.Exit(1)
+		}
+		return
+	}
+	fmt.Fprintf(os.Stderr, "pdfsearch: Unknown command %q\n", name)
+	flag.Usage()
+	os.Exit(1)
+}
+
+// newFlagSet returns the FlagSet for the command named `name` and the store directory flag -s.
+func newFlagSet(name string) (*flag.FlagSet, *string) {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	for _, c := range commands {
+		if c.name == name {
+			fs.Usage = func() {
+				fmt.Fprintf(os.Stderr, "Usage: pdfsearch %s %s\n%s\n", c.name, c.args, c.summary)
+				fs.PrintDefaults()
+			}
+		}
+	}
+	persistDir := fs.String("s", defaultStore, "Index store directory name.")
+	return fs, persistDir
+}
+
+// parseArgs parses `args` with `fs` and returns the remaining arguments. It exits with a usage
+// message if there are fewer than `minArgs` of them.
+func parseArgs(fs *flag.FlagSet, args []string, minArgs int) []string {
+	fs.Parse(args)
+	if fs.NArg() < minArgs {
+		fs.Usage()
+		os.Exit(1)
+	}
+	return fs.Args()
+}
+
+// runSelfTest indexes, searches and highlights a built-in PDF in a temporary store. It doesn't use
+// a store so it has no -s option.
+func runSelfTest(args []string) error {
+	fs := flag.NewFlagSet("selftest", flag.ExitOnError)
+	parseArgs(fs, args, 0)
+	report, err := doclib.SelfTest("")
+	fmt.Println(report)
+	return err
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"strings"
+
+	"github.com/peterwilliams97/pdf-search/doclib"
+)
+
+const replHelp = `Enter a query to search the store, or one of these commands:
+  :next            Show the next page of results.
+  :filter <text>   Only show the current results whose text contains <text>.
+  :files           Show the files in the current results.
+  :open <n>        Open the PDF of result <n>.
+  :markup <file>   Mark up the current results in PDF file <file>.
+  :size <n>        Show <n> results per page.
+  :all             Only match pages with all the query terms. Repeat to match any term.
+  :within <n>      Only match pages with all the query terms within <n> characters. 0 to disable.
+  :help            Show this help.
+  :quit            Exit.`
+
+// repl is the state of an interactive search session.
+type repl struct {
+	x        *doclib.PdfIndex
+	size     int
+	allTerms bool
+	within   int
+	term     string             // The last query.
+	results  doclib.PdfMatchSet // The results being shown.
+}
+
+// runRepl searches a store interactively. The store is kept open between queries.
+func runRepl(args []string) error {
+	fs, persistDir := newFlagSet("repl")
+	r := repl{size: 10}
+	fs.IntVar(&r.size, "n", r.size, "Number of results per page.")
+	fs.BoolVar(&r.allTerms, "all", false, "Only match pages with all the query terms.")
+	fs.IntVar(&r.within, "within", 0,
+		"Only match pages with all the query terms within this many characters.")
+	parseArgs(fs, args, 0)
+
+	x, err := doclib.OpenPdfIndex(*persistDir)
+	if err != nil {
+		return fmt.Errorf("Could not open %q. err=%v", *persistDir, err)
+	}
+	defer x.Close()
+	r.x = x
+	fmt.Printf("%q: %d documents. Type :help for help.\n", *persistDir, x.NumDocs())
+
+	scanner := bufio.NewScanner(os.Stdin)
+	for {
+		fmt.Print("pdfsearch> ")
+		if !scanner.Scan() {
+			break
+		}
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		if line == ":quit" || line == ":q" {
+			break
+		}
+		if err := r.do(line); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		}
+	}
+	return scanner.Err()
+}
+
+// do runs the command or query in `line`.
+func (r *repl) do(line string) error {
+	if !strings.HasPrefix(line, ":") {
+		return r.search(line, "")
+	}
+	parts := strings.SplitN(line, " ", 2)
+	cmd, arg := parts[0], ""
+	if len(parts) > 1 {
+		arg = strings.TrimSpace(parts[1])
+	}
+	switch cmd {
+	case ":help", ":h":
+		fmt.Println(replHelp)
+	case ":next", ":n":
+		if r.results.NextCursor == "" {
+			return fmt.Errorf("no more results")
+		}
+		return r.search(r.term, r.results.NextCursor)
+	case ":filter", ":f":
+		r.results = filterMatches(r.results, arg)
+		r.show()
+	case ":files":
+		for i, fn := range r.results.Files() {
+			fmt.Printf("%4d: %q\n", i+1, fn)
+		}
+	case ":open", ":o":
+		m, err := r.match(arg)
+		if err != nil {
+			return err
+		}
+		return openFile(m.InPath)
+	case ":markup", ":m":
+		if arg == "" {
+			return fmt.Errorf("no output file")
+		}
+		extractions := doclib.CreateExtractList(len(r.results.Matches))
+		for _, m := range r.results.Matches {
+			extractions.AddPdfMatch(m)
+		}
+		if err := extractions.SaveOutputPdf(arg); err != nil {
+			return err
+		}
+		fmt.Printf("Marked up %d pages in %q\n", extractions.NumPages(), arg)
+	case ":size":
+		size, err := strconv.Atoi(arg)
+		if err != nil || size <= 0 {
+			return fmt.Errorf("bad size %q", arg)
+		}
+		r.size = size
+	case ":all":
+		r.allTerms = !r.allTerms
+		fmt.Printf("allTerms=%t\n", r.allTerms)
+	case ":within":
+		within, err := strconv.Atoi(arg)
+		if err != nil || within < 0 {
+			return fmt.Errorf("bad distance %q", arg)
+		}
+		r.within = within
+	default:
+		return fmt.Errorf("unknown command %q. Type :help for help", cmd)
+	}
+	return nil
+}
+
+// search runs query `term` starting at `cursor` and shows the results.
+func (r *repl) search(term, cursor string) error {
+	opts := doclib.SearchOptions{
+		MaxResults: r.size,
+		Cursor:     cursor,
+		AllTerms:   r.allTerms,
+		Within:     r.within,
+	}
+	results, err := r.x.Search(term, opts)
+	if err != nil {
+		return err
+	}
+	r.term = term
+	r.results = results
+	r.show()
+	return nil
+}
+
+// show prints the current results.
+func (r *repl) show() {
+	fmt.Printf("%s", r.results)
+	if r.results.NextCursor != "" {
+		fmt.Println("Type :next for more results.")
+	}
+}
+
+// match returns the result numbered `arg` in the current results.
+func (r *repl) match(arg string) (doclib.PdfMatch, error) {
+	n, err := strconv.Atoi(arg)
+	i := n - r.results.From - 1
+	if err != nil || i < 0 || i >= len(r.results.Matches) {
+		return doclib.PdfMatch{}, fmt.Errorf("no result %q", arg)
+	}
+	return r.results.Matches[i], nil
+}
+
+// filterMatches returns the matches in `results` whose text contains `text`, ignoring case.
+func filterMatches(results doclib.PdfMatchSet, text string) doclib.PdfMatchSet {
+	text = strings.ToLower(text)
+	var matches []doclib.PdfMatch
+	for _, m := range results.Matches {
+		if strings.Contains(strings.ToLower(m.Line), text) ||
+			strings.Contains(strings.ToLower(m.Snippet), text) {
+			matches = append(matches, m)
+		}
+	}
+	results.Matches = matches
+	return results
+}
+
+// openFile opens `path` with the desktop's default application.
+func openFile(path string) error {
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "darwin":
+		cmd = exec.Command("open", path)
+	case "windows":
+		cmd = exec.Command("cmd", "/c", "start", "", path)
+	default:
+		cmd = exec.Command("xdg-open", path)
+	}
+	return cmd.Start()
+}
@@ -0,0 +1,268 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/peterwilliams97/pdf-search/doclib"
+)
+
+// runSearch searches a store for the query in `args`.
+func runSearch(args []string) error {
+	fs, persistDir := newFlagSet("search")
+	var opts doclib.SearchOptions
+	fs.IntVar(&opts.MaxResults, "n", 10, "Max number of results to return.")
+	fs.IntVar(&opts.From, "from", 0, "Offset of the first result to return.")
+	fs.BoolVar(&opts.AllTerms, "all", false, "Only match pages that contain all the query terms.")
+	fs.IntVar(&opts.Within, "within", 0,
+		"Only match pages where all the query terms are within this many characters.")
+	fs.IntVar(&opts.Fuzziness, "fuzzy", 0,
+		"Also match words that differ from the query terms by up to this many edits (1 or 2).")
+	fs.StringVar(&opts.Lang, "lang", "", "Only match pages in this language. e.g. en, fr or de.")
+	fs.BoolVar(&opts.CaseSensitive, "case", false,
+		"Only match words with the same case as the query, e.g. IT but not it.")
+	fs.BoolVar(&opts.DiacriticSensitive, "diacritics", false,
+		"Only match words with the same accents as the query, e.g. résumé but not resume.")
+	var tags, after, before, qty string
+	fs.StringVar(&after, "after", "",
+		"Only match files dated on or after this date. e.g. 2020, 2020-06 or 2020-06-30")
+	fs.StringVar(&before, "before", "", "Only match files dated before this date.")
+	fs.StringVar(&qty, "qty", "", "Only match pages with a number or quantity in this range, "+
+		"in any unit of the same kind. e.g. \"2..5 mm\", \"..1kg\" or \"1000..\"")
+	fs.StringVar(&opts.DateField, "date", doclib.DateCreated,
+		"The date that -after, -before and -sort use: created (PDF creation date) or modified.")
+	fs.StringVar(&opts.Sort, "sort", "", "Sort matches by date (oldest first), -date (newest first), "+
+		"path (by file then page), size (smallest first) or -size. (default by score)")
+	fs.StringVar(&tags, "tag", "",
+		"Only match files with all these comma separated key=value tags. e.g. year=2019")
+	fs.BoolVar(&opts.TagFacet, "facets", false, "Show the number of matching pages with each tag.")
+	fs.BoolVar(&opts.CollapseDuplicates, "collapse", false,
+		"Only show the best match of near-duplicate pages, e.g. pages in revisions of a document.")
+	fs.BoolVar(&opts.CollapseIdentical, "identical", false, "Only show the best match of pages "+
+		"with identical text, e.g. copied appendices, with the locations of the others.")
+	var regex, narrow bool
+	fs.BoolVar(&regex, "regex", false,
+		"Treat the query as a Go regular expression and match it against the page texts.")
+	fs.BoolVar(&narrow, "narrow", false, "With -regex, only scan the pages whose indexed words "+
+		"contain the literal text of the regular expression. Faster but can miss matches.")
+	var thumbsDir, cluster string
+	fs.StringVar(&cluster, "cluster", "",
+		"Search the shard stores of the index workers in this cluster manifest instead of a store.")
+	fs.StringVar(&thumbsDir, "thumbs", "",
+		"Write a PNG thumbnail of each matched page with the matches highlighted to this directory.")
+	format := "text"
+	fs.StringVar(&format, "o", format,
+		"Output format: text, json, csv, tree, which groups matches by file and section, docs, "+
+			"which lists the matching files with hit counts, or cells, which shows the table cell "+
+			"and row of matches in tables.")
+	args = parseArgs(fs, args, 1)
+	term := strings.Join(args, " ")
+	if format != "text" && format != "json" && format != "csv" && format != "tree" &&
+		format != "docs" && format != "cells" {
+		return fmt.Errorf("Unknown output format %q", format)
+	}
+	var err error
+	if opts.Tags, err = doclib.ParseTags(tags); err != nil {
+		return err
+	}
+	if opts.After, err = doclib.ParseDate(after); err != nil {
+		return err
+	}
+	if opts.Before, err = doclib.ParseDate(before); err != nil {
+		return err
+	}
+	if qty != "" {
+		if opts.Quantity, err = doclib.ParseQuantityRange(qty); err != nil {
+			return err
+		}
+	}
+
+	if cluster != "" {
+		return searchCluster(cluster, term, opts, format)
+	}
+	x, err := doclib.OpenPdfIndex(*persistDir)
+	if err != nil {
+		return fmt.Errorf("Could not open %q. err=%v", *persistDir, err)
+	}
+	defer x.Close()
+	var results doclib.PdfMatchSet
+	if regex {
+		results, err = x.RegexSearch(context.Background(), term,
+			doclib.RegexOptions{MaxResults: opts.MaxResults, UseIndex: narrow})
+	} else {
+		results, err = x.Search(term, opts)
+	}
+	if err != nil {
+		return fmt.Errorf("Could not search %q. err=%v", *persistDir, err)
+	}
+	if thumbsDir != "" {
+		if err := writeThumbnails(x, results, thumbsDir); err != nil {
+			return err
+		}
+	}
+	switch format {
+	case "json":
+		b, err := json.MarshalIndent(results, "", "\t")
+		if err != nil {
+			return err
+		}
+		fmt.Printf("%s\n", b)
+	case "csv":
+		return results.WriteCSV(os.Stdout)
+	case "tree":
+		fmt.Printf("term=%q\n", term)
+		fmt.Print(results.GroupString())
+	case "docs":
+		fmt.Printf("term=%q\n", term)
+		for i, d := range results.ByDocument() {
+			fmt.Printf("%4d: %s\n", i+1, d)
+		}
+	case "cells":
+		fmt.Printf("term=%q\n", term)
+		for i, m := range results.Matches {
+			fmt.Printf("%4d: %q page %d ", results.From+i+1, m.InPath, m.PageNum)
+			if m.Table != nil {
+				fmt.Printf("%s\n", m.Table)
+			} else {
+				fmt.Printf("line %d: %q\n", m.LineNum, m.Line)
+			}
+		}
+	default:
+		fmt.Printf("term=%q\n", term)
+		fmt.Println(results)
+		if opts.TagFacet {
+			fmt.Print(results.TagFacetString())
+		}
+	}
+	return nil
+}
+
+// searchCluster searches the shard stores in the cluster manifest `manifestPath` for `term` and
+// prints the matches in `format`, text or json.
+func searchCluster(manifestPath, term string, opts doclib.SearchOptions, format string) error {
+	if format != "text" && format != "json" {
+		return fmt.Errorf("Clusters can't be searched with -o %s", format)
+	}
+	m, err := doclib.LoadClusterManifest(manifestPath)
+	if err != nil {
+		return err
+	}
+	multi, err := doclib.OpenCluster(m)
+	if err != nil {
+		return err
+	}
+	results, err := multi.Search(term, opts)
+	if err != nil {
+		return fmt.Errorf("Could not search %q. err=%v", manifestPath, err)
+	}
+	if format == "json" {
+		b, err := json.MarshalIndent(results, "", "\t")
+		if err != nil {
+			return err
+		}
+		fmt.Printf("%s\n", b)
+		return nil
+	}
+	fmt.Printf("term=%q\n", term)
+	fmt.Println(results)
+	return nil
+}
+
+// writeThumbnails writes a thumbnail of the page of each match in `results`, which are from a
+// search of `x`, to `dir`. The thumbnails are named by the match numbers, e.g. 001.png.
+func writeThumbnails(x *doclib.PdfIndex, results doclib.PdfMatchSet, dir string) error {
+	if err := doclib.MkDir(dir); err != nil {
+		return err
+	}
+	for i, m := range results.Matches {
+		data, err := x.RenderMatchThumbnail(m, 0)
+		if err != nil {
+			return fmt.Errorf("Could not render thumbnail of %q:%d. err=%v", m.InPath, m.PageNum, err)
+		}
+		outPath := filepath.Join(dir, fmt.Sprintf("%03d.png", results.From+i+1))
+		if err := ioutil.WriteFile(outPath, data, 0666); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// runServe serves searches of a store over HTTP. See doclib/pdf_server.go for the protocol.
+func runServe(args []string) error {
+	fs, persistDir := newFlagSet("serve")
+	addr := ":8080"
+	var admin bool
+	var openDocs int
+	fs.StringVar(&addr, "addr", addr, "Address to listen on.")
+	fs.BoolVar(&admin, "admin", false, "Serve the admin endpoints that modify the store.")
+	fs.IntVar(&openDocs, "docs", 0,
+		"Max number of documents to keep open between searches. (default the store's config or 64)")
+	parseArgs(fs, args, 0)
+
+	x, err := doclib.OpenPdfIndex(*persistDir)
+	if err != nil {
+		return fmt.Errorf("Could not open %q. err=%v", *persistDir, err)
+	}
+	defer x.Close()
+	if openDocs > 0 {
+		x.SetOpenDocs(openDocs)
+	}
+
+	fmt.Printf("Serving %q (%d documents) on %q admin=%t\n", *persistDir, x.NumDocs(), addr, admin)
+	return http.ListenAndServe(addr, doclib.NewPdfServer(x, admin))
+}
+
+// runMarkup searches a store for the query in `args` and marks up every occurrence of every query
+// term on the matching pages.
+func runMarkup(args []string) error {
+	fs, persistDir := newFlagSet("markup")
+	outPath := "markup.results.pdf"
+	maxResults := 10
+	var colors, highlightDir string
+	style := doclib.DefaultMarkupStyle()
+	fs.StringVar(&outPath, "o", outPath, "Name of PDF file that will show marked up results.")
+	fs.IntVar(&maxResults, "n", maxResults, "Max number of results to mark up.")
+	fs.StringVar(&colors, "c", "", "Comma separated hex colors of the query terms.")
+	fs.Float64Var(&style.BorderWidth, "w", style.BorderWidth, "Width of the rectangle borders.")
+	fs.BoolVar(&style.Annotate, "a", false,
+		"Mark up with annotations that show the matched terms in popups.")
+	fs.BoolVar(&style.Fill, "f", false, "Fill the annotations with the term colors.")
+	fs.StringVar(&highlightDir, "p", "",
+		"If set, write copies of the matching PDFs with highlight annotations to this directory.")
+	args = parseArgs(fs, args, 1)
+	term := strings.Join(args, " ")
+
+	results, err := doclib.SearchPdfIndex(*persistDir, term, maxResults)
+	if err != nil {
+		return fmt.Errorf("Could not search %q. err=%v", *persistDir, err)
+	}
+	if colors != "" {
+		style.Colors = strings.Split(colors, ",")
+	}
+	extractions := doclib.CreateExtractList(maxResults)
+	extractions.SetStyle(style)
+	for _, m := range results.Matches {
+		extractions.AddPdfMatch(m)
+	}
+	if err := extractions.SaveOutputPdf(outPath); err != nil {
+		return fmt.Errorf("Could not save %q. err=%v", outPath, err)
+	}
+	fmt.Printf("Marked up %d pages for %q in %q\n", extractions.NumPages(), term, outPath)
+
+	if highlightDir != "" {
+		outPaths, err := doclib.HighlightPdfMatchSet(results, highlightDir, style)
+		if err != nil {
+			return fmt.Errorf("Could not highlight PDFs in %q. err=%v", highlightDir, err)
+		}
+		for inPath, outPath := range outPaths {
+			fmt.Printf("Highlighted %q in %q\n", filepath.Base(inPath), outPath)
+		}
+	}
+	return nil
+}
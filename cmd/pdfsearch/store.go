@@ -0,0 +1,425 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/peterwilliams97/pdf-search/doclib"
+)
+
+// runList lists the documents in a store.
+func runList(args []string) error {
+	fs, persistDir := newFlagSet("ls")
+	var filter doclib.DocFilter
+	var status string
+	fs.StringVar(&filter.PathPattern, "p", "", "Only list documents whose path matches this.")
+	fs.StringVar(&status, "t", "", "Only list documents with this status (ok, empty, quarantined).")
+	fs.IntVar(&filter.MinPages, "m", 0, "Only list documents with at least this many pages.")
+	fs.StringVar(&filter.Extractor, "e", "",
+		"Only list documents extracted by this extractor (e.g. unidoc, unidoc/3.0.0, unknown).")
+	fs.IntVar(&filter.Offset, "o", 0, "Number of documents to skip.")
+	fs.IntVar(&filter.Limit, "n", 100, "Max number of documents to list.")
+	parseArgs(fs, args, 0)
+	filter.Status = doclib.DocStatus(status)
+
+	docs, total, err := doclib.ListDocs(*persistDir, filter)
+	if err != nil {
+		return fmt.Errorf("Could not list %q. err=%v", *persistDir, err)
+	}
+	for _, d := range docs {
+		fmt.Printf("%4d: %.12s %4d pages %6.2f MB %-11s %s %q\n", d.DocIdx, d.Hash, d.NumPages,
+			d.SizeMB, d.Status, d.Indexed.Format("2006-01-02 15:04"), d.InPath)
+		for _, alias := range d.Aliases {
+			fmt.Printf("%50s %q\n", "alias", alias)
+		}
+		if d.Cold {
+			fmt.Printf("%50s\n", "in cold storage")
+		}
+	}
+	fmt.Printf("Showing %d-%d of %d documents\n", filter.Offset+1, filter.Offset+len(docs), total)
+	return nil
+}
+
+// runRemove deletes the documents that match the query in `args` from a store.
+func runRemove(args []string) error {
+	fs, persistDir := newFlagSet("rm")
+	var dryRun bool
+	fs.BoolVar(&dryRun, "n", false, "Dry run. List the documents that would be deleted.")
+	fs.Usage = func() {
+		fmt.Fprint(fs.Output(), `Usage: pdfsearch rm [OPTIONS] <query>
+Deletes the documents with pages that match <query> from a store. Whole documents are deleted, not
+just the matching pages. <query> is a search query, e.g. "author:smith", or "path:<pattern>" to
+delete the documents whose paths match <pattern>, e.g. "path:/scans/2017/".
+Don't run this while other programs are using the store.
+`)
+		fs.PrintDefaults()
+	}
+	args = parseArgs(fs, args, 1)
+	q := strings.Join(args, " ")
+
+	report, err := doclib.DeleteByQuery(*persistDir, q, dryRun)
+	if err != nil {
+		return fmt.Errorf("Could not delete %q from %q. err=%v", q, *persistDir, err)
+	}
+	fmt.Println(report)
+	return nil
+}
+
+// runVerify checks that the files of a store are consistent and optionally repairs them.
+func runVerify(args []string) error {
+	fs, persistDir := newFlagSet("verify")
+	var repair bool
+	fs.BoolVar(&repair, "repair", false, "Prune the dangling entries that are found.")
+	fs.Usage = func() {
+		fmt.Fprint(fs.Output(), `Usage: pdfsearch verify [OPTIONS]
+Checks that the file list, positions files, page texts and bleve index of a store are consistent.
+With -repair, damaged documents, orphan files and orphan index entries are deleted and documents
+that are missing from the index are re-indexed.
+Don't run this with -repair while other programs are using the store.
+`)
+		fs.PrintDefaults()
+	}
+	parseArgs(fs, args, 0)
+
+	report, err := doclib.VerifyStore(*persistDir, repair)
+	if err != nil {
+		return fmt.Errorf("Could not verify %q. err=%v", *persistDir, err)
+	}
+	fmt.Println(report)
+	if !report.OK() && !report.Repaired {
+		return fmt.Errorf("%d problems in %q", len(report.Problems), *persistDir)
+	}
+	return nil
+}
+
+// runStats shows a summary of a store.
+func runStats(args []string) error {
+	fs, persistDir := newFlagSet("stats")
+	parseArgs(fs, args, 0)
+
+	info, err := doclib.ReadStoreInfo(*persistDir)
+	if err != nil {
+		return fmt.Errorf("Could not read %q. err=%v", *persistDir, err)
+	}
+	stats, err := doclib.ReadStoreStats(*persistDir)
+	if err != nil {
+		return fmt.Errorf("Could not read %q. err=%v", *persistDir, err)
+	}
+	fmt.Printf("store %q\n", *persistDir)
+	fmt.Println(stats)
+	fmt.Printf("features: %+v\n", info.Features)
+	if holder, locked, err := doclib.ReadStoreLock(*persistDir); err != nil {
+		return err
+	} else if locked {
+		fmt.Printf("being written by %s\n", holder)
+	}
+	return nil
+}
+
+// runVocab lists the most frequent terms in a store with the number of pages each is on or, with
+// -d, the most frequent terms in a document with the number of times each occurs.
+func runVocab(args []string) error {
+	fs, persistDir := newFlagSet("vocab")
+	n := 50
+	docIdx := -1
+	fs.IntVar(&n, "n", n, "Max number of terms to list. 0 for all.")
+	fs.IntVar(&docIdx, "d", docIdx, "List the terms of the document with this index. See ls.")
+	parseArgs(fs, args, 0)
+
+	x, err := doclib.OpenPdfIndex(*persistDir)
+	if err != nil {
+		return fmt.Errorf("Could not open %q. err=%v", *persistDir, err)
+	}
+	defer x.Close()
+	var terms []doclib.TermCount
+	what := "pages"
+	if docIdx >= 0 {
+		inPath, err := x.DocPath(uint64(docIdx))
+		if err != nil {
+			return fmt.Errorf("No document %d in %q. err=%v", docIdx, *persistDir, err)
+		}
+		fmt.Printf("%q\n", inPath)
+		terms, err = x.DocTerms(uint64(docIdx), n)
+		if err != nil {
+			return err
+		}
+		what = "times"
+	} else if terms, err = x.TopTerms(n); err != nil {
+		return err
+	}
+	for i, tc := range terms {
+		fmt.Printf("%4d: %-30q %6d %s\n", i+1, tc.Term, tc.Count, what)
+	}
+	return nil
+}
+
+// runDups lists the groups of near-duplicate pages or, with -docs, documents in a store.
+func runDups(args []string) error {
+	fs, persistDir := newFlagSet("dups")
+	var docs bool
+	var fraction float64
+	maxDistance := doclib.DefaultNearDuplicateDistance
+	fs.IntVar(&maxDistance, "b", maxDistance,
+		"Max number of bits by which the fingerprints of near-duplicate pages differ.")
+	fs.BoolVar(&docs, "docs", false, "List near-duplicate documents instead of pages.")
+	fs.Float64Var(&fraction, "f", 0.8,
+		"Min fraction of the pages of the shorter of two near-duplicate documents with "+
+			"near-duplicates in the other.")
+	parseArgs(fs, args, 0)
+
+	x, err := doclib.OpenPdfIndex(*persistDir)
+	if err != nil {
+		return fmt.Errorf("Could not open %q. err=%v", *persistDir, err)
+	}
+	defer x.Close()
+	if docs {
+		groups, err := x.NearDuplicateDocs(maxDistance, fraction)
+		if err != nil {
+			return err
+		}
+		for i, group := range groups {
+			fmt.Printf("%4d: %d documents\n", i+1, len(group))
+			for _, inPath := range group {
+				fmt.Printf("\t%q\n", inPath)
+			}
+		}
+		fmt.Printf("%d groups of near-duplicate documents\n", len(groups))
+		return nil
+	}
+	groups, err := x.NearDuplicatePages(maxDistance)
+	if err != nil {
+		return err
+	}
+	for i, group := range groups {
+		fmt.Printf("%4d: %d pages\n", i+1, len(group))
+		for _, ref := range group {
+			fmt.Printf("\t%q page %d\n", ref.InPath, ref.PageNum)
+		}
+	}
+	fmt.Printf("%d groups of near-duplicate pages\n", len(groups))
+	return nil
+}
+
+// runFreeze moves the positions data of the documents in a store that were indexed more than -days
+// days ago to the cold storage directory in `args`. Searches fetch them back when they need them.
+func runFreeze(args []string) error {
+	fs, persistDir := newFlagSet("freeze")
+	days := 365
+	fs.IntVar(&days, "days", days, "Freeze documents that were indexed more than this many days ago.")
+	args = parseArgs(fs, args, 1)
+
+	lState, err := doclib.OpenPositionsState(*persistDir, false)
+	if err != nil {
+		return fmt.Errorf("Could not open %q. err=%v", *persistDir, err)
+	}
+	if err := lState.SetColdDir(args[0]); err != nil {
+		return fmt.Errorf("Could not use cold storage %q. err=%v", args[0], err)
+	}
+	n, err := lState.FreezeDocs(time.Now().AddDate(0, 0, -days))
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Froze %d documents in %q to %q\n", n, *persistDir, args[0])
+	return nil
+}
+
+// runMerge adds the documents of the stores in `args` to the store given by -s, which is created if
+// it doesn't exist. Documents that are already in the store are not added again.
+func runMerge(args []string) error {
+	fs, persistDir := newFlagSet("merge")
+	args = parseArgs(fs, args, 1)
+
+	if err := doclib.MergeStores(*persistDir, args...); err != nil {
+		return err
+	}
+	info, err := doclib.ReadStoreInfo(*persistDir)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Merged %d stores into %q. %d documents\n", len(args), *persistDir, info.NumDocs)
+	return nil
+}
+
+// runMove moves the store given by -s to the directory in `args`, which must not exist.
+func runMove(args []string) error {
+	fs, persistDir := newFlagSet("mv")
+	fs.Usage = func() {
+		fmt.Fprint(fs.Output(), `Usage: pdfsearch mv [OPTIONS] <new store directory>
+Moves a store to a new directory, which must not exist. Its parent directories are created if
+necessary. Don't run this while other programs are using the store.
+`)
+		fs.PrintDefaults()
+	}
+	args = parseArgs(fs, args, 1)
+	newDir := args[0]
+
+	if holder, locked, err := doclib.ReadStoreLock(*persistDir); err != nil {
+		return err
+	} else if locked {
+		return fmt.Errorf("Could not move %q. It is being written by %s", *persistDir, holder)
+	}
+	lState, index, err := doclib.MoveStore(*persistDir, newDir, nil, nil)
+	if err != nil {
+		return err
+	}
+	if index != nil {
+		defer index.Close()
+	}
+	fmt.Printf("Moved %q to %q. %d documents\n", *persistDir, newDir, lState.Len())
+	return nil
+}
+
+// runExport writes a snapshot of a store to the tar.gz file in `args`.
+func runExport(args []string) error {
+	fs, persistDir := newFlagSet("export")
+	args = parseArgs(fs, args, 1)
+
+	f, err := os.Create(args[0])
+	if err != nil {
+		return err
+	}
+	m, err := doclib.ExportSnapshot(*persistDir, f)
+	if err2 := f.Close(); err == nil {
+		err = err2
+	}
+	if err != nil {
+		os.Remove(args[0])
+		return err
+	}
+	fmt.Printf("Exported %q to %q. %d documents, %d files\n", *persistDir, args[0], m.NumDocs,
+		len(m.Files))
+	if m.NumFrozen > 0 {
+		fmt.Printf("%d frozen documents are not in the snapshot.\n", m.NumFrozen)
+	}
+	return nil
+}
+
+// runImport creates the store given by -s from the snapshot in `args`. The store must not exist.
+func runImport(args []string) error {
+	fs, persistDir := newFlagSet("import")
+	args = parseArgs(fs, args, 1)
+
+	f, err := os.Open(args[0])
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	m, err := doclib.ImportSnapshot(f, *persistDir)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Imported %q to %q. %d documents, %d files. Created %s\n", args[0], *persistDir,
+		m.NumDocs, len(m.Files), m.Created.Format(time.RFC3339))
+	return nil
+}
+
+// runBad lists the known bad PDFs of a store, which are not indexed, and adds and removes PDFs.
+func runBad(args []string) error {
+	fs, persistDir := newFlagSet("bad")
+	var add, remove, reason string
+	fs.StringVar(&add, "add", "", "Add the PDF with this hash to the known bad PDFs.")
+	fs.StringVar(&reason, "why", "added by user", "With -add, the reason the PDF is bad.")
+	fs.StringVar(&remove, "rm", "",
+		"Remove the PDF with this hash from the known bad PDFs so that it is indexed again.")
+	parseArgs(fs, args, 0)
+
+	filter, err := doclib.LoadCorpusFilter(*persistDir)
+	if err != nil {
+		return err
+	}
+	if add != "" {
+		if err := filter.AddBadHash(add, reason); err != nil {
+			return err
+		}
+	}
+	if remove != "" {
+		ok, err := filter.RemoveBadHash(remove)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return fmt.Errorf("%q is not a known bad PDF", remove)
+		}
+	}
+	bad := filter.BadHashes()
+	hashes := make([]string, 0, len(bad))
+	for hash := range bad {
+		hashes = append(hashes, hash)
+	}
+	sort.Strings(hashes)
+	for _, hash := range hashes {
+		fmt.Printf("%s %s\n", hash, bad[hash])
+	}
+	fmt.Printf("%d known bad PDFs\n", len(bad))
+	return nil
+}
+
+// runConfig shows the configuration of a store and changes the options that are given.
+func runConfig(args []string) error {
+	fs, persistDir := newFlagSet("config")
+	var batchSize, openDocs int
+	var maxFileMB, minTextQuality, flushPeriod float64
+	var pageRanges, exclude string
+	fs.IntVar(&batchSize, "b", 0, "Number of pages to add to the bleve index in a batch.")
+	fs.StringVar(&pageRanges, "pages", "", "Only index these pages of each file. e.g. 1-50,60,100-")
+	fs.Float64Var(&maxFileMB, "maxmb", 0, "Don't index files larger than this many MB.")
+	fs.StringVar(&exclude, "x", "", "Comma separated glob patterns of files that aren't indexed.")
+	fs.Float64Var(&minTextQuality, "q", 0, "OCR pages whose text has a quality below this (0-1).")
+	fs.IntVar(&openDocs, "docs", 0, "Max number of documents to keep open between reads.")
+	fs.Float64Var(&flushPeriod, "flush", 0, "Max seconds between saves of the file list.")
+	fs.Usage = func() {
+		fmt.Fprint(fs.Output(), `Usage: pdfsearch config [OPTIONS]
+Shows the configuration of a store after changing the options that are given. The configuration
+supplies the options that aren't given to the other commands. 0 or "" restores an option's default.
+`)
+		fs.PrintDefaults()
+	}
+	parseArgs(fs, args, 0)
+
+	config, err := doclib.LoadStoreConfig(*persistDir)
+	if err != nil {
+		return fmt.Errorf("Could not read %q. err=%v", *persistDir, err)
+	}
+	changed := false
+	fs.Visit(func(f *flag.Flag) {
+		changed = changed || f.Name != "s"
+		switch f.Name {
+		case "b":
+			config.BatchSize = batchSize
+		case "pages":
+			config.PageRanges, err = doclib.ParsePageRanges(pageRanges)
+		case "maxmb":
+			config.MaxFileMB = maxFileMB
+		case "x":
+			config.Exclude = nil
+			if exclude != "" {
+				config.Exclude = strings.Split(exclude, ",")
+			}
+		case "q":
+			config.MinTextQuality = minTextQuality
+		case "docs":
+			config.OpenDocs = openDocs
+		case "flush":
+			config.FlushPeriodSec = flushPeriod
+		}
+	})
+	if err != nil {
+		return err
+	}
+	if changed {
+		if err := doclib.SaveStoreConfig(*persistDir, config); err != nil {
+			return fmt.Errorf("Could not write the config of %q. err=%v", *persistDir, err)
+		}
+	}
+	b, err := json.MarshalIndent(config, "", "\t")
+	if err != nil {
+		return err
+	}
+	fmt.Printf("store %q\n%s\n", *persistDir, b)
+	return nil
+}
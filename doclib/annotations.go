@@ -0,0 +1,111 @@
+package doclib
+
+import (
+	"strings"
+
+	"github.com/unidoc/unidoc/pdf/core"
+	pdf "github.com/unidoc/unidoc/pdf/model"
+)
+
+// skipAnnotations are the subtypes of the annotations whose contents aren't indexed. Links and
+// form widgets don't have comments and pop-ups repeat the contents of their parents.
+var skipAnnotations = map[string]bool{
+	"Link":   true,
+	"Widget": true,
+	"Popup":  true,
+}
+
+// readPageAnnotations returns the contents of the annotations on `page` that have contents, such
+// as sticky notes, free text annotations and the comments on highlights, in page order.
+func readPageAnnotations(page *pdf.PdfPage) []string {
+	pageDict, ok := core.GetDict(page.GetContainingPdfObject())
+	if !ok {
+		return nil
+	}
+	return annotationContents(pageDict.Get("Annots"))
+}
+
+// annotationContents returns the contents of the annotations in `annots`, a page's Annots array.
+func annotationContents(annots core.PdfObject) []string {
+	arr, ok := core.GetArray(annots)
+	if !ok {
+		return nil
+	}
+	var contents []string
+	for i := 0; i < arr.Len(); i++ {
+		annot, ok := core.GetDict(arr.Get(i))
+		if !ok {
+			continue
+		}
+		if subtype, ok := core.TraceToDirectObject(annot.Get("Subtype")).(*core.PdfObjectName); ok &&
+			skipAnnotations[string(*subtype)] {
+			continue
+		}
+		s, _ := core.GetStringVal(annot.Get("Contents"))
+		if s = strings.TrimSpace(decodePdfText(s)); s != "" {
+			contents = append(contents, s)
+		}
+	}
+	return contents
+}
+
+// setPageAnnotations records that `annots` are the annotation contents of the page with index
+// `pageIdx` in `lDoc`. It is called on documents that are being written, after AddDocPage.
+func (lDoc *Doc) setPageAnnotations(pageIdx uint32, annots []string) {
+	if lDoc.isMem() {
+		for uint32(len(lDoc.pageAnnots)) <= pageIdx {
+			lDoc.pageAnnots = append(lDoc.pageAnnots, nil)
+		}
+		lDoc.pageAnnots[pageIdx] = annots
+		return
+	}
+	lDoc.spans[pageIdx].Annots = annots
+}
+
+// PageAnnotations returns the annotation contents of the page with index `pageIdx` in `lDoc`. It
+// is nil for pages without annotations and pages that were indexed before annotations were
+// recorded.
+func (lDoc *Doc) PageAnnotations(pageIdx uint32) []string {
+	if lDoc.isMem() {
+		if pageIdx < uint32(len(lDoc.pageAnnots)) {
+			return lDoc.pageAnnots[pageIdx]
+		}
+		return nil
+	}
+	if pageIdx < uint32(len(lDoc.spans)) {
+		return lDoc.spans[pageIdx].Annots
+	}
+	return nil
+}
+
+// repeatAnnotations returns the annotation contents of the pages in `lDoc` that have the same text
+// as the page with index `pageIdx`. These are the annotations that are indexed with the first page
+// with the text.
+func repeatAnnotations(lDoc *Doc, pageIdx uint32) ([]string, error) {
+	repeatIdxs, err := repeatPageIdxs(lDoc, pageIdx)
+	if err != nil {
+		return nil, err
+	}
+	var annots []string
+	for _, idx := range repeatIdxs {
+		annots = append(annots, lDoc.PageAnnotations(idx)...)
+	}
+	return annots, nil
+}
+
+// mergeRepeatAnnotations returns the annotation contents to index with each of the pages with
+// texts `texts` and annotation contents `annots`. Only the first page with each text is indexed
+// (see pageRepeats) so it gets the annotations of all the pages with its text.
+func mergeRepeatAnnotations(texts []string, annots [][]string) [][]string {
+	first := map[string]int{} // {text: index of first page with text}
+	merged := make([][]string, len(texts))
+	for i, text := range texts {
+		j, ok := first[text]
+		if !ok {
+			first[text] = i
+			j = i
+		}
+		merged[j] = append(merged[j], annots[i]...)
+	}
+	return merged
+}
@@ -0,0 +1,46 @@
+package doclib
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/unidoc/unidoc/pdf/core"
+)
+
+// makeAnnot returns an annotation dictionary with subtype `subtype` and contents `contents`.
+func makeAnnot(subtype, contents string) *core.PdfObjectDictionary {
+	annot := core.MakeDict()
+	annot.Set("Subtype", core.MakeName(subtype))
+	if contents != "" {
+		annot.Set("Contents", core.MakeString(contents))
+	}
+	return annot
+}
+
+func TestAnnotationContents(t *testing.T) {
+	annots := core.MakeArray(
+		makeAnnot("Text", "Check this figure"),
+		makeAnnot("Popup", "Check this figure"),
+		makeAnnot("Highlight", " Wrong date \n"),
+		makeAnnot("Link", "http://example.com"),
+		makeAnnot("Widget", "Name"),
+		makeAnnot("FreeText", "Approved"),
+		makeAnnot("Square", ""),
+	)
+	expected := []string{"Check this figure", "Wrong date", "Approved"}
+	if contents := annotationContents(annots); !reflect.DeepEqual(contents, expected) {
+		t.Errorf("got %q expected %q", contents, expected)
+	}
+	if contents := annotationContents(nil); len(contents) != 0 {
+		t.Errorf("no annotations: %q", contents)
+	}
+}
+
+func TestMergeRepeatAnnotations(t *testing.T) {
+	texts := []string{"cover", "form", "form", "notes", "form"}
+	annots := [][]string{{"a"}, nil, {"b"}, {"c"}, {"d", "e"}}
+	expected := [][]string{{"a"}, {"b", "d", "e"}, nil, {"c"}, nil}
+	if merged := mergeRepeatAnnotations(texts, annots); !reflect.DeepEqual(merged, expected) {
+		t.Errorf("got %q expected %q", merged, expected)
+	}
+}
@@ -6,39 +6,177 @@ import (
 
 	"github.com/blevesearch/bleve"
 	btreap "github.com/blevesearch/bleve/index/store/gtreap"
+	"github.com/blevesearch/bleve/mapping"
 	"github.com/blevesearch/blevex/preload"
 	"github.com/unidoc/unidoc/common"
 )
 
 // CreateBleveIndex creates a new persistent Bleve index at `indexPath`.
 // If `forceCreate` is true then an existing index will be deleted.
-// If `allowAppend` is true then an existing index will be appended to.
+// If `allowAppend` is true then an existing index will be appended to. ErrMappingMismatch is
+// returned if the existing index was created with a different index mapping. It must be rebuilt
+// with `forceCreate`.
 // TODO: Remove `allowAppend` argument. Instead always append to an existing index if
 //      `forceCreate` is false.
 func CreateBleveIndex(indexPath string, forceCreate, allowAppend bool) (bleve.Index, error) {
+	return createBleveIndex(indexPath, StopwordConfig{}, forceCreate, allowAppend)
+}
+
+// createBleveIndex is CreateBleveIndex for a store with stop words `stopwords`.
+func createBleveIndex(indexPath string, stopwords StopwordConfig, forceCreate, allowAppend bool) (
+	bleve.Index, error) {
+
 	// Create a new index.
-	mapping := bleve.NewIndexMapping()
-	index, err := bleve.New(indexPath, mapping)
+	indexMapping, err := newIndexMapping(stopwords)
+	if err != nil {
+		return nil, err
+	}
+	index, err := bleve.New(indexPath, indexMapping)
 	if err == bleve.ErrorIndexPathExists {
 		common.Log.Error("Bleve index %q exists.", indexPath)
 		if forceCreate {
 			common.Log.Info("Removing %q.", indexPath)
 			removeIndex(indexPath)
-			index, err = bleve.New(indexPath, mapping)
+			index, err = bleve.New(indexPath, indexMapping)
 		} else if allowAppend {
 			common.Log.Info("Opening existing %q.", indexPath)
 			index, err = bleve.Open(indexPath)
+			if err != nil {
+				return nil, err
+			}
+			if err := checkMapping(index, indexPath, stopwords); err != nil {
+				index.Close()
+				return nil, err
+			}
+			return index, nil
 		}
 	}
-	return index, err
+	if err != nil {
+		return index, err
+	}
+	if err := setMappingHash(index, stopwords); err != nil {
+		index.Close()
+		return nil, err
+	}
+	return index, nil
 }
 
 // CreateBleveMemIndex creates a new in-memory (unpersisted) Bleve index.
 func CreateBleveMemIndex() (bleve.Index, error) {
-	// Create a new index.
-	mapping := bleve.NewIndexMapping()
-	index, err := bleve.NewMemOnly(mapping)
-	return index, err
+	return createBleveMemIndex(StopwordConfig{})
+}
+
+// createBleveMemIndex is CreateBleveMemIndex for a store with stop words `stopwords`.
+func createBleveMemIndex(stopwords StopwordConfig) (bleve.Index, error) {
+	indexMapping, err := newIndexMapping(stopwords)
+	if err != nil {
+		return nil, err
+	}
+	return bleve.NewMemOnly(indexMapping)
+}
+
+// newIndexMapping returns the mapping for bleve indexes of IDText page documents in a store with
+// stop words `stopwords`.
+// The pages in each language that has an analyzer have their own document mapping. See
+// pageMapping().
+func newIndexMapping(stopwords StopwordConfig) (*mapping.IndexMappingImpl, error) {
+	indexMapping := bleve.NewIndexMapping()
+	pageAnalyzer, stopFilter, err := addStopwordAnalyzer(indexMapping, stopwords)
+	if err != nil {
+		return nil, err
+	}
+	if err := addFoldAnalyzers(indexMapping, stopFilter); err != nil {
+		return nil, err
+	}
+	if err := addQuantityAnalyzer(indexMapping); err != nil {
+		return nil, err
+	}
+	indexMapping.DefaultMapping = pageMapping("", pageAnalyzer)
+	for lang := range langAnalyzers {
+		indexMapping.AddDocumentMapping(lang, pageMapping(lang, pageAnalyzer))
+	}
+	return indexMapping, nil
+}
+
+// maxBatchOps is the default maximum number of bleve operations in a batch.
+const maxBatchOps = 1000
+
+// batcher accumulates bleve index operations and executes them in batches.
+type batcher struct {
+	index bleve.Index
+	batch *bleve.Batch
+	size  int // Maximum number of operations in a batch.
+	n     int // Number of operations in `batch`.
+	// shards are the batchers for the shards of `index` if it is a shardedIndex. Otherwise nil.
+	shards []*batcher
+}
+
+// newBatcher returns a batcher for `index` that executes batches of up to `size` operations.
+// If `size` <= 0 then maxBatchOps is used.
+func newBatcher(index bleve.Index, size int) *batcher {
+	if size <= 0 {
+		size = maxBatchOps
+	}
+	if x, ok := index.(*shardedIndex); ok {
+		b := &batcher{index: index, size: size}
+		for _, shard := range x.shards {
+			b.shards = append(b.shards, newBatcher(shard, size))
+		}
+		return b
+	}
+	return &batcher{index: index, batch: index.NewBatch(), size: size}
+}
+
+// delete adds a deletion of bleve document `id` to `b`.
+func (b *batcher) delete(id string) error {
+	if b.shards != nil {
+		// The document's hash isn't known so delete it from every shard.
+		for _, s := range b.shards {
+			if err := s.delete(id); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	b.batch.Delete(id)
+	return b.added()
+}
+
+// indexDoc adds an indexing of `data` as bleve document `id` to `b`. `hash` is the hash of the PDF
+// that the page is from. It selects the shard of a sharded index.
+func (b *batcher) indexDoc(id, hash string, data interface{}) error {
+	if b.shards != nil {
+		return b.shards[shardOf(hash, len(b.shards))].indexDoc(id, hash, data)
+	}
+	if err := b.batch.Index(id, data); err != nil {
+		return err
+	}
+	return b.added()
+}
+
+// added flushes `b` if it is full.
+func (b *batcher) added() error {
+	b.n++
+	if b.n < b.size {
+		return nil
+	}
+	return b.flush()
+}
+
+// flush executes the operations in `b`. The shards of a sharded index are written in parallel.
+func (b *batcher) flush() error {
+	if b.shards != nil {
+		return flushShards(b.shards)
+	}
+	if b.n == 0 {
+		return nil
+	}
+	if err := b.index.Batch(b.batch); err != nil {
+		return err
+	}
+	b.batch.Reset()
+	b.n = 0
+	return nil
 }
 
 // removeIndex removes the Bleve index persistent data in `indexPath` from disk.
@@ -54,17 +192,19 @@ func removeIndex(indexPath string) {
 	}
 }
 
-func TestRoundtripMem(index bleve.Index) bleve.Index {
+// TestRoundtripMem returns a copy of in-memory index `index` made by exporting it and importing the
+// export.
+func TestRoundtripMem(index bleve.Index) (bleve.Index, error) {
 	data, err := ExportBleveMem(index)
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
 	index2, err := ImportBleveMem(data)
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
 	common.Log.Info("!!!! data=%d", len(data))
-	return index2
+	return index2, nil
 }
 
 func ExportBleveMem(index bleve.Index) ([]byte, error) {
@@ -81,10 +221,19 @@ func ExportBleveMem(index bleve.Index) ([]byte, error) {
 }
 
 func ImportBleveMem(data []byte) (bleve.Index, error) {
+	return importBleveMem(data, StopwordConfig{})
+}
+
+// importBleveMem is ImportBleveMem for an index with stop words `stopwords`.
+func importBleveMem(data []byte, stopwords StopwordConfig) (bleve.Index, error) {
 
+	indexMapping, err := newIndexMapping(stopwords)
+	if err != nil {
+		return nil, err
+	}
 	index, err := bleve.NewUsing(
 		"",
-		bleve.NewIndexMapping(),
+		indexMapping,
 		bleve.Config.DefaultIndexType,
 		preload.Name,
 		map[string]interface{}{
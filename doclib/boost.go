@@ -0,0 +1,77 @@
+package doclib
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+
+	"github.com/blevesearch/bleve/search"
+	"github.com/unidoc/unidoc/common"
+)
+
+// BoostTable is a table of ranking boosts for documents. It is {file hash: boost factor}.
+// The bleve score of each hit on a document is multiplied by the document's boost factor so
+// popular or authoritative documents can be ranked higher without rebuilding the index.
+// Documents that are not in the table have a boost factor of 1.
+type BoostTable map[string]float64
+
+// boostFileName is the name of the boost table file in a store directory. SearchPdfIndex uses it
+// if it exists.
+const boostFileName = "boosts.json"
+
+// boostOversample is the number of hits per requested result that are fetched from bleve when
+// boosting. Boosted documents can only be moved up from hits that bleve returns.
+const boostOversample = 3
+
+// LoadBoostTable returns the BoostTable in JSON file `filename`. The file is a JSON object of the
+// form {"<file hash>": <boost factor>, ...}.
+func LoadBoostTable(filename string) (BoostTable, error) {
+	b, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	var boosts BoostTable
+	if err := json.Unmarshal(b, &boosts); err != nil {
+		return nil, fmt.Errorf("Could not parse boost table %q. err=%v", filename, err)
+	}
+	for hash, boost := range boosts {
+		if boost < 0 {
+			return nil, fmt.Errorf("Negative boost %g for %q in %q", boost, hash, filename)
+		}
+	}
+	return boosts, nil
+}
+
+// loadStoreBoosts returns the BoostTable in store directory `persistDir` or nil if there isn't
+// one.
+func loadStoreBoosts(persistDir string) (BoostTable, error) {
+	filename := filepath.Join(persistDir, boostFileName)
+	if !Exists(filename) {
+		return nil, nil
+	}
+	return LoadBoostTable(filename)
+}
+
+// boostHits multiplies the scores of `hits` by the boost factors in `boosts` of the documents they
+// are in, sorts them by their new scores and returns the first `maxResults` of them.
+func (lState *Store) boostHits(hits search.DocumentMatchCollection, boosts BoostTable,
+	maxResults int) search.DocumentMatchCollection {
+
+	for _, hit := range hits {
+		docIdx, _, err := decodeID(hit.ID)
+		if err != nil {
+			continue
+		}
+		if boost, ok := boosts[lState.indexHash[docIdx]]; ok {
+			hit.Score *= boost
+		}
+	}
+	sort.SliceStable(hits, func(i, j int) bool { return hits[i].Score > hits[j].Score })
+	if len(hits) > maxResults {
+		hits = hits[:maxResults]
+	}
+	common.Log.Debug("boostHits: %d hits", len(hits))
+	return hits
+}
@@ -0,0 +1,74 @@
+package doclib
+
+import (
+	"bytes"
+	"sync"
+
+	flatbuffers "github.com/google/flatbuffers/go"
+)
+
+// Searches read the positions and text of many pages and indexing serializes the positions of
+// every page. The buffers used for this are pooled to reduce garbage collection during bulk
+// operations.
+
+var (
+	// byteBufPool is a pool of *[]byte buffers for reading page positions.
+	byteBufPool = sync.Pool{New: func() interface{} { return new([]byte) }}
+	// textBufPool is a pool of *bytes.Buffer for reading page texts.
+	textBufPool = sync.Pool{New: func() interface{} { return new(bytes.Buffer) }}
+	// builderPool is a pool of *flatbuffers.Builder for serializing page positions.
+	builderPool = sync.Pool{New: func() interface{} { return flatbuffers.NewBuilder(1024) }}
+)
+
+// maxPooledBuf is the capacity of the largest buffer that is returned to a pool. Buffers for the
+// occasional huge page are left to the garbage collector so they don't stay allocated.
+const maxPooledBuf = 1 << 20
+
+// getByteBuf returns a buffer of length `size` from byteBufPool. It must be returned with
+// putByteBuf.
+func getByteBuf(size int) *[]byte {
+	bp := byteBufPool.Get().(*[]byte)
+	if cap(*bp) < size {
+		*bp = make([]byte, size)
+	}
+	*bp = (*bp)[:size]
+	return bp
+}
+
+// putByteBuf returns `bp` to byteBufPool. The caller must not use it afterwards.
+func putByteBuf(bp *[]byte) {
+	if cap(*bp) > maxPooledBuf {
+		return
+	}
+	byteBufPool.Put(bp)
+}
+
+// getTextBuf returns an empty buffer from textBufPool. It must be returned with putTextBuf.
+func getTextBuf() *bytes.Buffer {
+	buf := textBufPool.Get().(*bytes.Buffer)
+	buf.Reset()
+	return buf
+}
+
+// putTextBuf returns `buf` to textBufPool. The caller must not use it afterwards.
+func putTextBuf(buf *bytes.Buffer) {
+	if buf.Cap() > maxPooledBuf {
+		return
+	}
+	textBufPool.Put(buf)
+}
+
+// getBuilder returns a flatbuffers.Builder from builderPool. It must be returned with putBuilder
+// after the bytes it built have been used.
+func getBuilder() *flatbuffers.Builder {
+	return builderPool.Get().(*flatbuffers.Builder)
+}
+
+// putBuilder returns `b` to builderPool. The caller must not use it or the bytes it built
+// afterwards.
+func putBuilder(b *flatbuffers.Builder) {
+	if len(b.Bytes) > maxPooledBuf {
+		return
+	}
+	builderPool.Put(b)
+}
@@ -0,0 +1,318 @@
+package doclib
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/unidoc/unidoc/common"
+)
+
+// ErrNoColdStorage is returned when the positions data of a document that was moved to cold
+// storage is needed and the store has no cold Storage. See Store.SetColdStorage.
+var ErrNoColdStorage = errors.New("document is in cold storage and no cold storage is set")
+
+// Storage is a slower, cheaper storage tier, such as object storage, that the positions data of
+// rarely accessed documents can be moved to. Keys are slash separated paths relative to the
+// store's positions directory. Implementations must be safe for concurrent use.
+type Storage interface {
+	// Put stores the contents of `r` under `key`, replacing any existing contents.
+	Put(key string, r io.Reader) error
+	// Get returns a reader for the contents stored under `key`. The caller closes it.
+	Get(key string) (io.ReadCloser, error)
+	// Delete removes `key`. It is not an error if `key` doesn't exist.
+	Delete(key string) error
+}
+
+// DirStorage is a Storage in a directory, such as a directory on a network file system or a
+// mounted object storage bucket.
+type DirStorage string
+
+// Put stores the contents of `r` in file `key` in directory `d`.
+func (d DirStorage) Put(key string, r io.Reader) error {
+	path := filepath.Join(string(d), filepath.FromSlash(key))
+	if err := os.MkdirAll(filepath.Dir(path), 0777); err != nil {
+		return err
+	}
+	return writeFileAtomic(path, r)
+}
+
+// Get opens file `key` in directory `d`.
+func (d DirStorage) Get(key string) (io.ReadCloser, error) {
+	return os.Open(filepath.Join(string(d), filepath.FromSlash(key)))
+}
+
+// Delete removes file `key` from directory `d`.
+func (d DirStorage) Delete(key string) error {
+	err := os.Remove(filepath.Join(string(d), filepath.FromSlash(key)))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// SetColdStorage sets the Storage that FreezeDocs moves positions data to and that the data of
+// frozen documents is fetched from when they are read. It must be set every time the store is
+// opened. Use SetColdDir for cold storage that is a directory.
+func (lState *Store) SetColdStorage(cold Storage) {
+	lState.cold = cold
+}
+
+// SetColdDir sets the cold storage of `lState` to the DirStorage `dir` and records it in the
+// store's manifest so that the store uses it whenever it is opened.
+func (lState *Store) SetColdDir(dir string) error {
+	if lState.isMem() {
+		return fmt.Errorf("SetColdDir: in-memory stores don't have cold storage")
+	}
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0777); err != nil {
+		return err
+	}
+	m, err := loadManifest(lState.root)
+	if err != nil {
+		return err
+	}
+	m.ColdDir = dir
+	if err := saveManifest(lState.root, m); err != nil {
+		return err
+	}
+	lState.cold = DirStorage(dir)
+	return nil
+}
+
+// FreezeDocs moves the positions data and legacy per-document page texts of the documents in
+// `lState` that were indexed before `before` to lState's cold Storage and deletes the local
+// copies. Documents that were indexed before indexing times were recorded are frozen too.
+// Frozen documents are still searched. Their data is fetched back to the local disk the first time
+// a search needs it and stays there as a cache until the next FreezeDocs.
+// It returns the number of documents that were frozen.
+func (lState *Store) FreezeDocs(before time.Time) (int, error) {
+	if lState.isMem() {
+		return 0, fmt.Errorf("FreezeDocs: in-memory stores can't be frozen")
+	}
+	if lState.cold == nil {
+		return 0, ErrNoColdStorage
+	}
+
+	// Copy the documents to cold storage and record that they are frozen before deleting any local
+	// files so that a failure part way through doesn't lose data.
+	var frozen []int
+	n := 0
+	for i, fd := range lState.fileList {
+		if !fd.Indexed.Before(before) {
+			continue
+		}
+		frozen = append(frozen, i)
+		if fd.Cold {
+			continue // Only the local cache of the document's files needs to be deleted.
+		}
+		paths, err := coldPaths(lState.docPersistPaths(fd.Hash))
+		if err != nil {
+			return 0, err
+		}
+		for _, path := range paths {
+			if err := lState.putCold(path); err != nil {
+				return 0, err
+			}
+		}
+		lState.fileList[i].Cold = true
+		n++
+	}
+	if err := lState.Flush(); err != nil {
+		return 0, err
+	}
+
+	for _, i := range frozen {
+		fd := lState.fileList[i]
+		paths, err := coldPaths(lState.docPersistPaths(fd.Hash))
+		if err != nil {
+			return n, err
+		}
+		lState.evictDoc(fd.Hash)
+		for _, path := range paths {
+			if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+				return n, err
+			}
+		}
+	}
+	if n > 0 {
+		lState.bumpGeneration()
+	}
+	common.Log.Info("FreezeDocs: Froze %d documents indexed before %s. %d are frozen",
+		n, before, len(frozen))
+	return n, nil
+}
+
+// coldPaths returns the paths of the local files of the document with files `persist` that are
+// moved to cold storage: its positions data and any legacy page text files.
+func coldPaths(persist *docPersist) ([]string, error) {
+	var paths []string
+	if Exists(persist.dataPath) {
+		paths = append(paths, persist.dataPath)
+	}
+	if Exists(persist.textDir) {
+		infos, err := ioutil.ReadDir(persist.textDir)
+		if err != nil {
+			return nil, err
+		}
+		for _, fi := range infos {
+			paths = append(paths, filepath.Join(persist.textDir, fi.Name()))
+		}
+	}
+	return paths, nil
+}
+
+// thawDoc fetches the files of frozen document `lDoc` that aren't on the local disk from lState's
+// cold storage. It does nothing for documents that aren't frozen.
+func (lState *Store) thawDoc(lDoc *Doc) error {
+	fd := lState.fileList[lDoc.docIdx]
+	if !fd.Cold || Exists(lDoc.dataPath) {
+		return nil
+	}
+	if lState.cold == nil {
+		return fmt.Errorf("Could not read %q. err=%v", fd.InPath, ErrNoColdStorage)
+	}
+	t0 := time.Now()
+	if err := lState.getCold(lDoc.dataPath); err != nil {
+		return err
+	}
+	// Legacy page text files are fetched as they are read. See readPersistedPageText.
+	common.Log.Info("thawDoc: Fetched %q from cold storage in %.3f sec", fd.InPath,
+		time.Since(t0).Seconds())
+	return nil
+}
+
+// thawPageText fetches legacy page text file `filename` of frozen document `lDoc` from lState's
+// cold storage if it isn't on the local disk.
+func (lState *Store) thawPageText(lDoc *Doc, filename string) error {
+	if !lState.fileList[lDoc.docIdx].Cold || lState.cold == nil || Exists(filename) {
+		return nil
+	}
+	return lState.getCold(filename)
+}
+
+// openSpans returns the Doc of document `docIdx` in persistent store `lState` with its
+// page spans but not its positions data, which may be in cold storage. It doesn't need to be
+// closed.
+func (lState *Store) openSpans(docIdx uint64) (*Doc, error) {
+	lDoc, err := lState.baseFields(docIdx)
+	if err != nil {
+		return nil, err
+	}
+	b, err := ioutil.ReadFile(lDoc.spansPath)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(b, &lDoc.spans); err != nil {
+		return nil, err
+	}
+	lDoc.readOnly = true
+	return lDoc, nil
+}
+
+// unfreezeDoc fetches all the files of frozen document `lDoc` from cold storage and marks it as
+// not frozen so that it can be modified. Its cold copies are deleted.
+func (lState *Store) unfreezeDoc(lDoc *Doc) error {
+	fd := &lState.fileList[lDoc.docIdx]
+	if !fd.Cold {
+		return nil
+	}
+	if err := lState.thawDoc(lDoc); err != nil {
+		return err
+	}
+	for pageIdx, span := range lDoc.spans {
+		if span.TextHash != "" {
+			continue
+		}
+		if err := lState.thawPageText(lDoc, lDoc.GetTextPath(uint32(pageIdx))); err != nil {
+			return err
+		}
+	}
+	fd.Cold = false
+	if err := lState.Flush(); err != nil {
+		return err
+	}
+	return lState.deleteCold(lDoc)
+}
+
+// deleteCold deletes the cold copies of the files of frozen document `lDoc`.
+func (lState *Store) deleteCold(lDoc *Doc) error {
+	if lState.cold == nil {
+		return nil
+	}
+	if err := lState.cold.Delete(lState.coldKey(lDoc.dataPath)); err != nil {
+		return err
+	}
+	for pageIdx, span := range lDoc.spans {
+		if span.TextHash != "" {
+			continue // Content-addressed page texts are never frozen.
+		}
+		key := lState.coldKey(lDoc.GetTextPath(uint32(pageIdx)))
+		if err := lState.cold.Delete(key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// coldKey returns the cold storage key of local file `path` in `lState`.
+func (lState *Store) coldKey(path string) string {
+	rel, err := filepath.Rel(lState.positionsDir(), path)
+	if err != nil {
+		rel = filepath.Base(path)
+	}
+	return filepath.ToSlash(rel)
+}
+
+// putCold copies local file `path` to lState's cold storage.
+func (lState *Store) putCold(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err := lState.cold.Put(lState.coldKey(path), f); err != nil {
+		return fmt.Errorf("Could not write %q to cold storage. err=%v", path, err)
+	}
+	return nil
+}
+
+// getCold copies local file `path` from lState's cold storage.
+func (lState *Store) getCold(path string) error {
+	r, err := lState.cold.Get(lState.coldKey(path))
+	if err != nil {
+		return fmt.Errorf("Could not read %q from cold storage. err=%v", path, err)
+	}
+	defer r.Close()
+	if err := MkParentDir(path); err != nil {
+		return err
+	}
+	return writeFileAtomic(path, r)
+}
+
+// writeFileAtomic writes the contents of `r` to file `path`. Concurrent readers of `path` see
+// either no file or the complete file.
+func writeFileAtomic(path string, r io.Reader) error {
+	f, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
@@ -0,0 +1,276 @@
+package doclib
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/unidoc/unidoc/common"
+)
+
+const (
+	// badHashesFileName is the name of the list of known bad PDFs in a store directory. Each line
+	// is the hash of a PDF followed by the reason it is bad. Blank lines and lines starting with #
+	// are ignored, so users can add PDFs to the list. Comments aren't kept when PDFs are
+	// quarantined.
+	badHashesFileName = "bad_hashes.txt"
+	// extractingFileName is the name of the list of the PDFs whose text is being extracted in a
+	// store directory. Each line is the hash of a PDF followed by its path.
+	extractingFileName = "extracting.txt"
+	// suspectsFileName is the name of the list of the PDFs that were being extracted when the last
+	// indexing process died, in the format of extractingFileName.
+	suspectsFileName = "suspects.txt"
+)
+
+// CorpusFilter is the list of known bad PDFs of a store. The PDFs in the list are skipped when
+// documents are indexed into the store. PDFs that crash the text extractor are added to the list,
+// quarantined, so that later indexing runs skip them. There are two kinds of crashes:
+//   - Panics in the PDF library, which are recovered from. See ProcessPDFReader.
+//   - Fatal errors, such as stack overflows, and hangs that kill the indexing process or make
+//     users kill it. These are detected by the next indexing run from the PDFs that were being
+//     extracted when the process died. Those PDFs become suspects. A suspect is quarantined if
+//     it is being extracted again when the next indexing process dies. The suspects are cleared
+//     when it doesn't die. This keeps the other PDFs that were being extracted when a process
+//     died, or when a user stopped it, out of the list.
+//
+// Files are excluded by their paths with IndexOptions.Exclude.
+type CorpusFilter struct {
+	persistDir string
+	mu         sync.Mutex
+	bad        map[string]string // {hash: reason the PDF is bad}
+	extracting map[string]string // {hash: path} of the PDFs being extracted.
+	suspects   map[string]string // {hash: path} of the PDFs that may have killed an indexer.
+}
+
+// LoadCorpusFilter returns the list of known bad PDFs of the store in `persistDir`. PDFs that were
+// being extracted when the last indexing process died become suspects. Suspects that were being
+// extracted when the indexing process before it died too are quarantined.
+func LoadCorpusFilter(persistDir string) (*CorpusFilter, error) {
+	bad, err := readHashList(filepath.Join(persistDir, badHashesFileName))
+	if err != nil {
+		return nil, err
+	}
+	crashed, err := readHashList(filepath.Join(persistDir, extractingFileName))
+	if err != nil {
+		return nil, err
+	}
+	suspects, err := readHashList(filepath.Join(persistDir, suspectsFileName))
+	if err != nil {
+		return nil, err
+	}
+	f := &CorpusFilter{persistDir: persistDir, bad: bad, extracting: map[string]string{},
+		suspects: map[string]string{}}
+	if len(crashed) == 0 {
+		f.suspects = suspects
+		return f, nil
+	}
+	for hash, inPath := range crashed {
+		if _, ok := suspects[hash]; !ok {
+			common.Log.Error("LoadCorpusFilter: %q was being extracted when an indexing process "+
+				"died. It will be quarantined if that happens again.", inPath)
+			f.suspects[hash] = inPath
+			continue
+		}
+		common.Log.Error("LoadCorpusFilter: Quarantining %q. It crashed the text extractor.",
+			inPath)
+		f.bad[hash] = fmt.Sprintf("crashed extractor: %s", inPath)
+	}
+	if err := f.saveBad(); err != nil {
+		return nil, err
+	}
+	if err := f.saveSuspects(); err != nil {
+		return nil, err
+	}
+	if err := f.saveExtracting(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// BadHashes returns the list of known bad PDFs as {hash: reason the PDF is bad}.
+func (f *CorpusFilter) BadHashes() map[string]string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	bad := make(map[string]string, len(f.bad))
+	for hash, reason := range f.bad {
+		bad[hash] = reason
+	}
+	return bad
+}
+
+// AddBadHash adds the PDF with hash `hash` to the list of known bad PDFs. `reason` says why it is
+// bad.
+func (f *CorpusFilter) AddBadHash(hash, reason string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.bad[hash] = strings.Join(strings.Fields(reason), " ")
+	return f.saveBad()
+}
+
+// RemoveBadHash removes the PDF with hash `hash` from the list of known bad PDFs so that it will
+// be indexed again. It returns false if the PDF isn't in the list.
+func (f *CorpusFilter) RemoveBadHash(hash string) (bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, ok := f.bad[hash]; !ok {
+		return false, nil
+	}
+	delete(f.bad, hash)
+	return true, f.saveBad()
+}
+
+// badReason returns the reason the PDF with hash `hash` is bad or "" if it isn't known to be bad.
+func (f *CorpusFilter) badReason(hash string) string {
+	if f == nil {
+		return ""
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.bad[hash]
+}
+
+// startExtraction records that the text of PDF `inPath` with hash `hash` is about to be extracted.
+func (f *CorpusFilter) startExtraction(hash, inPath string) {
+	if f == nil {
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.extracting[hash] = inPath
+	if err := f.saveExtracting(); err != nil {
+		common.Log.Error("startExtraction: Couldn't save %q. err=%v", inPath, err)
+	}
+}
+
+// endExtraction records that the extraction of PDF `inPath` with hash `hash` has finished with
+// error `err`. The PDF is quarantined if the PDF library panicked. Otherwise it is no longer a
+// suspect.
+func (f *CorpusFilter) endExtraction(hash, inPath string, err error) {
+	if f == nil {
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.extracting, hash)
+	if saveErr := f.saveExtracting(); saveErr != nil {
+		common.Log.Error("endExtraction: Couldn't save %q. err=%v", inPath, saveErr)
+	}
+	if _, ok := f.suspects[hash]; ok {
+		delete(f.suspects, hash)
+		if saveErr := f.saveSuspects(); saveErr != nil {
+			common.Log.Error("endExtraction: Couldn't save %q. err=%v", inPath, saveErr)
+		}
+	}
+	if _, ok := err.(panicError); !ok {
+		return
+	}
+	common.Log.Error("endExtraction: Quarantining %q. err=%v", inPath, err)
+	f.bad[hash] = strings.Join(strings.Fields(fmt.Sprintf("crashed extractor: %s: %v",
+		inPath, err)), " ")
+	if saveErr := f.saveBad(); saveErr != nil {
+		common.Log.Error("endExtraction: Couldn't quarantine %q. err=%v", inPath, saveErr)
+	}
+}
+
+// clearSuspects records that an indexing process finished without dying, so the PDFs that were
+// being extracted when the process before it died are no longer suspects.
+func (f *CorpusFilter) clearSuspects() error {
+	if f == nil {
+		return nil
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if len(f.suspects) == 0 {
+		return nil
+	}
+	f.suspects = map[string]string{}
+	return f.saveSuspects()
+}
+
+// saveBad writes the list of known bad PDFs to the store directory. It must be called with f.mu
+// held.
+func (f *CorpusFilter) saveBad() error {
+	return writeHashList(filepath.Join(f.persistDir, badHashesFileName), f.bad,
+		"# Known bad PDFs. These are not indexed. Each line is: <hash> <reason>")
+}
+
+// saveExtracting writes the list of PDFs being extracted to the store directory. It must be called
+// with f.mu held.
+func (f *CorpusFilter) saveExtracting() error {
+	return saveOrRemoveHashList(filepath.Join(f.persistDir, extractingFileName), f.extracting)
+}
+
+// saveSuspects writes the list of suspect PDFs to the store directory. It must be called with f.mu
+// held.
+func (f *CorpusFilter) saveSuspects() error {
+	return saveOrRemoveHashList(filepath.Join(f.persistDir, suspectsFileName), f.suspects)
+}
+
+// saveOrRemoveHashList writes the {hash: path} entries in `entries` to file `path` or removes the
+// file if there are no entries.
+func saveOrRemoveHashList(path string, entries map[string]string) error {
+	if len(entries) == 0 {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	return writeHashList(path, entries, "")
+}
+
+// readHashList returns the {hash: value} entries in the file `path` in the format of
+// badHashesFileName. It returns an empty map if the file doesn't exist.
+func readHashList(path string) (map[string]string, error) {
+	entries := map[string]string{}
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return entries, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Could not read %q. err=%v", path, err)
+	}
+	return parseHashList(bytes.NewReader(b))
+}
+
+// parseHashList returns the {hash: value} entries in the hash list read from `r`.
+func parseHashList(r io.Reader) (map[string]string, error) {
+	entries := map[string]string{}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		entries[fields[0]] = strings.Join(fields[1:], " ")
+	}
+	return entries, scanner.Err()
+}
+
+// writeHashList writes the {hash: value} entries in `entries`, sorted by hash, to file `path`
+// after the comment line `header` if it isn't empty.
+func writeHashList(path string, entries map[string]string, header string) error {
+	hashes := make([]string, 0, len(entries))
+	for hash := range entries {
+		hashes = append(hashes, hash)
+	}
+	sort.Strings(hashes)
+	var buf bytes.Buffer
+	if header != "" {
+		fmt.Fprintln(&buf, header)
+	}
+	for _, hash := range hashes {
+		fmt.Fprintf(&buf, "%s %s\n", hash, entries[hash])
+	}
+	if err := MkParentDir(path); err != nil {
+		return err
+	}
+	return writeFileAtomic(path, &buf)
+}
@@ -0,0 +1,100 @@
+package doclib
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCorpusFilter(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pdf-search-filter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// A user's list and a PDF that was being extracted when the last indexing process died.
+	userList := "# Don't index these\n\naaaa  encrypted\tand broken\n"
+	if err := ioutil.WriteFile(filepath.Join(dir, badHashesFileName), []byte(userList),
+		0666); err != nil {
+		t.Fatal(err)
+	}
+	crash := func(extracting string) *CorpusFilter {
+		if err := ioutil.WriteFile(filepath.Join(dir, extractingFileName), []byte(extracting),
+			0666); err != nil {
+			t.Fatal(err)
+		}
+		f, err := LoadCorpusFilter(dir)
+		if err != nil {
+			t.Fatalf("LoadCorpusFilter failed. err=%v", err)
+		}
+		if Exists(filepath.Join(dir, extractingFileName)) {
+			t.Errorf("%s wasn't removed", extractingFileName)
+		}
+		return f
+	}
+	f := crash("bbbb hang.pdf\neeee innocent.pdf\n")
+	if reason := f.badReason("aaaa"); reason != "encrypted and broken" {
+		t.Errorf("aaaa: reason=%q", reason)
+	}
+	if reason := f.badReason("bbbb"); reason != "" {
+		t.Errorf("bbbb was quarantined after one crash. reason=%q", reason)
+	}
+	// bbbb is being extracted when the next process dies too. eeee isn't.
+	f = crash("bbbb hang.pdf\nffff other.pdf\n")
+	if reason := f.badReason("bbbb"); reason != "crashed extractor: hang.pdf" {
+		t.Errorf("bbbb wasn't quarantined. reason=%q", reason)
+	}
+	for _, hash := range []string{"eeee", "ffff"} {
+		if reason := f.badReason(hash); reason != "" {
+			t.Errorf("%s was quarantined. reason=%q", hash, reason)
+		}
+	}
+	// ffff is cleared by a process that doesn't die.
+	if err := f.clearSuspects(); err != nil {
+		t.Fatal(err)
+	}
+	if Exists(filepath.Join(dir, suspectsFileName)) {
+		t.Errorf("%s wasn't removed", suspectsFileName)
+	}
+	f = crash("ffff other.pdf\n")
+	if reason := f.badReason("ffff"); reason != "" {
+		t.Errorf("ffff was quarantined after crashes that weren't in a row. reason=%q", reason)
+	}
+
+	f.startExtraction("cccc", "ok.pdf")
+	f.endExtraction("cccc", "ok.pdf", errors.New("no pages"))
+	f.startExtraction("dddd", "panic.pdf")
+	f.endExtraction("dddd", "panic.pdf", panicError{errors.New("nil pointer")})
+	if reason := f.badReason("cccc"); reason != "" {
+		t.Errorf("cccc: PDFs that fail without crashing aren't bad. reason=%q", reason)
+	}
+	if ok, err := f.RemoveBadHash("aaaa"); !ok || err != nil {
+		t.Errorf("RemoveBadHash failed. ok=%t err=%v", ok, err)
+	}
+
+	// The list is saved as it changes.
+	f, err = LoadCorpusFilter(dir)
+	if err != nil {
+		t.Fatalf("LoadCorpusFilter failed. err=%v", err)
+	}
+	expected := map[string]string{
+		"bbbb": "crashed extractor: hang.pdf",
+		"dddd": "crashed extractor: panic.pdf: nil pointer",
+	}
+	bad := f.BadHashes()
+	if len(bad) != len(expected) {
+		t.Fatalf("got %d bad PDFs expected %d. %q", len(bad), len(expected), bad)
+	}
+	for hash, reason := range expected {
+		if bad[hash] != reason {
+			t.Errorf("%s: got %q expected %q", hash, bad[hash], reason)
+		}
+	}
+	var nilFilter *CorpusFilter // In-memory stores have no filter.
+	if reason := nilFilter.badReason("bbbb"); reason != "" {
+		t.Errorf("nil filter: reason=%q", reason)
+	}
+}
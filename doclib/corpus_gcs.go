@@ -0,0 +1,88 @@
+//go:build gcs
+// +build gcs
+
+package doclib
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/iterator"
+)
+
+// GCSSource is the CorpusSource of the PDF and image objects in a Google Cloud Storage bucket.
+// Documents are named by their object URLs, e.g. gs://bucket/reports/2019.pdf.
+// It uses the application default credentials.
+type GCSSource struct {
+	Bucket string
+	Prefix string // Only objects whose names start with Prefix are indexed.
+	client *storage.Client
+}
+
+// NewGCSSource returns a GCSSource for the objects in `bucket` whose names start with `prefix`.
+func NewGCSSource(bucket, prefix string) (*GCSSource, error) {
+	client, err := storage.NewClient(context.Background())
+	if err != nil {
+		return nil, fmt.Errorf("Could not create GCS client. err=%v", err)
+	}
+	return &GCSSource{Bucket: bucket, Prefix: prefix, client: client}, nil
+}
+
+// List returns the URLs of the PDF and image objects in `g`.
+func (g *GCSSource) List() ([]string, error) {
+	var names []string
+	it := g.client.Bucket(g.Bucket).Objects(context.Background(), &storage.Query{Prefix: g.Prefix})
+	for {
+		attrs, err := it.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return names, err
+		}
+		if isCorpusDoc(attrs.Name) {
+			names = append(names, g.url(attrs.Name))
+		}
+	}
+	return names, nil
+}
+
+// Open returns the contents of the object with URL `name`.
+func (g *GCSSource) Open(name string) (io.ReadCloser, error) {
+	obj, err := g.object(name)
+	if err != nil {
+		return nil, err
+	}
+	return g.client.Bucket(g.Bucket).Object(obj).NewReader(context.Background())
+}
+
+// Hash returns the value of the "sha256" metadata of the object with URL `name` or "" if it
+// doesn't have one. GCS only computes MD5 and CRC32C checksums.
+func (g *GCSSource) Hash(name string) (string, error) {
+	obj, err := g.object(name)
+	if err != nil {
+		return "", err
+	}
+	attrs, err := g.client.Bucket(g.Bucket).Object(obj).Attrs(context.Background())
+	if err != nil {
+		return "", err
+	}
+	return strings.ToLower(attrs.Metadata["sha256"]), nil
+}
+
+// url returns the URL of object `obj`.
+func (g *GCSSource) url(obj string) string {
+	return "gs://" + g.Bucket + "/" + obj
+}
+
+// object returns the name of the object with URL `name`.
+func (g *GCSSource) object(name string) (string, error) {
+	prefix := "gs://" + g.Bucket + "/"
+	if !strings.HasPrefix(name, prefix) {
+		return "", fmt.Errorf("%q is not in bucket %q", name, g.Bucket)
+	}
+	return strings.TrimPrefix(name, prefix), nil
+}
@@ -0,0 +1,29 @@
+//go:build !gcs
+// +build !gcs
+
+package doclib
+
+import "io"
+
+// GCSSource is a placeholder for builds without the `gcs` tag.
+type GCSSource struct{}
+
+// NewGCSSource returns ErrNoGCS. Build with `-tags gcs` for Google Cloud Storage sources.
+func NewGCSSource(bucket, prefix string) (*GCSSource, error) {
+	return nil, ErrNoGCS
+}
+
+// List returns ErrNoGCS.
+func (g *GCSSource) List() ([]string, error) {
+	return nil, ErrNoGCS
+}
+
+// Open returns ErrNoGCS.
+func (g *GCSSource) Open(name string) (io.ReadCloser, error) {
+	return nil, ErrNoGCS
+}
+
+// Hash returns ErrNoGCS.
+func (g *GCSSource) Hash(name string) (string, error) {
+	return "", ErrNoGCS
+}
@@ -0,0 +1,108 @@
+//go:build s3
+// +build s3
+
+package doclib
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// S3Source is the CorpusSource of the PDF and image objects in an S3 bucket. Documents are named
+// by their object URLs, e.g. s3://bucket/reports/2019.pdf.
+// It uses the AWS credentials and region from the environment. See session.NewSession.
+type S3Source struct {
+	Bucket string
+	Prefix string // Only objects whose keys start with Prefix are indexed.
+	client *s3.S3
+}
+
+// NewS3Source returns an S3Source for the objects in `bucket` whose keys start with `prefix`.
+func NewS3Source(bucket, prefix string) (*S3Source, error) {
+	sess, err := session.NewSessionWithOptions(session.Options{
+		SharedConfigState: session.SharedConfigEnable,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Could not create AWS session. err=%v", err)
+	}
+	return &S3Source{Bucket: bucket, Prefix: prefix, client: s3.New(sess)}, nil
+}
+
+// List returns the URLs of the PDF and image objects in `s`.
+func (s *S3Source) List() ([]string, error) {
+	var names []string
+	input := &s3.ListObjectsV2Input{Bucket: aws.String(s.Bucket), Prefix: aws.String(s.Prefix)}
+	err := s.client.ListObjectsV2Pages(input, func(page *s3.ListObjectsV2Output, last bool) bool {
+		for _, obj := range page.Contents {
+			key := aws.StringValue(obj.Key)
+			if isCorpusDoc(key) {
+				names = append(names, s.url(key))
+			}
+		}
+		return true
+	})
+	return names, err
+}
+
+// Open returns the contents of the object with URL `name`.
+func (s *S3Source) Open(name string) (io.ReadCloser, error) {
+	key, err := s.key(name)
+	if err != nil {
+		return nil, err
+	}
+	out, err := s.client.GetObject(&s3.GetObjectInput{
+		Bucket: aws.String(s.Bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return out.Body, nil
+}
+
+// Hash returns the SHA-256 checksum of the object with URL `name` if it was uploaded with one.
+// Multipart uploads have checksums of their parts, not of the object, so "" is returned for them.
+func (s *S3Source) Hash(name string) (string, error) {
+	key, err := s.key(name)
+	if err != nil {
+		return "", err
+	}
+	out, err := s.client.HeadObject(&s3.HeadObjectInput{
+		Bucket:       aws.String(s.Bucket),
+		Key:          aws.String(key),
+		ChecksumMode: aws.String(s3.ChecksumModeEnabled),
+	})
+	if err != nil {
+		return "", err
+	}
+	checksum := aws.StringValue(out.ChecksumSHA256)
+	if checksum == "" || strings.Contains(checksum, "-") {
+		return "", nil
+	}
+	b, err := base64.StdEncoding.DecodeString(checksum)
+	if err != nil {
+		return "", nil
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// url returns the URL of the object with key `key`.
+func (s *S3Source) url(key string) string {
+	return "s3://" + s.Bucket + "/" + key
+}
+
+// key returns the key of the object with URL `name`.
+func (s *S3Source) key(name string) (string, error) {
+	prefix := "s3://" + s.Bucket + "/"
+	if !strings.HasPrefix(name, prefix) {
+		return "", fmt.Errorf("%q is not in bucket %q", name, s.Bucket)
+	}
+	return strings.TrimPrefix(name, prefix), nil
+}
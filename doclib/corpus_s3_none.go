@@ -0,0 +1,29 @@
+//go:build !s3
+// +build !s3
+
+package doclib
+
+import "io"
+
+// S3Source is a placeholder for builds without the `s3` tag.
+type S3Source struct{}
+
+// NewS3Source returns ErrNoS3. Build with `-tags s3` for S3 sources.
+func NewS3Source(bucket, prefix string) (*S3Source, error) {
+	return nil, ErrNoS3
+}
+
+// List returns ErrNoS3.
+func (s *S3Source) List() ([]string, error) {
+	return nil, ErrNoS3
+}
+
+// Open returns ErrNoS3.
+func (s *S3Source) Open(name string) (io.ReadCloser, error) {
+	return nil, ErrNoS3
+}
+
+// Hash returns ErrNoS3.
+func (s *S3Source) Hash(name string) (string, error) {
+	return "", ErrNoS3
+}
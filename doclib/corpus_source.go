@@ -0,0 +1,124 @@
+package doclib
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/blevesearch/bleve"
+	"github.com/unidoc/unidoc/common"
+)
+
+var (
+	// ErrNoS3 is returned by NewS3Source in builds without S3 support.
+	ErrNoS3 = errors.New("S3 sources need a build with -tags s3")
+	// ErrNoGCS is returned by NewGCSSource in builds without Google Cloud Storage support.
+	ErrNoGCS = errors.New("GCS sources need a build with -tags gcs")
+)
+
+// CorpusSource is a repository of documents that can be indexed, such as a directory tree or a
+// cloud storage bucket. Documents are identified by names, such as file paths or object URLs,
+// which are recorded as the documents' FileDesc.InPath. Implementations must be safe for
+// concurrent use.
+type CorpusSource interface {
+	// List returns the names of the documents in the source.
+	List() ([]string, error)
+	// Open returns a reader for the contents of document `name`. The caller closes it.
+	Open(name string) (io.ReadCloser, error)
+	// Hash returns the hex SHA-256 hash of the contents of document `name`, like FileDesc.Hash, or
+	// "" if the source can't tell without reading the contents. Documents whose hashes are
+	// already in the store are not downloaded.
+	Hash(name string) (string, error)
+}
+
+// IndexCorpus is IndexPdfReadersOpts for the documents in `src`. The documents are opened by the
+// extraction workers and spooled with SpoolReader, so they aren't all read at once.
+// Documents that are too large are handled as in IndexPdfStreams.
+// PDFs that aren't files on disk are copied to temporary files when their pages are recognized
+// with OCR, which renders pages from files.
+func IndexCorpus(src CorpusSource, persistDir string, forceCreate, allowAppend bool,
+	opts IndexOptions, report func(string)) (*Store, bleve.Index, int, error) {
+
+	names, err := src.List()
+	if err != nil {
+		return nil, nil, 0, fmt.Errorf("Could not list corpus. err=%v", err)
+	}
+	opts.Source = src
+	return IndexPdfReadersOpts(names, nil, persistDir, forceCreate, allowAppend, opts, report)
+}
+
+// extractSourceDoc extracts the text and text locations from document `name` in opts.Source.
+// It does not modify any shared state so it may be called concurrently.
+func extractSourceDoc(ctx context.Context, name string, opts IndexOptions) docExtraction {
+	t0 := time.Now()
+	hash, err := opts.Source.Hash(name)
+	if err != nil {
+		common.Log.Error("extractSourceDoc: Could not get hash of %q. err=%v", name, err)
+	} else if hash != "" && opts.skipHashes[hash] {
+		fd := FileDesc{InPath: name, Hash: hash}
+		return docExtraction{inPath: name, fd: fd, exists: true, duration: time.Since(t0)}
+	}
+	r, err := opts.Source.Open(name)
+	if err != nil {
+		return docExtraction{inPath: name, err: err, duration: time.Since(t0)}
+	}
+	defer r.Close()
+	s, err := spoolDoc(name, r, opts)
+	if err != nil {
+		return docExtraction{inPath: name, err: err, duration: time.Since(t0)}
+	}
+	defer s.Close()
+	ext := extractDocPagePositions(ctx, name, s, opts)
+	ext.duration = time.Since(t0)
+	return ext
+}
+
+// spoolDoc returns a SpooledReader for document `name` which is read from `r`. Documents larger
+// than opts.MaxFileMB are truncated so that they are reported as too large when they are
+// indexed. If opts.MaxFileMB isn't set then an error is returned for documents larger than
+// maxSpoolMB.
+func spoolDoc(name string, r io.Reader, opts IndexOptions) (*SpooledReader, error) {
+	maxMB := float64(maxSpoolMB)
+	if opts.MaxFileMB > 0 {
+		maxMB = opts.MaxFileMB
+	}
+	s, err := SpoolReader(r, int64(maxMB*1024*1024))
+	if err != nil {
+		return nil, fmt.Errorf("Could not read %q. err=%v", name, err)
+	}
+	if s.Truncated && opts.MaxFileMB <= 0 {
+		s.Close()
+		return nil, fmt.Errorf("%q is larger than %.0f MB", name, maxMB)
+	}
+	return s, nil
+}
+
+// GlobSource is the CorpusSource of the local files that match glob patterns. `**` matches any
+// number of directories. See PatternsToPaths.
+type GlobSource []string
+
+// List returns the paths of the regular files that match the patterns in `g`, smallest first.
+func (g GlobSource) List() ([]string, error) {
+	return PatternsToPaths(g, true)
+}
+
+// Open opens file `name`.
+func (g GlobSource) Open(name string) (io.ReadCloser, error) {
+	return os.Open(name)
+}
+
+// Hash returns "". Local files are hashed when they are read.
+func (g GlobSource) Hash(name string) (string, error) {
+	return "", nil
+}
+
+// isCorpusDoc returns true if object `name` in a cloud storage bucket has the extension of a file
+// that can be indexed.
+func isCorpusDoc(name string) bool {
+	return strings.ToLower(filepath.Ext(name)) == ".pdf" || IsImageFile(name)
+}
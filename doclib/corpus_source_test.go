@@ -0,0 +1,22 @@
+package doclib
+
+import "testing"
+
+func TestIsCorpusDoc(t *testing.T) {
+	tests := []struct {
+		name string
+		doc  bool
+	}{
+		{"reports/2019/q1.pdf", true},
+		{"reports/2019/Q2.PDF", true},
+		{"scans/page1.tif", true},
+		{"reports/2019/", false},
+		{"reports/2019/q1.docx", false},
+		{"pdf", false},
+	}
+	for _, test := range tests {
+		if doc := isCorpusDoc(test.name); doc != test.doc {
+			t.Errorf("name=%q doc=%t expected=%t", test.name, doc, test.doc)
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package doclib
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/blevesearch/bleve"
+	"github.com/blevesearch/bleve/search/query"
+)
+
+// Date fields that searches can be filtered and sorted by. See SearchOptions.DateField.
+const (
+	DateCreated  = "created"  // The PDF's creation date. See DocMetadata.CreationDate.
+	DateModified = "modified" // The modification time of the PDF file. See FileDesc.Modified.
+)
+
+// dateLayouts are the layouts of the dates that ParseDate accepts, most specific first.
+var dateLayouts = []string{time.RFC3339, "2006-01-02T15:04:05", "2006-01-02", "2006-01", "2006"}
+
+// ParseDate returns the time of date `s`, which is an RFC 3339 time or a date of the form
+// 2006-01-02, 2006-01 or 2006. Dates without times are the start of the day, month or year in UTC.
+// The zero time is returned for "".
+func ParseDate(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	for _, layout := range dateLayouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("Bad date %q. Use 2006-01-02, 2006-01, 2006 or RFC 3339.", s)
+}
+
+// checkDateOptions returns an error if the date field or date range in `opts` are not valid.
+func checkDateOptions(opts SearchOptions) error {
+	switch opts.DateField {
+	case "", DateCreated, DateModified:
+	default:
+		return fmt.Errorf("Unknown date field %q. Use %q or %q.", opts.DateField, DateCreated,
+			DateModified)
+	}
+	if !opts.After.IsZero() && !opts.Before.IsZero() && !opts.After.Before(opts.Before) {
+		return fmt.Errorf("Empty date range. after=%s before=%s", opts.After, opts.Before)
+	}
+	return nil
+}
+
+// dateField returns the name of the bleve field of the date that `opts` filters and sorts by.
+func dateField(opts SearchOptions) string {
+	if opts.DateField == "" {
+		return DateCreated
+	}
+	return opts.DateField
+}
+
+// dateQuery returns `q` restricted to pages of documents whose date field `field` is at or after
+// `after` and before `before`. A zero `after` or `before` leaves that end of the range open.
+func dateQuery(q query.Query, field string, after, before time.Time) query.Query {
+	inclusive, exclusive := true, false
+	dateQ := bleve.NewDateRangeInclusiveQuery(after, before, &inclusive, &exclusive)
+	dateQ.SetField(field)
+	return bleve.NewConjunctionQuery(q, dateQ)
+}
+
+// fileModTime returns the modification time of `rs` if it is a file, otherwise the zero time.
+func fileModTime(rs io.ReadSeeker) time.Time {
+	f, ok := rs.(*os.File)
+	if !ok {
+		return time.Time{}
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		return time.Time{}
+	}
+	return fi.ModTime()
+}
@@ -0,0 +1,53 @@
+package doclib
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseDate(t *testing.T) {
+	tests := []struct {
+		s    string
+		date time.Time
+		ok   bool
+	}{
+		{"", time.Time{}, true},
+		{"2020", time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC), true},
+		{"2020-06", time.Date(2020, 6, 1, 0, 0, 0, 0, time.UTC), true},
+		{"2020-06-30", time.Date(2020, 6, 30, 0, 0, 0, 0, time.UTC), true},
+		{"2020-06-30T12:30:00Z", time.Date(2020, 6, 30, 12, 30, 0, 0, time.UTC), true},
+		{"30/06/2020", time.Time{}, false},
+		{"2020-13", time.Time{}, false},
+	}
+	for _, test := range tests {
+		date, err := ParseDate(test.s)
+		if (err == nil) != test.ok {
+			t.Errorf("s=%q err=%v ok=%t", test.s, err, test.ok)
+			continue
+		}
+		if !date.Equal(test.date) {
+			t.Errorf("s=%q date=%s expected=%s", test.s, date, test.date)
+		}
+	}
+}
+
+func TestCheckDateOptions(t *testing.T) {
+	y2020 := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	y2021 := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		opts SearchOptions
+		ok   bool
+	}{
+		{SearchOptions{}, true},
+		{SearchOptions{After: y2020, Before: y2021, DateField: DateModified, Sort: SortNewest}, true},
+		{SearchOptions{After: y2020}, true},
+		{SearchOptions{After: y2021, Before: y2020}, false},
+		{SearchOptions{After: y2020, Before: y2020}, false},
+		{SearchOptions{DateField: "indexed"}, false},
+	}
+	for i, test := range tests {
+		if err := checkDateOptions(test.opts); (err == nil) != test.ok {
+			t.Errorf("%d: err=%v ok=%t", i, err, test.ok)
+		}
+	}
+}
@@ -0,0 +1,152 @@
+package doclib
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/blevesearch/bleve"
+	"github.com/unidoc/unidoc/common"
+)
+
+// deletePageSize is the number of bleve hits DeleteByQuery reads per search request.
+const deletePageSize = 1000
+
+// DeleteReport describes the documents that DeleteByQuery removed or, in a dry run, would remove.
+type DeleteReport struct {
+	Query    string
+	DryRun   bool      // True if no documents were removed.
+	NumPages int       // Number of indexed pages that matched Query.
+	Docs     []DocInfo // The documents with pages that matched Query in store order.
+}
+
+func (r DeleteReport) String() string {
+	verb := "Deleted"
+	if r.DryRun {
+		verb = "Would delete"
+	}
+	parts := []string{fmt.Sprintf("%s %d documents (%d matching pages) for query %q",
+		verb, len(r.Docs), r.NumPages, r.Query)}
+	for _, d := range r.Docs {
+		parts = append(parts, fmt.Sprintf("%4d: %.12s %4d pages %q",
+			d.DocIdx, d.Hash, d.NumPages, d.InPath))
+	}
+	return strings.Join(parts, "\n")
+}
+
+// pathQueryPrefix starts DeleteByQuery queries that select documents by path. Paths aren't in the
+// bleve index so these queries are matched against the positions store like DocFilter.PathPattern.
+const pathQueryPrefix = "path:"
+
+// DeleteByQuery removes all the documents in the store in `persistDir` that have a page that
+// matches query `q` from the bleve index and the positions store. Whole documents are removed, not
+// just the matching pages. `q` has the same syntax as search queries, e.g. `author:smith`, or is
+// "path:<pattern>" to select the documents whose path or an alias matches <pattern>, e.g.
+// "path:/scans/2017/". If `dryRun` is true, the documents are reported but not removed.
+func DeleteByQuery(persistDir, q string, dryRun bool) (DeleteReport, error) {
+	indexPath := filepath.Join(persistDir, "bleve")
+	index, err := OpenStoreIndex(persistDir)
+	if err != nil {
+		return DeleteReport{}, fmt.Errorf("Could not open Bleve index %q. err=%v", indexPath, err)
+	}
+	defer index.Close()
+
+	lState, err := OpenPositionsState(persistDir, false)
+	if err != nil {
+		return DeleteReport{}, fmt.Errorf("Could not open positions store %q. err=%v",
+			persistDir, err)
+	}
+	return lState.DeleteByQuery(index, q, dryRun)
+}
+
+// DeleteByQuery removes the documents in `lState` and `index` that have a page that matches query
+// `q`. See DeleteByQuery.
+func (lState *Store) DeleteByQuery(index bleve.Index, q string, dryRun bool) (
+	DeleteReport, error) {
+
+	report := DeleteReport{Query: q, DryRun: dryRun}
+	if strings.TrimSpace(q) == "" {
+		return report, fmt.Errorf("Could not delete by query. Empty query")
+	}
+
+	var docIdxs map[uint64]bool
+	var err error
+	if strings.HasPrefix(q, pathQueryPrefix) {
+		docIdxs, err = lState.pathDocs(strings.TrimPrefix(q, pathQueryPrefix), &report)
+	} else {
+		docIdxs, err = lState.queryDocs(index, q, &report)
+	}
+	if err != nil {
+		return report, err
+	}
+
+	var hashes []string
+	for docIdx := range docIdxs {
+		report.Docs = append(report.Docs, lState.docInfo(docIdx, lState.fileList[docIdx]))
+	}
+	sort.Slice(report.Docs, func(i, j int) bool {
+		return report.Docs[i].DocIdx < report.Docs[j].DocIdx
+	})
+	for _, d := range report.Docs {
+		hashes = append(hashes, d.Hash)
+	}
+	common.Log.Info("DeleteByQuery: q=%q docs=%d pages=%d dryRun=%t",
+		q, len(hashes), report.NumPages, dryRun)
+	if dryRun || len(hashes) == 0 {
+		return report, nil
+	}
+	return report, lState.RemoveDocs(index, hashes)
+}
+
+// queryDocs returns the indexes of the documents in `lState` with pages in `index` that match
+// query `q`. It adds the number of matching pages to `report`.
+func (lState *Store) queryDocs(index bleve.Index, q string, report *DeleteReport) (
+	map[uint64]bool, error) {
+
+	docIdxs := map[uint64]bool{}
+	q = lState.normalization.normalizeQuery(q)
+	for from := 0; ; from += deletePageSize {
+		search := bleve.NewSearchRequestOptions(makeQuery(q), deletePageSize, from, false)
+		results, err := index.Search(search)
+		if err != nil {
+			return nil, err
+		}
+		for _, hit := range results.Hits {
+			docIdx, _, err := decodeID(hit.ID)
+			if err != nil {
+				return nil, fmt.Errorf("Bad bleve ID %q. err=%v", hit.ID, err)
+			}
+			if docIdx >= uint64(len(lState.fileList)) {
+				common.Log.Error("DeleteByQuery: bleve ID %q is not in the positions store", hit.ID)
+				continue
+			}
+			docIdxs[docIdx] = true
+			report.NumPages++
+		}
+		if len(results.Hits) < deletePageSize {
+			break
+		}
+	}
+	return docIdxs, nil
+}
+
+// pathDocs returns the indexes of the documents in `lState` whose path or an alias matches
+// `pattern`. See DocFilter.PathPattern. It adds the number of pages in the documents to `report`.
+func (lState *Store) pathDocs(pattern string, report *DeleteReport) (
+	map[uint64]bool, error) {
+
+	pattern = strings.TrimSpace(pattern)
+	if pattern == "" {
+		return nil, fmt.Errorf("Could not delete by query. Empty path pattern")
+	}
+	filter := DocFilter{PathPattern: pattern}
+	docIdxs := map[uint64]bool{}
+	for i, fd := range lState.fileList {
+		if filter.matchPath(fd) {
+			docIdxs[uint64(i)] = true
+			report.NumPages += lState.docInfo(uint64(i), fd).NumPages
+		}
+	}
+	return docIdxs, nil
+}
@@ -0,0 +1,342 @@
+package doclib
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/unidoc/unidoc/common"
+)
+
+/*
+   Distributed indexing builds the store of a corpus that is too big for one machine as one shard
+   store per machine.
+   - Each machine runs an index worker, an IndexWorker served over HTTP, e.g. `pdfsearch worker`.
+     The worker indexes the files it is sent into its local store and serves searches of the
+     store with the pdf-search HTTP protocol. See pdf_server.go.
+   - A coordinator, IndexDistributed, shards the corpus's path list over the workers, sends each
+     worker its shard, waits for them to finish and returns a ClusterManifest of the shards.
+   - OpenCluster returns a MultiIndex that searches all the shards through their workers.
+   The workers must be able to read the files by their paths, e.g. from a shared file system or
+   from a CorpusSource, such as an S3 bucket, that all the workers are configured with.
+*/
+
+// ErrIndexing is returned when an IndexWorker is asked to search while it is indexing.
+var ErrIndexing = errors.New("the store is being indexed")
+
+// IndexWorkerStatus is the state of an index worker. It is the response to GET /index.
+type IndexWorkerStatus struct {
+	Running   bool // The worker is indexing.
+	NumFiles  int  // Number of files in the current or last run.
+	FilesDone int  // Number of files the current or last run has processed.
+	PagesDone int  // Number of pages the current or last run has indexed.
+	// The counts of the last run's files by their FileStatus.
+	NumIndexed   int
+	NumDuplicate int
+	NumFailed    int
+	NumSkipped   int
+	NumDocs      int    // Number of documents in the worker's store after the last run.
+	Err          string `json:",omitempty"` // Error that stopped the last run, if any.
+	Started      time.Time
+	Finished     time.Time
+}
+
+// indexRequest is the body of a POST /index request.
+type indexRequest struct {
+	Paths []string
+}
+
+// IndexWorker indexes the files that an IndexDistributed coordinator sends it into a store and
+// serves searches of the store. It is an http.Handler that serves
+//   POST /index    Starts indexing the paths in the JSON body {"Paths": [...]} into the store.
+//   GET  /index    -> IndexWorkerStatus
+// and the pdf-search HTTP protocol for searches. Searches are refused while the worker is indexing
+// because the store is open for writing.
+type IndexWorker struct {
+	persistDir string
+	opts       IndexOptions
+	ctx        context.Context
+	cancel     func()
+	mu         sync.Mutex
+	status     IndexWorkerStatus
+	x          *PdfIndex    // The store opened for searching. nil if it isn't open.
+	search     http.Handler // Serves searches of `x`.
+}
+
+// NewIndexWorker returns an IndexWorker that indexes into the store in `persistDir` with options
+// `opts`. The store is created if it doesn't exist and is appended to if it does. Call Close when
+// finished.
+func NewIndexWorker(persistDir string, opts IndexOptions) *IndexWorker {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &IndexWorker{persistDir: persistDir, opts: opts, ctx: ctx, cancel: cancel}
+}
+
+// Status returns the state of `w`.
+func (w *IndexWorker) Status() IndexWorkerStatus {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.status
+}
+
+// Close stops the indexing run of `w`, if any, and closes its store.
+func (w *IndexWorker) Close() error {
+	w.cancel()
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.closeStore()
+}
+
+// ServeHTTP serves the requests of `w`.
+func (w *IndexWorker) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == "/index" {
+		w.serveIndex(rw, r)
+		return
+	}
+	search, err := w.searcher()
+	if err != nil {
+		writeError(rw, err)
+		return
+	}
+	search.ServeHTTP(rw, r)
+}
+
+// serveIndex serves the /index requests of `w`.
+func (w *IndexWorker) serveIndex(rw http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(rw, w.Status())
+	case http.MethodPost:
+		var req indexRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(rw, fmt.Sprintf("Bad index request. err=%v", err), http.StatusBadRequest)
+			return
+		}
+		if err := w.start(req.Paths); err != nil {
+			http.Error(rw, err.Error(), http.StatusConflict)
+			return
+		}
+		writeJSON(rw, w.Status())
+	default:
+		http.Error(rw, "Use GET or POST", http.StatusMethodNotAllowed)
+	}
+}
+
+// searcher returns the handler for searches of the store of `w`. It returns ErrIndexing while
+// `w` is indexing.
+func (w *IndexWorker) searcher() (http.Handler, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.status.Running {
+		return nil, ErrIndexing
+	}
+	if w.search == nil {
+		x, err := OpenPdfIndex(w.persistDir)
+		if err != nil {
+			return nil, err
+		}
+		w.x, w.search = x, NewPdfServer(x, false)
+	}
+	return w.search, nil
+}
+
+// closeStore closes the store of `w` if it is open for searching. It must be called with w.mu
+// held.
+func (w *IndexWorker) closeStore() error {
+	if w.x == nil {
+		return nil
+	}
+	err := w.x.Close()
+	w.x, w.search = nil, nil
+	return err
+}
+
+// start starts indexing `pathList` into the store of `w` in the background. It returns an error if
+// `w` is already indexing.
+func (w *IndexWorker) start(pathList []string) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.status.Running {
+		return errors.New("the worker is already indexing")
+	}
+	if err := w.closeStore(); err != nil {
+		common.Log.Error("IndexWorker: Could not close %q. err=%v", w.persistDir, err)
+	}
+	w.status = IndexWorkerStatus{Running: true, NumFiles: len(pathList), Started: time.Now()}
+	go w.run(pathList)
+	return nil
+}
+
+// run indexes `pathList` into the store of `w` and records the outcome in w.status.
+func (w *IndexWorker) run(pathList []string) {
+	var report IndexReport
+	opts := w.opts
+	opts.Report = &report
+	opts.Progress = ProgressFunc(func(p Progress) {
+		w.mu.Lock()
+		defer w.mu.Unlock()
+		w.status.FilesDone = p.FilesDone
+		w.status.PagesDone = p.PagesDone
+	})
+	lState, index, _, err := IndexPdfReadersContext(w.ctx, pathList, nil, w.persistDir, false,
+		true, opts, nil)
+	numDocs := 0
+	if err == nil {
+		numDocs = lState.Len()
+		index.Close()
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.status.Running = false
+	w.status.Finished = time.Now()
+	w.status.NumIndexed = report.NumIndexed
+	w.status.NumDuplicate = report.NumDuplicate
+	w.status.NumFailed = report.NumFailed
+	w.status.NumSkipped = report.NumSkipped
+	w.status.NumDocs = numDocs
+	if err != nil {
+		common.Log.Error("IndexWorker: Could not index into %q. err=%v", w.persistDir, err)
+		w.status.Err = err.Error()
+	}
+}
+
+// ClusterShard is a shard store of a corpus indexed by an index worker.
+type ClusterShard struct {
+	URL      string // Base URL of the worker, e.g. "http://host:8090".
+	NumFiles int    // Number of the corpus's files that were sent to the worker.
+	Status   IndexWorkerStatus
+}
+
+// ClusterManifest describes a corpus that was indexed by index workers. See IndexDistributed.
+type ClusterManifest struct {
+	Shards []ClusterShard
+	Built  time.Time
+}
+
+// clusterPollPeriod is how often IndexDistributed checks whether the workers have finished.
+const clusterPollPeriod = 5 * time.Second
+
+// IndexDistributed indexes the files in `pathList` with the index workers at `workerURLs`. Each
+// file is sent to one worker. See ShardPaths. It waits for all the workers to finish and returns
+// a ClusterManifest of their stores. It returns an error if any of the workers failed, along with
+// the manifest. If `ctx` is done, it stops waiting. The workers carry on indexing.
+func IndexDistributed(ctx context.Context, pathList []string, workerURLs []string) (
+	ClusterManifest, error) {
+
+	if len(workerURLs) == 0 {
+		return ClusterManifest{}, errors.New("no index workers")
+	}
+	shards := ShardPaths(pathList, len(workerURLs))
+	m := ClusterManifest{Shards: make([]ClusterShard, len(workerURLs))}
+	errs := make([]error, len(workerURLs))
+	var wg sync.WaitGroup
+	for i, u := range workerURLs {
+		m.Shards[i] = ClusterShard{URL: u, NumFiles: len(shards[i])}
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			m.Shards[i].Status, errs[i] = runShard(ctx, NewRemoteIndex(m.Shards[i].URL),
+				shards[i])
+		}(i)
+	}
+	wg.Wait()
+	m.Built = time.Now()
+
+	numFailed := 0
+	var firstErr error
+	for i, err := range errs {
+		if err != nil {
+			common.Log.Error("IndexDistributed: Worker %q failed. err=%v", workerURLs[i], err)
+			numFailed++
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+	if numFailed > 0 {
+		return m, fmt.Errorf("%d of %d index workers failed. err=%v", numFailed,
+			len(workerURLs), firstErr)
+	}
+	return m, nil
+}
+
+// runShard sends `pathList` to the index worker `worker` and waits for it to index them. It
+// returns the worker's final status.
+func runShard(ctx context.Context, worker *RemoteIndex, pathList []string) (IndexWorkerStatus,
+	error) {
+
+	status, err := worker.StartIndexing(pathList)
+	if err != nil {
+		return status, err
+	}
+	ticker := time.NewTicker(clusterPollPeriod)
+	defer ticker.Stop()
+	for status.Running {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return status, ctx.Err()
+		}
+		if status, err = worker.IndexStatus(); err != nil {
+			return status, err
+		}
+	}
+	if status.Err != "" {
+		return status, errors.New(status.Err)
+	}
+	return status, nil
+}
+
+// ShardPaths splits `pathList` into `n` shards by the hashes of the paths, so that a file is
+// always sent to the same one of `n` workers and isn't indexed twice when a corpus is indexed
+// again.
+func ShardPaths(pathList []string, n int) [][]string {
+	shards := make([][]string, n)
+	for _, inPath := range pathList {
+		h := fnv.New32a()
+		h.Write([]byte(inPath))
+		i := int(h.Sum32() % uint32(n))
+		shards[i] = append(shards[i], inPath)
+	}
+	return shards
+}
+
+// SaveClusterManifest writes `m` to the JSON file `path`.
+func SaveClusterManifest(path string, m ClusterManifest) error {
+	b, err := json.MarshalIndent(m, "", "\t")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, b, 0666)
+}
+
+// LoadClusterManifest reads the ClusterManifest in the JSON file `path`.
+func LoadClusterManifest(path string) (ClusterManifest, error) {
+	var m ClusterManifest
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return m, fmt.Errorf("Could not read cluster manifest %q. err=%v", path, err)
+	}
+	if err := json.Unmarshal(b, &m); err != nil {
+		return m, fmt.Errorf("Could not parse cluster manifest %q. err=%v", path, err)
+	}
+	return m, nil
+}
+
+// OpenCluster returns a MultiIndex that searches the shard stores in `m` through their index
+// workers. Matches are named by the workers' URLs.
+func OpenCluster(m ClusterManifest) (*MultiIndex, error) {
+	var searchers []Searcher
+	var names []string
+	for _, shard := range m.Shards {
+		searchers = append(searchers, NewRemoteIndex(shard.URL))
+		names = append(names, shard.URL)
+	}
+	return NewMultiIndex(searchers, names)
+}
@@ -0,0 +1,76 @@
+package doclib
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestShardPaths(t *testing.T) {
+	var pathList []string
+	for i := 0; i < 1000; i++ {
+		pathList = append(pathList, fmt.Sprintf("corpus/%03d.pdf", i))
+	}
+	shards := ShardPaths(pathList, 4)
+	if len(shards) != 4 {
+		t.Fatalf("%d shards", len(shards))
+	}
+	seen := map[string]int{}
+	for i, shard := range shards {
+		if len(shard) < 150 {
+			t.Errorf("Shard %d is unbalanced. %d of %d files", i, len(shard), len(pathList))
+		}
+		for _, inPath := range shard {
+			seen[inPath]++
+		}
+	}
+	if len(seen) != len(pathList) {
+		t.Errorf("%d of %d files are in shards", len(seen), len(pathList))
+	}
+	for inPath, n := range seen {
+		if n != 1 {
+			t.Errorf("%q is in %d shards", inPath, n)
+		}
+	}
+
+	// Files go to the same shard when a corpus is indexed again with more files.
+	again := ShardPaths(append(pathList, "corpus/new.pdf"), 4)
+	for i := range shards {
+		if !reflect.DeepEqual(shards[i], again[i][:len(shards[i])]) {
+			t.Errorf("Shard %d changed.", i)
+		}
+	}
+}
+
+func TestClusterManifest(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pdf-search-cluster")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	m := ClusterManifest{
+		Shards: []ClusterShard{
+			{URL: "http://host1:8090", NumFiles: 10,
+				Status: IndexWorkerStatus{NumFiles: 10, NumIndexed: 9, NumFailed: 1, NumDocs: 9}},
+			{URL: "http://host2:8090", NumFiles: 12,
+				Status: IndexWorkerStatus{NumFiles: 12, NumIndexed: 12, NumDocs: 12}},
+		},
+		Built: time.Date(2019, 6, 1, 12, 0, 0, 0, time.UTC),
+	}
+	path := filepath.Join(dir, "cluster.json")
+	if err := SaveClusterManifest(path, m); err != nil {
+		t.Fatal(err)
+	}
+	got, err := LoadClusterManifest(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, m) {
+		t.Errorf("got %+v expected %+v", got, m)
+	}
+}
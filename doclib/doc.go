@@ -0,0 +1,22 @@
+// Package doclib indexes PDF files for full text search and finds the positions of the matches on
+// the PDF pages so they can be highlighted.
+//
+// doclib has no stable release yet. The API that is intended to become stable in v1 is
+//   - Indexing: IndexPdfFiles, IndexPdfFilesOpts, IndexPdfReaders, IndexPdfReadersOpts,
+//     IndexOptions, IndexReport and FileReport.
+//   - Searching: PdfIndex, OpenPdfIndex, Searcher, SearchOptions, PdfMatchSet, PdfMatch,
+//     MultiIndex, RemoteIndex and NewPdfServer.
+//   - Stores: Store, Doc, OpenPositionsState, DocInfo, DocFilter, StoreInfo, MoveStore and
+//     DeleteByQuery.
+//   - Markup: ExtractList, MarkupStyle, HighlightPdf and the page coordinate types PageBox and
+//     ViewRect.
+//   - Logging and progress: Logger, SetLogger, ProgressReporter and Progress.
+//
+// Until v1, which also needs a go.mod for the module and the storage internals moved under
+// internal/, the names above are only renamed with deprecated aliases and everything else that is
+// exported may change. In particular the flatbuffers types in package serial are a storage
+// format, not an API.
+//
+// Old names are kept as deprecated aliases. PositionsState is now Store and DocPositions is now
+// Doc.
+package doclib
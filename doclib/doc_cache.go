@@ -0,0 +1,211 @@
+package doclib
+
+import (
+	"container/list"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"sync"
+
+	"github.com/unidoc/unidoc/common"
+)
+
+// maxOpenDocs is the default number of documents whose positions data files a persistent
+// Store keeps mapped into memory. See Store.SetOpenDocs().
+const maxOpenDocs = 64
+
+// mappedDoc is the positions data of a document in a persistent store, mapped into memory, and
+// the byteSpans of its pages. mappedDocs are shared by the Doc that read the document so
+// they must not be modified.
+type mappedDoc struct {
+	hash  string     // Hash of the document's PDF file.
+	spans []byteSpan // Locations of the pages' positions in `data`.
+	data  []byte     // Contents of the document's positions data file.
+	refs  int        // Number of open Doc that are reading `data`.
+	// evicted is true if the document has been removed from the cache. Its data is unmapped when
+	// the last Doc reading it is closed.
+	evicted bool
+}
+
+// docCache is a least recently used cache of the mappedDocs of a Store. It lets searches
+// read the positions of many pages without opening, seeking and reading a positions file for
+// each page. It is safe for concurrent use.
+// Documents are keyed by file hash rather than document index because document indexes change
+// when documents are removed from the store.
+type docCache struct {
+	mu   sync.Mutex
+	size int                      // Maximum number of documents in the cache.
+	lru  *list.List               // *mappedDocs. The front is the most recently used.
+	docs map[string]*list.Element // {hash: element of `lru`}
+}
+
+// newDocCache returns a docCache that holds up to `size` documents.
+func newDocCache(size int) *docCache {
+	return &docCache{size: size, lru: list.New(), docs: map[string]*list.Element{}}
+}
+
+// get returns the mappedDoc for the document with hash `hash`. It calls `load` to read the document
+// if it is not in `c`. The caller must call release on the mappedDoc when it has finished with it.
+func (c *docCache) get(hash string, load func() (*mappedDoc, error)) (*mappedDoc, error) {
+	c.mu.Lock()
+	if e, ok := c.docs[hash]; ok {
+		c.lru.MoveToFront(e)
+		md := e.Value.(*mappedDoc)
+		md.refs++
+		c.mu.Unlock()
+		return md, nil
+	}
+	c.mu.Unlock()
+
+	// Load without holding the lock so that documents can be loaded concurrently.
+	md, err := load()
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.size <= 0 {
+		// Caching is disabled. `md` is unmapped when it is released.
+		md.refs++
+		md.evicted = true
+		return md, nil
+	}
+	if e, ok := c.docs[hash]; ok {
+		// Another goroutine loaded the document while we were loading it.
+		unmapDoc(md)
+		c.lru.MoveToFront(e)
+		md = e.Value.(*mappedDoc)
+		md.refs++
+		return md, nil
+	}
+	md.refs++
+	c.docs[hash] = c.lru.PushFront(md)
+	for c.lru.Len() > c.size {
+		c.remove(c.lru.Back())
+	}
+	return md, nil
+}
+
+// release records that a Doc has finished reading `md`.
+func (c *docCache) release(md *mappedDoc) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	md.refs--
+	if md.evicted && md.refs == 0 {
+		unmapDoc(md)
+	}
+}
+
+// evict removes the document with hash `hash` from `c`. It is called when the document's files
+// are changed or deleted.
+func (c *docCache) evict(hash string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.docs[hash]; ok {
+		c.remove(e)
+	}
+}
+
+// resize sets the maximum number of documents in `c` to `size`, removing the least recently used
+// documents if there are more than that.
+func (c *docCache) resize(size int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.size = size
+	for c.lru.Len() > c.size {
+		c.remove(c.lru.Back())
+	}
+}
+
+// close removes all the documents from `c`.
+func (c *docCache) close() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for c.lru.Len() > 0 {
+		c.remove(c.lru.Back())
+	}
+}
+
+// remove removes `e` from `c`. The caller must hold c.mu.
+func (c *docCache) remove(e *list.Element) {
+	md := c.lru.Remove(e).(*mappedDoc)
+	delete(c.docs, md.hash)
+	md.evicted = true
+	if md.refs == 0 {
+		unmapDoc(md)
+	}
+}
+
+// loadMappedDoc returns the mappedDoc for the document with hash `hash` whose files are described
+// by `persist`.
+func loadMappedDoc(hash string, persist *docPersist) (*mappedDoc, error) {
+	b, err := ioutil.ReadFile(persist.spansPath)
+	if err != nil {
+		return nil, err
+	}
+	var spans []byteSpan
+	if err := json.Unmarshal(b, &spans); err != nil {
+		return nil, err
+	}
+	f, err := os.Open(persist.dataPath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	md := &mappedDoc{hash: hash, spans: spans}
+	if fi.Size() == 0 {
+		return md, nil // Empty files can't be mapped.
+	}
+	md.data, err = mmapFile(f, int(fi.Size()))
+	if err != nil {
+		return nil, err
+	}
+	return md, nil
+}
+
+// unmapDoc releases the memory mapping of `md`.
+func unmapDoc(md *mappedDoc) {
+	if md.data == nil {
+		return
+	}
+	if err := munmapFile(md.data); err != nil {
+		common.Log.Error("unmapDoc: Could not unmap %s. err=%v", md.hash, err)
+	}
+	md.data = nil
+}
+
+// evictDoc removes the document with hash `hash` from the cache of mapped documents of `lState`.
+func (lState *Store) evictDoc(hash string) {
+	if lState.docCache != nil {
+		lState.docCache.evict(hash)
+	}
+}
+
+// SetOpenDocs sets the maximum number of documents whose positions `lState` keeps open to `n`.
+// Documents are opened on every read if `n` <= 0. It has no effect on in-memory stores.
+func (lState *Store) SetOpenDocs(n int) {
+	if lState.docCache != nil {
+		lState.docCache.resize(n)
+	}
+}
+
+// Close releases the documents that `lState` keeps open for reading. It doesn't save `lState`.
+// Writers must call Flush(). `lState` can still be used after Close. Documents are reopened as
+// they are read.
+func (lState *Store) Close() error {
+	lState.closeDocs()
+	return nil
+}
+
+// closeDocs empties the cache of mapped documents of `lState`. Documents that are being read are
+// unmapped when the Doc reading them are closed.
+func (lState *Store) closeDocs() {
+	if lState.docCache != nil {
+		lState.docCache.close()
+	}
+}
@@ -0,0 +1,61 @@
+package doclib
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+)
+
+// DocLabels are the user-supplied descriptions of a document that search UIs show instead of
+// its path. They are useful for corpora that were downloaded from the web, where the path of
+// a document's local copy means nothing to users.
+type DocLabels struct {
+	URI   string   `json:",omitempty"` // Canonical URI of the document, e.g. its web URL.
+	Title string   `json:",omitempty"` // Display title of the document.
+	Tags  []string `json:",omitempty"` // User-supplied tags, e.g. collection names.
+}
+
+// LabelTable is a table of DocLabels for documents that are being indexed. It is
+// {document path: labels}. Documents that are not in the table have no labels.
+type LabelTable map[string]DocLabels
+
+// LoadLabelTable returns the LabelTable in JSON file `filename`. The file is a JSON object of the
+// form {"<path>": {"uri": "<uri>", "title": "<title>", "tags": ["<tag>", ...]}, ...}.
+func LoadLabelTable(filename string) (LabelTable, error) {
+	b, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	var labels LabelTable
+	if err := json.Unmarshal(b, &labels); err != nil {
+		return nil, fmt.Errorf("Could not parse label table %q. err=%v", filename, err)
+	}
+	return labels, nil
+}
+
+// SetDocLabels replaces the labels of document `docIdx` in `lState` with `labels` and saves them.
+func (lState *Store) SetDocLabels(docIdx uint64, labels DocLabels) error {
+	if int(docIdx) >= len(lState.fileList) {
+		return ErrNoDoc
+	}
+	lState.fileList[docIdx].DocLabels = labels
+	if lState.isMem() {
+		return nil
+	}
+	return saveFileList(lState.fileListPath(), lState.fileList)
+}
+
+// labels returns the labels of `lDoc` for showing in search results. The title is the PDF's
+// metadata title if no title was supplied.
+func (lDoc *Doc) labels() DocLabels {
+	fileList := lDoc.lState.fileList
+	if int(lDoc.docIdx) >= len(fileList) {
+		return DocLabels{}
+	}
+	fd := fileList[lDoc.docIdx]
+	labels := fd.DocLabels
+	if labels.Title == "" {
+		labels.Title = fd.Metadata.Title
+	}
+	return labels
+}
@@ -0,0 +1,36 @@
+package doclib
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestFileDescLabelsJSON(t *testing.T) {
+	fd := FileDesc{
+		InPath:    "corpus/7f3a.pdf",
+		DocLabels: DocLabels{URI: "https://example.com/spec.pdf", Title: "Spec", Tags: []string{"a"}},
+	}
+	b, err := json.Marshal(fd)
+	if err != nil {
+		t.Fatalf("Marshal failed. err=%v", err)
+	}
+	var got FileDesc
+	if err := json.Unmarshal(b, &got); err != nil {
+		t.Fatalf("Unmarshal failed. err=%v", err)
+	}
+	if !reflect.DeepEqual(got.DocLabels, fd.DocLabels) {
+		t.Errorf("labels=%+v expected=%+v", got.DocLabels, fd.DocLabels)
+	}
+
+	// Label files use lower case keys.
+	var labels LabelTable
+	data := `{"a.pdf": {"uri": "https://example.com/a.pdf", "title": "A", "tags": ["x", "y"]}}`
+	if err := json.Unmarshal([]byte(data), &labels); err != nil {
+		t.Fatalf("Unmarshal failed. err=%v", err)
+	}
+	expected := DocLabels{URI: "https://example.com/a.pdf", Title: "A", Tags: []string{"x", "y"}}
+	if !reflect.DeepEqual(labels["a.pdf"], expected) {
+		t.Errorf("labels=%+v expected=%+v", labels["a.pdf"], expected)
+	}
+}
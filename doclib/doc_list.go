@@ -0,0 +1,179 @@
+package doclib
+
+import (
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/unidoc/unidoc/common"
+)
+
+// DocStatus is the state of a document in a store.
+type DocStatus string
+
+const (
+	DocOK          DocStatus = "ok"          // The document has indexed pages.
+	DocEmpty       DocStatus = "empty"       // No text was extracted from the document.
+	DocQuarantined DocStatus = "quarantined" // The document's positions files can't be read.
+)
+
+// DocInfo describes a document in a store.
+type DocInfo struct {
+	DocIdx   uint64    // Index of document in the store.
+	Hash     string    // SHA-256 hash of file contents.
+	InPath   string    // Path the document was indexed from.
+	Aliases  []string  // Other paths of files with the same contents.
+	NumPages int       // Number of pages in the positions store.
+	SizeMB   float64   // Size of PDF file.
+	Indexed  time.Time // When the document was added to the store. Zero for older stores.
+	Status   DocStatus
+	// Extractors are the extractors that produced the document's text. Empty for older stores.
+	Extractors []Extractor
+	// TextQuality is the mean TextQuality of the document's pages. It is 0 for documents that
+	// were indexed before text quality was scored.
+	TextQuality float64
+	// LowQualityPages is the number of pages with a TextQuality below LowTextQuality.
+	LowQualityPages int
+	// Cold is true if the document's positions data is in cold storage. See FreezeDocs.
+	Cold bool `json:",omitempty"`
+	// DocLabels are the document's URI, display title and tags. See IndexOptions.Labels.
+	DocLabels
+}
+
+// DocFilter selects the documents returned by ListDocs.
+type DocFilter struct {
+	// PathPattern, if not empty, is a filepath.Match pattern or a substring that the document's
+	// path or one of its aliases must match.
+	PathPattern string
+	Status      DocStatus // If not empty, only documents with this status are returned.
+	MinPages    int       // Only documents with at least this many pages are returned.
+	Offset      int       // Number of matching documents to skip.
+	Limit       int       // Maximum number of documents to return. No limit if <= 0.
+	// Extractor, if not empty, is an extractor name such as "unidoc" or name/version such as
+	// "unidoc/3.0.0". Only documents with text from a matching extractor are returned. Use
+	// "unknown" to find documents that were indexed before extractors were recorded.
+	Extractor string
+}
+
+// ListDocs returns the documents in the store in `persistDir` that match `filter`, and the total
+// number of documents that match `filter` before `filter`.Offset and `filter`.Limit are applied.
+func ListDocs(persistDir string, filter DocFilter) ([]DocInfo, int, error) {
+	lState, err := OpenPositionsState(persistDir, false)
+	if err != nil {
+		return nil, 0, err
+	}
+	return lState.ListDocs(filter)
+}
+
+// ListDocs returns the documents in `lState` that match `filter`, and the total number of
+// documents that match `filter` before `filter`.Offset and `filter`.Limit are applied.
+func (lState *Store) ListDocs(filter DocFilter) ([]DocInfo, int, error) {
+	var docs []DocInfo
+	total := 0
+	for i, fd := range lState.fileList {
+		docIdx := uint64(i)
+		if !filter.matchPath(fd) || !filter.matchExtractor(fd) {
+			continue
+		}
+		info := lState.docInfo(docIdx, fd)
+		if filter.Status != "" && info.Status != filter.Status {
+			continue
+		}
+		if info.NumPages < filter.MinPages {
+			continue
+		}
+		total++
+		if total <= filter.Offset {
+			continue
+		}
+		if filter.Limit > 0 && len(docs) >= filter.Limit {
+			continue
+		}
+		docs = append(docs, info)
+	}
+	return docs, total, nil
+}
+
+// docInfo returns the DocInfo for the document `fd` with index `docIdx` in `lState`.
+func (lState *Store) docInfo(docIdx uint64, fd FileDesc) DocInfo {
+	info := DocInfo{
+		DocIdx:     docIdx,
+		Hash:       fd.Hash,
+		InPath:     fd.InPath,
+		Aliases:    fd.Aliases,
+		SizeMB:     fd.SizeMB,
+		Indexed:    fd.Indexed,
+		Status:     DocOK,
+		Extractors: fd.Extractors,
+		Cold:       fd.Cold,
+		DocLabels:  fd.DocLabels,
+	}
+	var lDoc *Doc
+	var err error
+	if fd.Cold {
+		// Don't fetch a frozen document's positions data from cold storage just to describe it.
+		lDoc, err = lState.openSpans(docIdx)
+	} else {
+		lDoc, err = lState.OpenPositionsDoc(docIdx)
+		if err == nil && lDoc != nil {
+			defer lDoc.Close()
+		}
+	}
+	if err != nil || lDoc == nil {
+		common.Log.Debug("docInfo: Could not open %q. err=%v", fd.InPath, err)
+		info.Status = DocQuarantined
+		return info
+	}
+	info.NumPages = lDoc.Len()
+	if info.NumPages == 0 {
+		info.Status = DocEmpty
+	}
+	numScored := 0
+	for pageIdx := uint32(0); pageIdx < uint32(info.NumPages); pageIdx++ {
+		quality, ok := lDoc.PageQuality(pageIdx)
+		if !ok {
+			continue
+		}
+		numScored++
+		info.TextQuality += quality
+		if quality < LowTextQuality {
+			info.LowQualityPages++
+		}
+	}
+	if numScored > 0 {
+		info.TextQuality /= float64(numScored)
+	}
+	return info
+}
+
+// matchPath returns true if the path or one of the aliases of `fd` matches f.PathPattern.
+func (f DocFilter) matchPath(fd FileDesc) bool {
+	if f.PathPattern == "" {
+		return true
+	}
+	for _, path := range append([]string{fd.InPath}, fd.Aliases...) {
+		if strings.Contains(path, f.PathPattern) {
+			return true
+		}
+		if ok, _ := filepath.Match(f.PathPattern, path); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// matchExtractor returns true if one of the extractors of `fd` matches f.Extractor.
+func (f DocFilter) matchExtractor(fd FileDesc) bool {
+	if f.Extractor == "" {
+		return true
+	}
+	if len(fd.Extractors) == 0 {
+		return f.Extractor == "unknown"
+	}
+	for _, e := range fd.Extractors {
+		if e.Matches(f.Extractor) {
+			return true
+		}
+	}
+	return false
+}
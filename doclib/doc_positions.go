@@ -2,7 +2,6 @@ package doclib
 
 import (
 	"encoding/json"
-	"errors"
 	"fmt"
 	"hash/crc32"
 	"io"
@@ -12,15 +11,14 @@ import (
 	"sort"
 	"strings"
 
-	flatbuffers "github.com/google/flatbuffers/go"
 	"github.com/peterwilliams97/pdf-search/serial"
 	"github.com/unidoc/unidoc/common"
 	"github.com/unidoc/unidoc/pdf/extractor"
 )
 
-// DocPositions tracks the data that is used to index a PDF file.
-type DocPositions struct {
-	lState  *PositionsState                    // State of whole store.
+// Doc tracks the data that is used to index a PDF file. Docs are opened and created by a Store.
+type Doc struct {
+	lState  *Store                             // State of whole store.
 	inPath  string                             // Path of input PDF file.
 	docIdx  uint64                             // Index into lState.fileList.
 	pageDpl map[uint32]serial.DocPageLocations // !@#$ Debugging
@@ -28,6 +26,11 @@ type DocPositions struct {
 	*docData
 }
 
+// DocPositions is the old name of Doc.
+//
+// Deprecated: Use Doc. DocPositions will be removed in v1.
+type DocPositions = Doc
+
 // docPersist tracks the info for indexing a PDF file on disk.
 type docPersist struct {
 	dataFile    *os.File   // Positions are stored in this file.
@@ -36,6 +39,13 @@ type docPersist struct {
 	spansPath   string     // Path where `spans` is saved.
 	textDir     string     // !@#$ Debugging
 	pageDplPath string
+	readOnly    bool // Opened for reading by openDoc(). Nothing needs to be saved on Close().
+	// mapped is the memory mapped positions data that a read only document reads instead of
+	// `dataFile` if its Store has a docCache.
+	mapped *mappedDoc
+	// appending is true if the document was opened by appendPositionsDoc(). The debug file at
+	// `pageDplPath` only has the pages added since then so it isn't saved.
+	appending bool
 }
 
 // docData is the data for indexing a PDF file in memory.
@@ -43,6 +53,13 @@ type docData struct {
 	// loc       serial.DocPageLocations
 	pageNums  []uint32
 	pageTexts []string
+	pageBoxes []PageBox // pageBoxes[i] is the PageBox of page pageNums[i]. It may be short.
+	// pageQualities[i] is the TextQuality of page pageNums[i]. It may be short.
+	pageQualities []float32
+	// pageAnnots[i] is the annotation contents of page pageNums[i]. It may be short.
+	pageAnnots [][]string
+	// pageTables[i] is the tables on page pageNums[i]. It may be short.
+	pageTables [][]Table
 }
 
 // byteSpan is the location of the bytes of a DocPageLocations in a data file.
@@ -53,9 +70,23 @@ type byteSpan struct {
 	Size    uint32 // Size of the DocPageLocations in the data file.
 	Check   uint32 // CRC checksum for the DocPageLocations data.
 	PageNum uint32 // PDF page number.
+	// TextHash is the content address of the page text. See Store.textPath(). It is empty
+	// in stores that were created before page texts were content-addressed. Their page texts are
+	// in docPersist.textDir.
+	TextHash string `json:",omitempty"`
+	// Box is the page's crop box and rotation. It is nil in stores that were created before page
+	// boxes were recorded.
+	Box *PageBox `json:",omitempty"`
+	// Quality is the TextQuality of the page's text. It is nil in stores that were created before
+	// text quality was scored.
+	Quality *float32 `json:",omitempty"`
+	// Annots is the contents of the page's annotations. See PageAnnotations.
+	Annots []string `json:",omitempty"`
+	// Tables is the tables on the page. See PageTables.
+	Tables []Table `json:",omitempty"`
 }
 
-func (d DocPositions) String() string {
+func (d Doc) String() string {
 	parts := []string{fmt.Sprintf("%q docIdx=%d mem=%t",
 		filepath.Base(d.inPath), d.docIdx, d.docData != nil)}
 	if d.docPersist != nil {
@@ -67,11 +98,15 @@ func (d DocPositions) String() string {
 	if (d.docPersist != nil) == (d.docData != nil) {
 		parts = append(parts, "<BAD>")
 	}
-	return fmt.Sprintf("DocPositions{%s}", strings.Join(parts, "\n"))
+	return fmt.Sprintf("Doc{%s}", strings.Join(parts, "\n"))
 }
 
-func (d DocPositions) Len() int {
-	return len(d.pageNums)
+// Len returns the number of pages in `d`.
+func (d Doc) Len() int {
+	if d.isMem() {
+		return len(d.pageNums)
+	}
+	return len(d.spans)
 }
 
 func (d docPersist) String() string {
@@ -92,22 +127,36 @@ func (d docData) String() string {
 	return fmt.Sprintf("docData{pageNums=%d pageTexts=%d%s}", np, nt, bad)
 }
 
-func (d DocPositions) isMem() bool {
+func (d Doc) isMem() bool {
 	persist := d.docPersist != nil
 	mem := d.docData != nil
 	if persist == mem {
-		panic(fmt.Errorf("d=%s should not happen\n%#v", d, d))
+		common.Log.Error("isMem: d=%s should not happen\n%#v", d, d)
 	}
 	return mem
 }
 
 // openDoc() opens `lDoc` for reading. In a persistent `lDoc`, necessary files are opened.
-func (lDoc *DocPositions) openDoc() error {
+func (lDoc *Doc) openDoc() error {
 	if lDoc.isMem() {
 		return nil
 	}
 
 	// Persistent case.
+	if cache := lDoc.lState.docCache; cache != nil {
+		hash := lDoc.lState.indexHash[lDoc.docIdx]
+		md, err := cache.get(hash, func() (*mappedDoc, error) {
+			return loadMappedDoc(hash, lDoc.docPersist)
+		})
+		if err != nil {
+			return err
+		}
+		lDoc.mapped = md
+		lDoc.spans = md.spans
+		lDoc.readOnly = true
+		return nil
+	}
+
 	f, err := os.Open(lDoc.dataPath)
 	if err != nil {
 		return err
@@ -123,11 +172,12 @@ func (lDoc *DocPositions) openDoc() error {
 		return err
 	}
 	lDoc.spans = spans
+	lDoc.readOnly = true
 
 	return nil
 }
 
-func (lDoc *DocPositions) Save() error {
+func (lDoc *Doc) Save() error {
 	if lDoc.isMem() {
 		return nil
 	}
@@ -138,13 +188,40 @@ func (lDoc *DocPositions) Save() error {
 	return ioutil.WriteFile(lDoc.spansPath, b, 0666)
 }
 
-func (lDoc *DocPositions) Close() error {
+// commit closes `lDoc`, which was opened for writing, and syncs its positions files to disk. It
+// is the positions half of the per-document commit barrier: a document's pages are only added to
+// the bleve index after its positions have been committed. See indexJournal.
+func (lDoc *Doc) commit() error {
+	if lDoc.isMem() || lDoc.readOnly || lDoc.mapped != nil {
+		return lDoc.Close()
+	}
+	if err := lDoc.dataFile.Sync(); err != nil {
+		lDoc.dataFile.Close()
+		return err
+	}
+	if err := lDoc.Close(); err != nil {
+		return err
+	}
+	return syncFile(lDoc.spansPath)
+}
+
+func (lDoc *Doc) Close() error {
 	if lDoc.isMem() {
 		return nil
 	}
 	// Persistent case.
-	if err := lDoc.saveJsonDebug(); err != nil {
-		return err
+	if lDoc.mapped != nil {
+		lDoc.lState.docCache.release(lDoc.mapped)
+		lDoc.mapped = nil
+		return nil
+	}
+	if lDoc.readOnly {
+		return lDoc.dataFile.Close()
+	}
+	if !lDoc.appending {
+		if err := lDoc.saveJsonDebug(); err != nil {
+			return err
+		}
 	}
 	if err := lDoc.Save(); err != nil {
 		return err
@@ -152,7 +229,7 @@ func (lDoc *DocPositions) Close() error {
 	return lDoc.dataFile.Close()
 }
 
-func (lDoc *DocPositions) saveJsonDebug() error {
+func (lDoc *Doc) saveJsonDebug() error {
 	common.Log.Debug("saveJsonDebug: pageDpl=%d pageDplPath=%q",
 		len(lDoc.pageDpl), lDoc.pageDplPath)
 	var pageNums []uint32
@@ -178,9 +255,9 @@ func (lDoc *DocPositions) saveJsonDebug() error {
 
 // AddDocPage adds a page (with page number `pageNum` and contents `dpl`) to `lDoc`.
 // !@#$ Remove `text` param.
-func (lDoc *DocPositions) AddDocPage(pageNum uint32, dpl serial.DocPageLocations, text string) (uint32, error) {
+func (lDoc *Doc) AddDocPage(pageNum uint32, dpl serial.DocPageLocations, text string) (uint32, error) {
 	if pageNum == 0 {
-		panic("pageNum = 0 should never happen")
+		return 0, fmt.Errorf("AddDocPage: Bad page number 0 in %q", lDoc.inPath)
 	}
 	lDoc.pageDpl[pageNum] = dpl // !@#$
 
@@ -192,10 +269,11 @@ func (lDoc *DocPositions) AddDocPage(pageNum uint32, dpl serial.DocPageLocations
 	return lDoc.addDocPagePersist(pageNum, dpl, text)
 }
 
-func (lDoc *DocPositions) addDocPagePersist(pageNum uint32, dpl serial.DocPageLocations,
+func (lDoc *Doc) addDocPagePersist(pageNum uint32, dpl serial.DocPageLocations,
 	text string) (uint32, error) {
 
-	b := flatbuffers.NewBuilder(0)
+	b := getBuilder()
+	defer putBuilder(b)
 	buf := serial.MakeDocPageLocations(b, dpl)
 	check := crc32.ChecksumIEEE(buf) // uint32
 	offset, err := lDoc.dataFile.Seek(0, io.SeekCurrent)
@@ -214,36 +292,121 @@ func (lDoc *DocPositions) addDocPagePersist(pageNum uint32, dpl serial.DocPageLo
 		return 0, err
 	}
 
+	span.TextHash, err = lDoc.lState.addPageText(text)
+	if err != nil {
+		return 0, err
+	}
 	lDoc.spans = append(lDoc.spans, span)
 	pageIdx := uint32(len(lDoc.spans) - 1)
+	return pageIdx, nil
+}
 
-	filename := lDoc.GetTextPath(pageIdx)
-	err = ioutil.WriteFile(filename, []byte(text), 0644)
-	if err != nil {
-		return 0, err
+// setPageBox records that `box` is the PageBox of the page with index `pageIdx` in `lDoc`. It is
+// called on documents that are being written, after AddDocPage.
+func (lDoc *Doc) setPageBox(pageIdx uint32, box PageBox) {
+	if lDoc.isMem() {
+		for uint32(len(lDoc.pageBoxes)) <= pageIdx {
+			lDoc.pageBoxes = append(lDoc.pageBoxes, PageBox{})
+		}
+		lDoc.pageBoxes[pageIdx] = box
+		return
+	}
+	lDoc.spans[pageIdx].Box = &box
+}
+
+// pageBox returns the PageBox of the page with index `pageIdx` in `lDoc` and false if it wasn't
+// recorded.
+func (lDoc *Doc) pageBox(pageIdx uint32) (PageBox, bool) {
+	var box PageBox
+	if lDoc.isMem() {
+		if pageIdx < uint32(len(lDoc.pageBoxes)) {
+			box = lDoc.pageBoxes[pageIdx]
+		}
+	} else if pageIdx < uint32(len(lDoc.spans)) && lDoc.spans[pageIdx].Box != nil {
+		box = *lDoc.spans[pageIdx].Box
+	}
+	return box, !box.IsZero()
+}
+
+// setPageQuality records that `quality` is the TextQuality of the page with index `pageIdx` in
+// `lDoc`. It is called on documents that are being written, after AddDocPage.
+func (lDoc *Doc) setPageQuality(pageIdx uint32, quality float64) {
+	q := float32(quality)
+	if lDoc.isMem() {
+		for uint32(len(lDoc.pageQualities)) <= pageIdx {
+			lDoc.pageQualities = append(lDoc.pageQualities, 0)
+		}
+		lDoc.pageQualities[pageIdx] = q
+		return
+	}
+	lDoc.spans[pageIdx].Quality = &q
+}
+
+// PageQuality returns the TextQuality of the page with index `pageIdx` in `lDoc` and false if it
+// wasn't recorded.
+func (lDoc *Doc) PageQuality(pageIdx uint32) (float64, bool) {
+	if lDoc.isMem() {
+		if pageIdx < uint32(len(lDoc.pageQualities)) {
+			return float64(lDoc.pageQualities[pageIdx]), true
+		}
+	} else if pageIdx < uint32(len(lDoc.spans)) && lDoc.spans[pageIdx].Quality != nil {
+		return float64(*lDoc.spans[pageIdx].Quality), true
 	}
-	return pageIdx, err
+	return 0.0, false
 }
 
-func (lDoc *DocPositions) ReadPageText(pageIdx uint32) (string, error) {
+func (lDoc *Doc) ReadPageText(pageIdx uint32) (string, error) {
 	if lDoc.isMem() {
 		return lDoc.pageTexts[pageIdx], nil
 	}
 	return lDoc.readPersistedPageText(pageIdx)
 }
 
-func (lDoc *DocPositions) readPersistedPageText(pageIdx uint32) (string, error) {
+// pageTextHash returns the hash of the text of the page with index `pageIdx` in `lDoc`. This is
+// the page's byteSpan.TextHash if it has one, so the text isn't read. See textHash.
+func (lDoc *Doc) pageTextHash(pageIdx uint32) (string, error) {
+	if !lDoc.isMem() && int(pageIdx) < len(lDoc.spans) && lDoc.spans[pageIdx].TextHash != "" {
+		return lDoc.spans[pageIdx].TextHash, nil
+	}
+	text, err := lDoc.ReadPageText(pageIdx)
+	if err != nil {
+		return "", err
+	}
+	return textHash(text), nil
+}
+
+// PageNum returns the PDF page number (1-offset) of the page with index `pageIdx` in `lDoc`.
+func (lDoc *Doc) PageNum(pageIdx uint32) (uint32, error) {
+	if pageIdx >= uint32(lDoc.Len()) {
+		return 0, fmt.Errorf("Bad pageIdx=%d lDoc=%s", pageIdx, lDoc)
+	}
+	if lDoc.isMem() {
+		return lDoc.pageNums[pageIdx], nil
+	}
+	return lDoc.spans[pageIdx].PageNum, nil
+}
+
+func (lDoc *Doc) readPersistedPageText(pageIdx uint32) (string, error) {
 	filename := lDoc.GetTextPath(pageIdx)
-	b, err := ioutil.ReadFile(filename)
+	if err := lDoc.lState.thawPageText(lDoc, filename); err != nil {
+		return "", err
+	}
+	f, err := os.Open(filename)
 	if err != nil {
 		return "", err
 	}
-	return string(b), nil
+	defer f.Close()
+	buf := getTextBuf()
+	defer putTextBuf(buf)
+	if _, err := buf.ReadFrom(f); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
 }
 
 // ReadPagePositions returns the DocPageLocations of the text on the `pageIdx` (0-offset)
 // returned text in document `lDoc`.
-func (lDoc *DocPositions) ReadPagePositions(pageIdx uint32) (uint32, serial.DocPageLocations, error) {
+func (lDoc *Doc) ReadPagePositions(pageIdx uint32) (uint32, serial.DocPageLocations, error) {
 	if lDoc.isMem() {
 		if pageIdx >= uint32(len(lDoc.pageNums)) {
 			return 0, serial.DocPageLocations{}, fmt.Errorf("Bad pageIdx=%d lDoc=%s", pageIdx, lDoc)
@@ -259,7 +422,7 @@ func (lDoc *DocPositions) ReadPagePositions(pageIdx uint32) (uint32, serial.DocP
 	return lDoc.readPersistedPagePositions(pageIdx)
 }
 
-func (lDoc *DocPositions) readPersistedPagePositions(pageIdx uint32) (
+func (lDoc *Doc) readPersistedPagePositions(pageIdx uint32) (
 	uint32, serial.DocPageLocations, error) {
 
 	e := lDoc.spans[pageIdx]
@@ -267,28 +430,65 @@ func (lDoc *DocPositions) readPersistedPagePositions(pageIdx uint32) (
 		return 0, serial.DocPageLocations{}, fmt.Errorf("Bad span pageIdx=%d e=%+v", pageIdx, e)
 	}
 
+	if lDoc.mapped != nil {
+		data := lDoc.mapped.data
+		end := uint64(e.Offset) + uint64(e.Size)
+		if end > uint64(len(data)) {
+			return 0, serial.DocPageLocations{}, fmt.Errorf("Bad span pageIdx=%d e=%+v size=%d",
+				pageIdx, e, len(data))
+		}
+		return lDoc.checkPagePositions(e, data[e.Offset:end])
+	}
+
 	offset, err := lDoc.dataFile.Seek(int64(e.Offset), io.SeekStart)
 	if err != nil || uint32(offset) != e.Offset {
 		common.Log.Error("ReadPagePositions: Seek failed e=%+v offset=%d err=%v",
 			e, offset, err)
 		return 0, serial.DocPageLocations{}, err
 	}
-	buf := make([]byte, e.Size)
-	if _, err := lDoc.dataFile.Read(buf); err != nil {
+	bp := getByteBuf(int(e.Size))
+	defer putByteBuf(bp)
+	buf := *bp
+	if _, err := io.ReadFull(lDoc.dataFile, buf); err != nil {
 		return 0, serial.DocPageLocations{}, err
 	}
+	return lDoc.checkPagePositions(e, buf)
+}
+
+// checkPagePositions returns the page number and DocPageLocations of the page with byteSpan `e`
+// whose serialized DocPageLocations are `buf`. The checksum of `buf` is verified.
+func (lDoc *Doc) checkPagePositions(e byteSpan, buf []byte) (
+	uint32, serial.DocPageLocations, error) {
+
 	size := len(buf)
 	check := crc32.ChecksumIEEE(buf)
 	if check != e.Check {
 		common.Log.Error("ReadPagePositions: e=%+v size=%d check=%d", e, size, check)
-		panic(errors.New("bad checksum"))
-		return 0, serial.DocPageLocations{}, errors.New("bad checksum")
+		return 0, serial.DocPageLocations{}, ErrBadChecksum
 	}
 	dpl, err := serial.ReadDocPageLocations(buf)
 	return e.PageNum, dpl, err
 }
 
-func (lDoc *DocPositions) GetTextPath(pageIdx uint32) string {
+// removeFiles deletes the files that store `lDoc` on disk. The content-addressed page texts are
+// shared with other documents so they are not deleted. See releasePageTexts.
+func (lDoc *Doc) removeFiles() error {
+	if lDoc.isMem() {
+		return nil
+	}
+	for _, path := range []string{lDoc.dataPath, lDoc.spansPath, lDoc.pageDplPath} {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return os.RemoveAll(lDoc.textDir)
+}
+
+// GetTextPath returns the path of the file that the text of page `pageIdx` in `lDoc` is stored in.
+func (lDoc *Doc) GetTextPath(pageIdx uint32) string {
+	if int(pageIdx) < len(lDoc.spans) && lDoc.spans[pageIdx].TextHash != "" {
+		return lDoc.lState.textPath(lDoc.spans[pageIdx].TextHash)
+	}
 	return filepath.Join(lDoc.textDir, fmt.Sprintf("%03d.txt", pageIdx))
 }
 
@@ -296,31 +496,33 @@ func CreateFileDesc(inPath string, rs io.ReadSeeker) (FileDesc, error) {
 	if rs != nil {
 		size, hash, err := ReaderSizeHash(rs)
 		return FileDesc{
-			InPath: inPath,
-			Hash:   hash,
-			SizeMB: float64(size) / 1024.0 / 1024.0,
+			InPath:   inPath,
+			Hash:     hash,
+			SizeMB:   float64(size) / 1024.0 / 1024.0,
+			Modified: fileModTime(rs),
 		}, err
 	}
 	hash, err := FileHash(inPath)
 	if err != nil {
 		return FileDesc{}, err
 	}
-	size, err := FileSize(inPath)
+	fi, err := os.Stat(inPath)
 	if err != nil {
 		return FileDesc{}, err
 	}
 	return FileDesc{
-		InPath: inPath,
-		Hash:   hash,
-		SizeMB: float64(size) / 1024.0 / 1024.0,
+		InPath:   inPath,
+		Hash:     hash,
+		SizeMB:   float64(fi.Size()) / 1024.0 / 1024.0,
+		Modified: fi.ModTime(),
 	}, nil
 }
 
 // DocPageText contains doc:page indexes, the PDF page number and the text extracted from from a PDF
 // page.
 type DocPageText struct {
-	DocIdx  uint64 // Doc index (0-offset) into PositionsState.fileList .
-	PageIdx uint32 // Page index (0-offset) into DocPositions.index .
+	DocIdx  uint64 // Doc index (0-offset) into Store.fileList .
+	PageIdx uint32 // Page index (0-offset) into Doc.index .
 	PageNum uint32 // Page number in PDF file (1-offset)
 	Text    string // Extracted page text.
 }
@@ -0,0 +1,43 @@
+package doclib
+
+import "fmt"
+
+// DuplicatePolicy is what indexing does with a file whose contents are the same as a document that
+// is already in the store, such as a copy of a PDF under another path.
+type DuplicatePolicy int
+
+const (
+	// DuplicateAddAlias records the file's path as an alias of the document that is already in the
+	// store. The file is reported as FileDuplicate.
+	DuplicateAddAlias DuplicatePolicy = iota
+	// DuplicateSkip reports the file as FileDuplicate and doesn't record its path.
+	DuplicateSkip
+	// DuplicateError reports the file as FileFailed with error ErrDuplicate.
+	DuplicateError
+)
+
+// duplicatePolicyNames are the names of the DuplicatePolicy values.
+var duplicatePolicyNames = map[DuplicatePolicy]string{
+	DuplicateAddAlias: "alias",
+	DuplicateSkip:     "skip",
+	DuplicateError:    "error",
+}
+
+func (p DuplicatePolicy) String() string {
+	if name, ok := duplicatePolicyNames[p]; ok {
+		return name
+	}
+	return fmt.Sprintf("DuplicatePolicy(%d)", int(p))
+}
+
+// ParseDuplicatePolicy returns the DuplicatePolicy named `name`, which is one of "alias", "skip"
+// or "error".
+func ParseDuplicatePolicy(name string) (DuplicatePolicy, error) {
+	for p, n := range duplicatePolicyNames {
+		if n == name {
+			return p, nil
+		}
+	}
+	return DuplicateAddAlias, fmt.Errorf("Unknown duplicate policy %q. Use alias, skip or error",
+		name)
+}
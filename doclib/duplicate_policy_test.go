@@ -0,0 +1,15 @@
+package doclib
+
+import "testing"
+
+func TestParseDuplicatePolicy(t *testing.T) {
+	for _, p := range []DuplicatePolicy{DuplicateAddAlias, DuplicateSkip, DuplicateError} {
+		got, err := ParseDuplicatePolicy(p.String())
+		if err != nil || got != p {
+			t.Errorf("ParseDuplicatePolicy(%q) = %v, %v. expected %v", p.String(), got, err, p)
+		}
+	}
+	if _, err := ParseDuplicatePolicy("merge"); err == nil {
+		t.Errorf("ParseDuplicatePolicy(%q) succeeded. expected error", "merge")
+	}
+}
@@ -0,0 +1,18 @@
+package doclib
+
+import (
+	"errors"
+
+	"github.com/peterwilliams97/pdf-search/serial"
+)
+
+// ErrDuplicate is returned when a PDF is added to a store that already has a PDF with the same
+// contents.
+var ErrDuplicate = errors.New("duplicate PDF")
+
+// ErrCorruptStore is returned when the data in a store is inconsistent. The details are logged.
+var ErrCorruptStore = errors.New("corrupt store")
+
+// ErrBadChecksum is returned when data read from a store or a stream doesn't match its checksum.
+// It is serial.ErrBadChecksum so the errors from both packages can be compared with it.
+var ErrBadChecksum = serial.ErrBadChecksum
@@ -0,0 +1,364 @@
+package doclib
+
+import (
+	"container/heap"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultRetryBackoff is the wait before the first retry of a failed extraction if
+// IndexOptions.RetryBackoff isn't set.
+const defaultRetryBackoff = time.Second
+
+// JobState is the state of the extraction of a document in an ExtractScheduler.
+type JobState string
+
+const (
+	JobPending  JobState = "pending"  // Waiting to be extracted.
+	JobRunning  JobState = "running"  // Being extracted.
+	JobRetrying JobState = "retrying" // Failed and waiting to be extracted again.
+	JobFailed   JobState = "failed"   // Failed on its last attempt.
+	JobDone     JobState = "done"     // Extracted, or skipped, and passed on to the store.
+)
+
+// FilePriority is the scheduling priority of the PDF files that match a glob pattern.
+type FilePriority struct {
+	// Pattern is a glob pattern that matches a file's path or base name. See filepath.Match.
+	Pattern  string
+	Priority int // Files with higher priorities are extracted first. The default is 0.
+}
+
+// ParseFilePriorities returns the FilePriorities in `s`, a comma separated list of
+// pattern=priority. e.g. "urgent/*=10,*.scan.pdf=-1"
+func ParseFilePriorities(s string) ([]FilePriority, error) {
+	var priorities []FilePriority
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		i := strings.LastIndex(part, "=")
+		if i <= 0 || i == len(part)-1 {
+			return nil, fmt.Errorf("Bad file priority %q. Use pattern=priority", part)
+		}
+		pattern := part[:i]
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("Bad priority pattern %q. err=%v", pattern, err)
+		}
+		priority, err := strconv.Atoi(part[i+1:])
+		if err != nil {
+			return nil, fmt.Errorf("Bad priority %q. err=%v", part, err)
+		}
+		priorities = append(priorities, FilePriority{Pattern: pattern, Priority: priority})
+	}
+	return priorities, nil
+}
+
+// filePriority returns the priority that `opts` gives PDF file `inPath`. The first matching
+// FilePriority is used.
+func (opts IndexOptions) filePriority(inPath string) int {
+	base := filepath.Base(inPath)
+	for _, fp := range opts.Priorities {
+		for _, name := range []string{inPath, base} {
+			if matched, _ := filepath.Match(fp.Pattern, name); matched {
+				return fp.Priority
+			}
+		}
+	}
+	return 0
+}
+
+// ExtractJob describes the extraction of a document by an ExtractScheduler.
+type ExtractJob struct {
+	InPath   string
+	State    JobState
+	Priority int
+	SizeMB   float64 // Size of the PDF file. 0 if it isn't known until the file is read.
+	Attempts int     // Number of times extraction of the document has started.
+	// Err is the error from the last attempt if it failed.
+	Err      string    `json:",omitempty"`
+	Started  time.Time // When the last attempt started.
+	Finished time.Time // When the last attempt finished.
+}
+
+// ExtractScheduler chooses the order in which the concurrent extraction workers of an indexing run
+// extract its documents and retries documents whose extraction failed. Documents with higher
+// priorities are extracted first. See IndexOptions.Priorities and IndexOptions.SmallFirst.
+// Set IndexOptions.Scheduler to an ExtractScheduler to see the pending, running and failed
+// documents of a long indexing run with Jobs and to steer it with SetPriority while it runs. An
+// ExtractScheduler must not be shared by concurrent indexing runs.
+type ExtractScheduler struct {
+	mu       sync.Mutex
+	jobs     []*extractJob // The jobs of the current run in `pathList` order.
+	ready    jobQueue      // The pending jobs and the retries that are due.
+	retrying []*extractJob // The retries that aren't due yet.
+	running  int           // Number of running jobs.
+	changed  chan struct{} // Closed, and replaced, when a job is finished or reprioritized.
+}
+
+// extractJob is the ExtractJob for pathList[i] of an indexing run.
+type extractJob struct {
+	ExtractJob
+	i         int
+	cost      int64     // Estimated memory used to extract the document. See memoryBudget.
+	readyAt   time.Time // When a JobRetrying job may be started again.
+	heapIndex int       // Index of the job in ExtractScheduler.ready or -1 if it isn't there.
+}
+
+// NewExtractScheduler returns an ExtractScheduler with no jobs.
+func NewExtractScheduler() *ExtractScheduler {
+	return &ExtractScheduler{changed: make(chan struct{})}
+}
+
+// Jobs returns the jobs of the current or last indexing run in the order of the files passed to
+// the run. If `states` are given only the jobs in those states are returned.
+func (s *ExtractScheduler) Jobs(states ...JobState) []ExtractJob {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var jobs []ExtractJob
+	for _, j := range s.jobs {
+		if len(states) == 0 || hasJobState(states, j.State) {
+			jobs = append(jobs, j.ExtractJob)
+		}
+	}
+	return jobs
+}
+
+// Counts returns the number of jobs in each state.
+func (s *ExtractScheduler) Counts() map[JobState]int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	counts := map[JobState]int{}
+	for _, j := range s.jobs {
+		counts[j.State]++
+	}
+	return counts
+}
+
+// SetPriority changes the priority of the document `inPath` if it is waiting to be extracted. It
+// returns false if there is no such document.
+func (s *ExtractScheduler) SetPriority(inPath string, priority int) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	found := false
+	for _, j := range s.jobs {
+		if j.InPath == inPath && (j.State == JobPending || j.State == JobRetrying) {
+			j.Priority = priority
+			if j.heapIndex >= 0 {
+				heap.Fix(&s.ready, j.heapIndex)
+			}
+			found = true
+		}
+	}
+	if found {
+		s.notify()
+	}
+	return found
+}
+
+// hasJobState returns true if `state` is in `states`.
+func hasJobState(states []JobState, state JobState) bool {
+	for _, st := range states {
+		if st == state {
+			return true
+		}
+	}
+	return false
+}
+
+// start replaces the jobs of `s` with the documents in `pathList`, read by `getReader`, that will
+// be extracted with options `opts`.
+func (s *ExtractScheduler) start(pathList []string, getReader func(i int) io.ReadSeeker,
+	opts IndexOptions) {
+
+	jobs := make([]*extractJob, len(pathList))
+	for i, inPath := range pathList {
+		size := fileSize(inPath, getReader(i), opts)
+		j := &extractJob{i: i, cost: estimateExtractionBytes(size)}
+		j.InPath = inPath
+		j.State = JobPending
+		j.Priority = opts.filePriority(inPath)
+		if size > 0 {
+			j.SizeMB = float64(size) / 1024.0 / 1024.0
+		}
+		jobs[i] = j
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.jobs = jobs
+	s.ready = jobQueue{jobs: append([]*extractJob(nil), jobs...), smallFirst: opts.SmallFirst}
+	for i, j := range s.ready.jobs {
+		j.heapIndex = i
+	}
+	heap.Init(&s.ready)
+	s.retrying = nil
+	s.running = 0
+	s.notify()
+}
+
+// next returns the next job to extract and marks it as running. It waits for retries that aren't
+// due yet and for running jobs that may be retried. It returns nil when there are no more jobs to
+// extract and `ctx`'s error if `ctx` is done first.
+func (s *ExtractScheduler) next(ctx context.Context) (*extractJob, error) {
+	for {
+		s.mu.Lock()
+		now := time.Now()
+		var wakeAt time.Time // When the first retry that isn't due yet is due.
+		retrying := s.retrying[:0]
+		for _, j := range s.retrying {
+			if !j.readyAt.After(now) {
+				heap.Push(&s.ready, j)
+				continue
+			}
+			if wakeAt.IsZero() || j.readyAt.Before(wakeAt) {
+				wakeAt = j.readyAt
+			}
+			retrying = append(retrying, j)
+		}
+		s.retrying = retrying
+		if s.ready.Len() > 0 {
+			j := heap.Pop(&s.ready).(*extractJob)
+			j.State = JobRunning
+			j.Attempts++
+			j.Started = now
+			s.running++
+			s.mu.Unlock()
+			return j, nil
+		}
+		// Running jobs may fail and be retried.
+		active := s.running > 0 || len(s.retrying) > 0
+		changed := s.changed
+		s.mu.Unlock()
+		if !active {
+			return nil, nil
+		}
+
+		var wake <-chan time.Time
+		if !wakeAt.IsZero() {
+			wake = time.After(wakeAt.Sub(now))
+		}
+		select {
+		case <-changed:
+		case <-wake:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// jobQueue is a heap of extractJobs. Jobs with higher priorities come first, then smaller files
+// if smallFirst is set, then earlier files.
+type jobQueue struct {
+	jobs       []*extractJob
+	smallFirst bool
+}
+
+func (q *jobQueue) Len() int { return len(q.jobs) }
+
+func (q *jobQueue) Less(i, j int) bool {
+	a, b := q.jobs[i], q.jobs[j]
+	if a.Priority != b.Priority {
+		return a.Priority > b.Priority
+	}
+	if q.smallFirst && a.cost != b.cost {
+		return a.cost < b.cost
+	}
+	return a.i < b.i
+}
+
+func (q *jobQueue) Swap(i, j int) {
+	q.jobs[i], q.jobs[j] = q.jobs[j], q.jobs[i]
+	q.jobs[i].heapIndex = i
+	q.jobs[j].heapIndex = j
+}
+
+func (q *jobQueue) Push(x interface{}) {
+	j := x.(*extractJob)
+	j.heapIndex = len(q.jobs)
+	q.jobs = append(q.jobs, j)
+}
+
+func (q *jobQueue) Pop() interface{} {
+	n := len(q.jobs)
+	j := q.jobs[n-1]
+	q.jobs = q.jobs[:n-1]
+	j.heapIndex = -1
+	return j
+}
+
+// finish records the extraction `ext` of job `j`. If extraction failed and `j` has attempts left
+// in `opts`, `j` is scheduled to be retried after an exponential backoff and finish returns true.
+func (s *ExtractScheduler) finish(j *extractJob, ext docExtraction, opts IndexOptions) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	defer s.notify()
+	s.running--
+	j.Finished = time.Now()
+	j.Err = ""
+	if ext.err == nil {
+		j.State = JobDone
+		return false
+	}
+	j.Err = ext.err.Error()
+	if _, isPanic := ext.err.(panicError); isPanic || j.Attempts > opts.MaxRetries {
+		j.State = JobFailed
+		return false
+	}
+	backoff := opts.RetryBackoff
+	if backoff <= 0 {
+		backoff = defaultRetryBackoff
+	}
+	j.State = JobRetrying
+	j.readyAt = j.Finished.Add(backoff << uint(j.Attempts-1))
+	s.retrying = append(s.retrying, j)
+	return true
+}
+
+// notify wakes the callers of next that are waiting for a job. It must be called with s.mu held.
+func (s *ExtractScheduler) notify() {
+	close(s.changed)
+	s.changed = make(chan struct{})
+}
+
+// JobsHandler returns an http.Handler that serves the jobs of `s` as JSON. GET requests return
+//   {"Counts": {"done": 10, "running": 4, "pending": 186}, "Jobs": [<ExtractJob>, ...]}
+// The optional `state` query parameter, e.g. ?state=failed, selects the jobs in a state.
+// POST requests with `path` and `priority` parameters change the priority of a pending job.
+func JobsHandler(s *ExtractScheduler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			var states []JobState
+			if state := r.FormValue("state"); state != "" {
+				states = append(states, JobState(state))
+			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(struct {
+				Counts map[JobState]int
+				Jobs   []ExtractJob
+			}{s.Counts(), s.Jobs(states...)})
+		case http.MethodPost:
+			inPath := r.FormValue("path")
+			priority, err := strconv.Atoi(r.FormValue("priority"))
+			if err != nil {
+				http.Error(w, fmt.Sprintf("Bad priority. err=%v", err), http.StatusBadRequest)
+				return
+			}
+			if !s.SetPriority(inPath, priority) {
+				http.Error(w, fmt.Sprintf("No pending job for %q", inPath), http.StatusNotFound)
+				return
+			}
+			fmt.Fprintf(w, "%q has priority %d\n", inPath, priority)
+		default:
+			http.Error(w, "Use GET or POST", http.StatusMethodNotAllowed)
+		}
+	})
+}
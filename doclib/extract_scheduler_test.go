@@ -0,0 +1,119 @@
+package doclib
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseFilePriorities(t *testing.T) {
+	got, err := ParseFilePriorities("urgent/*=10, *.scan.pdf=-1,")
+	if err != nil {
+		t.Fatalf("ParseFilePriorities failed. err=%v", err)
+	}
+	expected := []FilePriority{{"urgent/*", 10}, {"*.scan.pdf", -1}}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("got %+v expected %+v", got, expected)
+	}
+	for _, s := range []string{"urgent/*", "=3", "a.pdf=high", "[=1"} {
+		if _, err := ParseFilePriorities(s); err == nil {
+			t.Errorf("%q: Bad priority was accepted.", s)
+		}
+	}
+}
+
+func TestExtractSchedulerOrder(t *testing.T) {
+	pathList := []string{"big.pdf", "small.pdf", "urgent/a.pdf", "medium.pdf", "unknown.pdf"}
+	sizes := []int{3000, 10, 5000, 500, -1}
+	getReader := func(i int) io.ReadSeeker {
+		if sizes[i] < 0 {
+			return nil
+		}
+		return bytes.NewReader(make([]byte, sizes[i]))
+	}
+	opts := IndexOptions{
+		Priorities: []FilePriority{{Pattern: "urgent/*", Priority: 1}},
+		SmallFirst: true,
+	}
+	s := NewExtractScheduler()
+	s.start(pathList, getReader, opts)
+	if !s.SetPriority("big.pdf", 2) {
+		t.Errorf("SetPriority failed.")
+	}
+	ctx := context.Background()
+	var order []string
+	for {
+		j, err := s.next(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if j == nil {
+			break
+		}
+		order = append(order, j.InPath)
+		s.finish(j, docExtraction{}, opts)
+	}
+	// Documents whose sizes are unknown are assumed to be big.
+	expected := []string{"big.pdf", "urgent/a.pdf", "small.pdf", "medium.pdf", "unknown.pdf"}
+	if !reflect.DeepEqual(order, expected) {
+		t.Errorf("got %q expected %q", order, expected)
+	}
+	if counts := s.Counts(); counts[JobDone] != len(pathList) {
+		t.Errorf("counts=%v", counts)
+	}
+	if s.SetPriority("small.pdf", 3) {
+		t.Errorf("SetPriority changed a finished job.")
+	}
+}
+
+func TestExtractSchedulerRetry(t *testing.T) {
+	opts := IndexOptions{MaxRetries: 2, RetryBackoff: 10 * time.Millisecond}
+	s := NewExtractScheduler()
+	s.start([]string{"flaky.pdf", "crash.pdf"}, func(int) io.ReadSeeker { return nil }, opts)
+	ctx := context.Background()
+	attempts := map[string]int{}
+	for {
+		j, err := s.next(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if j == nil {
+			break
+		}
+		attempts[j.InPath]++
+		var ext docExtraction
+		if j.InPath == "crash.pdf" {
+			ext.err = panicError{errors.New("nil pointer")}
+		} else {
+			ext.err = errors.New("connection reset")
+		}
+		retry := s.finish(j, ext, opts)
+		if retry != (j.InPath == "flaky.pdf" && attempts[j.InPath] <= opts.MaxRetries) {
+			t.Errorf("%q: attempt %d retry=%t", j.InPath, attempts[j.InPath], retry)
+		}
+	}
+	// Crashes aren't retried.
+	if attempts["flaky.pdf"] != 3 || attempts["crash.pdf"] != 1 {
+		t.Errorf("attempts=%v", attempts)
+	}
+	failed := s.Jobs(JobFailed)
+	if len(failed) != 2 || failed[0].Attempts != 3 || failed[0].Err != "connection reset" {
+		t.Errorf("failed=%+v", failed)
+	}
+
+	// Waiting for a retry stops when the context is done.
+	s.start([]string{"flaky.pdf"}, func(int) io.ReadSeeker { return nil },
+		IndexOptions{MaxRetries: 1, RetryBackoff: time.Hour})
+	j, _ := s.next(ctx)
+	s.finish(j, docExtraction{err: errors.New("timeout")}, IndexOptions{MaxRetries: 1,
+		RetryBackoff: time.Hour})
+	ctx2, cancel := context.WithTimeout(ctx, 20*time.Millisecond)
+	defer cancel()
+	if _, err := s.next(ctx2); err != context.DeadlineExceeded {
+		t.Errorf("got %v expected %v", err, context.DeadlineExceeded)
+	}
+}
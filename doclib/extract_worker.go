@@ -0,0 +1,335 @@
+package doclib
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/peterwilliams97/pdf-search/serial"
+	"github.com/unidoc/unidoc/common"
+)
+
+// ExtractWorkerCommand is the command line argument that makes a program that indexes with
+// IndexOptions.Isolate run as an extraction worker. See RunExtractWorker.
+const ExtractWorkerCommand = "extract-one"
+
+// workerOptions are the IndexOptions that control extraction in a form that can be sent to an
+// extraction worker. Extractors are sent by their registered names.
+type workerOptions struct {
+	TextExtractor  string `json:",omitempty"`
+	FileExtractors []workerFileExtractor
+	OCR            string `json:",omitempty"`
+	Normalization  TextNormalization
+	RawTextOrder   bool
+	PageRanges     PageRanges
+	MinTextQuality float64
+	MaxBufferMB    float64
+}
+
+// workerFileExtractor is a FileExtractor sent to an extraction worker.
+type workerFileExtractor struct {
+	Pattern   string
+	Extractor string // Registered name of the extractor.
+}
+
+// workerRequest is what an extraction worker reads from its standard input. Requests without a
+// PdfPath check that the worker runs.
+type workerRequest struct {
+	InPath  string   // Name of the PDF file.
+	PdfPath string   // File the PDF is read from.
+	FD      FileDesc // Description of the PDF file.
+	Options workerOptions
+}
+
+// workerResult is the docExtraction that an extraction worker writes to its standard output.
+type workerResult struct {
+	FD       FileDesc
+	Pages    []workerPage
+	Err      string `json:",omitempty"` // Error from extraction, if any.
+	Panic    bool   `json:",omitempty"` // Err is a panic in the PDF library.
+	NumPages int
+	PageErrs []string
+}
+
+// workerPage is a pageExtraction sent from an extraction worker.
+type workerPage struct {
+	PageNum   uint32
+	Text      string
+	Locations []serial.TextLocation
+	Box       PageBox
+	Quality   float64
+	Annots    []string `json:",omitempty"`
+	Tables    []Table  `json:",omitempty"`
+}
+
+// RunExtractWorker runs the program as an extraction worker: it reads a request to extract a PDF
+// from standard input and writes the extracted text and text locations to standard output.
+// Programs that index with IndexOptions.Isolate must call it, and exit, when they are run with the
+// single argument ExtractWorkerCommand. Anything the program prints to standard output while the
+// worker runs, such as logs, goes to standard error.
+func RunExtractWorker() error {
+	out := os.Stdout
+	os.Stdout = os.Stderr
+	defer func() { os.Stdout = out }()
+	return runExtractWorker(os.Stdin, out)
+}
+
+// runExtractWorker reads a workerRequest from `r` and writes its workerResult to `w`.
+func runExtractWorker(r io.Reader, w io.Writer) error {
+	var req workerRequest
+	if err := json.NewDecoder(r).Decode(&req); err != nil {
+		return fmt.Errorf("Could not read extraction request. err=%v", err)
+	}
+	return json.NewEncoder(w).Encode(req.extract())
+}
+
+// extract returns the extraction of the PDF in `req`.
+func (req workerRequest) extract() workerResult {
+	if req.PdfPath == "" {
+		return workerResult{}
+	}
+	opts, err := req.Options.indexOptions()
+	if err != nil {
+		return workerResult{FD: req.FD, Err: err.Error()}
+	}
+	f, err := os.Open(req.PdfPath)
+	if err != nil {
+		return workerResult{FD: req.FD, Err: err.Error()}
+	}
+	defer f.Close()
+	return makeWorkerResult(extractPdfPages(context.Background(), req.InPath, f, req.FD, opts))
+}
+
+// makeWorkerResult returns `ext` in the form that extraction workers send.
+func makeWorkerResult(ext docExtraction) workerResult {
+	res := workerResult{FD: ext.fd, NumPages: ext.numPages, PageErrs: ext.pageErrs}
+	if ext.err != nil {
+		res.Err = ext.err.Error()
+		_, res.Panic = ext.err.(panicError)
+	}
+	for _, pe := range ext.pages {
+		res.Pages = append(res.Pages, workerPage{
+			PageNum:   pe.pageNum,
+			Text:      pe.text,
+			Locations: pe.dpl.Locations,
+			Box:       pe.box,
+			Quality:   pe.quality,
+			Annots:    pe.annots,
+			Tables:    pe.tables,
+		})
+	}
+	return res
+}
+
+// docExtraction returns the docExtraction of PDF `inPath` that an extraction worker sent as `res`.
+func (res workerResult) docExtraction(inPath string) docExtraction {
+	ext := docExtraction{inPath: inPath, fd: res.FD, numPages: res.NumPages,
+		pageErrs: res.PageErrs}
+	if res.Err != "" {
+		ext.err = errors.New(res.Err)
+		if res.Panic {
+			ext.err = panicError{ext.err}
+		}
+	}
+	for _, p := range res.Pages {
+		ext.pages = append(ext.pages, pageExtraction{
+			pageNum: p.PageNum,
+			text:    p.Text,
+			dpl:     serial.DocPageLocations{Locations: p.Locations},
+			box:     p.Box,
+			quality: p.Quality,
+			annots:  p.Annots,
+			tables:  p.Tables,
+		})
+	}
+	return ext
+}
+
+// workerOptions returns the options in `opts` that control extraction in the form that is sent to
+// extraction workers. It returns an error if any of the extractors aren't registered.
+func (opts IndexOptions) workerOptions() (workerOptions, error) {
+	w := workerOptions{
+		Normalization:  opts.Normalization,
+		RawTextOrder:   opts.RawTextOrder,
+		PageRanges:     opts.PageRanges,
+		MinTextQuality: opts.MinTextQuality,
+		MaxBufferMB:    opts.MaxBufferMB,
+	}
+	var err error
+	if opts.TextExtractor != nil {
+		if w.TextExtractor, err = registeredName(opts.TextExtractor.Extractor()); err != nil {
+			return workerOptions{}, err
+		}
+	}
+	for _, fe := range opts.FileExtractors {
+		name, err := registeredName(fe.Extractor.Extractor())
+		if err != nil {
+			return workerOptions{}, err
+		}
+		w.FileExtractors = append(w.FileExtractors, workerFileExtractor{fe.Pattern, name})
+	}
+	if opts.OCR != nil {
+		if w.OCR, err = registeredName(opts.OCR.Extractor()); err != nil {
+			return workerOptions{}, err
+		}
+	}
+	return w, nil
+}
+
+// registeredName returns the name that the TextExtractor whose name and version are `e` is
+// registered as.
+func registeredName(e Extractor) (string, error) {
+	name := strings.ToLower(e.Name)
+	textExtractorsMu.Lock()
+	_, ok := textExtractors[name]
+	textExtractorsMu.Unlock()
+	if !ok {
+		return "", fmt.Errorf("Extractor %q can't be used by extraction workers. It isn't "+
+			"registered. Known extractors: %s", e.Name, strings.Join(TextExtractorNames(), ", "))
+	}
+	return name, nil
+}
+
+// indexOptions returns IndexOptions with the extraction options in `w`. The extractors are
+// created from their registered names.
+func (w workerOptions) indexOptions() (IndexOptions, error) {
+	opts := IndexOptions{
+		Normalization:  w.Normalization,
+		RawTextOrder:   w.RawTextOrder,
+		PageRanges:     w.PageRanges,
+		MinTextQuality: w.MinTextQuality,
+		MaxBufferMB:    w.MaxBufferMB,
+	}
+	var err error
+	if w.TextExtractor != "" {
+		if opts.TextExtractor, err = NewTextExtractor(w.TextExtractor); err != nil {
+			return IndexOptions{}, err
+		}
+	}
+	for _, fe := range w.FileExtractors {
+		te, err := NewTextExtractor(fe.Extractor)
+		if err != nil {
+			return IndexOptions{}, err
+		}
+		opts.FileExtractors = append(opts.FileExtractors, FileExtractor{fe.Pattern, te})
+	}
+	if w.OCR != "" {
+		te, err := NewTextExtractor(w.OCR)
+		if err != nil {
+			return IndexOptions{}, err
+		}
+		ocr, ok := te.(OCRExtractor)
+		if !ok {
+			return IndexOptions{}, fmt.Errorf("Extractor %q is not an OCR engine", w.OCR)
+		}
+		opts.OCR = ocr.OCR
+	}
+	return opts, nil
+}
+
+// checkIsolation returns an error if PDFs can't be extracted by extraction workers with `opts`:
+// either the extractors aren't registered or the program doesn't run as an extraction worker.
+func (opts IndexOptions) checkIsolation() error {
+	if _, err := opts.workerOptions(); err != nil {
+		return err
+	}
+	var res workerResult
+	if err := runWorker(context.Background(), workerRequest{}, &res); err != nil {
+		return fmt.Errorf("Could not run extraction worker. Does the program call "+
+			"RunExtractWorker? err=%v", err)
+	}
+	return nil
+}
+
+// extractIsolated extracts the text and text locations of the pages of the PDF file described by
+// `fd` and read from `rs` in an extraction worker. `inPath` is the name of the PDF file.
+// PDFs that kill the worker are reported as panics so that they are quarantined. See CorpusFilter.
+// The worker is killed if `ctx` is done.
+func extractIsolated(ctx context.Context, inPath string, rs io.ReadSeeker, fd FileDesc,
+	opts IndexOptions) docExtraction {
+
+	fail := func(err error) docExtraction {
+		return docExtraction{inPath: inPath, fd: fd, err: err}
+	}
+	w, err := opts.workerOptions()
+	if err != nil {
+		return fail(err)
+	}
+	pdfPath, cleanup, err := pdfFilePath(rs)
+	if err != nil {
+		return fail(err)
+	}
+	defer cleanup()
+
+	req := workerRequest{InPath: inPath, PdfPath: pdfPath, FD: fd, Options: w}
+	var res workerResult
+	if err := runWorker(ctx, req, &res); err != nil {
+		if ctx.Err() != nil {
+			return fail(ctx.Err())
+		}
+		common.Log.Error("extractIsolated: Extraction worker died. inPath=%q err=%v", inPath, err)
+		return fail(panicError{fmt.Errorf("extraction worker died: %v", err)})
+	}
+	return res.docExtraction(inPath)
+}
+
+// runWorker runs an extraction worker on `req` and decodes its result into `res`. It returns an
+// error if the worker doesn't exit cleanly with a result. The worker is killed if `ctx` is done.
+func runWorker(ctx context.Context, req workerRequest, res *workerResult) error {
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	b, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+	var stdout bytes.Buffer
+	cmd := exec.CommandContext(ctx, exe, ExtractWorkerCommand)
+	cmd.Stdin = bytes.NewReader(b)
+	cmd.Stdout = &stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return err
+	}
+	return json.Unmarshal(stdout.Bytes(), res)
+}
+
+// pdfFilePath returns the path of a file with the contents of `rs`, which extraction workers read,
+// and a function that removes the file if it is temporary. Files and spooled files are used
+// directly. Other readers are copied to a temporary file.
+func pdfFilePath(rs io.ReadSeeker) (string, func(), error) {
+	noop := func() {}
+	switch r := rs.(type) {
+	case *os.File:
+		return r.Name(), noop, nil
+	case *SpooledReader:
+		if r.file != nil {
+			return r.file.Name(), noop, nil
+		}
+	}
+	if _, err := rs.Seek(0, io.SeekStart); err != nil {
+		return "", nil, err
+	}
+	f, err := ioutil.TempFile("", "pdf-search-extract")
+	if err != nil {
+		return "", nil, err
+	}
+	cleanup := func() { os.Remove(f.Name()) }
+	_, err = io.Copy(f, rs)
+	if err2 := f.Close(); err == nil {
+		err = err2
+	}
+	if err != nil {
+		cleanup()
+		return "", nil, err
+	}
+	return f.Name(), cleanup, nil
+}
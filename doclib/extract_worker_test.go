@@ -0,0 +1,108 @@
+package doclib
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/peterwilliams97/pdf-search/serial"
+)
+
+func TestWorkerResult(t *testing.T) {
+	ext := docExtraction{
+		fd:       FileDesc{InPath: "a.pdf", Hash: "abcd", Extractors: []Extractor{unidocExtractor}},
+		numPages: 3,
+		pageErrs: []string{"page 2: bad font"},
+		err:      panicError{errors.New("nil pointer")},
+		pages: []pageExtraction{
+			{
+				pageNum: 1,
+				text:    "Total 42",
+				dpl: serial.DocPageLocations{Locations: []serial.TextLocation{
+					{Start: 0, End: 5, Llx: 10, Lly: 700, Urx: 40, Ury: 712},
+					{Start: 6, End: 8, Llx: 45, Lly: 700, Urx: 58, Ury: 712},
+				}},
+				box:     PageBox{Urx: 612, Ury: 792},
+				quality: 0.9,
+				annots:  []string{"check"},
+				tables:  []Table{{Rows: [][]TableCell{{{Text: "Total"}, {Text: "42"}}}}},
+			},
+			{pageNum: 3, text: "end", quality: 1},
+		},
+	}
+
+	// Send the extraction the way an extraction worker does.
+	var buf bytes.Buffer
+	if err := json.NewEncoder(&buf).Encode(makeWorkerResult(ext)); err != nil {
+		t.Fatal(err)
+	}
+	var res workerResult
+	if err := json.Unmarshal(buf.Bytes(), &res); err != nil {
+		t.Fatal(err)
+	}
+	got := res.docExtraction("a.pdf")
+
+	if _, ok := got.err.(panicError); !ok || got.err.Error() != "nil pointer" {
+		t.Errorf("Panic wasn't sent. err=%#v", got.err)
+	}
+	if got.inPath != "a.pdf" || got.numPages != ext.numPages ||
+		!reflect.DeepEqual(got.pageErrs, ext.pageErrs) {
+		t.Errorf("got %+v", got)
+	}
+	if got.fd.Hash != ext.fd.Hash || !reflect.DeepEqual(got.fd.Extractors, ext.fd.Extractors) {
+		t.Errorf("fd: got %+v expected %+v", got.fd, ext.fd)
+	}
+	if !reflect.DeepEqual(got.pages, ext.pages) {
+		t.Errorf("pages:\n\tgot      %+v\n\texpected %+v", got.pages, ext.pages)
+	}
+}
+
+// pdfiumExtractor is a TextExtractor that isn't registered.
+type pdfiumExtractor struct {
+	UnidocExtractor
+}
+
+func (pdfiumExtractor) Extractor() Extractor {
+	return Extractor{Name: "pdfium"}
+}
+
+func TestWorkerOptions(t *testing.T) {
+	opts := IndexOptions{
+		TextExtractor:  UnidocExtractor{},
+		FileExtractors: []FileExtractor{{Pattern: "scans/*", Extractor: UnidocExtractor{}}},
+		Normalization:  NormNFKC | NormQuotes,
+		RawTextOrder:   true,
+		PageRanges:     PageRanges{{First: 1, Last: 5, Step: 2}},
+		MinTextQuality: 0.5,
+	}
+	w, err := opts.workerOptions()
+	if err != nil {
+		t.Fatalf("workerOptions failed. err=%v", err)
+	}
+	b, err := json.Marshal(w)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var w2 workerOptions
+	if err := json.Unmarshal(b, &w2); err != nil {
+		t.Fatal(err)
+	}
+	got, err := w2.indexOptions()
+	if err != nil {
+		t.Fatalf("indexOptions failed. err=%v", err)
+	}
+	if got.TextExtractor != (UnidocExtractor{}) || len(got.FileExtractors) != 1 ||
+		got.FileExtractors[0].Pattern != "scans/*" || got.Normalization != opts.Normalization ||
+		!got.RawTextOrder || !reflect.DeepEqual(got.PageRanges, opts.PageRanges) ||
+		got.MinTextQuality != opts.MinTextQuality {
+		t.Errorf("got %+v expected %+v", got, opts)
+	}
+
+	// Extractors that aren't registered can't be created by workers.
+	opts.TextExtractor = pdfiumExtractor{}
+	if _, err := opts.workerOptions(); err == nil {
+		t.Errorf("Unregistered extractor was accepted.")
+	}
+}
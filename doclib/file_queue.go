@@ -0,0 +1,217 @@
+package doclib
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/unidoc/unidoc/common"
+)
+
+// queueFileName is the name of the file queue in a store directory.
+const queueFileName = "queue.jsonl"
+
+// The events recorded in a file queue.
+const (
+	queueAdd  = "add"  // A file was queued to be indexed.
+	queueDone = "done" // A file was processed.
+)
+
+// queueEntry is a line in a file queue.
+type queueEntry struct {
+	Event  string
+	InPath string
+}
+
+// fileQueue is the queue of the files that indexing runs of a persistent store were asked to index
+// and haven't processed yet. It is a log of the files that are added to the queue and the files
+// that are processed. Files that an interrupted or crashed run didn't process are indexed by the
+// next run into the store. This complements the indexing journal, which records the documents
+// that were written to the store. See indexJournal.
+// Only files that can be read again by name are queued, not documents read from other
+// io.ReadSeekers.
+type fileQueue struct {
+	mu      sync.Mutex
+	path    string
+	f       *os.File
+	enc     *json.Encoder
+	pending map[string]bool // The queued files that haven't been processed.
+}
+
+// queuePath returns the path of the file queue in store directory `persistDir`.
+func queuePath(persistDir string) string {
+	return filepath.Join(persistDir, queueFileName)
+}
+
+// QueuedFiles returns the files that indexing runs of the store in `persistDir` were asked to
+// index but didn't process because they were interrupted. The next indexing run into the store
+// indexes them.
+func QueuedFiles(persistDir string) ([]string, error) {
+	path := queuePath(persistDir)
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Could not read %q. err=%v", path, err)
+	}
+	return pendingFiles(bytes.NewReader(b))
+}
+
+// openFileQueue opens the file queue in store directory `persistDir` and returns it and the files
+// left in it by earlier runs, in the order they were queued. The queue is compacted so that it
+// only holds those files.
+func openFileQueue(persistDir string) (*fileQueue, []string, error) {
+	queued, err := QueuedFiles(persistDir)
+	if err != nil {
+		return nil, nil, err
+	}
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	q := &fileQueue{path: queuePath(persistDir), pending: map[string]bool{}}
+	for _, inPath := range queued {
+		enc.Encode(queueEntry{Event: queueAdd, InPath: inPath})
+		q.pending[inPath] = true
+	}
+	if err := MkDir(persistDir); err != nil {
+		return nil, nil, err
+	}
+	if err := writeFileAtomic(q.path, &buf); err != nil {
+		return nil, nil, err
+	}
+	if q.f, err = os.OpenFile(q.path, os.O_WRONLY|os.O_APPEND, 0666); err != nil {
+		return nil, nil, err
+	}
+	q.enc = json.NewEncoder(q.f)
+	if len(queued) > 0 {
+		common.Log.Info("openFileQueue: %d files in %q weren't processed by earlier runs.",
+			len(queued), persistDir)
+	}
+	return q, queued, nil
+}
+
+// add adds the files in `pathList` that aren't already queued to `q`. The queue is synced to disk
+// so the files will be indexed after a crash.
+func (q *fileQueue) add(pathList []string) error {
+	if q == nil {
+		return nil
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for _, inPath := range pathList {
+		if q.pending[inPath] {
+			continue
+		}
+		if err := q.enc.Encode(queueEntry{Event: queueAdd, InPath: inPath}); err != nil {
+			return err
+		}
+		q.pending[inPath] = true
+	}
+	return q.f.Sync()
+}
+
+// done records that file `inPath` has been processed. It isn't synced to disk. A file whose
+// record is lost in a crash is processed again, which finds it in the store.
+func (q *fileQueue) done(inPath string) error {
+	if q == nil {
+		return nil
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if !q.pending[inPath] {
+		return nil
+	}
+	delete(q.pending, inPath)
+	return q.enc.Encode(queueEntry{Event: queueDone, InPath: inPath})
+}
+
+// close closes `q`. The queue is removed if it is empty.
+func (q *fileQueue) close() error {
+	if q == nil {
+		return nil
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if err := q.f.Close(); err != nil {
+		return err
+	}
+	if len(q.pending) == 0 {
+		return os.Remove(q.path)
+	}
+	return nil
+}
+
+// pendingFiles returns the files that were added to the file queue read from `r` and weren't
+// processed, in the order they were added. An incompletely written last line, such as from a
+// crash, is ignored.
+func pendingFiles(r io.Reader) ([]string, error) {
+	var order []string
+	pending := map[string]bool{}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		var e queueEntry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			common.Log.Error("pendingFiles: Bad entry %q. err=%v", scanner.Text(), err)
+			break
+		}
+		switch e.Event {
+		case queueAdd:
+			if !pending[e.InPath] {
+				order = append(order, e.InPath)
+			}
+			pending[e.InPath] = true
+		case queueDone:
+			pending[e.InPath] = false
+		}
+	}
+	var queued []string
+	for _, inPath := range order {
+		if pending[inPath] {
+			queued = append(queued, inPath)
+			pending[inPath] = false
+		}
+	}
+	return queued, scanner.Err()
+}
+
+// addQueuedFiles returns `pathList` and `rsList` with the files in `queued` that aren't in
+// `pathList` added. The added files are read by name. It also returns the files that can be
+// queued: those that are read by name or from files.
+func addQueuedFiles(pathList []string, rsList []io.ReadSeeker, queued []string) ([]string,
+	[]io.ReadSeeker, []string) {
+
+	inList := map[string]bool{}
+	for _, inPath := range pathList {
+		inList[inPath] = true
+	}
+	allPaths := append([]string(nil), pathList...)
+	var allReaders []io.ReadSeeker
+	if len(rsList) > 0 {
+		allReaders = append([]io.ReadSeeker(nil), rsList...)
+	}
+	for _, inPath := range queued {
+		if inList[inPath] {
+			continue
+		}
+		allPaths = append(allPaths, inPath)
+		if len(rsList) > 0 {
+			allReaders = append(allReaders, nil)
+		}
+	}
+
+	var queueable []string
+	for i, inPath := range allPaths {
+		if len(allReaders) == 0 || allReaders[i] == nil {
+			queueable = append(queueable, inPath)
+		} else if _, ok := allReaders[i].(*os.File); ok {
+			queueable = append(queueable, inPath)
+		}
+	}
+	return allPaths, allReaders, queueable
+}
@@ -0,0 +1,102 @@
+package doclib
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestFileQueue(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pdf-search-queue")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	q, queued, err := openFileQueue(dir)
+	if err != nil {
+		t.Fatalf("openFileQueue failed. err=%v", err)
+	}
+	if len(queued) != 0 {
+		t.Errorf("New queue has files. %q", queued)
+	}
+	if err := q.add([]string{"a.pdf", "b.pdf", "c.pdf"}); err != nil {
+		t.Fatal(err)
+	}
+	q.done("b.pdf")
+	// The run is interrupted.
+	if err := q.close(); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{"a.pdf", "c.pdf"}
+	q, queued, err = openFileQueue(dir)
+	if err != nil {
+		t.Fatalf("openFileQueue failed. err=%v", err)
+	}
+	if !reflect.DeepEqual(queued, expected) {
+		t.Errorf("got %q expected %q", queued, expected)
+	}
+	q.add([]string{"c.pdf", "d.pdf"})
+	for _, inPath := range []string{"a.pdf", "c.pdf", "d.pdf"} {
+		q.done(inPath)
+	}
+	if err := q.close(); err != nil {
+		t.Fatal(err)
+	}
+	if Exists(queuePath(dir)) {
+		t.Errorf("Empty queue wasn't removed.")
+	}
+}
+
+func TestPendingFiles(t *testing.T) {
+	log := `{"Event":"add","InPath":"a.pdf"}
+{"Event":"add","InPath":"b.pdf"}
+{"Event":"done","InPath":"a.pdf"}
+{"Event":"add","InPath":"a.pdf"}
+{"Event":"done","InPath":"b.pdf"}
+{"Event":"add","InPath":"c.p`
+	queued, err := pendingFiles(strings.NewReader(log))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []string{"a.pdf"}; !reflect.DeepEqual(queued, expected) {
+		t.Errorf("got %q expected %q", queued, expected)
+	}
+}
+
+func TestAddQueuedFiles(t *testing.T) {
+	f, err := ioutil.TempFile("", "pdf-search-queue")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	pathList := []string{"a.pdf", "mem.pdf"}
+	rsList := []io.ReadSeeker{f, bytes.NewReader(nil)}
+	paths, readers, queueable := addQueuedFiles(pathList, rsList, []string{"b.pdf", "a.pdf"})
+	if expected := []string{"a.pdf", "mem.pdf", "b.pdf"}; !reflect.DeepEqual(paths, expected) {
+		t.Errorf("paths: got %q expected %q", paths, expected)
+	}
+	if len(readers) != 3 || readers[2] != nil {
+		t.Errorf("Queued file should be opened by name. readers=%v", readers)
+	}
+	// Files read from memory can't be queued.
+	if expected := []string{"a.pdf", "b.pdf"}; !reflect.DeepEqual(queueable, expected) {
+		t.Errorf("queueable: got %q expected %q", queueable, expected)
+	}
+	if len(pathList) != 2 || len(rsList) != 2 {
+		t.Errorf("Arguments were changed.")
+	}
+
+	// Documents read by name.
+	paths, readers, queueable = addQueuedFiles([]string{"a.pdf"}, nil, []string{"b.pdf"})
+	if len(readers) != 0 || !reflect.DeepEqual(paths, queueable) || len(paths) != 2 {
+		t.Errorf("paths=%q readers=%v queueable=%q", paths, readers, queueable)
+	}
+}
@@ -3,7 +3,6 @@ package doclib
 import (
 	"crypto/sha256"
 	"encoding/hex"
-	"fmt"
 	"io"
 	"io/ioutil"
 	"os"
@@ -84,13 +83,13 @@ func (ff *FileFinder) Find(fullpath string) string {
 	name := filepath.Base(fullpath)
 	pathList, ok := ff.namePaths[name]
 	if !ok {
-		fmt.Printf("$$1 No match.   %40q : %q\n", name, fullpath)
+		common.Log.Info("Find: No match. %40q : %q", name, fullpath)
 		return ""
 	} else if len(pathList) > 1 {
 		best := longestMatchingSuffix(fullpath, pathList)
-		fmt.Printf("$$2 Duplicates. %40q:\n -- %100q\n -- %100q\n", name, fullpath, best)
+		common.Log.Info("Find: Duplicates. %40q:\n -- %100q\n -- %100q", name, fullpath, best)
 		for i, p := range pathList {
-			fmt.Printf("%6d: %q\n", i, p)
+			common.Log.Info("%6d: %q", i, p)
 		}
 		return best
 	}
@@ -272,46 +271,3 @@ func Reverse(arr []string) []string {
 	}
 	return rev
 }
-
-// CleanCorpus returns `corpus` with known bad files removed.
-func CleanCorpus(corpus []string) []string {
-	var cleaned []string
-	for _, path := range corpus {
-		keep := true
-		for _, bad := range badFiles {
-			if strings.Contains(path, bad) {
-				keep = false
-			}
-		}
-		if keep {
-			cleaned = append(cleaned, path)
-		}
-	}
-	return cleaned
-}
-
-var badFiles = []string{
-	"bookmarks_circular.pdf",            // Stack overflow in reader
-	"4865ab395ed664c3ee17.pdf",          // Stack overflow in image forms
-	"circularReferencesInResources.pdf", // Stack overflow in image forms
-	"mrm-icdar.pdf",                     // !@#$
-	"ghmt.pdf",                          // !@#$
-	"SA_implementations.pdf",            // !@#$
-	"naacl06-shinyama.pdf",              // !@#$
-	"a_im_",                             // !@#$
-	"CaiHof-CIKM2004.pdf",
-	"blurhmt.pdf",
-	"ESCP-R reference_151008.pdf",
-	"a_imagemask.pdf",
-	"sample_chapter_verilab_aop_cookbook.pdf",
-	"TWISCKeyDist.pdf",
-	"ergodicity/1607.04968.pdf",
-	"1812.09449.pdf",                         // hangs
-	"INF586.pdf",                             // hangs
-	"commercial-invoice-template-230kb.pdf",  // r=invalid pad length
-	"CGU_Motor_Vehicle_Insurance_Policy.pdf", // r=invalid pad length
-	"Forerunner_230_OM_EN.pdf} r=invalid",    // r=invalid pad length
-	"transitions_test.pdf",                   //required attribute missing (No /Type/Font )
-	"page_tree_multiple_levels.pdf",          //required attribute missing
-
-}
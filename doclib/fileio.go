@@ -6,6 +6,8 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+
+	"github.com/unidoc/unidoc/common"
 )
 
 // MkParentDir creates the parent directory for `filename` if it doesn't already exist.
@@ -19,14 +21,14 @@ func MkDir(dir string) error {
 	_, err := os.Stat(dir)
 	if !os.IsNotExist(err) {
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "MkDir: Stat failed. dir=%q err=%v\n", dir, err)
+			common.Log.Error("MkDir: Stat failed. dir=%q err=%v", dir, err)
 			return err
 		}
 		return nil
 	}
 	err = os.Mkdir(dir, 0777)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "MkDir: Mkdir failed. dir=%q err=%v\n", dir, err)
+		common.Log.Error("MkDir: Mkdir failed. dir=%q err=%v", dir, err)
 	}
 	return err
 }
@@ -35,7 +37,7 @@ func MkDir(dir string) error {
 func Exists(filename string) bool {
 	_, err := os.Stat(filename)
 	if err != nil && !os.IsNotExist(err) {
-		fmt.Fprintf(os.Stderr, "Exists: Stat failed. filename=%q err=%v\n", filename, err)
+		common.Log.Error("Exists: Stat failed. filename=%q err=%v", filename, err)
 	}
 	return err == nil
 }
@@ -48,10 +50,10 @@ func WriteJsonSlice(filename string, vals []string) error {
 	whole := strings.Join(parts, "\n")
 	err := ioutil.WriteFile(filename, []byte(whole), 0777)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "WriteJsonSlice: WriteFile failed filename=%q err=%v\n", filename, err)
+		common.Log.Error("WriteJsonSlice: WriteFile failed filename=%q err=%v", filename, err)
 		return err
 	}
-	fmt.Printf("WriteJsonSlice: Saved %d entries to %q\n", len(vals), filename)
+	common.Log.Info("WriteJsonSlice: Saved %d entries to %q", len(vals), filename)
 	return nil
 }
 
@@ -69,7 +71,7 @@ func ChangePathDirExt(inDir, inPath, outDir, outExt string) (string, error) {
 func ChangePathDir(inDir, inPath, outDir string) (string, error) {
 	rel, err := filepath.Rel(inDir, inPath)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "ChangePathDir: Rel failed. inPath=%q inDir=%q err=%v\n",
+		common.Log.Error("ChangePathDir: Rel failed. inPath=%q inDir=%q err=%v",
 			inPath, inDir, err)
 		return "", err
 	}
@@ -83,9 +85,19 @@ func ChangePathExt(inPath, outExt string) string {
 
 // RemoveDirectory recursively removes directory `dir` and its contents from disk.
 func RemoveDirectory(dir string) error {
+	if err := removeDirContents(dir, ""); err != nil {
+		return err
+	}
+	return os.Remove(dir)
+}
+
+// removeDirContents removes everything in directory `dir` except the file named `keep`. It has
+// the same safety checks as RemoveDirectory.
+func removeDirContents(dir, keep string) error {
 	if dir == "" || strings.HasPrefix(dir, ".") || strings.HasPrefix(dir, "/") {
 		full, _ := filepath.Abs(dir)
-		panic(fmt.Errorf("RemoveDirectory: Suspicious dir=%q (%q)", dir, full))
+		common.Log.Error("RemoveDirectory: Suspicious dir=%q (%q)", dir, full)
+		return fmt.Errorf("RemoveDirectory: Suspicious dir=%q", dir)
 	}
 	d, err := os.Open(dir)
 	if err != nil {
@@ -97,10 +109,26 @@ func RemoveDirectory(dir string) error {
 		return err
 	}
 	for _, name := range names {
+		if name == keep {
+			continue
+		}
 		err = os.RemoveAll(filepath.Join(dir, name))
 		if err != nil {
 			return err
 		}
 	}
-	return os.Remove(dir)
+	return nil
+}
+
+// syncFile commits the contents of file `filename` to disk.
+func syncFile(filename string) error {
+	f, err := os.OpenFile(filename, os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
 }
@@ -0,0 +1,130 @@
+package doclib
+
+import (
+	"strings"
+
+	"github.com/unidoc/unidoc/pdf/core"
+	pdf "github.com/unidoc/unidoc/pdf/model"
+)
+
+// FormField is a filled in field of a PDF's interactive (AcroForm) form.
+type FormField struct {
+	// Name is the field's fully qualified name, the names of the field and its ancestors joined by
+	// periods, e.g. "invoice.total".
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// maxFormFields is the maximum number of form field dictionaries read from a PDF. It stops
+// malformed field trees with cycles from being read forever.
+const maxFormFields = 10000
+
+// ReadFormFields returns the form fields with values in the PDF in `pdfReader` in field tree
+// order. Unchecked check boxes and empty fields are omitted.
+func ReadFormFields(pdfReader *pdf.PdfReader) ([]FormField, error) {
+	trailer, err := pdfReader.GetTrailer()
+	if err != nil {
+		return nil, err
+	}
+	root, ok := core.GetDict(trailer.Get("Root"))
+	if !ok {
+		return nil, nil
+	}
+	acroForm, ok := core.GetDict(root.Get("AcroForm"))
+	if !ok {
+		return nil, nil
+	}
+	return readFormFieldList(acroForm.Get("Fields")), nil
+}
+
+// readFormFieldList returns the form fields with values in `fields`, an AcroForm Fields array.
+func readFormFieldList(fields core.PdfObject) []FormField {
+	r := formReader{seen: map[*core.PdfObjectDictionary]bool{}}
+	r.readFields(fields, "")
+	return r.fields
+}
+
+// formReader reads the fields in a PDF form's field tree.
+type formReader struct {
+	seen   map[*core.PdfObjectDictionary]bool // Field dictionaries that have been read.
+	fields []FormField
+}
+
+// readFields reads the fields in array `obj` and their descendants. `parent` is the fully
+// qualified name of the fields' parent.
+func (r *formReader) readFields(obj core.PdfObject, parent string) {
+	arr, ok := core.GetArray(obj)
+	if !ok {
+		return
+	}
+	for i := 0; i < arr.Len(); i++ {
+		field, ok := core.GetDict(arr.Get(i))
+		if !ok || r.seen[field] || len(r.seen) >= maxFormFields {
+			continue
+		}
+		r.seen[field] = true
+		name := parent
+		if t, ok := core.GetStringVal(field.Get("T")); ok && t != "" {
+			name = decodePdfText(t)
+			if parent != "" {
+				name = parent + "." + name
+			}
+		}
+		// Widget annotations, the kids that have no names, don't have values of their own.
+		if value := formFieldValue(field.Get("V")); value != "" && name != "" {
+			r.fields = append(r.fields, FormField{Name: name, Value: value})
+		}
+		r.readFields(field.Get("Kids"), name)
+	}
+}
+
+// formFieldValue returns the text of form field value `obj`. Text fields have string values,
+// check boxes and radio buttons have name values and multiple selection lists have arrays. The
+// value of unchecked boxes, Off, is returned as "".
+func formFieldValue(obj core.PdfObject) string {
+	switch v := core.TraceToDirectObject(obj).(type) {
+	case *core.PdfObjectString:
+		s, _ := core.GetStringVal(v)
+		return strings.TrimSpace(decodePdfText(s))
+	case *core.PdfObjectName:
+		if s := string(*v); s != "Off" {
+			return s
+		}
+	case *core.PdfObjectArray:
+		var values []string
+		for i := 0; i < v.Len(); i++ {
+			if s := formFieldValue(v.Get(i)); s != "" {
+				values = append(values, s)
+			}
+		}
+		return strings.Join(values, ", ")
+	}
+	return ""
+}
+
+// formFieldValues returns the values of `fields`. They are the FormFields field of the bleve page
+// documents, so the array position of a matched value is the index of its field in `fields`.
+func formFieldValues(fields []FormField) []string {
+	if len(fields) == 0 {
+		return nil
+	}
+	values := make([]string, len(fields))
+	for i, f := range fields {
+		values[i] = f.Value
+	}
+	return values
+}
+
+// formFieldName returns the name of the form field of `lDoc` whose value is at array position
+// `i` in the FormFields field of its bleve page documents, or "" if there isn't one.
+func (lDoc *Doc) formFieldName(i int) string {
+	fileList := lDoc.lState.fileList
+	if int(lDoc.docIdx) >= len(fileList) {
+		return ""
+	}
+	fields := fileList[lDoc.docIdx].FormFields
+	if i < 0 || i >= len(fields) {
+		return ""
+	}
+	return fields[i].Name
+}
@@ -0,0 +1,56 @@
+package doclib
+
+import (
+	"testing"
+
+	"github.com/unidoc/unidoc/pdf/core"
+)
+
+// makeField returns a form field dictionary with name `t`, value `v` and kids `kids`. `t` and `v`
+// are omitted if they are empty and nil.
+func makeField(t string, v core.PdfObject, kids ...core.PdfObject) *core.PdfObjectDictionary {
+	field := core.MakeDict()
+	if t != "" {
+		field.Set("T", core.MakeString(t))
+	}
+	if v != nil {
+		field.Set("V", v)
+	}
+	if len(kids) > 0 {
+		field.Set("Kids", core.MakeArray(kids...))
+	}
+	return field
+}
+
+func TestReadFormFields(t *testing.T) {
+	invoice := makeField("invoice", nil,
+		makeField("number", core.MakeString(" INV-2041 ")),
+		makeField("total", core.MakeString("1,250.00")),
+		makeField("paid", core.MakeName("Off")),
+		makeField("notes", core.MakeString("")))
+	// A radio button group whose value is on the group and whose kids are widgets.
+	shipping := makeField("shipping", core.MakeName("Express"), makeField("", nil), makeField("", nil))
+	colors := makeField("colors", core.MakeArray(core.MakeString("red"), core.MakeString("blue")))
+	fields := readFormFieldList(core.MakeArray(invoice, shipping, colors, invoice))
+
+	expected := []FormField{
+		{Name: "invoice.number", Value: "INV-2041"},
+		{Name: "invoice.total", Value: "1,250.00"},
+		{Name: "shipping", Value: "Express"},
+		{Name: "colors", Value: "red, blue"},
+	}
+	if len(fields) != len(expected) {
+		t.Fatalf("got %d fields expected %d. %+v", len(fields), len(expected), fields)
+	}
+	for i, f := range fields {
+		if f != expected[i] {
+			t.Errorf("%d: got %+v expected %+v", i, f, expected[i])
+		}
+	}
+	if values := formFieldValues(fields); len(values) != len(fields) || values[2] != "Express" {
+		t.Errorf("formFieldValues: %q", values)
+	}
+	if fields := readFormFieldList(nil); len(fields) != 0 {
+		t.Errorf("no form: %+v", fields)
+	}
+}
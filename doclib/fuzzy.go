@@ -0,0 +1,65 @@
+package doclib
+
+import (
+	"unicode/utf8"
+
+	"github.com/blevesearch/bleve"
+	"github.com/blevesearch/bleve/search/query"
+)
+
+// maxFuzziness is the largest edit distance bleve supports for fuzzy queries.
+const maxFuzziness = 2
+
+// minFuzzyRunes is the length of the shortest terms that fuzzy searches match approximately.
+// Shorter terms would match too many unrelated words.
+const minFuzzyRunes = 4
+
+// fuzzyBoost is the boost of approximate matches relative to exact matches.
+const fuzzyBoost = 0.5
+
+// fuzzyQuery returns `q`, the query for query string `term` over `index`, extended to also match
+// pages with words that are within `fuzziness` edits of the terms in `term`, e.g. OCR errors.
+// Exact matches score higher than approximate matches. Queries with field scopes are not
+// extended.
+func fuzzyQuery(index bleve.Index, q query.Query, term string, fuzziness int) query.Query {
+	if hasFieldScope(term) {
+		return q
+	}
+	disjuncts := []query.Query{q}
+	for _, t := range analyzeTerms(index, term) {
+		if fq := approxTermQuery(t, fuzziness); fq != nil {
+			disjuncts = append(disjuncts, fq)
+		}
+	}
+	if len(disjuncts) == 1 {
+		return q
+	}
+	return bleve.NewDisjunctionQuery(disjuncts...)
+}
+
+// fuzzyTermQuery returns a query that matches analyzed term `t` exactly or, with a lower score,
+// within `fuzziness` edits.
+func fuzzyTermQuery(t string, fuzziness int) query.Query {
+	exact := bleve.NewTermQuery(t)
+	fq := approxTermQuery(t, fuzziness)
+	if fq == nil {
+		return exact
+	}
+	return bleve.NewDisjunctionQuery(exact, fq)
+}
+
+// approxTermQuery returns a query that matches the words within `fuzziness` edits of analyzed
+// term `t` or nil if `t` is too short to be matched approximately. `fuzziness` is capped at
+// maxFuzziness.
+func approxTermQuery(t string, fuzziness int) query.Query {
+	if fuzziness <= 0 || utf8.RuneCountInString(t) < minFuzzyRunes {
+		return nil
+	}
+	if fuzziness > maxFuzziness {
+		fuzziness = maxFuzziness
+	}
+	fq := bleve.NewFuzzyQuery(t)
+	fq.SetFuzziness(fuzziness)
+	fq.SetBoost(fuzzyBoost)
+	return fq
+}
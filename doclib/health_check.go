@@ -0,0 +1,216 @@
+package doclib
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/unidoc/unidoc/common"
+)
+
+// The self test PDF has one US Letter page with selfTestText drawn in 24 point Helvetica with its
+// baseline starting at (72, 700).
+const (
+	selfTestText  = "The quick brown fox jumps over the lazy dog"
+	selfTestTerm  = "fox"
+	selfTestX     = 72.0
+	selfTestY     = 700.0
+	selfTestSize  = 24.0
+	selfTestWidth = 612.0
+)
+
+// SelfTestStep is the result of a step of SelfTest.
+type SelfTestStep struct {
+	Name     string
+	Err      error // nil if the step passed.
+	Duration time.Duration
+}
+
+// SelfTestReport is the result of SelfTest.
+type SelfTestReport struct {
+	Passed bool
+	Steps  []SelfTestStep
+}
+
+func (r SelfTestReport) String() string {
+	parts := []string{fmt.Sprintf("passed=%t", r.Passed)}
+	for _, s := range r.Steps {
+		status := "ok"
+		if s.Err != nil {
+			status = s.Err.Error()
+		}
+		parts = append(parts, fmt.Sprintf("%10s: %6.3f sec %s", s.Name, s.Duration.Seconds(),
+			status))
+	}
+	return strings.Join(parts, "\n")
+}
+
+// SelfTest checks that the library works in the current environment. It indexes a small built-in
+// PDF into a new store in `persistDir`, searches it, checks the text positions of the match and
+// writes a highlighted copy of the PDF. Services that embed the library can call it at startup to
+// catch broken PDF extraction or file system environments early.
+// `persistDir` must not exist. It is deleted when SelfTest returns. If `persistDir` is "" a
+// temporary directory is used.
+// The returned error is the error of the first step that failed.
+func SelfTest(persistDir string) (SelfTestReport, error) {
+	var report SelfTestReport
+	if persistDir != "" && Exists(persistDir) {
+		return report, fmt.Errorf("Self test store %q already exists", persistDir)
+	}
+	workDir, err := ioutil.TempDir("", "pdf-search-selftest")
+	if err != nil {
+		return report, err
+	}
+	defer os.RemoveAll(workDir)
+	if persistDir == "" {
+		persistDir = filepath.Join(workDir, "store.selftest")
+	}
+	defer os.RemoveAll(persistDir)
+
+	inPath := filepath.Join(workDir, "selftest.pdf")
+	outPath := filepath.Join(workDir, "selftest.highlight.pdf")
+	var results PdfMatchSet
+	steps := []struct {
+		name string
+		run  func() error
+	}{
+		{"write", func() error { return ioutil.WriteFile(inPath, selfTestPdf(), 0666) }},
+		{"index", func() error { return selfTestIndex(inPath, persistDir) }},
+		{"search", func() (err error) {
+			results, err = selfTestSearch(persistDir)
+			return err
+		}},
+		{"positions", func() error { return checkSelfTestMatch(results.Matches[0]) }},
+		{"highlight", func() error { return checkSelfTestHighlight(inPath, outPath, results) }},
+	}
+	for _, s := range steps {
+		t0 := time.Now()
+		err := s.run()
+		report.Steps = append(report.Steps, SelfTestStep{Name: s.name, Err: err,
+			Duration: time.Since(t0)})
+		if err != nil {
+			common.Log.Error("SelfTest: %s failed. err=%v", s.name, err)
+			return report, fmt.Errorf("self test %s failed. err=%v", s.name, err)
+		}
+	}
+	report.Passed = true
+	return report, nil
+}
+
+// selfTestIndex indexes PDF `inPath` into a new store in `persistDir`.
+func selfTestIndex(inPath, persistDir string) error {
+	opts := DefaultIndexOptions()
+	opts.NumWorkers = 1
+	var indexReport IndexReport
+	opts.Report = &indexReport
+	lState, index, numPages, err := IndexPdfFilesOpts([]string{inPath}, persistDir, true, false,
+		opts, func(string) {})
+	if err != nil {
+		return err
+	}
+	lState.Flush()
+	index.Close()
+	if numPages != 1 {
+		return fmt.Errorf("indexed %d pages, expected 1. %s", numPages, indexReport)
+	}
+	return nil
+}
+
+// selfTestSearch searches the self test store in `persistDir` for selfTestTerm.
+func selfTestSearch(persistDir string) (PdfMatchSet, error) {
+	x, err := OpenPdfIndex(persistDir)
+	if err != nil {
+		return PdfMatchSet{}, err
+	}
+	defer x.Close()
+	results, err := x.Search(selfTestTerm, SearchOptions{MaxResults: 10})
+	if err != nil {
+		return results, err
+	}
+	if len(results.Matches) != 1 {
+		return results, fmt.Errorf("%d matches for %q, expected 1", len(results.Matches),
+			selfTestTerm)
+	}
+	return results, nil
+}
+
+// checkSelfTestMatch returns an error if `m` isn't the match of selfTestTerm on the self test
+// page.
+func checkSelfTestMatch(m PdfMatch) error {
+	if m.PageNum != 1 {
+		return fmt.Errorf("match on page %d, expected page 1", m.PageNum)
+	}
+	if !strings.Contains(m.Line, selfTestTerm) {
+		return fmt.Errorf("matched line %q doesn't contain %q", m.Line, selfTestTerm)
+	}
+	if len(m.Positions) == 0 {
+		return errors.New("no positions")
+	}
+	pos := m.Positions[0]
+	// The term is on the text line so its box must be inside the line's box.
+	llx, lly := float32(selfTestX), float32(selfTestY-selfTestSize/2)
+	urx, ury := float32(selfTestWidth), float32(selfTestY+selfTestSize*1.5)
+	if !(llx <= pos.Llx && pos.Llx < pos.Urx && pos.Urx <= urx &&
+		lly <= pos.Lly && pos.Lly < pos.Ury && pos.Ury <= ury) {
+		return fmt.Errorf("bad position %s for %q. expected inside [%g %g %g %g]", pos,
+			selfTestTerm, llx, lly, urx, ury)
+	}
+	return nil
+}
+
+// checkSelfTestHighlight writes a copy of self test PDF `inPath` with the matches in `results`
+// highlighted to `outPath` and returns an error if it isn't a PDF with a highlight.
+func checkSelfTestHighlight(inPath, outPath string, results PdfMatchSet) error {
+	if err := HighlightPdf(inPath, outPath, results.Matches, DefaultMarkupStyle()); err != nil {
+		return err
+	}
+	pdfReader, err := PdfOpenFile(outPath, false)
+	if err != nil {
+		return err
+	}
+	if numPages, err := pdfReader.GetNumPages(); err != nil || numPages != 1 {
+		return fmt.Errorf("highlighted PDF has %d pages, expected 1. err=%v", numPages, err)
+	}
+	b, err := ioutil.ReadFile(outPath)
+	if err != nil {
+		return err
+	}
+	if !bytes.Contains(b, []byte("/Highlight")) {
+		return errors.New("highlighted PDF has no highlight annotation")
+	}
+	return nil
+}
+
+// selfTestPdf returns the self test PDF.
+func selfTestPdf() []byte {
+	content := fmt.Sprintf("BT /F1 %g Tf %g %g Td (%s) Tj ET", selfTestSize, selfTestX,
+		selfTestY, selfTestText)
+	objects := []string{
+		"<< /Type /Catalog /Pages 2 0 R >>",
+		"<< /Type /Pages /Kids [3 0 R] /Count 1 >>",
+		"<< /Type /Page /Parent 2 0 R /MediaBox [0 0 612 792] " +
+			"/Resources << /Font << /F1 4 0 R >> >> /Contents 5 0 R >>",
+		"<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica >>",
+		fmt.Sprintf("<< /Length %d >>\nstream\n%s\nendstream", len(content), content),
+	}
+	var b bytes.Buffer
+	b.WriteString("%PDF-1.4\n")
+	offsets := make([]int, len(objects))
+	for i, obj := range objects {
+		offsets[i] = b.Len()
+		fmt.Fprintf(&b, "%d 0 obj\n%s\nendobj\n", i+1, obj)
+	}
+	xref := b.Len()
+	fmt.Fprintf(&b, "xref\n0 %d\n0000000000 65535 f \n", len(objects)+1)
+	for _, offset := range offsets {
+		fmt.Fprintf(&b, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(&b, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n",
+		len(objects)+1, xref)
+	return b.Bytes()
+}
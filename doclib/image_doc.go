@@ -0,0 +1,125 @@
+package doclib
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/peterwilliams97/pdf-search/serial"
+	"github.com/unidoc/unidoc/common"
+)
+
+// ErrNoImageOCR is returned when an image file is indexed without an IndexOptions.OCR that
+// implements ImageOCR.
+var ErrNoImageOCR = errors.New("image files need an OCR that can recognize images")
+
+// imageExtensions are the file extensions of the image files that can be indexed.
+var imageExtensions = map[string]bool{
+	".tif":  true,
+	".tiff": true,
+	".png":  true,
+	".jpg":  true,
+	".jpeg": true,
+}
+
+// IsImageFile returns true if `inPath` has the extension of an image file that can be indexed.
+// Image files are indexed as documents with a single page whose text is recognized with OCR.
+func IsImageFile(inPath string) bool {
+	return imageExtensions[strings.ToLower(filepath.Ext(inPath))]
+}
+
+// ImageOCR is a PageOCR that can also recognize the text in image files such as scans. Set
+// IndexOptions.OCR to an ImageOCR to index TIFF, PNG and JPEG files along with PDFs.
+type ImageOCR interface {
+	PageOCR
+	// RecognizeImage returns the words in the image in `data`, which is the contents of image
+	// file `inPath`, and the width and height of the image in points. The word bounding boxes are
+	// in points with the origin at the bottom left of the image, like the coordinates of a PDF
+	// page that the image fills.
+	RecognizeImage(inPath string, data []byte) (words []OCRWord, width, height float64, err error)
+}
+
+// extractImageDoc extracts the text and text locations from image file `inPath` which is read from
+// `rs` and described by `fd`. The image is a document with a single page whose text is recognized
+// with opts.OCR. Extraction started at `t0`.
+func extractImageDoc(inPath string, rs io.ReadSeeker, fd FileDesc, opts IndexOptions,
+	t0 time.Time) docExtraction {
+
+	ext := docExtraction{inPath: inPath, fd: fd, numPages: 1}
+	if !opts.PageRanges.Contains(1) {
+		ext.numPages = 0
+		ext.duration = time.Since(t0)
+		return ext
+	}
+	ocr, ok := opts.OCR.(ImageOCR)
+	if !ok {
+		ext.err = ErrNoImageOCR
+		ext.duration = time.Since(t0)
+		return ext
+	}
+	pe, err := extractImagePage(ocr, inPath, rs, opts.Normalization)
+	if err != nil {
+		ext.pageErrs = append(ext.pageErrs, fmt.Sprintf("page 1: %v", err))
+	} else if pe.text != "" {
+		ext.fd.Extractors = addExtractor(ext.fd.Extractors, ocr.Extractor())
+		ext.pages = append(ext.pages, pe)
+	}
+	ext.duration = time.Since(t0)
+	return ext
+}
+
+// extractImagePage returns the synthetic page of image file `inPath` which is read from `rs`. Its
+// text is recognized by `ocr` and normalized with `normalization`, and its page box is the image.
+func extractImagePage(ocr ImageOCR, inPath string, rs io.ReadSeeker,
+	normalization TextNormalization) (pageExtraction, error) {
+
+	if _, err := rs.Seek(0, io.SeekStart); err != nil {
+		return pageExtraction{}, err
+	}
+	data, err := ioutil.ReadAll(rs)
+	if err != nil {
+		return pageExtraction{}, err
+	}
+	if !isImageData(data) {
+		return pageExtraction{}, fmt.Errorf("not a TIFF, PNG or JPEG file")
+	}
+	words, width, height, err := ocr.RecognizeImage(inPath, data)
+	if err != nil {
+		common.Log.Error("extractImagePage: OCR failed. inPath=%q err=%v", inPath, err)
+		return pageExtraction{}, fmt.Errorf("OCR: %v", err)
+	}
+	var dpl serial.DocPageLocations
+	text, locations := ocrWordsText(words)
+	text, locations = joinHyphenated(text, locations)
+	text, locations = normalization.normalizePage(text, locations)
+	text, dpl.Locations = canonicalPageText(text, locations)
+	dpl.Locations = wordLocations(text, dpl.Locations)
+	common.Log.Debug("extractImagePage: inPath=%q words=%d text=%d", inPath, len(words), len(text))
+	return pageExtraction{
+		pageNum: 1,
+		text:    text,
+		dpl:     dpl,
+		box:     PageBox{Urx: width, Ury: height},
+		quality: TextQuality(text),
+		tables:  detectTables(text, dpl.Locations),
+	}, nil
+}
+
+// isImageData returns true if `data` starts with the signature of a TIFF, PNG or JPEG file.
+func isImageData(data []byte) bool {
+	for _, sig := range [][]byte{
+		[]byte("II*\x00"), []byte("MM\x00*"), // TIFF
+		[]byte("\x89PNG\r\n\x1a\n"), // PNG
+		[]byte("\xff\xd8\xff"),      // JPEG
+	} {
+		if bytes.HasPrefix(data, sig) {
+			return true
+		}
+	}
+	return false
+}
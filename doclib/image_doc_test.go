@@ -0,0 +1,42 @@
+package doclib
+
+import "testing"
+
+func TestIsImageFile(t *testing.T) {
+	tests := []struct {
+		inPath string
+		image  bool
+	}{
+		{"scans/page1.tif", true},
+		{"scans/PAGE2.TIFF", true},
+		{"photo.jpeg", true},
+		{"photo.JPG", true},
+		{"diagram.png", true},
+		{"paper.pdf", false},
+		{"png", false},
+	}
+	for _, test := range tests {
+		if image := IsImageFile(test.inPath); image != test.image {
+			t.Errorf("inPath=%q image=%t expected=%t", test.inPath, image, test.image)
+		}
+	}
+}
+
+func TestIsImageData(t *testing.T) {
+	tests := []struct {
+		data  string
+		image bool
+	}{
+		{"II*\x00\x08\x00\x00\x00", true},
+		{"MM\x00*\x00\x00\x00\x08", true},
+		{"\x89PNG\r\n\x1a\n\x00\x00", true},
+		{"\xff\xd8\xff\xe0\x00\x10JFIF", true},
+		{"%PDF-1.7", false},
+		{"", false},
+	}
+	for _, test := range tests {
+		if image := isImageData([]byte(test.data)); image != test.image {
+			t.Errorf("data=%q image=%t expected=%t", test.data, image, test.image)
+		}
+	}
+}
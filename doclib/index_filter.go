@@ -0,0 +1,37 @@
+package doclib
+
+import (
+	"fmt"
+	"io"
+	"path/filepath"
+)
+
+// excludeFile returns the reason PDF file `inPath`, which is read from `rs`, should not be indexed
+// with options `opts` or "" if it should be indexed.
+func (opts IndexOptions) excludeFile(inPath string, rs io.ReadSeeker) (string, error) {
+	base := filepath.Base(inPath)
+	for _, pattern := range opts.Exclude {
+		for _, name := range []string{inPath, base} {
+			matched, err := filepath.Match(pattern, name)
+			if err != nil {
+				return "", fmt.Errorf("Bad exclusion pattern %q. err=%v", pattern, err)
+			}
+			if matched {
+				return fmt.Sprintf("excluded by %q", pattern), nil
+			}
+		}
+	}
+	if opts.MaxFileMB > 0 {
+		size, err := rs.Seek(0, io.SeekEnd)
+		if err != nil {
+			return "", err
+		}
+		if _, err := rs.Seek(0, io.SeekStart); err != nil {
+			return "", err
+		}
+		if sizeMB := float64(size) / 1024.0 / 1024.0; sizeMB > opts.MaxFileMB {
+			return fmt.Sprintf("%.1f MB is larger than %.1f MB", sizeMB, opts.MaxFileMB), nil
+		}
+	}
+	return "", nil
+}
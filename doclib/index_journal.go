@@ -0,0 +1,245 @@
+package doclib
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/blevesearch/bleve"
+	"github.com/unidoc/unidoc/common"
+)
+
+// journalFileName is the name of the indexing journal in a store directory.
+const journalFileName = "journal.jsonl"
+
+// The events recorded in an indexing journal. A document's events are always in this order.
+const (
+	journalStart = "start" // A document is about to be written to the store.
+	// journalPositions means a document's positions files have been committed to disk. Its pages
+	// may not have been committed to the bleve index.
+	journalPositions = "positions"
+	journalDone      = "done" // A document has been completely written to the store.
+)
+
+// journalEntry is a line in an indexing journal.
+type journalEntry struct {
+	Event    string
+	DocIdx   uint64
+	NumPages int
+	FD       FileDesc
+}
+
+// indexJournal records the progress of indexing in a store so that indexing can be resumed after
+// a crash. file_list.json is only saved periodically but every document that is completely
+// written to the store is recorded in the journal.
+// Each document is written to the positions store and then to the bleve index, never both at
+// once, and the journal records which of them have been committed. This commit barrier means a
+// crash can't leave the bleve index with pages whose positions are missing without the journal
+// saying so.
+type indexJournal struct {
+	path string
+	f    *os.File
+	enc  *json.Encoder
+}
+
+// journalPath returns the path of the indexing journal in store directory `persistDir`.
+func journalPath(persistDir string) string {
+	return filepath.Join(persistDir, journalFileName)
+}
+
+// openJournal opens the indexing journal in store directory `persistDir` for appending.
+func openJournal(persistDir string) (*indexJournal, error) {
+	if err := MkDir(persistDir); err != nil {
+		return nil, err
+	}
+	path := journalPath(persistDir)
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
+	if err != nil {
+		return nil, err
+	}
+	return &indexJournal{path: path, f: f, enc: json.NewEncoder(f)}, nil
+}
+
+// start records that `numPages` pages of document `fd` with index `docIdx` are about to be
+// written.
+func (j *indexJournal) start(docIdx uint64, fd FileDesc, numPages int) error {
+	if j == nil {
+		return nil
+	}
+	return j.enc.Encode(journalEntry{Event: journalStart, DocIdx: docIdx, NumPages: numPages, FD: fd})
+}
+
+// positions records that the positions files of document `fd` with index `docIdx` have been
+// committed.
+func (j *indexJournal) positions(docIdx uint64, fd FileDesc) error {
+	if j == nil {
+		return nil
+	}
+	if err := j.enc.Encode(journalEntry{Event: journalPositions, DocIdx: docIdx, FD: fd}); err != nil {
+		return err
+	}
+	return j.f.Sync()
+}
+
+// done records that document `fd` with index `docIdx` has been completely written.
+// The journal is synced to disk so the document won't be indexed again after a crash.
+func (j *indexJournal) done(docIdx uint64, fd FileDesc) error {
+	if j == nil {
+		return nil
+	}
+	if err := j.enc.Encode(journalEntry{Event: journalDone, DocIdx: docIdx, FD: fd}); err != nil {
+		return err
+	}
+	return j.f.Sync()
+}
+
+// close closes `j`. If `complete` is true, indexing finished and the store's file_list.json has
+// been saved so the journal is no longer needed and is removed.
+func (j *indexJournal) close(complete bool) error {
+	if j == nil {
+		return nil
+	}
+	if err := j.f.Close(); err != nil {
+		return err
+	}
+	if complete {
+		return os.Remove(j.path)
+	}
+	return nil
+}
+
+// readJournal returns the entries in the indexing journal in store directory `persistDir`.
+// An incompletely written last line, such as from a crash, is ignored.
+func readJournal(persistDir string) ([]journalEntry, error) {
+	f, err := os.Open(journalPath(persistDir))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+
+	var entries []journalEntry
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 16*1024*1024)
+	for scanner.Scan() {
+		var e journalEntry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			common.Log.Error("readJournal: Bad entry %q. err=%v", scanner.Text(), err)
+			break
+		}
+		entries = append(entries, e)
+	}
+	return entries, scanner.Err()
+}
+
+// replayJournal brings `lState` and `index` up to date with the indexing journal in `lState`'s
+// store directory after an indexing run that didn't complete.
+//  - Documents that were completely written but were not saved in file_list.json are added to it.
+//  - The partially written document, if any, is re-indexed in `index` from its positions if they
+//    were committed. Otherwise it is removed from `lState` and `index` so it can be indexed again.
+func (lState *Store) replayJournal(index bleve.Index) error {
+	entries, err := readJournal(lState.root)
+	if err != nil || len(entries) == 0 {
+		return err
+	}
+
+	var incomplete *journalEntry
+	committed := false // Were the positions of the `incomplete` document committed?
+	for i, e := range entries {
+		switch e.Event {
+		case journalStart:
+			incomplete = &entries[i]
+			committed = false
+		case journalPositions:
+			committed = incomplete != nil && incomplete.DocIdx == e.DocIdx
+		case journalDone:
+			incomplete = nil
+			if e.DocIdx == uint64(len(lState.fileList)) {
+				lState.addFile(e.FD)
+			}
+		}
+	}
+	common.Log.Info("replayJournal: %d entries. %d documents. incomplete=%t",
+		len(entries), len(lState.fileList), incomplete != nil)
+
+	if incomplete != nil && committed {
+		if err := lState.recommitDoc(index, *incomplete); err != nil {
+			common.Log.Error("replayJournal: Could not re-index %q from its positions. err=%v",
+				incomplete.FD.InPath, err)
+		} else {
+			incomplete = nil
+		}
+	}
+	if incomplete != nil {
+		docIdx := incomplete.DocIdx
+		b := newBatcher(index, maxBatchOps)
+		for pageIdx := 0; pageIdx < incomplete.NumPages; pageIdx++ {
+			if err := b.delete(pageID(docIdx, uint32(pageIdx))); err != nil {
+				return err
+			}
+		}
+		if err := b.flush(); err != nil {
+			return err
+		}
+		// file_list.json may have been saved after the incomplete document was added.
+		if docIdx < uint64(len(lState.fileList)) {
+			lState.truncateFileList(docIdx)
+		}
+		lDoc := Doc{lState: lState, inPath: incomplete.FD.InPath, docIdx: docIdx}
+		lDoc.docPersist = lState.docPersistPaths(incomplete.FD.Hash)
+		if err := lDoc.removeFiles(); err != nil {
+			return err
+		}
+	}
+
+	// The page text reference counts are only saved by Flush() so they don't include the documents
+	// that were written after the last Flush().
+	if err := lState.recountTextRefs(); err != nil {
+		return err
+	}
+
+	if err := lState.Flush(); err != nil {
+		return err
+	}
+	return os.Remove(journalPath(lState.root))
+}
+
+// recommitDoc adds the pages of the document in journal entry `e`, whose positions were committed
+// but whose pages may not all have been committed to `index`, to `index` from its positions.
+func (lState *Store) recommitDoc(index bleve.Index, e journalEntry) error {
+	if e.DocIdx == uint64(len(lState.fileList)) {
+		lState.addFile(e.FD)
+	}
+	if e.DocIdx >= uint64(len(lState.fileList)) || lState.fileList[e.DocIdx].Hash != e.FD.Hash {
+		return fmt.Errorf("document %d is not %q", e.DocIdx, e.FD.InPath)
+	}
+	// reindexDoc deletes the document's pages and adds them with the same IDs. The batch keeps the
+	// last operation on each ID so the pages are added.
+	b := newBatcher(index, maxBatchOps)
+	if err := lState.reindexDoc(b, e.DocIdx, e.DocIdx); err != nil {
+		lState.truncateFileList(e.DocIdx)
+		return err
+	}
+	if err := b.flush(); err != nil {
+		lState.truncateFileList(e.DocIdx)
+		return err
+	}
+	common.Log.Info("recommitDoc: Re-indexed %q from its committed positions.", e.FD.InPath)
+	return nil
+}
+
+// truncateFileList removes the documents with indexes >= `n` from `lState`.fileList.
+func (lState *Store) truncateFileList(n uint64) {
+	for _, fd := range lState.fileList[n:] {
+		delete(lState.hashIndex, fd.Hash)
+		delete(lState.hashPath, fd.Hash)
+	}
+	for idx := n; idx < uint64(len(lState.fileList)); idx++ {
+		delete(lState.indexHash, idx)
+	}
+	lState.fileList = lState.fileList[:n]
+}
@@ -0,0 +1,214 @@
+package doclib
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+
+	"github.com/blevesearch/bleve"
+	"github.com/unidoc/unidoc/common"
+	pdf "github.com/unidoc/unidoc/pdf/model"
+)
+
+// ErrPageExists is returned when a page is added to a document that already has a page with the
+// same page number.
+var ErrPageExists = errors.New("page already indexed")
+
+// IndexPageBytes is IndexPage for a page that is supplied as a single page PDF file in
+// `pageBytes`. This is the form that most PDF splitters produce.
+func (lState *Store) IndexPageBytes(index bleve.Index, docKey string, pageNum uint32,
+	pageBytes []byte, opts IndexOptions) (DocPageText, error) {
+
+	pdfReader, err := PdfOpenReader(bytes.NewReader(pageBytes), false)
+	if err != nil {
+		return DocPageText{}, fmt.Errorf("Could not read page %d of %q. err=%v",
+			pageNum, docKey, err)
+	}
+	page, err := pdfReader.GetPage(1)
+	if err != nil {
+		return DocPageText{}, fmt.Errorf("Could not read page %d of %q. err=%v",
+			pageNum, docKey, err)
+	}
+	return lState.IndexPage(index, docKey, pageNum, page, opts)
+}
+
+// IndexPage adds `page`, which is page `pageNum` of the document identified by `docKey`, to
+// `lState` and `index`. It lets pipelines that split PDFs into pages, or that produce pages one at
+// a time, index pages without having the whole PDF file.
+// The first page added for a `docKey` creates a document in `lState` with InPath `docKey`.
+// Later pages are appended to it. Pages may be added in any order but each page number may only
+// be added once. ErrPageExists is returned for repeated page numbers.
+// It returns the DocPageText of the indexed page. The Text of the returned page is empty if the
+// page has no text, in which case the page is not indexed.
+// Pages with no text are recognized with opts.OCR if it is set.
+// The documents are not journaled so the caller should Flush `lState` when it has added a batch of
+// pages. It may be called concurrently for the same `index` and `lState`. The pages are extracted
+// concurrently and added to the store one at a time. It doesn't take the store's writer lock so
+// processes that add pages to a persistent store should hold it. See LockStore.
+func (lState *Store) IndexPage(index bleve.Index, docKey string, pageNum uint32,
+	page *pdf.PdfPage, opts IndexOptions) (DocPageText, error) {
+
+	if pageNum == 0 {
+		return DocPageText{}, fmt.Errorf("Bad page number for %q. %v", docKey, ErrRange)
+	}
+	opts.Normalization = lState.normalization
+	pe, extractor, err := extractPage(&pdfFile{inPath: docKey}, pageNum, page, opts)
+	if err != nil {
+		return DocPageText{}, err
+	}
+	if pe.text == "" {
+		return DocPageText{PageNum: pageNum}, nil
+	}
+
+	lState.mu.Lock()
+	defer lState.mu.Unlock()
+	hash := docKeyHash(docKey)
+	docIdx, exists := lState.hashIndex[hash]
+	var lDoc *Doc
+	if exists {
+		lDoc, err = lState.appendPositionsDoc(docIdx)
+	} else {
+		fd := FileDesc{InPath: docKey, Hash: hash}
+		lDoc, err = lState.CreatePositionsDoc(fd)
+		if err == nil {
+			docIdx = lDoc.docIdx
+			if lState.isMem() {
+				lState.hashDoc[hash] = lDoc
+			}
+		}
+	}
+	if err != nil {
+		return DocPageText{}, err
+	}
+	fd := &lState.fileList[docIdx]
+	fd.Extractors = addExtractor(fd.Extractors, extractor)
+
+	// The first page in the document with the same text as `page`, if any, is indexed for both.
+	firstIdx, repeats, err := textRepeats(lDoc, pe.pageNum, pe.text)
+	if err != nil {
+		lDoc.Close()
+		return DocPageText{}, err
+	}
+	// The page is indexed as the first page with its text.
+	firstNum := pe.pageNum
+	if repeats > 0 {
+		if firstNum, err = lDoc.PageNum(firstIdx); err != nil {
+			lDoc.Close()
+			return DocPageText{}, err
+		}
+	}
+	pageIdx, err := lDoc.AddDocPage(pe.pageNum, pe.dpl, pe.text)
+	if err != nil {
+		lDoc.Close()
+		return DocPageText{}, err
+	}
+	lDoc.setPageBox(pageIdx, pe.box)
+	lDoc.setPageQuality(pageIdx, pe.quality)
+	lDoc.setPageAnnotations(pageIdx, pe.annots)
+	lDoc.setPageTables(pageIdx, pe.tables)
+	fd.setFingerprint(pageIdx, pageFingerprint(pe.text))
+	annots, err := repeatAnnotations(lDoc, pageIdx)
+	if err != nil {
+		lDoc.Close()
+		return DocPageText{}, err
+	}
+	if err := lDoc.Close(); err != nil {
+		return DocPageText{}, err
+	}
+	lState.evictDoc(hash)
+
+	id := pageID(docIdx, pageIdx)
+	if repeats > 0 {
+		id = pageID(docIdx, firstIdx)
+	}
+	b := newBatcher(index, 1)
+	doc := pageDocument(id, *fd, firstNum, pe.text, repeats+1, annots)
+	if err := b.indexDoc(id, hash, doc); err != nil {
+		return DocPageText{}, err
+	}
+	if err := b.flush(); err != nil {
+		return DocPageText{}, err
+	}
+	common.Log.Debug("IndexPage: %q page %d docIdx=%d pageIdx=%d repeats=%d",
+		docKey, pageNum, docIdx, pageIdx, repeats)
+	if err := lState.updateManifestFeatures(); err != nil {
+		return DocPageText{}, err
+	}
+	lState.bumpGeneration()
+	return DocPageText{DocIdx: docIdx, PageIdx: pageIdx, PageNum: pageNum, Text: pe.text}, nil
+}
+
+// docKeyHash returns the FileDesc.Hash of the document added by IndexPage with key `docKey`.
+// The documents don't have a PDF file so their hashes are of their keys. The hashes are prefixed
+// so they can't be the same as the hash of a PDF file.
+func docKeyHash(docKey string) string {
+	sum := sha256.Sum256([]byte(docKey))
+	return "key-" + hex.EncodeToString(sum[:])
+}
+
+// textRepeats returns the index of the first page in `lDoc` with text `text` and the number of
+// pages with that text. It returns ErrPageExists if `lDoc` has a page with page number `pageNum`.
+func textRepeats(lDoc *Doc, pageNum uint32, text string) (uint32, int, error) {
+	var firstIdx uint32
+	repeats := 0
+	for pageIdx := uint32(0); pageIdx < uint32(lDoc.Len()); pageIdx++ {
+		pn, err := lDoc.PageNum(pageIdx)
+		if err != nil {
+			return 0, 0, err
+		}
+		if pn == pageNum {
+			return 0, 0, fmt.Errorf("Could not add page %d to %q. %v",
+				pageNum, lDoc.inPath, ErrPageExists)
+		}
+		t, err := lDoc.ReadPageText(pageIdx)
+		if err != nil {
+			return 0, 0, err
+		}
+		if t != text {
+			continue
+		}
+		if repeats == 0 {
+			firstIdx = pageIdx
+		}
+		repeats++
+	}
+	return firstIdx, repeats, nil
+}
+
+// appendPositionsDoc opens the Doc of document `docIdx` in `lState` for adding pages.
+func (lState *Store) appendPositionsDoc(docIdx uint64) (*Doc, error) {
+	if lState.isMem() {
+		return lState.OpenPositionsDoc(docIdx)
+	}
+	lDoc, err := lState.baseFields(docIdx)
+	if err != nil {
+		return nil, err
+	}
+	b, err := ioutil.ReadFile(lDoc.spansPath)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(b, &lDoc.spans); err != nil {
+		return nil, err
+	}
+	if err := lState.unfreezeDoc(lDoc); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(lDoc.dataPath, os.O_RDWR, 0666)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := f.Seek(0, io.SeekEnd); err != nil {
+		f.Close()
+		return nil, err
+	}
+	lDoc.dataFile = f
+	lDoc.appending = true
+	return lDoc, nil
+}
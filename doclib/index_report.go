@@ -0,0 +1,92 @@
+package doclib
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"time"
+)
+
+// FileStatus is the result of indexing a PDF file.
+type FileStatus string
+
+const (
+	FileIndexed   FileStatus = "indexed"   // The file's pages were added to the index.
+	FileDuplicate FileStatus = "duplicate" // A file with the same contents was already indexed.
+	FileFailed    FileStatus = "failed"    // The file could not be read or indexed.
+	FileSkipped   FileStatus = "skipped"   // The file was excluded by the IndexOptions filters.
+)
+
+// FileReport describes the indexing of a PDF file.
+type FileReport struct {
+	InPath       string
+	Hash         string `json:",omitempty"` // SHA-256 hash of file contents.
+	SizeMB       float64
+	Status       FileStatus
+	IndexedPages int      // Number of pages added to the positions store.
+	SkippedPages int      // Number of pages with no text or whose text couldn't be extracted.
+	Errors       []string `json:",omitempty"` // Errors from reading, extracting and indexing.
+	Duration     time.Duration
+}
+
+// IndexReport describes an indexing run. IndexPdfReadersOpts fills in IndexOptions.Report.
+type IndexReport struct {
+	Files        []FileReport
+	NumIndexed   int // Number of files with Status FileIndexed.
+	NumDuplicate int // Number of files with Status FileDuplicate.
+	NumFailed    int // Number of files with Status FileFailed.
+	NumSkipped   int // Number of files with Status FileSkipped.
+	IndexedPages int // Total number of pages added to the positions store.
+	SkippedPages int // Total number of pages that were skipped.
+	Duration     time.Duration
+}
+
+// add adds the report for a file `f` to `r`.
+func (r *IndexReport) add(f FileReport) {
+	r.Files = append(r.Files, f)
+	switch f.Status {
+	case FileIndexed:
+		r.NumIndexed++
+	case FileDuplicate:
+		r.NumDuplicate++
+	case FileFailed:
+		r.NumFailed++
+	case FileSkipped:
+		r.NumSkipped++
+	}
+	r.IndexedPages += f.IndexedPages
+	r.SkippedPages += f.SkippedPages
+}
+
+// Failed returns the reports of the files in `r` that failed.
+func (r IndexReport) Failed() []FileReport {
+	var failed []FileReport
+	for _, f := range r.Files {
+		if f.Status == FileFailed {
+			failed = append(failed, f)
+		}
+	}
+	return failed
+}
+
+// SaveJSON writes `r` to JSON file `filename`.
+func (r IndexReport) SaveJSON(filename string) error {
+	b, err := json.MarshalIndent(r, "", "\t")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filename, b, 0666)
+}
+
+func (r IndexReport) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%d files in %.1f sec: %d indexed, %d duplicate, %d failed, %d skipped. "+
+		"%d pages indexed, %d skipped.",
+		len(r.Files), r.Duration.Seconds(), r.NumIndexed, r.NumDuplicate, r.NumFailed,
+		r.NumSkipped, r.IndexedPages, r.SkippedPages)
+	for _, f := range r.Failed() {
+		fmt.Fprintf(&b, "\n\t%q: %s", f.InPath, strings.Join(f.Errors, "; "))
+	}
+	return b.String()
+}
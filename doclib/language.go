@@ -0,0 +1,197 @@
+package doclib
+
+import (
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/blevesearch/bleve"
+	"github.com/blevesearch/bleve/analysis/analyzer/keyword"
+	"github.com/blevesearch/bleve/analysis/lang/de"
+	"github.com/blevesearch/bleve/analysis/lang/en"
+	"github.com/blevesearch/bleve/analysis/lang/es"
+	"github.com/blevesearch/bleve/analysis/lang/fr"
+	"github.com/blevesearch/bleve/analysis/lang/it"
+	"github.com/blevesearch/bleve/analysis/lang/pt"
+	"github.com/blevesearch/bleve/mapping"
+	"github.com/blevesearch/bleve/search/query"
+	"github.com/unidoc/unidoc/common"
+)
+
+// LanguageDetector detects the dominant language of page texts.
+type LanguageDetector interface {
+	// DetectLanguage returns the ISO 639-1 code, e.g. "en" or "fr", of the dominant language of
+	// `text` or "" if it can't be determined.
+	DetectLanguage(text string) string
+}
+
+// languageDetector is the LanguageDetector that is used to detect the languages of pages when they
+// are indexed.
+var languageDetector LanguageDetector = stopwordDetector{}
+
+// SetLanguageDetector makes the library detect the languages of pages with `d`, e.g. a wrapper
+// around a statistical detector such as lingua. The default detector recognizes English, French,
+// German, Spanish, Italian and Portuguese from their commonest words.
+// It must be called before indexing. Stores whose pages were indexed with a different detector
+// may give different results for `lang:` filters when pages are reindexed.
+func SetLanguageDetector(d LanguageDetector) {
+	languageDetector = d
+}
+
+// langAnalyzers are {language: bleve analyzer} for the languages whose page texts are also
+// indexed with a language specific analyzer that does stemming and removes stop words.
+var langAnalyzers = map[string]string{
+	"en": en.AnalyzerName,
+	"fr": fr.AnalyzerName,
+	"de": de.AnalyzerName,
+	"es": es.AnalyzerName,
+	"it": it.AnalyzerName,
+	"pt": pt.AnalyzerName,
+}
+
+// langTextField returns the name of the bleve field that holds the page text analyzed with the
+// analyzer for language `lang`.
+func langTextField(lang string) string {
+	return "text_" + lang
+}
+
+// pageMapping returns the bleve document mapping for the pages in language `lang`. The page text
+// is indexed with the store's page text analyzer `pageAnalyzer`, like all pages, with the case and
+// diacritic folding analyzers of foldTextField(), with the quantity analyzer in quantityTextField
+// and with the analyzer for `lang` in langTextField(lang) if there is one. The values of the
+// page's numbers and quantities are indexed as numbers in quantityField for range queries. The
+// extractor, lang and tag fields are indexed as keywords so they can be used as facets and
+// filters. The file field is indexed as a keyword so matches can be sorted by it. The dates are
+// indexed as datetimes for date ranges and sorting.
+// The page text is the only field in bleve's _all field, so queries that don't name a field, such
+// as plain match queries, only match words in the page text. The other fields, e.g. the title and
+// author, are only searched by field-scoped queries such as `author:smith`.
+func pageMapping(lang, pageAnalyzer string) *mapping.DocumentMapping {
+	dm := bleve.NewDocumentMapping()
+	for _, field := range metadataFields {
+		dm.AddFieldMappingsAt(field, notInAll(bleve.NewTextFieldMapping()))
+	}
+	keywordMapping := notInAll(bleve.NewTextFieldMapping())
+	keywordMapping.Analyzer = keyword.Name
+	dm.AddFieldMappingsAt(extractorField, keywordMapping)
+	dm.AddFieldMappingsAt(langField, keywordMapping)
+	dm.AddFieldMappingsAt(tagField, keywordMapping)
+	dm.AddFieldMappingsAt(fileField, keywordMapping)
+	dm.AddFieldMappingsAt(repeatsField, notInAll(bleve.NewNumericFieldMapping()))
+	dm.AddFieldMappingsAt(pageField, notInAll(bleve.NewNumericFieldMapping()))
+	dm.AddFieldMappingsAt(sizeField, notInAll(bleve.NewNumericFieldMapping()))
+	dm.AddFieldMappingsAt(DateCreated, notInAll(bleve.NewDateTimeFieldMapping()))
+	dm.AddFieldMappingsAt(DateModified, notInAll(bleve.NewDateTimeFieldMapping()))
+	dm.AddSubDocumentMapping(quantityField, quantityMapping())
+	// Form field values and annotations are text with their own term locations.
+	valuesMapping := notInAll(bleve.NewTextFieldMapping())
+	valuesMapping.Analyzer = pageAnalyzer
+	dm.AddFieldMappingsAt(formFieldsField, valuesMapping)
+	dm.AddFieldMappingsAt(annotsField, valuesMapping)
+	textMapping := bleve.NewTextFieldMapping()
+	textMapping.Analyzer = pageAnalyzer
+	textMappings := append([]*mapping.FieldMapping{textMapping}, foldTextMappings()...)
+	textMappings = append(textMappings, quantityTextMapping())
+	if analyzer, ok := langAnalyzers[lang]; ok {
+		stemMapping := bleve.NewTextFieldMapping()
+		stemMapping.Name = langTextField(lang)
+		stemMapping.Analyzer = analyzer
+		stemMapping.Store = false
+		stemMapping.IncludeInAll = false
+		stemMapping.IncludeTermVectors = false
+		textMappings = append(textMappings, stemMapping)
+	}
+	dm.AddFieldMappingsAt(textField, textMappings...)
+	return dm
+}
+
+// notInAll returns `fm` with the field excluded from bleve's _all field.
+func notInAll(fm *mapping.FieldMapping) *mapping.FieldMapping {
+	fm.IncludeInAll = false
+	return fm
+}
+
+// langQuery returns `q`, the query for query string `term`, restricted to pages in language
+// `lang`. If `stem` is true then pages whose text matches `term` when both are analyzed with the
+// analyzer for `lang`, e.g. pages with other inflections of the words in `term`, also match.
+func langQuery(q query.Query, term, lang string, stem bool) query.Query {
+	langQ := bleve.NewTermQuery(lang)
+	langQ.SetField(langField)
+	if analyzer, ok := langAnalyzers[lang]; ok && stem && !hasFieldScope(term) {
+		stemQ := bleve.NewMatchQuery(term)
+		stemQ.SetField(langTextField(lang))
+		stemQ.Analyzer = analyzer
+		q = bleve.NewDisjunctionQuery(q, stemQ)
+	}
+	return bleve.NewConjunctionQuery(langQ, q)
+}
+
+// minLangWords is the minimum number of stop words a text must contain for stopwordDetector to
+// detect its language.
+const minLangWords = 3
+
+// langStopwords are {language: common words} for the languages stopwordDetector recognizes.
+var langStopwords = map[string][]string{
+	"en": {"the", "and", "of", "to", "is", "that", "with", "for", "this", "are", "was", "be",
+		"by", "which", "from", "have", "not", "or", "it", "on"},
+	"fr": {"le", "la", "les", "des", "et", "est", "une", "du", "dans", "pour", "que", "qui",
+		"sur", "pas", "avec", "sont", "au", "ce", "il", "par"},
+	"de": {"der", "die", "das", "und", "ist", "nicht", "mit", "den", "von", "zu", "ein", "eine",
+		"auf", "für", "dem", "sich", "des", "auch", "werden", "im"},
+	"es": {"el", "los", "las", "y", "es", "una", "del", "por", "con", "para", "que", "se", "al",
+		"como", "más", "pero", "sus", "está", "su", "son"},
+	"it": {"il", "di", "che", "la", "e", "per", "un", "una", "sono", "del", "della", "non",
+		"con", "si", "le", "gli", "questo", "anche", "nel", "dei"},
+	"pt": {"o", "os", "e", "da", "do", "dos", "das", "em", "um", "uma", "não", "para", "com",
+		"que", "se", "por", "mais", "como", "é", "são"},
+}
+
+// stopwordLangs is {word: languages that have `word` as a stop word}.
+var stopwordLangs = func() map[string][]string {
+	m := map[string][]string{}
+	for lang, words := range langStopwords {
+		for _, w := range words {
+			m[w] = append(m[w], lang)
+		}
+	}
+	return m
+}()
+
+// stopwordDetector is the default LanguageDetector. It counts the stop words of each language in
+// the text and picks the language with clearly the most.
+type stopwordDetector struct{}
+
+// DetectLanguage returns the language whose stop words occur most often in `text`. "" is returned
+// if `text` has fewer than minLangWords stop words of that language or if the runner-up language
+// has more than 2/3 as many.
+func (stopwordDetector) DetectLanguage(text string) string {
+	counts := map[string]int{}
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r)
+	})
+	for _, w := range words {
+		for _, lang := range stopwordLangs[w] {
+			counts[lang]++
+		}
+	}
+	langs := make([]string, 0, len(counts))
+	for lang := range counts {
+		langs = append(langs, lang)
+	}
+	sort.Slice(langs, func(i, j int) bool {
+		li, lj := langs[i], langs[j]
+		if counts[li] != counts[lj] {
+			return counts[li] > counts[lj]
+		}
+		return li < lj
+	})
+	if len(langs) == 0 || counts[langs[0]] < minLangWords {
+		return ""
+	}
+	if len(langs) > 1 && 3*counts[langs[1]] > 2*counts[langs[0]] {
+		common.Log.Trace("DetectLanguage: ambiguous. %q=%d %q=%d", langs[0], counts[langs[0]],
+			langs[1], counts[langs[1]])
+		return ""
+	}
+	return langs[0]
+}
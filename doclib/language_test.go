@@ -0,0 +1,85 @@
+package doclib
+
+import (
+	"testing"
+
+	"github.com/blevesearch/bleve"
+)
+
+func TestDetectLanguage(t *testing.T) {
+	tests := []struct {
+		text string
+		lang string
+	}{
+		{"The quick brown fox jumps over the lazy dog and it is the best of the foxes.", "en"},
+		{"Le renard brun est rapide et il saute par-dessus le chien qui dort dans la cour.", "fr"},
+		{"Der schnelle braune Fuchs springt über den faulen Hund und ist nicht müde.", "de"},
+		{"El rápido zorro marrón salta sobre el perro perezoso y los gatos del vecino.", "es"},
+		{"Il cane della vicina non dorme e gli uccelli sono nel giardino con i gatti.", "it"},
+		{"O cão não dorme e os pássaros estão no jardim com mais gatos da vizinha.", "pt"},
+		{"Invoice 2019-04-01 Total 1234.00", ""},
+		{"", ""},
+	}
+	d := stopwordDetector{}
+	for _, test := range tests {
+		if lang := d.DetectLanguage(test.text); lang != test.lang {
+			t.Errorf("DetectLanguage(%q) = %q. expected %q", test.text, lang, test.lang)
+		}
+	}
+}
+
+// TestPageMappingAll checks that plain queries only match words in the page text and that
+// field-scoped queries match the other fields.
+func TestPageMappingAll(t *testing.T) {
+	indexMapping, err := newIndexMapping(StopwordConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	index, err := bleve.NewMemOnly(indexMapping)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer index.Close()
+	doc := IDText{
+		ID:         "0.1",
+		Text:       "The quick brown fox jumps over the lazy dog.",
+		Title:      "zebra",
+		Author:     "smith",
+		Subject:    "giraffe",
+		Keywords:   "okapi",
+		Extractor:  []string{"unidoc"},
+		Lang:       "en",
+		Tag:        []string{"department=legal"},
+		FormFields: []string{"quokka"},
+		Annots:     []string{"wombat"},
+		File:       "animals.pdf",
+	}
+	if err := index.Index(doc.ID, doc); err != nil {
+		t.Fatal(err)
+	}
+	tests := map[string]uint64{
+		"fox":           1,
+		"zebra":         0,
+		"smith":         0,
+		"giraffe":       0,
+		"okapi":         0,
+		"unidoc":        0,
+		"en":            0,
+		"legal":         0,
+		"quokka":        0,
+		"wombat":        0,
+		"animals.pdf":   0,
+		"title:zebra":   1,
+		"author:smith":  1,
+		"Annots:wombat": 1,
+	}
+	for term, expected := range tests {
+		results, err := index.Search(bleve.NewSearchRequest(makeQuery(term)))
+		if err != nil {
+			t.Fatalf("term=%q err=%v", term, err)
+		}
+		if results.Total != expected {
+			t.Errorf("term=%q matches=%d expected=%d", term, results.Total, expected)
+		}
+	}
+}
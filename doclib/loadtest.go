@@ -0,0 +1,222 @@
+package doclib
+
+import (
+	"bufio"
+	"fmt"
+	"math/rand"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// LoadTestOptions controls how RunLoadTest runs queries.
+type LoadTestOptions struct {
+	Concurrency int           // Number of queries run at the same time.
+	Requests    int           // Number of queries to run. The queries are cycled. 0 for one pass.
+	Duration    time.Duration // If > 0, no queries are started after this time.
+	Search      SearchOptions // Options for each query.
+}
+
+// LoadTestReport is the result of RunLoadTest.
+type LoadTestReport struct {
+	Requests    int           // Number of queries that were run.
+	Errors      int           // Number of queries that failed.
+	Concurrency int           // Number of queries run at the same time.
+	Duration    time.Duration // Wall clock time of the test.
+	// Latencies of the queries.
+	Min, Mean, P50, P90, P99, Max time.Duration
+	// ErrorSamples are the first few distinct errors.
+	ErrorSamples []string `json:",omitempty"`
+}
+
+// maxErrorSamples is the number of distinct errors that a LoadTestReport keeps.
+const maxErrorSamples = 10
+
+// RunLoadTest runs `queries` against `s` with opts.Concurrency concurrent clients and returns the
+// latency and error statistics. `s` may be a local PdfIndex or a RemoteIndex so that stores and
+// servers can be tested the same way.
+func RunLoadTest(s Searcher, queries []string, opts LoadTestOptions) LoadTestReport {
+	concurrency := opts.Concurrency
+	if concurrency <= 0 {
+		concurrency = 1
+	}
+	numRequests := opts.Requests
+	if numRequests <= 0 {
+		numRequests = len(queries)
+	}
+	if len(queries) == 0 {
+		numRequests = 0
+	}
+
+	latencies := make([]time.Duration, numRequests)
+	errs := make([]error, numRequests)
+	started := make([]bool, numRequests)
+	jobs := make(chan int)
+	t0 := time.Now()
+
+	var wg sync.WaitGroup
+	for w := 0; w < concurrency; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				start := time.Now()
+				_, errs[i] = s.Search(queries[i%len(queries)], opts.Search)
+				latencies[i] = time.Since(start)
+			}
+		}()
+	}
+	for i := 0; i < numRequests; i++ {
+		if opts.Duration > 0 && time.Since(t0) > opts.Duration {
+			break
+		}
+		started[i] = true
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	r := LoadTestReport{Concurrency: concurrency, Duration: time.Since(t0)}
+	var done []time.Duration
+	seen := map[string]bool{}
+	for i, ok := range started {
+		if !ok {
+			continue
+		}
+		r.Requests++
+		if err := errs[i]; err != nil {
+			r.Errors++
+			if msg := err.Error(); !seen[msg] && len(r.ErrorSamples) < maxErrorSamples {
+				seen[msg] = true
+				r.ErrorSamples = append(r.ErrorSamples, msg)
+			}
+			continue
+		}
+		done = append(done, latencies[i])
+	}
+	r.setLatencies(done)
+	return r
+}
+
+// setLatencies sets the latency statistics of `r` from the latencies of the successful queries
+// `latencies`.
+func (r *LoadTestReport) setLatencies(latencies []time.Duration) {
+	n := len(latencies)
+	if n == 0 {
+		return
+	}
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	var total time.Duration
+	for _, d := range latencies {
+		total += d
+	}
+	percentile := func(p float64) time.Duration { return latencies[int(p*float64(n-1))] }
+	r.Min = latencies[0]
+	r.Max = latencies[n-1]
+	r.Mean = total / time.Duration(n)
+	r.P50 = percentile(0.50)
+	r.P90 = percentile(0.90)
+	r.P99 = percentile(0.99)
+}
+
+// QPS returns the number of queries per second in `r`.
+func (r LoadTestReport) QPS() float64 {
+	if r.Duration <= 0 {
+		return 0
+	}
+	return float64(r.Requests) / r.Duration.Seconds()
+}
+
+// ErrorRate returns the fraction of the queries in `r` that failed.
+func (r LoadTestReport) ErrorRate() float64 {
+	if r.Requests == 0 {
+		return 0
+	}
+	return float64(r.Errors) / float64(r.Requests)
+}
+
+func (r LoadTestReport) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%d queries in %.1f sec with %d clients: %.1f queries/sec, %d errors (%.2f%%)\n",
+		r.Requests, r.Duration.Seconds(), r.Concurrency, r.QPS(), r.Errors, 100.0*r.ErrorRate())
+	fmt.Fprintf(&b, "latency: min=%s mean=%s p50=%s p90=%s p99=%s max=%s",
+		r.Min, r.Mean, r.P50, r.P90, r.P99, r.Max)
+	for _, msg := range r.ErrorSamples {
+		fmt.Fprintf(&b, "\n\terror: %s", msg)
+	}
+	return b.String()
+}
+
+// ReadQueryLog returns the queries in query log file `filename`. The file has one query per line.
+// Blank lines and lines starting with # are ignored.
+func ReadQueryLog(filename string) ([]string, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var queries []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		queries = append(queries, line)
+	}
+	return queries, scanner.Err()
+}
+
+// maxSampleTerms is the maximum number of index terms that SampleQueries chooses query terms
+// from.
+const maxSampleTerms = 10000
+
+// SampleQueries returns `n` synthetic queries of `termsPerQuery` terms each that are chosen at
+// random from the page text terms in `x`. `seed` seeds the random choices so that tests can be
+// repeated.
+func (x *PdfIndex) SampleQueries(n, termsPerQuery int, seed int64) ([]string, error) {
+	if err := x.refresh(); err != nil {
+		return nil, err
+	}
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+	if x.index == nil {
+		return nil, ErrClosed
+	}
+
+	rng := rand.New(rand.NewSource(seed))
+
+	// Reservoir sample the terms so that big dictionaries don't need to be held in memory.
+	var terms []string
+	i := 0
+	err := walkTermDict(x.index, func(tc TermCount) error {
+		if len(terms) < maxSampleTerms {
+			terms = append(terms, tc.Term)
+		} else if j := rng.Intn(i + 1); j < maxSampleTerms {
+			terms[j] = tc.Term
+		}
+		i++
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(terms) == 0 {
+		return nil, nil
+	}
+
+	if termsPerQuery <= 0 {
+		termsPerQuery = 1
+	}
+	queries := make([]string, n)
+	for i := range queries {
+		parts := make([]string, termsPerQuery)
+		for j := range parts {
+			parts[j] = terms[rng.Intn(len(terms))]
+		}
+		queries[i] = strings.Join(parts, " ")
+	}
+	return queries, nil
+}
@@ -0,0 +1,16 @@
+//go:build windows
+// +build windows
+
+package doclib
+
+import "os"
+
+// lockFile does nothing. Stores aren't locked on Windows.
+func lockFile(f *os.File) error {
+	return nil
+}
+
+// unlockFile does nothing. See lockFile.
+func unlockFile(f *os.File) error {
+	return nil
+}
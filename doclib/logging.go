@@ -0,0 +1,91 @@
+package doclib
+
+import (
+	"time"
+
+	"github.com/unidoc/unidoc/common"
+)
+
+// Logger is what the library logs through. Services that embed the library call SetLogger to send
+// its logs to their own logging system instead of the console. The messages are Printf style.
+type Logger interface {
+	Error(format string, args ...interface{})
+	Info(format string, args ...interface{})
+	Debug(format string, args ...interface{})
+}
+
+// SetLogger makes the library, including UniDoc, log through `l`. UniDoc warnings are logged as
+// errors, notices as info and trace messages are dropped. SetLogging sets a console logger.
+func SetLogger(l Logger) {
+	common.SetLogger(unidocLogger{l})
+}
+
+// unidocLogger is the UniDoc common.Logger that sends UniDoc and doclib logs to a Logger.
+type unidocLogger struct {
+	Logger
+}
+
+func (l unidocLogger) Warning(format string, args ...interface{}) {
+	l.Error(format, args...)
+}
+
+func (l unidocLogger) Notice(format string, args ...interface{}) {
+	l.Info(format, args...)
+}
+
+func (l unidocLogger) Trace(format string, args ...interface{}) {}
+
+// IsLogLevel returns true for the levels that `l` logs. The Logger filters messages by level
+// itself.
+func (l unidocLogger) IsLogLevel(level common.LogLevel) bool {
+	return level <= common.LogLevelDebug
+}
+
+// Progress is the progress of an indexing run.
+type Progress struct {
+	InPath     string        // The file that was just indexed.
+	FilesDone  int           // Number of files that have been processed.
+	TotalFiles int           // Number of files in the run.
+	PagesDone  int           // Number of pages that have been indexed.
+	Elapsed    time.Duration // Time since the run started.
+	// PagesPerSec is the average indexing rate since the run started.
+	PagesPerSec float64
+	// ETA is the estimated time until the run finishes based on the time taken by the files that
+	// have been processed.
+	ETA time.Duration
+}
+
+// ProgressReporter receives the progress of indexing runs. Set IndexOptions.Progress to a
+// ProgressReporter to show progress bars or update job status. Progress is called from the
+// goroutine that called the indexing function after each file is processed.
+type ProgressReporter interface {
+	Progress(p Progress)
+}
+
+// ProgressFunc is a function that is a ProgressReporter.
+type ProgressFunc func(p Progress)
+
+// Progress calls f(p).
+func (f ProgressFunc) Progress(p Progress) {
+	f(p)
+}
+
+// makeProgress returns the Progress of a run of `totalFiles` files that started at `t0` after
+// `filesDone` of them, containing `pagesDone` pages, have been processed. `inPath` is the last file
+// processed.
+func makeProgress(inPath string, filesDone, totalFiles, pagesDone int, t0 time.Time) Progress {
+	p := Progress{
+		InPath:     inPath,
+		FilesDone:  filesDone,
+		TotalFiles: totalFiles,
+		PagesDone:  pagesDone,
+		Elapsed:    time.Since(t0),
+	}
+	if p.Elapsed > 0 {
+		p.PagesPerSec = float64(pagesDone) / p.Elapsed.Seconds()
+	}
+	if filesDone > 0 && filesDone < totalFiles {
+		p.ETA = p.Elapsed / time.Duration(filesDone) * time.Duration(totalFiles-filesDone)
+	}
+	return p
+}
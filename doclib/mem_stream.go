@@ -0,0 +1,198 @@
+package doclib
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/blevesearch/bleve"
+	"github.com/blevesearch/blevex/preload"
+	flatbuffers "github.com/google/flatbuffers/go"
+	"github.com/peterwilliams97/pdf-search/serial"
+	"github.com/unidoc/unidoc/common"
+)
+
+const (
+	// memChunkBytes is the approximate number of bytes of page text in each chunk of documents
+	// that WriteMemStore writes.
+	memChunkBytes = 4 * 1024 * 1024
+	// bleveChunkBytes is the size of the chunks of the exported bleve index that WriteMemStore
+	// writes.
+	bleveChunkBytes = 1024 * 1024
+)
+
+// WriteMemStore writes in-memory store `lState` and its bleve index `index` to `w` as a stream of
+// checksummed frames. See serial.WriteFrame. The documents are written in chunks of about
+// memChunkBytes of page text so the whole store is never serialized in memory at once.
+// Only the paths and hashes of the documents' FileDescs are written. The store's normalization
+// and stop words are written as a StoreConfig so that the reader analyzes queries the same way.
+func (lState *Store) WriteMemStore(w io.Writer, index bleve.Index) error {
+	if !lState.isMem() {
+		return fmt.Errorf("WriteMemStore: %q is not an in-memory store", lState.root)
+	}
+	b := flatbuffers.NewBuilder(0)
+	header := serial.SerialPdfIndex{NumFiles: uint32(len(lState.fileList))}
+	for _, lDoc := range lState.hashDoc {
+		header.NumPages += uint32(len(lDoc.pageNums))
+	}
+	buf := serial.MakeSerialPdfIndex(b, header)
+	if err := serial.WriteFrame(w, serial.FrameHeader, buf); err != nil {
+		return err
+	}
+	config, err := json.Marshal(StoreConfig{
+		Normalization: lState.normalization,
+		Stopwords:     lState.stopwords,
+	})
+	if err != nil {
+		return err
+	}
+	if err := serial.WriteFrame(w, serial.FrameConfig, config); err != nil {
+		return err
+	}
+
+	var chunk []serial.HashIndexPathDoc
+	size := 0
+	for i, fd := range lState.fileList {
+		lDoc, ok := lState.hashDoc[fd.Hash]
+		if !ok {
+			return fmt.Errorf("WriteMemStore: no document for %q. err=%v", fd.InPath, ErrNoDoc)
+		}
+		chunk = append(chunk, serial.HashIndexPathDoc{
+			Hash:  fd.Hash,
+			Index: uint64(i),
+			Path:  lState.hashPath[fd.Hash],
+			Doc: serial.DocPositions{
+				Path:      lDoc.inPath,
+				DocIdx:    lDoc.docIdx,
+				PageNums:  lDoc.pageNums,
+				PageTexts: lDoc.pageTexts,
+			},
+		})
+		for _, text := range lDoc.pageTexts {
+			size += len(text)
+		}
+		if size >= memChunkBytes {
+			if err := serial.WriteHIPDsFrame(w, b, chunk); err != nil {
+				return err
+			}
+			chunk, size = nil, 0
+		}
+	}
+	if len(chunk) > 0 {
+		if err := serial.WriteHIPDsFrame(w, b, chunk); err != nil {
+			return err
+		}
+	}
+
+	i, _, err := index.Advanced()
+	if err != nil {
+		return err
+	}
+	bw := bufio.NewWriterSize(frameWriter{w: w, kind: serial.FrameBleve}, bleveChunkBytes)
+	if err := preload.ExportBleve(i, bw); err != nil {
+		return err
+	}
+	if err := bw.Flush(); err != nil {
+		return err
+	}
+	return serial.WriteFrame(w, serial.FrameEnd, nil)
+}
+
+// ReadMemStore reads an in-memory store and its bleve index that were written by WriteMemStore
+// from `r`. The documents are read one chunk at a time. The bleve index is imported from one
+// buffer because bleve's preload store can only be loaded that way. Stores in streams that were
+// written without a configuration have the default normalization and stop words.
+func ReadMemStore(r io.Reader) (*Store, bleve.Index, error) {
+	lState, err := OpenPositionsState("", false)
+	if err != nil {
+		return nil, nil, err
+	}
+	var header serial.SerialPdfIndex
+	var bleveMem bytes.Buffer
+	numPages := uint32(0)
+	for {
+		kind, buf, err := serial.ReadFrame(r)
+		if err != nil {
+			return nil, nil, fmt.Errorf("Could not read in-memory store. err=%v", err)
+		}
+		switch kind {
+		case serial.FrameHeader:
+			if header, err = serial.ReadSerialPdfIndex(buf); err != nil {
+				return nil, nil, err
+			}
+		case serial.FrameConfig:
+			var config StoreConfig
+			if err := json.Unmarshal(buf, &config); err != nil {
+				return nil, nil, fmt.Errorf("Could not parse in-memory store config. err=%v", err)
+			}
+			lState.normalization = config.Normalization
+			lState.stopwords = config.Stopwords
+		case serial.FrameHIPDs:
+			spi, err := serial.ReadSerialPdfIndex(buf)
+			if err != nil {
+				return nil, nil, err
+			}
+			for _, hipd := range spi.HIPDs {
+				if err := lState.addHIPD(hipd); err != nil {
+					return nil, nil, err
+				}
+			}
+			numPages += spi.NumPages
+		case serial.FrameBleve:
+			bleveMem.Write(buf)
+		case serial.FrameEnd:
+			if int(header.NumFiles) != len(lState.fileList) || header.NumPages != numPages {
+				return nil, nil, fmt.Errorf("In-memory store is truncated. NumFiles=%d NumPages=%d "+
+					"read %d files %d pages", header.NumFiles, header.NumPages, len(lState.fileList),
+					numPages)
+			}
+			index, err := importBleveMem(bleveMem.Bytes(), lState.stopwords)
+			if err != nil {
+				return nil, nil, fmt.Errorf("Could not import bleve memory index. err=%v", err)
+			}
+			common.Log.Info("ReadMemStore: %d files %d pages bleve=%d bytes",
+				header.NumFiles, header.NumPages, bleveMem.Len())
+			return lState, index, nil
+		default:
+			return nil, nil, fmt.Errorf("Unknown frame kind %q in in-memory store", kind)
+		}
+	}
+}
+
+// addHIPD adds the document described by `hipd` to in-memory store `lState`. Documents must be
+// added in file list order.
+func (lState *Store) addHIPD(hipd serial.HashIndexPathDoc) error {
+	if hipd.Index != uint64(len(lState.fileList)) {
+		return fmt.Errorf("Document %q is out of order. Index=%d expected=%d",
+			hipd.Path, hipd.Index, len(lState.fileList))
+	}
+	lState.fileList = append(lState.fileList, FileDesc{InPath: hipd.Path, Hash: hipd.Hash})
+	lState.hashIndex[hipd.Hash] = hipd.Index
+	lState.indexHash[hipd.Index] = hipd.Hash
+	lState.hashPath[hipd.Hash] = hipd.Path
+	lState.hashDoc[hipd.Hash] = &Doc{
+		lState: lState,
+		inPath: hipd.Doc.Path,
+		docIdx: hipd.Doc.DocIdx,
+		docData: &docData{
+			pageNums:  hipd.Doc.PageNums,
+			pageTexts: hipd.Doc.PageTexts,
+		},
+	}
+	return nil
+}
+
+// frameWriter writes the data passed to each Write call to `w` as a frame of kind `kind`.
+type frameWriter struct {
+	w    io.Writer
+	kind byte
+}
+
+func (fw frameWriter) Write(p []byte) (int, error) {
+	if err := serial.WriteFrame(fw.w, fw.kind, p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
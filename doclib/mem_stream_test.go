@@ -0,0 +1,49 @@
+package doclib
+
+import (
+	"bytes"
+	"testing"
+)
+
+// TestMemStoreStopwords checks that an in-memory store read by ReadMemStore has the stop words
+// of the store that WriteMemStore wrote.
+func TestMemStoreStopwords(t *testing.T) {
+	lState, err := OpenPositionsState("", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lState.stopwords = StopwordConfig{Lang: StopwordsNone}
+	index, err := createBleveMemIndex(lState.stopwords)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fd := FileDesc{InPath: "doc.pdf", Hash: "a0123456789"}
+	text := "To be or not to be"
+	pages, err := lState.addDocPagePositions(fd, []pageExtraction{{pageNum: 1, text: text}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := pageID(pages[0].DocIdx, pages[0].PageIdx)
+	if err := index.Index(id, pageDocument(id, fd, 1, text, 1, nil)); err != nil {
+		t.Fatal(err)
+	}
+
+	var b bytes.Buffer
+	if err := lState.WriteMemStore(&b, index); err != nil {
+		t.Fatal(err)
+	}
+	lState2, index2, err := ReadMemStore(&b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer index2.Close()
+	if lState2.stopwords.Lang != StopwordsNone {
+		t.Errorf("stopwords=%+v expected=%+v", lState2.stopwords, lState.stopwords)
+	}
+	// "be" is an English stop word so it is only indexed if the index has no default stop words.
+	m := index2.Mapping()
+	tokens := m.AnalyzerNamed(m.AnalyzerNameForPath(textField)).Analyze([]byte(text))
+	if len(tokens) != 6 {
+		t.Errorf("tokens=%d expected=6", len(tokens))
+	}
+}
@@ -0,0 +1,109 @@
+package doclib
+
+import (
+	"context"
+	"io"
+	"os"
+	"sync"
+)
+
+const (
+	// extractionMemFactor is the estimated ratio of the peak memory used to extract and index a
+	// PDF to the size of the PDF file. UniDoc keeps the parsed objects of a PDF in memory and the
+	// text locations of a page are about 20 bytes per character.
+	extractionMemFactor = 8
+	// minExtractionBytes is the estimated memory used to extract a small PDF.
+	minExtractionBytes = 4 * 1024 * 1024
+	// unknownFileBytes is the assumed size of PDFs whose sizes aren't known until they are read,
+	// such as the documents in a CorpusSource.
+	unknownFileBytes = 10 * 1024 * 1024
+	// textLocationBytes is the size of a serial.TextLocation.
+	textLocationBytes = 20
+)
+
+// memoryBudget limits the estimated memory used by the documents that are being extracted by
+// concurrent extraction workers or are waiting to be added to a store. See
+// IndexOptions.MemoryBudgetMB.
+type memoryBudget struct {
+	mu    sync.Mutex
+	limit int64         // Budget in bytes.
+	used  int64         // Estimated bytes used by the documents that have been admitted.
+	freed chan struct{} // Closed, and replaced, when memory is released.
+}
+
+// newMemoryBudget returns a memoryBudget of `limitMB` MB or nil if `limitMB` <= 0, which is no
+// limit.
+func newMemoryBudget(limitMB float64) *memoryBudget {
+	if limitMB <= 0 {
+		return nil
+	}
+	return &memoryBudget{limit: int64(limitMB * 1024 * 1024), freed: make(chan struct{})}
+}
+
+// acquire waits until `n` bytes fit in the budget and reserves them. It returns `ctx`'s error if
+// `ctx` is done first. A document that is bigger than the whole budget is admitted when no other
+// documents are in memory, so big PDFs are extracted by themselves rather than not at all.
+func (b *memoryBudget) acquire(ctx context.Context, n int64) error {
+	if b == nil {
+		return nil
+	}
+	for {
+		b.mu.Lock()
+		if b.used == 0 || b.used+n <= b.limit {
+			b.used += n
+			b.mu.Unlock()
+			return nil
+		}
+		freed := b.freed
+		b.mu.Unlock()
+		select {
+		case <-freed:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// release returns `n` bytes reserved by acquire to the budget.
+func (b *memoryBudget) release(n int64) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.used -= n
+	close(b.freed)
+	b.freed = make(chan struct{})
+}
+
+// fileSize returns the size in bytes of PDF `inPath` which is read from `rs`, or opened by name if
+// `rs` is nil. It returns -1 if the size isn't known until the PDF is read, as for the documents in
+// a CorpusSource.
+func fileSize(inPath string, rs io.ReadSeeker, opts IndexOptions) int64 {
+	if rs != nil {
+		n, err := rs.Seek(0, io.SeekEnd)
+		rs.Seek(0, io.SeekStart)
+		if err == nil {
+			return n
+		}
+	} else if opts.Source == nil {
+		if fi, err := os.Stat(inPath); err == nil {
+			return fi.Size()
+		}
+	}
+	return -1
+}
+
+// estimateExtractionBytes returns the estimated peak memory used to extract and index a PDF of
+// `size` bytes. `size` is -1 if it isn't known.
+func estimateExtractionBytes(size int64) int64 {
+	if size < 0 {
+		size = unknownFileBytes
+	}
+	return minExtractionBytes + extractionMemFactor*size
+}
+
+// bufferBytes returns the approximate memory used by the text and text locations of `pe`.
+func (pe pageExtraction) bufferBytes() int {
+	return len(pe.text) + textLocationBytes*len(pe.dpl.Locations)
+}
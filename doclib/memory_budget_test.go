@@ -0,0 +1,82 @@
+package doclib
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/peterwilliams97/pdf-search/serial"
+)
+
+func TestMemoryBudget(t *testing.T) {
+	const mb = 1024 * 1024
+	b := newMemoryBudget(10)
+	ctx := context.Background()
+	if err := b.acquire(ctx, 6*mb); err != nil {
+		t.Fatal(err)
+	}
+
+	// The second document doesn't fit until the first is released.
+	admitted := make(chan struct{})
+	go func() {
+		if err := b.acquire(ctx, 6*mb); err != nil {
+			t.Error(err)
+		}
+		close(admitted)
+	}()
+	select {
+	case <-admitted:
+		t.Fatalf("Document was admitted over budget.")
+	case <-time.After(50 * time.Millisecond):
+	}
+	b.release(6 * mb)
+	select {
+	case <-admitted:
+	case <-time.After(time.Second):
+		t.Fatalf("Document wasn't admitted after memory was released.")
+	}
+
+	// Waiting stops when the context is done.
+	ctx2, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	if err := b.acquire(ctx2, 6*mb); err != context.DeadlineExceeded {
+		t.Errorf("acquire: got %v expected %v", err, context.DeadlineExceeded)
+	}
+
+	// A document that is bigger than the budget is admitted when it is alone.
+	b.release(6 * mb)
+	if err := b.acquire(ctx, 100*mb); err != nil {
+		t.Errorf("Big document wasn't admitted. err=%v", err)
+	}
+
+	var noBudget *memoryBudget
+	if err := noBudget.acquire(ctx, 100*mb); err != nil {
+		t.Errorf("nil budget: err=%v", err)
+	}
+	noBudget.release(100 * mb)
+}
+
+func TestEstimateExtractionBytes(t *testing.T) {
+	rs := bytes.NewReader(make([]byte, 1000))
+	rs.Seek(10, 0)
+	size := fileSize("a.pdf", rs, IndexOptions{})
+	if size != 1000 {
+		t.Errorf("fileSize: got %d expected 1000", size)
+	}
+	if pos, _ := rs.Seek(0, 1); pos != 0 {
+		t.Errorf("Reader wasn't rewound. pos=%d", pos)
+	}
+	expected := int64(minExtractionBytes + extractionMemFactor*1000)
+	if n := estimateExtractionBytes(size); n != expected {
+		t.Errorf("got %d expected %d", n, expected)
+	}
+	if n := estimateExtractionBytes(-1); n <= expected {
+		t.Errorf("Unknown size: got %d", n)
+	}
+	pe := pageExtraction{text: "hello world", dpl: serial.DocPageLocations{
+		Locations: make([]serial.TextLocation, 2)}}
+	if n := pe.bufferBytes(); n != 11+2*textLocationBytes {
+		t.Errorf("bufferBytes: got %d", n)
+	}
+}
@@ -0,0 +1,246 @@
+package doclib
+
+import (
+	"bytes"
+	"encoding/xml"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf16"
+
+	"github.com/unidoc/unidoc/common"
+	"github.com/unidoc/unidoc/pdf/core"
+	pdf "github.com/unidoc/unidoc/pdf/model"
+)
+
+// DocMetadata is the document-level metadata of a PDF file. It is read from the PDF's Info
+// dictionary and XMP metadata stream.
+type DocMetadata struct {
+	Title        string    `json:",omitempty"`
+	Author       string    `json:",omitempty"`
+	Subject      string    `json:",omitempty"`
+	Keywords     string    `json:",omitempty"`
+	CreationDate time.Time // Zero if the PDF has no creation date.
+}
+
+// ReadDocMetadata returns the metadata of the PDF in `pdfReader`.
+// Info dictionary entries take precedence. Entries that are missing from the Info dictionary are
+// taken from the XMP metadata.
+func ReadDocMetadata(pdfReader *pdf.PdfReader) (DocMetadata, error) {
+	trailer, err := pdfReader.GetTrailer()
+	if err != nil {
+		return DocMetadata{}, err
+	}
+	meta := readInfoMetadata(trailer)
+	if root, ok := core.GetDict(trailer.Get("Root")); ok {
+		if stream, ok := core.TraceToDirectObject(root.Get("Metadata")).(*core.PdfObjectStream); ok {
+			data, err := core.DecodeStream(stream)
+			if err != nil {
+				common.Log.Debug("ReadDocMetadata: Bad XMP stream. err=%v", err)
+			} else {
+				meta.merge(parseXmpMetadata(data))
+			}
+		}
+	}
+	return meta, nil
+}
+
+// readInfoMetadata returns the metadata in the Info dictionary referenced by PDF `trailer`.
+func readInfoMetadata(trailer *core.PdfObjectDictionary) DocMetadata {
+	info, ok := core.GetDict(trailer.Get("Info"))
+	if !ok {
+		return DocMetadata{}
+	}
+	get := func(key string) string {
+		s, ok := core.GetStringVal(info.Get(core.PdfObjectName(key)))
+		if !ok {
+			return ""
+		}
+		return strings.TrimSpace(decodePdfText(s))
+	}
+	creationDate, _ := parsePdfDate(get("CreationDate"))
+	return DocMetadata{
+		Title:        get("Title"),
+		Author:       get("Author"),
+		Subject:      get("Subject"),
+		Keywords:     get("Keywords"),
+		CreationDate: creationDate,
+	}
+}
+
+// merge sets the empty fields in `meta` to the corresponding fields in `other`.
+func (meta *DocMetadata) merge(other DocMetadata) {
+	if meta.Title == "" {
+		meta.Title = other.Title
+	}
+	if meta.Author == "" {
+		meta.Author = other.Author
+	}
+	if meta.Subject == "" {
+		meta.Subject = other.Subject
+	}
+	if meta.Keywords == "" {
+		meta.Keywords = other.Keywords
+	}
+	if meta.CreationDate.IsZero() {
+		meta.CreationDate = other.CreationDate
+	}
+}
+
+// decodePdfText decodes PDF text string `s`. PDF text strings are UTF-16BE if they start with a
+// byte order mark, otherwise they are PDFDocEncoding, which we treat as Latin-1.
+func decodePdfText(s string) string {
+	b := []byte(s)
+	if len(b) >= 2 && b[0] == 0xFE && b[1] == 0xFF {
+		b = b[2:]
+		codes := make([]uint16, len(b)/2)
+		for i := range codes {
+			codes[i] = uint16(b[2*i])<<8 | uint16(b[2*i+1])
+		}
+		return string(utf16.Decode(codes))
+	}
+	runes := make([]rune, len(b))
+	for i, c := range b {
+		runes[i] = rune(c)
+	}
+	return string(runes)
+}
+
+// parsePdfDate parses PDF date string `s` of the form D:YYYYMMDDHHmmSSOHH'mm'.
+// All the fields after the year are optional.
+func parsePdfDate(s string) (time.Time, bool) {
+	s = strings.TrimPrefix(strings.TrimSpace(s), "D:")
+	if len(s) < 4 {
+		return time.Time{}, false
+	}
+	// Fields are year, month, day, hour, minute, second.
+	fields := []int{0, 1, 1, 0, 0, 0}
+	widths := []int{4, 2, 2, 2, 2, 2}
+	pos := 0
+	for i, w := range widths {
+		if pos+w > len(s) || !isDigits(s[pos:pos+w]) {
+			break
+		}
+		fields[i], _ = strconv.Atoi(s[pos : pos+w])
+		pos += w
+	}
+	loc := time.UTC
+	if pos < len(s) && (s[pos] == '+' || s[pos] == '-') {
+		tz := strings.Replace(s[pos+1:], "'", "", -1)
+		if len(tz) >= 2 && isDigits(tz[:2]) {
+			hh, _ := strconv.Atoi(tz[:2])
+			mm := 0
+			if len(tz) >= 4 && isDigits(tz[2:4]) {
+				mm, _ = strconv.Atoi(tz[2:4])
+			}
+			offset := hh*3600 + mm*60
+			if s[pos] == '-' {
+				offset = -offset
+			}
+			loc = time.FixedZone("", offset)
+		}
+	}
+	return time.Date(fields[0], time.Month(fields[1]), fields[2], fields[3], fields[4], fields[5],
+		0, loc), true
+}
+
+// isDigits returns true if `s` is made of ASCII digits.
+func isDigits(s string) bool {
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return len(s) > 0
+}
+
+// parseXmpMetadata returns the metadata in XMP packet `data`. XMP properties may be elements or
+// attributes of rdf:Description, and the Dublin Core properties are usually wrapped in
+// rdf:Alt, rdf:Bag or rdf:Seq lists.
+func parseXmpMetadata(data []byte) DocMetadata {
+	values := map[string][]string{} // {property: values}
+	set := func(name xml.Name, value string) {
+		value = strings.TrimSpace(value)
+		if value == "" {
+			return
+		}
+		key := xmpKey(name)
+		if key != "" {
+			values[key] = append(values[key], value)
+		}
+	}
+
+	decoder := xml.NewDecoder(bytes.NewReader(data))
+	var stack []xml.Name
+	for {
+		tok, err := decoder.Token()
+		if err != nil {
+			break
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			stack = append(stack, t.Name)
+			if t.Name.Local == "Description" {
+				for _, attr := range t.Attr {
+					set(attr.Name, attr.Value)
+				}
+			}
+		case xml.EndElement:
+			if len(stack) > 0 {
+				stack = stack[:len(stack)-1]
+			}
+		case xml.CharData:
+			// The property is the innermost element that isn't part of an RDF list.
+			for i := len(stack) - 1; i >= 0; i-- {
+				if l := stack[i].Local; l != "li" && l != "Alt" && l != "Bag" && l != "Seq" {
+					set(stack[i], string(t))
+					break
+				}
+			}
+		}
+	}
+
+	first := func(key string) string {
+		if v := values[key]; len(v) > 0 {
+			return v[0]
+		}
+		return ""
+	}
+	var meta DocMetadata
+	meta.Title = first("title")
+	meta.Author = strings.Join(values["creator"], ", ")
+	meta.Subject = first("description")
+	meta.Keywords = first("Keywords")
+	if meta.Keywords == "" {
+		meta.Keywords = strings.Join(values["subject"], ", ")
+	}
+	if t, err := time.Parse(time.RFC3339, first("CreateDate")); err == nil {
+		meta.CreationDate = t
+	} else if t, err := time.Parse("2006-01-02", first("CreateDate")); err == nil {
+		meta.CreationDate = t
+	}
+	return meta
+}
+
+// Namespaces of the XMP properties we read.
+const (
+	nsDublinCore = "http://purl.org/dc/elements/1.1/"
+	nsXmpBasic   = "http://ns.adobe.com/xap/1.0/"
+	nsAdobePdf   = "http://ns.adobe.com/pdf/1.3/"
+)
+
+// xmpKey returns the key used by parseXmpMetadata for XMP property `name` or "" if it is not a
+// property we read.
+func xmpKey(name xml.Name) string {
+	switch {
+	case name.Space == nsDublinCore &&
+		(name.Local == "title" || name.Local == "creator" || name.Local == "description" ||
+			name.Local == "subject"):
+		return name.Local
+	case name.Space == nsXmpBasic && name.Local == "CreateDate":
+		return name.Local
+	case name.Space == nsAdobePdf && name.Local == "Keywords":
+		return name.Local
+	}
+	return ""
+}
@@ -0,0 +1,20 @@
+//go:build !windows
+// +build !windows
+
+package doclib
+
+import (
+	"os"
+	"syscall"
+)
+
+// mmapFile maps the first `size` bytes of `f` into memory for reading. The mapping stays valid
+// after `f` is closed. It must be released with munmapFile.
+func mmapFile(f *os.File, size int) ([]byte, error) {
+	return syscall.Mmap(int(f.Fd()), 0, size, syscall.PROT_READ, syscall.MAP_SHARED)
+}
+
+// munmapFile releases a mapping made by mmapFile.
+func munmapFile(data []byte) error {
+	return syscall.Munmap(data)
+}
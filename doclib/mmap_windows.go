@@ -0,0 +1,22 @@
+//go:build windows
+// +build windows
+
+package doclib
+
+import (
+	"io"
+	"os"
+)
+
+// mmapFile reads the first `size` bytes of `f` into memory. Positions data files are small enough
+// that this is a reasonable substitute for a memory mapping on Windows.
+func mmapFile(f *os.File, size int) ([]byte, error) {
+	data := make([]byte, size)
+	_, err := io.ReadFull(f, data)
+	return data, err
+}
+
+// munmapFile releases the data read by mmapFile.
+func munmapFile(data []byte) error {
+	return nil
+}
@@ -6,7 +6,9 @@ import (
 	"path/filepath"
 	"strings"
 
+	"github.com/peterwilliams97/pdf-search/serial"
 	"github.com/unidoc/unidoc/common"
+	"github.com/unidoc/unidoc/pdf/core"
 	"github.com/unidoc/unidoc/pdf/creator"
 	pdf "github.com/unidoc/unidoc/pdf/model"
 )
@@ -18,6 +20,8 @@ type ExtractList struct {
 	sourceSet map[string]bool
 	contents  map[string]map[uint32]pageContent // Pages for each document
 	// documentIndex map[string]int
+	style      MarkupStyle    // How the rectangles are drawn.
+	termColors map[string]int // {term: index into style.Colors}
 }
 
 func (l ExtractList) String() string {
@@ -35,8 +39,40 @@ type Extract struct {
 
 type pageContent struct {
 	// pageNum                 // page number (1-offset) of page in source document
-	rects []pdf.PdfRectangle // the rectangles to be drawn on the PDF page
-	page  *pdf.PdfPage       // the UniDoc PDF page. Created as needed.
+	rects []markupRect // the rectangles to be drawn on the PDF page
+	page  *pdf.PdfPage // the UniDoc PDF page. Created as needed.
+}
+
+// markupRect is a rectangle to be drawn on a PDF page.
+type markupRect struct {
+	rect  pdf.PdfRectangle
+	term  string // The matched term that the rectangle surrounds. May be empty.
+	color string // Hex color of the rectangle.
+}
+
+// MarkupStyle controls how an ExtractList marks up PDF pages.
+type MarkupStyle struct {
+	// Colors are the hex colors of the rectangles. Each matched term gets its own color, cycling
+	// through Colors. The first color is used for rectangles that aren't for a term.
+	Colors      []string
+	BorderWidth float64 // Width of the rectangle borders.
+	Shadow      bool    // Draw a white shadow around the rectangle borders so they stand out.
+	// Annotate marks up the matches with square annotations instead of drawing rectangles on the
+	// pages. The annotations have popups containing the matched terms and can be filled with
+	// transparent colors.
+	Annotate    bool
+	Fill        bool    // Fill the annotations with the term colors. Only used if Annotate is set.
+	FillOpacity float64 // Opacity, 0 to 1, of the annotations and of HighlightPdf highlights.
+}
+
+// DefaultMarkupStyle returns the MarkupStyle used by ExtractLists created by CreateExtractList.
+func DefaultMarkupStyle() MarkupStyle {
+	return MarkupStyle{
+		Colors:      []string{"#0000ff", "#ff0000", "#00a000", "#ff8000", "#a000a0", "#00a0a0"},
+		BorderWidth: BorderWidth,
+		Shadow:      true,
+		FillOpacity: 0.3,
+	}
 }
 
 // type DocContents struct {
@@ -44,7 +80,62 @@ type pageContent struct {
 // 	pages    []*pdf.PdfPage // pages
 // }
 
+// SetStyle sets the MarkupStyle of `l` to `style`.
+func (l *ExtractList) SetStyle(style MarkupStyle) {
+	if len(style.Colors) == 0 {
+		style.Colors = DefaultMarkupStyle().Colors
+	}
+	l.style = style
+}
+
+// AddRect adds a rectangle with corners (`llx`, `lly`), (`urx`, `ury`) on page `pageNum` of PDF
+// `inPath` to `l`.
 func (l *ExtractList) AddRect(inPath string, pageNum uint32, llx, lly, urx, ury float32) {
+	l.addRect(inPath, pageNum, llx, lly, urx, ury, "")
+}
+
+// AddPdfMatch adds rectangles for all the matched terms in `m` to `l`. Each term is drawn in its
+// own color. See MarkupStyle. Matches in image files are skipped.
+func (l *ExtractList) AddPdfMatch(m PdfMatch) {
+	if IsImageFile(m.InPath) {
+		common.Log.Info("AddPdfMatch: Can't mark up image file %q", m.InPath)
+		return
+	}
+	for i, pos := range m.Positions {
+		if pos == (serial.TextLocation{}) {
+			continue // No bounding box was found for this span.
+		}
+		term := ""
+		if i < len(m.Spans) {
+			term = m.Spans[i].Term
+		}
+		l.addRect(m.InPath, m.PageNum, pos.Llx, pos.Lly, pos.Urx, pos.Ury, term)
+	}
+}
+
+// termColor returns the color of the rectangles for `term`.
+func (l *ExtractList) termColor(term string) string {
+	return l.style.termColor(l.termColors, term)
+}
+
+// termColor returns the color in `s` for `term`. `termColors` is {term: index into s.Colors} for
+// the terms that have been assigned colors. New terms are added to it.
+func (s MarkupStyle) termColor(termColors map[string]int, term string) string {
+	if term == "" {
+		return s.Colors[0]
+	}
+	i, ok := termColors[term]
+	if !ok {
+		i = len(termColors) % len(s.Colors)
+		termColors[term] = i
+	}
+	return s.Colors[i]
+}
+
+// addRect adds a rectangle with corners (`llx`, `lly`), (`urx`, `ury`) around matched term `term`
+// on page `pageNum` of PDF `inPath` to `l`.
+func (l *ExtractList) addRect(inPath string, pageNum uint32, llx, lly, urx, ury float32,
+	term string) {
 	common.Log.Info("AddRect %q %3d {%.1f %.1f %.1f %.1f}", filepath.Base(inPath), pageNum, llx, lly, urx, ury)
 	pathPage := fmt.Sprintf("%s.%d", inPath, pageNum)
 	if !l.sourceSet[pathPage] {
@@ -63,23 +154,27 @@ func (l *ExtractList) AddRect(inPath string, pageNum uint32, llx, lly, urx, ury
 		l.contents[inPath] = docContent
 	}
 	pageContent := docContent[pageNum]
-	if len(pageContent.rects) >= 3 {
-		return
+	r := markupRect{
+		rect: pdf.PdfRectangle{Llx: float64(llx), Lly: float64(lly), Urx: float64(urx),
+			Ury: float64(ury)},
+		term:  term,
+		color: l.termColor(term),
 	}
-	r := pdf.PdfRectangle{float64(llx), float64(lly), float64(urx), float64(ury)}
-	pageContent.rects = append(pageContent.rects, r)
 	if pageNum == 0 {
-		common.Log.Error("inPath=%q pageNum=%d", inPath, pageNum)
-		panic("xxxx")
+		common.Log.Error("addRect: Bad page number. inPath=%q pageNum=%d", inPath, pageNum)
+		return
 	}
+	pageContent.rects = append(pageContent.rects, r)
 	docContent[pageNum] = pageContent
 }
 
 func CreateExtractList(maxPages int) *ExtractList {
 	return &ExtractList{
-		maxPages:  maxPages,
-		contents:  map[string]map[uint32]pageContent{},
-		sourceSet: map[string]bool{},
+		maxPages:   maxPages,
+		contents:   map[string]map[uint32]pageContent{},
+		sourceSet:  map[string]bool{},
+		style:      DefaultMarkupStyle(),
+		termColors: map[string]int{},
 	}
 }
 
@@ -138,24 +233,36 @@ func (l *ExtractList) SaveOutputPdf(outPath string) error {
 			common.Log.Error("%d: %+v", i, src)
 			return errMissing
 		}
+		if l.style.Annotate {
+			for _, r := range pageContent.rects {
+				pageContent.page.AddAnnotation(l.style.annotation(r))
+			}
+		}
 		if err := c.AddPage(pageContent.page); err != nil {
 			common.Log.Error("%d: %+v ", i, src)
 			return err
 		}
+		if l.style.Annotate {
+			continue
+		}
 
 		h := pageContent.page.MediaBox.Ury
-		shift := 2.0 // !@#$ Hack to line up highlight box
-		for _, r := range pageContent.rects {
-			common.Log.Info("SaveOutputPdf: %q:%d %s", filepath.Base(src.inPath), src.pageNum, rectString(r))
-			rect := c.NewRectangle(r.Llx, h-r.Lly+shift, r.Urx-r.Llx, -(r.Ury - r.Lly + shift))
+		for _, m := range pageContent.rects {
+			r := m.rect
+			common.Log.Info("SaveOutputPdf: %q:%d %s", filepath.Base(src.inPath), src.pageNum,
+				rectString(r))
+			rect := c.NewRectangle(r.Llx, h-r.Lly+markupShift, r.Urx-r.Llx,
+				-(r.Ury - r.Lly + markupShift))
 			// rect := c.NewRectangle(r.Llx, r.Lly, r.Urx-r.Llx, r.Ury-r.Lly)
-			rect.SetBorderColor(creator.ColorRGBFromHex("#ffffff")) // White border shadow.
-			rect.SetBorderWidth(ShadowWidth)
-			if err := c.Draw(rect); err != nil {
-				return err
+			if l.style.Shadow {
+				rect.SetBorderColor(creator.ColorRGBFromHex("#ffffff")) // White border shadow.
+				rect.SetBorderWidth(l.style.BorderWidth + ShadowWidth - BorderWidth)
+				if err := c.Draw(rect); err != nil {
+					return err
+				}
 			}
-			rect.SetBorderColor(creator.ColorRGBFromHex("#0000ff")) // Red border.
-			rect.SetBorderWidth(BorderWidth)
+			rect.SetBorderColor(creator.ColorRGBFromHex(m.color))
+			rect.SetBorderWidth(l.style.BorderWidth)
 			if err := c.Draw(rect); err != nil {
 				return err
 			}
@@ -165,6 +272,33 @@ func (l *ExtractList) SaveOutputPdf(outPath string) error {
 	return c.WriteToFile(outPath)
 }
 
+// markupShift is the distance rectangles are extended below their text to line them up with it.
+const markupShift = 2.0 // !@#$ Hack to line up highlight box
+
+// annotation returns a square annotation for `r` in style `s`. The annotation's popup contains
+// the matched term.
+func (s MarkupStyle) annotation(m markupRect) *pdf.PdfAnnotation {
+	r := m.rect
+	annot := pdf.NewPdfAnnotationSquare()
+	annot.Rect = core.MakeArrayFromFloats([]float64{r.Llx, r.Lly - markupShift, r.Urx, r.Ury})
+	red, green, blue := creator.ColorRGBFromHex(m.color).ToRGB()
+	color := core.MakeArrayFromFloats([]float64{red, green, blue})
+	annot.C = color
+	if s.Fill {
+		annot.IC = color
+	}
+	annot.BS = core.MakeDict()
+	annot.BS.(*core.PdfObjectDictionary).Set("W", core.MakeFloat(s.BorderWidth))
+	if s.FillOpacity > 0 {
+		annot.CA = core.MakeFloat(s.FillOpacity)
+	}
+	if m.term != "" {
+		annot.Contents = core.MakeString(m.term)
+		annot.T = core.MakeString("pdf-search")
+	}
+	return annot.PdfAnnotation
+}
+
 func rectString(r pdf.PdfRectangle) string {
 	return fmt.Sprintf("{llx: %4.1f lly: %4.1f urx: %4.1f ury: %4.1f} %.1f x %.1f",
 		r.Llx, r.Lly, r.Urx, r.Ury, r.Urx-r.Llx, r.Ury-r.Lly)
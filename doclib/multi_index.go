@@ -0,0 +1,306 @@
+package doclib
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/blevesearch/bleve"
+	"github.com/blevesearch/bleve/search"
+)
+
+// ScoreNormalization is how a MultiIndex makes the scores of matches from different stores
+// comparable. bleve scores depend on the term statistics of the index that was searched, so a
+// term that is rare in a small store scores much higher there than in a big store.
+type ScoreNormalization int
+
+const (
+	// NormalizeMax divides the scores of each store's matches by the score of its best match.
+	NormalizeMax ScoreNormalization = iota
+	// NormalizeMinMax scales the scores of each store's matches to [0, 1] over the matches
+	// returned from that store.
+	NormalizeMinMax
+	// NormalizeNone uses the raw bleve scores. MultiIndexes of local stores opened with
+	// OpenMultiIndex search all the stores with one bleve IndexAlias query and rank the matches
+	// globally.
+	NormalizeNone
+)
+
+// MultiIndex searches several stores and merges their matches. It is safe for concurrent use.
+type MultiIndex struct {
+	searchers []Searcher
+	names     []string
+	local     []*PdfIndex // The stores if they were opened by OpenMultiIndex. Otherwise nil.
+	// Normalization is how scores from different stores are made comparable.
+	Normalization ScoreNormalization
+	// Weights, if not nil, are per-store calibration factors. The normalized scores of store i's
+	// matches are multiplied by Weights[i]. Use them to favor stores with better documents.
+	Weights []float64
+}
+
+// MultiMatch is a match from one of the stores of a MultiIndex.
+type MultiMatch struct {
+	Store     string  // Name of the store the match is from.
+	StoreIdx  int     // Index of the store in the MultiIndex.
+	NormScore float64 // Normalized score that the matches are ordered by.
+	PdfMatch
+}
+
+// MultiMatchSet is the result of a MultiIndex search.
+type MultiMatchSet struct {
+	TotalMatches   int           // Sum of the TotalMatches of the stores.
+	SearchDuration time.Duration // Wall clock time of the search.
+	From           int
+	Matches        []MultiMatch
+	// StoreErrors is {store name: error} for the stores that couldn't be searched. The matches
+	// from the other stores are still returned.
+	StoreErrors map[string]string `json:",omitempty"`
+}
+
+// NewMultiIndex returns a MultiIndex over `searchers`. `names`[i] is the name reported for
+// matches from `searchers`[i], e.g. the store's directory or server URL.
+func NewMultiIndex(searchers []Searcher, names []string) (*MultiIndex, error) {
+	if len(searchers) == 0 {
+		return nil, fmt.Errorf("MultiIndex needs at least one store")
+	}
+	if len(names) != len(searchers) {
+		return nil, fmt.Errorf("MultiIndex has %d stores and %d names", len(searchers), len(names))
+	}
+	return &MultiIndex{searchers: searchers, names: names}, nil
+}
+
+// OpenMultiIndex opens the persistent stores in `persistDirs`, e.g. one store per corpus or per
+// month, for searching as one store. The stores are searched with a bleve IndexAlias so the
+// matches from all the stores are ranked together. Call Close when finished.
+func OpenMultiIndex(persistDirs []string) (*MultiIndex, error) {
+	var local []*PdfIndex
+	var searchers []Searcher
+	for _, persistDir := range persistDirs {
+		x, err := OpenPdfIndex(persistDir)
+		if err != nil {
+			for _, x := range local {
+				x.Close()
+			}
+			return nil, err
+		}
+		local = append(local, x)
+		searchers = append(searchers, x)
+	}
+	m, err := NewMultiIndex(searchers, persistDirs)
+	if err != nil {
+		return nil, err
+	}
+	m.local = local
+	m.Normalization = NormalizeNone
+	return m, nil
+}
+
+// Close closes the stores opened by OpenMultiIndex. Stores passed to NewMultiIndex are not closed.
+func (m *MultiIndex) Close() error {
+	var err error
+	for _, x := range m.local {
+		if e := x.Close(); e != nil && err == nil {
+			err = e
+		}
+	}
+	return err
+}
+
+// Search returns the matches for query `term` over all the stores in `m`, ordered by normalized
+// score. opts.Cursor is not supported. Use opts.From to page through the matches.
+// Each store is asked for opts.From+opts.MaxResults matches so that the merged matches are in the
+// same order whatever page is requested.
+func (m *MultiIndex) Search(term string, opts SearchOptions) (MultiMatchSet, error) {
+	if opts.Cursor != "" {
+		return MultiMatchSet{}, fmt.Errorf("MultiIndex doesn't support cursors. Use From")
+	}
+	if m.local != nil && m.Normalization == NormalizeNone {
+		return m.searchAlias(term, opts)
+	}
+	t0 := time.Now()
+	storeOpts := opts
+	storeOpts.From = 0
+	storeOpts.MaxResults = opts.From + opts.MaxResults
+
+	results := make([]PdfMatchSet, len(m.searchers))
+	errs := make([]error, len(m.searchers))
+	var wg sync.WaitGroup
+	for i, s := range m.searchers {
+		wg.Add(1)
+		go func(i int, s Searcher) {
+			defer wg.Done()
+			results[i], errs[i] = s.Search(term, storeOpts)
+		}(i, s)
+	}
+	wg.Wait()
+
+	var set MultiMatchSet
+	for i, result := range results {
+		if errs[i] != nil {
+			if set.StoreErrors == nil {
+				set.StoreErrors = map[string]string{}
+			}
+			set.StoreErrors[m.names[i]] = errs[i].Error()
+			continue
+		}
+		set.TotalMatches += result.TotalMatches
+		scores := m.normalize(i, result.Matches)
+		for j, pm := range result.Matches {
+			set.Matches = append(set.Matches, MultiMatch{
+				Store:     m.names[i],
+				StoreIdx:  i,
+				NormScore: scores[j],
+				PdfMatch:  pm,
+			})
+		}
+	}
+	if len(set.StoreErrors) == len(m.searchers) {
+		return set, fmt.Errorf("Could not search any store. err=%v", errs[0])
+	}
+
+	sort.SliceStable(set.Matches, func(i, j int) bool {
+		return set.Matches[i].NormScore > set.Matches[j].NormScore
+	})
+	if len(set.Matches) > opts.From {
+		set.Matches = set.Matches[opts.From:]
+	} else {
+		set.Matches = nil
+	}
+	if len(set.Matches) > opts.MaxResults {
+		set.Matches = set.Matches[:opts.MaxResults]
+	}
+	set.From = opts.From
+	set.SearchDuration = time.Since(t0)
+	return set, nil
+}
+
+// searchAlias returns the matches for query `term` over the local stores of `m`. The stores' bleve
+// indexes are searched with one IndexAlias query, which merges their hits by score, and each hit is
+// looked up in the Store of its store. opts.Boosts and opts.Within are not supported.
+func (m *MultiIndex) searchAlias(term string, opts SearchOptions) (MultiMatchSet, error) {
+	if len(opts.Boosts) > 0 || opts.Within > 0 {
+		return MultiMatchSet{}, fmt.Errorf("MultiIndex doesn't support boosts or proximity " +
+			"search over all stores. Use a score normalization")
+	}
+	t0 := time.Now()
+	for _, x := range m.local {
+		if err := x.refresh(); err != nil {
+			return MultiMatchSet{}, err
+		}
+	}
+	// Hold the stores open while their indexes are in the alias.
+	var indexes []bleve.Index
+	storeIdx := map[string]int{} // {bleve index name: index in m.local}
+	var normalization TextNormalization
+	for i, x := range m.local {
+		x.mu.RLock()
+		defer x.mu.RUnlock()
+		if x.index == nil {
+			return MultiMatchSet{}, ErrClosed
+		}
+		indexes = append(indexes, x.index)
+		// Stores with different normalizations are searched for the most normalized query.
+		normalization |= x.lState.normalization
+		if sx, ok := x.index.(*shardedIndex); ok {
+			for _, shard := range sx.shards {
+				storeIdx[shard.Name()] = i
+			}
+		} else {
+			storeIdx[x.index.Name()] = i
+		}
+	}
+	alias := bleve.NewIndexAlias(indexes...)
+	term = normalization.normalizeQuery(term)
+	request := makeSearchRequest(alias, term, opts)
+	request.From, request.Size = opts.From, opts.MaxResults
+	sr, err := alias.Search(request)
+	if err != nil {
+		return MultiMatchSet{}, err
+	}
+
+	storeHits := make([]search.DocumentMatchCollection, len(m.local))
+	for _, hit := range sr.Hits {
+		i, ok := storeIdx[hit.Index]
+		if !ok {
+			return MultiMatchSet{}, fmt.Errorf("Hit %q from unknown index %q", hit.ID, hit.Index)
+		}
+		storeHits[i] = append(storeHits[i], hit)
+	}
+	set := MultiMatchSet{TotalMatches: int(sr.Total), From: opts.From}
+	for i, hits := range storeHits {
+		if len(hits) == 0 {
+			continue
+		}
+		matches, err := m.local[i].lState.hydrateHits(hits)
+		if err != nil {
+			return MultiMatchSet{}, err
+		}
+		for _, pm := range matches {
+			set.Matches = append(set.Matches, MultiMatch{
+				Store:     m.names[i],
+				StoreIdx:  i,
+				NormScore: pm.Score,
+				PdfMatch:  pm,
+			})
+		}
+	}
+	sort.SliceStable(set.Matches, func(i, j int) bool {
+		return set.Matches[i].NormScore > set.Matches[j].NormScore
+	})
+	set.SearchDuration = time.Since(t0)
+	return set, nil
+}
+
+// normalize returns the normalized scores of `matches` from store `storeIdx`.
+func (m *MultiIndex) normalize(storeIdx int, matches []PdfMatch) []float64 {
+	scores := make([]float64, len(matches))
+	if len(matches) == 0 {
+		return scores
+	}
+	top, bottom := matches[0].Score, matches[0].Score
+	for _, pm := range matches {
+		if pm.Score > top {
+			top = pm.Score
+		}
+		if pm.Score < bottom {
+			bottom = pm.Score
+		}
+	}
+	for i, pm := range matches {
+		score := pm.Score
+		switch m.Normalization {
+		case NormalizeMax:
+			if top > 0 {
+				score /= top
+			}
+		case NormalizeMinMax:
+			if top > bottom {
+				score = (score - bottom) / (top - bottom)
+			} else {
+				score = 1.0
+			}
+		}
+		scores[i] = score
+	}
+	if storeIdx < len(m.Weights) {
+		for i := range scores {
+			scores[i] *= m.Weights[storeIdx]
+		}
+	}
+	return scores
+}
+
+func (s MultiMatchSet) String() string {
+	parts := []string{fmt.Sprintf("%d matches from %d. TotalMatches=%d duration=%.3f sec",
+		len(s.Matches), s.From+1, s.TotalMatches, s.SearchDuration.Seconds())}
+	for i, m := range s.Matches {
+		parts = append(parts, fmt.Sprintf("%3d: %.3f (%.3f) %s %q page %d line %d: %q",
+			s.From+i+1, m.NormScore, m.Score, m.Store, m.InPath, m.PageNum, m.LineNum, m.Line))
+	}
+	for name, err := range s.StoreErrors {
+		parts = append(parts, fmt.Sprintf("%s: %s", name, err))
+	}
+	return strings.Join(parts, "\n")
+}
@@ -0,0 +1,363 @@
+package doclib
+
+import (
+	"hash/fnv"
+	"math/bits"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/blevesearch/bleve/search"
+)
+
+// DefaultNearDuplicateDistance is the largest number of bits by which the fingerprints of two
+// pages can differ for the pages to be near-duplicates. See pageFingerprint.
+const DefaultNearDuplicateDistance = 3
+
+// shingleSize is the number of words in the shingles that are hashed into page fingerprints.
+const shingleSize = 3
+
+// pageFingerprint returns the simhash of page text `text`. The simhashes of pages whose texts
+// differ in a few words, such as the same page in two revisions of a manual, differ in a few bits.
+// The hashed features are the runs of shingleSize words in `text`, ignoring case and punctuation.
+// It returns 0 if `text` has no words.
+func pageFingerprint(text string) uint64 {
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+	if len(words) == 0 {
+		return 0
+	}
+	n := len(words) - shingleSize + 1
+	if n < 1 {
+		n = 1
+	}
+	var weights [64]int
+	for i := 0; i < n; i++ {
+		end := i + shingleSize
+		if end > len(words) {
+			end = len(words)
+		}
+		h := fnv.New64a()
+		h.Write([]byte(strings.Join(words[i:end], " ")))
+		sum := h.Sum64()
+		for b := 0; b < 64; b++ {
+			if sum&(1<<uint(b)) != 0 {
+				weights[b]++
+			} else {
+				weights[b]--
+			}
+		}
+	}
+	var fp uint64
+	for b, w := range weights {
+		if w > 0 {
+			fp |= 1 << uint(b)
+		}
+	}
+	return fp
+}
+
+// fingerprintDistance returns the number of bits by which fingerprints `a` and `b` differ.
+func fingerprintDistance(a, b uint64) int {
+	return bits.OnesCount64(a ^ b)
+}
+
+// setFingerprint records that `fp` is the fingerprint of the page with index `pageIdx` of the
+// document described by `fd`.
+func (fd *FileDesc) setFingerprint(pageIdx uint32, fp uint64) {
+	for uint32(len(fd.Fingerprints)) <= pageIdx {
+		fd.Fingerprints = append(fd.Fingerprints, 0)
+	}
+	fd.Fingerprints[pageIdx] = fp
+}
+
+// pageFingerprintOf returns the fingerprint of page `pageIdx` of document `docIdx` in `lState` or 0
+// if it isn't known.
+func (lState *Store) pageFingerprintOf(docIdx uint64, pageIdx uint32) uint64 {
+	if docIdx >= uint64(len(lState.fileList)) {
+		return 0
+	}
+	fps := lState.fileList[docIdx].Fingerprints
+	if pageIdx >= uint32(len(fps)) {
+		return 0
+	}
+	return fps[pageIdx]
+}
+
+// PageRef identifies a page in a Store.
+type PageRef struct {
+	DocIdx  uint64
+	PageIdx uint32
+	InPath  string
+	PageNum uint32 // PDF page number (1-offset).
+}
+
+// NearDuplicatePages returns the groups of pages in `lState` whose texts are near-duplicates: each
+// page in a group has a fingerprint within `maxDistance` bits of another page in the group. Pages
+// that were indexed before fingerprints were recorded aren't in any group. Groups are sorted by
+// their first pages and the pages in each group by document and page.
+func (lState *Store) NearDuplicatePages(maxDistance int) ([][]PageRef, error) {
+	var refs []PageRef
+	var fps []uint64
+	for docIdx, fd := range lState.fileList {
+		for pageIdx, fp := range fd.Fingerprints {
+			if fp == 0 {
+				continue
+			}
+			refs = append(refs, PageRef{DocIdx: uint64(docIdx), PageIdx: uint32(pageIdx),
+				InPath: fd.InPath})
+			fps = append(fps, fp)
+		}
+	}
+	var groups [][]PageRef
+	for _, idxs := range nearDuplicateGroups(fps, maxDistance) {
+		group := make([]PageRef, len(idxs))
+		for i, j := range idxs {
+			group[i] = refs[j]
+		}
+		groups = append(groups, group)
+	}
+	for _, group := range groups {
+		if err := lState.setPageNums(group); err != nil {
+			return nil, err
+		}
+	}
+	return groups, nil
+}
+
+// setPageNums sets the PageNum of each PageRef in `refs` from the page indexes of the documents
+// in `lState`.
+func (lState *Store) setPageNums(refs []PageRef) error {
+	for i := range refs {
+		ref := &refs[i]
+		lDoc, err := lState.OpenPositionsDoc(ref.DocIdx)
+		if err != nil {
+			return err
+		}
+		ref.PageNum, err = lDoc.PageNum(ref.PageIdx)
+		lDoc.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// NearDuplicateDocs returns the groups of documents in `lState` that are near-duplicates, such as
+// revisions of the same manual. Two documents are near-duplicates if at least `minFraction` of the
+// pages of the shorter one have near-duplicates, within `maxDistance` bits, in the other. The
+// groups are the paths of the documents in document order.
+func (lState *Store) NearDuplicateDocs(maxDistance int, minFraction float64) [][]string {
+	var docIdxs []int
+	var fps []uint64
+	numPages := make([]int, len(lState.fileList))
+	for docIdx, fd := range lState.fileList {
+		for _, fp := range fd.Fingerprints {
+			if fp == 0 {
+				continue
+			}
+			docIdxs = append(docIdxs, docIdx)
+			fps = append(fps, fp)
+			numPages[docIdx]++
+		}
+	}
+
+	// shared[{a, b}] is the number of pages of document a with near-duplicates in document b.
+	type docPair struct{ a, b int }
+	shared := map[docPair]int{}
+	for _, group := range nearDuplicateGroups(fps, maxDistance) {
+		docs := map[int]bool{}
+		for _, j := range group {
+			docs[docIdxs[j]] = true
+		}
+		for _, j := range group {
+			for b := range docs {
+				if a := docIdxs[j]; a != b {
+					shared[docPair{a, b}]++
+				}
+			}
+		}
+	}
+
+	parent := make([]int, len(lState.fileList))
+	for i := range parent {
+		parent[i] = i
+	}
+	for pair, n := range shared {
+		a, b := pair.a, pair.b
+		if numPages[a] > numPages[b] || numPages[a] == numPages[b] && a > b {
+			continue // Only the shorter document's pages are counted.
+		}
+		if float64(n) >= minFraction*float64(numPages[a]) {
+			unionRoots(parent, a, b)
+		}
+	}
+	members := map[int][]string{}
+	var roots []int
+	for docIdx, fd := range lState.fileList {
+		r := findRoot(parent, docIdx)
+		if len(members[r]) == 0 {
+			roots = append(roots, r)
+		}
+		members[r] = append(members[r], fd.InPath)
+	}
+	var groups [][]string
+	for _, r := range roots {
+		if len(members[r]) > 1 {
+			groups = append(groups, members[r])
+		}
+	}
+	return groups
+}
+
+// nearDuplicateGroups returns the groups of indexes of the fingerprints in `fps` that are
+// transitively within `maxDistance` bits of each other. Groups have at least 2 members.
+// Candidate pairs are found by splitting the fingerprints into maxDistance+1 bands. Fingerprints
+// that differ in at most maxDistance bits are the same in at least one band.
+func nearDuplicateGroups(fps []uint64, maxDistance int) [][]int {
+	if maxDistance < 0 {
+		maxDistance = 0
+	}
+	numBands := maxDistance + 1
+	if numBands > 64 {
+		numBands = 64
+	}
+	type bandKey struct {
+		band  int
+		value uint64
+	}
+	parent := make([]int, len(fps))
+	for i := range parent {
+		parent[i] = i
+	}
+	for band := 0; band < numBands; band++ {
+		lo, hi := band*64/numBands, (band+1)*64/numBands
+		mask := uint64(1)<<uint(hi-lo) - 1
+		if hi-lo == 64 {
+			mask = ^uint64(0)
+		}
+		buckets := map[bandKey][]int{}
+		for i, fp := range fps {
+			key := bandKey{band, fp >> uint(lo) & mask}
+			buckets[key] = append(buckets[key], i)
+		}
+		for _, idxs := range buckets {
+			for x, i := range idxs {
+				for _, j := range idxs[x+1:] {
+					if fingerprintDistance(fps[i], fps[j]) <= maxDistance {
+						unionRoots(parent, i, j)
+					}
+				}
+			}
+		}
+	}
+	members := map[int][]int{}
+	for i := range fps {
+		r := findRoot(parent, i)
+		members[r] = append(members[r], i)
+	}
+	var groups [][]int
+	for _, group := range members {
+		if len(group) > 1 {
+			groups = append(groups, group)
+		}
+	}
+	sort.Slice(groups, func(i, j int) bool { return groups[i][0] < groups[j][0] })
+	return groups
+}
+
+// findRoot returns the root of `i` in the union-find forest `parent`.
+func findRoot(parent []int, i int) int {
+	for parent[i] != i {
+		parent[i] = parent[parent[i]]
+		i = parent[i]
+	}
+	return i
+}
+
+// unionRoots joins the trees of `i` and `j` in the union-find forest `parent`.
+func unionRoots(parent []int, i, j int) {
+	ri, rj := findRoot(parent, i), findRoot(parent, j)
+	if ri < rj {
+		parent[rj] = ri
+	} else if rj < ri {
+		parent[ri] = rj
+	}
+}
+
+// collapseHits returns `hits` without the hits on pages that are near-duplicates of the pages of
+// earlier hits, and {bleve ID of hit: number of near-duplicate hits that were dropped}. Hits on
+// pages without fingerprints are kept.
+func (lState *Store) collapseHits(hits search.DocumentMatchCollection) (
+	search.DocumentMatchCollection, map[string]int) {
+
+	var kept search.DocumentMatchCollection
+	var keptFps []uint64
+	var keptIDs []string
+	collapsed := map[string]int{}
+	for _, hit := range hits {
+		docIdx, pageIdx, err := decodeID(hit.ID)
+		fp := uint64(0)
+		if err == nil {
+			fp = lState.pageFingerprintOf(docIdx, pageIdx)
+		}
+		duplicate := false
+		if fp != 0 {
+			for i, k := range keptFps {
+				if k != 0 && fingerprintDistance(fp, k) <= DefaultNearDuplicateDistance {
+					collapsed[keptIDs[i]]++
+					duplicate = true
+					break
+				}
+			}
+		}
+		if !duplicate {
+			kept = append(kept, hit)
+			keptFps = append(keptFps, fp)
+			keptIDs = append(keptIDs, hit.ID)
+		}
+	}
+	return kept, collapsed
+}
+
+// collapseIdenticalHits returns `hits` without the hits on pages with exactly the same text as the
+// pages of earlier hits, and {bleve ID of hit: pages of the hits that were dropped in its favor}.
+// Pages are compared by the hashes of their texts, so pages that differ only in case or
+// punctuation are kept. The PageNums of the dropped pages aren't set.
+func (lState *Store) collapseIdenticalHits(hits search.DocumentMatchCollection) (
+	search.DocumentMatchCollection, map[string][]PageRef, error) {
+
+	var kept search.DocumentMatchCollection
+	keptIDs := map[string]string{} // {page text hash: bleve ID of the kept hit with it}
+	copies := map[string][]PageRef{}
+	for _, hit := range hits {
+		docIdx, pageIdx, err := decodeID(hit.ID)
+		if err != nil {
+			return nil, nil, err
+		}
+		hash, err := lState.pageTextHash(docIdx, pageIdx)
+		if err != nil {
+			return nil, nil, err
+		}
+		if id, ok := keptIDs[hash]; ok {
+			copies[id] = append(copies[id], PageRef{DocIdx: docIdx, PageIdx: pageIdx,
+				InPath: lState.fileList[docIdx].InPath})
+			continue
+		}
+		keptIDs[hash] = hit.ID
+		kept = append(kept, hit)
+	}
+	return kept, copies, nil
+}
+
+// pageTextHash returns the hash of the text of page `pageIdx` of document `docIdx` in `lState`.
+// See Doc.pageTextHash.
+func (lState *Store) pageTextHash(docIdx uint64, pageIdx uint32) (string, error) {
+	lDoc, err := lState.OpenPositionsDoc(docIdx)
+	if err != nil {
+		return "", err
+	}
+	defer lDoc.Close()
+	return lDoc.pageTextHash(pageIdx)
+}
@@ -0,0 +1,117 @@
+package doclib
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/blevesearch/bleve/search"
+	"github.com/peterwilliams97/pdf-search/serial"
+)
+
+func TestPageFingerprint(t *testing.T) {
+	page := strings.Repeat("The quick brown fox jumps over the lazy dog near the river bank. ", 4) +
+		"Section 4.2 describes how the pump is serviced and how often its filters are changed. " +
+		"Always disconnect the power supply before removing the front panel of the unit."
+	revised := strings.Replace(page, "Section 4.2", "Section 5.1", 1)
+	other := "Quarterly revenue grew in all regions, led by strong sales of consumer products in " +
+		"the second half of the year. Operating costs were flat and margins improved again."
+
+	fp := pageFingerprint(page)
+	if d := fingerprintDistance(fp, pageFingerprint(strings.ToUpper(page))); d != 0 {
+		t.Errorf("case changes the fingerprint. distance=%d", d)
+	}
+	if d := fingerprintDistance(fp, pageFingerprint(revised)); d > 8 {
+		t.Errorf("revised page distance=%d", d)
+	}
+	if d := fingerprintDistance(fp, pageFingerprint(other)); d <= 8 {
+		t.Errorf("unrelated page distance=%d", d)
+	}
+	if fp := pageFingerprint(" -- "); fp != 0 {
+		t.Errorf("no words: fingerprint=%x", fp)
+	}
+}
+
+func TestNearDuplicateGroups(t *testing.T) {
+	fps := []uint64{
+		0xFFFF0000FFFF0000,
+		0x0123456789ABCDEF,
+		0xFFFF0000FFFF0003, // 2 bits from fps[0].
+		0x0123456789ABCDEE, // 1 bit from fps[1].
+		0xAAAAAAAAAAAAAAAA,
+		0xFFFF0000FFFF0007, // 1 bit from fps[2].
+	}
+	expected := [][]int{{0, 2, 5}, {1, 3}}
+	if groups := nearDuplicateGroups(fps, 2); !reflect.DeepEqual(groups, expected) {
+		t.Errorf("got %v expected %v", groups, expected)
+	}
+	expected = [][]int{{1, 3}, {2, 5}}
+	if groups := nearDuplicateGroups(fps, 1); !reflect.DeepEqual(groups, expected) {
+		t.Errorf("maxDistance=1: got %v expected %v", groups, expected)
+	}
+}
+
+// TestCollapseIdenticalHits checks that hits on pages with the same text are collapsed and that
+// pages that differ only in case or punctuation are kept.
+func TestCollapseIdenticalHits(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pdf-search-identical")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	lState, err := OpenPositionsState(dir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	docs := []struct {
+		inPath string
+		hash   string
+		pages  []string
+	}{
+		{"manual.pdf", "a0123456789", []string{"Chapter 1 Introduction", "Safety notes.", "Index"}},
+		{"merged.pdf", "b0123456789",
+			[]string{"Chapter 1 introduction", "Safety notes", "Chapter 1 Introduction"}},
+	}
+	for _, doc := range docs {
+		lDoc, err := lState.CreatePositionsDoc(FileDesc{InPath: doc.inPath, Hash: doc.hash})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i, text := range doc.pages {
+			pageNum := uint32(i + 1)
+			dpl := serial.DocPageLocations{Page: pageNum}
+			if _, err := lDoc.AddDocPage(pageNum, dpl, text); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := lDoc.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var hits search.DocumentMatchCollection
+	for _, id := range []string{pageID(1, 1), pageID(0, 0), pageID(0, 1), pageID(1, 0),
+		pageID(1, 2), pageID(0, 2)} {
+		hits = append(hits, &search.DocumentMatch{ID: id})
+	}
+	kept, copies, err := lState.collapseIdenticalHits(hits)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ids []string
+	for _, hit := range kept {
+		ids = append(ids, hit.ID)
+	}
+	expected := []string{pageID(1, 1), pageID(0, 0), pageID(0, 1), pageID(1, 0), pageID(0, 2)}
+	if !reflect.DeepEqual(ids, expected) {
+		t.Errorf("kept=%q expected=%q", ids, expected)
+	}
+	expectedCopies := map[string][]PageRef{
+		pageID(0, 0): {{DocIdx: 1, PageIdx: 2, InPath: "merged.pdf"}},
+	}
+	if !reflect.DeepEqual(copies, expectedCopies) {
+		t.Errorf("copies=%+v expected=%+v", copies, expectedCopies)
+	}
+}
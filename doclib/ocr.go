@@ -0,0 +1,144 @@
+package doclib
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/peterwilliams97/pdf-search/serial"
+	"github.com/unidoc/unidoc/common"
+	pdf "github.com/unidoc/unidoc/pdf/model"
+)
+
+// ErrNoOCR is returned when OCR is requested from a build without OCR support.
+// Build with `-tags ocr` to enable Tesseract OCR.
+var ErrNoOCR = errors.New("OCR not supported in this build")
+
+// PageOCR recognizes the text on PDF pages that have no text layer.
+// Set IndexOptions.OCR to a PageOCR to index scanned PDFs.
+type PageOCR interface {
+	// RecognizePage returns the words on page number `pageNum` of PDF file `inPath`.
+	// `page` is the parsed page. `inPath` is a file on disk. It is a temporary copy for PDFs that
+	// are read from streams or object stores.
+	RecognizePage(inPath string, pageNum uint32, page *pdf.PdfPage) ([]OCRWord, error)
+	// Extractor returns the name and version of the OCR engine. It is recorded for the documents
+	// that have recognized pages.
+	Extractor() Extractor
+}
+
+// OCRWord is a word recognized by a PageOCR.
+type OCRWord struct {
+	Text               string  // The recognized text.
+	Line               int     // Words with the same Line are on the same line of text.
+	Llx, Lly, Urx, Ury float64 // Bounding box of word in PDF coordinates.
+}
+
+// ocrPageText returns the text and text locations of page number `pageNum` of the PDF `src` as
+// recognized by `ocr`.
+func ocrPageText(ocr PageOCR, src *pdfFile, pageNum uint32, page *pdf.PdfPage) (
+	string, []serial.TextLocation, error) {
+
+	path, err := src.filePath()
+	if err != nil {
+		return "", nil, fmt.Errorf("Could not copy %q for OCR. err=%v", src.inPath, err)
+	}
+	words, err := ocr.RecognizePage(path, pageNum, page)
+	if err != nil {
+		return "", nil, err
+	}
+	text, locations := ocrWordsText(words)
+	common.Log.Debug("ocrPageText: inPath=%q pageNum=%d words=%d text=%d",
+		src.inPath, pageNum, len(words), len(text))
+	return text, locations, nil
+}
+
+// pdfFile is a PDF that is being extracted. PageOCR renders pages from files so PDFs that are read
+// from streams or object stores are copied to a temporary file when their first page is
+// recognized.
+type pdfFile struct {
+	inPath  string        // Name of the PDF.
+	rs      io.ReadSeeker // Reads the PDF. nil if the PDF is the file `inPath`.
+	path    string        // File with the contents of the PDF. "" until it is needed.
+	cleanup func()        // Removes `path` if it is a temporary file.
+	err     error         // Error from copying the PDF.
+}
+
+// filePath returns the path of a file with the contents of `src`.
+func (src *pdfFile) filePath() (string, error) {
+	if src.rs == nil {
+		return src.inPath, nil
+	}
+	if src.path != "" || src.err != nil {
+		return src.path, src.err
+	}
+	// `rs` is being parsed so its position is restored after it is copied.
+	pos, err := src.rs.Seek(0, io.SeekCurrent)
+	if err != nil {
+		src.err = err
+		return "", err
+	}
+	path, cleanup, err := pdfFilePath(src.rs)
+	if _, err2 := src.rs.Seek(pos, io.SeekStart); err2 != nil && err == nil {
+		cleanup()
+		err = err2
+	}
+	if err != nil {
+		src.err = err
+		return "", err
+	}
+	src.path, src.cleanup = path, cleanup
+	return path, nil
+}
+
+// close removes the temporary copy of `src` if there is one.
+func (src *pdfFile) close() {
+	if src.cleanup != nil {
+		src.cleanup()
+	}
+}
+
+// ocrWordsText returns the text and synthetic text locations of `words`.
+// Words are separated by spaces and lines by newlines.
+// Each word has a location with its bounding box at its start offset and the separator after it
+// has a zero width location at the word's right edge. GetPosition combines the locations at the
+// start and end of a span so a span over a whole word gets the word's bounding box.
+func ocrWordsText(words []OCRWord) (string, []serial.TextLocation) {
+	var b strings.Builder
+	var locations []serial.TextLocation
+	prevLine := 0
+	for _, w := range words {
+		if w.Text == "" {
+			continue
+		}
+		if b.Len() > 0 {
+			if w.Line != prevLine {
+				b.WriteString("\n")
+			} else {
+				b.WriteString(" ")
+			}
+		}
+		prevLine = w.Line
+		start := uint32(b.Len())
+		b.WriteString(w.Text)
+		end := uint32(b.Len())
+		locations = append(locations,
+			serial.TextLocation{
+				Start: start,
+				End:   end,
+				Llx:   float32(w.Llx),
+				Lly:   float32(w.Lly),
+				Urx:   float32(w.Urx),
+				Ury:   float32(w.Ury),
+			},
+			serial.TextLocation{
+				Start: end,
+				End:   end + 1,
+				Llx:   float32(w.Urx),
+				Lly:   float32(w.Lly),
+				Urx:   float32(w.Urx),
+				Ury:   float32(w.Ury),
+			})
+	}
+	return b.String(), locations
+}
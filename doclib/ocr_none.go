@@ -0,0 +1,33 @@
+//go:build !ocr
+// +build !ocr
+
+package doclib
+
+import (
+	pdf "github.com/unidoc/unidoc/pdf/model"
+)
+
+// TesseractOCR is a placeholder for builds without the `ocr` tag.
+type TesseractOCR struct{}
+
+// NewTesseractOCR returns ErrNoOCR. Build with `-tags ocr` for Tesseract OCR.
+func NewTesseractOCR(lang string, dpi int) (*TesseractOCR, error) {
+	return nil, ErrNoOCR
+}
+
+// RecognizePage returns ErrNoOCR.
+func (t *TesseractOCR) RecognizePage(inPath string, pageNum uint32, page *pdf.PdfPage) (
+	[]OCRWord, error) {
+	return nil, ErrNoOCR
+}
+
+// Extractor returns the name of the OCR engine.
+func (t *TesseractOCR) Extractor() Extractor {
+	return Extractor{Name: "tesseract"}
+}
+
+// RecognizeImage returns ErrNoOCR.
+func (t *TesseractOCR) RecognizeImage(inPath string, data []byte) ([]OCRWord, float64, float64,
+	error) {
+	return nil, 0, 0, ErrNoOCR
+}
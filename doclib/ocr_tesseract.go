@@ -0,0 +1,151 @@
+//go:build ocr
+// +build ocr
+
+package doclib
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	_ "image/jpeg" // Register the image formats that RecognizeImage reads.
+	_ "image/png"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+
+	"github.com/otiai10/gosseract"
+	"github.com/unidoc/unidoc/common"
+	pdf "github.com/unidoc/unidoc/pdf/model"
+	_ "golang.org/x/image/tiff"
+)
+
+// TesseractOCR is an ImageOCR that renders pages with pdftoppm and recognizes them and image files
+// with Tesseract.
+// It needs the poppler-utils and Tesseract programs and libraries to be installed.
+type TesseractOCR struct {
+	Lang string // Tesseract language, e.g. "eng".
+	DPI  int    // Resolution pages are rendered at.
+}
+
+// NewTesseractOCR returns a TesseractOCR that recognizes text in language `lang` in pages
+// rendered at `dpi` dots per inch.
+func NewTesseractOCR(lang string, dpi int) (*TesseractOCR, error) {
+	if _, err := exec.LookPath("pdftoppm"); err != nil {
+		return nil, fmt.Errorf("Could not find pdftoppm. err=%v", err)
+	}
+	if lang == "" {
+		lang = "eng"
+	}
+	if dpi <= 0 {
+		dpi = 300
+	}
+	return &TesseractOCR{Lang: lang, DPI: dpi}, nil
+}
+
+// RecognizePage returns the words on page number `pageNum` of PDF file `inPath`.
+// The page is rendered from `inPath` so it must be a file on disk.
+func (t *TesseractOCR) RecognizePage(inPath string, pageNum uint32, page *pdf.PdfPage) (
+	[]OCRWord, error) {
+
+	mediaBox, err := page.GetMediaBox()
+	if err != nil {
+		return nil, err
+	}
+	imagePath, err := t.renderPage(inPath, pageNum)
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(filepath.Dir(imagePath))
+
+	// !@#$ Page rotation is not handled.
+	words, err := t.recognize(func(client *gosseract.Client) error {
+		return client.SetImage(imagePath)
+	}, mediaBox.Llx, mediaBox.Ury)
+	if err != nil {
+		return nil, err
+	}
+	common.Log.Debug("RecognizePage: %q:%d words=%d", inPath, pageNum, len(words))
+	return words, nil
+}
+
+// RecognizeImage returns the words in image file `inPath` whose contents are `data` and the size
+// of the image in points. The image is treated as a scan at t.DPI dots per inch.
+func (t *TesseractOCR) RecognizeImage(inPath string, data []byte) ([]OCRWord, float64, float64,
+	error) {
+
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, 0, 0, fmt.Errorf("Could not read image %q. err=%v", inPath, err)
+	}
+	scale := 72.0 / float64(t.DPI)
+	width, height := float64(config.Width)*scale, float64(config.Height)*scale
+	words, err := t.recognize(func(client *gosseract.Client) error {
+		return client.SetImageFromBytes(data)
+	}, 0, height)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	common.Log.Debug("RecognizeImage: %q %dx%d words=%d", inPath, config.Width, config.Height,
+		len(words))
+	return words, width, height, nil
+}
+
+// recognize returns the words in the image that `setImage` gives a Tesseract client. The word
+// bounding boxes are converted from image pixels with the origin at the top left to points with
+// the origin at the bottom left of a page whose top left corner is at (`llx`, `ury`).
+func (t *TesseractOCR) recognize(setImage func(client *gosseract.Client) error,
+	llx, ury float64) ([]OCRWord, error) {
+
+	client := gosseract.NewClient()
+	defer client.Close()
+	if err := client.SetLanguage(t.Lang); err != nil {
+		return nil, err
+	}
+	if err := setImage(client); err != nil {
+		return nil, err
+	}
+	boxes, err := client.GetBoundingBoxes(gosseract.RIL_WORD)
+	if err != nil {
+		return nil, err
+	}
+
+	scale := 72.0 / float64(t.DPI)
+	words := make([]OCRWord, 0, len(boxes))
+	for _, b := range boxes {
+		r := b.Box
+		words = append(words, OCRWord{
+			Text: b.Word,
+			Line: b.BlockNum<<20 | b.ParNum<<10 | b.LineNum,
+			Llx:  llx + float64(r.Min.X)*scale,
+			Lly:  ury - float64(r.Max.Y)*scale,
+			Urx:  llx + float64(r.Max.X)*scale,
+			Ury:  ury - float64(r.Min.Y)*scale,
+		})
+	}
+	return words, nil
+}
+
+// Extractor returns the name and version of the OCR engine.
+func (t *TesseractOCR) Extractor() Extractor {
+	return Extractor{Name: "tesseract", Version: gosseract.Version()}
+}
+
+// renderPage renders page number `pageNum` of PDF file `inPath` to a PNG file in a new temporary
+// directory and returns the path of the PNG file.
+func (t *TesseractOCR) renderPage(inPath string, pageNum uint32) (string, error) {
+	dir, err := ioutil.TempDir("", "pdf-search-ocr")
+	if err != nil {
+		return "", err
+	}
+	prefix := filepath.Join(dir, "page")
+	page := strconv.Itoa(int(pageNum))
+	cmd := exec.Command("pdftoppm", "-f", page, "-l", page, "-r", strconv.Itoa(t.DPI),
+		"-png", "-singlefile", inPath, prefix)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		os.RemoveAll(dir)
+		return "", fmt.Errorf("Could not render %q:%d. err=%v\n%s", inPath, pageNum, err, out)
+	}
+	return prefix + ".png", nil
+}
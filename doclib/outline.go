@@ -0,0 +1,125 @@
+package doclib
+
+import (
+	"strings"
+
+	"github.com/unidoc/unidoc/common"
+	"github.com/unidoc/unidoc/pdf/core"
+	pdf "github.com/unidoc/unidoc/pdf/model"
+)
+
+// Bookmark is an entry in the outline (bookmarks) of a PDF.
+type Bookmark struct {
+	Title   string `json:"title"`
+	PageNum uint32 `json:"page"`            // Page number (1-offset) of the bookmark's destination.
+	Level   int    `json:"level,omitempty"` // Depth in the outline. Top level bookmarks are 0.
+}
+
+// maxBookmarks is the maximum number of bookmarks read from a PDF. It stops malformed outlines with
+// cycles from being read forever.
+const maxBookmarks = 10000
+
+// ReadOutline returns the bookmarks in the outline of the PDF in `pdfReader` in outline order.
+// Bookmarks whose destinations aren't pages in the PDF, such as named destinations and links to
+// other files, are omitted.
+func ReadOutline(pdfReader *pdf.PdfReader) ([]Bookmark, error) {
+	trailer, err := pdfReader.GetTrailer()
+	if err != nil {
+		return nil, err
+	}
+	root, ok := core.GetDict(trailer.Get("Root"))
+	if !ok {
+		return nil, nil
+	}
+	outlines, ok := core.GetDict(root.Get("Outlines"))
+	if !ok {
+		return nil, nil
+	}
+	// pageNums is {object number of page: page number}.
+	pageNums := map[int64]uint32{}
+	for i, page := range pdfReader.PageList {
+		if obj, ok := page.GetContainingPdfObject().(*core.PdfIndirectObject); ok {
+			pageNums[obj.ObjectNumber] = uint32(i + 1)
+		}
+	}
+	r := outlineReader{pageNums: pageNums, seen: map[*core.PdfObjectDictionary]bool{}}
+	r.readItems(outlines.Get("First"), 0)
+	return r.bookmarks, nil
+}
+
+// outlineReader reads the bookmarks in a PDF outline.
+type outlineReader struct {
+	pageNums  map[int64]uint32                   // {object number of page: page number}
+	seen      map[*core.PdfObjectDictionary]bool // Outline items that have been read.
+	bookmarks []Bookmark
+}
+
+// readItems reads the outline item `obj` and its siblings and their descendants. The items are at
+// depth `level` in the outline.
+func (r *outlineReader) readItems(obj core.PdfObject, level int) {
+	for {
+		item, ok := core.GetDict(obj)
+		if !ok || r.seen[item] || len(r.seen) >= maxBookmarks {
+			return
+		}
+		r.seen[item] = true
+		title, _ := core.GetStringVal(item.Get("Title"))
+		title = strings.TrimSpace(decodePdfText(title))
+		if pageNum := r.destPageNum(item); pageNum > 0 && title != "" {
+			r.bookmarks = append(r.bookmarks, Bookmark{Title: title, PageNum: pageNum, Level: level})
+		} else {
+			common.Log.Debug("readItems: Skipping bookmark %q. No page destination.", title)
+		}
+		r.readItems(item.Get("First"), level+1)
+		obj = item.Get("Next")
+	}
+}
+
+// destPageNum returns the page number of the destination of outline item `item` or 0 if its
+// destination isn't a page in the PDF. The destination is either the item's Dest entry or the
+// D entry of its GoTo action.
+func (r *outlineReader) destPageNum(item *core.PdfObjectDictionary) uint32 {
+	dest := item.Get("Dest")
+	if dest == nil {
+		if action, ok := core.GetDict(item.Get("A")); ok {
+			dest = action.Get("D")
+		}
+	}
+	arr, ok := core.GetArray(dest)
+	if !ok || arr.Len() == 0 {
+		return 0
+	}
+	switch page := arr.Get(0).(type) {
+	case *core.PdfIndirectObject:
+		return r.pageNums[page.ObjectNumber]
+	case *core.PdfObjectReference:
+		return r.pageNums[page.ObjectNumber]
+	}
+	return 0
+}
+
+// enclosingBookmark returns the title of the bookmark in `outline` whose section contains page
+// `pageNum` or "" if there isn't one. This is the bookmark with the highest page number that is
+// not after `pageNum`. Of bookmarks with the same page number, the last one in outline order,
+// which is the most deeply nested one, is chosen.
+func enclosingBookmark(outline []Bookmark, pageNum uint32) string {
+	var best *Bookmark
+	for i, b := range outline {
+		if b.PageNum <= pageNum && (best == nil || b.PageNum >= best.PageNum) {
+			best = &outline[i]
+		}
+	}
+	if best == nil {
+		return ""
+	}
+	return best.Title
+}
+
+// bookmark returns the title of the bookmark whose section contains page `pageNum` of `lDoc`.
+func (lDoc *Doc) bookmark(pageNum uint32) string {
+	fileList := lDoc.lState.fileList
+	if int(lDoc.docIdx) >= len(fileList) {
+		return ""
+	}
+	return enclosingBookmark(fileList[lDoc.docIdx].Outline, pageNum)
+}
@@ -0,0 +1,32 @@
+package doclib
+
+import "testing"
+
+func TestEnclosingBookmark(t *testing.T) {
+	outline := []Bookmark{
+		{Title: "1 Introduction", PageNum: 3},
+		{Title: "2 Syntax", PageNum: 10},
+		{Title: "2.1 Objects", PageNum: 10, Level: 1},
+		{Title: "2.2 Filters", PageNum: 15, Level: 1},
+		{Title: "3 Graphics", PageNum: 30},
+	}
+	tests := []struct {
+		pageNum uint32
+		title   string
+	}{
+		{1, ""},
+		{3, "1 Introduction"},
+		{9, "1 Introduction"},
+		{10, "2.1 Objects"},
+		{20, "2.2 Filters"},
+		{100, "3 Graphics"},
+	}
+	for _, test := range tests {
+		if title := enclosingBookmark(outline, test.pageNum); title != test.title {
+			t.Errorf("enclosingBookmark(%d) = %q. expected %q", test.pageNum, title, test.title)
+		}
+	}
+	if title := enclosingBookmark(nil, 5); title != "" {
+		t.Errorf("enclosingBookmark(nil, 5) = %q. expected \"\"", title)
+	}
+}
@@ -0,0 +1,123 @@
+package doclib
+
+import (
+	"math"
+
+	"github.com/peterwilliams97/pdf-search/serial"
+	pdf "github.com/unidoc/unidoc/pdf/model"
+)
+
+// CoordSpace is a coordinate space for positions on a PDF page.
+type CoordSpace string
+
+const (
+	// CoordsPDF is PDF user space. The origin is the bottom left of the unrotated page and y
+	// increases upwards. serial.TextLocations are in this space.
+	CoordsPDF CoordSpace = "pdf"
+	// CoordsView is the space of the page as a viewer displays it. The origin is the top left of
+	// the visible, rotated page, y increases downwards and the units are points.
+	CoordsView CoordSpace = "view"
+)
+
+// PageBox is the visible area and rotation of a PDF page.
+type PageBox struct {
+	Llx, Lly, Urx, Ury float64 // The page's crop box in PDF user space.
+	Rotate             int     // Clockwise rotation of the page when it is displayed. 0, 90, 180 or 270.
+}
+
+// ViewRect is a rectangle in CoordsView coordinates. (X, Y) is its top left corner.
+type ViewRect struct {
+	X, Y, W, H float64
+}
+
+// getPageBox returns the PageBox of `page`.
+func getPageBox(page *pdf.PdfPage) (PageBox, error) {
+	box := page.CropBox
+	if box == nil {
+		mediaBox, err := page.GetMediaBox()
+		if err != nil {
+			return PageBox{}, err
+		}
+		box = mediaBox
+	}
+	rotate := 0
+	if page.Rotate != nil {
+		rotate = normalizeRotation(int(*page.Rotate))
+	}
+	return PageBox{Llx: box.Llx, Lly: box.Lly, Urx: box.Urx, Ury: box.Ury, Rotate: rotate}, nil
+}
+
+// normalizeRotation returns `rotate` as one of 0, 90, 180 or 270. PDF page rotations must be
+// multiples of 90 degrees but may be negative or more than 360.
+func normalizeRotation(rotate int) int {
+	rotate = (rotate/90*90)%360 + 360
+	return rotate % 360
+}
+
+// IsZero returns true if `b` has no area, e.g. it is for a page in a store that was indexed before
+// page boxes were recorded.
+func (b PageBox) IsZero() bool {
+	return b.Urx <= b.Llx || b.Ury <= b.Lly
+}
+
+// ViewSize returns the width and height of the page described by `b` as it is displayed.
+func (b PageBox) ViewSize() (w, h float64) {
+	w, h = b.Urx-b.Llx, b.Ury-b.Lly
+	if b.Rotate == 90 || b.Rotate == 270 {
+		w, h = h, w
+	}
+	return w, h
+}
+
+// ToView returns the rectangle in CoordsView coordinates of `loc`, which is in CoordsPDF
+// coordinates on the page described by `b`.
+func (b PageBox) ToView(loc serial.TextLocation) ViewRect {
+	x0, y0 := b.pointToView(float64(loc.Llx), float64(loc.Lly))
+	x1, y1 := b.pointToView(float64(loc.Urx), float64(loc.Ury))
+	return ViewRect{
+		X: math.Min(x0, x1),
+		Y: math.Min(y0, y1),
+		W: math.Abs(x1 - x0),
+		H: math.Abs(y1 - y0),
+	}
+}
+
+// ToPDF returns the location in CoordsPDF coordinates of `r`, which is in CoordsView coordinates
+// on the page described by `b`. It is the inverse of ToView.
+func (b PageBox) ToPDF(r ViewRect) (llx, lly, urx, ury float64) {
+	x0, y0 := b.pointToPDF(r.X, r.Y)
+	x1, y1 := b.pointToPDF(r.X+r.W, r.Y+r.H)
+	return math.Min(x0, x1), math.Min(y0, y1), math.Max(x0, x1), math.Max(y0, y1)
+}
+
+// pointToView returns the CoordsView coordinates of PDF user space point (`x`, `y`).
+func (b PageBox) pointToView(x, y float64) (float64, float64) {
+	w, h := b.Urx-b.Llx, b.Ury-b.Lly
+	x, y = x-b.Llx, y-b.Lly
+	switch b.Rotate {
+	case 90:
+		return y, x
+	case 180:
+		return w - x, y
+	case 270:
+		return h - y, w - x
+	}
+	return x, h - y
+}
+
+// pointToPDF returns the PDF user space coordinates of CoordsView point (`u`, `v`).
+func (b PageBox) pointToPDF(u, v float64) (float64, float64) {
+	w, h := b.Urx-b.Llx, b.Ury-b.Lly
+	var x, y float64
+	switch b.Rotate {
+	case 90:
+		x, y = v, u
+	case 180:
+		x, y = w-u, v
+	case 270:
+		x, y = w-v, h-u
+	default:
+		x, y = u, h-v
+	}
+	return x + b.Llx, y + b.Lly
+}
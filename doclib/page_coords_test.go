@@ -0,0 +1,43 @@
+package doclib
+
+import (
+	"testing"
+
+	"github.com/peterwilliams97/pdf-search/serial"
+)
+
+func TestPageBoxToView(t *testing.T) {
+	loc := serial.TextLocation{Llx: 100, Lly: 700, Urx: 150, Ury: 712}
+	tests := []struct {
+		rotate int
+		w, h   float64
+		r      ViewRect
+	}{
+		{0, 612, 792, ViewRect{X: 100, Y: 80, W: 50, H: 12}},
+		{90, 792, 612, ViewRect{X: 700, Y: 100, W: 12, H: 50}},
+		{180, 612, 792, ViewRect{X: 462, Y: 700, W: 50, H: 12}},
+		{270, 792, 612, ViewRect{X: 80, Y: 462, W: 12, H: 50}},
+	}
+	for _, test := range tests {
+		b := PageBox{Urx: 612, Ury: 792, Rotate: test.rotate}
+		if w, h := b.ViewSize(); w != test.w || h != test.h {
+			t.Errorf("rotate=%d size=%gx%g expected=%gx%g", test.rotate, w, h, test.w, test.h)
+		}
+		r := b.ToView(loc)
+		if r != test.r {
+			t.Errorf("rotate=%d r=%+v expected=%+v", test.rotate, r, test.r)
+		}
+		llx, lly, urx, ury := b.ToPDF(r)
+		if llx != 100 || lly != 700 || urx != 150 || ury != 712 {
+			t.Errorf("rotate=%d ToPDF=(%g %g %g %g)", test.rotate, llx, lly, urx, ury)
+		}
+	}
+}
+
+func TestNormalizeRotation(t *testing.T) {
+	for rotate, expected := range map[int]int{0: 0, 90: 90, -90: 270, 450: 90, -540: 180} {
+		if r := normalizeRotation(rotate); r != expected {
+			t.Errorf("rotate=%d r=%d expected=%d", rotate, r, expected)
+		}
+	}
+}
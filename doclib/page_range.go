@@ -0,0 +1,104 @@
+package doclib
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// PageRange is the page numbers First, First+Step, First+2*Step, ... up to Last (1-offset) in a
+// PDF. A Last of 0 means the last page of the PDF. A Step of 0 or 1 means every page.
+type PageRange struct {
+	First uint32
+	Last  uint32
+	Step  uint32
+}
+
+// PageRanges is a list of PageRanges. A page is in a PageRanges if it is in any of its PageRanges.
+// An empty PageRanges has all the pages in a PDF. Explicit lists of pages are PageRanges of single
+// pages.
+type PageRanges []PageRange
+
+// ParsePageRanges returns the page ranges in `s`, which is a comma separated list of page numbers
+// and ranges of page numbers such as "1-50,60,100-". A range with no last page goes to the end of
+// the PDF. A range may have a step, e.g. "1-:2" is the odd pages.
+func ParsePageRanges(s string) (PageRanges, error) {
+	var ranges PageRanges
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		r, err := parsePageRange(part)
+		if err != nil {
+			return nil, fmt.Errorf("Bad page range %q", part)
+		}
+		ranges = append(ranges, r)
+	}
+	return ranges, nil
+}
+
+// parsePageRange returns the page range in `s`, which has the form "<first>[-[<last>]][:<step>]".
+func parsePageRange(s string) (PageRange, error) {
+	var r PageRange
+	if i := strings.Index(s, ":"); i >= 0 {
+		step, err := strconv.ParseUint(s[i+1:], 10, 32)
+		if err != nil || step == 0 {
+			return r, fmt.Errorf("bad step")
+		}
+		r.Step = uint32(step)
+		s = s[:i]
+	}
+	first, last := s, s
+	if i := strings.Index(s, "-"); i >= 0 {
+		first, last = s[:i], s[i+1:]
+	}
+	n, err := strconv.ParseUint(first, 10, 32)
+	if err != nil || n == 0 {
+		return r, fmt.Errorf("bad first page")
+	}
+	r.First = uint32(n)
+	if last != "" {
+		n, err := strconv.ParseUint(last, 10, 32)
+		if err != nil || uint32(n) < r.First {
+			return r, fmt.Errorf("bad last page")
+		}
+		r.Last = uint32(n)
+	}
+	return r, nil
+}
+
+// Contains returns true if page `pageNum` is in `r`.
+func (r PageRange) Contains(pageNum uint32) bool {
+	if pageNum < r.First || (r.Last != 0 && pageNum > r.Last) {
+		return false
+	}
+	return r.Step <= 1 || (pageNum-r.First)%r.Step == 0
+}
+
+// Contains returns true if page `pageNum` is in `ranges` or `ranges` is empty.
+func (ranges PageRanges) Contains(pageNum uint32) bool {
+	if len(ranges) == 0 {
+		return true
+	}
+	for _, r := range ranges {
+		if r.Contains(pageNum) {
+			return true
+		}
+	}
+	return false
+}
+
+// After returns true if page `pageNum` is after all the pages in `ranges`. Page processing can
+// stop at this page.
+func (ranges PageRanges) After(pageNum uint32) bool {
+	if len(ranges) == 0 {
+		return false
+	}
+	for _, r := range ranges {
+		if r.Last == 0 || pageNum <= r.Last {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,61 @@
+package doclib
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestParsePageRanges(t *testing.T) {
+	ranges, err := ParsePageRanges("1-50, 60,100-")
+	if err != nil {
+		t.Fatalf("err=%v", err)
+	}
+	tests := []struct {
+		pageNum uint32
+		in      bool
+		past    bool
+	}{
+		{1, true, false},
+		{50, true, false},
+		{51, false, false},
+		{60, true, false},
+		{99, false, false},
+		{1000, true, false},
+	}
+	for _, test := range tests {
+		if in := ranges.Contains(test.pageNum); in != test.in {
+			t.Errorf("pageNum=%d in=%t expected=%t", test.pageNum, in, test.in)
+		}
+		if past := ranges.After(test.pageNum); past != test.past {
+			t.Errorf("pageNum=%d past=%t expected=%t", test.pageNum, past, test.past)
+		}
+	}
+
+	ranges, err = ParsePageRanges("3-5")
+	if err != nil {
+		t.Fatalf("err=%v", err)
+	}
+	if ranges.Contains(2) || !ranges.Contains(5) || !ranges.After(6) {
+		t.Errorf("ranges=%+v", ranges)
+	}
+
+	ranges, err = ParsePageRanges("2-10:3,20")
+	if err != nil {
+		t.Fatalf("err=%v", err)
+	}
+	var pageNums []uint32
+	for pageNum := uint32(1); !ranges.After(pageNum); pageNum++ {
+		if ranges.Contains(pageNum) {
+			pageNums = append(pageNums, pageNum)
+		}
+	}
+	if fmt.Sprint(pageNums) != "[2 5 8 20]" {
+		t.Errorf("pageNums=%v", pageNums)
+	}
+
+	for _, bad := range []string{"0", "5-3", "a-b", "-4", "1-5:0", "1-5:x"} {
+		if _, err := ParsePageRanges(bad); err == nil {
+			t.Errorf("%q should not parse", bad)
+		}
+	}
+}
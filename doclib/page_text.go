@@ -0,0 +1,293 @@
+package doclib
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/peterwilliams97/pdf-search/serial"
+)
+
+// ErrTextMismatch is returned when the text stored in a Store differs from the text that
+// was indexed in bleve.
+var ErrTextMismatch = errors.New("stored page text differs from indexed text")
+
+// canonicalPageText returns the canonical form of page text `text` and its text locations `locs`.
+// The canonical text is stored in the positions store, indexed by bleve and used to generate
+// snippets, so bleve offsets are exact byte offsets into the stored text.
+// The canonical form doesn't move any characters so the offsets in `locs` remain valid:
+//  - Trailing white space is removed. Locations in the removed text are dropped.
+//  - Each byte of an invalid UTF-8 sequence is replaced by '?'. bleve would otherwise replace the
+//    sequence with a 3 byte U+FFFD.
+func canonicalPageText(text string, locs []serial.TextLocation) (string, []serial.TextLocation) {
+	if !utf8.ValidString(text) {
+		b := make([]byte, 0, len(text))
+		for i := 0; i < len(text); {
+			r, size := utf8.DecodeRuneInString(text[i:])
+			if r == utf8.RuneError && size == 1 {
+				b = append(b, '?')
+			} else {
+				b = append(b, text[i:i+size]...)
+			}
+			i += size
+		}
+		text = string(b)
+	}
+
+	n := len(text)
+	for n > 0 {
+		r, size := utf8.DecodeLastRuneInString(text[:n])
+		if !unicode.IsSpace(r) {
+			break
+		}
+		n -= size
+	}
+	text = text[:n]
+
+	for len(locs) > 0 && locs[len(locs)-1].Start >= uint32(n) {
+		locs = locs[:len(locs)-1]
+	}
+	return text, locs
+}
+
+// PageTextSpan returns the text over [`start`, `end`) in page text `text`. `start` and `end` are
+// byte offsets such as those in TermSpan. An error is returned if the span is not in `text` or
+// doesn't lie on UTF-8 character boundaries.
+func PageTextSpan(text string, start, end uint32) (string, error) {
+	if start > end || end > uint32(len(text)) {
+		return "", fmt.Errorf("span [%d:%d] not in page text (%d bytes). %v",
+			start, end, len(text), ErrTextMismatch)
+	}
+	if !utf8.RuneStart(spanByte(text, start)) || !utf8.RuneStart(spanByte(text, end)) {
+		return "", fmt.Errorf("span [%d:%d] is not on character boundaries. %v",
+			start, end, ErrTextMismatch)
+	}
+	return text[start:end], nil
+}
+
+// spanByte returns the byte at `offset` in `text` or 0 if `offset` is at the end of `text`.
+func spanByte(text string, offset uint32) byte {
+	if offset >= uint32(len(text)) {
+		return 0
+	}
+	return text[offset]
+}
+
+// pageRepeats returns the number of times each page text in `texts` is repeated in a document.
+// repeats[i] is the number of pages in `texts` with the same text as `texts`[i] if page i is the
+// first page with that text, or 0 if page i repeats an earlier page.
+// Only the pages with repeats[i] > 0 are indexed in bleve, which stops boilerplate pages that
+// are repeated many times from inflating the index and the search results.
+func pageRepeats(texts []string) []int {
+	first := map[string]int{} // {text: index of first page with text}
+	repeats := make([]int, len(texts))
+	for i, text := range texts {
+		j, ok := first[text]
+		if !ok {
+			first[text] = i
+			j = i
+		}
+		repeats[j]++
+	}
+	return repeats
+}
+
+// repeatPageNums returns the page numbers of the pages in `lDoc` that have the same text as the
+// page with index `pageIdx`.
+func repeatPageNums(lDoc *Doc, pageIdx uint32) ([]uint32, error) {
+	repeatIdxs, err := repeatPageIdxs(lDoc, pageIdx)
+	if err != nil {
+		return nil, err
+	}
+	pageNums := make([]uint32, len(repeatIdxs))
+	for i, idx := range repeatIdxs {
+		if pageNums[i], err = lDoc.PageNum(idx); err != nil {
+			return nil, err
+		}
+	}
+	return pageNums, nil
+}
+
+// repeatPageIdxs returns the indexes of the pages in `lDoc` that have the same text as the page
+// with index `pageIdx`. Pages are compared by their byteSpan.TextHash so their texts are only read
+// in memory stores and in stores that were created before page texts were content-addressed.
+func repeatPageIdxs(lDoc *Doc, pageIdx uint32) ([]uint32, error) {
+	if lDoc.isMem() {
+		var repeatIdxs []uint32
+		for idx, text := range lDoc.pageTexts {
+			if text == lDoc.pageTexts[pageIdx] {
+				repeatIdxs = append(repeatIdxs, uint32(idx))
+			}
+		}
+		return repeatIdxs, nil
+	}
+	hash, err := lDoc.pageTextHash(pageIdx)
+	if err != nil {
+		return nil, err
+	}
+	var repeatIdxs []uint32
+	for idx := uint32(0); idx < uint32(lDoc.Len()); idx++ {
+		h, err := lDoc.pageTextHash(idx)
+		if err != nil {
+			return nil, err
+		}
+		if h == hash {
+			repeatIdxs = append(repeatIdxs, idx)
+		}
+	}
+	return repeatIdxs, nil
+}
+
+// textPiece records where a run of the original text went in a rewritten text. The original text
+// over [srcStart, srcEnd) became the rewritten text over [dstStart, dstEnd). Runs that were copied
+// have the same length in both. Runs that were deleted are empty in the rewritten text.
+type textPiece struct {
+	srcStart, srcEnd uint32
+	dstStart, dstEnd uint32
+	copied           bool
+}
+
+// textRewriter rewrites a page text from start to end and keeps the table of textPieces that maps
+// offsets in the original text to offsets in the rewritten text, so that the text locations of the
+// original text can be moved to the rewritten text.
+type textRewriter struct {
+	src    string      // The original text.
+	pos    uint32      // Offset in `src` of the text that hasn't been rewritten yet.
+	b      []byte      // The rewritten text.
+	pieces []textPiece // The mapping table in order of offset.
+}
+
+// newTextRewriter returns a textRewriter for page text `src`.
+func newTextRewriter(src string) *textRewriter {
+	return &textRewriter{src: src, b: make([]byte, 0, len(src))}
+}
+
+// keep copies the original text up to offset `end` to the rewritten text.
+func (r *textRewriter) keep(end uint32) {
+	if end <= r.pos {
+		return
+	}
+	dst := uint32(len(r.b))
+	r.b = append(r.b, r.src[r.pos:end]...)
+	if n := len(r.pieces); n > 0 && r.pieces[n-1].copied && r.pieces[n-1].srcEnd == r.pos {
+		r.pieces[n-1].srcEnd, r.pieces[n-1].dstEnd = end, uint32(len(r.b))
+	} else {
+		r.pieces = append(r.pieces, textPiece{r.pos, end, dst, uint32(len(r.b)), true})
+	}
+	r.pos = end
+}
+
+// replace replaces the original text from the current offset to offset `end` with `s`. The
+// locations of the replaced text are moved to the start of `s` or dropped if `s` is empty.
+func (r *textRewriter) replace(end uint32, s string) {
+	dst := uint32(len(r.b))
+	r.b = append(r.b, s...)
+	r.pieces = append(r.pieces, textPiece{r.pos, end, dst, uint32(len(r.b)), false})
+	r.pos = end
+}
+
+// text returns the rewritten text. The rest of the original text is kept.
+func (r *textRewriter) text() string {
+	r.keep(uint32(len(r.src)))
+	return string(r.b)
+}
+
+// mapLocations returns `locs`, which are locations in the original text, moved to the rewritten
+// text. Locations in deleted text are dropped. text must be called first.
+func (r *textRewriter) mapLocations(locs []serial.TextLocation) []serial.TextLocation {
+	mapped := make([]serial.TextLocation, 0, len(locs))
+	for _, loc := range locs {
+		start, ok := r.mapOffset(loc.Start)
+		if !ok {
+			continue
+		}
+		if loc.End > loc.Start {
+			end, _ := r.mapOffset(loc.End - 1)
+			if end < start {
+				end = start
+			}
+			loc.End = end + 1
+		}
+		loc.Start = start
+		mapped = append(mapped, loc)
+	}
+	sort.SliceStable(mapped, func(i, j int) bool { return mapped[i].Start < mapped[j].Start })
+	return mapped
+}
+
+// mapOffset returns the offset in the rewritten text of offset `ofs` in the original text. It
+// returns false if the text at `ofs` was deleted.
+func (r *textRewriter) mapOffset(ofs uint32) (uint32, bool) {
+	i := sort.Search(len(r.pieces), func(i int) bool { return r.pieces[i].srcEnd > ofs })
+	if i == len(r.pieces) {
+		return 0, false
+	}
+	p := r.pieces[i]
+	switch {
+	case p.dstStart == p.dstEnd:
+		return 0, false
+	case p.copied:
+		return p.dstStart + ofs - p.srcStart, true
+	default:
+		return p.dstStart, true
+	}
+}
+
+// hyphenBreakRe matches a word that is broken across lines with a hyphen, e.g. "informa-\ntion".
+// The first group is the end of the first part of the word and the second group is the start of
+// the second part. Only second parts that start with a lower case letter are matched so that
+// hyphenated names such as "Jean-\nPaul" keep their hyphens.
+var hyphenBreakRe = regexp.MustCompile(`(\pL)[-\x{2010}\x{00AD}][ \t]*\n[ \t]*(\p{Ll})`)
+
+// hyphenBreakStartRe matches the hyphen and line break of hyphenBreakRe at the start of a text.
+var hyphenBreakStartRe = regexp.MustCompile(`^[-\x{2010}\x{00AD}][ \t]*\n[ \t]*\p{Ll}`)
+
+// joinHyphenated returns page text `text` and its text locations `locs` with the words that are
+// broken across lines with hyphens joined. The hyphen and line break are removed and the second
+// part of the word is moved to the end of the first line. The line break is moved to after the
+// word so lines keep their numbers, e.g. "an informa-\ntion store" becomes "an information\nstore".
+// The locations of the moved characters move with them and the hyphens' locations are dropped.
+func joinHyphenated(text string, locs []serial.TextLocation) (string, []serial.TextLocation) {
+	matches := hyphenBreakRe.FindAllStringSubmatchIndex(text, -1)
+	if len(matches) == 0 {
+		return text, locs
+	}
+	n := uint32(len(text))
+	r := newTextRewriter(text)
+	breaks := 0 // Number of line breaks removed from the word being joined.
+	for _, m := range matches {
+		hyphen, word := uint32(m[3]), uint32(m[4])
+		if hyphen < r.pos {
+			continue
+		}
+		r.keep(hyphen)
+		r.replace(word, "")
+		breaks++
+		// The second part of the word runs to the next white space. It may itself be broken.
+		wordEnd := word
+		for wordEnd < n {
+			c, size := utf8.DecodeRuneInString(text[wordEnd:])
+			if unicode.IsSpace(c) || hyphenBreakStartRe.MatchString(text[wordEnd:]) {
+				break
+			}
+			wordEnd += uint32(size)
+		}
+		r.keep(wordEnd)
+		if wordEnd < n && text[wordEnd] != ' ' && text[wordEnd] != '\t' && text[wordEnd] != '\n' {
+			continue // The word is broken again.
+		}
+		// Replace the spaces after the word with the line breaks.
+		spaceEnd := wordEnd
+		for spaceEnd < n && (text[spaceEnd] == ' ' || text[spaceEnd] == '\t') {
+			spaceEnd++
+		}
+		r.replace(spaceEnd, strings.Repeat("\n", breaks))
+		breaks = 0
+	}
+	joined := r.text()
+	return joined, r.mapLocations(locs)
+}
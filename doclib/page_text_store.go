@@ -0,0 +1,189 @@
+package doclib
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/unidoc/unidoc/common"
+)
+
+/*
+   Page texts are stored content-addressed so that identical pages in different documents, such as
+   boilerplate cover pages and disclaimers, are stored once.
+
+   <root>/
+      text_refs.json        {text hash: number of pages with that text}
+      texts/
+          <xx>/
+              <text hash>.txt    xx is the first 2 characters of the text hash
+*/
+
+const (
+	textsDirName     = "texts"
+	textRefsFileName = "text_refs.json"
+)
+
+// textHash returns the content address of page text `text`.
+func textHash(text string) string {
+	h := sha256.Sum256([]byte(text))
+	return hex.EncodeToString(h[:])
+}
+
+// textsDir returns the directory where `lState` stores page texts.
+func (lState *Store) textsDir() string {
+	return filepath.Join(lState.root, textsDirName)
+}
+
+// textPath returns the path of the page text with hash `hash` in `lState`.
+func (lState *Store) textPath(hash string) string {
+	return filepath.Join(lState.textsDir(), hash[:2], hash+".txt")
+}
+
+// textRefsPath is the path where lState.textRefs is stored on disk.
+func (lState *Store) textRefsPath() string {
+	return filepath.Join(lState.root, textRefsFileName)
+}
+
+// addPageText stores page text `text` in `lState` if it isn't already stored and adds a reference
+// to it. It returns the hash of `text`.
+func (lState *Store) addPageText(text string) (string, error) {
+	if err := lState.loadTextRefs(); err != nil {
+		return "", err
+	}
+	hash := textHash(text)
+	path := lState.textPath(hash)
+	if lState.textRefs[hash] == 0 || !Exists(path) {
+		if err := os.MkdirAll(filepath.Dir(path), 0777); err != nil {
+			return "", err
+		}
+		if err := ioutil.WriteFile(path, []byte(text), 0644); err != nil {
+			return "", err
+		}
+	}
+	lState.textRefs[hash]++
+	return hash, nil
+}
+
+// releasePageText removes a reference to the page text with hash `hash` from `lState` and deletes
+// the text when there are no more references to it.
+func (lState *Store) releasePageText(hash string) error {
+	if err := lState.loadTextRefs(); err != nil {
+		return err
+	}
+	if lState.textRefs[hash] > 1 {
+		lState.textRefs[hash]--
+		return nil
+	}
+	delete(lState.textRefs, hash)
+	err := os.Remove(lState.textPath(hash))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// releasePageTexts removes `lDoc`'s references to its page texts. See releasePageText.
+func (lDoc *Doc) releasePageTexts() error {
+	if lDoc.isMem() {
+		return nil
+	}
+	for _, span := range lDoc.spans {
+		if span.TextHash == "" {
+			continue
+		}
+		if err := lDoc.lState.releasePageText(span.TextHash); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// loadTextRefs loads the page text reference counts of `lState` from disk if they haven't been
+// loaded. They are only needed when a store is being modified so they aren't loaded by
+// OpenPositionsState.
+func (lState *Store) loadTextRefs() error {
+	if lState.textRefs != nil {
+		return nil
+	}
+	textRefs := map[string]int{}
+	b, err := ioutil.ReadFile(lState.textRefsPath())
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err == nil {
+		if err := json.Unmarshal(b, &textRefs); err != nil {
+			return err
+		}
+	}
+	lState.textRefs = textRefs
+	return nil
+}
+
+// saveTextRefs saves the page text reference counts of `lState` to disk if they have been loaded.
+func (lState *Store) saveTextRefs() error {
+	if lState.textRefs == nil {
+		return nil
+	}
+	b, err := json.MarshalIndent(lState.textRefs, "", "\t")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(lState.textRefsPath(), b, 0666)
+}
+
+// recountTextRefs rebuilds the page text reference counts of `lState` from the documents in it and
+// deletes the page texts that no document refers to. Page texts are not deleted if any document
+// can't be read.
+// The reference counts are only saved by Flush() so they can be out of date after a crash.
+func (lState *Store) recountTextRefs() error {
+	if lState.isMem() {
+		return nil
+	}
+	textRefs := map[string]int{}
+	complete := true
+	for i := range lState.fileList {
+		lDoc, err := lState.OpenPositionsDoc(uint64(i))
+		if err != nil {
+			common.Log.Error("recountTextRefs: Could not open %q. err=%v",
+				lState.fileList[i].InPath, err)
+			complete = false
+			continue
+		}
+		for _, span := range lDoc.spans {
+			if span.TextHash != "" {
+				textRefs[span.TextHash]++
+			}
+		}
+		lDoc.Close()
+	}
+	lState.textRefs = textRefs
+	if !complete {
+		return nil
+	}
+
+	numOrphans := 0
+	err := filepath.Walk(lState.textsDir(), func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+		hash := strings.TrimSuffix(filepath.Base(path), ".txt")
+		if textRefs[hash] > 0 {
+			return nil
+		}
+		numOrphans++
+		return os.Remove(path)
+	})
+	common.Log.Info("recountTextRefs: %d texts. %d orphans removed.", len(textRefs), numOrphans)
+	return err
+}
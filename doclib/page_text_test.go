@@ -0,0 +1,119 @@
+package doclib
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/peterwilliams97/pdf-search/serial"
+)
+
+func TestCanonicalPageText(t *testing.T) {
+	locs := []serial.TextLocation{{Start: 0, End: 1}, {Start: 4, End: 5}, {Start: 5, End: 6}}
+	text, locs := canonicalPageText("ab\xffc \n", locs)
+	if text != "ab?c" {
+		t.Fatalf("text=%q", text)
+	}
+	if len(locs) != 1 {
+		t.Fatalf("locs=%d", len(locs))
+	}
+}
+
+func TestPageTextSpan(t *testing.T) {
+	text := "naïve text"
+	if s, err := PageTextSpan(text, 0, 6); err != nil || s != "naïve" {
+		t.Fatalf("s=%q err=%v", s, err)
+	}
+	if _, err := PageTextSpan(text, 0, 3); err == nil {
+		t.Fatalf("expected error for span inside a character")
+	}
+	if _, err := PageTextSpan(text, 6, uint32(len(text))+1); err == nil {
+		t.Fatalf("expected error for span past end of text")
+	}
+}
+
+func TestJoinHyphenated(t *testing.T) {
+	for _, test := range []struct {
+		text, expected string
+	}{
+		{"an informa-\ntion store", "an information\nstore"},
+		{"an informa- \n  tion, store", "an information,\nstore"},
+		{"an informa-\ntion\nstore", "an information\n\nstore"},
+		{"in-\nfor-\nmation x", "information\n\nx"},
+		{"Jean-\nPaul and 1-\n2", "Jean-\nPaul and 1-\n2"},
+		{"soft\u00ad\nhyphen", "softhyphen\n"},
+		{"no breaks", "no breaks"},
+	} {
+		text, _ := joinHyphenated(test.text, nil)
+		if text != test.expected {
+			t.Errorf("%q: got %q expected %q", test.text, text, test.expected)
+		}
+	}
+}
+
+func TestJoinHyphenatedLocations(t *testing.T) {
+	text := "an informa-\ntion store"
+	var locs []serial.TextLocation
+	for i, c := range text {
+		if c != ' ' && c != '\n' {
+			locs = append(locs, serial.TextLocation{Start: uint32(i), Llx: float32(i)})
+		}
+	}
+	joined, joinedLocs := joinHyphenated(text, locs)
+	if len(joinedLocs) != len(locs)-1 {
+		t.Fatalf("locations=%d expected=%d", len(joinedLocs), len(locs)-1)
+	}
+	// Each character keeps the box it had before it moved. The hyphen's location is dropped.
+	for _, loc := range joinedLocs {
+		c := joined[loc.Start]
+		if orig := text[int(loc.Llx)]; c != orig {
+			t.Errorf("offset %d: %q has the location of %q", loc.Start, c, orig)
+		}
+	}
+}
+
+// TestRepeatPageNums checks that the pages with the same text as a page are found in memory and
+// persistent stores, and that the texts of the other pages aren't read in persistent stores.
+func TestRepeatPageNums(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pdf-search-repeats")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for _, root := range []string{"", dir} {
+		lState, err := OpenPositionsState(root, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		lDoc, err := lState.CreatePositionsDoc(FileDesc{InPath: "doc.pdf", Hash: "a0123456789"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i, text := range []string{"Cover page", "Body text", "Cover page"} {
+			pageNum := uint32(i + 1)
+			dpl := serial.DocPageLocations{Page: pageNum}
+			if _, err := lDoc.AddDocPage(pageNum, dpl, text); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if root != "" {
+			if err := lDoc.Close(); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.Remove(lState.textPath(textHash("Body text"))); err != nil {
+				t.Fatal(err)
+			}
+			if lDoc, err = lState.OpenPositionsDoc(0); err != nil {
+				t.Fatal(err)
+			}
+		}
+		pageNums, err := repeatPageNums(lDoc, 0)
+		if err != nil {
+			t.Fatalf("root=%q err=%v", root, err)
+		}
+		if expected := []uint32{1, 3}; !reflect.DeepEqual(pageNums, expected) {
+			t.Errorf("root=%q pageNums=%d expected=%d", root, pageNums, expected)
+		}
+	}
+}
@@ -0,0 +1,248 @@
+package doclib
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Searcher searches a store. It is implemented by PdfIndex for local stores
+// and by RemoteIndex for stores served by NewPdfServer.
+type Searcher interface {
+	Search(term string, opts SearchOptions) (PdfMatchSet, error)
+	ListDocs(filter DocFilter) ([]DocInfo, int, error)
+	ReadPage(docIdx uint64, pageIdx uint32) (PageData, error)
+	StoreInfo() (StoreInfo, error)
+}
+
+var (
+	_ Searcher = (*PdfIndex)(nil)
+	_ Searcher = (*RemoteIndex)(nil)
+)
+
+// RemoteIndex is a client for a store served by NewPdfServer. It can be used by clients that
+// can't or don't want to copy the store, e.g. laptops searching a big index on a server.
+type RemoteIndex struct {
+	baseURL string
+	client  *http.Client
+}
+
+// NewRemoteIndex returns a RemoteIndex for the server at `baseURL`, e.g. "http://host:8080".
+func NewRemoteIndex(baseURL string) *RemoteIndex {
+	return &RemoteIndex{baseURL: strings.TrimRight(baseURL, "/"), client: http.DefaultClient}
+}
+
+// Search returns the PdfMatchSet for query `term` over the remote store. opts.Boosts and
+// opts.Synonyms are ignored. The server uses the store's boosts.json and synonyms.txt.
+// The markup fields of the returned PdfMatches are set so the results can be marked up locally if
+// the PDF files are available. See FetchPdf.
+func (c *RemoteIndex) Search(term string, opts SearchOptions) (PdfMatchSet, error) {
+	q := url.Values{}
+	q.Set("q", term)
+	q.Set("n", strconv.Itoa(opts.MaxResults))
+	q.Set("from", strconv.Itoa(opts.From))
+	if opts.Cursor != "" {
+		q.Set("cursor", opts.Cursor)
+	}
+	if opts.AllTerms {
+		q.Set("all", "1")
+	}
+	if opts.Within > 0 {
+		q.Set("within", strconv.Itoa(opts.Within))
+	}
+	if opts.Lang != "" {
+		q.Set("lang", opts.Lang)
+	}
+	if opts.Fuzziness > 0 {
+		q.Set("fuzzy", strconv.Itoa(opts.Fuzziness))
+	}
+	for _, tag := range opts.Tags {
+		q.Add("tag", tag)
+	}
+	if opts.TagFacet {
+		q.Set("tagfacet", "1")
+	}
+	if !opts.After.IsZero() {
+		q.Set("after", opts.After.Format(time.RFC3339))
+	}
+	if !opts.Before.IsZero() {
+		q.Set("before", opts.Before.Format(time.RFC3339))
+	}
+	if opts.DateField != "" {
+		q.Set("datefield", opts.DateField)
+	}
+	if opts.Sort != "" {
+		q.Set("sort", opts.Sort)
+	}
+	if opts.CaseSensitive {
+		q.Set("case", "1")
+	}
+	if opts.DiacriticSensitive {
+		q.Set("diacritics", "1")
+	}
+	if opts.Quantity != nil {
+		q.Set("qty", opts.Quantity.String())
+	}
+	if opts.CollapseDuplicates {
+		q.Set("collapse", "1")
+	}
+	if opts.CollapseIdentical {
+		q.Set("identical", "1")
+	}
+	var results pdfMatchSetWire
+	err := c.get("/search", q, &results)
+	return PdfMatchSet(results), err
+}
+
+// ListDocs returns the documents in the remote store that match `filter`.
+func (c *RemoteIndex) ListDocs(filter DocFilter) ([]DocInfo, int, error) {
+	q := url.Values{}
+	q.Set("path", filter.PathPattern)
+	q.Set("status", string(filter.Status))
+	q.Set("extractor", filter.Extractor)
+	q.Set("min", strconv.Itoa(filter.MinPages))
+	q.Set("offset", strconv.Itoa(filter.Offset))
+	q.Set("limit", strconv.Itoa(filter.Limit))
+	var docs docList
+	err := c.get("/docs", q, &docs)
+	return docs.Docs, docs.Total, err
+}
+
+// ReadPage returns the text and text locations of page `pageIdx` of document `docIdx` in the
+// remote store.
+func (c *RemoteIndex) ReadPage(docIdx uint64, pageIdx uint32) (PageData, error) {
+	q := url.Values{}
+	q.Set("doc", strconv.FormatUint(docIdx, 10))
+	q.Set("page", strconv.FormatUint(uint64(pageIdx), 10))
+	var page PageData
+	err := c.get("/page", q, &page)
+	return page, err
+}
+
+// Suggest returns up to `n` completions of query `term` from the remote store. See Suggest.
+func (c *RemoteIndex) Suggest(term string, n int) ([]Suggestion, error) {
+	q := url.Values{}
+	q.Set("q", term)
+	q.Set("n", strconv.Itoa(n))
+	var suggestions []Suggestion
+	err := c.get("/suggest", q, &suggestions)
+	return suggestions, err
+}
+
+// StoreInfo returns the StoreInfo of the remote store.
+func (c *RemoteIndex) StoreInfo() (StoreInfo, error) {
+	var info StoreInfo
+	err := c.get("/info", url.Values{}, &info)
+	return info, err
+}
+
+// Stats returns the StoreStats of the remote store.
+func (c *RemoteIndex) Stats() (StoreStats, error) {
+	var stats StoreStats
+	err := c.get("/stats", url.Values{}, &stats)
+	return stats, err
+}
+
+// FetchPdf writes the PDF file of document `docIdx` in the remote store to `w`. The document
+// index of a PdfMatch is PdfMatch.Doc.
+func (c *RemoteIndex) FetchPdf(docIdx uint64, w io.Writer) error {
+	q := url.Values{}
+	q.Set("doc", strconv.FormatUint(docIdx, 10))
+	resp, err := c.do("/pdf", q)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, err = io.Copy(w, resp.Body)
+	return err
+}
+
+// FetchThumbnail writes a PNG image of page `pageIdx` of document `docIdx` in the remote store,
+// rendered at `dpi` with the rectangles `marks` highlighted, to `w`. See
+// PdfIndex.RenderPageThumbnail.
+func (c *RemoteIndex) FetchThumbnail(docIdx uint64, pageIdx uint32, dpi int, marks []ViewRect,
+	w io.Writer) error {
+
+	q := url.Values{}
+	q.Set("doc", strconv.FormatUint(docIdx, 10))
+	q.Set("page", strconv.FormatUint(uint64(pageIdx), 10))
+	q.Set("dpi", strconv.Itoa(dpi))
+	if len(marks) > 0 {
+		q.Set("marks", formatViewRects(marks))
+	}
+	resp, err := c.do("/thumb", q)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, err = io.Copy(w, resp.Body)
+	return err
+}
+
+// StartIndexing asks the index worker at the server to index the files in `pathList`. It returns
+// the worker's status. See IndexWorker.
+func (c *RemoteIndex) StartIndexing(pathList []string) (IndexWorkerStatus, error) {
+	var status IndexWorkerStatus
+	b, err := json.Marshal(indexRequest{Paths: pathList})
+	if err != nil {
+		return status, err
+	}
+	u := c.baseURL + "/index"
+	resp, err := c.client.Post(u, "application/json", bytes.NewReader(b))
+	if err != nil {
+		return status, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := ioutil.ReadAll(resp.Body)
+		return status, fmt.Errorf("Request %q failed. status=%q err=%s", u, resp.Status,
+			strings.TrimSpace(string(msg)))
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&status); err != nil {
+		return status, fmt.Errorf("Could not decode response from %q. err=%v", u, err)
+	}
+	return status, nil
+}
+
+// IndexStatus returns the status of the index worker at the server.
+func (c *RemoteIndex) IndexStatus() (IndexWorkerStatus, error) {
+	var status IndexWorkerStatus
+	err := c.get("/index", url.Values{}, &status)
+	return status, err
+}
+
+// get makes the request `path`?`q` to the server and decodes the JSON response into `v`.
+func (c *RemoteIndex) get(path string, q url.Values, v interface{}) error {
+	resp, err := c.do(path, q)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return fmt.Errorf("Could not decode response from %q. err=%v", c.baseURL+path, err)
+	}
+	return nil
+}
+
+// do makes the GET request `path`?`q` to the server. The caller must close the response body.
+func (c *RemoteIndex) do(path string, q url.Values) (*http.Response, error) {
+	u := c.baseURL + path + "?" + q.Encode()
+	resp, err := c.client.Get(u)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		msg, _ := ioutil.ReadAll(resp.Body)
+		return nil, fmt.Errorf("Request %q failed. status=%q err=%s", u, resp.Status,
+			strings.TrimSpace(string(msg)))
+	}
+	return resp, nil
+}
@@ -0,0 +1,129 @@
+package doclib
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/peterwilliams97/pdf-search/serial"
+	"github.com/unidoc/unidoc/common"
+	"github.com/unidoc/unidoc/pdf/core"
+	"github.com/unidoc/unidoc/pdf/creator"
+	pdf "github.com/unidoc/unidoc/pdf/model"
+)
+
+// HighlightPdf writes a copy of PDF file `inPath` to `outPath` with a PDF Highlight annotation
+// over every matched term in the PdfMatches in `matches` that are in `inPath`.
+// Unlike ExtractList.SaveOutputPdf, which draws rectangles on extracted pages, the output has all
+// the pages of the original and the highlights can be selected, hidden or deleted in PDF viewers.
+// The highlight colors and opacity come from `style`. The popup of each highlight contains its
+// matched term.
+func HighlightPdf(inPath, outPath string, matches []PdfMatch, style MarkupStyle) error {
+	if len(style.Colors) == 0 {
+		style.Colors = DefaultMarkupStyle().Colors
+	}
+	pdfReader, err := PdfOpenFile(inPath, false)
+	if err != nil {
+		return fmt.Errorf("Could not open %q. err=%v", inPath, err)
+	}
+	numPages, err := pdfReader.GetNumPages()
+	if err != nil {
+		return err
+	}
+
+	// pageMarks is {page number: highlights on page}
+	pageMarks := map[int][]markupRect{}
+	termColors := map[string]int{}
+	for _, m := range matches {
+		if m.InPath != inPath {
+			continue
+		}
+		for i, pos := range m.Positions {
+			if pos == (serial.TextLocation{}) {
+				continue // No bounding box was found for this span.
+			}
+			term := ""
+			if i < len(m.Spans) {
+				term = m.Spans[i].Term
+			}
+			r := markupRect{
+				rect: pdf.PdfRectangle{Llx: float64(pos.Llx), Lly: float64(pos.Lly),
+					Urx: float64(pos.Urx), Ury: float64(pos.Ury)},
+				term:  term,
+				color: style.termColor(termColors, term),
+			}
+			pageNum := int(m.PageNum)
+			pageMarks[pageNum] = append(pageMarks[pageNum], r)
+		}
+	}
+
+	pdfWriter := pdf.NewPdfWriter()
+	for pageNum := 1; pageNum <= numPages; pageNum++ {
+		page, err := pdfReader.GetPage(pageNum)
+		if err != nil {
+			return err
+		}
+		for _, r := range pageMarks[pageNum] {
+			page.AddAnnotation(style.highlight(r))
+		}
+		if err := pdfWriter.AddPage(page); err != nil {
+			return err
+		}
+	}
+	common.Log.Info("HighlightPdf: %q -> %q %d pages highlighted", inPath, outPath,
+		len(pageMarks))
+
+	f, err := os.Create(outPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return pdfWriter.Write(f)
+}
+
+// HighlightPdfMatchSet writes highlighted copies of the PDF files in `results` to directory
+// `outDir`. See HighlightPdf. Image files are skipped. It returns {PDF path: highlighted copy
+// path}.
+func HighlightPdfMatchSet(results PdfMatchSet, outDir string, style MarkupStyle) (
+	map[string]string, error) {
+
+	if err := os.MkdirAll(outDir, 0777); err != nil {
+		return nil, err
+	}
+	outPaths := map[string]string{}
+	for i, inPath := range results.Files() {
+		if IsImageFile(inPath) {
+			continue // Images have no PDF pages to annotate.
+		}
+		base := filepath.Base(inPath)
+		outPath := filepath.Join(outDir, fmt.Sprintf("%03d.%s", i+1, base))
+		if err := HighlightPdf(inPath, outPath, results.Matches, style); err != nil {
+			return outPaths, err
+		}
+		outPaths[inPath] = outPath
+	}
+	return outPaths, nil
+}
+
+// highlight returns a Highlight annotation for `m` in style `s`. The quadrilateral of the
+// annotation is the bounding box of the matched text.
+func (s MarkupStyle) highlight(m markupRect) *pdf.PdfAnnotation {
+	r := m.rect
+	annot := pdf.NewPdfAnnotationHighlight()
+	annot.Rect = core.MakeArrayFromFloats([]float64{r.Llx, r.Lly, r.Urx, r.Ury})
+	// The points are in the order upper-left, upper-right, lower-left, lower-right, which is the
+	// order that viewers expect, rather than the counterclockwise order in the PDF spec.
+	annot.QuadPoints = core.MakeArrayFromFloats([]float64{
+		r.Llx, r.Ury, r.Urx, r.Ury, r.Llx, r.Lly, r.Urx, r.Lly,
+	})
+	red, green, blue := creator.ColorRGBFromHex(m.color).ToRGB()
+	annot.C = core.MakeArrayFromFloats([]float64{red, green, blue})
+	if s.FillOpacity > 0 {
+		annot.CA = core.MakeFloat(s.FillOpacity)
+	}
+	if m.term != "" {
+		annot.Contents = core.MakeString(m.term)
+		annot.T = core.MakeString("pdf-search")
+	}
+	return annot.PdfAnnotation
+}
@@ -0,0 +1,374 @@
+package doclib
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/blevesearch/bleve"
+	"github.com/peterwilliams97/pdf-search/serial"
+	"github.com/unidoc/unidoc/common"
+)
+
+// ErrClosed is returned when a closed PdfIndex is used.
+var ErrClosed = errors.New("index is closed")
+
+// generationFileName is the name of the store generation marker file in a store directory.
+const generationFileName = "generation"
+
+// PdfIndex is a long-lived handle for searching a persistent store.
+// It checks the store's generation before each search and transparently re-opens the store if
+// it has been changed by another process, e.g. by re-indexing, compaction or a restore from
+// backup. It is safe for concurrent use.
+type PdfIndex struct {
+	persistDir string
+	mu         sync.RWMutex
+	lState     *Store
+	index      bleve.Index
+	boosts     BoostTable
+	gen        storeGeneration
+	openDocs   int // Number of documents the Store keeps open. 0 for the store config.
+	// cold is the cold storage of frozen documents. It is nil unless SetColdStorage was called.
+	cold Storage
+}
+
+// storeGeneration identifies a version of the files in a store.
+type storeGeneration struct {
+	marker   string      // Contents of the generation marker file.
+	bleveDir os.FileInfo // The bleve index directory. Changes if the directory is replaced.
+	fileList time.Time   // Modification time of file_list.json.
+}
+
+// OpenPdfIndex opens the persistent store in `persistDir` for searching.
+func OpenPdfIndex(persistDir string) (*PdfIndex, error) {
+	x := &PdfIndex{persistDir: persistDir}
+	if err := x.open(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// Search returns the PdfMatchSet for query `term` over the PDFs in `x`. See SearchIndexOpts.
+// If opts.Boosts is nil, the store's boosts.json is used.
+func (x *PdfIndex) Search(term string, opts SearchOptions) (PdfMatchSet, error) {
+	return x.SearchContext(context.Background(), term, opts)
+}
+
+// SearchContext is Search with a context. See SearchIndexContext.
+func (x *PdfIndex) SearchContext(ctx context.Context, term string, opts SearchOptions) (
+	PdfMatchSet, error) {
+
+	if err := x.refresh(); err != nil {
+		return PdfMatchSet{}, err
+	}
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+	if x.index == nil {
+		return PdfMatchSet{}, ErrClosed
+	}
+	if opts.Boosts == nil {
+		opts.Boosts = x.boosts
+	}
+	return SearchIndexContext(ctx, x.lState, x.index, term, opts)
+}
+
+// PageData is the text and text locations of a page in a store.
+type PageData struct {
+	DocIdx    uint64
+	PageIdx   uint32
+	InPath    string
+	PageNum   uint32 // PDF page number (1-offset).
+	Text      string
+	Locations []serial.TextLocation
+}
+
+// ReadPage returns the text and text locations of page `pageIdx` of document `docIdx` in `x`.
+func (x *PdfIndex) ReadPage(docIdx uint64, pageIdx uint32) (PageData, error) {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+	if x.index == nil {
+		return PageData{}, ErrClosed
+	}
+	lDoc, err := x.lState.OpenPositionsDoc(docIdx)
+	if err != nil {
+		return PageData{}, err
+	}
+	defer lDoc.Close()
+	if pageIdx >= uint32(lDoc.Len()) {
+		return PageData{}, ErrRange
+	}
+	pageNum, dpl, err := lDoc.ReadPagePositions(pageIdx)
+	if err != nil {
+		return PageData{}, err
+	}
+	text, err := lDoc.ReadPageText(pageIdx)
+	if err != nil {
+		return PageData{}, err
+	}
+	return PageData{
+		DocIdx:    docIdx,
+		PageIdx:   pageIdx,
+		InPath:    lDoc.inPath,
+		PageNum:   pageNum,
+		Text:      text,
+		Locations: dpl.Locations,
+	}, nil
+}
+
+// ListDocs returns the documents in `x` that match `filter`. See Store.ListDocs.
+func (x *PdfIndex) ListDocs(filter DocFilter) ([]DocInfo, int, error) {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+	if x.index == nil {
+		return nil, 0, ErrClosed
+	}
+	return x.lState.ListDocs(filter)
+}
+
+// StoreInfo returns the number of documents and the features of the store in `x`. See
+// StoreFeatures.
+func (x *PdfIndex) StoreInfo() (StoreInfo, error) {
+	if err := x.refresh(); err != nil {
+		return StoreInfo{}, err
+	}
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+	if x.index == nil {
+		return StoreInfo{}, ErrClosed
+	}
+	return x.lState.StoreInfo()
+}
+
+// Stats returns the corpus level statistics of the store in `x`. See Store.Stats.
+func (x *PdfIndex) Stats() (StoreStats, error) {
+	if err := x.refresh(); err != nil {
+		return StoreStats{}, err
+	}
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+	if x.index == nil {
+		return StoreStats{}, ErrClosed
+	}
+	return x.lState.Stats()
+}
+
+// NearDuplicatePages returns the groups of near-duplicate pages in `x`. See
+// Store.NearDuplicatePages.
+func (x *PdfIndex) NearDuplicatePages(maxDistance int) ([][]PageRef, error) {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+	if x.index == nil {
+		return nil, ErrClosed
+	}
+	return x.lState.NearDuplicatePages(maxDistance)
+}
+
+// NearDuplicateDocs returns the groups of near-duplicate documents in `x`. See
+// Store.NearDuplicateDocs.
+func (x *PdfIndex) NearDuplicateDocs(maxDistance int, minFraction float64) ([][]string, error) {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+	if x.index == nil {
+		return nil, ErrClosed
+	}
+	return x.lState.NearDuplicateDocs(maxDistance, minFraction), nil
+}
+
+// DocPath returns the path of the PDF file of document `docIdx` in `x`.
+func (x *PdfIndex) DocPath(docIdx uint64) (string, error) {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+	inPath, ok := x.lState.indexToPath(docIdx)
+	if !ok {
+		return "", ErrNoDoc
+	}
+	return inPath, nil
+}
+
+// Move moves the store of `x` to directory `newDir`. See MoveStore.
+// Searches wait until the store has been moved and re-opened.
+func (x *PdfIndex) Move(newDir string) error {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	if x.index == nil {
+		return ErrClosed
+	}
+	// Close the bleve index here rather than in MoveStore so that `x` can be re-opened if the
+	// move fails.
+	if err := x.close(); err != nil {
+		return err
+	}
+	lState, index, err := MoveStore(x.persistDir, newDir, x.lState, nil)
+	if err != nil {
+		if openErr := x.open(); openErr != nil {
+			common.Log.Error("PdfIndex: Could not re-open %q. err=%v", x.persistDir, openErr)
+		}
+		return err
+	}
+	x.persistDir = newDir
+	x.lState, x.index = lState, index
+	x.gen, err = readGeneration(newDir)
+	return err
+}
+
+// NumDocs returns the number of PDF files in `x`.
+func (x *PdfIndex) NumDocs() int {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+	return x.lState.Len()
+}
+
+// Close closes `x`.
+func (x *PdfIndex) Close() error {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	return x.close()
+}
+
+// refresh re-opens `x` if its store has changed since it was opened.
+func (x *PdfIndex) refresh() error {
+	gen, err := readGeneration(x.persistDir)
+	if err != nil {
+		return err
+	}
+	x.mu.RLock()
+	same := gen.same(x.gen)
+	x.mu.RUnlock()
+	if same {
+		return nil
+	}
+
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	if gen.same(x.gen) {
+		return nil // Another goroutine re-opened `x`.
+	}
+	common.Log.Info("PdfIndex: %q has changed. Re-opening.", x.persistDir)
+	if err := x.close(); err != nil {
+		common.Log.Error("PdfIndex: Could not close %q. err=%v", x.persistDir, err)
+	}
+	return x.open()
+}
+
+// open opens the bleve index, Store and boost table in x.persistDir.
+// The caller must hold x.mu for writing or have sole access to `x`.
+func (x *PdfIndex) open() error {
+	// Read the generation first so that a change while we are opening causes another re-open.
+	gen, err := readGeneration(x.persistDir)
+	if err != nil {
+		return err
+	}
+	indexPath := filepath.Join(x.persistDir, "bleve")
+	index, err := OpenStoreIndexReadOnly(x.persistDir)
+	if err != nil {
+		return fmt.Errorf("Could not open Bleve index %q. err=%v", indexPath, err)
+	}
+	lState, err := OpenPositionsState(x.persistDir, false)
+	if err != nil {
+		index.Close()
+		return fmt.Errorf("Could not open positions store %q. err=%v", x.persistDir, err)
+	}
+	boosts, err := loadStoreBoosts(x.persistDir)
+	if err != nil {
+		index.Close()
+		return err
+	}
+	if x.openDocs > 0 {
+		lState.SetOpenDocs(x.openDocs)
+	}
+	if x.cold != nil {
+		lState.SetColdStorage(x.cold)
+	}
+	x.index, x.lState, x.boosts, x.gen = index, lState, boosts, gen
+	return nil
+}
+
+// SetOpenDocs sets the maximum number of documents whose positions `x` keeps open between searches
+// to `n`. See Store.SetOpenDocs().
+func (x *PdfIndex) SetOpenDocs(n int) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	x.openDocs = n
+	if x.lState != nil {
+		x.lState.SetOpenDocs(n)
+	}
+}
+
+// SetColdStorage sets the Storage that the positions data of frozen documents in `x` is fetched
+// from. It isn't needed if the store's cold storage was set with Store.SetColdDir.
+// See Store.FreezeDocs.
+func (x *PdfIndex) SetColdStorage(cold Storage) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	x.cold = cold
+	if x.lState != nil {
+		x.lState.SetColdStorage(cold)
+	}
+}
+
+// close closes the bleve index in `x`. The caller must hold x.mu for writing.
+func (x *PdfIndex) close() error {
+	if x.index == nil {
+		return nil
+	}
+	x.lState.Close()
+	err := x.index.Close()
+	x.index = nil
+	return err
+}
+
+// BumpGeneration marks the store in `persistDir` as changed so that PdfIndexes that have it open
+// will re-open it. Programs that modify a store, e.g. by restoring it from a backup, should call
+// it or write a new value to the store's generation file.
+func BumpGeneration(persistDir string) error {
+	marker := strconv.FormatInt(time.Now().UnixNano(), 10)
+	return ioutil.WriteFile(filepath.Join(persistDir, generationFileName), []byte(marker), 0666)
+}
+
+// bumpGeneration marks the store of `lState` as changed. See BumpGeneration.
+func (lState *Store) bumpGeneration() {
+	if lState.isMem() {
+		return
+	}
+	if err := BumpGeneration(lState.root); err != nil {
+		common.Log.Error("bumpGeneration: Could not update generation of %q. err=%v",
+			lState.root, err)
+	}
+}
+
+// readGeneration returns the current generation of the store in `persistDir`.
+func readGeneration(persistDir string) (storeGeneration, error) {
+	var gen storeGeneration
+	b, err := ioutil.ReadFile(filepath.Join(persistDir, generationFileName))
+	if err != nil && !os.IsNotExist(err) {
+		return gen, err
+	}
+	gen.marker = strings.TrimSpace(string(b))
+	if gen.bleveDir, err = os.Stat(filepath.Join(persistDir, "bleve")); err != nil {
+		return gen, err
+	}
+	fi, err := os.Stat(filepath.Join(persistDir, "file_list.json"))
+	if err != nil && !os.IsNotExist(err) {
+		return gen, err
+	}
+	if err == nil {
+		gen.fileList = fi.ModTime()
+	}
+	return gen, nil
+}
+
+// same returns true if `g` and `h` are the same generation of a store.
+func (g storeGeneration) same(h storeGeneration) bool {
+	if g.bleveDir == nil || h.bleveDir == nil {
+		return false
+	}
+	return g.marker == h.marker && os.SameFile(g.bleveDir, h.bleveDir) &&
+		g.fileList.Equal(h.fileList)
+}
@@ -0,0 +1,362 @@
+package doclib
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/unidoc/unidoc/common"
+)
+
+/*
+   The pdf-search HTTP protocol lets clients search a store on a server without copying it.
+   All responses are JSON except /pdf.
+
+   GET  /search?q=<query>&n=<max results>&from=<offset>&cursor=<cursor>&all=1&within=<chars>
+               &lang=<language>&fuzzy=<edits>&tag=<key=value>&tagfacet=1 -> PdfMatchSet
+               &after=<date>&before=<date>&datefield=created|modified&sort=<order>
+               &case=1&diacritics=1&qty=<range>&collapse=1&identical=1
+               tag may be repeated. Matches must have all the tags. Dates are as in ParseDate.
+               Sort orders are as in SearchOptions.Sort.
+               case and diacritics make case and diacritics significant.
+               qty is a quantity range as in ParseQuantityRange, e.g. 2..5 mm.
+               collapse collapses matches on near-duplicate pages. See PdfMatch.NearDuplicates.
+               identical collapses matches on identical pages. See PdfMatch.Copies.
+               from+n must be at most 10000. See SearchOptions.MaxResults.
+   GET  /docs?path=<pattern>&status=<status>&extractor=<name>&min=<pages>&offset=<n>&limit=<n>
+                                        -> docList
+   GET  /page?doc=<docIdx>&page=<pageIdx> -> PageData
+   GET  /similar?doc=<docIdx>&page=<pageIdx>&n=<max results> -> PdfMatchSet. See SimilarPages.
+   GET  /pdf?doc=<docIdx>                -> The PDF file.
+   GET  /thumb?doc=<docIdx>&page=<pageIdx>&dpi=<dpi>&marks=<x,y,w,h,...>
+                                        -> PNG image of the page. See RenderPageThumbnail.
+   GET  /suggest?q=<partial query>&n=<max suggestions> -> []Suggestion. See Suggest.
+   GET  /info                            -> StoreInfo
+   GET  /stats                           -> StoreStats
+   POST /admin/move?to=<dir>             -> Moves the store. Only served if admin is enabled.
+
+   Index workers serve these as well. See IndexWorker.
+   POST /index  {"Paths": [...]}         -> IndexWorkerStatus. Starts indexing the files.
+   GET  /index                           -> IndexWorkerStatus
+
+   Indexing runs stream their progress to dashboards on a separate server. See ProgressHandler.
+   GET  /progress (WebSocket)           -> A JSON progress message after each file.
+
+   Errors are returned as an HTTP error status with the error message as the body.
+*/
+
+// docList is the response to a /docs request.
+type docList struct {
+	Docs  []DocInfo
+	Total int // Number of documents that matched the filter.
+}
+
+// pdfMatchSetWire is the /search response. It is a PdfMatchSet without the export schema of
+// PdfMatchSet.MarshalJSON so that clients get all the fields.
+type pdfMatchSetWire PdfMatchSet
+
+// pdfServer serves a PdfIndex over HTTP.
+type pdfServer struct {
+	x *PdfIndex
+}
+
+// NewPdfServer returns an http.Handler that serves searches of `x`. If `admin` is true then the
+// admin endpoints, which modify the store, are also served.
+func NewPdfServer(x *PdfIndex, admin bool) http.Handler {
+	s := pdfServer{x: x}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/search", s.search)
+	mux.HandleFunc("/docs", s.docs)
+	mux.HandleFunc("/page", s.page)
+	mux.HandleFunc("/similar", s.similar)
+	mux.HandleFunc("/pdf", s.pdf)
+	mux.HandleFunc("/thumb", s.thumb)
+	mux.HandleFunc("/suggest", s.suggest)
+	mux.HandleFunc("/info", s.info)
+	mux.HandleFunc("/stats", s.stats)
+	if admin {
+		mux.HandleFunc("/admin/move", s.move)
+	}
+	return mux
+}
+
+func (s pdfServer) search(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	term := q.Get("q")
+	if term == "" {
+		http.Error(w, "no query", http.StatusBadRequest)
+		return
+	}
+	after, err := ParseDate(q.Get("after"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	before, err := ParseDate(q.Get("before"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	maxResults, err1 := queryCount(q.Get("n"), 10, 1, maxResultWindow)
+	from, err2 := queryCount(q.Get("from"), 0, 0, maxResultWindow)
+	if err1 != nil || err2 != nil {
+		http.Error(w, "bad n or from", http.StatusBadRequest)
+		return
+	}
+	var quantity *QuantityRange
+	if qty := q.Get("qty"); qty != "" {
+		if quantity, err = ParseQuantityRange(qty); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	opts := SearchOptions{
+		MaxResults: maxResults,
+		From:       from,
+		Cursor:     q.Get("cursor"),
+		AllTerms:   q.Get("all") != "",
+		Within:     queryInt(q.Get("within"), 0),
+		Lang:       q.Get("lang"),
+		Fuzziness:  queryInt(q.Get("fuzzy"), 0),
+		Tags:       q["tag"],
+		TagFacet:   q.Get("tagfacet") != "",
+		After:      after,
+		Before:     before,
+		DateField:  q.Get("datefield"),
+		Sort:       q.Get("sort"),
+
+		CaseSensitive:      q.Get("case") != "",
+		DiacriticSensitive: q.Get("diacritics") != "",
+		Quantity:           quantity,
+		CollapseDuplicates: q.Get("collapse") != "",
+		CollapseIdentical:  q.Get("identical") != "",
+	}
+	// The search is abandoned if the client goes away.
+	results, err := s.x.SearchContext(r.Context(), term, opts)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, pdfMatchSetWire(results))
+}
+
+func (s pdfServer) docs(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	filter := DocFilter{
+		PathPattern: q.Get("path"),
+		Status:      DocStatus(q.Get("status")),
+		Extractor:   q.Get("extractor"),
+		MinPages:    queryInt(q.Get("min"), 0),
+		Offset:      queryInt(q.Get("offset"), 0),
+		Limit:       queryInt(q.Get("limit"), 100),
+	}
+	docs, total, err := s.x.ListDocs(filter)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, docList{Docs: docs, Total: total})
+}
+
+func (s pdfServer) page(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	docIdx, err1 := strconv.ParseUint(q.Get("doc"), 10, 64)
+	pageIdx, err2 := strconv.ParseUint(q.Get("page"), 10, 32)
+	if err1 != nil || err2 != nil {
+		http.Error(w, "bad doc or page", http.StatusBadRequest)
+		return
+	}
+	page, err := s.x.ReadPage(docIdx, uint32(pageIdx))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, page)
+}
+
+func (s pdfServer) similar(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	docIdx, err1 := strconv.ParseUint(q.Get("doc"), 10, 64)
+	pageIdx, err2 := strconv.ParseUint(q.Get("page"), 10, 32)
+	if err1 != nil || err2 != nil {
+		http.Error(w, "bad doc or page", http.StatusBadRequest)
+		return
+	}
+	results, err := s.x.SimilarPages(docIdx, uint32(pageIdx), queryInt(q.Get("n"), 10))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, pdfMatchSetWire(results))
+}
+
+func (s pdfServer) pdf(w http.ResponseWriter, r *http.Request) {
+	docIdx, err := strconv.ParseUint(r.URL.Query().Get("doc"), 10, 64)
+	if err != nil {
+		http.Error(w, "bad doc", http.StatusBadRequest)
+		return
+	}
+	inPath, err := s.x.DocPath(docIdx)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/pdf")
+	http.ServeFile(w, r, inPath)
+}
+
+func (s pdfServer) thumb(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	docIdx, err1 := strconv.ParseUint(q.Get("doc"), 10, 64)
+	pageIdx, err2 := strconv.ParseUint(q.Get("page"), 10, 32)
+	marks, err3 := parseViewRects(q.Get("marks"))
+	if err1 != nil || err2 != nil || err3 != nil {
+		http.Error(w, "bad doc, page or marks", http.StatusBadRequest)
+		return
+	}
+	data, err := s.x.RenderPageThumbnail(docIdx, uint32(pageIdx), queryInt(q.Get("dpi"), 0), marks)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "image/png")
+	w.Write(data)
+}
+
+func (s pdfServer) suggest(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	suggestions, err := s.x.Suggest(q.Get("q"), queryInt(q.Get("n"), DefaultSuggestions))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if suggestions == nil {
+		suggestions = []Suggestion{}
+	}
+	writeJSON(w, suggestions)
+}
+
+func (s pdfServer) info(w http.ResponseWriter, r *http.Request) {
+	info, err := s.x.StoreInfo()
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, info)
+}
+
+func (s pdfServer) stats(w http.ResponseWriter, r *http.Request) {
+	stats, err := s.x.Stats()
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, stats)
+}
+
+func (s pdfServer) move(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "POST required", http.StatusMethodNotAllowed)
+		return
+	}
+	newDir := r.URL.Query().Get("to")
+	if newDir == "" {
+		http.Error(w, "no destination", http.StatusBadRequest)
+		return
+	}
+	if err := s.x.Move(newDir); err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, map[string]string{"persistDir": newDir})
+}
+
+// queryInt returns the integer value of query parameter value `val` or `def` if it is empty or
+// not an integer.
+func queryInt(val string, def int) int {
+	n, err := strconv.Atoi(val)
+	if err != nil {
+		return def
+	}
+	return n
+}
+
+// queryCount returns the integer value of query parameter value `val` or `def` if it is empty.
+// It returns an error if `val` is not an integer or is not in [`min`, `max`].
+func queryCount(val string, def, min, max int) (int, error) {
+	if val == "" {
+		return def, nil
+	}
+	n, err := strconv.Atoi(val)
+	if err != nil {
+		return 0, err
+	}
+	if n < min {
+		return 0, fmt.Errorf("%d is less than %d", n, min)
+	}
+	if n > max {
+		return 0, fmt.Errorf("%d is more than %d", n, max)
+	}
+	return n, nil
+}
+
+// parseViewRects parses `val`, a comma separated list of the X, Y, W and H of ViewRects. See
+// formatViewRects.
+func parseViewRects(val string) ([]ViewRect, error) {
+	if val == "" {
+		return nil, nil
+	}
+	parts := strings.Split(val, ",")
+	if len(parts)%4 != 0 {
+		return nil, fmt.Errorf("%d numbers is not a list of rectangles", len(parts))
+	}
+	nums := make([]float64, len(parts))
+	for i, part := range parts {
+		x, err := strconv.ParseFloat(part, 64)
+		if err != nil {
+			return nil, err
+		}
+		nums[i] = x
+	}
+	rects := make([]ViewRect, len(nums)/4)
+	for i := range rects {
+		rects[i] = ViewRect{X: nums[4*i], Y: nums[4*i+1], W: nums[4*i+2], H: nums[4*i+3]}
+	}
+	return rects, nil
+}
+
+// formatViewRects returns `rects` in the format that parseViewRects parses.
+func formatViewRects(rects []ViewRect) string {
+	parts := make([]string, 0, 4*len(rects))
+	for _, r := range rects {
+		for _, x := range []float64{r.X, r.Y, r.W, r.H} {
+			parts = append(parts, strconv.FormatFloat(x, 'f', -1, 64))
+		}
+	}
+	return strings.Join(parts, ",")
+}
+
+// writeJSON writes `v` to `w` as JSON.
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		common.Log.Error("writeJSON: Could not write response. err=%v", err)
+	}
+}
+
+// writeError writes `err` to `w` with an HTTP status that reflects it.
+func writeError(w http.ResponseWriter, err error) {
+	status := http.StatusInternalServerError
+	switch {
+	case err == ErrNoDoc || err == ErrRange || os.IsNotExist(err):
+		status = http.StatusNotFound
+	case err == ErrBadCursor || err == ErrResultWindow:
+		status = http.StatusBadRequest
+	case err == ErrClosed || err == ErrIndexing:
+		status = http.StatusServiceUnavailable
+	}
+	http.Error(w, err.Error(), status)
+}
@@ -0,0 +1,113 @@
+package doclib
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/peterwilliams97/pdf-search/serial"
+)
+
+// TestSearchBadRequest checks that the /search handler rejects bad result counts and offsets
+// before it searches.
+func TestSearchBadRequest(t *testing.T) {
+	server := NewPdfServer(nil, false)
+	for _, query := range []string{"n=0", "n=-1", "n=x", "from=-1", "from=x", "n=5&from=-3",
+		"n=10001", "from=10001", "from=4611686018427387904"} {
+		r := httptest.NewRequest(http.MethodGet, "/search?q=pdf&"+query, nil)
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, r)
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("query=%q code=%d expected=%d", query, w.Code, http.StatusBadRequest)
+		}
+	}
+}
+
+// TestRemoteSearchCollapse checks that RemoteIndex.Search passes CollapseDuplicates to the server.
+func TestRemoteSearchCollapse(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pdf-search-server")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	page := strings.Repeat("The quick brown fox jumps over the lazy dog near the river bank. ", 4) +
+		"Section 4.2 describes how the pump is serviced and how often its filters are changed."
+	writeTestStore(t, dir, [][]string{{page}, {strings.ToUpper(page)}})
+
+	x, err := OpenPdfIndex(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer x.Close()
+	server := httptest.NewServer(NewPdfServer(x, false))
+	defer server.Close()
+	remote := NewRemoteIndex(server.URL)
+
+	for _, collapse := range []bool{false, true} {
+		opts := SearchOptions{MaxResults: 10, CollapseDuplicates: collapse}
+		results, err := remote.Search("pump", opts)
+		if err != nil {
+			t.Fatalf("collapse=%t err=%v", collapse, err)
+		}
+		expected, dups := 2, 0
+		if collapse {
+			expected, dups = 1, 1
+		}
+		if len(results.Matches) != expected {
+			t.Fatalf("collapse=%t matches=%d expected=%d", collapse, len(results.Matches),
+				expected)
+		}
+		if n := results.Matches[0].NearDuplicates; n != dups {
+			t.Errorf("collapse=%t NearDuplicates=%d expected=%d", collapse, n, dups)
+		}
+	}
+}
+
+// writeTestStore writes a persistent store in `dir` with a document for each element of `docs`,
+// which is the texts of the document's pages. The pages are added to the store and its bleve
+// index without extracting them from PDFs.
+func writeTestStore(t *testing.T, dir string, docs [][]string) {
+	lState, err := OpenPositionsState(dir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	index, err := createBleveIndex(filepath.Join(dir, "bleve"), StopwordConfig{}, false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer index.Close()
+	for i, pages := range docs {
+		fd := FileDesc{InPath: fmt.Sprintf("doc%d.pdf", i), Hash: fmt.Sprintf("%x", i+0x100000)}
+		for _, text := range pages {
+			fd.Fingerprints = append(fd.Fingerprints, pageFingerprint(text))
+		}
+		lDoc, err := lState.CreatePositionsDoc(fd)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for j, text := range pages {
+			pageNum := uint32(j + 1)
+			dpl := serial.DocPageLocations{Page: pageNum,
+				Locations: wordLocations(text, charLocations(text))}
+			pageIdx, err := lDoc.AddDocPage(pageNum, dpl, text)
+			if err != nil {
+				t.Fatal(err)
+			}
+			id := pageID(lDoc.docIdx, pageIdx)
+			if err := index.Index(id, pageDocument(id, fd, pageNum, text, 1, nil)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := lDoc.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := lState.Flush(); err != nil {
+		t.Fatal(err)
+	}
+}
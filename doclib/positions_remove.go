@@ -0,0 +1,210 @@
+package doclib
+
+import (
+	"errors"
+
+	"github.com/blevesearch/bleve"
+	"github.com/unidoc/unidoc/common"
+)
+
+// ErrNoDoc is returned when a document is not in a Store.
+var ErrNoDoc = errors.New("document not in store")
+
+// RemoveDoc removes the PDF with file hash `hash` from `lState` and its pages from `index`.
+// The document's .dat, .idx.json, .dpl.json and .pages files, and the page texts that no other
+// document refers to, are deleted and it is removed from file_list.json.
+// The documents after the removed document in file_list.json move down one place. Their bleve IDs
+// encode their document index so their pages are re-indexed under their new IDs.
+func (lState *Store) RemoveDoc(index bleve.Index, hash string) error {
+	return lState.RemoveDocs(index, []string{hash})
+}
+
+// RemoveDocs removes the PDFs with file hashes `hashes` from `lState` and their pages from `index`
+// in one pass. The documents after each removed document are re-indexed once, however many
+// documents are removed. It waits for documents that other goroutines are adding to `lState`.
+// See RemoveDoc.
+func (lState *Store) RemoveDocs(index bleve.Index, hashes []string) error {
+	return lState.removeDocs(index, hashes, false)
+}
+
+// removeDocs is RemoveDocs. If `force` is true, documents whose positions files can't be read are
+// also removed. Only the bleve pages of such documents that are listed in their page spans are
+// removed from `index`.
+func (lState *Store) removeDocs(index bleve.Index, hashes []string, force bool) error {
+	if err := lState.lockWriter(); err != nil {
+		return err
+	}
+	defer lState.unlockWriter()
+	lState.mu.Lock()
+	defer lState.mu.Unlock()
+	removed := map[uint64]bool{}
+	var lDocs []*Doc
+	var numPages []int
+	var cold []bool
+	for _, hash := range hashes {
+		docIdx, ok := lState.hashIndex[hash]
+		if !ok {
+			return ErrNoDoc
+		}
+		if removed[docIdx] {
+			continue
+		}
+		removed[docIdx] = true
+		lDoc, err := lState.OpenPositionsDoc(docIdx)
+		if err == nil {
+			err = lDoc.Close()
+		} else if force {
+			common.Log.Error("removeDocs: Could not open %q. Removing it anyway. err=%v",
+				lState.fileList[docIdx].InPath, err)
+			lDoc, err = lState.damagedDoc(docIdx)
+		}
+		if err != nil {
+			return err
+		}
+		n := lDoc.Len()
+		common.Log.Info("RemoveDocs: hash=%q docIdx=%d numPages=%d", hash, docIdx, n)
+		lDocs = append(lDocs, lDoc)
+		numPages = append(numPages, n)
+		cold = append(cold, lState.fileList[docIdx].Cold)
+	}
+	if len(lDocs) == 0 {
+		return nil
+	}
+
+	b := newBatcher(index, maxBatchOps)
+	for i, lDoc := range lDocs {
+		for pageIdx := 0; pageIdx < numPages[i]; pageIdx++ {
+			if err := b.delete(pageID(lDoc.docIdx, uint32(pageIdx))); err != nil {
+				return err
+			}
+		}
+	}
+
+	// Move the pages of the following documents down one document index per removed document
+	// before them. Documents are moved in increasing index order so that no moved page is given
+	// the ID of a page that hasn't been moved yet.
+	shift := uint64(0)
+	for idx := uint64(0); idx < uint64(len(lState.fileList)); idx++ {
+		if removed[idx] {
+			shift++
+			continue
+		}
+		if shift == 0 {
+			continue
+		}
+		if err := lState.reindexDoc(b, idx, idx-shift); err != nil {
+			return err
+		}
+	}
+	if err := b.flush(); err != nil {
+		return err
+	}
+
+	// Compact the file list.
+	var fileList []FileDesc
+	for i, fd := range lState.fileList {
+		if !removed[uint64(i)] {
+			fileList = append(fileList, fd)
+		}
+	}
+	lState.fileList = fileList
+	for _, lDoc := range lDocs {
+		hash := lState.indexHash[lDoc.docIdx]
+		delete(lState.hashPath, hash)
+		delete(lState.hashIndex, hash)
+		if lState.isMem() {
+			delete(lState.hashDoc, hash)
+		}
+	}
+	lState.indexHash = map[uint64]string{}
+	for i, fd := range lState.fileList {
+		idx := uint64(i)
+		lState.hashIndex[fd.Hash] = idx
+		lState.indexHash[idx] = fd.Hash
+		if lDoc, ok := lState.hashDoc[fd.Hash]; ok {
+			lDoc.docIdx = idx
+		}
+	}
+	for _, lDoc := range lDocs {
+		if err := lDoc.releasePageTexts(); err != nil {
+			return err
+		}
+	}
+	if err := lState.Flush(); err != nil {
+		return err
+	}
+	if err := lState.updateManifestFeatures(); err != nil {
+		return err
+	}
+	lState.bumpGeneration()
+	for _, hash := range hashes {
+		lState.evictDoc(hash)
+	}
+
+	for i, lDoc := range lDocs {
+		if err := lDoc.removeFiles(); err != nil {
+			return err
+		}
+		if cold[i] {
+			if err := lState.deleteCold(lDoc); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// reindexDoc moves the bleve pages of document `oldIdx` in `lState` to document index `newIdx`.
+func (lState *Store) reindexDoc(b *batcher, oldIdx, newIdx uint64) error {
+	lDoc, err := lState.OpenPositionsDoc(oldIdx)
+	if err != nil {
+		return err
+	}
+	defer lDoc.Close()
+	fd := lState.fileList[oldIdx]
+	numPages := uint32(lDoc.Len())
+	texts := make([]string, numPages)
+	annots := make([][]string, numPages)
+	for pageIdx := uint32(0); pageIdx < numPages; pageIdx++ {
+		text, err := lDoc.ReadPageText(pageIdx)
+		if err != nil {
+			return err
+		}
+		texts[pageIdx] = text
+		annots[pageIdx] = lDoc.PageAnnotations(pageIdx)
+	}
+	repeats := pageRepeats(texts)
+	annots = mergeRepeatAnnotations(texts, annots)
+	for pageIdx := uint32(0); pageIdx < numPages; pageIdx++ {
+		if err := b.delete(pageID(oldIdx, pageIdx)); err != nil {
+			return err
+		}
+		if repeats[pageIdx] == 0 {
+			continue
+		}
+		pageNum, err := lDoc.PageNum(pageIdx)
+		if err != nil {
+			return err
+		}
+		id := pageID(newIdx, pageIdx)
+		doc := pageDocument(id, fd, pageNum, texts[pageIdx], repeats[pageIdx], annots[pageIdx])
+		if err := b.indexDoc(id, fd.Hash, doc); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// damagedDoc returns a Doc for removing document `docIdx` in `lState`, which can't be
+// opened. It has the document's page spans if they can be read. It doesn't need to be closed.
+func (lState *Store) damagedDoc(docIdx uint64) (*Doc, error) {
+	if lDoc, err := lState.openSpans(docIdx); err == nil {
+		return lDoc, nil
+	}
+	lDoc, err := lState.baseFields(docIdx)
+	if err != nil {
+		return nil, err
+	}
+	lDoc.readOnly = true
+	return lDoc, nil
+}
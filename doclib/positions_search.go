@@ -1,25 +1,52 @@
 package doclib
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"path/filepath"
+	"regexp"
+	"runtime"
 	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/blevesearch/bleve"
 	"github.com/blevesearch/bleve/registry"
 	"github.com/blevesearch/bleve/search"
+	"github.com/blevesearch/bleve/search/query"
 	"github.com/peterwilliams97/pdf-search/serial"
 	"github.com/unidoc/unidoc/common"
 )
 
 type PdfMatchSet struct {
-	TotalMatches   int
+	// TotalMatches is the number of matches. Searches that filter or collapse the bleve hits,
+	// e.g. with SearchOptions.Within, only filter the hits they fetch, so the hits that weren't
+	// fetched are counted as matches. TotalMatches is an upper bound for them unless all the hits
+	// were fetched.
+	TotalMatches int
+	// IndexDuration is the time taken to index the PDFs in the Store that was searched.
+	// It is zero if the PDFs were indexed by another process.
+	IndexDuration time.Duration
+	// SearchDuration is the time taken by the bleve search.
 	SearchDuration time.Duration
-	Matches        []PdfMatch
+	// BoostDuration is the time taken to apply SearchOptions.Boosts to the bleve hits.
+	BoostDuration time.Duration
+	// HydrationDuration is the time taken to look up the PDF text positions of the bleve hits.
+	HydrationDuration time.Duration
+	// ExtractorCounts is {extractor: number of matching pages}. The extractors are names such as
+	// "unidoc" and name/versions such as "unidoc/3.0.0". See SearchOptions.ExtractorFacet.
+	ExtractorCounts map[string]int
+	// TagCounts is {tag: number of matching pages}. See SearchOptions.TagFacet and TagFacets.
+	TagCounts map[string]int
+	// From is the offset of the first match in `Matches` in the full list of TotalMatches matches.
+	From int
+	// NextCursor is an opaque cursor for fetching the next page of matches with
+	// SearchOptions.Cursor. It is empty if there are no more matches.
+	NextCursor string
+	Matches    []PdfMatch
 }
 
 // PdfMatch describes a single search match in a PDF document.
@@ -29,6 +56,49 @@ type PdfMatch struct {
 	PageNum uint32
 	LineNum int
 	Line    string
+	// Snippet is the whole sentences around the first matched term. See getSnippet().
+	Snippet string
+	// RepeatPageNums are the page numbers of all the pages in the PDF with the same text as this
+	// page, including this page. Only the first of these pages is indexed. It is nil if the page
+	// text is not repeated.
+	RepeatPageNums []uint32
+	// NearDuplicates is the number of matches on near-duplicate pages that were dropped in favor
+	// of this one. See SearchOptions.CollapseDuplicates.
+	NearDuplicates int
+	// Copies are the other pages with the same text as this page whose matches were dropped in
+	// favor of this one, such as the same appendix in other PDFs. See
+	// SearchOptions.CollapseIdentical.
+	Copies []PageRef
+	// Positions are the bounding boxes of the matched terms in Spans. Positions[i] is the bounding
+	// box of Spans[i]. It is the zero TextLocation if no box was found.
+	Positions []serial.TextLocation
+	// Page is the crop box and rotation of the page. It is zero if the page was indexed before page
+	// boxes were recorded.
+	Page PageBox
+	// Boxes are Positions in BoxCoords coordinates. Boxes[i] is the box of Spans[i]. When BoxCoords
+	// is CoordsView, web viewers can draw Boxes on the rendered page without knowing about PDF
+	// coordinates. Boxes is nil and BoxCoords is empty if Page is zero.
+	Boxes     []ViewRect
+	BoxCoords CoordSpace
+	// Bookmark is the title of the PDF bookmark whose section contains the page, e.g.
+	// "7.3 Annotations". It is empty if the PDF has no bookmarks before the page.
+	Bookmark string
+	// Heading is the last line on the page up to the matched line that looks like a numbered
+	// section heading, e.g. "7.3 Annotations". It is empty if there is no such line.
+	Heading string
+	// FormField is the name of the PDF form field whose value matched the query. It is empty if
+	// no form field value matched. See FileDesc.FormFields.
+	FormField string
+	// Annotation is the contents of the annotation on the page, such as a sticky note or a comment
+	// on a highlight, that matched the query. It is empty if no annotation matched. Matches with
+	// an Annotation may have no Spans if only the annotation matched.
+	Annotation string
+	// Table is the table cell that contains the first matched term and the cell's row. It is nil
+	// if the term isn't in a table or the page was indexed before tables were detected. See
+	// detectTables.
+	Table *TableMatch
+	// DocLabels are the URI, display title and tags of the PDF. See IndexOptions.Labels.
+	DocLabels
 	serial.DocPageLocations
 	match
 }
@@ -38,10 +108,94 @@ type match struct {
 	pageIdx  uint32
 	Score    float64
 	Fragment string
-	Start    uint32
-	End      uint32
+	Start    uint32     // Start of first matched term in page text.
+	End      uint32     // End of first matched term in page text.
+	Spans    []TermSpan // All the matched terms in the page text in text order.
+	repeats  int        // Number of pages in the PDF with the same text as this page.
+	formIdx  int        // Index of the first matched form field value or -1 if there isn't one.
+	annotIdx int        // Index of the first matched annotation or -1 if there isn't one.
 }
 
+// TermSpan is the location of a matched search term in a page's text.
+// The span is over [Start, End) in the page text.
+type TermSpan struct {
+	Term  string // The (analyzed) term that was matched.
+	Start uint32 // Offset of the start of the match in the page text.
+	End   uint32 // Offset of the end of the match in the page text.
+}
+
+// PageTermSpans are the locations of the matches of a search term on a PDF page.
+type PageTermSpans struct {
+	InPath  string
+	PageNum uint32
+	Spans   []TermSpan
+}
+
+// SearchOptions controls how SearchIndexOpts searches an index.
+type SearchOptions struct {
+	// MaxResults and From select the page of matches to return. Only the first 10,000 matches
+	// can be returned, so searches fail with ErrResultWindow if From+MaxResults is more than that.
+	MaxResults int        // Maximum number of matches to return. This is the page size.
+	From       int        // Offset of the first match to return. Ignored if Cursor is set.
+	Cursor     string     // PdfMatchSet.NextCursor from the search for the previous page.
+	Boosts     BoostTable // Ranking boosts for documents. May be nil.
+	// AllTerms restricts matches to pages that contain all the terms in the query. By default
+	// pages that contain any of the terms match.
+	AllTerms bool
+	// Within, if > 0, restricts matches to pages where all the terms in the query occur within
+	// this many characters of each other. It implies AllTerms. PdfMatchSet.TotalMatches may be
+	// an upper bound on the number of matches.
+	Within int
+	// ExtractorFacet requests the number of matching pages per extractor in
+	// PdfMatchSet.ExtractorCounts.
+	ExtractorFacet bool
+	// Lang, if not empty, restricts matches to pages in this language, e.g. "fr". Pages that
+	// match the query after stemming with the language's analyzer also match. See IDText.Lang.
+	Lang string
+	// Fuzziness, if > 0, is the number of edits, up to 2, by which words on a page can differ from
+	// the query terms and still match. This finds pages with OCR errors and typos. Exact matches
+	// rank above approximate ones. Terms shorter than 4 characters must match exactly.
+	Fuzziness int
+	// Tags, if not empty, restricts matches to pages of documents that have all these tags, e.g.
+	// "department=legal". See IndexOptions.Tags.
+	Tags []string
+	// TagFacet requests the number of matching pages per document tag in PdfMatchSet.TagCounts.
+	TagFacet bool
+	// After and Before, if not zero, restrict matches to pages of documents whose date is at or
+	// after After and before Before. Documents without dates don't match. See ParseDate.
+	After, Before time.Time
+	// DateField is the date that After, Before and Sort apply to: DateCreated, the default, or
+	// DateModified.
+	DateField string
+	// Sort is the order of the matches: SortScore, the default, SortOldest, SortNewest, SortPath,
+	// SortSmallest or SortLargest. Boosts are ignored unless matches are sorted by score.
+	Sort string
+	// CollapseDuplicates drops the matches on pages that are near-duplicates of the pages of
+	// higher scoring matches, such as the same page in other revisions of a manual. The number of
+	// dropped matches is in PdfMatch.NearDuplicates. See NearDuplicatePages.
+	CollapseDuplicates bool
+	// CollapseIdentical drops the matches on pages with exactly the same text as the pages of
+	// higher scoring matches, such as appendices copied into several PDFs and PDFs merged from
+	// others. The locations of the dropped matches are in PdfMatch.Copies. Unlike
+	// CollapseDuplicates, pages that differ slightly, e.g. in their revision dates, case or
+	// punctuation, are kept.
+	CollapseIdentical bool
+	// CaseSensitive and DiacriticSensitive stop the query from matching words that differ from it
+	// in case, e.g. "it" for "IT", or in diacritics, e.g. "résumé" for "resume". By default
+	// neither is significant. Stores built before this was indexed must be rebuilt for the default
+	// to match words with other diacritics and for case sensitive searches to match anything.
+	CaseSensitive, DiacriticSensitive bool
+	// Synonyms are the synonyms of the words in the query that also match. If it is nil then the
+	// store's synonyms are used. See IndexOptions.Synonyms.
+	Synonyms *SynonymDict
+	// Quantity, if not nil, restricts matches to pages with a number or quantity in its range, in
+	// any unit of the same dimension, e.g. 0.5 cm for 2..10 mm. See ParseQuantityRange.
+	Quantity *QuantityRange
+}
+
+// SearchPdfIndex returns the PdfMatchSet for query `term` over the store in `persistDir`.
+// If the store has a boosts.json file, it is used as the BoostTable for the search. See
+// LoadBoostTable().
 func SearchPdfIndex(persistDir, term string, maxResults int) (PdfMatchSet, error) {
 	p := PdfMatchSet{}
 
@@ -52,7 +206,7 @@ func SearchPdfIndex(persistDir, term string, maxResults int) (PdfMatchSet, error
 	common.Log.Debug("indexPath=%q", indexPath)
 
 	// Open existing index.
-	index, err := bleve.Open(indexPath)
+	index, err := OpenStoreIndex(persistDir)
 	if err != nil {
 		return p, fmt.Errorf("Could not open Bleve index %q", indexPath)
 	}
@@ -64,7 +218,13 @@ func SearchPdfIndex(persistDir, term string, maxResults int) (PdfMatchSet, error
 	}
 	common.Log.Debug("lState=%s", *lState)
 
-	results, err := SearchIndex(lState, index, term, maxResults)
+	boosts, err := loadStoreBoosts(persistDir)
+	if err != nil {
+		return p, err
+	}
+
+	opts := SearchOptions{MaxResults: maxResults, Boosts: boosts}
+	results, err := SearchIndexOpts(lState, index, term, opts)
 	if err != nil {
 		return p, fmt.Errorf("Could not find term=%q %q. err=%v", term, persistDir, err)
 	}
@@ -75,42 +235,263 @@ func SearchPdfIndex(persistDir, term string, maxResults int) (PdfMatchSet, error
 	return results, nil
 }
 
-func SearchIndex(lState *PositionsState, index bleve.Index, term string, maxResults int) (
+// SearchIndex returns the PdfMatchSet for query `term` over the PDFs in `lState` and `index`.
+// `term` may contain field-scoped queries such as `author:smith` and date-range filters such as
+// `created:>="2017-01-01"`. See makeQuery().
+func SearchIndex(lState *Store, index bleve.Index, term string, maxResults int) (
 	PdfMatchSet, error) {
+	return SearchIndexOpts(lState, index, term, SearchOptions{MaxResults: maxResults})
+}
+
+// SearchIndexOpts is SearchIndex with the search options `opts`.
+func SearchIndexOpts(lState *Store, index bleve.Index, term string, opts SearchOptions) (
+	PdfMatchSet, error) {
+	return SearchIndexContext(context.Background(), lState, index, term, opts)
+}
+
+// SearchIndexContext is SearchIndexOpts with a context. The search stops with `ctx`'s error if
+// `ctx` is cancelled or times out, e.g. when the client of a server goes away.
+func SearchIndexContext(ctx context.Context, lState *Store, index bleve.Index,
+	term string, opts SearchOptions) (PdfMatchSet, error) {
 	p := PdfMatchSet{}
+	if opts.From < 0 {
+		return p, fmt.Errorf("Bad From=%d. It must not be negative.", opts.From)
+	}
+	if opts.MaxResults <= 0 {
+		return p, fmt.Errorf("Bad MaxResults=%d. It must be positive.", opts.MaxResults)
+	}
+	term = lState.normalization.normalizeQuery(term)
+	if opts.Synonyms == nil {
+		opts.Synonyms = lState.synonyms
+	}
+	maxResults := opts.MaxResults
+	from := opts.From
+	if opts.Cursor != "" {
+		var err error
+		if from, err = decodeCursor(term, opts.Cursor); err != nil {
+			return p, err
+		}
+	}
+	if err := checkResultWindow(from, maxResults); err != nil {
+		return p, err
+	}
+	if err := checkDateOptions(opts); err != nil {
+		return p, err
+	}
+	if err := checkSortOrder(opts.Sort); err != nil {
+		return p, err
+	}
+	if opts.Quantity != nil {
+		if err := opts.Quantity.check(); err != nil {
+			return p, err
+		}
+	}
+	boosted := len(opts.Boosts) > 0 && opts.Sort == SortScore
+	rerank := boosted || opts.Within > 0 || opts.CollapseDuplicates || opts.CollapseIdentical
 
-	common.Log.Debug("SearchIndex: term=%q maxResults=%d", term, maxResults)
+	common.Log.Debug("SearchIndex: term=%q maxResults=%d from=%d", term, maxResults, from)
 
 	if lState.Len() == 0 {
 		return p, fmt.Errorf("Empty positions store %s", lState)
 	}
 
-	query := bleve.NewMatchQuery(term)
-	search := bleve.NewSearchRequest(query)
-	types, _ := registry.HighlighterTypesAndInstances()
-	common.Log.Debug("Higlighters=%+v", types)
-	search.Highlight = bleve.NewHighlight()
-	search.Fields = []string{"Text"}
-	search.Highlight.Fields = search.Fields
-	search.Size = maxResults
+	search := makeSearchRequest(index, term, opts)
+	search.From, search.Size = searchWindow(from, maxResults, rerank)
 
-	searchResults, err := index.Search(search)
+	var searchResults *bleve.SearchResult
+	var collapsed map[string]int
+	var copies map[string][]PageRef
+	var total int
+	var err error
+	if rerank {
+		searchResults, total, collapsed, copies, err = lState.searchFiltered(ctx, index, search,
+			opts, from+maxResults)
+	} else if searchResults, err = index.SearchInContext(ctx, search); err == nil {
+		total = int(searchResults.Total)
+	}
 	if err != nil {
 		return p, err
 	}
+	p.IndexDuration = lState.indexDuration
+	p.SearchDuration = searchResults.Took
+	p.From = from
 
 	common.Log.Debug("=================!!!=====================")
 	common.Log.Debug("searchResults=%T", searchResults)
 
+	var boostDuration time.Duration
+	if rerank {
+		hits := searchResults.Hits
+		if boosted {
+			t0 := time.Now()
+			hits = lState.boostHits(hits, opts.Boosts, from+maxResults)
+			boostDuration = time.Since(t0)
+		} else if len(hits) > from+maxResults {
+			hits = hits[:from+maxResults]
+		}
+		if len(hits) > from {
+			hits = hits[from:]
+		} else {
+			hits = nil
+		}
+		searchResults.Hits = hits
+	}
+	p.TotalMatches = total
 	if len(searchResults.Hits) == 0 {
 		common.Log.Info("No matches")
 		return p, nil
 	}
+	next := from + len(searchResults.Hits)
 
-	return lState.getPdfMatches(searchResults)
+	p, err = lState.getPdfMatches(searchResults)
+	if err != nil {
+		return p, err
+	}
+	p.TotalMatches = total
+	for i, m := range p.Matches {
+		id := pageID(m.docIdx, m.pageIdx)
+		p.Matches[i].NearDuplicates = collapsed[id]
+		if refs := copies[id]; len(refs) > 0 {
+			if err := lState.setPageNums(refs); err != nil {
+				return p, err
+			}
+			p.Matches[i].Copies = refs
+		}
+	}
+	p.IndexDuration = lState.indexDuration
+	p.BoostDuration = boostDuration
+	p.ExtractorCounts = extractorCounts(searchResults)
+	p.TagCounts = tagCounts(searchResults)
+	if opts.Sort == SortPath {
+		// Stores indexed before paths and page numbers were indexed aren't sorted by bleve.
+		p = p.Sorted(SortPath)
+	}
+	p.From = from
+	if next < p.TotalMatches && next < maxResultWindow {
+		p.NextCursor = encodeCursor(term, next)
+	}
+	return p, nil
 }
 
-func (lState *PositionsState) getResults(sr *bleve.SearchResult) (string, error) {
+// searchFiltered returns the bleve search results of `request` with the hits that pass the
+// SearchOptions.Within, CollapseIdentical and CollapseDuplicates filters in `opts`, the number of
+// matches, and the near-duplicate counts and identical copies of the hits. The bleve hits are
+// fetched in windows of growing sizes, starting with `request`'s, until `needed` hits pass the
+// filters or there are no more hits. The hits that weren't fetched are counted as matches.
+func (lState *Store) searchFiltered(ctx context.Context, index bleve.Index,
+	request *bleve.SearchRequest, opts SearchOptions, needed int) (*bleve.SearchResult, int,
+	map[string]int, map[string][]PageRef, error) {
+
+	var results *bleve.SearchResult
+	var near search.DocumentMatchCollection // The fetched hits that pass the Within filter.
+	fetched := 0
+	for {
+		sr, err := index.SearchInContext(ctx, request)
+		if err != nil {
+			return nil, 0, nil, nil, err
+		}
+		if results == nil {
+			results = sr
+		} else {
+			results.Took += sr.Took
+		}
+		fetched += len(sr.Hits)
+		hits := sr.Hits
+		if opts.Within > 0 {
+			hits = nearHits(hits, opts.Within)
+		}
+		near = append(near, hits...)
+
+		// The collapse filters compare each hit with the hits before it, so they are applied to
+		// all the fetched hits.
+		hits = near
+		var collapsed map[string]int
+		var copies map[string][]PageRef
+		if opts.CollapseIdentical {
+			if hits, copies, err = lState.collapseIdenticalHits(hits); err != nil {
+				return nil, 0, nil, nil, err
+			}
+		}
+		if opts.CollapseDuplicates {
+			hits, collapsed = lState.collapseHits(hits)
+		}
+		if len(hits) >= needed || len(sr.Hits) < request.Size || fetched >= int(sr.Total) {
+			common.Log.Debug("searchFiltered: %d of %d hits fetched. %d passed", fetched,
+				sr.Total, len(hits))
+			results.Hits = hits
+			return results, len(hits) + int(sr.Total) - fetched, collapsed, copies, nil
+		}
+		request.From, request.Size = fetched, nextSearchWindow(request.Size)
+	}
+}
+
+// makeSearchRequest returns the bleve search request for query `term` over `index` with options
+// `opts`. The caller sets the request's From and Size.
+func makeSearchRequest(index bleve.Index, term string, opts SearchOptions) *bleve.SearchRequest {
+	var q query.Query
+	if opts.AllTerms || opts.Within > 0 {
+		q = allTermsQuery(index, term, opts.Fuzziness)
+	} else {
+		q = makeQuery(term)
+		if opts.Fuzziness > 0 {
+			q = fuzzyQuery(index, q, term, opts.Fuzziness)
+		}
+	}
+	if opts.Synonyms != nil {
+		q = opts.Synonyms.synonymQuery(index, q, term, opts.AllTerms || opts.Within > 0,
+			opts.Fuzziness)
+	}
+	q = foldQuery(q, term, opts)
+	if !opts.AllTerms && opts.Within == 0 {
+		q = quantityQuery(q, term)
+	}
+	if opts.Lang != "" {
+		// Stemming would match words with other cases and diacritics.
+		stem := !opts.CaseSensitive && !opts.DiacriticSensitive
+		q = langQuery(q, term, opts.Lang, stem)
+	}
+	if len(opts.Tags) > 0 {
+		q = tagsQuery(q, opts.Tags)
+	}
+	if !opts.After.IsZero() || !opts.Before.IsZero() {
+		q = dateQuery(q, dateField(opts), opts.After, opts.Before)
+	}
+	if opts.Quantity != nil {
+		q = quantityRangeQuery(q, *opts.Quantity)
+	}
+	search := bleve.NewSearchRequest(q)
+	types, _ := registry.HighlighterTypesAndInstances()
+	common.Log.Debug("Higlighters=%+v", types)
+	search.Highlight = bleve.NewHighlight()
+	search.Fields = []string{textField, repeatsField}
+	search.Highlight.Fields = []string{textField}
+	if opts.ExtractorFacet {
+		search.AddFacet(extractorField, bleve.NewFacetRequest(extractorField, maxExtractorFacets))
+	}
+	if opts.TagFacet {
+		search.AddFacet(tagField, bleve.NewFacetRequest(tagField, maxTagFacets))
+	}
+	search.SortBy(bleveSortOrder(opts))
+	return search
+}
+
+// maxExtractorFacets is the maximum number of extractors counted in PdfMatchSet.ExtractorCounts.
+const maxExtractorFacets = 20
+
+// extractorCounts returns the extractor facet counts in `sr` or nil if there aren't any.
+func extractorCounts(sr *bleve.SearchResult) map[string]int {
+	facet, ok := sr.Facets[extractorField]
+	if !ok || facet.Terms == nil {
+		return nil
+	}
+	counts := map[string]int{}
+	for _, t := range facet.Terms {
+		counts[t.Term] = t.Count
+	}
+	return counts
+}
+
+func (lState *Store) getResults(sr *bleve.SearchResult) (string, error) {
 	matchSet, err := lState.getPdfMatches(sr)
 	if err != nil {
 		return "", err
@@ -118,29 +499,120 @@ func (lState *PositionsState) getResults(sr *bleve.SearchResult) (string, error)
 	return matchSet.String(), nil
 }
 
-func (lState *PositionsState) getPdfMatches(sr *bleve.SearchResult) (PdfMatchSet, error) {
+// maxHydrateWorkers is the maximum number of goroutines getPdfMatches uses to look up hits in a
+// Store.
+const maxHydrateWorkers = 8
+
+// getPdfMatches returns the PdfMatchSet corresponding to the bleve search results `sr`.
+// The hits are grouped by document and the documents are looked up concurrently. Each document
+// is opened once for all its hits.
+func (lState *Store) getPdfMatches(sr *bleve.SearchResult) (PdfMatchSet, error) {
 	var matches []PdfMatch
+	t0 := time.Now()
 	if sr.Total > 0 && sr.Request.Size > 0 {
-		for _, hit := range sr.Hits {
-			m, err := lState.getPdfMatch(hit)
-			if err != nil {
-				if err == ErrNoMatch {
-					continue
-				}
-				return PdfMatchSet{}, err
-			}
-			matches = append(matches, m)
+		var err error
+		matches, err = lState.hydrateHits(sr.Hits)
+		if err != nil {
+			return PdfMatchSet{}, err
 		}
 	}
 
 	return PdfMatchSet{
-		TotalMatches:   int(sr.Total),
-		SearchDuration: sr.Took,
-		Matches:        matches,
+		TotalMatches:      int(sr.Total),
+		SearchDuration:    sr.Took,
+		HydrationDuration: time.Since(t0),
+		Matches:           matches,
 	}, nil
 }
 
-func (lState *PositionsState) getHit(i int, hit *search.DocumentMatch) (string, error) {
+// hydrateResult is the result of looking up a hit in a Store.
+type hydrateResult struct {
+	m   PdfMatch
+	err error
+}
+
+// hydrateHits returns the PdfMatches for `hits` in the order of `hits`. Hits with no matched terms
+// are skipped.
+func (lState *Store) hydrateHits(hits search.DocumentMatchCollection) ([]PdfMatch, error) {
+	results := make([]hydrateResult, len(hits))
+
+	// docHits is {docIdx: indexes in `hits` of hits on document docIdx}
+	docHits := map[uint64][]int{}
+	var docOrder []uint64
+	var ms []match
+	for i, hit := range hits {
+		m, err := getMatch(hit)
+		ms = append(ms, m)
+		if err != nil {
+			results[i].err = err
+			continue
+		}
+		if _, ok := docHits[m.docIdx]; !ok {
+			docOrder = append(docOrder, m.docIdx)
+		}
+		docHits[m.docIdx] = append(docHits[m.docIdx], i)
+	}
+
+	numWorkers := runtime.NumCPU()
+	if numWorkers > maxHydrateWorkers {
+		numWorkers = maxHydrateWorkers
+	}
+	if numWorkers > len(docOrder) {
+		numWorkers = len(docOrder)
+	}
+
+	// Each document is hydrated by a single worker so the workers write to disjoint elements of
+	// `results` and never share a Doc.
+	docs := make(chan uint64)
+	var wg sync.WaitGroup
+	for w := 0; w < numWorkers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for docIdx := range docs {
+				lState.hydrateDocHits(docIdx, docHits[docIdx], ms, results)
+			}
+		}()
+	}
+	for _, docIdx := range docOrder {
+		docs <- docIdx
+	}
+	close(docs)
+	wg.Wait()
+
+	var matches []PdfMatch
+	for _, r := range results {
+		if r.err != nil {
+			if r.err == ErrNoMatch {
+				continue
+			}
+			return nil, r.err
+		}
+		matches = append(matches, r.m)
+	}
+	return matches, nil
+}
+
+// hydrateDocHits looks up the matches `ms`[i] for i in `hitIdxs` in document `docIdx` and stores
+// the results in `results`[i].
+func (lState *Store) hydrateDocHits(docIdx uint64, hitIdxs []int, ms []match,
+	results []hydrateResult) {
+
+	lDoc, err := lState.OpenPositionsDoc(docIdx)
+	if err != nil {
+		for _, i := range hitIdxs {
+			results[i].err = err
+		}
+		return
+	}
+	defer lDoc.Close()
+	for _, i := range hitIdxs {
+		m, err := getDocPdfMatch(lDoc, ms[i])
+		results[i] = hydrateResult{m: m, err: err}
+	}
+}
+
+func (lState *Store) getHit(i int, hit *search.DocumentMatch) (string, error) {
 	p, err := lState.getPdfMatch(hit)
 	if err != nil {
 		return "", err
@@ -153,14 +625,14 @@ func (s PdfMatchSet) String() string {
 		return "No matches"
 	}
 	if len(s.Matches) == 0 {
-		return fmt.Sprintf("%d matches, SearchDuration %s\n", s.TotalMatches, s.SearchDuration)
+		return fmt.Sprintf("%d matches, %s\n", s.TotalMatches, s.Timings())
 	}
 	var b strings.Builder
-	fmt.Fprintf(&b, "%d matches, showing %d, SearchDuration %s\n",
-		s.TotalMatches, len(s.Matches), s.SearchDuration)
+	fmt.Fprintf(&b, "%d matches, showing %d-%d, %s\n", s.TotalMatches, s.From+1,
+		s.From+len(s.Matches), s.Timings())
 	for i, m := range s.Matches {
 		fmt.Fprintln(&b, "--------------------------------------------------")
-		fmt.Fprintf(&b, "%d: %s\n", i+1, m)
+		fmt.Fprintf(&b, "%d: %s\n", s.From+i+1, m)
 	}
 	return b.String()
 }
@@ -175,11 +647,26 @@ func (s PdfMatchSet) Filter(maxResultsPerFile int) PdfMatchSet {
 			matches = append(matches, m)
 		}
 	}
-	return PdfMatchSet{
-		TotalMatches:   s.TotalMatches,
-		SearchDuration: s.SearchDuration, // !@#$ IndexDuration
-		Matches:        matches,
+	s.Matches = matches
+	return s
+}
+
+// Duration returns the total time taken to build `s`, including the time taken to index the PDFs.
+func (s PdfMatchSet) Duration() time.Duration {
+	return s.IndexDuration + s.SearchDuration + s.BoostDuration + s.HydrationDuration
+}
+
+// Timings returns a description of the time taken by each phase of building `s`.
+func (s PdfMatchSet) Timings() string {
+	timings := fmt.Sprintf("SearchDuration %s HydrationDuration %s", s.SearchDuration,
+		s.HydrationDuration)
+	if s.BoostDuration > 0 {
+		timings += fmt.Sprintf(" BoostDuration %s", s.BoostDuration)
+	}
+	if s.IndexDuration > 0 {
+		timings = fmt.Sprintf("IndexDuration %s %s", s.IndexDuration, timings)
 	}
+	return timings
 }
 
 // Files returns the unique file names in `s`.
@@ -196,40 +683,165 @@ func (s PdfMatchSet) Files() []string {
 	return files
 }
 
+// TermLocations returns the locations of all the matched terms in `s` grouped by term and page.
+// The returned map is {term: locations of term on each page in `s`}.
+func (s PdfMatchSet) TermLocations() map[string][]PageTermSpans {
+	termPages := map[string][]PageTermSpans{}
+	for _, m := range s.Matches {
+		for term, spans := range m.TermSpans() {
+			termPages[term] = append(termPages[term], PageTermSpans{
+				InPath:  m.InPath,
+				PageNum: m.PageNum,
+				Spans:   spans,
+			})
+		}
+	}
+	return termPages
+}
+
+// TermSpans returns the matched terms in `p` grouped by term.
+func (p PdfMatch) TermSpans() map[string][]TermSpan {
+	termSpans := map[string][]TermSpan{}
+	for _, span := range p.Spans {
+		termSpans[span.Term] = append(termSpans[span.Term], span)
+	}
+	return termSpans
+}
+
 func (p PdfMatch) String() string {
-	return fmt.Sprintf("path=%q pageNum=%d line=%d (score=%.3f) match=%q\n"+
+	bookmark := ""
+	if p.Bookmark != "" {
+		bookmark = fmt.Sprintf(" bookmark=%q", p.Bookmark)
+	}
+	title := ""
+	if p.Title != "" {
+		title = fmt.Sprintf(" title=%q", p.Title)
+	}
+	field := ""
+	if p.FormField != "" {
+		field = fmt.Sprintf(" field=%q", p.FormField)
+	}
+	annotation := ""
+	if p.Annotation != "" {
+		annotation = fmt.Sprintf(" annotation=%q", p.Annotation)
+	}
+	dups := ""
+	if p.NearDuplicates > 0 {
+		dups = fmt.Sprintf(" near-duplicates=%d", p.NearDuplicates)
+	}
+	if len(p.Copies) > 0 {
+		locs := make([]string, len(p.Copies))
+		for i, c := range p.Copies {
+			locs[i] = fmt.Sprintf("%s:%d", c.InPath, c.PageNum)
+		}
+		dups += fmt.Sprintf(" copies=[%s]", strings.Join(locs, " "))
+	}
+	return fmt.Sprintf("path=%q%s pageNum=%d%s%s%s line=%d (score=%.3f)%s match=%q\n"+
 		"^^^^^^^^ Marked up Text ^^^^^^^^\n"+
 		"%s",
-		p.InPath, p.PageNum, p.LineNum, p.Score, p.Line, p.Fragment)
+		p.InPath, title, p.PageNum, bookmark, field, annotation, p.LineNum, p.Score, dups, p.Line,
+		p.Fragment)
 }
 
 // getPdfMatch returns the PdfMatch corresponding the bleve DocumentMatch `hit`.
 // The returned PdfMatch contains information that is not in `hit` that is looked up in `lState`.
 // We purposely try to keep `hit` small to improve bleve indexing performance and to reduce the
 // index size.
-func (lState *PositionsState) getPdfMatch(hit *search.DocumentMatch) (PdfMatch, error) {
+func (lState *Store) getPdfMatch(hit *search.DocumentMatch) (PdfMatch, error) {
 	m, err := getMatch(hit)
 	if err != nil {
 		return PdfMatch{}, err
 	}
-	inPath, pageNum, dpl, err := lState.ReadDocPagePositions(m.docIdx, m.pageIdx)
+	lDoc, err := lState.OpenPositionsDoc(m.docIdx)
 	if err != nil {
 		return PdfMatch{}, err
 	}
-	common.Log.Error("dpl=%#v", dpl)
-	text, err := lState.ReadDocPageText(m.docIdx, m.pageIdx)
+	defer lDoc.Close()
+	return getDocPdfMatch(lDoc, m)
+}
+
+// getDocPdfMatch returns the PdfMatch for match `m` in document `lDoc`.
+func getDocPdfMatch(lDoc *Doc, m match) (PdfMatch, error) {
+	inPath := lDoc.inPath
+	pageNum, dpl, err := lDoc.ReadPagePositions(m.pageIdx)
+	if err != nil {
+		return PdfMatch{}, err
+	}
+	common.Log.Debug("dpl=%#v", dpl)
+	// Record where the match is so that it can be used by clients that don't have the
+	// Store, e.g. RemoteIndex clients.
+	dpl.Doc = m.docIdx
+	dpl.Page = pageNum
+	text, err := lDoc.ReadPageText(m.pageIdx)
 	if err != nil {
 		return PdfMatch{}, err
 	}
+	// The bleve offsets are byte offsets into the text that was indexed. They are only valid for
+	// `text` if it is the same as the indexed text.
+	for _, span := range m.Spans {
+		if _, err := PageTextSpan(text, span.Start, span.End); err != nil {
+			return PdfMatch{}, fmt.Errorf("Bad span. inPath=%q pageNum=%d term=%q err=%v",
+				inPath, pageNum, span.Term, err)
+		}
+	}
 	lineNum, line, ok := getLineNumber(text, m.Start)
 	if !ok {
 		return PdfMatch{}, fmt.Errorf("No line number. m=%s", m)
 	}
+	var repeatNums []uint32
+	if m.repeats > 1 {
+		if repeatNums, err = repeatPageNums(lDoc, m.pageIdx); err != nil {
+			return PdfMatch{}, err
+		}
+	}
+	annotation := ""
+	if m.annotIdx >= 0 {
+		// The annotations of all the pages with the page's text were indexed with the page.
+		annots, err := repeatAnnotations(lDoc, m.pageIdx)
+		if err != nil {
+			return PdfMatch{}, err
+		}
+		if m.annotIdx < len(annots) {
+			annotation = annots[m.annotIdx]
+		}
+	}
+	positions := make([]serial.TextLocation, len(m.Spans))
+	for i, span := range m.Spans {
+		positions[i] = GetPosition(dpl.Locations, span.Start, span.End)
+	}
+	var table *TableMatch
+	if len(positions) > 0 {
+		table = findTableCell(lDoc.PageTables(m.pageIdx), positions[0])
+	}
+	page, hasBox := lDoc.pageBox(m.pageIdx)
+	var boxes []ViewRect
+	var boxCoords CoordSpace
+	if hasBox {
+		boxes = make([]ViewRect, len(positions))
+		for i, loc := range positions {
+			if loc != (serial.TextLocation{}) {
+				boxes[i] = page.ToView(loc)
+			}
+		}
+		boxCoords = CoordsView
+	}
 	return PdfMatch{
 		InPath:           inPath,
 		PageNum:          pageNum,
 		LineNum:          lineNum,
 		Line:             line,
+		Snippet:          getSnippet(text, m.Start, m.End),
+		RepeatPageNums:   repeatNums,
+		Positions:        positions,
+		Page:             page,
+		Boxes:            boxes,
+		BoxCoords:        boxCoords,
+		Bookmark:         lDoc.bookmark(pageNum),
+		Heading:          lastHeading(text, m.Start),
+		FormField:        lDoc.formFieldName(m.formIdx),
+		Annotation:       annotation,
+		Table:            table,
+		DocLabels:        lDoc.labels(),
 		DocPageLocations: dpl,
 		match:            m,
 	}, nil
@@ -242,6 +854,65 @@ func (m match) String() string {
 
 var ErrNoMatch = errors.New("no match for hit")
 
+// Names of fields in the bleve page documents. See IDText.
+const (
+	textField       = "Text"
+	formFieldsField = "FormFields"
+	annotsField     = "Annots"
+	repeatsField    = "repeats"
+	extractorField  = "extractor"
+	langField       = "lang"
+	tagField        = "tag"
+	fileField       = "file"
+	pageField       = "page"
+	sizeField       = "size"
+)
+
+// metadataFields are the text fields of the page ID and document metadata in the bleve page
+// documents.
+var metadataFields = []string{"ID", "title", "author", "subject", "keywords"}
+
+// queryFields are the fields of the bleve page documents that queries can be scoped to, apart
+// from the text_ fields, e.g. text_folded, and the fields of the quantityField sub-document.
+var queryFields = append([]string{textField, formFieldsField, annotsField, repeatsField,
+	extractorField, langField, tagField, fileField, pageField, sizeField, DateCreated,
+	DateModified}, metadataFields...)
+
+// fieldScopeRe matches the words followed by colons in queries, e.g. `author:`.
+var fieldScopeRe = regexp.MustCompile(`(^|\s)[+-]?([\w.]+):`)
+
+// hasFieldScope returns true if query `term` contains field scopes such as `author:smith`. Only
+// the names of fields of the bleve page documents are field scopes. Other words followed by
+// colons, e.g. in `https://example.com` and `note: details`, are searched for as text.
+func hasFieldScope(term string) bool {
+	for _, groups := range fieldScopeRe.FindAllStringSubmatch(term, -1) {
+		field := groups[2]
+		if strings.HasPrefix(field, "text_") || strings.HasPrefix(field, quantityField+".") {
+			return true
+		}
+		for _, f := range queryFields {
+			if field == f {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// makeQuery returns the bleve query for query string `term`.
+// Queries with field scopes, e.g. `author:smith`, or date ranges, e.g. `created:>="2017-01-01"`,
+// are parsed with the bleve query string syntax. Other queries match `term` against all fields.
+// See hasFieldScope.
+func makeQuery(term string) query.Query {
+	if hasFieldScope(term) {
+		return bleve.NewQueryStringQuery(term)
+	}
+	return bleve.NewMatchQuery(term)
+}
+
+// getMatch returns the match for bleve DocumentMatch `hit`. The match contains the locations of
+// all the matched terms in the page text of `hit`. Hits that only matched the document metadata,
+// including date-range matches, have no locations in the page text.
 func getMatch(hit *search.DocumentMatch) (match, error) {
 
 	docIdx, pageIdx, err := decodeID(hit.ID)
@@ -249,51 +920,111 @@ func getMatch(hit *search.DocumentMatch) (match, error) {
 		return match{}, err
 	}
 
-	start, end := -1, -1
-	frags := ""
-	common.Log.Debug("------------------------")
-	for k, fragments := range hit.Fragments {
+	n := 0
+	for _, fragments := range hit.Fragments {
 		for _, fragment := range fragments {
-			frags += fragment
+			n += len(fragment)
 		}
-		loc := hit.Locations[k]
-		common.Log.Info("%q: %v", k, frags)
-		for kk, v := range loc {
-			for i, l := range v {
-				common.Log.Info("\t%q: %d: %#v", kk, i, l)
-				if start < 0 {
-					start = int(l.Start)
-					end = int(l.End)
-				}
-			}
+	}
+	var sb strings.Builder
+	sb.Grow(n)
+	for _, fragments := range hit.Fragments {
+		for _, fragment := range fragments {
+			sb.WriteString(fragment)
 		}
 	}
-	if start < 0 {
-		common.Log.Error("Fragments=%d", len(hit.Fragments))
-		for k := range hit.Fragments {
-			loc := hit.Locations[k]
-			common.Log.Error("%q: %v", k, frags)
-			for kk, v := range loc {
-				for i, l := range v {
-					common.Log.Error("\t%q: %d: %#v", kk, i, l)
+	frags := sb.String()
+
+	// Only the offsets in the page text fields refer to the page text. A word that matched in
+	// more than one of them gets one span.
+	var spans []TermSpan
+	seen := map[[2]uint64]bool{}
+	common.Log.Debug("------------------------")
+	for _, field := range pageTextFields {
+		loc, ok := hit.Locations[field]
+		if !ok {
+			continue
+		}
+		common.Log.Debug("%q: %v", field, frags)
+		for term, v := range loc {
+			for i, l := range v {
+				common.Log.Debug("\t%q: %d: %#v", term, i, l)
+				if seen[[2]uint64{l.Start, l.End}] {
+					continue
 				}
+				seen[[2]uint64{l.Start, l.End}] = true
+				spans = append(spans, TermSpan{
+					Term:  term,
+					Start: uint32(l.Start),
+					End:   uint32(l.End),
+				})
 			}
 		}
-		err := ErrNoMatch
-		common.Log.Error("hit=%s err=%v", hit, err)
-		return match{}, err
 	}
+	// Indexes made before repeated pages were detected don't have a repeats field.
+	repeats := 1
+	if r, ok := hit.Fields[repeatsField].(float64); ok {
+		repeats = int(r)
+	}
+
+	formIdx := firstArrayPosition(hit.Locations[formFieldsField])
+	annotIdx := firstArrayPosition(hit.Locations[annotsField])
+
+	if len(spans) == 0 {
+		return match{
+			docIdx:   docIdx,
+			pageIdx:  pageIdx,
+			Score:    hit.Score,
+			Fragment: frags,
+			repeats:  repeats,
+			formIdx:  formIdx,
+			annotIdx: annotIdx,
+		}, nil
+	}
+	sort.Slice(spans, func(i, j int) bool {
+		si, sj := spans[i], spans[j]
+		if si.Start != sj.Start {
+			return si.Start < sj.Start
+		}
+		return si.Term < sj.Term
+	})
 	return match{
 		docIdx:   docIdx,
 		pageIdx:  pageIdx,
 		Score:    hit.Score,
 		Fragment: frags,
-		Start:    uint32(start),
-		End:      uint32(end),
+		Start:    spans[0].Start,
+		End:      spans[0].End,
+		Spans:    spans,
+		repeats:  repeats,
+		formIdx:  formIdx,
+		annotIdx: annotIdx,
 	}, nil
 }
 
-// id := fmt.Sprintf("%04X.%d", l.DocIdx, l.PageIdx)
+// firstArrayPosition returns the smallest array position of the term locations `locs` in an array
+// field, which is the index of the first matched value, or -1 if there are no locations.
+func firstArrayPosition(locs search.TermLocationMap) int {
+	first := -1
+	for _, v := range locs {
+		for _, l := range v {
+			if len(l.ArrayPositions) > 0 {
+				if i := int(l.ArrayPositions[0]); first < 0 || i < first {
+					first = i
+				}
+			}
+		}
+	}
+	return first
+}
+
+// pageID returns the bleve document ID of page `pageIdx` of document `docIdx`.
+func pageID(docIdx uint64, pageIdx uint32) string {
+	return fmt.Sprintf("%04X.%d", docIdx, pageIdx)
+}
+
+// decodeID returns the document and page indexes encoded in bleve document ID `id`.
+// It is the inverse of pageID.
 func decodeID(id string) (uint64, uint32, error) {
 	parts := strings.Split(id, ".")
 	if len(parts) != 2 {
@@ -351,7 +1082,14 @@ func lineEndings(text string) []uint32 {
 	return endings
 }
 
+// GetPosition returns the location of the text over [`start`, `end`) on a page with text locations
+// `positions`. For word locations it is the bounding box of the words that the text overlaps.
+// For the character locations of older stores it is the bounding box of the characters at `start`
+// and `end`.
 func GetPosition(positions []serial.TextLocation, start, end uint32) serial.TextLocation {
+	if isWordLocations(positions) {
+		return wordsPosition(positions, start, end)
+	}
 	i0, ok0 := getPositionIndex(positions, end)
 	i1, ok1 := getPositionIndex(positions, start)
 	if !(ok0 && ok1) {
@@ -371,7 +1109,7 @@ func GetPosition(positions []serial.TextLocation, start, end uint32) serial.Text
 func getPositionIndex(positions []serial.TextLocation, offset uint32) (int, bool) {
 	i := sort.Search(len(positions), func(i int) bool { return positions[i].Start >= offset })
 	ok := 0 <= i && i < len(positions)
-	if !ok {
+	if !ok && len(positions) > 0 {
 		common.Log.Error("getPositionIndex: offset=%d i=%d len=%d %v==%v", offset, i, len(positions),
 			positions[0], positions[len(positions)-1])
 	}
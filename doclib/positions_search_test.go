@@ -0,0 +1,194 @@
+package doclib
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/blevesearch/bleve"
+)
+
+// TestWithinPages checks the match count and cursors of proximity searches, which filter the
+// bleve hits.
+func TestWithinPages(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pdf-search-within")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	near := "Replace the pump filter every year."
+	far := "The pump is serviced every year. " + strings.Repeat("Nothing else happens. ", 10) +
+		"The filter is changed every month."
+	writeTestStore(t, dir, [][]string{{far}, {near}, {far}, {near}, {near}})
+	x, err := OpenPdfIndex(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer x.Close()
+
+	opts := SearchOptions{MaxResults: 2, Within: 20}
+	var inPaths []string
+	for page := 0; page < 3; page++ {
+		results, err := x.Search("pump filter", opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if results.TotalMatches != 3 {
+			t.Errorf("page %d: TotalMatches=%d expected=3", page, results.TotalMatches)
+		}
+		for _, m := range results.Matches {
+			inPaths = append(inPaths, m.InPath)
+		}
+		if results.NextCursor == "" {
+			break
+		}
+		opts.Cursor = results.NextCursor
+	}
+	if len(inPaths) != 3 {
+		t.Fatalf("matches=%q expected 3", inPaths)
+	}
+	for _, inPath := range inPaths {
+		if inPath != "doc1.pdf" && inPath != "doc3.pdf" && inPath != "doc4.pdf" {
+			t.Errorf("%q doesn't have the terms within 20 characters", inPath)
+		}
+	}
+}
+
+// TestWithinLowRanked checks that proximity searches find matches on pages that bleve ranks below
+// the first window of hits that the search fetches.
+func TestWithinLowRanked(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pdf-search-within")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	far := "pump pump pump. " + strings.Repeat("Nothing else happens. ", 3) + "filter filter filter."
+	near := "The pump filter is in the basement. " + strings.Repeat("Nothing else happens. ", 10)
+	var docs [][]string
+	for i := 0; i < 40; i++ {
+		docs = append(docs, []string{far})
+	}
+	docs = append(docs, []string{near}, []string{near})
+	writeTestStore(t, dir, docs)
+	x, err := OpenPdfIndex(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer x.Close()
+
+	// The pages with the terms far apart rank higher.
+	results, err := x.Search("pump filter", SearchOptions{MaxResults: 6, AllTerms: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, m := range results.Matches {
+		if m.InPath == "doc40.pdf" || m.InPath == "doc41.pdf" {
+			t.Fatalf("%q is in the first window of hits", m.InPath)
+		}
+	}
+
+	opts := SearchOptions{MaxResults: 2, Within: 20}
+	results, err = x.Search("pump filter", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results.Matches) != 2 {
+		t.Fatalf("matches=%d expected=2", len(results.Matches))
+	}
+	for _, m := range results.Matches {
+		if m.InPath != "doc40.pdf" && m.InPath != "doc41.pdf" {
+			t.Errorf("%q doesn't have the terms within 20 characters", m.InPath)
+		}
+	}
+	if results.TotalMatches != 2 || results.NextCursor != "" {
+		t.Errorf("TotalMatches=%d NextCursor=%q expected 2 and no cursor", results.TotalMatches,
+			results.NextCursor)
+	}
+}
+
+// TestResultWindow checks that searches for matches past the first maxResultWindow fail instead of
+// fetching that many bleve hits.
+func TestResultWindow(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pdf-search-window")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	writeTestStore(t, dir, [][]string{{"Replace the pump filter every year."}})
+	x, err := OpenPdfIndex(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer x.Close()
+
+	for _, opts := range []SearchOptions{
+		{From: 1 << 62, MaxResults: 10, Within: 20},
+		{From: 1 << 62, MaxResults: 10},
+		{From: 9995, MaxResults: 10},
+		{MaxResults: 1 << 62},
+		{MaxResults: 10, Cursor: encodeCursor("pump filter", 1<<62)},
+	} {
+		if _, err := x.Search("pump filter", opts); err != ErrResultWindow {
+			t.Errorf("From=%d MaxResults=%d err=%v expected=%v", opts.From, opts.MaxResults, err,
+				ErrResultWindow)
+		}
+	}
+	if _, err := x.Search("pump filter", SearchOptions{From: 9990, MaxResults: 10}); err != nil {
+		t.Errorf("err=%v", err)
+	}
+}
+
+// TestHasFieldScope checks that only the names of page document fields are field scopes.
+func TestHasFieldScope(t *testing.T) {
+	tests := map[string]bool{
+		"author:smith":                true,
+		"pump +title:manual":          true,
+		`created:>="2017-01-01"`:      true,
+		"-lang:fr":                    true,
+		"text_folded:resume":          true,
+		"num.m:>=0.002":               true,
+		"pump filter":                 false,
+		"https://example.com/docs":    false,
+		"note: details":               false,
+		"Author:smith":                false,
+		"see section 4.2 ratio 16:9":  false,
+		"the pump (model: X-20) fits": false,
+	}
+	for term, expected := range tests {
+		if got := hasFieldScope(term); got != expected {
+			t.Errorf("hasFieldScope(%q)=%t expected=%t", term, got, expected)
+		}
+	}
+}
+
+// TestColonQueries checks that queries with words followed by colons that aren't field names,
+// such as URLs and labels, match the page text.
+func TestColonQueries(t *testing.T) {
+	indexMapping, err := newIndexMapping(StopwordConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	index, err := bleve.NewMemOnly(indexMapping)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer index.Close()
+	doc := IDText{
+		ID:    "0.1",
+		Text:  "The manual is at https://example.com/docs. note: details are in section 2.",
+		Title: "manual",
+	}
+	if err := index.Index(doc.ID, doc); err != nil {
+		t.Fatal(err)
+	}
+	for _, term := range []string{"https://example.com/docs", "note: details", "title:manual"} {
+		results, err := index.Search(bleve.NewSearchRequest(makeQuery(term)))
+		if err != nil {
+			t.Fatalf("term=%q err=%v", term, err)
+		}
+		if results.Total != 1 {
+			t.Errorf("term=%q matches=%d expected=1", term, results.Total)
+		}
+	}
+}
@@ -1,6 +1,8 @@
 package doclib
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -8,7 +10,9 @@ import (
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"runtime"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/blevesearch/bleve"
@@ -21,12 +25,160 @@ var ErrRange = errors.New("out of range")
 
 // FileDesc describes a PDF file.
 type FileDesc struct {
-	InPath string  // Full path to PDF file.
-	Hash   string  // SHA-256 hash of file contents.
-	SizeMB float64 // Size of PDF file on disk.
+	InPath   string      // Full path to PDF file.
+	Hash     string      // SHA-256 hash of file contents.
+	SizeMB   float64     // Size of PDF file on disk.
+	Metadata DocMetadata // Title, author etc of the PDF.
+	Indexed  time.Time   // When the PDF was added to the store.
+	// Modified is the modification time of the PDF file. It is zero for PDFs that weren't read
+	// from files and PDFs that were indexed before modification times were recorded.
+	Modified time.Time
+	// Aliases are the other paths of files with the same contents as InPath that were indexed.
+	// They are not indexed again.
+	Aliases []string `json:",omitempty"`
+	// Extractors are the extractors that produced the text of the PDF. It is empty for PDFs that
+	// were indexed before extractors were recorded.
+	Extractors []Extractor `json:",omitempty"`
+	// Cold is true if the document's positions data has been moved to cold storage. See
+	// Store.FreezeDocs.
+	Cold bool `json:",omitempty"`
+	// Outline is the PDF's bookmarks in outline order. It is empty for PDFs without bookmarks
+	// and PDFs that were indexed before outlines were recorded.
+	Outline []Bookmark `json:",omitempty"`
+	// FormFields are the filled in fields of the PDF's form. It is empty for PDFs without forms
+	// and PDFs that were indexed before form fields were recorded.
+	FormFields []FormField `json:",omitempty"`
+	// Fingerprints are the simhashes of the texts of the PDF's pages. Fingerprints[i] is the
+	// fingerprint of the page with index i. They are empty for PDFs that were indexed before
+	// fingerprints were recorded. See pageFingerprint.
+	Fingerprints []uint64 `json:",omitempty"`
+	// DocLabels are the URI, display title and tags that were supplied for the document when it
+	// was indexed. See IndexOptions.Labels.
+	DocLabels
 }
 
-// IndexPdfFiles creates a bleve+PositionsState index for `pathList`.
+// IndexOptions controls how IndexPdfFilesOpts and IndexPdfReadersOpts build an index.
+type IndexOptions struct {
+	// NumWorkers is the number of goroutines that extract text and text locations from PDFs.
+	// Extraction is serial if NumWorkers <= 1. Writes to the Store and bleve index are
+	// always serialized.
+	NumWorkers int
+	// MemoryBudgetMB, if > 0, is the memory in MB that concurrent extraction workers may use. The
+	// memory used by a PDF is estimated from its file size and PDFs wait to be extracted until
+	// their estimates fit in the budget with those of the PDFs that are already in memory. It
+	// bounds memory use when big PDFs are extracted concurrently.
+	MemoryBudgetMB float64
+	// MaxBufferMB, if > 0, is the most memory in MB used by the extracted text and text locations
+	// of a PDF. Its pages are buffered until it is added to the store, so the pages after the
+	// buffer is full are skipped.
+	MaxBufferMB float64
+	// Priorities are the scheduling priorities of the PDF files that match their patterns. Files
+	// with higher priorities are extracted first. The first match is used.
+	Priorities []FilePriority
+	// SmallFirst extracts smaller PDF files before bigger ones of the same priority so that most
+	// files are indexed early in a run whose corpus has a few huge files.
+	SmallFirst bool
+	// MaxRetries is the number of times the extraction of a PDF that failed is retried, e.g.
+	// after a timeout or a network error reading a CorpusSource. PDFs that crash the PDF library
+	// aren't retried.
+	MaxRetries int
+	// RetryBackoff is the wait before the first retry of a PDF. It doubles with each retry. The
+	// default is 1 second.
+	RetryBackoff time.Duration
+	// Scheduler, if not nil, schedules the extraction of the PDFs so that callers can inspect and
+	// steer it while the PDFs are indexed. See ExtractScheduler.
+	Scheduler *ExtractScheduler
+	// TextExtractor, if not nil, extracts the text of the PDF pages instead of UniDoc.
+	TextExtractor TextExtractor
+	// FileExtractors are the TextExtractors for the PDF files that match their patterns. The first
+	// match is used. Files that match none of them use TextExtractor.
+	FileExtractors []FileExtractor
+	// OCR, if not nil, is used to recognize the text on pages that have no extractable text, such
+	// as the pages of scanned documents.
+	OCR PageOCR
+	// Normalization is the TextNormalization of a new store. Existing stores keep the
+	// normalization they were created with.
+	Normalization TextNormalization
+	// RawTextOrder keeps page text in the order the extractor returns it instead of rearranging
+	// the columns of multi-column pages into reading order. It is for debugging extraction.
+	RawTextOrder bool
+	// Isolate extracts each PDF in a child process, an extraction worker, so that a PDF that kills
+	// the PDF library with a fatal error doesn't stop the indexing run. The worker is the program
+	// itself run with the single argument ExtractWorkerCommand, so programs that set Isolate must
+	// call RunExtractWorker when they are run that way. The extractors in the options must be
+	// registered with RegisterTextExtractor so that the worker can create them.
+	Isolate bool
+	// FileTimeout, if > 0, is the longest time spent extracting a PDF. PDFs that take longer are
+	// reported as failed. Extraction in the indexing process is stopped between pages, so a PDF
+	// that hangs on a page is only stopped if Isolate is set, which kills the extraction worker.
+	FileTimeout time.Duration
+	// BatchSize is the maximum number of pages that are added to the bleve index in a batch.
+	BatchSize int
+	// Resume resumes indexing into a persistent store after an indexing run that didn't complete.
+	// The documents that were completely indexed are kept and the partially indexed document is
+	// indexed again. See indexJournal.
+	Resume bool
+	// Report, if not nil, is filled in with the status of each PDF file that is indexed.
+	Report *IndexReport
+	// PageRanges, if not empty, are the ranges of pages that are indexed in each PDF.
+	PageRanges PageRanges
+	// MaxFileMB, if > 0, is the size in MB of the largest PDF file that is indexed.
+	MaxFileMB float64
+	// Exclude is a list of glob patterns of PDF files that are not indexed. A pattern excludes a
+	// file if it matches the file's path or base name. See filepath.Match.
+	Exclude []string
+	// Shards, if > 1, is the number of shards the bleve index of a new persistent store is split
+	// into. Documents are assigned to shards by their hashes and the shards are written in
+	// parallel. Searches of the store search all the shards. It is ignored when appending to an
+	// existing store, which keeps the number of shards it was created with.
+	Shards int
+	// MinTextQuality, if > 0, is the TextQuality below which the text extracted from a page is
+	// treated as garbage, such as text from a font with a broken CMap. Such pages are recognized
+	// with OCR if it is set and the recognized text is used if it has a higher TextQuality.
+	MinTextQuality float64
+	// Progress, if not nil, is called after each PDF file is processed.
+	Progress ProgressReporter
+	// Duplicates is what is done with files whose contents are already in the store. The default
+	// is DuplicateAddAlias.
+	Duplicates DuplicatePolicy
+	// Source, if not nil, is the CorpusSource that documents are read from when no reader is given
+	// for them. See IndexCorpus.
+	Source CorpusSource
+	// Labels, if not nil, are the DocLabels of the documents that are indexed, keyed by their
+	// paths. They are stored in the documents' FileDescs and returned in search results.
+	Labels LabelTable
+	// Tags are tags, e.g. "department=legal", that are added to the tags of every document that
+	// is indexed. Searches can be filtered by tags and count the pages with each tag. See
+	// SearchOptions.Tags.
+	Tags []string
+	// Synonyms, if not nil, is the SynonymDict of a new store. It is saved in the store and
+	// searches of the store also match the synonyms of the words in their queries. Existing stores
+	// keep their synonyms, which are in the synonyms.txt file in the store directory.
+	Synonyms *SynonymDict
+	// Stopwords are the stop words of a new store, which aren't indexed. Existing stores keep the
+	// stop words they were created with. See StopwordConfig.
+	Stopwords StopwordConfig
+
+	skipHashes map[string]bool // Hashes of documents that are already in the store.
+	filter     *CorpusFilter   // Known bad PDFs of the store. nil for in-memory stores.
+	queue      *fileQueue      // Queue of the files to index. nil for in-memory stores.
+}
+
+// defaultBatchSize is the default IndexOptions.BatchSize.
+const defaultBatchSize = 100
+
+// DefaultIndexOptions returns the IndexOptions used by IndexPdfFiles and IndexPdfReaders.
+// It uses a number of extraction workers that won't overload the host computer.
+func DefaultIndexOptions() IndexOptions {
+	numWorkers := runtime.NumCPU() - 1
+	if numWorkers <= 0 {
+		numWorkers = 1
+	}
+	return IndexOptions{NumWorkers: numWorkers, BatchSize: defaultBatchSize,
+		Normalization: DefaultNormalization}
+}
+
+// IndexPdfFiles creates a Store and bleve index for `pathList`.
 // If `persistDir` is not empty, the index is written to this directory.
 // If `forceCreate` is true and `persistDir` is not empty, a new directory is always created.
 // If `allowAppend` is true and `persistDir` is not empty and a bleve index already exists on disk
@@ -35,124 +187,710 @@ type FileDesc struct {
 // TODO: Remove `allowAppend` argument. Instead always append to a bleve index if it exists and
 //      `forceCreate` is not set.
 func IndexPdfFiles(pathList []string, persistDir string, forceCreate, allowAppend bool,
-	report func(string)) (*PositionsState, bleve.Index, int, error) {
+	report func(string)) (*Store, bleve.Index, int, error) {
+	return IndexPdfFilesOpts(pathList, persistDir, forceCreate, allowAppend, DefaultIndexOptions(),
+		report)
+}
+
+// IndexPdfFilesOpts is IndexPdfFiles with the indexing options `opts`.
+func IndexPdfFilesOpts(pathList []string, persistDir string, forceCreate, allowAppend bool,
+	opts IndexOptions, report func(string)) (*Store, bleve.Index, int, error) {
+	return IndexPdfFilesContext(context.Background(), pathList, persistDir, forceCreate,
+		allowAppend, opts, report)
+}
+
+// IndexPdfFilesContext is IndexPdfFilesOpts with a context. See IndexPdfReadersContext.
+// Files that can't be opened are reported as FileFailed in opts.Report and the other files are
+// indexed.
+func IndexPdfFilesContext(ctx context.Context, pathList []string, persistDir string, forceCreate,
+	allowAppend bool, opts IndexOptions, report func(string)) (*Store, bleve.Index, int,
+	error) {
 
-	var rsList []io.ReadSeeker
-	for _, inPath := range pathList {
+	// Files that can't be opened here get nil readers so their extraction opens them again and
+	// fails with the error.
+	rsList := make([]io.ReadSeeker, len(pathList))
+	for i, inPath := range pathList {
 		rs, err := os.Open(inPath)
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "Opened %d files\n", len(rsList))
-			break
-			return nil, nil, 0, err
+			common.Log.Error("IndexPdfFilesOpts: Could not open %q. err=%v", inPath, err)
+			continue
 		}
 		defer rs.Close()
-		rsList = append(rsList, rs)
+		rsList[i] = rs
 	}
-	return IndexPdfReaders(pathList, rsList, persistDir, forceCreate, allowAppend, report)
+	return IndexPdfReadersContext(ctx, pathList, rsList, persistDir, forceCreate, allowAppend,
+		opts, report)
 }
 
-// IndexPdfReaders returns a PositionsState and a bleve.Index over the PDF contents read by the
+// IndexPdfReaders returns a Store and a bleve.Index over the PDF contents read by the
 // io.ReaderSeeker's in `rsList`.
 // The names of the PDFs are in the corresponding position in `pathList`.
 // The inde`persistDir
 // If `persist` is false, the index is stored in memory.
 // If `persist` is true, the index is stored on disk in `persistDir`.
 // `report` is a supplied function that is called to report progress.
+// Use IndexPdfStreams for readers that can't seek, such as HTTP response bodies.
 func IndexPdfReaders(pathList []string, rsList []io.ReadSeeker, persistDir string, forceCreate,
-	allowAppend bool, report func(string)) (*PositionsState, bleve.Index, int, error) {
+	allowAppend bool, report func(string)) (*Store, bleve.Index, int, error) {
+	return IndexPdfReadersOpts(pathList, rsList, persistDir, forceCreate, allowAppend,
+		DefaultIndexOptions(), report)
+}
+
+// IndexPdfReadersOpts is IndexPdfReaders with the indexing options `opts`.
+// If opts.NumWorkers > 1 then the PDFs are extracted concurrently. The extracted documents are
+// added to the Store and bleve index in the order their extraction finishes, so the
+// document indexes may differ from those of serial extraction. See ExtractScheduler.
+// New persistent stores save `opts` in their StoreConfig. The unset fields of `opts` are taken from
+// the StoreConfig of existing stores.
+func IndexPdfReadersOpts(pathList []string, rsList []io.ReadSeeker, persistDir string, forceCreate,
+	allowAppend bool, opts IndexOptions, report func(string)) (*Store, bleve.Index, int, error) {
+	return IndexPdfReadersContext(context.Background(), pathList, rsList, persistDir, forceCreate,
+		allowAppend, opts, report)
+}
 
-	common.Log.Info("Indexing %d PDF files.", len(pathList))
+// IndexPdfReadersContext is IndexPdfReadersOpts with a context. Indexing stops with `ctx`'s error
+// if `ctx` is cancelled or times out. The documents that were completely indexed are kept and the
+// store is saved, so a cancelled run of a big corpus can be continued by indexing the corpus into
+// the store again. Documents whose extraction was interrupted aren't indexed.
+func IndexPdfReadersContext(ctx context.Context, pathList []string, rsList []io.ReadSeeker,
+	persistDir string, forceCreate, allowAppend bool, opts IndexOptions, report func(string)) (
+	*Store, bleve.Index, int, error) {
 
-	lState, err := OpenPositionsState(persistDir, forceCreate)
+	common.Log.Info("Indexing %d PDF files. %d workers", len(pathList), opts.NumWorkers)
+
+	if opts.Resume {
+		if forceCreate || persistDir == "" {
+			return nil, nil, 0, errors.New("resume needs an existing persistent store")
+		}
+		allowAppend = true
+	}
+
+	// Lock the store before opening it so that a store that another process is writing to isn't
+	// removed or opened for writing.
+	var lock *StoreLock
+	if persistDir != "" {
+		var err error
+		if lock, err = LockStore(persistDir); err != nil {
+			return nil, nil, 0, err
+		}
+	}
+	lState, err := openPositionsState(persistDir, forceCreate, lock)
 	if err != nil {
+		lock.Unlock()
 		return nil, nil, 0, fmt.Errorf("Could not create positions store %q. err=%v", persistDir, err)
 	}
+	defer lState.unlockWriter()
 	defer lState.Flush()
 
 	var index bleve.Index
 	if len(persistDir) == 0 {
-		index, err = CreateBleveMemIndex()
+		index, err = createBleveMemIndex(opts.Stopwords)
 		if err != nil {
 			return nil, nil, 0, fmt.Errorf("Could not create Bleve memoryindex. err=%v", err)
 		}
+		lState.normalization = opts.Normalization
+		lState.synonyms = opts.Synonyms
+		lState.stopwords = opts.Stopwords
 	} else {
 		indexPath := filepath.Join(persistDir, "bleve")
 		common.Log.Info("indexPath=%q", indexPath)
+		var manifest storeManifest
+		if !forceCreate {
+			if err := checkManifest(persistDir); err != nil {
+				return nil, nil, 0, mappingError(persistDir, err)
+			}
+			if manifest, err = loadManifest(persistDir); err != nil {
+				return nil, nil, 0, err
+			}
+		}
+		created := forceCreate || !Exists(indexPath)
+		if created {
+			manifest.Shards = opts.Shards
+		} else if opts.Shards > 1 && opts.Shards != manifest.Shards {
+			common.Log.Error("%q has %d shards. Ignoring Shards=%d", persistDir, manifest.Shards,
+				opts.Shards)
+		}
+		// New stores record the options they were created with. Existing stores supply the
+		// options that weren't set, including the stop words of their index mapping.
+		if !created {
+			config, err := LoadStoreConfig(persistDir)
+			if err != nil {
+				return nil, nil, 0, err
+			}
+			opts = config.fillOptions(opts)
+		}
 		// Create a new Bleve index.
-		index, err = CreateBleveIndex(indexPath, forceCreate, allowAppend)
+		index, err = createStoreIndex(indexPath, manifest.Shards, opts.Stopwords, forceCreate,
+			allowAppend)
+		if err == ErrMappingMismatch {
+			return nil, nil, 0, mappingError(persistDir, err)
+		}
 		if err != nil {
-			return nil, nil, 0, fmt.Errorf("Could not create Bleve index in %q", indexPath)
+			return nil, nil, 0, fmt.Errorf("Could not create Bleve index in %q. err=%v",
+				indexPath, err)
+		}
+		if manifest.MappingHash, err = currentMappingHash(opts.Stopwords); err != nil {
+			return nil, nil, 0, err
+		}
+		if err := saveManifest(persistDir, manifest); err != nil {
+			return nil, nil, 0, err
+		}
+		if created {
+			err = SaveStoreConfig(persistDir, configFromOptions(opts))
+			lState.normalization = opts.Normalization
+			lState.stopwords = opts.Stopwords
+			if err == nil && opts.Synonyms != nil {
+				err = opts.Synonyms.save(persistDir)
+				lState.synonyms = opts.Synonyms
+			}
+		}
+		if err != nil {
+			return nil, nil, 0, err
 		}
+
+		if opts.Resume {
+			if err := lState.replayJournal(index); err != nil {
+				return nil, nil, 0, fmt.Errorf("Could not resume indexing %q. err=%v",
+					persistDir, err)
+			}
+		} else if Exists(journalPath(persistDir)) && !forceCreate {
+			common.Log.Error("%q has an incomplete indexing journal. Resume indexing to repair it.",
+				persistDir)
+		}
+
+		// Index the files that earlier runs didn't get to as well as `pathList`.
+		queue, queued, err := openFileQueue(persistDir)
+		if err != nil {
+			return nil, nil, 0, err
+		}
+		defer func() {
+			if err := queue.close(); err != nil {
+				common.Log.Error("Could not close file queue %q. err=%v", persistDir, err)
+			}
+		}()
+		var queueable []string
+		pathList, rsList, queueable = addQueuedFiles(pathList, rsList, queued)
+		if err := queue.add(queueable); err != nil {
+			return nil, nil, 0, fmt.Errorf("Could not queue files in %q. err=%v", persistDir, err)
+		}
+		opts.queue = queue
 	}
 
-	totalPages := 0
-	// Add the pages of all the PDFs in `pathList` to `index`.
-	for i, inPath := range pathList {
-		readerOnly := ""
+	totalPages, err := lState.IndexReadersContext(ctx, index, pathList, rsList, opts, report)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	return lState, index, totalPages, nil
+}
+
+// IndexReaders adds the PDFs read by the io.ReadSeekers in `rsList`, whose names are in the
+// corresponding positions in `pathList`, to `lState` and `index`. If `rsList` is empty the PDFs
+// are read from the files in `pathList`. It returns the page count that IndexPdfReadersOpts
+// returns.
+// Unlike IndexPdfReadersOpts, it may be called from multiple goroutines at once for the same
+// `lState` and `index`. The PDFs are extracted concurrently and added to the store one document at
+// a time, so the documents of concurrent calls are interleaved in the store's document order.
+// The store is saved and its indexing journal is closed when the last concurrent call returns.
+func (lState *Store) IndexReaders(index bleve.Index, pathList []string,
+	rsList []io.ReadSeeker, opts IndexOptions, report func(string)) (int, error) {
+	return lState.IndexReadersContext(context.Background(), index, pathList, rsList, opts, report)
+}
+
+// IndexReadersContext is IndexReaders with a context. It stops with `ctx`'s error if `ctx` is
+// cancelled or times out. The documents that were completely indexed are kept.
+func (lState *Store) IndexReadersContext(ctx context.Context, index bleve.Index,
+	pathList []string, rsList []io.ReadSeeker, opts IndexOptions, report func(string)) (int,
+	error) {
+
+	t0 := time.Now()
+	if opts.Isolate {
+		if err := opts.checkIsolation(); err != nil {
+			return 0, err
+		}
+	}
+	if err := lState.lockWriter(); err != nil {
+		return 0, err
+	}
+	defer lState.unlockWriter()
+	if err := lState.startWriter(); err != nil {
+		return 0, err
+	}
+
+	// Pages are normalized the way the store's pages and queries are.
+	opts.Normalization = lState.normalization
+	opts.filter = lState.filter
+	// Don't extract documents that are already in the store.
+	opts.skipHashes = map[string]bool{}
+	lState.mu.Lock()
+	for hash := range lState.hashIndex {
+		opts.skipHashes[hash] = true
+	}
+	lState.mu.Unlock()
+
+	readerOnly := ""
+	if len(rsList) > 0 {
+		readerOnly = " (readerOnly)"
+	}
+	getReader := func(i int) io.ReadSeeker {
 		if len(rsList) > 0 {
-			readerOnly = " (readerOnly)"
+			return rsList[i]
 		}
+		return nil
+	}
+
+	totalPages := 0
+	filesDone := 0
+	var build BuildStats // The documents and pages added by this run.
+	// processDoc adds the extracted text and locations of pathList[i] to `lState` and `index`.
+	processDoc := func(i int, ext docExtraction) error {
+		inPath := pathList[i]
+		filesDone++
 		if report != nil {
-			report(fmt.Sprintf("%3d of %d: %q%s", i+1, len(pathList), inPath, readerOnly))
+			report(fmt.Sprintf("%3d of %d: %q%s", filesDone, len(pathList), inPath, readerOnly))
 		}
-		var err error
-		if len(rsList) > 0 {
-			rs := rsList[i]
-			err = indexDocPagesLocReader(index, lState, inPath, rs)
-		} else {
-			err = indexDocPagesLocFile(index, lState, inPath)
+		lState.mu.Lock()
+		fileReport, err := indexDocExtraction(index, lState, ext, opts)
+		if opts.Report != nil {
+			opts.Report.add(fileReport)
+		}
+		lState.mu.Unlock()
+		if fileReport.Status == FileIndexed {
+			build.NumDocs++
 		}
+		build.NumPages += fileReport.IndexedPages
 		if err != nil {
-			return nil, nil, 0, fmt.Errorf("Could not index file %q", inPath)
+			return fmt.Errorf("Could not index file %q", inPath)
+		}
+		if err := opts.queue.done(inPath); err != nil {
+			return err
 		}
 		docCount, err := index.DocCount()
 		if err != nil {
-			return nil, nil, 0, err
+			return err
 		}
 		common.Log.Debug("Indexed %q. Total %d pages indexed.", inPath, docCount)
 		totalPages += int(docCount)
+		if opts.Progress != nil {
+			opts.Progress.Progress(makeProgress(inPath, filesDone, len(pathList), build.NumPages,
+				t0))
+		}
+		return nil
 	}
 
-	return lState, index, totalPages, err
+	// Add the pages of all the PDFs in `pathList` to `index`.
+	var err error
+	if opts.NumWorkers > 1 && len(pathList) > 1 || opts.scheduled() {
+		err = extractDocsConcurrent(ctx, pathList, getReader, opts, processDoc)
+	} else {
+		for i, inPath := range pathList {
+			if err = ctx.Err(); err != nil {
+				break
+			}
+			if err = processDoc(i, extractDoc(ctx, inPath, getReader(i), opts)); err != nil {
+				break
+			}
+		}
+	}
+	if opts.Report != nil {
+		opts.Report.Duration = time.Since(t0)
+	}
+	build.Finished = time.Now()
+	build.Duration = build.Finished.Sub(t0)
+	// Documents are indexed completely or not at all so the store can be saved after a
+	// cancellation.
+	if err2 := lState.endWriter(build, err == nil || err == ctx.Err()); err == nil {
+		err = err2
+	}
+	return totalPages, err
 }
 
-type IDText struct {
-	ID   string
-	Text string
+// startWriter registers a call of IndexReaders on `lState`. The first of a set of concurrent
+// calls opens the indexing journal and loads the known bad PDFs of a persistent store.
+func (lState *Store) startWriter() error {
+	lState.mu.Lock()
+	defer lState.mu.Unlock()
+	lState.writers++
+	if lState.writers > 1 || lState.isMem() {
+		return nil
+	}
+	filter, err := LoadCorpusFilter(lState.root)
+	if err != nil {
+		lState.writers--
+		return err
+	}
+	journal, err := openJournal(lState.root)
+	if err != nil {
+		lState.writers--
+		return err
+	}
+	lState.journal = journal
+	lState.filter = filter
+	lState.writeFailed = false
+	return nil
 }
 
-// indexDocPagesLocFile adds the text of all the pages in PDF file `inPath` to Bleve index `index`.
-func indexDocPagesLocFile(index bleve.Index, lState *PositionsState, inPath string) error {
-	rs, err := os.Open(inPath)
+// endWriter unregisters a call of IndexReaders on `lState` that added the documents in `build`.
+// `ok` is false if the call failed. The last of a set of concurrent calls saves `lState` and
+// closes its indexing journal. The journal is kept for resuming if any of the calls failed.
+func (lState *Store) endWriter(build BuildStats, ok bool) error {
+	lState.mu.Lock()
+	defer lState.mu.Unlock()
+	lState.writers--
+	lState.writeFailed = lState.writeFailed || !ok
+	lState.indexDuration += build.Duration
+	if lState.writers > 0 {
+		return nil
+	}
+	if err := lState.filter.clearSuspects(); err != nil {
+		common.Log.Error("endWriter: Couldn't clear the suspect PDFs of %q. err=%v", lState.root,
+			err)
+	}
+	lState.filter = nil
+	if lState.writeFailed {
+		lState.journal.close(false)
+		lState.journal = nil
+		return nil
+	}
+	if err := lState.Flush(); err != nil {
+		lState.journal.close(false)
+		lState.journal = nil
+		return err
+	}
+	err := lState.journal.close(true)
+	lState.journal = nil
 	if err != nil {
 		return err
 	}
-	defer rs.Close()
-	return indexDocPagesLocReader(index, lState, inPath, rs)
+	if err := lState.updateManifestFeatures(); err != nil {
+		return err
+	}
+	if err := lState.recordBuild(build); err != nil {
+		return err
+	}
+	lState.bumpGeneration()
+	return nil
+}
+
+// docExtraction is the text and text locations extracted from a PDF file.
+// docExtractions are created by extraction workers that don't touch the Store so they can
+// run concurrently. They are written to the Store and bleve index by a single goroutine.
+type docExtraction struct {
+	inPath string           // Path of PDF file.
+	fd     FileDesc         // Description of PDF file.
+	pages  []pageExtraction // Extracted pages.
+	err    error            // Error from extraction, if any.
+	exists bool             // The document is already in the store so it wasn't extracted.
+	// skipped is the reason the document was excluded from indexing by the IndexOptions filters
+	// or "" if it wasn't.
+	skipped string
+	// numPages is the number of pages that were processed. Pages with no text and pages whose
+	// text couldn't be extracted are not in `pages`.
+	numPages int
+	pageErrs []string      // Errors from extracting pages that were skipped.
+	duration time.Duration // Time taken to extract the document.
+}
+
+// pageExtraction is the text and text locations extracted from a PDF page.
+type pageExtraction struct {
+	pageNum uint32                  // Page number in PDF file (1-offset).
+	text    string                  // Extracted page text.
+	dpl     serial.DocPageLocations // Locations of the text in `text`.
+	box     PageBox                 // Crop box and rotation of the page.
+	quality float64                 // TextQuality of `text`.
+	annots  []string                // Contents of the page's annotations.
+	tables  []Table                 // Tables on the page.
+}
+
+// extractDocsConcurrent extracts the text and text locations from the PDFs in `pathList` with
+// opts.NumWorkers goroutines. `getReader`(i) returns the reader for pathList[i] or nil if the file
+// should be opened by the worker.
+// The PDFs are extracted in the order chosen by opts.Scheduler, or an ExtractScheduler with `opts`
+// if it is nil, and failed extractions are retried as it directs.
+// `process` is called on the calling goroutine with each docExtraction in the order extraction
+// finishes, so the caller doesn't need to synchronize writes to its Store and bleve
+// index. If opts.MemoryBudgetMB is set, documents are only extracted when their estimated memory
+// use fits in the budget with the documents that are being extracted or are waiting for
+// `process`.
+func extractDocsConcurrent(ctx context.Context, pathList []string,
+	getReader func(i int) io.ReadSeeker, opts IndexOptions,
+	process func(i int, ext docExtraction) error) error {
+
+	numWorkers := opts.NumWorkers
+	if numWorkers < 1 {
+		numWorkers = 1
+	}
+	type result struct {
+		i    int
+		cost int64 // Estimated memory used by the document. See memoryBudget.
+		ext  docExtraction
+	}
+	results := make(chan result, numWorkers)
+	done := make(chan struct{})
+	defer close(done)
+	budget := newMemoryBudget(opts.MemoryBudgetMB)
+	sched := opts.Scheduler
+	if sched == nil {
+		sched = NewExtractScheduler()
+	}
+	sched.start(pathList, getReader, opts)
+	// workCtx stops the workers waiting for jobs and memory when this function returns.
+	workCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	for w := 0; w < numWorkers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				j, err := sched.next(workCtx)
+				if err != nil || j == nil {
+					return
+				}
+				if err := budget.acquire(workCtx, j.cost); err != nil {
+					return
+				}
+				ext := extractDoc(ctx, pathList[j.i], getReader(j.i), opts)
+				if ctx.Err() == nil && sched.finish(j, ext, opts) {
+					common.Log.Info("extractDocsConcurrent: Retrying %q. attempts=%d err=%v",
+						j.InPath, j.Attempts, ext.err)
+					budget.release(j.cost)
+					continue
+				}
+				select {
+				case results <- result{j.i, j.cost, ext}:
+				case <-done:
+					return
+				}
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	for r := range results {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := process(r.i, r.ext); err != nil {
+			return err
+		}
+		budget.release(r.cost)
+	}
+	return ctx.Err()
+}
+
+// scheduled returns true if `opts` asks for extraction to be scheduled by an ExtractScheduler,
+// which is always done when extraction is concurrent.
+func (opts IndexOptions) scheduled() bool {
+	return opts.Scheduler != nil || len(opts.Priorities) > 0 || opts.SmallFirst ||
+		opts.MaxRetries > 0
+}
+
+// IDText is the bleve document for a PDF page.
+// The metadata fields are lower case so they can be used in field-scoped queries such as
+// `author:smith` and `created:>="2017-01-01"`.
+type IDText struct {
+	ID       string
+	Text     string
+	Repeats  int        `json:"repeats"` // Number of pages in the PDF with this text.
+	Title    string     `json:"title"`
+	Author   string     `json:"author"`
+	Subject  string     `json:"subject"`
+	Keywords string     `json:"keywords"`
+	Created  *time.Time `json:"created"` // nil if the PDF has no creation date.
+	// Modified is the modification time of the PDF file. nil if it isn't known.
+	Modified *time.Time `json:"modified"`
+	// Extractor is the names and versions of the extractors of the PDF. See extractorTerms().
+	Extractor []string `json:"extractor"`
+	// Lang is the ISO 639-1 code of the page's dominant language or "" if it is unknown. See
+	// SetLanguageDetector.
+	Lang string `json:"lang"`
+	// Tag is the tags of the PDF, e.g. "department=legal". See DocLabels.Tags.
+	Tag []string `json:"tag"`
+	// FormFields are the values of the PDF's form fields. See FileDesc.FormFields.
+	FormFields []string
+	// Annots are the contents of the annotations on the page. See PageAnnotations.
+	Annots []string
+	// File, Page and Size are the path, page number and size in MB of the page. They are for
+	// sorting matches. See SearchOptions.Sort.
+	File string  `json:"file"`
+	Page uint32  `json:"page"`
+	Size float64 `json:"size"`
+	// Num is the values of the numbers and quantities in the page text in the base units of their
+	// dimensions, {dimension: values}. See pageQuantities.
+	Num map[string][]float64 `json:"num"`
+}
+
+// Type returns the page's language. It selects the bleve document mapping of the page. See
+// pageMapping().
+func (d IDText) Type() string {
+	return d.Lang
+}
+
+// pageDocument returns the bleve document for page number `pageNum`, which has bleve ID `id` and
+// text `text`, in the PDF described by `fd`. `repeats` is the number of pages in the PDF with text
+// `text` and `annots` are the contents of the annotations on those pages.
+// All bleve page documents should be created by this function.
+func pageDocument(id string, fd FileDesc, pageNum uint32, text string, repeats int,
+	annots []string) IDText {
+
+	meta := fd.Metadata
+	doc := IDText{
+		ID:         id,
+		Text:       text,
+		Repeats:    repeats,
+		Title:      meta.Title,
+		Author:     meta.Author,
+		Subject:    meta.Subject,
+		Keywords:   meta.Keywords,
+		Extractor:  extractorTerms(fd.Extractors),
+		FormFields: formFieldValues(fd.FormFields),
+		Annots:     annots,
+		Lang:       languageDetector.DetectLanguage(text),
+		Tag:        fd.Tags,
+		File:       fd.InPath,
+		Page:       pageNum,
+		Size:       fd.SizeMB,
+		Num:        pageQuantities(text),
+	}
+	if !meta.CreationDate.IsZero() {
+		created := meta.CreationDate
+		doc.Created = &created
+	}
+	if !fd.Modified.IsZero() {
+		modified := fd.Modified
+		doc.Modified = &modified
+	}
+	return doc
+}
+
+// extractDoc extracts the text and text locations from the PDF file `inPath` which is read from
+// `rs`. If `rs` is nil then `inPath` is opened in opts.Source or, if it isn't set, the file system.
+// It does not modify any shared state so it may be called concurrently.
+func extractDoc(ctx context.Context, inPath string, rs io.ReadSeeker,
+	opts IndexOptions) docExtraction {
+
+	if rs == nil && opts.Source != nil {
+		return extractSourceDoc(ctx, inPath, opts)
+	}
+	if rs == nil {
+		f, err := os.Open(inPath)
+		if err != nil {
+			return docExtraction{inPath: inPath, err: err}
+		}
+		defer f.Close()
+		rs = f
+	}
+	return extractDocPagePositions(ctx, inPath, rs, opts)
+}
+
+// indexDocPagesLocFile adds the text of all the pages in PDF file `inPath` to Bleve index `index`.
+func indexDocPagesLocFile(index bleve.Index, lState *Store, inPath string) error {
+	opts := DefaultIndexOptions()
+	_, err := indexDocExtraction(index, lState, extractDoc(context.Background(), inPath, nil, opts),
+		opts)
+	return err
 }
 
 // indexDocPagesLocReader updates `index` and `lState` with the text positions of the text in the
 // PDF file accessed by `rs`. `inPath` is the name of the PDF file.
-func indexDocPagesLocReader(index bleve.Index, lState *PositionsState,
+func indexDocPagesLocReader(index bleve.Index, lState *Store,
 	inPath string, rs io.ReadSeeker) error {
+	opts := DefaultIndexOptions()
+	ext := extractDoc(context.Background(), inPath, rs, opts)
+	lState.mu.Lock()
+	defer lState.mu.Unlock()
+	_, err := indexDocExtraction(index, lState, ext, opts)
+	return err
+}
+
+// indexDocExtraction updates `index` and `lState` with the text positions in `ext`.
+// The pages are added to `index` in batches of up to opts.BatchSize pages. Documents that are
+// already in `lState` are handled according to opts.Duplicates.
+// It returns a FileReport describing the indexing of the document. Documents that can't be
+// extracted or added to `lState` are reported as FileFailed. An error is only returned if `index`
+// or `lState`'s indexing journal can't be updated.
+// It must be called with lState.mu held.
+func indexDocExtraction(index bleve.Index, lState *Store, ext docExtraction,
+	opts IndexOptions) (FileReport, error) {
+	start := time.Now()
+	inPath := ext.inPath
+	rep := FileReport{
+		InPath:       inPath,
+		Hash:         ext.fd.Hash,
+		SizeMB:       ext.fd.SizeMB,
+		SkippedPages: ext.numPages - len(ext.pages),
+		Errors:       ext.pageErrs,
+	}
+	fail := func(err error) (FileReport, error) {
+		rep.Status = FileFailed
+		rep.Errors = append(rep.Errors, err.Error())
+		rep.Duration = ext.duration + time.Since(start)
+		return rep, nil
+	}
 
-	docPages, err := lState.ExtractDocPagePositionsReader(inPath, rs)
+	if ext.err != nil {
+		common.Log.Error("indexDocExtraction: Couldn't extract pages from %q err=%v", inPath, ext.err)
+		return fail(ext.err)
+	}
+	if ext.skipped != "" {
+		common.Log.Info("indexDocExtraction: Skipping %q. %s", inPath, ext.skipped)
+		rep.Status = FileSkipped
+		rep.Errors = []string{ext.skipped}
+		rep.Duration = ext.duration + time.Since(start)
+		return rep, nil
+	}
+	if docIdx, ok := lState.hashIndex[ext.fd.Hash]; ok {
+		common.Log.Info("indexDocExtraction: %q is already indexed. duplicates=%s", inPath,
+			opts.Duplicates)
+		switch opts.Duplicates {
+		case DuplicateError:
+			return fail(ErrDuplicate)
+		case DuplicateAddAlias:
+			lState.addAlias(docIdx, inPath)
+		}
+		rep.Status = FileDuplicate
+		rep.Duration = ext.duration + time.Since(start)
+		return rep, nil
+	}
+	ext.fd.DocLabels = opts.Labels[inPath]
+	ext.fd.Tags = mergeTags(ext.fd.Tags, opts.Tags)
+	docPages, err := lState.addDocPagePositions(ext.fd, ext.pages)
 	if err != nil {
-		common.Log.Error("indexDocPagesLocReader: Couldn't extract pages from %q err=%v", inPath, err)
-		return nil
+		common.Log.Error("indexDocExtraction: Couldn't add pages from %q err=%v", inPath, err)
+		return fail(err)
 	}
-	common.Log.Debug("indexDocPagesLocReader: inPath=%q docPages=%d", inPath, len(docPages))
+	common.Log.Debug("indexDocExtraction: inPath=%q docPages=%d", inPath, len(docPages))
+
+	texts := make([]string, len(docPages))
+	pageAnnots := make([][]string, len(docPages))
+	for i, l := range docPages {
+		texts[i] = l.Text
+		pageAnnots[i] = ext.pages[i].annots
+	}
+	repeats := pageRepeats(texts)
+	pageAnnots = mergeRepeatAnnotations(texts, pageAnnots)
 
 	t0 := time.Now()
+	b := newBatcher(index, opts.BatchSize)
 	for i, l := range docPages {
+		if repeats[i] == 0 {
+			// This page's text was indexed with an earlier page. The page is still in the
+			// positions store so it can be marked up.
+			continue
+		}
 		// Don't weigh down the Bleve index with the text bounding boxes.
-		id := fmt.Sprintf("%04X.%d", l.DocIdx, l.PageIdx)
-		idText := IDText{ID: id, Text: l.Text}
+		id := pageID(l.DocIdx, l.PageIdx)
+		idText := pageDocument(id, ext.fd, l.PageNum, l.Text, repeats[i], pageAnnots[i])
 
-		err = index.Index(id, idText)
+		err = b.indexDoc(id, ext.fd.Hash, idText)
 		dt := time.Since(t0)
 		if err != nil {
-			return err
+			fail(err)
+			return rep, err
 		}
 		if i%100 == 0 {
 			common.Log.Debug("\tIndexed %2d of %d pages in %5.1f sec (%.2f sec/page)",
@@ -160,19 +898,27 @@ func indexDocPagesLocReader(index bleve.Index, lState *PositionsState,
 			common.Log.Debug("\tid=%q text=%d", id, len(idText.Text))
 		}
 	}
+	if err := b.flush(); err != nil {
+		fail(err)
+		return rep, err
+	}
 	dt := time.Since(t0)
 	common.Log.Debug("\tIndexed %d pages in %.1f sec (%.3f sec/page)\n",
 		len(docPages), dt.Seconds(), dt.Seconds()/float64(len(docPages)))
-	return nil
+	rep.Status = FileIndexed
+	rep.IndexedPages = len(docPages)
+	rep.Duration = ext.duration + time.Since(start)
+	docIdx := lState.hashIndex[ext.fd.Hash]
+	return rep, lState.journal.done(docIdx, lState.fileList[docIdx])
 }
 
 /*
-   PositionsState is for serializing and accessing DocPageLocations.
+   Store is for serializing and accessing DocPageLocations.
 
    Positions are read from disk a page at a time by ReadPositions which returns the
    []DocPageLocations for the PDF page given by `doc` and `page`.
 
-   func (lState *PositionsState) ReadPositions(doc uint64, page uint32) ([]DocPageLocations, error)
+   func (lState *Store) ReadPositions(doc uint64, page uint32) ([]DocPageLocations, error)
 
    We use this to allow an efficient look up of DocPageLocation of an offset within a page's text.
    1) Look up []DocPageLocations for the PDF page given by `doc` and `page`
@@ -188,37 +934,81 @@ func indexDocPagesLocReader(index bleve.Index, lState *PositionsState,
          check  uint32
 
    <root>/
-      file_list.json
+      file_list.json  (See storeFileList in store_migrate.go)
+      text_refs.json
+      manifest.json  (See store_manifest.go)
+      bad_hashes.txt  (See corpus_filter.go)
+      suspects.txt  (See corpus_filter.go)
+      queue.jsonl  (See file_queue.go)
       positions/
           <hash1>.dat
           <hash1>.idx
-          <hash1>.pages
-              <page1>.txt
-              <page2>.txt
-              ...
           <hash2>.dat
           <hash2>.idx
-          <hash2>.pages
-              <page1>.txt
-              <page2>.txt
-              ...
           ...
+      texts/
+          <xx>/<page text hash>.txt  (See page_text_store.go)
+          ...
+
+   Stores created before page texts were content-addressed have a <hash>.pages directory of
+   <page>.txt files per document instead of texts/.
 */
 
 const storeUpdatePeriodSec = 60.0
 
-// PositionsState is the global state of a writer or reader to the position indexes saved to disk.
-type PositionsState struct {
-	root       string                   // Top level directory of the data saved to disk
-	fileList   []FileDesc               // List of file entries
-	hashIndex  map[string]uint64        // {file hash: index into fileList}
-	indexHash  map[uint64]string        // {index into fileList: file hash}
-	hashPath   map[string]string        // {file hash: file path}
-	hashDoc    map[string]*DocPositions // {file hash: DocPositions}
-	updateTime time.Time                // Time of last Flush()
+// Store is the global state of a writer or reader of the text positions of the PDFs in a
+// bleve+positions store. It is on disk or, for stores without a directory, in memory.
+type Store struct {
+	root       string            // Top level directory of the data saved to disk
+	fileList   []FileDesc        // List of file entries
+	hashIndex  map[string]uint64 // {file hash: index into fileList}
+	indexHash  map[uint64]string // {index into fileList: file hash}
+	hashPath   map[string]string // {file hash: file path}
+	hashDoc    map[string]*Doc   // {file hash: Doc}
+	updateTime time.Time         // Time of last Flush()
+	journal    *indexJournal     // Indexing journal. nil when not indexing.
+	filter     *CorpusFilter     // Known bad PDFs. nil when not indexing.
+	// indexDuration is the time spent indexing PDFs into `lState` by this process.
+	indexDuration time.Duration
+	// textRefs is {page text hash: number of pages with that text}. It is nil until it is needed.
+	// See loadTextRefs().
+	textRefs map[string]int
+	// docCache holds the memory mapped positions of recently read documents. It is nil for
+	// in-memory stores.
+	docCache *docCache
+	// cold is where the positions data of frozen documents is kept. It is nil if no cold storage
+	// has been set.
+	cold Storage
+	// flushPeriod is the longest time that documents are added without saving the file list. See
+	// StoreConfig.FlushPeriodSec.
+	flushPeriod time.Duration
+	// normalization is the TextNormalization of the pages in the store. Queries are normalized
+	// with it too. See StoreConfig.Normalization.
+	normalization TextNormalization
+	// synonyms are the synonyms of the words in the store's queries. nil if the store has none.
+	// See IndexOptions.Synonyms.
+	synonyms *SynonymDict
+	// stopwords are the stop words of the store's bleve index. See StoreConfig.Stopwords.
+	stopwords StopwordConfig
+	// mu serializes the writers of the store. Documents are added and removed with it held. It is
+	// a pointer because Store has methods with value receivers.
+	mu *sync.Mutex
+	// writers is the number of IndexReaders calls in progress. writeFailed is true if one of
+	// them failed. See startWriter.
+	writers     int
+	writeFailed bool
+	// lock is the writer lock on the store that this process holds. locks is the number of users
+	// of the lock. See lockWriter.
+	lock  *StoreLock
+	locks int
 }
 
-func (l PositionsState) String() string {
+// PositionsState is the old name of Store.
+//
+// Deprecated: Use Store. PositionsState will be removed in v1.
+type PositionsState = Store
+
+func (l Store) String() string {
 	var parts []string
 	parts = append(parts,
 		fmt.Sprintf("%q fileList=%d hashIndex=%d indexHash=%d hashPath=%d hashDoc=%d %s",
@@ -227,37 +1017,43 @@ func (l PositionsState) String() string {
 	for k, lDoc := range l.hashDoc {
 		parts = append(parts, fmt.Sprintf("%q: %d", k, lDoc.Len()))
 	}
-	return fmt.Sprintf("{PositionsState: %s}", strings.Join(parts, "\t"))
+	return fmt.Sprintf("{Store: %s}", strings.Join(parts, "\t"))
 }
 
-func (l PositionsState) Check() {
-	err := fmt.Errorf("Bad PositionsState: %s", l)
-	if len(l.fileList) == 0 || len(l.hashIndex) == 0 || len(l.indexHash) == 0 || len(l.hashPath) == 0 {
-		panic(err)
-	}
-	if len(l.hashDoc) == 0 {
-		panic(err)
-	}
+// Check returns ErrCorruptStore if in-memory store `l` is empty or has an empty document.
+func (l Store) Check() error {
+	bad := len(l.fileList) == 0 || len(l.hashIndex) == 0 || len(l.indexHash) == 0 ||
+		len(l.hashPath) == 0 || len(l.hashDoc) == 0
 	for _, lDoc := range l.hashDoc {
 		if lDoc.Len() == 0 {
-			panic(err)
+			bad = true
 		}
 	}
+	if bad {
+		common.Log.Error("Check: Bad PositionsState: %s", l)
+		return ErrCorruptStore
+	}
+	return nil
 }
 
-func FromHIPDs(hipds []serial.HashIndexPathDoc) PositionsState {
-	var l PositionsState
+// FromHIPDs returns the in-memory Store described by `hipds`.
+//
+// Deprecated: HashIndexPathDocs are a serialization detail of in-memory stores. FromHIPDs will be
+// unexported in v1.
+// Documents with no pages and empty stores are logged as errors.
+func FromHIPDs(hipds []serial.HashIndexPathDoc) Store {
+	var l Store
 	l.hashIndex = map[string]uint64{} // {file hash: index into fileList}
 	l.indexHash = map[uint64]string{} // {index into fileList: file hash}
 	l.hashPath = map[string]string{}  // {file hash: file path}
-	l.hashDoc = map[string]*DocPositions{}
+	l.hashDoc = map[string]*Doc{}
 	for _, h := range hipds {
 		hash := h.Hash
 		idx := h.Index
 		path := h.Path
 		sdoc := h.Doc
 
-		doc := DocPositions{
+		doc := Doc{
 			inPath: sdoc.Path,   // Path of input PDF file.
 			docIdx: sdoc.DocIdx, // Index into lState.fileList.
 			docData: &docData{
@@ -265,11 +1061,9 @@ func FromHIPDs(hipds []serial.HashIndexPathDoc) PositionsState {
 				pageTexts: sdoc.PageTexts,
 			},
 		}
-		if len(doc.pageNums) == 0 {
-			panic("pageNums")
-		}
-		if len(doc.pageTexts) == 0 {
-			panic("pageTexts")
+		if len(doc.pageNums) == 0 || len(doc.pageTexts) == 0 {
+			common.Log.Error("FromHIPDs: %q has no pages. pageNums=%d pageTexts=%d", path,
+				len(doc.pageNums), len(doc.pageTexts))
 		}
 		l.hashPath[hash] = path
 		l.hashDoc[hash] = &doc
@@ -277,21 +1071,16 @@ func FromHIPDs(hipds []serial.HashIndexPathDoc) PositionsState {
 		l.indexHash[idx] = hash
 	}
 	if len(l.hashPath) == 0 {
-		panic("hashPath")
-	}
-	if len(l.hashDoc) == 0 {
-		panic("hashDoc")
-	}
-	if len(l.hashIndex) == 0 {
-		panic("hashIndex")
-	}
-	if len(l.indexHash) == 0 {
-		panic("indexHash")
+		common.Log.Error("FromHIPDs: No documents")
 	}
 	return l
 }
 
-func (l PositionsState) ToHIPDs() []serial.HashIndexPathDoc {
+// ToHIPDs returns the serializable description of in-memory Store `l`.
+//
+// Deprecated: HashIndexPathDocs are a serialization detail of in-memory stores. ToHIPDs will be
+// unexported in v1.
+func (l Store) ToHIPDs() []serial.HashIndexPathDoc {
 	var hipds []serial.HashIndexPathDoc
 	for hash, idx := range l.hashIndex {
 		path := l.hashPath[hash]
@@ -313,15 +1102,15 @@ func (l PositionsState) ToHIPDs() []serial.HashIndexPathDoc {
 	return hipds
 }
 
-func (l PositionsState) Len() int {
+func (l Store) Len() int {
 	return len(l.fileList)
 }
 
-func (l PositionsState) isMem() bool {
+func (l Store) isMem() bool {
 	return l.root == ""
 }
 
-func (lState PositionsState) indexToPath(idx uint64) (string, bool) {
+func (lState Store) indexToPath(idx uint64) (string, bool) {
 	hash, ok := lState.indexHash[idx]
 	if !ok {
 		return "", false
@@ -330,41 +1119,77 @@ func (lState PositionsState) indexToPath(idx uint64) (string, bool) {
 	return inPath, ok
 }
 
-func (lState PositionsState) positionsDir() string {
+func (lState Store) positionsDir() string {
 	return filepath.Join(lState.root, "positions")
 }
 
 // OpenPositionsState loads indexes from an existing locations directory `root` or creates one if it
 // doesn't exist.
 // When opening for writing, do this to ensure final index is written to disk:
-//    lState, err := doclib.OpenPositionsState(persistDir, forceCreate)
-//    defer lState.Flush()
-func OpenPositionsState(root string, forceCreate bool) (*PositionsState, error) {
-	lState := PositionsState{
+//
+//	lState, err := doclib.OpenPositionsState(persistDir, forceCreate)
+//	defer lState.Flush()
+func OpenPositionsState(root string, forceCreate bool) (*Store, error) {
+	return openPositionsState(root, forceCreate, nil)
+}
+
+// openPositionsState is OpenPositionsState for a writer that holds the writer lock `lock` on the
+// store. The lock is released with the returned Store's unlockWriter. `lock` is nil if
+// the caller doesn't hold the lock.
+func openPositionsState(root string, forceCreate bool, lock *StoreLock) (*Store, error) {
+	lState := Store{
 		root:      root,
 		hashIndex: map[string]uint64{},
 		indexHash: map[uint64]string{},
 		hashPath:  map[string]string{},
+		mu:        &sync.Mutex{},
+		lock:      lock,
+	}
+	if lock != nil {
+		lState.locks = 1
+	}
+	if forceCreate && !lState.isMem() {
+		if err := lState.removePositionsState(); err != nil {
+			return nil, err
+		}
+	}
+	config, err := LoadStoreConfig(root)
+	if err != nil {
+		return nil, err
+	}
+	lState.flushPeriod = config.flushPeriod()
+	lState.normalization = config.Normalization
+	lState.stopwords = config.Stopwords
+	if lState.synonyms, err = loadStoreSynonyms(root); err != nil {
+		return nil, err
 	}
 	if lState.isMem() {
-		lState.hashDoc = map[string]*DocPositions{}
+		lState.hashDoc = map[string]*Doc{}
 	} else {
-		if forceCreate {
-			if err := lState.removePositionsState(); err != nil {
-				return nil, err
-			}
-		}
 		filename := lState.fileListPath()
-		fileList, err := loadFileList(filename)
+		fileList, version, err := loadFileList(filename)
 		if err != nil {
 			return nil, err
 		}
 		lState.fileList = fileList
+		lState.docCache = newDocCache(config.openDocs())
+		m, err := loadManifest(root)
+		if err != nil {
+			return nil, err
+		}
+		if m.ColdDir != "" {
+			lState.cold = DirStorage(m.ColdDir)
+		}
 		for i, hip := range fileList {
 			lState.hashIndex[hip.Hash] = uint64(i)
 			lState.indexHash[uint64(i)] = hip.Hash
 			lState.hashPath[hip.Hash] = hip.InPath
 		}
+		if version != StoreFormatVersion {
+			if err := lState.migrate(version); err != nil {
+				return nil, err
+			}
+		}
 	}
 
 	lState.updateTime = time.Now()
@@ -372,8 +1197,7 @@ func OpenPositionsState(root string, forceCreate bool) (*PositionsState, error)
 	return &lState, nil
 }
 
-func (lState *PositionsState) ExtractDocPagePositions(inPath string) ([]DocPageText, error) {
-	panic("ExtractDocPagePositions")
+func (lState *Store) ExtractDocPagePositions(inPath string) ([]DocPageText, error) {
 	rs, err := os.Open(inPath)
 	if err != nil {
 		return []DocPageText{}, err
@@ -384,101 +1208,309 @@ func (lState *PositionsState) ExtractDocPagePositions(inPath string) ([]DocPageT
 
 // ExtractDocPagePositionsReader extracts the text of the PDF file referenced by `rs`.
 // It returns the text as a DocPageText per page.
-// The []DocPageText refer to DocPositions which are stored in lState.hashDoc which is updated in
+// The []DocPageText refer to Doc which are stored in lState.hashDoc which is updated in
 // this function.
-func (lState *PositionsState) ExtractDocPagePositionsReader(inPath string, rs io.ReadSeeker) (
+func (lState *Store) ExtractDocPagePositionsReader(inPath string, rs io.ReadSeeker) (
 	[]DocPageText, error) {
 
-	fd, err := CreateFileDesc(inPath, rs)
-	if err != nil {
-		return nil, err
+	ext := extractDocPagePositions(context.Background(), inPath, rs, DefaultIndexOptions())
+	if ext.err != nil {
+		return nil, ext.err
 	}
+	return lState.addDocPagePositions(ext.fd, ext.pages)
+}
 
-	lDoc, err := lState.CreatePositionsDoc(fd)
+// extractDocPagePositions extracts the text and text locations of the pages of the PDF file
+// referenced by `rs`. `inPath` is the name of the PDF file.
+// Pages with no text are recognized with opts.OCR if it is set. Image files are recognized with
+// opts.OCR as documents with a single page. See IsImageFile. Known bad PDFs are skipped and PDFs
+// that crash the PDF library are quarantined. See CorpusFilter.
+// Extraction stops if `ctx` is done or takes longer than opts.FileTimeout.
+// It doesn't access any Store so it can be called concurrently.
+func extractDocPagePositions(ctx context.Context, inPath string, rs io.ReadSeeker,
+	opts IndexOptions) docExtraction {
+
+	t0 := time.Now()
+	skipped, err := opts.excludeFile(inPath, rs)
 	if err != nil {
-		return nil, err
+		return docExtraction{inPath: inPath, err: err, duration: time.Since(t0)}
 	}
+	if skipped != "" {
+		return docExtraction{inPath: inPath, skipped: skipped, duration: time.Since(t0)}
+	}
+	fd, err := CreateFileDesc(inPath, rs)
+	if err != nil {
+		return docExtraction{inPath: inPath, err: err, duration: time.Since(t0)}
+	}
+	if opts.skipHashes[fd.Hash] {
+		return docExtraction{inPath: inPath, fd: fd, exists: true, duration: time.Since(t0)}
+	}
+	if reason := opts.filter.badReason(fd.Hash); reason != "" {
+		return docExtraction{inPath: inPath, fd: fd, skipped: "known bad PDF: " + reason,
+			duration: time.Since(t0)}
+	}
+	if IsImageFile(inPath) {
+		return extractImageDoc(inPath, rs, fd, opts, t0)
+	}
+	fileCtx, cancel := ctx, context.CancelFunc(func() {})
+	if opts.FileTimeout > 0 {
+		fileCtx, cancel = context.WithTimeout(ctx, opts.FileTimeout)
+	}
+	defer cancel()
+	opts.filter.startExtraction(fd.Hash, inPath)
+	var ext docExtraction
+	if opts.Isolate {
+		ext = extractIsolated(fileCtx, inPath, rs, fd, opts)
+	} else {
+		ext = extractPdfPages(fileCtx, inPath, rs, fd, opts)
+	}
+	opts.filter.endExtraction(fd.Hash, inPath, ext.err)
+	if ext.err != nil && fileCtx.Err() == context.DeadlineExceeded && ctx.Err() == nil {
+		ext.err = fmt.Errorf("Extraction timed out after %s", opts.FileTimeout)
+	}
+	ext.duration = time.Since(t0)
+	return ext
+}
 
-	var docPages []DocPageText
-
-	err = ProcessPDFPagesReader(inPath, rs, func(pageNum uint32, page *pdf.PdfPage) error {
-		text, locations, err := ExtractPageTextLocation(page)
+// extractPdfPages extracts the text and text locations of the pages of the PDF file described by
+// `fd` and read from `rs`. `inPath` is the name of the PDF file. It is the part of
+// extractDocPagePositions that parses the PDF, which is run in an extraction worker process when
+// opts.Isolate is set. It stops with `ctx`'s error if `ctx` is done.
+func extractPdfPages(ctx context.Context, inPath string, rs io.ReadSeeker, fd FileDesc,
+	opts IndexOptions) docExtraction {
+
+	src := &pdfFile{inPath: inPath, rs: rs}
+	defer src.close()
+	var pages []pageExtraction
+	var pageErrs []string
+	numPages := 0
+	bufferBytes := 0
+	processPage := func(pageNum uint32, page *pdf.PdfPage) error {
+		numPages++
+		if opts.MaxBufferMB > 0 && float64(bufferBytes) > opts.MaxBufferMB*1024*1024 {
+			pageErrs = append(pageErrs, fmt.Sprintf("page %d: page buffer is full", pageNum))
+			return nil
+		}
+		pe, extractor, err := extractPage(src, pageNum, page, opts)
 		if err != nil {
-			common.Log.Error("ExtractDocPagePositions: ExtractPageTextLocation failed. "+
-				"inPath=%q pageNum=%d err=%v", inPath, pageNum, err)
+			pageErrs = append(pageErrs, fmt.Sprintf("page %d: %v", pageNum, err))
 			return nil // !@#$ Skip errors for now
 		}
-		if text == "" {
+		if pe.text == "" {
 			return nil
 		}
-
-		var dpl serial.DocPageLocations
-		for i, loc := range locations {
-			stl := ToSerialTextLocation(loc)
-			common.Log.Debug("%d: %s", i, stl)
-			dpl.Locations = append(dpl.Locations, stl)
+		fd.Extractors = addExtractor(fd.Extractors, extractor)
+		pages = append(pages, pe)
+		bufferBytes += pe.bufferBytes()
+		if len(pages)%100 == 99 {
+			common.Log.Debug("  pageNum=%d pages=%d %q", pageNum, len(pages), filepath.Base(inPath))
+		}
+		return nil
+	}
+	err := ProcessPDFReader(inPath, rs, func(pdfReader *pdf.PdfReader) error {
+		meta, err := ReadDocMetadata(pdfReader)
+		if err != nil {
+			common.Log.Error("extractPdfPages: Couldn't read metadata. inPath=%q err=%v",
+				inPath, err)
+		}
+		fd.Metadata = meta
+		if fd.Outline, err = ReadOutline(pdfReader); err != nil {
+			common.Log.Error("extractPdfPages: Couldn't read outline. inPath=%q err=%v",
+				inPath, err)
+		}
+		if fd.FormFields, err = ReadFormFields(pdfReader); err != nil {
+			common.Log.Error("extractPdfPages: Couldn't read form. inPath=%q err=%v",
+				inPath, err)
 		}
+		return processPDFPages(ctx, inPath, pdfReader, opts.PageRanges, processPage)
+	})
+	return docExtraction{inPath: inPath, fd: fd, pages: pages, err: err, numPages: numPages,
+		pageErrs: pageErrs}
+}
 
-		pageIdx, err := lDoc.AddDocPage(pageNum, dpl, text)
+// extractPage extracts the text and text locations of `page`, which is page `pageNum` of PDF
+// `src`, with the TextExtractor that `opts` selects for src.inPath. Pages with no text are
+// recognized with opts.OCR if it is set. Words that are hyphenated across lines are joined, the
+// text is normalized with opts.Normalization and, unless opts.RawTextOrder is set, the text of
+// multi-column pages is put in reading order. See joinHyphenated and readingOrder.
+// It returns the extracted page and the extractor that produced its text. The text of the returned
+// page is empty if the page has no text. The page's text locations are word locations. See
+// wordLocations.
+func extractPage(src *pdfFile, pageNum uint32, page *pdf.PdfPage, opts IndexOptions) (
+	pageExtraction, Extractor, error) {
+
+	inPath := src.inPath
+	te, err := opts.textExtractor(inPath)
+	if err != nil {
+		return pageExtraction{}, Extractor{}, err
+	}
+	extractor := te.Extractor()
+	var dpl serial.DocPageLocations
+	var text string
+	var locations []serial.TextLocation
+	if ocr, ok := te.(OCRExtractor); ok {
+		// OCR renders pages from a file, which src may have to make.
+		text, locations, err = ocrPageText(ocr.OCR, src, pageNum, page)
+	} else {
+		text, locations, err = te.ExtractPage(inPath, pageNum, page)
+	}
+	if err != nil {
+		common.Log.Error("extractPage: %s failed. inPath=%q pageNum=%d err=%v", extractor,
+			inPath, pageNum, err)
+		return pageExtraction{}, Extractor{}, err
+	}
+	dpl.Locations = locations
+	// Score the raw text. Invalid UTF-8 is a sign of garbage that canonicalPageText hides.
+	quality := TextQuality(text)
+	if text == "" && opts.OCR != nil {
+		extractor = opts.OCR.Extractor()
+		text, dpl.Locations, err = ocrPageText(opts.OCR, src, pageNum, page)
 		if err != nil {
-			return err
+			common.Log.Error("extractPage: OCR failed. inPath=%q pageNum=%d err=%v",
+				inPath, pageNum, err)
+			return pageExtraction{}, Extractor{}, fmt.Errorf("OCR: %v", err)
 		}
+		quality = TextQuality(text)
+	} else if quality < opts.MinTextQuality && opts.OCR != nil {
+		ocrText, ocrLocations, err := ocrPageText(opts.OCR, src, pageNum, page)
+		if err != nil {
+			// The extracted text is still better than nothing.
+			common.Log.Error("extractPage: OCR of low quality text failed. inPath=%q pageNum=%d "+
+				"quality=%.2f err=%v", inPath, pageNum, quality, err)
+		} else {
+			ocrQuality := TextQuality(ocrText)
+			common.Log.Info("extractPage: Low quality text. inPath=%q pageNum=%d quality=%.2f "+
+				"ocr=%.2f", inPath, pageNum, quality, ocrQuality)
+			if ocrQuality > quality {
+				extractor = opts.OCR.Extractor()
+				text, dpl.Locations, quality = ocrText, ocrLocations, ocrQuality
+			}
+		}
+	}
+	if !opts.RawTextOrder {
+		text, dpl.Locations = readingOrder(text, dpl.Locations)
+	}
+	text, dpl.Locations = joinHyphenated(text, dpl.Locations)
+	text, dpl.Locations = opts.Normalization.normalizePage(text, dpl.Locations)
+	text, dpl.Locations = canonicalPageText(text, dpl.Locations)
+	dpl.Locations = wordLocations(text, dpl.Locations)
+	box, err := getPageBox(page)
+	if err != nil {
+		// Matches on the page can still be reported in PDF coordinates.
+		common.Log.Error("extractPage: No page box. inPath=%q pageNum=%d err=%v",
+			inPath, pageNum, err)
+	}
+	return pageExtraction{pageNum: pageNum, text: text, dpl: dpl, box: box, quality: quality,
+		annots: readPageAnnotations(page), tables: detectTables(text, dpl.Locations)}, extractor, nil
+}
+
+// addDocPagePositions adds the pages `pages` extracted from the PDF described by `fd` to `lState`.
+// It returns the text as a DocPageText per page.
+func (lState *Store) addDocPagePositions(fd FileDesc, pages []pageExtraction) (
+	[]DocPageText, error) {
 
+	fd.Fingerprints = make([]uint64, len(pages))
+	for i, p := range pages {
+		fd.Fingerprints[i] = pageFingerprint(p.text)
+	}
+	lDoc, err := lState.CreatePositionsDoc(fd)
+	if err != nil {
+		return nil, err
+	}
+	if err := lState.journal.start(lDoc.docIdx, fd, len(pages)); err != nil {
+		lDoc.Close()
+		return nil, err
+	}
+
+	var docPages []DocPageText
+	for _, p := range pages {
+		pageIdx, err := lDoc.AddDocPage(p.pageNum, p.dpl, p.text)
+		if err != nil {
+			lDoc.Close()
+			return nil, err
+		}
+		lDoc.setPageBox(pageIdx, p.box)
+		lDoc.setPageQuality(pageIdx, p.quality)
+		lDoc.setPageAnnotations(pageIdx, p.annots)
+		lDoc.setPageTables(pageIdx, p.tables)
+		// Index the stored text so that bleve offsets are offsets into the text we read back when
+		// generating snippets.
+		text, err := lDoc.ReadPageText(pageIdx)
+		if err != nil {
+			lDoc.Close()
+			return nil, err
+		}
+		if text != p.text {
+			lDoc.Close()
+			return nil, fmt.Errorf("Page text changed when stored. inPath=%q pageNum=%d "+
+				"text=%d stored=%d. %v", fd.InPath, p.pageNum, len(p.text), len(text), ErrTextMismatch)
+		}
 		docPages = append(docPages, DocPageText{
 			DocIdx:  lDoc.docIdx,
 			PageIdx: pageIdx,
-			PageNum: pageNum,
+			PageNum: p.pageNum,
 			Text:    text,
 		})
-		if len(docPages)%100 == 99 {
-			common.Log.Debug("  pageNum=%d docPages=%d %q", pageNum, len(docPages),
-				filepath.Base(inPath))
-		}
-		dp := docPages[len(docPages)-1]
-		common.Log.Debug("ExtractDocPagePositions: Doc=%d Page=%d locs=%d",
-			dp.DocIdx, dp.PageIdx, len(dpl.Locations))
-
-		return nil
-	})
-	if err != nil {
-		return docPages, err
+		common.Log.Debug("addDocPagePositions: Doc=%d Page=%d locs=%d",
+			lDoc.docIdx, pageIdx, len(p.dpl.Locations))
 	}
-	err = lDoc.Close()
-	if err != nil {
+	if err = lDoc.commit(); err != nil {
+		return nil, err
+	}
+	if err := lState.journal.positions(lDoc.docIdx, fd); err != nil {
 		return nil, err
 	}
 	if lState.isMem() {
-		common.Log.Debug("ExtractDocPagePositions: pageNums=%v", lDoc.docData.pageNums)
+		common.Log.Debug("addDocPagePositions: pageNums=%v", lDoc.docData.pageNums)
 		lState.hashDoc[fd.Hash] = lDoc
 	}
-	return docPages, err
+	return docPages, nil
 }
 
 // addFile adds PDF file `fd` to `lState`.fileList.
 // returns: docIdx, inPath, exists
-//     docIdx: Index of PDF file in `lState`.fileList.
-//     inPath: Path to file. This the first path this file was added to the index with.
-//     exists: true if `fd` was already in lState`.fileList.
-func (lState *PositionsState) addFile(fd FileDesc) (uint64, string, bool) {
+//
+//	docIdx: Index of PDF file in `lState`.fileList.
+//	inPath: Path to file. This the first path this file was added to the index with.
+//	exists: true if `fd` was already in lState`.fileList.
+func (lState *Store) addFile(fd FileDesc) (uint64, string, bool) {
 	hash := fd.Hash
 	docIdx, ok := lState.hashIndex[hash]
 	if ok {
+		lState.addAlias(docIdx, fd.InPath)
 		return docIdx, lState.hashPath[hash], true
 	}
+	if fd.Indexed.IsZero() {
+		fd.Indexed = time.Now()
+	}
 	lState.fileList = append(lState.fileList, fd)
 	docIdx = uint64(len(lState.fileList) - 1)
 	lState.hashIndex[hash] = docIdx
 	lState.indexHash[docIdx] = hash
 	lState.hashPath[hash] = fd.InPath
-	dt := time.Since(lState.updateTime)
-	if dt.Seconds() > storeUpdatePeriodSec {
+	if time.Since(lState.updateTime) > lState.flushPeriod {
 		lState.Flush()
 		lState.updateTime = time.Now()
 	}
 	return docIdx, fd.InPath, false
 }
 
-func (lState *PositionsState) Flush() error {
+// addAlias records that `inPath` has the same contents as the PDF with index `docIdx` in
+// `lState`.fileList.
+func (lState *Store) addAlias(docIdx uint64, inPath string) {
+	fd := &lState.fileList[docIdx]
+	if inPath == fd.InPath {
+		return
+	}
+	for _, alias := range fd.Aliases {
+		if alias == inPath {
+			return
+		}
+	}
+	fd.Aliases = append(fd.Aliases, inPath)
+}
+
+func (lState *Store) Flush() error {
 	if lState.isMem() {
 		return nil
 	}
@@ -486,18 +1518,21 @@ func (lState *PositionsState) Flush() error {
 	docIdx := uint64(len(lState.fileList) - 1)
 	common.Log.Debug("*** Flush %3d files (%4.1f sec) %s",
 		docIdx+1, dt.Seconds(), lState.updateTime)
+	if err := lState.saveTextRefs(); err != nil {
+		return err
+	}
 	return saveFileList(lState.fileListPath(), lState.fileList)
 }
 
 // fileListPath is the path where lState.fileList is stored on disk.
-func (lState *PositionsState) fileListPath() string {
+func (lState *Store) fileListPath() string {
 	return filepath.Join(lState.root, "file_list.json")
 }
 
-// removePositionsState removes the PositionsState persistent data in the directory tree under
+// removePositionsState removes the Store persistent data in the directory tree under
 // `root` from disk.
-func (lState *PositionsState) removePositionsState() error {
-	if !Exists(lState.root) {
+func (lState *Store) removePositionsState() error {
+	if !Exists(lState.root) || onlyLockFile(lState.root) {
 		return nil
 	}
 	flPath := lState.fileListPath()
@@ -506,18 +1541,39 @@ func (lState *PositionsState) removePositionsState() error {
 			lState.root, flPath)
 		return errors.New("not a PositionsState directory")
 	}
-	err := RemoveDirectory(lState.root)
+	var err error
+	if lState.lock != nil {
+		// Keep the lock file so that the store stays locked while it is rebuilt.
+		err = removeDirContents(lState.root, lockFileName)
+	} else {
+		err = RemoveDirectory(lState.root)
+	}
 	if err != nil {
 		common.Log.Error("RemoveDirectory(%q) failed. err=%v", lState.root, err)
 	}
 	return err
 }
 
+// onlyLockFile returns true if directory `dir` is empty apart from a store lock file. This is what
+// a new store directory looks like after LockStore creates it.
+func onlyLockFile(dir string) bool {
+	d, err := os.Open(dir)
+	if err != nil {
+		return false
+	}
+	defer d.Close()
+	names, err := d.Readdirnames(-1)
+	if err != nil {
+		return false
+	}
+	return len(names) == 0 || (len(names) == 1 && names[0] == lockFileName)
+}
+
 // docPath returns the file path to the positions files for PDF with hash `hash`.
-func (lState *PositionsState) docPath(hash string) string {
+func (lState *Store) docPath(hash string) string {
 	common.Log.Trace("docPath: %q %s", lState.positionsDir(), hash)
 	if lState.isMem() {
-		panic(fmt.Errorf("lState=%s", *lState)) // !@#$ Remove this
+		common.Log.Error("docPath: In-memory stores have no paths. lState=%s", *lState)
 	}
 	return filepath.Join(lState.positionsDir(), hash)
 }
@@ -525,7 +1581,7 @@ func (lState *PositionsState) docPath(hash string) string {
 // createIfNecessary creates `lState`.positionsDir if it doesn't already exist.
 // It is called at the start of CreatePositionsDoc() which allows us to avoid creating our directory
 // structure until we have successfully extracted the text from a PDF pages.
-func (lState *PositionsState) createIfNecessary() error {
+func (lState *Store) createIfNecessary() error {
 	if lState.root == "" {
 		return fmt.Errorf("lState=%s", *lState)
 	}
@@ -541,7 +1597,7 @@ func (lState *PositionsState) createIfNecessary() error {
 	return err
 }
 
-func (lState *PositionsState) ReadDocPageText(docIdx uint64, pageIdx uint32) (string, error) {
+func (lState *Store) ReadDocPageText(docIdx uint64, pageIdx uint32) (string, error) {
 	lDoc, err := lState.OpenPositionsDoc(docIdx)
 	if err != nil {
 		return "", err
@@ -551,8 +1607,11 @@ func (lState *PositionsState) ReadDocPageText(docIdx uint64, pageIdx uint32) (st
 	return lDoc.ReadPageText(pageIdx)
 }
 
-// ReadDocPagePositions is inefficient. A DocPositions (a file) is opened and closed to read a page.
-func (lState *PositionsState) ReadDocPagePositions(docIdx uint64, pageIdx uint32) (
+// ReadDocPagePositions returns the path, page number and text locations of the page with index
+// `pageIdx` in document `docIdx` of `lState`. The positions of recently read documents are kept
+// open in a persistent `lState` so reading many pages of the same documents doesn't reopen their
+// files. See SetOpenDocs().
+func (lState *Store) ReadDocPagePositions(docIdx uint64, pageIdx uint32) (
 	string, uint32, serial.DocPageLocations, error) {
 
 	lDoc, err := lState.OpenPositionsDoc(docIdx)
@@ -565,17 +1624,17 @@ func (lState *PositionsState) ReadDocPagePositions(docIdx uint64, pageIdx uint32
 	return lDoc.inPath, pageNum, dpl, err
 }
 
-// CreatePositionsDoc creates a DocPositions for writing.
-// CreatePositionsDoc always populates the DocPositions with base fields.
+// CreatePositionsDoc creates a Doc for writing.
+// CreatePositionsDoc always populates the Doc with base fields.
 // In a persistent `lState`, necessary directories are created and files are opened.
-func (lState *PositionsState) CreatePositionsDoc(fd FileDesc) (*DocPositions, error) {
+func (lState *Store) CreatePositionsDoc(fd FileDesc) (*Doc, error) {
 	common.Log.Debug("CreatePositionsDoc: lState.positionsDir=%q", lState.positionsDir())
 
 	docIdx, p, exists := lState.addFile(fd)
 	if exists {
-		common.Log.Error("ExtractDocPagePositions: %q is the same PDF as %q. Ignoring",
+		common.Log.Error("CreatePositionsDoc: %q is the same PDF as %q. Ignoring",
 			fd.InPath, p)
-		return nil, errors.New("duplicate PDF")
+		return nil, ErrDuplicate
 	}
 	lDoc, err := lState.baseFields(docIdx)
 	if err != nil {
@@ -595,13 +1654,12 @@ func (lState *PositionsState) CreatePositionsDoc(fd FileDesc) (*DocPositions, er
 	if err != nil {
 		return nil, err
 	}
-	err = MkDir(lDoc.textDir)
-	return lDoc, err
+	return lDoc, nil
 }
 
-// OpenPositionsDoc opens a DocPositions for reading.
+// OpenPositionsDoc opens a Doc for reading.
 // In a persistent `lState`, necessary files are opened in lDoc.openDoc().
-func (lState *PositionsState) OpenPositionsDoc(docIdx uint64) (*DocPositions, error) {
+func (lState *Store) OpenPositionsDoc(docIdx uint64) (*Doc, error) {
 	if lState.isMem() {
 		hash := lState.indexHash[docIdx]
 		lDoc := lState.hashDoc[hash]
@@ -614,12 +1672,26 @@ func (lState *PositionsState) OpenPositionsDoc(docIdx uint64) (*DocPositions, er
 	if err != nil {
 		return nil, err
 	}
+	if err := lState.thawDoc(lDoc); err != nil {
+		return nil, err
+	}
 	err = lDoc.openDoc()
 	return lDoc, err
 }
 
-// baseFields populates a DocPositions with the fields that are the same for Open and Create.
-func (lState *PositionsState) baseFields(docIdx uint64) (*DocPositions, error) {
+// docPersistPaths returns a docPersist with the paths of the files for the PDF with hash `hash`.
+func (lState *Store) docPersistPaths(hash string) *docPersist {
+	locPath := lState.docPath(hash)
+	return &docPersist{
+		dataPath:    locPath + ".dat",
+		spansPath:   locPath + ".idx.json",
+		textDir:     locPath + ".pages",
+		pageDplPath: locPath + ".dpl.json",
+	}
+}
+
+// baseFields populates a Doc with the fields that are the same for Open and Create.
+func (lState *Store) baseFields(docIdx uint64) (*Doc, error) {
 	if int(docIdx) >= len(lState.fileList) {
 		common.Log.Error("docIdx=%d lState=%s\n=%#v", docIdx, *lState, *lState)
 		return nil, ErrRange
@@ -627,7 +1699,7 @@ func (lState *PositionsState) baseFields(docIdx uint64) (*DocPositions, error) {
 	inPath := lState.fileList[docIdx].InPath
 	hash := lState.fileList[docIdx].Hash
 
-	lDoc := DocPositions{
+	lDoc := Doc{
 		lState:  lState,
 		inPath:  inPath,
 		docIdx:  docIdx,
@@ -638,43 +1710,48 @@ func (lState *PositionsState) baseFields(docIdx uint64) (*DocPositions, error) {
 		mem := docData{}
 		lDoc.docData = &mem
 	} else {
-		locPath := lState.docPath(hash)
-		persist := docPersist{
-			dataPath:    locPath + ".dat",
-			spansPath:   locPath + ".idx.json",
-			textDir:     locPath + ".pages",
-			pageDplPath: locPath + ".dpl.json",
-		}
-		lDoc.docPersist = &persist
+		lDoc.docPersist = lState.docPersistPaths(hash)
 	}
 	common.Log.Debug("baseFields: docIdx=%d lDoc=%+v", docIdx, lDoc)
 	if lState.isMem() != lDoc.isMem() {
-		panic(fmt.Errorf("lState.isMem()=%t lDoc.isMem()=%t", lState.isMem(), lDoc.isMem()))
+		common.Log.Error("baseFields: lState.isMem()=%t lDoc.isMem()=%t", lState.isMem(),
+			lDoc.isMem())
+		return nil, ErrCorruptStore
 	}
 	return &lDoc, nil
 }
 
-func (lState *PositionsState) GetHashPath(docIdx uint64) (hash, inPath string) {
+func (lState *Store) GetHashPath(docIdx uint64) (hash, inPath string) {
 	hash = lState.indexHash[docIdx]
 	inPath = lState.hashPath[hash]
 	return hash, inPath
 }
 
-func loadFileList(filename string) ([]FileDesc, error) {
+// loadFileList returns the file list in `filename` and the format version of the store it is in.
+// See StoreFormatVersion. Stores that don't have a file list yet have the current version.
+func loadFileList(filename string) ([]FileDesc, int, error) {
 	b, err := ioutil.ReadFile(filename)
 	if err != nil {
 		if !Exists(filename) {
-			return nil, nil
+			return nil, StoreFormatVersion, nil
 		}
-		return nil, err
+		return nil, 0, err
+	}
+	// Version 0 file lists are lists of FileDescs.
+	if b = bytes.TrimSpace(b); len(b) > 0 && b[0] == '[' {
+		var fileList []FileDesc
+		err = json.Unmarshal(b, &fileList)
+		return fileList, 0, err
 	}
-	var fileList []FileDesc
-	err = json.Unmarshal(b, &fileList)
-	return fileList, err
+	var fl storeFileList
+	err = json.Unmarshal(b, &fl)
+	return fl.Files, fl.Version, err
 }
 
+// saveFileList saves `fileList` in `filename` in the current store format.
 func saveFileList(filename string, fileList []FileDesc) error {
-	b, err := json.MarshalIndent(fileList, "", "\t")
+	fl := storeFileList{Version: StoreFormatVersion, Files: fileList}
+	b, err := json.MarshalIndent(fl, "", "\t")
 	if err != nil {
 		return err
 	}
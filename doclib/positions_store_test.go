@@ -0,0 +1,48 @@
+package doclib
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestIndexUnopenableFiles checks that files that can't be opened are reported as failed and
+// don't stop the other files from being indexed.
+func TestIndexUnopenableFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pdf-search-open")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	a := filepath.Join(dir, "a.pdf")
+	b := filepath.Join(dir, "b.pdf")
+	for _, inPath := range []string{a, b} {
+		if err := ioutil.WriteFile(inPath, []byte("not a PDF"), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+	missing := filepath.Join(dir, "missing.pdf")
+
+	for _, numWorkers := range []int{1, 2} {
+		opts := DefaultIndexOptions()
+		opts.NumWorkers = numWorkers
+		opts.Report = &IndexReport{}
+		pathList := []string{a, missing, b}
+		_, index, _, err := IndexPdfFilesOpts(pathList, "", false, false, opts, nil)
+		if err != nil {
+			t.Fatalf("numWorkers=%d err=%v", numWorkers, err)
+		}
+		index.Close()
+		if len(opts.Report.Files) != len(pathList) {
+			t.Fatalf("numWorkers=%d reports=%d expected=%d", numWorkers,
+				len(opts.Report.Files), len(pathList))
+		}
+		for _, f := range opts.Report.Files {
+			if f.InPath == missing && (f.Status != FileFailed || len(f.Errors) == 0) {
+				t.Errorf("numWorkers=%d %q: Status=%q Errors=%q", numWorkers, f.InPath, f.Status,
+					f.Errors)
+			}
+		}
+	}
+}
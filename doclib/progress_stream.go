@@ -0,0 +1,144 @@
+package doclib
+
+import (
+	"net/http"
+	"sync"
+
+	"github.com/unidoc/unidoc/common"
+	"golang.org/x/net/websocket"
+)
+
+// progressBuffer is the number of Progress updates that are queued for a ProgressBroadcaster
+// subscriber. Older updates are dropped for subscribers that fall behind.
+const progressBuffer = 16
+
+// ProgressBroadcaster is a ProgressReporter that publishes the progress of an indexing run to any
+// number of subscribers, e.g. dashboards watching a long corpus build. Set IndexOptions.Progress to
+// it and Subscribe to it or serve it with ProgressHandler.
+// Progress never blocks indexing. Subscribers that don't keep up miss intermediate updates but
+// always get the latest one.
+type ProgressBroadcaster struct {
+	mu     sync.Mutex
+	subs   map[chan Progress]bool
+	last   *Progress // The most recent update. nil before the first one.
+	closed bool
+}
+
+// NewProgressBroadcaster returns a ProgressBroadcaster with no subscribers.
+func NewProgressBroadcaster() *ProgressBroadcaster {
+	return &ProgressBroadcaster{subs: map[chan Progress]bool{}}
+}
+
+// Progress publishes `p` to the subscribers of `b`.
+func (b *ProgressBroadcaster) Progress(p Progress) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return
+	}
+	b.last = &p
+	for ch := range b.subs {
+		sendLatest(ch, p)
+	}
+}
+
+// Subscribe returns a channel that receives the updates published by `b`, starting with the most
+// recent one, and a function that unsubscribes. The channel is closed when `b` is closed or the
+// subscription is cancelled.
+func (b *ProgressBroadcaster) Subscribe() (<-chan Progress, func()) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	ch := make(chan Progress, progressBuffer)
+	if b.last != nil {
+		ch <- *b.last
+	}
+	if b.closed {
+		close(ch)
+		return ch, func() {}
+	}
+	b.subs[ch] = true
+	cancel := func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		if b.subs[ch] {
+			delete(b.subs, ch)
+			close(ch)
+		}
+	}
+	return ch, cancel
+}
+
+// Close ends the run that `b` reports. The subscribers' channels are closed after they have
+// received the updates that were published.
+func (b *ProgressBroadcaster) Close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return
+	}
+	b.closed = true
+	for ch := range b.subs {
+		close(ch)
+	}
+	b.subs = nil
+}
+
+// sendLatest sends `p` on `ch` without blocking. If `ch` is full its oldest update is dropped.
+func sendLatest(ch chan Progress, p Progress) {
+	select {
+	case ch <- p:
+		return
+	default:
+	}
+	select {
+	case <-ch:
+	default:
+	}
+	select {
+	case ch <- p:
+	default:
+	}
+}
+
+// progressMessage is a Progress as it is sent to dashboards. Durations are in seconds.
+type progressMessage struct {
+	InPath      string  `json:"inPath"`
+	FilesDone   int     `json:"filesDone"`
+	TotalFiles  int     `json:"totalFiles"`
+	PagesDone   int     `json:"pagesDone"`
+	PagesPerSec float64 `json:"pagesPerSec"`
+	ElapsedSec  float64 `json:"elapsedSec"`
+	ETASec      float64 `json:"etaSec"`
+}
+
+// makeProgressMessage returns the progressMessage of `p`.
+func makeProgressMessage(p Progress) progressMessage {
+	return progressMessage{
+		InPath:      p.InPath,
+		FilesDone:   p.FilesDone,
+		TotalFiles:  p.TotalFiles,
+		PagesDone:   p.PagesDone,
+		PagesPerSec: p.PagesPerSec,
+		ElapsedSec:  p.Elapsed.Seconds(),
+		ETASec:      p.ETA.Seconds(),
+	}
+}
+
+// ProgressHandler returns an http.Handler that streams the updates published by `b` to WebSocket
+// clients as JSON text messages of the form
+//   {"inPath": "a.pdf", "filesDone": 10, "totalFiles": 200, "pagesDone": 1234,
+//    "pagesPerSec": 56.7, "elapsedSec": 21.8, "etaSec": 414.2}
+// The connection is closed when the run finishes.
+func ProgressHandler(b *ProgressBroadcaster) http.Handler {
+	return websocket.Handler(func(ws *websocket.Conn) {
+		defer ws.Close()
+		ch, cancel := b.Subscribe()
+		defer cancel()
+		for p := range ch {
+			if err := websocket.JSON.Send(ws, makeProgressMessage(p)); err != nil {
+				common.Log.Debug("ProgressHandler: Client went away. err=%v", err)
+				return
+			}
+		}
+	})
+}
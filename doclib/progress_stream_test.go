@@ -0,0 +1,77 @@
+package doclib
+
+import (
+	"testing"
+	"time"
+)
+
+func TestProgressBroadcaster(t *testing.T) {
+	b := NewProgressBroadcaster()
+	b.Progress(Progress{FilesDone: 1, TotalFiles: 10})
+	ch, cancel := b.Subscribe()
+	defer cancel()
+
+	// A new subscriber gets the latest update first.
+	if p := <-ch; p.FilesDone != 1 {
+		t.Fatalf("first update: FilesDone=%d expected=1", p.FilesDone)
+	}
+
+	// A subscriber that falls behind misses the oldest updates but gets the latest.
+	n := progressBuffer + 5
+	for i := 2; i <= n; i++ {
+		b.Progress(Progress{FilesDone: i, TotalFiles: 10})
+	}
+	if len(ch) != progressBuffer {
+		t.Fatalf("queued=%d expected=%d", len(ch), progressBuffer)
+	}
+	var last Progress
+	for i := 0; i < progressBuffer; i++ {
+		last = <-ch
+	}
+	if last.FilesDone != n {
+		t.Fatalf("last update: FilesDone=%d expected=%d", last.FilesDone, n)
+	}
+
+	b.Close()
+	select {
+	case _, ok := <-ch:
+		if ok {
+			t.Fatalf("update after Close")
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("channel not closed by Close")
+	}
+	b.Progress(Progress{FilesDone: n + 1})
+	cancel()
+
+	// Subscribers after Close get the final update and a closed channel.
+	ch2, _ := b.Subscribe()
+	if p, ok := <-ch2; !ok || p.FilesDone != n {
+		t.Fatalf("final update: ok=%t FilesDone=%d expected=%d", ok, p.FilesDone, n)
+	}
+	if _, ok := <-ch2; ok {
+		t.Fatalf("update after final update")
+	}
+}
+
+func TestProgressCancel(t *testing.T) {
+	b := NewProgressBroadcaster()
+	ch, cancel := b.Subscribe()
+	cancel()
+	if _, ok := <-ch; ok {
+		t.Fatalf("update after cancel")
+	}
+	b.Progress(Progress{FilesDone: 1})
+	cancel()
+	b.Close()
+}
+
+func TestMakeProgressRate(t *testing.T) {
+	p := makeProgress("a.pdf", 2, 4, 100, time.Now().Add(-10*time.Second))
+	if p.PagesPerSec < 9 || p.PagesPerSec > 10.1 {
+		t.Fatalf("PagesPerSec=%.2f expected about 10", p.PagesPerSec)
+	}
+	if p.ETA < 9*time.Second || p.ETA > 11*time.Second {
+		t.Fatalf("ETA=%s expected about 10s", p.ETA)
+	}
+}
@@ -0,0 +1,64 @@
+package doclib
+
+import (
+	"strings"
+
+	"github.com/unidoc/unidoc/common"
+)
+
+// Extractor identifies the software that extracted the text of a document. It is recorded for
+// each document so that documents can be re-extracted after an extractor is upgraded.
+type Extractor struct {
+	Name    string // Extraction backend, e.g. "unidoc" or "tesseract".
+	Version string // Version of the backend.
+}
+
+// unidocExtractor is the Extractor for text extracted by UniDoc.
+var unidocExtractor = Extractor{Name: "unidoc", Version: common.Version}
+
+func (e Extractor) String() string {
+	if e.Version == "" {
+		return e.Name
+	}
+	return e.Name + "/" + e.Version
+}
+
+// Matches returns true if `e` matches `pattern` which is an extractor name, such as "unidoc", or
+// a name and version, such as "unidoc/3.0.0".
+func (e Extractor) Matches(pattern string) bool {
+	return strings.EqualFold(pattern, e.Name) || strings.EqualFold(pattern, e.String())
+}
+
+// extractorTerms returns the values of the bleve extractor field for a document whose text was
+// extracted by `extractors`. Each extractor's name and its name/version are indexed as keywords so
+// that queries such as `extractor:tesseract` and `extractor:"unidoc/3.0.0"` match and the field
+// can be used as a search facet.
+func extractorTerms(extractors []Extractor) []string {
+	var terms []string
+	for _, e := range extractors {
+		terms = append(terms, e.Name)
+		if e.Version != "" {
+			terms = append(terms, e.String())
+		}
+	}
+	return terms
+}
+
+// addExtractor adds `e` to `extractors` if it is not already in it.
+func addExtractor(extractors []Extractor, e Extractor) []Extractor {
+	for _, x := range extractors {
+		if x == e {
+			return extractors
+		}
+	}
+	return append(extractors, e)
+}
+
+// Extractors returns the extractors that produced the text of `lDoc`. It is empty for documents
+// that were indexed before extractors were recorded.
+func (lDoc *Doc) Extractors() []Extractor {
+	if int(lDoc.docIdx) >= len(lDoc.lState.fileList) {
+		return nil
+	}
+	return lDoc.lState.fileList[lDoc.docIdx].Extractors
+}
@@ -0,0 +1,92 @@
+package doclib
+
+import (
+	"github.com/blevesearch/bleve"
+	"github.com/blevesearch/bleve/search"
+	"github.com/blevesearch/bleve/search/query"
+	"github.com/unidoc/unidoc/common"
+)
+
+// allTermsQuery returns a query that matches the pages in `index` that contain all the terms in
+// query string `term`. The terms are analyzed with the page text analyzer so stop words, which
+// are not indexed, are not required.
+// Queries with field scopes are parsed by makeQuery() which already supports the bleve query
+// string syntax for required terms, e.g. `+adobe +pdf`.
+// If `fuzziness` > 0 then words within `fuzziness` edits of each term also match. See
+// fuzzyTermQuery().
+func allTermsQuery(index bleve.Index, term string, fuzziness int) query.Query {
+	if hasFieldScope(term) {
+		return makeQuery(term)
+	}
+	terms := analyzeTerms(index, term)
+	if len(terms) == 0 {
+		return makeQuery(term)
+	}
+	conjuncts := make([]query.Query, len(terms))
+	for i, t := range terms {
+		conjuncts[i] = fuzzyTermQuery(t, fuzziness)
+	}
+	return bleve.NewConjunctionQuery(conjuncts...)
+}
+
+// analyzeTerms returns the unique terms that the page text analyzer of `index` produces for
+// `text`.
+func analyzeTerms(index bleve.Index, text string) []string {
+	m := index.Mapping()
+	analyzer := m.AnalyzerNamed(m.AnalyzerNameForPath(textField))
+	if analyzer == nil {
+		common.Log.Error("analyzeTerms: No analyzer for %q", textField)
+		return nil
+	}
+	seen := map[string]bool{}
+	var terms []string
+	for _, token := range analyzer.Analyze([]byte(text)) {
+		t := string(token.Term)
+		if !seen[t] {
+			seen[t] = true
+			terms = append(terms, t)
+		}
+	}
+	return terms
+}
+
+// nearHits returns the hits in `hits` where an occurrence of every matched term is within
+// `within` characters of occurrences of all the other matched terms. The distance between
+// occurrences is the difference of their start offsets in the page text.
+func nearHits(hits search.DocumentMatchCollection, within int) search.DocumentMatchCollection {
+	var near search.DocumentMatchCollection
+	for _, hit := range hits {
+		m, err := getMatch(hit)
+		if err != nil || !spansWithin(m.Spans, within) {
+			continue
+		}
+		near = append(near, hit)
+	}
+	common.Log.Debug("nearHits: within=%d %d of %d hits", within, len(near), len(hits))
+	return near
+}
+
+// spansWithin returns true if there is a range of `within` characters in which all the terms in
+// `spans` occur. `spans` must be sorted by Start, as they are by getMatch().
+func spansWithin(spans []TermSpan, within int) bool {
+	terms := map[string]bool{}
+	for _, s := range spans {
+		terms[s.Term] = true
+	}
+
+	// Slide a window over `spans`, keeping the smallest window ending at each span that contains
+	// as many terms as possible.
+	counts := map[string]int{}
+	lo := 0
+	for _, s := range spans {
+		counts[s.Term]++
+		for counts[spans[lo].Term] > 1 {
+			counts[spans[lo].Term]--
+			lo++
+		}
+		if len(counts) == len(terms) && int(s.Start-spans[lo].Start) <= within {
+			return true
+		}
+	}
+	return false
+}
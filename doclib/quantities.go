@@ -0,0 +1,372 @@
+package doclib
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/blevesearch/bleve"
+	"github.com/blevesearch/bleve/analysis"
+	"github.com/blevesearch/bleve/analysis/analyzer/custom"
+	"github.com/blevesearch/bleve/mapping"
+	"github.com/blevesearch/bleve/registry"
+	"github.com/blevesearch/bleve/search/query"
+)
+
+// Numbers and quantities with units, e.g. "1,000,000" and "3.5 mm", are indexed in canonical form
+// as well as as words. Their values are converted to the base unit of their dimension, e.g. metres
+// for lengths, so that "3.5 mm", "3.5mm" and "0.35 cm" are the same quantity.
+const (
+	// quantityTextField is the page text field with the canonical quantity tokens, e.g. "0.0035m".
+	quantityTextField = "text_quantity"
+	// quantityField is the field with the page's values in base units, {dimension: values}. The
+	// values of a dimension are in the numeric field quantityField.<dimension>, e.g. num.m.
+	quantityField = "num"
+	// numberDim is the dimension of numbers without units.
+	numberDim = "n"
+	// Names of the tokenizer that finds quantities and of the analyzer of quantityTextField.
+	quantityTokenizer = "pdfsearch_quantity"
+	quantityAnalyzer  = "pdfsearch_quantity"
+)
+
+// quantityUnit is a unit of measurement. A value in the unit is `factor` times the value in the
+// base unit of dimension `dim`.
+type quantityUnit struct {
+	dim    string
+	factor float64
+}
+
+// quantityUnits are the units that quantities are recognized with. They are case sensitive so
+// that e.g. mW and MW are different.
+var quantityUnits = map[string]quantityUnit{
+	// Lengths in metres.
+	"nm": {"m", 1e-9}, "µm": {"m", 1e-6}, "um": {"m", 1e-6}, "mm": {"m", 1e-3}, "cm": {"m", 1e-2},
+	"m": {"m", 1}, "km": {"m", 1e3}, "inch": {"m", 0.0254}, "inches": {"m", 0.0254},
+	"ft": {"m", 0.3048},
+	// Masses in kilograms.
+	"mg": {"kg", 1e-6}, "g": {"kg", 1e-3}, "kg": {"kg", 1}, "lb": {"kg", 0.45359237},
+	"lbs": {"kg", 0.45359237}, "oz": {"kg", 0.028349523125},
+	// Volumes in litres.
+	"ml": {"l", 1e-3}, "mL": {"l", 1e-3}, "l": {"l", 1}, "L": {"l", 1},
+	// Times in seconds.
+	"ms": {"s", 1e-3}, "s": {"s", 1}, "min": {"s", 60}, "h": {"s", 3600},
+	// Electrical units.
+	"mV": {"v", 1e-3}, "V": {"v", 1}, "kV": {"v", 1e3},
+	"mA": {"a", 1e-3}, "A": {"a", 1},
+	"mW": {"w", 1e-3}, "W": {"w", 1}, "kW": {"w", 1e3}, "MW": {"w", 1e6},
+	"Hz": {"hz", 1}, "kHz": {"hz", 1e3}, "MHz": {"hz", 1e6}, "GHz": {"hz", 1e9},
+	// Data sizes in bytes.
+	"kB": {"b", 1e3}, "KB": {"b", 1e3}, "MB": {"b", 1e6}, "GB": {"b", 1e9}, "TB": {"b", 1e12},
+	// Units that aren't converted.
+	"%": {"pct", 1}, "°C": {"degc", 1}, "°F": {"degf", 1},
+}
+
+// quantityRe matches numbers with an optional sign, thousands separators, decimal part and unit.
+// The unit may be separated from the number by a space. See findQuantities.
+var quantityRe = func() *regexp.Regexp {
+	units := make([]string, 0, len(quantityUnits))
+	for u := range quantityUnits {
+		units = append(units, regexp.QuoteMeta(u))
+	}
+	// Longer units first so that e.g. "mm" is matched rather than "m".
+	sort.Slice(units, func(i, j int) bool {
+		if len(units[i]) != len(units[j]) {
+			return len(units[i]) > len(units[j])
+		}
+		return units[i] < units[j]
+	})
+	return regexp.MustCompile(`([-−])?(\d{1,3}(?:,\d{3})+|\d+)(\.\d+)?(?:[ \x{00A0}]?(` +
+		strings.Join(units, "|") + `))?`)
+}()
+
+// quantity is a number or quantity in a text.
+type quantity struct {
+	start, end int     // Offsets of the quantity in the text.
+	value      float64 // Value in the base unit of `dim`.
+	dim        string  // Dimension of the quantity. numberDim for numbers without units.
+}
+
+// term returns the canonical token of `q`. e.g. "0.0035m" for 3.5 mm and "1000000" for 1,000,000.
+func (q quantity) term() string {
+	if q.dim == numberDim {
+		return formatQuantity(q.value)
+	}
+	return formatQuantity(q.value) + q.dim
+}
+
+// findQuantities returns the numbers and quantities in `text`. Numbers that are part of words or
+// of dotted sequences, e.g. "A4" or "1.2.3", aren't quantities. A unit that is followed by a
+// letter or digit isn't a unit, so "5 meters" is the number 5.
+func findQuantities(text string) []quantity {
+	var quantities []quantity
+	for _, m := range quantityRe.FindAllStringSubmatchIndex(text, -1) {
+		start, numStart, numEnd := m[0], m[4], m[5]
+		if m[7] >= 0 {
+			numEnd = m[7]
+		}
+		negative := m[2] >= 0
+		if negative && !quantityBoundaryBefore(text, start) {
+			negative, start = false, numStart
+		}
+		if !quantityBoundaryBefore(text, start) {
+			continue
+		}
+		unit, end := "", numEnd
+		if m[8] >= 0 && quantityBoundaryAfter(text, m[9]) {
+			unit, end = text[m[8]:m[9]], m[9]
+		} else if !quantityBoundaryAfter(text, numEnd) {
+			continue
+		}
+		value, err := strconv.ParseFloat(strings.Replace(text[numStart:numEnd], ",", "", -1), 64)
+		if err != nil {
+			continue
+		}
+		if negative {
+			value = -value
+		}
+		q := quantity{start: start, end: end, value: value, dim: numberDim}
+		if unit != "" {
+			u := quantityUnits[unit]
+			q.value, q.dim = roundQuantity(value*u.factor), u.dim
+		}
+		quantities = append(quantities, q)
+	}
+	return quantities
+}
+
+// quantityBoundaryBefore returns true if a quantity can start at offset `i` in `text`.
+func quantityBoundaryBefore(text string, i int) bool {
+	r, _ := utf8.DecodeLastRuneInString(text[:i])
+	return i == 0 || !(unicode.IsLetter(r) || unicode.IsDigit(r) || r == '.' || r == '_')
+}
+
+// quantityBoundaryAfter returns true if a quantity can end at offset `i` in `text`. It can't end
+// before a letter or digit or before a "." that is followed by a digit, as in "1.2.3".
+func quantityBoundaryAfter(text string, i int) bool {
+	r, size := utf8.DecodeRuneInString(text[i:])
+	if r == '.' {
+		next, _ := utf8.DecodeRuneInString(text[i+size:])
+		return !unicode.IsDigit(next)
+	}
+	return i == len(text) || !(unicode.IsLetter(r) || unicode.IsDigit(r))
+}
+
+// roundQuantity returns `v` rounded to 12 significant digits so that unit conversions give the
+// same values, e.g. 3.5 * 0.001 is 0.0035.
+func roundQuantity(v float64) float64 {
+	r, err := strconv.ParseFloat(strconv.FormatFloat(v, 'g', 12, 64), 64)
+	if err != nil {
+		return v
+	}
+	return r
+}
+
+// formatQuantity returns `v` as a decimal number without an exponent.
+func formatQuantity(v float64) string {
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
+
+// pageQuantities returns the values of the numbers and quantities in page text `text` as
+// {dimension: values} for the quantityField of its bleve document, or nil if there are none.
+func pageQuantities(text string) map[string][]float64 {
+	quantities := findQuantities(text)
+	if len(quantities) == 0 {
+		return nil
+	}
+	values := map[string][]float64{}
+	for _, q := range quantities {
+		values[q.dim] = append(values[q.dim], q.value)
+	}
+	return values
+}
+
+func init() {
+	registry.RegisterTokenizer(quantityTokenizer,
+		func(config map[string]interface{}, cache *registry.Cache) (analysis.Tokenizer, error) {
+			return quantityTokens{}, nil
+		})
+}
+
+// quantityTokens is a bleve tokenizer whose tokens are the canonical forms of the quantities in
+// a text. See quantity.term.
+type quantityTokens struct{}
+
+// Tokenize returns the canonical tokens of the quantities in `input` with their offsets in
+// `input`.
+func (quantityTokens) Tokenize(input []byte) analysis.TokenStream {
+	var stream analysis.TokenStream
+	for i, q := range findQuantities(string(input)) {
+		stream = append(stream, &analysis.Token{
+			Term:     []byte(q.term()),
+			Start:    q.start,
+			End:      q.end,
+			Position: i + 1,
+			Type:     analysis.Numeric,
+		})
+	}
+	return stream
+}
+
+// addQuantityAnalyzer adds the analyzer of quantityTextField to `indexMapping`.
+func addQuantityAnalyzer(indexMapping *mapping.IndexMappingImpl) error {
+	return indexMapping.AddCustomAnalyzer(quantityAnalyzer, map[string]interface{}{
+		"type":          custom.Name,
+		"tokenizer":     quantityTokenizer,
+		"token_filters": []string{},
+	})
+}
+
+// quantityTextMapping returns the field mapping of quantityTextField. It has term vectors so
+// that matched quantities can be located on the page.
+func quantityTextMapping() *mapping.FieldMapping {
+	fm := bleve.NewTextFieldMapping()
+	fm.Name = quantityTextField
+	fm.Analyzer = quantityAnalyzer
+	fm.Store = false
+	fm.IncludeInAll = false
+	return fm
+}
+
+// quantityMapping returns the document mapping of quantityField, which has a numeric field for
+// each dimension.
+func quantityMapping() *mapping.DocumentMapping {
+	dims := map[string]bool{numberDim: true}
+	for _, u := range quantityUnits {
+		dims[u.dim] = true
+	}
+	dm := bleve.NewDocumentMapping()
+	for dim := range dims {
+		fm := bleve.NewNumericFieldMapping()
+		fm.Store = false
+		fm.IncludeInAll = false
+		dm.AddFieldMappingsAt(dim, fm)
+	}
+	return dm
+}
+
+// quantityQuery returns `q`, the query for query string `term`, extended to also match the pages
+// with the quantities in `term` in any unit, e.g. "0.35 cm" for "3.5 mm". Queries without
+// quantities and with field scopes are returned unchanged.
+func quantityQuery(q query.Query, term string) query.Query {
+	if hasFieldScope(term) || len(findQuantities(term)) == 0 {
+		return q
+	}
+	mq := bleve.NewMatchQuery(term)
+	mq.SetField(quantityTextField)
+	mq.Analyzer = quantityAnalyzer
+	return bleve.NewDisjunctionQuery(q, mq)
+}
+
+// QuantityRange is a range of values of a quantity, e.g. 2 to 5 mm. See SearchOptions.Quantity.
+type QuantityRange struct {
+	Unit string   // Unit of Min and Max, e.g. "mm" or "kg". "" for numbers without units.
+	Min  *float64 // Smallest value in the range. nil if the range has no lower bound.
+	Max  *float64 // Largest value in the range. nil if the range has no upper bound.
+}
+
+// ParseQuantityRange returns the QuantityRange in `s`, which has the form <min>..<max> <unit>.
+// Either bound may be omitted. e.g. "2..5 mm", "..1.5kg", "1,000..". The unit may also be given
+// with the bounds, e.g. "2mm..5mm".
+func ParseQuantityRange(s string) (*QuantityRange, error) {
+	i := strings.Index(s, "..")
+	if i < 0 {
+		return nil, fmt.Errorf("Bad quantity range %q. Use <min>..<max> <unit>, e.g. 2..5 mm", s)
+	}
+	min, minUnit, err := parseQuantityBound(s[:i])
+	if err != nil {
+		return nil, err
+	}
+	max, maxUnit, err := parseQuantityBound(s[i+2:])
+	if err != nil {
+		return nil, err
+	}
+	if minUnit != "" && maxUnit != "" && minUnit != maxUnit {
+		return nil, fmt.Errorf("Quantity range %q has different units", s)
+	}
+	r := &QuantityRange{Unit: maxUnit, Min: min, Max: max}
+	if r.Unit == "" {
+		r.Unit = minUnit
+	}
+	if err := r.check(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// quantityBoundRe matches a bound of a QuantityRange: a number and an optional unit.
+var quantityBoundRe = regexp.MustCompile(`^([-−]?[\d,]*\.?\d*)\s*(\S*)$`)
+
+// parseQuantityBound returns the value and unit of bound `s` of a QuantityRange. The value is nil
+// if `s` is empty.
+func parseQuantityBound(s string) (*float64, string, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return nil, "", nil
+	}
+	groups := quantityBoundRe.FindStringSubmatch(s)
+	if groups == nil || groups[1] == "" {
+		return nil, "", fmt.Errorf("Bad quantity %q", s)
+	}
+	num := strings.Replace(strings.Replace(groups[1], ",", "", -1), "−", "-", 1)
+	v, err := strconv.ParseFloat(num, 64)
+	if err != nil {
+		return nil, "", fmt.Errorf("Bad quantity %q. err=%v", s, err)
+	}
+	return &v, groups[2], nil
+}
+
+// String returns `r` in the format that ParseQuantityRange parses.
+func (r QuantityRange) String() string {
+	var sb strings.Builder
+	if r.Min != nil {
+		sb.WriteString(formatQuantity(*r.Min))
+	}
+	sb.WriteString("..")
+	if r.Max != nil {
+		sb.WriteString(formatQuantity(*r.Max))
+	}
+	if r.Unit != "" {
+		sb.WriteString(" " + r.Unit)
+	}
+	return sb.String()
+}
+
+// check returns an error if `r` isn't a valid range.
+func (r QuantityRange) check() error {
+	if _, ok := quantityUnits[r.Unit]; !ok && r.Unit != "" {
+		return fmt.Errorf("Unknown unit %q in quantity range", r.Unit)
+	}
+	if r.Min == nil && r.Max == nil {
+		return fmt.Errorf("Quantity range %s has no bounds", r)
+	}
+	if r.Min != nil && r.Max != nil && *r.Min > *r.Max {
+		return fmt.Errorf("Empty quantity range %s", r)
+	}
+	return nil
+}
+
+// quantityRangeQuery returns `q` restricted to the pages with a quantity in range `r` in any unit
+// of the same dimension.
+func quantityRangeQuery(q query.Query, r QuantityRange) query.Query {
+	u, ok := quantityUnits[r.Unit]
+	if !ok {
+		u = quantityUnit{dim: numberDim, factor: 1}
+	}
+	var min, max *float64
+	if r.Min != nil {
+		v := roundQuantity(*r.Min * u.factor)
+		min = &v
+	}
+	if r.Max != nil {
+		v := roundQuantity(*r.Max * u.factor)
+		max = &v
+	}
+	inclusive := true
+	rangeQ := bleve.NewNumericRangeInclusiveQuery(min, max, &inclusive, &inclusive)
+	rangeQ.SetField(quantityField + "." + u.dim)
+	return bleve.NewConjunctionQuery(q, rangeQ)
+}
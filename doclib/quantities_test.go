@@ -0,0 +1,68 @@
+package doclib
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestFindQuantities(t *testing.T) {
+	tests := map[string][]string{
+		"a 3.5 mm bolt":               {"0.0035m"},
+		"a 3.5mm bolt":                {"0.0035m"},
+		"0.35 cm":                     {"0.0035m"},
+		"1,000,000 units":             {"1000000"},
+		"12 kg and 500 g":             {"12kg", "0.5kg"},
+		"5 meters":                    {"5"},
+		"-40 °C":                      {"-40degc"},
+		"pages 1,2,3":                 {"1", "2", "3"},
+		"version 1.2.3 of A4 paper":   nil,
+		"3.5mmx":                      nil,
+		"2.4 GHz at 10% load, 1.2 kV": {"2400000000hz", "10pct", "1200v"},
+		"no numbers":                  nil,
+	}
+	for text, expected := range tests {
+		var terms []string
+		for _, q := range findQuantities(text) {
+			terms = append(terms, q.term())
+		}
+		if !reflect.DeepEqual(terms, expected) {
+			t.Errorf("findQuantities(%q)=%q expected=%q", text, terms, expected)
+		}
+	}
+}
+
+func TestQuantityOffsets(t *testing.T) {
+	text := "a 3.5 mm bolt"
+	quantities := findQuantities(text)
+	if len(quantities) != 1 {
+		t.Fatalf("findQuantities(%q)=%+v", text, quantities)
+	}
+	if got := text[quantities[0].start:quantities[0].end]; got != "3.5 mm" {
+		t.Errorf("quantity=%q expected=%q", got, "3.5 mm")
+	}
+}
+
+func TestParseQuantityRange(t *testing.T) {
+	tests := map[string]string{
+		"2..5 mm":   "2..5 mm",
+		"2mm..5mm":  "2..5 mm",
+		"..1.5kg":   "..1.5 kg",
+		"1,000..":   "1000..",
+		" -3 .. 4 ": "-3..4",
+	}
+	for s, expected := range tests {
+		r, err := ParseQuantityRange(s)
+		if err != nil {
+			t.Errorf("ParseQuantityRange(%q) err=%v", s, err)
+			continue
+		}
+		if got := r.String(); got != expected {
+			t.Errorf("ParseQuantityRange(%q)=%q expected=%q", s, got, expected)
+		}
+	}
+	for _, s := range []string{"", "5 mm", "..", "2mm..5kg", "5..2", "2..5 furlongs", "x..5"} {
+		if _, err := ParseQuantityRange(s); err == nil {
+			t.Errorf("ParseQuantityRange(%q) succeeded. Expected an error", s)
+		}
+	}
+}
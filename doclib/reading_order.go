@@ -0,0 +1,244 @@
+package doclib
+
+import (
+	"math"
+	"sort"
+	"strings"
+
+	"github.com/peterwilliams97/pdf-search/serial"
+)
+
+const (
+	// columnGapFactor is the smallest horizontal gap, as a multiple of the text height, between
+	// characters on a line that is treated as a gap between columns. Word spaces are much smaller.
+	columnGapFactor = 1.5
+	// spanningFraction is the fraction of the width of a page's text above which a line segment is
+	// treated as spanning the columns, e.g. a title or a full width figure caption.
+	spanningFraction = 0.6
+	// minColumnSegments is the number of line segments a column needs for a page to be treated as
+	// having several columns.
+	minColumnSegments = 3
+)
+
+// textSegment is a run of a page text that is one line of one column, or a line with no text
+// locations.
+type textSegment struct {
+	start, end uint32              // Offsets of the segment in the page text.
+	box        serial.TextLocation // Bounding box of the segment's characters.
+	hasBox     bool                // Some of the segment's characters have boxes.
+	spanning   bool                // The segment spans the page's columns.
+	column     int                 // Index of the segment's column if it isn't spanning.
+	band       int                 // Number of spanning segments above the segment.
+	order      int                 // Index of the segment in the page text.
+}
+
+// centerY returns the vertical center of `seg`.
+func (seg textSegment) centerY() float64 {
+	return float64(seg.box.Lly+seg.box.Ury) / 2
+}
+
+// readingOrder returns page text `text` and its text locations `locs` rearranged into reading
+// order. Extractors that read pages in content stream order can interleave the lines of
+// multi-column pages, which breaks phrase searches and line numbers.
+// The page is split into line segments at gaps that are too wide to be word spaces. Segments are
+// clustered into columns by their x ranges. The columns are read left to right, each from top to
+// bottom, and the segments that span the columns, such as titles, separate bands of columns.
+// Each segment is a line of the returned text. Pages that don't have several columns are returned
+// unchanged.
+func readingOrder(text string, locs []serial.TextLocation) (string, []serial.TextLocation) {
+	sorted := make([]serial.TextLocation, len(locs))
+	copy(sorted, locs)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].Start < sorted[j].Start })
+
+	segments := lineSegments(text, sorted)
+	if !findColumns(segments) {
+		return text, locs
+	}
+	findBands(segments)
+	ordered := make([]textSegment, len(segments))
+	copy(ordered, segments)
+	sort.SliceStable(ordered, func(i, j int) bool { return readsBefore(ordered[i], ordered[j]) })
+
+	// Build the text in reading order and move each location with its segment.
+	var b strings.Builder
+	var ordLocs []serial.TextLocation
+	for _, seg := range ordered {
+		segText := strings.TrimRight(text[seg.start:seg.end], " \t\r\n")
+		if segText == "" {
+			continue
+		}
+		if b.Len() > 0 {
+			b.WriteString("\n")
+		}
+		base := uint32(b.Len())
+		b.WriteString(segText)
+		segEnd := seg.start + uint32(len(segText))
+		i := sort.Search(len(sorted), func(i int) bool { return sorted[i].Start >= seg.start })
+		for ; i < len(sorted) && sorted[i].Start < segEnd; i++ {
+			loc := sorted[i]
+			if loc.End > loc.Start {
+				if loc.End > segEnd {
+					loc.End = segEnd
+				}
+				loc.End = base + loc.End - seg.start
+			}
+			loc.Start = base + loc.Start - seg.start
+			ordLocs = append(ordLocs, loc)
+		}
+	}
+	sort.SliceStable(ordLocs, func(i, j int) bool { return ordLocs[i].Start < ordLocs[j].Start })
+	return b.String(), ordLocs
+}
+
+// lineSegments returns the segments of `text`, whose locations `locs` are sorted by offset. Lines
+// are split where the gap between successive characters is wider than columnGapFactor times the
+// text height or where the text moves left. Lines with no boxes are joined to the segment before
+// them.
+func lineSegments(text string, locs []serial.TextLocation) []textSegment {
+	var segments []textSegment
+	add := func(seg textSegment) {
+		if !seg.hasBox && len(segments) > 0 {
+			segments[len(segments)-1].end = seg.end
+			return
+		}
+		seg.order = len(segments)
+		segments = append(segments, seg)
+	}
+
+	i := 0
+	for lineStart := 0; lineStart < len(text); {
+		lineEnd := len(text)
+		if n := strings.IndexByte(text[lineStart:], '\n'); n >= 0 {
+			lineEnd = lineStart + n + 1
+		}
+		seg := textSegment{start: uint32(lineStart)}
+		var prev serial.TextLocation
+		for ; i < len(locs) && locs[i].Start < uint32(lineEnd); i++ {
+			loc := locs[i]
+			if loc.Start < uint32(lineStart) || loc.Ury <= loc.Lly {
+				continue
+			}
+			if seg.hasBox {
+				height := math.Max(float64(loc.Ury-loc.Lly), float64(prev.Ury-prev.Lly))
+				gap := float64(loc.Llx - prev.Urx)
+				if gap > columnGapFactor*height || loc.Urx < prev.Llx {
+					seg.end = loc.Start
+					add(seg)
+					seg = textSegment{start: loc.Start}
+				}
+			}
+			if seg.hasBox {
+				seg.box = unionLocation(seg.box, loc)
+			} else {
+				seg.box, seg.hasBox = loc, true
+			}
+			prev = loc
+		}
+		seg.end = uint32(lineEnd)
+		add(seg)
+		lineStart = lineEnd
+	}
+	return segments
+}
+
+// findColumns sets the column or spanning field of each of `segments`. It returns true if the
+// segments are in several columns.
+func findColumns(segments []textSegment) bool {
+	var llx, urx float32
+	for i, seg := range segments {
+		if i == 0 || seg.box.Llx < llx {
+			llx = seg.box.Llx
+		}
+		if i == 0 || seg.box.Urx > urx {
+			urx = seg.box.Urx
+		}
+	}
+	width := urx - llx
+
+	// Cluster the x ranges of the segments that don't span the page.
+	type xRange struct{ llx, urx float32 }
+	var ranges []xRange
+	for i := range segments {
+		seg := &segments[i]
+		seg.spanning = !seg.hasBox || seg.box.Urx-seg.box.Llx > spanningFraction*width
+		if !seg.spanning {
+			ranges = append(ranges, xRange{seg.box.Llx, seg.box.Urx})
+		}
+	}
+	sort.Slice(ranges, func(i, j int) bool { return ranges[i].llx < ranges[j].llx })
+	var columns []xRange
+	for _, r := range ranges {
+		if n := len(columns); n > 0 && r.llx <= columns[n-1].urx {
+			if r.urx > columns[n-1].urx {
+				columns[n-1].urx = r.urx
+			}
+			continue
+		}
+		columns = append(columns, r)
+	}
+	if len(columns) < 2 {
+		return false
+	}
+
+	counts := make([]int, len(columns))
+	for i := range segments {
+		seg := &segments[i]
+		if seg.spanning {
+			continue
+		}
+		seg.column = sort.Search(len(columns), func(c int) bool {
+			return columns[c].urx >= seg.box.Llx
+		})
+		counts[seg.column]++
+	}
+	big := 0
+	for _, n := range counts {
+		if n >= minColumnSegments {
+			big++
+		}
+	}
+	return big >= 2
+}
+
+// findBands sets the band of each of `segments`. The spanning segments divide a page into
+// horizontal bands that are read one after the other. A segment with no box comes first.
+func findBands(segments []textSegment) {
+	var spanY []float64
+	for _, seg := range segments {
+		if seg.spanning && seg.hasBox {
+			spanY = append(spanY, seg.centerY())
+		}
+	}
+	for i := range segments {
+		seg := &segments[i]
+		if !seg.hasBox {
+			seg.band = -1
+			continue
+		}
+		for _, y := range spanY {
+			if y > seg.centerY() {
+				seg.band++
+			}
+		}
+	}
+}
+
+// readsBefore returns true if segment `a` is read before segment `b`. Bands are read from top to
+// bottom. Within a band, columns are read left to right and each column from top to bottom,
+// followed by the spanning segment below them.
+func readsBefore(a, b textSegment) bool {
+	if a.band != b.band {
+		return a.band < b.band
+	}
+	if a.spanning != b.spanning {
+		return b.spanning
+	}
+	if a.column != b.column && !a.spanning {
+		return a.column < b.column
+	}
+	ya, yb := math.Round(a.centerY()), math.Round(b.centerY())
+	if ya != yb {
+		return ya > yb
+	}
+	return a.order < b.order
+}
@@ -0,0 +1,106 @@
+package doclib
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/peterwilliams97/pdf-search/serial"
+)
+
+// textRun is a run of text that starts at (x, y) on a page.
+type textRun struct {
+	text string
+	x, y float32
+}
+
+// makePage returns the text and character locations that an extractor that reads `lines` in
+// order would return. The runs on each line are separated by spaces. Characters are 6 points wide
+// and 10 points high. Spaces have no location.
+func makePage(lines [][]textRun) (string, []serial.TextLocation) {
+	var b strings.Builder
+	var locs []serial.TextLocation
+	for i, line := range lines {
+		if i > 0 {
+			b.WriteString("\n")
+		}
+		for j, run := range line {
+			if j > 0 {
+				b.WriteString(" ")
+			}
+			for k, c := range run.text {
+				if c != ' ' {
+					x := run.x + 6*float32(k)
+					locs = append(locs, serial.TextLocation{Start: uint32(b.Len()), Llx: x,
+						Lly: run.y, Urx: x + 6, Ury: run.y + 10})
+				}
+				b.WriteRune(c)
+			}
+		}
+	}
+	return b.String(), locs
+}
+
+func TestReadingOrderColumns(t *testing.T) {
+	// A title, two columns whose lines are interleaved by the extractor and a footer.
+	text, locs := makePage([][]textRun{
+		{{"A title that spans both of the columns", 50, 700}},
+		{{"left line 1", 50, 680}, {"right line 1", 160, 680}},
+		{{"left line 2", 50, 668}, {"right line 2", 160, 668}},
+		{{"left line 3", 50, 656}, {"right line 3", 160, 656}},
+		{{"A footer that spans both of the columns", 50, 40}},
+	})
+	ordText, ordLocs := readingOrder(text, locs)
+	expected := strings.Join([]string{
+		"A title that spans both of the columns",
+		"left line 1",
+		"left line 2",
+		"left line 3",
+		"right line 1",
+		"right line 2",
+		"right line 3",
+		"A footer that spans both of the columns",
+	}, "\n")
+	if ordText != expected {
+		t.Fatalf("text=\n%s\nexpected=\n%s", ordText, expected)
+	}
+	if len(ordLocs) != len(locs) {
+		t.Fatalf("locations=%d expected=%d", len(ordLocs), len(locs))
+	}
+	// Each character keeps its box.
+	for i, loc := range ordLocs {
+		if i > 0 && loc.Start <= ordLocs[i-1].Start {
+			t.Fatalf("locations out of order: %s %s", ordLocs[i-1], loc)
+		}
+	}
+	ofs := uint32(strings.Index(ordText, "right line 1"))
+	loc := ordLocs[locationIndex(ordLocs, ofs)]
+	if loc.Start != ofs || loc.Llx != 160 || loc.Lly != 680 {
+		t.Errorf("'r' of right line 1: %s", loc)
+	}
+}
+
+func TestReadingOrderSingleColumn(t *testing.T) {
+	text, locs := makePage([][]textRun{
+		{{"The first line of a page", 50, 700}},
+		{{"  indented", 50, 688}},
+		{{"The last line", 50, 676}},
+		{{"17", 300, 40}},
+	})
+	ordText, ordLocs := readingOrder(text, locs)
+	if ordText != text || len(ordLocs) != len(locs) {
+		t.Fatalf("single column page changed:\n%s", ordText)
+	}
+	if ordText, _ := readingOrder("", nil); ordText != "" {
+		t.Errorf("empty page: %q", ordText)
+	}
+}
+
+// locationIndex returns the index of the location in `locs` that starts at `offset`.
+func locationIndex(locs []serial.TextLocation, offset uint32) int {
+	for i, loc := range locs {
+		if loc.Start == offset {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,323 @@
+package doclib
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"regexp/syntax"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/blevesearch/bleve"
+	"github.com/blevesearch/bleve/search/query"
+	"github.com/unidoc/unidoc/common"
+)
+
+// RegexOptions control RegexSearch.
+type RegexOptions struct {
+	MaxResults int // Maximum number of matching pages to return. 10 if it is 0.
+	// UseIndex makes RegexSearch only scan the pages whose indexed terms contain the literal text
+	// that every match of the pattern must have, e.g. "acme" in `ACME-\d{4}-[A-Z]`. This is much
+	// faster for big stores but misses matches whose literal text is only in words that aren't
+	// indexed, such as the stop words "the" and "other".
+	UseIndex bool
+	// NumWorkers is the number of goroutines that scan page texts. runtime.NumCPU() if it is 0.
+	NumWorkers int
+}
+
+// regexBatchSize is the number of candidate pages fetched from the bleve index per request.
+const regexBatchSize = 1000
+
+// minRegexLiteral is the shortest literal text of a pattern that RegexOptions.UseIndex looks up.
+// Shorter texts are in too many terms to narrow the search.
+const minRegexLiteral = 3
+
+// RegexSearch returns the pages in `lState` whose stored text matches the Go regular expression
+// `pattern`, for patterns such as part numbers and serial formats that token searches can't
+// express. Each PdfMatch is a page, in store order, with a Span and Position for each match of
+// `pattern` on the page. Pages with the same text as an earlier page in their PDF are reported
+// with that page, as they are by searches. PdfMatchSet.TotalMatches is the number of matching
+// pages. The page texts are scanned concurrently. See RegexOptions.
+func RegexSearch(ctx context.Context, lState *Store, index bleve.Index, pattern string,
+	opts RegexOptions) (PdfMatchSet, error) {
+
+	t0 := time.Now()
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return PdfMatchSet{}, fmt.Errorf("Bad regular expression %q. err=%v", pattern, err)
+	}
+	if opts.MaxResults <= 0 {
+		opts.MaxResults = 10
+	}
+	if opts.NumWorkers <= 0 {
+		opts.NumWorkers = runtime.NumCPU()
+	}
+
+	// candidates are {docIdx: pages to scan}. A nil list means all the document's pages.
+	candidates := map[uint64][]uint32{}
+	var lits []string
+	if opts.UseIndex {
+		lits = regexLiterals(index, pattern)
+	}
+	if len(lits) > 0 {
+		if candidates, err = regexCandidates(ctx, index, lits); err != nil {
+			return PdfMatchSet{}, err
+		}
+	} else {
+		for docIdx := 0; docIdx < lState.Len(); docIdx++ {
+			candidates[uint64(docIdx)] = nil
+		}
+	}
+	common.Log.Debug("RegexSearch: pattern=%q literals=%q docs=%d", pattern, lits,
+		len(candidates))
+
+	docIdxs := make([]uint64, 0, len(candidates))
+	for docIdx := range candidates {
+		docIdxs = append(docIdxs, docIdx)
+	}
+	work := make(chan uint64)
+	var mu sync.Mutex
+	var matches []match
+	var firstErr error
+	var wg sync.WaitGroup
+	for w := 0; w < opts.NumWorkers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for docIdx := range work {
+				ms, err := lState.regexScanDoc(re, docIdx, candidates[docIdx])
+				mu.Lock()
+				if err != nil && firstErr == nil {
+					firstErr = err
+				}
+				matches = append(matches, ms...)
+				mu.Unlock()
+			}
+		}()
+	}
+	for _, docIdx := range docIdxs {
+		if ctx.Err() != nil {
+			break
+		}
+		work <- docIdx
+	}
+	close(work)
+	wg.Wait()
+	if err := ctx.Err(); err != nil {
+		return PdfMatchSet{}, err
+	}
+	if firstErr != nil {
+		return PdfMatchSet{}, firstErr
+	}
+
+	sort.Slice(matches, func(i, j int) bool {
+		if matches[i].docIdx != matches[j].docIdx {
+			return matches[i].docIdx < matches[j].docIdx
+		}
+		return matches[i].pageIdx < matches[j].pageIdx
+	})
+	p := PdfMatchSet{TotalMatches: len(matches), IndexDuration: lState.indexDuration}
+	if len(matches) > opts.MaxResults {
+		matches = matches[:opts.MaxResults]
+	}
+	for _, m := range matches {
+		lDoc, err := lState.OpenPositionsDoc(m.docIdx)
+		if err != nil {
+			return p, err
+		}
+		pm, err := getDocPdfMatch(lDoc, m)
+		lDoc.Close()
+		if err != nil {
+			return p, err
+		}
+		p.Matches = append(p.Matches, pm)
+	}
+	p.SearchDuration = time.Since(t0)
+	return p, nil
+}
+
+// RegexSearch returns the pages in `x` whose text matches the regular expression `pattern`. See
+// RegexSearch.
+func (x *PdfIndex) RegexSearch(ctx context.Context, pattern string, opts RegexOptions) (
+	PdfMatchSet, error) {
+
+	if err := x.refresh(); err != nil {
+		return PdfMatchSet{}, err
+	}
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+	if x.index == nil {
+		return PdfMatchSet{}, ErrClosed
+	}
+	return RegexSearch(ctx, x.lState, x.index, pattern, opts)
+}
+
+// regexScanDoc returns a match for each page of document `docIdx` in `lState` whose text matches
+// `re`. Only the pages in `pageIdxs` are scanned, or all the pages if it is nil. Pages with the
+// same text as an earlier page are skipped.
+func (lState *Store) regexScanDoc(re *regexp.Regexp, docIdx uint64,
+	pageIdxs []uint32) ([]match, error) {
+
+	lDoc, err := lState.OpenPositionsDoc(docIdx)
+	if err != nil {
+		return nil, err
+	}
+	defer lDoc.Close()
+	texts := make([]string, lDoc.Len())
+	for i := range texts {
+		if texts[i], err = lDoc.ReadPageText(uint32(i)); err != nil {
+			return nil, err
+		}
+	}
+	repeats := pageRepeats(texts)
+	if pageIdxs == nil {
+		for i := range texts {
+			pageIdxs = append(pageIdxs, uint32(i))
+		}
+	}
+	var matches []match
+	for _, pageIdx := range pageIdxs {
+		if int(pageIdx) >= len(texts) || repeats[pageIdx] == 0 {
+			continue
+		}
+		locs := re.FindAllStringIndex(texts[pageIdx], -1)
+		if len(locs) == 0 {
+			continue
+		}
+		m := match{
+			docIdx:   docIdx,
+			pageIdx:  pageIdx,
+			Score:    float64(len(locs)),
+			Start:    uint32(locs[0][0]),
+			End:      uint32(locs[0][1]),
+			repeats:  repeats[pageIdx],
+			formIdx:  -1,
+			annotIdx: -1,
+		}
+		for _, loc := range locs {
+			m.Spans = append(m.Spans, TermSpan{
+				Term:  texts[pageIdx][loc[0]:loc[1]],
+				Start: uint32(loc[0]),
+				End:   uint32(loc[1]),
+			})
+		}
+		m.Fragment = getSnippet(texts[pageIdx], m.Start, m.End)
+		matches = append(matches, m)
+	}
+	return matches, nil
+}
+
+// regexCandidates returns the pages in `index` whose page text has terms that contain all of
+// `lits` as {docIdx: page indexes}.
+func regexCandidates(ctx context.Context, index bleve.Index, lits []string) (map[uint64][]uint32,
+	error) {
+
+	var queries []query.Query
+	for _, lit := range lits {
+		q := bleve.NewWildcardQuery("*" + lit + "*")
+		q.SetField(textField)
+		queries = append(queries, q)
+	}
+	candidates := map[uint64][]uint32{}
+	for from := 0; ; from += regexBatchSize {
+		req := bleve.NewSearchRequestOptions(bleve.NewConjunctionQuery(queries...),
+			regexBatchSize, from, false)
+		sr, err := index.SearchInContext(ctx, req)
+		if err != nil {
+			return nil, err
+		}
+		for _, hit := range sr.Hits {
+			docIdx, pageIdx, err := decodeID(hit.ID)
+			if err != nil {
+				return nil, err
+			}
+			candidates[docIdx] = append(candidates[docIdx], pageIdx)
+		}
+		if len(sr.Hits) < regexBatchSize {
+			return candidates, nil
+		}
+	}
+}
+
+// regexLiterals returns the lower cased runs of letters and digits in the literal text that every
+// match of regular expression `pattern` contains, which are the same as the terms `index` would
+// make of them. Runs that are shorter than minRegexLiteral or that the analyzer of `index` drops
+// or splits, such as stop words, aren't returned.
+func regexLiterals(index bleve.Index, pattern string) []string {
+	re, err := syntax.Parse(pattern, syntax.Perl)
+	if err != nil {
+		return nil
+	}
+	m := index.Mapping()
+	analyzer := m.AnalyzerNamed(m.AnalyzerNameForPath(textField))
+	var lits []string
+	for _, run := range literalRuns(requiredLiterals(re.Simplify())) {
+		if len(run) < minRegexLiteral {
+			continue
+		}
+		if analyzer != nil {
+			tokens := analyzer.Analyze([]byte(run))
+			if len(tokens) != 1 || string(tokens[0].Term) != run {
+				continue
+			}
+		}
+		lits = append(lits, run)
+	}
+	return lits
+}
+
+// requiredLiterals returns the literal strings that every match of `re` contains.
+func requiredLiterals(re *syntax.Regexp) []string {
+	switch re.Op {
+	case syntax.OpLiteral:
+		return []string{string(re.Rune)}
+	case syntax.OpCapture, syntax.OpPlus:
+		return requiredLiterals(re.Sub[0])
+	case syntax.OpRepeat:
+		if re.Min >= 1 {
+			return requiredLiterals(re.Sub[0])
+		}
+	case syntax.OpConcat:
+		// Adjacent literals are joined so that they can be matched as one string.
+		var lits []string
+		cur := ""
+		for _, sub := range re.Sub {
+			if sub.Op == syntax.OpLiteral {
+				cur += string(sub.Rune)
+				continue
+			}
+			if cur != "" {
+				lits = append(lits, cur)
+				cur = ""
+			}
+			lits = append(lits, requiredLiterals(sub)...)
+		}
+		if cur != "" {
+			lits = append(lits, cur)
+		}
+		return lits
+	}
+	return nil
+}
+
+// literalRuns returns the distinct lower cased runs of letters and digits in `lits`.
+func literalRuns(lits []string) []string {
+	seen := map[string]bool{}
+	var runs []string
+	for _, lit := range lits {
+		fields := strings.FieldsFunc(strings.ToLower(lit), func(r rune) bool {
+			return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+		})
+		for _, run := range fields {
+			if !seen[run] {
+				seen[run] = true
+				runs = append(runs, run)
+			}
+		}
+	}
+	return runs
+}
@@ -0,0 +1,30 @@
+package doclib
+
+import (
+	"reflect"
+	"regexp/syntax"
+	"testing"
+)
+
+func TestRegexLiterals(t *testing.T) {
+	tests := map[string][]string{
+		`ACME-\d{4}-[A-Z]`:    {"acme"},
+		`Part (No|Number)`:    {"part", "n"},
+		`invoice\s+#?\d+ due`: {"invoice", "due"},
+		`serial: [0-9]+x`:     {"serial", "x"},
+		`(abc)+def`:           {"abc", "def"},
+		`(abc)*def`:           {"def"},
+		`foo|bar`:             nil,
+		`\d+`:                 nil,
+	}
+	for pattern, expected := range tests {
+		re, err := syntax.Parse(pattern, syntax.Perl)
+		if err != nil {
+			t.Fatalf("Could not parse %q. err=%v", pattern, err)
+		}
+		got := literalRuns(requiredLiterals(re.Simplify()))
+		if !reflect.DeepEqual(got, expected) {
+			t.Errorf("%q: literals=%q expected=%q", pattern, got, expected)
+		}
+	}
+}
@@ -0,0 +1,139 @@
+package doclib
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"strconv"
+
+	"github.com/peterwilliams97/pdf-search/serial"
+)
+
+// ResultRecord is a PdfMatch in the export schema of PdfMatchSet.MarshalJSON and
+// PdfMatchSet.WriteCSV. Fields are only added to the schema, never changed or removed, so that
+// downstream tools keep working.
+type ResultRecord struct {
+	File  string  `json:"file"`  // Path of the PDF.
+	Page  uint32  `json:"page"`  // Page number (1-offset).
+	Line  int     `json:"line"`  // Line number of the first matched term on the page.
+	Score float64 `json:"score"` // bleve score of the page.
+	// BBox is the bounding box [llx, lly, urx, ury] of the first matched term in PDF coordinates. It
+	// is nil if no box was found.
+	BBox     []float32 `json:"bbox"`
+	Fragment string    `json:"fragment"` // Highlighted fragment of the page text.
+	// Bookmark is the title of the PDF bookmark whose section contains the page. See
+	// PdfMatch.Bookmark.
+	Bookmark string `json:"bookmark,omitempty"`
+	// Field is the name of the PDF form field whose value matched. See PdfMatch.FormField.
+	Field string `json:"field,omitempty"`
+	// Annotation is the contents of the annotation that matched. See PdfMatch.Annotation.
+	Annotation string `json:"annotation,omitempty"`
+	// Cell and Row are the table cell that contains the match and the texts of the cells in its
+	// row. See PdfMatch.Table.
+	Cell string   `json:"cell,omitempty"`
+	Row  []string `json:"row,omitempty"`
+	// URI, Title and Tags are the PDF's DocLabels. See IndexOptions.Labels.
+	URI   string   `json:"uri,omitempty"`
+	Title string   `json:"title,omitempty"`
+	Tags  []string `json:"tags,omitempty"`
+	// Copies are the other pages with the same text whose matches were collapsed into this one.
+	// See PdfMatch.Copies.
+	Copies []CopyRecord `json:"copies,omitempty"`
+}
+
+// CopyRecord is a page in ResultRecord.Copies.
+type CopyRecord struct {
+	File string `json:"file"` // Path of the PDF.
+	Page uint32 `json:"page"` // Page number (1-offset).
+}
+
+// resultSetRecord is a PdfMatchSet in the export schema. See ResultRecord.
+type resultSetRecord struct {
+	Total      int            `json:"total"`
+	From       int            `json:"from"`
+	NextCursor string         `json:"nextCursor,omitempty"`
+	Matches    []ResultRecord `json:"matches"`
+}
+
+// csvHeader is the header row of PdfMatchSet.WriteCSV.
+var csvHeader = []string{"file", "page", "line", "score", "llx", "lly", "urx", "ury", "fragment"}
+
+// Records returns the matches in `s` as ResultRecords.
+func (s PdfMatchSet) Records() []ResultRecord {
+	records := make([]ResultRecord, 0, len(s.Matches))
+	for _, m := range s.Matches {
+		records = append(records, m.Record())
+	}
+	return records
+}
+
+// Record returns `m` as a ResultRecord.
+func (m PdfMatch) Record() ResultRecord {
+	r := ResultRecord{
+		File:       m.InPath,
+		Page:       m.PageNum,
+		Line:       m.LineNum,
+		Score:      m.Score,
+		Fragment:   m.Fragment,
+		Bookmark:   m.Bookmark,
+		Field:      m.FormField,
+		Annotation: m.Annotation,
+		URI:        m.URI,
+		Title:      m.Title,
+		Tags:       m.Tags,
+	}
+	for _, c := range m.Copies {
+		r.Copies = append(r.Copies, CopyRecord{File: c.InPath, Page: c.PageNum})
+	}
+	if m.Table != nil {
+		r.Cell = m.Table.Cell.Text
+		r.Row = m.Table.RowCells
+	}
+	for _, pos := range m.Positions {
+		if pos != (serial.TextLocation{}) {
+			r.BBox = []float32{pos.Llx, pos.Lly, pos.Urx, pos.Ury}
+			break
+		}
+	}
+	return r
+}
+
+// MarshalJSON returns `s` as a JSON object with the stable schema
+// {"total": <n>, "from": <n>, "nextCursor": <cursor>, "matches": [<ResultRecord>, ...]}.
+// It omits the timings and markup fields of `s`. The HTTP protocol carries all the fields.
+func (s PdfMatchSet) MarshalJSON() ([]byte, error) {
+	return json.Marshal(resultSetRecord{
+		Total:      s.TotalMatches,
+		From:       s.From,
+		NextCursor: s.NextCursor,
+		Matches:    s.Records(),
+	})
+}
+
+// WriteCSV writes the matches in `s` to `w` as CSV with a header row. The columns are the fields of
+// ResultRecord with BBox split into llx, lly, urx and ury, which are empty if there is no box.
+func (s PdfMatchSet) WriteCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(csvHeader); err != nil {
+		return err
+	}
+	for _, r := range s.Records() {
+		row := []string{
+			r.File,
+			strconv.Itoa(int(r.Page)),
+			strconv.Itoa(r.Line),
+			strconv.FormatFloat(r.Score, 'g', -1, 64),
+		}
+		box := make([]string, 4)
+		for i, v := range r.BBox {
+			box[i] = strconv.FormatFloat(float64(v), 'g', -1, 32)
+		}
+		row = append(row, box...)
+		row = append(row, r.Fragment)
+		if err := cw.Write(row); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
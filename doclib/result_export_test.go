@@ -0,0 +1,49 @@
+package doclib
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/peterwilliams97/pdf-search/serial"
+)
+
+func exportTestResults() PdfMatchSet {
+	var s PdfMatchSet
+	s.TotalMatches = 5
+	s.Matches = []PdfMatch{
+		{InPath: "a.pdf", PageNum: 3, LineNum: 7, Positions: []serial.TextLocation{
+			{}, {Llx: 72, Lly: 700, Urx: 100.5, Ury: 724}}},
+		{InPath: "b, \"c\".pdf", PageNum: 1},
+	}
+	s.Matches[0].Score = 1.5
+	s.Matches[0].Fragment = "the <mark>fox</mark>"
+	return s
+}
+
+func TestPdfMatchSetMarshalJSON(t *testing.T) {
+	b, err := json.Marshal(exportTestResults())
+	if err != nil {
+		t.Fatalf("err=%v", err)
+	}
+	expected := `{"total":5,"from":0,"matches":[` +
+		`{"file":"a.pdf","page":3,"line":7,"score":1.5,"bbox":[72,700,100.5,724],` +
+		`"fragment":"the \u003cmark\u003efox\u003c/mark\u003e"},` +
+		`{"file":"b, \"c\".pdf","page":1,"line":0,"score":0,"bbox":null,"fragment":""}]}`
+	if string(b) != expected {
+		t.Errorf("json=\n%s\nexpected=\n%s", b, expected)
+	}
+}
+
+func TestPdfMatchSetWriteCSV(t *testing.T) {
+	var buf bytes.Buffer
+	if err := exportTestResults().WriteCSV(&buf); err != nil {
+		t.Fatalf("err=%v", err)
+	}
+	expected := "file,page,line,score,llx,lly,urx,ury,fragment\n" +
+		"a.pdf,3,7,1.5,72,700,100.5,724,the <mark>fox</mark>\n" +
+		"\"b, \"\"c\"\".pdf\",1,0,0,,,,,\n"
+	if buf.String() != expected {
+		t.Errorf("csv=\n%s\nexpected=\n%s", buf.String(), expected)
+	}
+}
@@ -0,0 +1,197 @@
+package doclib
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// FileGroup is the matches in one PDF in a grouped PdfMatchSet. See PdfMatchSet.Group.
+type FileGroup struct {
+	InPath   string         `json:"file"`
+	Score    float64        `json:"score"` // Highest score of the matches in the file.
+	Sections []SectionGroup `json:"sections"`
+}
+
+// SectionGroup is the matches in one section of a PDF in a grouped PdfMatchSet.
+type SectionGroup struct {
+	// Title is the section's bookmark or heading. It is empty for the matches that aren't in a
+	// known section.
+	Title string      `json:"title"`
+	Pages []PageGroup `json:"pages"`
+}
+
+// PageGroup is the matches on one page in a grouped PdfMatchSet.
+type PageGroup struct {
+	PageNum uint32     `json:"page"`
+	Matches []PdfMatch `json:"matches"`
+}
+
+// Group returns the matches in `s` as a tree of file → section → page for result UIs that show
+// the matches in each file in document order, e.g. in collapsible lists. Files are in order of
+// their best ranked matches. Sections and pages are in page order.
+// A match's section is its PdfMatch.Bookmark or, for PDFs without bookmarks, its
+// PdfMatch.Heading.
+func (s PdfMatchSet) Group() []FileGroup {
+	var groups []FileGroup
+	fileIdx := map[string]int{}
+	for _, m := range s.Matches {
+		i, ok := fileIdx[m.InPath]
+		if !ok {
+			i = len(groups)
+			fileIdx[m.InPath] = i
+			groups = append(groups, FileGroup{InPath: m.InPath, Score: m.Score})
+		}
+		if m.Score > groups[i].Score {
+			groups[i].Score = m.Score
+		}
+		groups[i].add(m)
+	}
+	for i := range groups {
+		groups[i].sort()
+	}
+	return groups
+}
+
+// DocSummary summarizes the matches in one PDF in a PdfMatchSet. See PdfMatchSet.ByDocument.
+type DocSummary struct {
+	InPath    string  `json:"file"`
+	Hits      int     `json:"hits"`      // Number of matches in the PDF.
+	BestScore float64 `json:"bestScore"` // Highest score of the matches in the PDF.
+	FirstPage uint32  `json:"firstPage"` // Lowest page number of the matches in the PDF.
+	// Snippet is the snippet of the best scoring match in the PDF. See PdfMatch.Snippet.
+	Snippet string `json:"snippet"`
+}
+
+// ByDocument returns a DocSummary for each PDF with matches in `s` in order of the PDFs' first
+// matches in `s`. For score ordered match sets this is in order of the PDFs' best matches.
+func (s PdfMatchSet) ByDocument() []DocSummary {
+	var docs []DocSummary
+	docIdx := map[string]int{}
+	for _, m := range s.Matches {
+		snippet := m.Snippet
+		if snippet == "" {
+			snippet = m.Line
+		}
+		i, ok := docIdx[m.InPath]
+		if !ok {
+			docIdx[m.InPath] = len(docs)
+			docs = append(docs, DocSummary{InPath: m.InPath, Hits: 1, BestScore: m.Score,
+				FirstPage: m.PageNum, Snippet: snippet})
+			continue
+		}
+		d := &docs[i]
+		d.Hits++
+		if m.Score > d.BestScore {
+			d.BestScore = m.Score
+			d.Snippet = snippet
+		}
+		if m.PageNum < d.FirstPage {
+			d.FirstPage = m.PageNum
+		}
+	}
+	return docs
+}
+
+// String returns a one line description of `d` followed by its snippet.
+func (d DocSummary) String() string {
+	return fmt.Sprintf("%q hits=%d best=%.3f first page=%d\n    %s", d.InPath, d.Hits,
+		d.BestScore, d.FirstPage, strings.Join(strings.Fields(d.Snippet), " "))
+}
+
+// add adds `m` to the page and section groups of `g`.
+func (g *FileGroup) add(m PdfMatch) {
+	title := m.Section()
+	si := -1
+	for i, sec := range g.Sections {
+		if sec.Title == title {
+			si = i
+			break
+		}
+	}
+	if si < 0 {
+		si = len(g.Sections)
+		g.Sections = append(g.Sections, SectionGroup{Title: title})
+	}
+	sec := &g.Sections[si]
+	for i := range sec.Pages {
+		if sec.Pages[i].PageNum == m.PageNum {
+			sec.Pages[i].Matches = append(sec.Pages[i].Matches, m)
+			return
+		}
+	}
+	sec.Pages = append(sec.Pages, PageGroup{PageNum: m.PageNum, Matches: []PdfMatch{m}})
+}
+
+// sort sorts the pages in each section of `g` and the sections by their first pages.
+func (g *FileGroup) sort() {
+	for _, sec := range g.Sections {
+		pages := sec.Pages
+		sort.SliceStable(pages, func(i, j int) bool { return pages[i].PageNum < pages[j].PageNum })
+	}
+	sections := g.Sections
+	sort.SliceStable(sections, func(i, j int) bool {
+		return sections[i].Pages[0].PageNum < sections[j].Pages[0].PageNum
+	})
+}
+
+// Section returns the title of the section of the PDF that `m` is in. This is m.Bookmark if the
+// PDF has bookmarks, otherwise m.Heading.
+func (m PdfMatch) Section() string {
+	if m.Bookmark != "" {
+		return m.Bookmark
+	}
+	return m.Heading
+}
+
+// GroupString returns a text rendering of s.Group().
+func (s PdfMatchSet) GroupString() string {
+	var b strings.Builder
+	for _, fg := range s.Group() {
+		fmt.Fprintf(&b, "%q (score=%.3f)\n", fg.InPath, fg.Score)
+		for _, sec := range fg.Sections {
+			title := sec.Title
+			if title == "" {
+				title = "(no section)"
+			}
+			fmt.Fprintf(&b, "    %s\n", title)
+			for _, pg := range sec.Pages {
+				fmt.Fprintf(&b, "        page %d: %d matches\n", pg.PageNum, len(pg.Matches))
+				for _, m := range pg.Matches {
+					fmt.Fprintf(&b, "            line %d: %q\n", m.LineNum, m.Line)
+				}
+			}
+		}
+	}
+	return b.String()
+}
+
+// maxHeadingLen is the length of the longest line that lastHeading treats as a heading.
+const maxHeadingLen = 80
+
+// headingRe matches lines that look like numbered section headings, e.g. "7.3 Annotations",
+// "Chapter 7 Graphics" and "Appendix B Operators".
+var headingRe = regexp.MustCompile(
+	`^(\d+(\.\d+)*\.?|[A-Z]\.(\d+(\.\d+)*)?|(Chapter|Section|Part|Appendix|Annex) \w+\.?)\s+\p{Lu}`)
+
+// lastHeading returns the last line in page text `text` up to and including the line containing
+// byte offset `offset` that looks like a section heading or "" if there isn't one. Headings on
+// earlier pages are not found.
+func lastHeading(text string, offset uint32) string {
+	end := len(text)
+	if int(offset) < len(text) {
+		if i := strings.IndexByte(text[offset:], '\n'); i >= 0 {
+			end = int(offset) + i
+		}
+	}
+	lines := strings.Split(text[:end], "\n")
+	for i := len(lines) - 1; i >= 0; i-- {
+		line := strings.TrimSpace(lines[i])
+		if len(line) <= maxHeadingLen && headingRe.MatchString(line) &&
+			!strings.HasSuffix(line, ".") {
+			return line
+		}
+	}
+	return ""
+}
@@ -0,0 +1,84 @@
+package doclib
+
+import "testing"
+
+func TestGroup(t *testing.T) {
+	var s PdfMatchSet
+	add := func(inPath string, pageNum uint32, bookmark string, score float64) {
+		m := PdfMatch{InPath: inPath, PageNum: pageNum, Bookmark: bookmark}
+		m.Score = score
+		s.Matches = append(s.Matches, m)
+	}
+	add("b.pdf", 20, "2 Syntax", 0.9)
+	add("a.pdf", 1, "", 0.8)
+	add("b.pdf", 5, "1 Introduction", 0.7)
+	add("b.pdf", 12, "2 Syntax", 0.6)
+	add("b.pdf", 20, "2 Syntax", 0.5)
+
+	groups := s.Group()
+	if len(groups) != 2 || groups[0].InPath != "b.pdf" || groups[1].InPath != "a.pdf" {
+		t.Fatalf("Bad file groups %+v", groups)
+	}
+	b := groups[0]
+	if b.Score != 0.9 || len(b.Sections) != 2 {
+		t.Fatalf("Bad file group %+v", b)
+	}
+	if b.Sections[0].Title != "1 Introduction" || b.Sections[1].Title != "2 Syntax" {
+		t.Errorf("Bad section order %q %q", b.Sections[0].Title, b.Sections[1].Title)
+	}
+	pages := b.Sections[1].Pages
+	if len(pages) != 2 || pages[0].PageNum != 12 || pages[1].PageNum != 20 ||
+		len(pages[1].Matches) != 2 {
+		t.Errorf("Bad page groups %+v", pages)
+	}
+}
+
+func TestByDocument(t *testing.T) {
+	var s PdfMatchSet
+	add := func(inPath string, pageNum uint32, snippet string, score float64) {
+		m := PdfMatch{InPath: inPath, PageNum: pageNum, Snippet: snippet}
+		m.Score = score
+		s.Matches = append(s.Matches, m)
+	}
+	add("b.pdf", 20, "best b", 0.9)
+	add("a.pdf", 3, "best a", 0.8)
+	add("b.pdf", 5, "other b", 0.7)
+	add("a.pdf", 1, "other a", 0.8)
+
+	docs := s.ByDocument()
+	expected := []DocSummary{
+		{InPath: "b.pdf", Hits: 2, BestScore: 0.9, FirstPage: 5, Snippet: "best b"},
+		{InPath: "a.pdf", Hits: 2, BestScore: 0.8, FirstPage: 1, Snippet: "best a"},
+	}
+	if len(docs) != len(expected) {
+		t.Fatalf("docs=%+v expected=%+v", docs, expected)
+	}
+	for i, d := range docs {
+		if d != expected[i] {
+			t.Errorf("%d: doc=%+v expected=%+v", i, d, expected[i])
+		}
+	}
+}
+
+func TestLastHeading(t *testing.T) {
+	text := "7 Graphics\nSome text about graphics.\n7.3 Annotations\nA link annotation.\n" +
+		"Chapter 8 Fonts\n"
+	tests := []struct {
+		offset  uint32
+		heading string
+	}{
+		{0, "7 Graphics"},
+		{15, "7 Graphics"},
+		{40, "7.3 Annotations"},
+		{60, "7.3 Annotations"},
+		{uint32(len(text)), "Chapter 8 Fonts"},
+	}
+	for _, test := range tests {
+		if heading := lastHeading(text, test.offset); heading != test.heading {
+			t.Errorf("lastHeading(%d) = %q. expected %q", test.offset, heading, test.heading)
+		}
+	}
+	if heading := lastHeading("1999 was a good year.\n", 5); heading != "" {
+		t.Errorf("lastHeading of sentence = %q. expected \"\"", heading)
+	}
+}
@@ -0,0 +1,86 @@
+package doclib
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Sort orders of search results. See SearchOptions.Sort.
+const (
+	SortScore    = ""      // Best matches first.
+	SortOldest   = "date"  // Oldest documents first. See SearchOptions.DateField.
+	SortNewest   = "-date" // Newest documents first.
+	SortPath     = "path"  // By document path then page number, i.e. in reading order.
+	SortSmallest = "size"  // Smallest documents first.
+	SortLargest  = "-size" // Largest documents first.
+)
+
+// sortOrders are the known sort orders.
+var sortOrders = []string{SortScore, SortOldest, SortNewest, SortPath, SortSmallest, SortLargest}
+
+// checkSortOrder returns an error if `order` is not a known sort order.
+func checkSortOrder(order string) error {
+	for _, o := range sortOrders {
+		if order == o {
+			return nil
+		}
+	}
+	return fmt.Errorf("Unknown sort order %q. Use one of %q.", order, sortOrders[1:])
+}
+
+// bleveSortOrder returns the bleve sort order of the search with options `opts`.
+// Matches that sort the same are sorted by score then by bleve ID so that the order is
+// reproducible. Pages of documents that were indexed before the sort fields were indexed come
+// last.
+func bleveSortOrder(opts SearchOptions) []string {
+	var order []string
+	switch opts.Sort {
+	case SortOldest:
+		order = []string{dateField(opts)}
+	case SortNewest:
+		order = []string{"-" + dateField(opts)}
+	case SortPath:
+		order = []string{fileField, pageField}
+	case SortSmallest:
+		order = []string{sizeField}
+	case SortLargest:
+		order = []string{"-" + sizeField}
+	}
+	return append(order, "-_score", "_id")
+}
+
+// Sorted returns a copy of `s` with its matches sorted in order `order`. It is for match sets
+// that bleve didn't sort, such as those from stores that were indexed before the sort fields were
+// indexed. Only SortScore and SortPath can be applied to PdfMatches. `s` is returned unchanged
+// for other orders. Matches that sort the same are sorted by score, path, page number and text
+// offset.
+func (s PdfMatchSet) Sorted(order string) PdfMatchSet {
+	if order != SortScore && order != SortPath {
+		return s
+	}
+	matches := make([]PdfMatch, len(s.Matches))
+	copy(matches, s.Matches)
+	sort.SliceStable(matches, func(i, j int) bool {
+		mi, mj := matches[i], matches[j]
+		if order == SortPath {
+			if mi.InPath != mj.InPath {
+				return mi.InPath < mj.InPath
+			}
+			if mi.PageNum != mj.PageNum {
+				return mi.PageNum < mj.PageNum
+			}
+		}
+		if mi.Score != mj.Score {
+			return mi.Score > mj.Score
+		}
+		if mi.InPath != mj.InPath {
+			return mi.InPath < mj.InPath
+		}
+		if mi.PageNum != mj.PageNum {
+			return mi.PageNum < mj.PageNum
+		}
+		return mi.Start < mj.Start
+	})
+	s.Matches = matches
+	return s
+}
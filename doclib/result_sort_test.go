@@ -0,0 +1,62 @@
+package doclib
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestBleveSortOrder(t *testing.T) {
+	tests := []struct {
+		opts  SearchOptions
+		order []string
+	}{
+		{SearchOptions{}, []string{"-_score", "_id"}},
+		{SearchOptions{Sort: SortOldest}, []string{"created", "-_score", "_id"}},
+		{SearchOptions{Sort: SortNewest, DateField: DateModified},
+			[]string{"-modified", "-_score", "_id"}},
+		{SearchOptions{Sort: SortPath}, []string{"file", "page", "-_score", "_id"}},
+		{SearchOptions{Sort: SortLargest}, []string{"-size", "-_score", "_id"}},
+	}
+	for _, test := range tests {
+		if order := bleveSortOrder(test.opts); !reflect.DeepEqual(order, test.order) {
+			t.Errorf("sort=%q order=%q expected=%q", test.opts.Sort, order, test.order)
+		}
+	}
+	for _, order := range []string{"-path", "newest"} {
+		if err := checkSortOrder(order); err == nil {
+			t.Errorf("Unknown sort order %q was accepted", order)
+		}
+	}
+}
+
+func TestSorted(t *testing.T) {
+	newMatch := func(inPath string, pageNum uint32, score float64) PdfMatch {
+		m := PdfMatch{InPath: inPath, PageNum: pageNum}
+		m.Score = score
+		return m
+	}
+	s := PdfMatchSet{Matches: []PdfMatch{
+		newMatch("b.pdf", 3, 0.9),
+		newMatch("a.pdf", 7, 0.5),
+		newMatch("b.pdf", 1, 0.5),
+		newMatch("a.pdf", 2, 0.5),
+	}}
+	tests := []struct {
+		order    string
+		expected []string
+	}{
+		{SortPath, []string{"a.pdf:2", "a.pdf:7", "b.pdf:1", "b.pdf:3"}},
+		{SortScore, []string{"b.pdf:3", "a.pdf:2", "a.pdf:7", "b.pdf:1"}},
+		{SortNewest, []string{"b.pdf:3", "a.pdf:7", "b.pdf:1", "a.pdf:2"}},
+	}
+	for _, test := range tests {
+		var got []string
+		for _, m := range s.Sorted(test.order).Matches {
+			got = append(got, fmt.Sprintf("%s:%d", m.InPath, m.PageNum))
+		}
+		if !reflect.DeepEqual(got, test.expected) {
+			t.Errorf("order=%q got=%q expected=%q", test.order, got, test.expected)
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package doclib
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+)
+
+// ErrBadCursor is returned when a SearchOptions.Cursor is not valid for a search.
+var ErrBadCursor = errors.New("invalid search cursor")
+
+// searchCursor is the state needed to fetch the next page of results of a search. It is passed to
+// callers as an opaque string. See PdfMatchSet.NextCursor.
+type searchCursor struct {
+	Query uint32 // Checksum of the query string so that a cursor can't be used with another query.
+	From  int    // Offset of the first hit in the next page of results.
+}
+
+// encodeCursor returns the opaque cursor for the page of results of query `term` that starts at
+// hit number `from`.
+func encodeCursor(term string, from int) string {
+	c := searchCursor{Query: crc32.ChecksumIEEE([]byte(term)), From: from}
+	b, err := json.Marshal(c)
+	if err != nil {
+		panic(err) // searchCursor is always marshalable.
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// decodeCursor returns the offset of the first hit in the page of results of query `term` that
+// `cursor` refers to.
+func decodeCursor(term, cursor string) (int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return 0, ErrBadCursor
+	}
+	var c searchCursor
+	if err := json.Unmarshal(b, &c); err != nil {
+		return 0, ErrBadCursor
+	}
+	if c.Query != crc32.ChecksumIEEE([]byte(term)) || c.From < 0 {
+		return 0, ErrBadCursor
+	}
+	return c.From, nil
+}
+
+// maxResultWindow is the maximum of SearchOptions.From + SearchOptions.MaxResults. Searches must
+// fetch all the hits before the results they return.
+const maxResultWindow = 10000
+
+// ErrResultWindow is returned when the results requested by a search aren't in its first
+// maxResultWindow results.
+var ErrResultWindow = fmt.Errorf("only the first %d matches can be returned", maxResultWindow)
+
+// checkResultWindow returns ErrResultWindow if the results [`from`, `from`+`size`) of a search
+// aren't in the first maxResultWindow results.
+func checkResultWindow(from, size int) error {
+	if from > maxResultWindow || size > maxResultWindow-from {
+		return ErrResultWindow
+	}
+	return nil
+}
+
+// searchWindow returns the bleve From and Size that are needed to return the results
+// [`from`, `from`+`size`) of a search. Searches that re-rank or filter the bleve hits, such as
+// boosted and proximity searches, fetch the hits from the start, with boostOversample times as
+// many as needed. Searches that filter the bleve hits fetch more windows if too few of these hits
+// pass the filters. See nextSearchWindow and checkResultWindow.
+func searchWindow(from, size int, rerank bool) (int, int) {
+	if !rerank {
+		return from, size
+	}
+	return 0, (from + size) * boostOversample
+}
+
+// nextSearchWindow returns the bleve Size for the window of hits after a window of `size` hits.
+// Searches that filter the bleve hits fetch windows of growing sizes until enough hits pass the
+// filters.
+func nextSearchWindow(size int) int {
+	if size > maxResultWindow*boostOversample/2 {
+		return maxResultWindow * boostOversample
+	}
+	return 2 * size
+}
@@ -0,0 +1,190 @@
+package doclib
+
+import (
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// maxSnippetLen is the maximum length in bytes of a snippet returned by getSnippet.
+const maxSnippetLen = 300
+
+// getSnippet returns a snippet of page text `text` made of whole sentences around the span
+// [`start`, `end`). The sentence containing the span is always included. Sentences before and
+// after it are added alternately while the snippet is no longer than maxSnippetLen.
+// Line breaks within sentences are replaced by spaces as PDF text is usually broken into lines
+// for layout rather than meaning.
+// If the sentence containing the span is longer than maxSnippetLen then it is cut around the span.
+func getSnippet(text string, start, end uint32) string {
+	breaks := sentenceBreaks(text)
+	// Sentence i is text[breaks[i-1]:breaks[i]] where breaks[-1] is 0.
+	i := sort.Search(len(breaks), func(i int) bool { return breaks[i] > start })
+	if i >= len(breaks) {
+		return ""
+	}
+	lo, hi := i, i+1
+	sentenceStart := func(i int) uint32 {
+		if i == 0 {
+			return 0
+		}
+		return breaks[i-1]
+	}
+	s0, s1 := sentenceStart(lo), breaks[hi-1]
+	if s1-s0 > maxSnippetLen {
+		return "…" + cleanSnippet(cutAround(text[s0:s1], start-s0, end-s0)) + "…"
+	}
+	for {
+		grown := false
+		if hi < len(breaks) && breaks[hi]-s0 <= maxSnippetLen {
+			hi++
+			s1 = breaks[hi-1]
+			grown = true
+		}
+		if lo > 0 && s1-sentenceStart(lo-1) <= maxSnippetLen {
+			lo--
+			s0 = sentenceStart(lo)
+			grown = true
+		}
+		if !grown {
+			break
+		}
+	}
+	return cleanSnippet(text[s0:s1])
+}
+
+// cutAround returns the part of `text` of about maxSnippetLen bytes centered on the span
+// [`start`, `end`), cut at word boundaries.
+func cutAround(text string, start, end uint32) string {
+	if end > uint32(len(text)) {
+		end = uint32(len(text))
+	}
+	margin := uint32(0)
+	if end-start < maxSnippetLen {
+		margin = (maxSnippetLen - (end - start)) / 2
+	}
+	lo, hi := uint32(0), uint32(len(text))
+	if start > margin {
+		lo = start - margin
+		if i := strings.IndexFunc(text[lo:start], unicode.IsSpace); i >= 0 {
+			lo += uint32(i)
+		}
+	}
+	if end+margin < hi {
+		hi = end + margin
+		if i := strings.LastIndexFunc(text[end:hi], unicode.IsSpace); i >= 0 {
+			hi = end + uint32(i)
+		}
+	}
+	for lo < start && !utf8.RuneStart(text[lo]) {
+		lo++
+	}
+	for hi > end && hi < uint32(len(text)) && !utf8.RuneStart(text[hi]) {
+		hi--
+	}
+	return text[lo:hi]
+}
+
+// cleanSnippet returns `text` with runs of white space, including line breaks, replaced by single
+// spaces.
+func cleanSnippet(text string) string {
+	return strings.Join(strings.Fields(text), " ")
+}
+
+// sentenceBreaks returns the offsets of the ends of the sentences in `text`. The last offset is
+// always len(`text`).
+// The boundary rules are simple and meant to work reasonably for most languages:
+//  - A blank line ends a sentence.
+//  - '.', '!', '?' and their fullwidth forms, '…', '؟' and '।' followed by white space end a
+//    sentence, unless the '.' ends a common abbreviation or an initial such as "J.".
+//  - '。', '！' and '？' end a sentence whether or not they are followed by white space.
+//  Closing quotes and brackets after a terminator are part of the sentence.
+func sentenceBreaks(text string) []uint32 {
+	var breaks []uint32
+	n := len(text)
+	for i := 0; i < n; {
+		r, size := utf8.DecodeRuneInString(text[i:])
+		j := i + size
+		switch {
+		case r == '\n':
+			// A blank line.
+			k := j
+			for k < n && (text[k] == ' ' || text[k] == '\t' || text[k] == '\r') {
+				k++
+			}
+			if k < n && text[k] == '\n' {
+				breaks = appendBreak(breaks, uint32(j))
+			}
+		case isSentenceTerminator(r):
+			k := skipClosers(text, j)
+			if k >= n {
+				break
+			}
+			next, _ := utf8.DecodeRuneInString(text[k:])
+			abbrev := r == '.' && isAbbreviation(text[:i])
+			if isCJKTerminator(r) || (unicode.IsSpace(next) && !abbrev) {
+				breaks = appendBreak(breaks, uint32(k))
+			}
+		}
+		i = j
+	}
+	return appendBreak(breaks, uint32(n))
+}
+
+// appendBreak appends `b` to `breaks` if it is after the last break.
+func appendBreak(breaks []uint32, b uint32) []uint32 {
+	if len(breaks) > 0 && breaks[len(breaks)-1] >= b {
+		return breaks
+	}
+	return append(breaks, b)
+}
+
+// skipClosers returns the offset of the first character at or after `i` in `text` that is not a
+// closing quote or bracket.
+func skipClosers(text string, i int) int {
+	for i < len(text) {
+		r, size := utf8.DecodeRuneInString(text[i:])
+		if !strings.ContainsRune(`"')]}’”»」』）`, r) {
+			break
+		}
+		i += size
+	}
+	return i
+}
+
+// isSentenceTerminator returns true if `r` can end a sentence.
+func isSentenceTerminator(r rune) bool {
+	return strings.ContainsRune(".!?…؟।．！？", r) || isCJKTerminator(r)
+}
+
+// isCJKTerminator returns true if `r` ends a sentence in languages that don't put spaces between
+// sentences.
+func isCJKTerminator(r rune) bool {
+	return r == '。' || r == '！' || r == '？'
+}
+
+// abbreviations are common abbreviations that end in a '.' that doesn't end a sentence.
+var abbreviations = map[string]bool{
+	"dr": true, "mr": true, "mrs": true, "ms": true, "prof": true, "st": true, "vs": true,
+	"e.g": true, "i.e": true, "etc": true, "fig": true, "vol": true, "pp": true, "cf": true,
+	"approx": true, "z.b": true, "bzw": true, "usw": true,
+}
+
+// isAbbreviation returns true if `text` ends in an abbreviation or a single letter initial.
+func isAbbreviation(text string) bool {
+	word := text
+	if i := strings.LastIndexFunc(text, isWordSeparator); i >= 0 {
+		_, size := utf8.DecodeRuneInString(text[i:])
+		word = text[i+size:]
+	}
+	if utf8.RuneCountInString(word) == 1 {
+		r, _ := utf8.DecodeRuneInString(word)
+		return unicode.IsUpper(r)
+	}
+	return abbreviations[strings.ToLower(word)]
+}
+
+// isWordSeparator returns true if `r` separates an abbreviation from the text before it.
+func isWordSeparator(r rune) bool {
+	return unicode.IsSpace(r) || r == '('
+}
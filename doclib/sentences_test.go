@@ -0,0 +1,42 @@
+package doclib
+
+import "testing"
+
+func TestGetSnippet(t *testing.T) {
+	text := "The first sentence.\nThe second sentence is\nbroken over lines, e.g. here. A third!\n\n" +
+		"A new paragraph"
+	start := uint32(len("The first sentence.\nThe second "))
+	end := start + uint32(len("sentence"))
+	snippet := getSnippet(text, start, end)
+	expected := "The first sentence. The second sentence is broken over lines, e.g. here. A third! " +
+		"A new paragraph"
+	if snippet != expected {
+		t.Fatalf("snippet=%q expected=%q", snippet, expected)
+	}
+}
+
+func TestSentenceBreaks(t *testing.T) {
+	tests := []struct {
+		text   string
+		breaks []uint32
+	}{
+		{"One. Two.", []uint32{4, 9}},
+		{"Dr. Smith said (hi.) Then", []uint32{20, 25}},
+		{"第一句。第二句。", []uint32{12, 24}},
+		{"No terminator", []uint32{13}},
+		{"Para one\n\nPara two", []uint32{9, 18}},
+	}
+	for _, test := range tests {
+		breaks := sentenceBreaks(test.text)
+		if len(breaks) != len(test.breaks) {
+			t.Errorf("text=%q breaks=%v expected=%v", test.text, breaks, test.breaks)
+			continue
+		}
+		for i, b := range breaks {
+			if b != test.breaks[i] {
+				t.Errorf("text=%q breaks=%v expected=%v", test.text, breaks, test.breaks)
+				break
+			}
+		}
+	}
+}
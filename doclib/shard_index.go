@@ -0,0 +1,177 @@
+package doclib
+
+import (
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"path/filepath"
+	"sync"
+
+	"github.com/blevesearch/bleve"
+	"github.com/blevesearch/bleve/mapping"
+	"github.com/unidoc/unidoc/common"
+)
+
+// errShardedWrite is returned when a sharded index is written to without a batcher. Pages are
+// routed to shards by their document's hash, which bleve.Index.Index doesn't have.
+var errShardedWrite = errors.New("sharded bleve indexes must be written with a batcher")
+
+// shardDirFmt is the name of the directory of a shard of a sharded bleve index in the index's
+// directory.
+const shardDirFmt = "shard-%03d"
+
+// shardedIndex is a bleve index that is split into shards. Each document's pages are in the shard
+// selected by the document's hash. Big corpora are faster to build as several small indexes
+// because bleve's indexing cost grows with index size and the shards can be written in parallel.
+// shardedIndex embeds a bleve IndexAlias over the shards so it can be searched like any other
+// bleve index.
+type shardedIndex struct {
+	bleve.IndexAlias
+	shards []bleve.Index
+}
+
+// newShardedIndex returns a shardedIndex over `shards`.
+func newShardedIndex(shards []bleve.Index) *shardedIndex {
+	return &shardedIndex{IndexAlias: bleve.NewIndexAlias(shards...), shards: shards}
+}
+
+// shardOf returns the shard of the pages of the document with hash `hash` in an index with
+// `numShards` shards.
+func shardOf(hash string, numShards int) int {
+	h := fnv.New32a()
+	h.Write([]byte(hash))
+	return int(h.Sum32() % uint32(numShards))
+}
+
+// shardPath returns the directory of shard `i` of the sharded bleve index in `indexPath`.
+func shardPath(indexPath string, i int) string {
+	return filepath.Join(indexPath, fmt.Sprintf(shardDirFmt, i))
+}
+
+// createStoreIndex creates or opens the bleve index in `indexPath` with `numShards` shards and
+// stop words `stopwords`. The index isn't sharded if `numShards` <= 1. `forceCreate` and
+// `allowAppend` are as for CreateBleveIndex.
+func createStoreIndex(indexPath string, numShards int, stopwords StopwordConfig, forceCreate,
+	allowAppend bool) (bleve.Index, error) {
+
+	if numShards <= 1 {
+		return createBleveIndex(indexPath, stopwords, forceCreate, allowAppend)
+	}
+	var shards []bleve.Index
+	for i := 0; i < numShards; i++ {
+		shard, err := createBleveIndex(shardPath(indexPath, i), stopwords, forceCreate,
+			allowAppend)
+		if err != nil {
+			closeIndexes(shards)
+			return nil, err
+		}
+		shards = append(shards, shard)
+	}
+	return newShardedIndex(shards), nil
+}
+
+// OpenStoreIndex opens the bleve index of the store in `persistDir`. It is a sharded index if the
+// store was created with IndexOptions.Shards > 1. Callers should use it rather than opening
+// <persistDir>/bleve with bleve.Open.
+func OpenStoreIndex(persistDir string) (bleve.Index, error) {
+	return openStoreIndex(persistDir, nil)
+}
+
+// OpenStoreIndexReadOnly is OpenStoreIndex for searching. The index is opened read-only so that
+// processes that search a store can share it. If a writer has the index open, it waits up to 10
+// seconds for the writer to close it. See LockStore.
+func OpenStoreIndexReadOnly(persistDir string) (bleve.Index, error) {
+	return openStoreIndex(persistDir, map[string]interface{}{
+		"read_only":    true,
+		"bolt_timeout": readOnlyTimeout,
+	})
+}
+
+// openStoreIndex opens the bleve index of the store in `persistDir` with bleve runtime config
+// `runtimeConfig`. See OpenStoreIndex.
+func openStoreIndex(persistDir string, runtimeConfig map[string]interface{}) (bleve.Index,
+	error) {
+
+	indexPath := filepath.Join(persistDir, "bleve")
+	m, err := loadManifest(persistDir)
+	if err != nil {
+		return nil, err
+	}
+	if m.Shards <= 1 {
+		return bleve.OpenUsing(indexPath, runtimeConfig)
+	}
+	var shards []bleve.Index
+	for i := 0; i < m.Shards; i++ {
+		shard, err := bleve.OpenUsing(shardPath(indexPath, i), runtimeConfig)
+		if err != nil {
+			closeIndexes(shards)
+			return nil, err
+		}
+		shards = append(shards, shard)
+	}
+	return newShardedIndex(shards), nil
+}
+
+// closeIndexes closes the bleve indexes in `indexes`.
+func closeIndexes(indexes []bleve.Index) {
+	for _, index := range indexes {
+		if err := index.Close(); err != nil {
+			common.Log.Error("closeIndexes: Could not close %q. err=%v", index.Name(), err)
+		}
+	}
+}
+
+// Index returns errShardedWrite. Use a batcher to add pages to a shardedIndex.
+func (x *shardedIndex) Index(id string, data interface{}) error {
+	return errShardedWrite
+}
+
+// Delete deletes bleve document `id` from all the shards of `x`.
+func (x *shardedIndex) Delete(id string) error {
+	for _, shard := range x.shards {
+		if err := shard.Delete(id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Mapping returns the index mapping of `x`. All the shards have the same mapping.
+func (x *shardedIndex) Mapping() mapping.IndexMapping {
+	return x.shards[0].Mapping()
+}
+
+// Close closes all the shards of `x`.
+func (x *shardedIndex) Close() error {
+	var err error
+	for _, shard := range x.shards {
+		if e := shard.Close(); e != nil && err == nil {
+			err = e
+		}
+	}
+	x.IndexAlias.Close()
+	return err
+}
+
+// flushShards flushes the batchers of the shards of a sharded index in parallel.
+func flushShards(shards []*batcher) error {
+	errs := make([]error, len(shards))
+	var wg sync.WaitGroup
+	for i, b := range shards {
+		if b.n == 0 {
+			continue
+		}
+		wg.Add(1)
+		go func(i int, b *batcher) {
+			defer wg.Done()
+			errs[i] = b.flush()
+		}(i, b)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,193 @@
+package doclib
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/blevesearch/bleve"
+	"github.com/unidoc/unidoc/common"
+)
+
+const (
+	// maxLikeTerms is the maximum number of terms in the query that SimilarPages builds.
+	maxLikeTerms = 25
+	// maxLikePageFraction is the largest fraction of the pages in a store that a term can be on
+	// and still be used to find similar pages. Commoner terms don't say what a page is about.
+	maxLikePageFraction = 0.5
+)
+
+// SimilarPages returns up to `n` pages in `lState` and `index`, other than page `pageIdx` of
+// document `docIdx`, that are most like that page. It is a "more like this" search: the terms in
+// the page's text with the highest TF-IDF scores are searched for with their scores as boosts.
+// The matches are ordered by score and have no NextCursor.
+func SimilarPages(lState *Store, index bleve.Index, docIdx uint64, pageIdx uint32,
+	n int) (PdfMatchSet, error) {
+
+	lDoc, err := lState.OpenPositionsDoc(docIdx)
+	if err != nil {
+		return PdfMatchSet{}, err
+	}
+	if pageIdx >= uint32(lDoc.Len()) {
+		lDoc.Close()
+		return PdfMatchSet{}, ErrRange
+	}
+	text, err := lDoc.ReadPageText(pageIdx)
+	lDoc.Close()
+	if err != nil {
+		return PdfMatchSet{}, err
+	}
+	term, err := moreLikeThisQuery(index, text)
+	if err != nil || term == "" {
+		return PdfMatchSet{}, err
+	}
+	common.Log.Debug("SimilarPages: doc=%d page=%d term=%q", docIdx, pageIdx, term)
+
+	if n <= 0 {
+		n = 10
+	}
+	// The page itself is the best match for its terms so ask for one more match than needed.
+	p, err := SearchIndexOpts(lState, index, term, SearchOptions{MaxResults: n + 1})
+	if err != nil {
+		return p, err
+	}
+	var matches []PdfMatch
+	for _, m := range p.Matches {
+		if m.docIdx == docIdx && m.pageIdx == pageIdx {
+			p.TotalMatches--
+			continue
+		}
+		matches = append(matches, m)
+	}
+	if len(matches) > n {
+		matches = matches[:n]
+	}
+	p.Matches = matches
+	p.NextCursor = ""
+	return p, nil
+}
+
+// SimilarPages returns up to `n` pages in `x` that are most like page `pageIdx` of document
+// `docIdx`. See SimilarPages.
+func (x *PdfIndex) SimilarPages(docIdx uint64, pageIdx uint32, n int) (PdfMatchSet, error) {
+	if err := x.refresh(); err != nil {
+		return PdfMatchSet{}, err
+	}
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+	if x.index == nil {
+		return PdfMatchSet{}, ErrClosed
+	}
+	return SimilarPages(x.lState, x.index, docIdx, pageIdx, n)
+}
+
+// moreLikeThisQuery returns a bleve query string that matches the pages in `index` with the most
+// distinctive terms of page text `text`, or "" if `text` has no distinctive terms.
+func moreLikeThisQuery(index bleve.Index, text string) (string, error) {
+	m := index.Mapping()
+	name := m.AnalyzerNameForPath(textField)
+	analyzer := m.AnalyzerNamed(name)
+	if analyzer == nil {
+		return "", fmt.Errorf("No %q analyzer", name)
+	}
+	termFreqs := map[string]int{}
+	for _, token := range analyzer.Analyze([]byte(text)) {
+		termFreqs[string(token.Term)]++
+	}
+	numPages, err := index.DocCount()
+	if err != nil {
+		return "", err
+	}
+	pageCounts := map[string]uint64{}
+	for t := range termFreqs {
+		count, err := termPageCount(index, t)
+		if err != nil {
+			return "", err
+		}
+		pageCounts[t] = count
+	}
+	var parts []string
+	for _, wt := range likeTerms(termFreqs, pageCounts, numPages, maxLikeTerms) {
+		parts = append(parts, fmt.Sprintf("%s:%s^%.3f", textField, wt.term, wt.weight))
+	}
+	return strings.Join(parts, " "), nil
+}
+
+// termPageCount returns the number of pages in `index` whose text has term `term`.
+func termPageCount(index bleve.Index, term string) (uint64, error) {
+	if x, ok := index.(*shardedIndex); ok {
+		var total uint64
+		for _, shard := range x.shards {
+			count, err := termPageCount(shard, term)
+			if err != nil {
+				return 0, err
+			}
+			total += count
+		}
+		return total, nil
+	}
+	dict, err := index.FieldDictRange(textField, []byte(term), []byte(term))
+	if err != nil {
+		return 0, err
+	}
+	defer dict.Close()
+	entry, err := dict.Next()
+	if err != nil || entry == nil || entry.Term != term {
+		return 0, err
+	}
+	return entry.Count, nil
+}
+
+// weightedTerm is a term in a "more like this" query and its boost.
+type weightedTerm struct {
+	term   string
+	weight float64
+}
+
+// likeTerms returns the up to `maxTerms` terms of a page with the highest TF-IDF scores, highest
+// first, with their scores normalized so that the highest is 1. `termFreqs` are {term: number of
+// times it is on the page}, `pageCounts` are {term: number of pages it is on} and `numPages` is
+// the number of pages in the store. Terms that are only on the page, that are on too many pages or
+// that are query syntax aren't used.
+func likeTerms(termFreqs map[string]int, pageCounts map[string]uint64, numPages uint64,
+	maxTerms int) []weightedTerm {
+
+	var terms []weightedTerm
+	for t, tf := range termFreqs {
+		df := pageCounts[t]
+		if df <= 1 || float64(df) > maxLikePageFraction*float64(numPages) || !plainTerm(t) {
+			continue
+		}
+		idf := 1.0 + math.Log(float64(numPages)/float64(df+1))
+		terms = append(terms, weightedTerm{term: t, weight: float64(tf) * idf})
+	}
+	sort.Slice(terms, func(i, j int) bool {
+		if terms[i].weight != terms[j].weight {
+			return terms[i].weight > terms[j].weight
+		}
+		return terms[i].term < terms[j].term
+	})
+	if len(terms) > maxTerms {
+		terms = terms[:maxTerms]
+	}
+	if len(terms) > 0 {
+		top := terms[0].weight
+		for i := range terms {
+			terms[i].weight /= top
+		}
+	}
+	return terms
+}
+
+// plainTerm returns true if `term` only has letters and digits, so that it can be put in a bleve
+// query string without escaping.
+func plainTerm(term string) bool {
+	for _, r := range term {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			return false
+		}
+	}
+	return term != ""
+}
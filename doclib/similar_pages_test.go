@@ -0,0 +1,47 @@
+package doclib
+
+import "testing"
+
+func TestLikeTerms(t *testing.T) {
+	termFreqs := map[string]int{
+		"glyph":   3, // Distinctive and frequent on the page.
+		"kerning": 1, // Distinctive.
+		"page":    5, // On most pages.
+		"zzyzx":   2, // Only on this page.
+		"a+b":     2, // Query syntax.
+		"font":    1,
+	}
+	pageCounts := map[string]uint64{
+		"glyph": 4, "kerning": 2, "page": 90, "zzyzx": 1, "a+b": 3, "font": 20,
+	}
+	terms := likeTerms(termFreqs, pageCounts, 100, 10)
+	if len(terms) != 3 {
+		t.Fatalf("Expected 3 terms, got %v", terms)
+	}
+	expected := []string{"glyph", "kerning", "font"}
+	for i, wt := range terms {
+		if wt.term != expected[i] {
+			t.Errorf("term %d=%q expected=%q. terms=%v", i, wt.term, expected[i], terms)
+		}
+	}
+	if terms[0].weight != 1.0 || terms[1].weight >= 1.0 || terms[2].weight >= terms[1].weight {
+		t.Errorf("Bad weights %v", terms)
+	}
+	if terms := likeTerms(termFreqs, pageCounts, 100, 1); len(terms) != 1 ||
+		terms[0].term != "glyph" {
+		t.Errorf("Expected only glyph. terms=%v", terms)
+	}
+	if terms := likeTerms(map[string]int{"zzyzx": 1}, pageCounts, 100, 10); len(terms) != 0 {
+		t.Errorf("Expected no terms. terms=%v", terms)
+	}
+}
+
+func TestPlainTerm(t *testing.T) {
+	for term, expected := range map[string]bool{
+		"glyph": true, "2017": true, "café": true, "": false, "a+b": false, "x:y": false,
+	} {
+		if got := plainTerm(term); got != expected {
+			t.Errorf("plainTerm(%q)=%t expected=%t", term, got, expected)
+		}
+	}
+}
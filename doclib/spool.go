@@ -0,0 +1,117 @@
+package doclib
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+
+	"github.com/blevesearch/bleve"
+	"github.com/unidoc/unidoc/common"
+)
+
+const (
+	// spoolMemBytes is the size of the largest stream that SpoolReader keeps in memory. Bigger
+	// streams are spooled to temporary files.
+	spoolMemBytes = 8 * 1024 * 1024
+	// maxSpoolMB is the size in MB of the largest stream that IndexPdfStreams and IndexCorpus
+	// index when IndexOptions.MaxFileMB isn't set.
+	maxSpoolMB = 2048
+)
+
+// SpooledReader is an io.ReadSeeker over the contents of an io.Reader that may not be able to
+// seek, such as an HTTP response body or an S3 object stream. The contents are kept in memory or a
+// temporary file. It must be closed to remove the temporary file.
+type SpooledReader struct {
+	io.ReadSeeker
+	Size      int64    // Number of bytes that were spooled.
+	Truncated bool     // The stream was larger than the spooling limit. See SpoolReader.
+	file      *os.File // Temporary file that holds the contents. nil if they are in memory.
+}
+
+// SpoolReader returns a SpooledReader over the contents of `r`. If `r` is an io.ReadSeeker that can
+// seek then it is used directly. Otherwise streams of up to 8 MB are read into memory and bigger
+// ones are copied to a temporary file.
+// At most `maxBytes`+1 bytes of `r` are read. If `r` has more than `maxBytes` bytes then the
+// returned SpooledReader has the first `maxBytes`+1 of them and its Truncated field is true.
+func SpoolReader(r io.Reader, maxBytes int64) (*SpooledReader, error) {
+	if rs, ok := r.(io.ReadSeeker); ok {
+		if size, err := rs.Seek(0, io.SeekEnd); err == nil {
+			if _, err := rs.Seek(0, io.SeekStart); err != nil {
+				return nil, err
+			}
+			return &SpooledReader{ReadSeeker: rs, Size: size, Truncated: size > maxBytes}, nil
+		}
+	}
+	limit := maxBytes + 1
+	memLimit := int64(spoolMemBytes)
+	if memLimit > limit {
+		memLimit = limit
+	}
+	var buf bytes.Buffer
+	n, err := io.CopyN(&buf, r, memLimit)
+	if err == io.EOF || (err == nil && n == limit) {
+		return &SpooledReader{ReadSeeker: bytes.NewReader(buf.Bytes()), Size: n,
+			Truncated: n > maxBytes}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := ioutil.TempFile("", "pdf-search-spool")
+	if err != nil {
+		return nil, err
+	}
+	s := &SpooledReader{ReadSeeker: f, file: f}
+	if _, err := f.Write(buf.Bytes()); err != nil {
+		s.Close()
+		return nil, err
+	}
+	m, err := io.Copy(f, io.LimitReader(r, limit-n))
+	if err != nil {
+		s.Close()
+		return nil, err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		s.Close()
+		return nil, err
+	}
+	s.Size = n + m
+	s.Truncated = s.Size > maxBytes
+	common.Log.Debug("SpoolReader: Spooled %d bytes to %q", s.Size, f.Name())
+	return s, nil
+}
+
+// Close removes the temporary file of `s`, if there is one.
+func (s *SpooledReader) Close() error {
+	if s.file == nil {
+		return nil
+	}
+	err := s.file.Close()
+	if err2 := os.Remove(s.file.Name()); err == nil {
+		err = err2
+	}
+	s.file = nil
+	return err
+}
+
+// IndexPdfStreams is IndexPdfReadersOpts for PDFs read from the io.Readers in `rList`, which don't
+// need to be able to seek. The streams are spooled with SpoolReader before they are indexed.
+// Streams larger than opts.MaxFileMB are not spooled completely. They are reported as skipped like
+// files that are too large. If opts.MaxFileMB isn't set then an error is returned for streams
+// larger than 2 GB.
+func IndexPdfStreams(pathList []string, rList []io.Reader, persistDir string, forceCreate,
+	allowAppend bool, opts IndexOptions, report func(string)) (*Store, bleve.Index, int,
+	error) {
+
+	var rsList []io.ReadSeeker
+	for i, r := range rList {
+		s, err := spoolDoc(pathList[i], r, opts)
+		if err != nil {
+			return nil, nil, 0, err
+		}
+		defer s.Close()
+		rsList = append(rsList, s)
+	}
+	return IndexPdfReadersOpts(pathList, rsList, persistDir, forceCreate, allowAppend, opts, report)
+}
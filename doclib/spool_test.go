@@ -0,0 +1,61 @@
+package doclib
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"testing"
+)
+
+// streamReader hides the Seek method of the reader it wraps.
+type streamReader struct {
+	r io.Reader
+}
+
+func (s streamReader) Read(p []byte) (int, error) {
+	return s.r.Read(p)
+}
+
+func TestSpoolReader(t *testing.T) {
+	for _, test := range []struct {
+		size      int
+		maxBytes  int64
+		truncated bool
+	}{
+		{1000, 2000, false},
+		{1000, 1000, false},
+		{1000, 500, true},
+		{spoolMemBytes + 1000, spoolMemBytes + 2000, false},
+		{spoolMemBytes + 1000, spoolMemBytes + 500, true},
+	} {
+		data := bytes.Repeat([]byte("0123456789"), test.size/10)
+		s, err := SpoolReader(streamReader{bytes.NewReader(data)}, test.maxBytes)
+		if err != nil {
+			t.Fatalf("size=%d maxBytes=%d err=%v", test.size, test.maxBytes, err)
+		}
+		if s.Truncated != test.truncated {
+			t.Errorf("size=%d maxBytes=%d Truncated=%t. expected %t", test.size, test.maxBytes,
+				s.Truncated, test.truncated)
+		}
+		expected := data
+		if test.truncated {
+			expected = data[:test.maxBytes+1]
+		}
+		for i := 0; i < 2; i++ {
+			got, err := ioutil.ReadAll(s)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, expected) || s.Size != int64(len(expected)) {
+				t.Errorf("size=%d maxBytes=%d: read %d bytes, Size=%d. expected %d", test.size,
+					test.maxBytes, len(got), s.Size, len(expected))
+			}
+			if _, err := s.Seek(0, io.SeekStart); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := s.Close(); err != nil {
+			t.Errorf("Close failed. err=%v", err)
+		}
+	}
+}
@@ -0,0 +1,143 @@
+package doclib
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/blevesearch/bleve/analysis"
+	"github.com/blevesearch/bleve/analysis/analyzer/custom"
+	"github.com/blevesearch/bleve/analysis/analyzer/standard"
+	"github.com/blevesearch/bleve/analysis/lang/de"
+	"github.com/blevesearch/bleve/analysis/lang/en"
+	"github.com/blevesearch/bleve/analysis/lang/es"
+	"github.com/blevesearch/bleve/analysis/lang/fr"
+	"github.com/blevesearch/bleve/analysis/lang/it"
+	"github.com/blevesearch/bleve/analysis/lang/pt"
+	"github.com/blevesearch/bleve/analysis/token/lowercase"
+	"github.com/blevesearch/bleve/analysis/token/stop"
+	"github.com/blevesearch/bleve/analysis/tokenizer/unicode"
+	"github.com/blevesearch/bleve/analysis/tokenmap"
+	"github.com/blevesearch/bleve/mapping"
+)
+
+// StopwordConfig is the stop word list of a store. Stop words aren't indexed, so they can't be
+// searched for, but they make the index smaller and searches for phrases with them faster.
+// The zero StopwordConfig is the English stop words of the standard analyzer. Technical corpora
+// can remove words such as "it" and "no", and corpora in other languages can use their own.
+// A store's stop words are part of its index mapping and are fixed when it is created.
+type StopwordConfig struct {
+	// Lang is the language whose stop words are the default stop words: en, fr, de, es, it, pt or
+	// none for no default stop words. "" means en.
+	Lang string `json:",omitempty"`
+	// Add are words that are stop words as well as the defaults.
+	Add []string `json:",omitempty"`
+	// Remove are default stop words that are indexed.
+	Remove []string `json:",omitempty"`
+}
+
+// StopwordsNone is the StopwordConfig.Lang of stores with no default stop words.
+const StopwordsNone = "none"
+
+// langStopwordLists are {language: bleve's stop word list for the language}.
+var langStopwordLists = map[string][]byte{
+	"en": en.EnglishStopWords,
+	"fr": fr.FrenchStopWords,
+	"de": de.GermanStopWords,
+	"es": es.SpanishStopWords,
+	"it": it.ItalianStopWords,
+	"pt": pt.PortugueseStopWords,
+}
+
+// Names of the analysis components of stores with a non-default StopwordConfig.
+const (
+	textAnalyzer    = "pdfsearch_text"
+	stopFilter      = "pdfsearch_stop"
+	stopwordsMapKey = "pdfsearch_stopwords"
+)
+
+// isDefault returns true if `c` is the stop words of the standard analyzer.
+func (c StopwordConfig) isDefault() bool {
+	return (c.Lang == "" || c.Lang == "en") && len(c.Add) == 0 && len(c.Remove) == 0
+}
+
+// Words returns the stop words of `c` in alphabetical order.
+func (c StopwordConfig) Words() ([]string, error) {
+	tokens := analysis.NewTokenMap()
+	lang := c.Lang
+	if lang == "" {
+		lang = "en"
+	}
+	if lang != StopwordsNone {
+		list, ok := langStopwordLists[lang]
+		if !ok {
+			return nil, fmt.Errorf("No stop words for language %q", c.Lang)
+		}
+		if err := tokens.LoadBytes(list); err != nil {
+			return nil, err
+		}
+	}
+	for _, w := range c.Add {
+		tokens[strings.ToLower(strings.TrimSpace(w))] = true
+	}
+	for _, w := range c.Remove {
+		delete(tokens, strings.ToLower(strings.TrimSpace(w)))
+	}
+	delete(tokens, "")
+	words := make([]string, 0, len(tokens))
+	for w := range tokens {
+		words = append(words, w)
+	}
+	sort.Strings(words)
+	return words, nil
+}
+
+// addStopwordAnalyzer adds the analysis components for the stop words in `c` to `indexMapping`
+// and makes the page text analyzer its default analyzer. It returns the names of the page text
+// analyzer and of the token filter that removes the stop words. This is the standard analyzer
+// and its stop filter if `c` is the default, in which case `indexMapping` is unchanged. The
+// stop filter is "" if there are no stop words.
+func addStopwordAnalyzer(indexMapping *mapping.IndexMappingImpl, c StopwordConfig) (
+	analyzer, filter string, err error) {
+
+	if c.isDefault() {
+		return standard.Name, en.StopName, nil
+	}
+	words, err := c.Words()
+	if err != nil {
+		return "", "", err
+	}
+	filters := []string{lowercase.Name}
+	if len(words) > 0 {
+		tokens := make([]interface{}, len(words))
+		for i, w := range words {
+			tokens[i] = w
+		}
+		err = indexMapping.AddCustomTokenMap(stopwordsMapKey, map[string]interface{}{
+			"type":   tokenmap.Name,
+			"tokens": tokens,
+		})
+		if err != nil {
+			return "", "", err
+		}
+		err = indexMapping.AddCustomTokenFilter(stopFilter, map[string]interface{}{
+			"type":           stop.Name,
+			"stop_token_map": stopwordsMapKey,
+		})
+		if err != nil {
+			return "", "", err
+		}
+		filter = stopFilter
+		filters = append(filters, stopFilter)
+	}
+	err = indexMapping.AddCustomAnalyzer(textAnalyzer, map[string]interface{}{
+		"type":          custom.Name,
+		"tokenizer":     unicode.Name,
+		"token_filters": filters,
+	})
+	if err != nil {
+		return "", "", err
+	}
+	indexMapping.DefaultAnalyzer = textAnalyzer
+	return textAnalyzer, filter, nil
+}
@@ -0,0 +1,36 @@
+package doclib
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestStopwordWords(t *testing.T) {
+	c := StopwordConfig{Lang: StopwordsNone, Add: []string{"Fig", " table", "fig"}}
+	words, err := c.Words()
+	if err != nil {
+		t.Fatalf("Words failed. err=%v", err)
+	}
+	if expected := []string{"fig", "table"}; !reflect.DeepEqual(words, expected) {
+		t.Errorf("words=%q expected=%q", words, expected)
+	}
+
+	c = StopwordConfig{Remove: []string{"it", "no"}}
+	if words, err = c.Words(); err != nil {
+		t.Fatalf("Words failed. err=%v", err)
+	}
+	has := map[string]bool{}
+	for _, w := range words {
+		has[w] = true
+	}
+	if !has["the"] || has["it"] || has["no"] {
+		t.Errorf("English stop words without it and no: %q", words)
+	}
+
+	if _, err := (StopwordConfig{Lang: "xx"}).Words(); err == nil {
+		t.Errorf("Expected an error for an unknown language")
+	}
+	if !(StopwordConfig{Lang: "en"}).isDefault() || c.isDefault() {
+		t.Errorf("isDefault is wrong")
+	}
+}
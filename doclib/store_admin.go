@@ -0,0 +1,96 @@
+package doclib
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/blevesearch/bleve"
+	"github.com/unidoc/unidoc/common"
+)
+
+// ErrNotStore is returned when a directory is not a store.
+var ErrNotStore = errors.New("not a PositionsState directory")
+
+// MoveStore moves the store in directory `oldDir` to `newDir`.
+// `lState` and `index` are the open handles on the store in `oldDir`, or nil if they are not open.
+// Moving a store while its files are open would leave the handles pointing at the old paths, so
+// the caller must stop writing to `lState` and `index` before calling MoveStore. MoveStore flushes
+// and closes them, renames the directory and returns handles on the store in `newDir`. The old
+// handles must not be used after MoveStore is called.
+// A nil bleve.Index is returned if the store has no bleve index.
+// `newDir` must not exist. Its parent directories are created if necessary.
+func MoveStore(oldDir, newDir string, lState *Store, index bleve.Index) (
+	*Store, bleve.Index, error) {
+
+	oldAbs, err := filepath.Abs(oldDir)
+	if err != nil {
+		return nil, nil, err
+	}
+	newAbs, err := filepath.Abs(newDir)
+	if err != nil {
+		return nil, nil, err
+	}
+	common.Log.Info("MoveStore: %q -> %q", oldAbs, newAbs)
+
+	if oldAbs == newAbs {
+		return nil, nil, fmt.Errorf("Could not move store %q to itself", oldDir)
+	}
+	if !Exists(filepath.Join(oldDir, "file_list.json")) {
+		return nil, nil, fmt.Errorf("Could not move %q. err=%v", oldDir, ErrNotStore)
+	}
+	if Exists(newDir) {
+		return nil, nil, fmt.Errorf("Could not move store %q to %q. %q exists",
+			oldDir, newDir, newDir)
+	}
+	if lState != nil {
+		if lState.isMem() {
+			return nil, nil, fmt.Errorf("Could not move in-memory store. err=%v", ErrNotStore)
+		}
+		root, err := filepath.Abs(lState.root)
+		if err != nil {
+			return nil, nil, err
+		}
+		if root != oldAbs {
+			return nil, nil, fmt.Errorf("Could not move store %q. Handle is for %q",
+				oldDir, lState.root)
+		}
+	}
+
+	// Quiesce the store.
+	if lState != nil {
+		if err := lState.Flush(); err != nil {
+			return nil, nil, fmt.Errorf("Could not flush positions store %q. err=%v", oldDir, err)
+		}
+		lState.closeDocs()
+	}
+	if index != nil {
+		if err := index.Close(); err != nil {
+			return nil, nil, fmt.Errorf("Could not close Bleve index in %q. err=%v", oldDir, err)
+		}
+	}
+
+	if err := os.MkdirAll(filepath.Dir(newAbs), 0777); err != nil {
+		return nil, nil, err
+	}
+	if err := os.Rename(oldAbs, newAbs); err != nil {
+		return nil, nil, fmt.Errorf("Could not move store %q to %q. err=%v", oldDir, newDir, err)
+	}
+
+	// Reopen the store from its new location. The paths of the files in the store are all
+	// relative to the store root so only the root changes.
+	lState, err = OpenPositionsState(newDir, false)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Could not open positions store %q. err=%v", newDir, err)
+	}
+	indexPath := filepath.Join(newDir, "bleve")
+	if !Exists(indexPath) {
+		return lState, nil, nil
+	}
+	index, err = OpenStoreIndex(newDir)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Could not open Bleve index %q. err=%v", indexPath, err)
+	}
+	return lState, index, nil
+}
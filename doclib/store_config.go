@@ -0,0 +1,129 @@
+package doclib
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"time"
+)
+
+// configFileName is the name of the configuration file in a store directory.
+const configFileName = "config.json"
+
+// StoreConfig is the configuration of a persistent store. It is written to the store's config.json
+// when the store is created and read whenever the store is opened so that the programs that use a
+// store don't have to specify the same options every time. It can be edited by hand.
+// Zero fields mean the built-in defaults. Non-zero options passed to a call override the
+// configuration for that call.
+type StoreConfig struct {
+	// BatchSize, PageRanges, MaxFileMB, Exclude and MinTextQuality are the values of the
+	// IndexOptions fields with the same names that are used when the fields are not set.
+	BatchSize      int        `json:",omitempty"`
+	PageRanges     PageRanges `json:",omitempty"`
+	MaxFileMB      float64    `json:",omitempty"`
+	Exclude        []string   `json:",omitempty"`
+	MinTextQuality float64    `json:",omitempty"`
+	// Normalization is the TextNormalization of the store's pages and queries. It is set when the
+	// store is created and must not be changed. Stores without it aren't normalized.
+	Normalization TextNormalization
+	// Stopwords are the store's stop words. They are set when the store is created and must not
+	// be changed as they are part of the store's index mapping.
+	Stopwords StopwordConfig
+	// OpenDocs is the number of documents whose positions data files are kept open between reads.
+	// See Store.SetOpenDocs.
+	OpenDocs int `json:",omitempty"`
+	// FlushPeriodSec is the longest time in seconds that documents added to the store go without
+	// the file list being saved.
+	FlushPeriodSec float64 `json:",omitempty"`
+}
+
+// configPath returns the path of the configuration file of the store in `persistDir`.
+func configPath(persistDir string) string {
+	return filepath.Join(persistDir, configFileName)
+}
+
+// LoadStoreConfig returns the StoreConfig of the store in `persistDir`. A zero StoreConfig is
+// returned for stores without a configuration file.
+func LoadStoreConfig(persistDir string) (StoreConfig, error) {
+	var c StoreConfig
+	filename := configPath(persistDir)
+	if persistDir == "" || !Exists(filename) {
+		return c, nil
+	}
+	b, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return c, err
+	}
+	if err := json.Unmarshal(b, &c); err != nil {
+		return c, fmt.Errorf("Could not parse store config %q. err=%v", filename, err)
+	}
+	return c, nil
+}
+
+// SaveStoreConfig writes `c` to the configuration file of the store in `persistDir`.
+func SaveStoreConfig(persistDir string, c StoreConfig) error {
+	b, err := json.MarshalIndent(c, "", "\t")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(configPath(persistDir), b, 0666)
+}
+
+// configFromOptions returns the StoreConfig that is saved in a store that is created with the
+// indexing options `opts`.
+func configFromOptions(opts IndexOptions) StoreConfig {
+	return StoreConfig{
+		BatchSize:      opts.BatchSize,
+		PageRanges:     opts.PageRanges,
+		MaxFileMB:      opts.MaxFileMB,
+		Exclude:        opts.Exclude,
+		MinTextQuality: opts.MinTextQuality,
+		Normalization:  opts.Normalization,
+		Stopwords:      opts.Stopwords,
+	}
+}
+
+// fillOptions returns `opts` with its unset fields set from `c`. The normalization and stop words
+// are always the store's.
+func (c StoreConfig) fillOptions(opts IndexOptions) IndexOptions {
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = c.BatchSize
+	}
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = defaultBatchSize
+	}
+	if len(opts.PageRanges) == 0 {
+		opts.PageRanges = c.PageRanges
+	}
+	if opts.MaxFileMB <= 0 {
+		opts.MaxFileMB = c.MaxFileMB
+	}
+	if len(opts.Exclude) == 0 {
+		opts.Exclude = c.Exclude
+	}
+	if opts.MinTextQuality <= 0 {
+		opts.MinTextQuality = c.MinTextQuality
+	}
+	opts.Normalization = c.Normalization
+	opts.Stopwords = c.Stopwords
+	return opts
+}
+
+// openDocs returns the number of documents a store with configuration `c` keeps open.
+func (c StoreConfig) openDocs() int {
+	if c.OpenDocs > 0 {
+		return c.OpenDocs
+	}
+	return maxOpenDocs
+}
+
+// flushPeriod returns the longest time that a store with configuration `c` goes without saving
+// its file list while documents are being added.
+func (c StoreConfig) flushPeriod() time.Duration {
+	sec := c.FlushPeriodSec
+	if sec <= 0 {
+		sec = storeUpdatePeriodSec
+	}
+	return time.Duration(sec * float64(time.Second))
+}
@@ -0,0 +1,200 @@
+package doclib
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/unidoc/unidoc/common"
+)
+
+// Stores can be shared by processes. A process that writes to a store takes an exclusive advisory
+// lock (flock) on the store's lock file so that there is at most one writer at a time. Searchers
+// don't take the lock. They open the bleve index read-only so that many of them can share it.
+// The lock file records the process that holds the lock. The operating system releases the lock
+// when that process exits, so a lock file with a holder that isn't locked was left by a writer
+// that crashed. The next writer takes the lock over and reports it as stale.
+// Stores aren't locked on Windows.
+
+// lockFileName is the name of the lock file in a store directory.
+const lockFileName = "store.lock"
+
+// readOnlyTimeout is how long a read-only open of a bleve index waits for a writer to close it.
+const readOnlyTimeout = "10s"
+
+// ErrStoreLocked is returned when a process tries to write to a store that another process is
+// writing to.
+var ErrStoreLocked = errors.New("store is locked by another writer")
+
+// errLockBusy is returned by lockFile when another process holds the lock.
+var errLockBusy = errors.New("lock is held by another process")
+
+// LockInfo describes the process that holds or held a store's lock.
+type LockInfo struct {
+	PID     int       // Process ID.
+	Host    string    // Host name.
+	Started time.Time // When the lock was taken.
+}
+
+func (info LockInfo) String() string {
+	return fmt.Sprintf("pid %d on %q since %s", info.PID, info.Host,
+		info.Started.Format(time.RFC3339))
+}
+
+// StoreLock is an exclusive lock on a store. See LockStore.
+type StoreLock struct {
+	f    *os.File
+	path string
+	// Stale describes the writer that held the lock before and exited without releasing it, e.g.
+	// by crashing. It is nil if the lock was released properly.
+	Stale *LockInfo
+}
+
+// LockStore takes the writer lock on the store in `persistDir`. It doesn't wait. If another
+// process holds the lock it logs the holder and returns ErrStoreLocked. See ReadStoreLock.
+// The lock must be released with Unlock.
+func LockStore(persistDir string) (*StoreLock, error) {
+	if err := MkDir(persistDir); err != nil {
+		return nil, err
+	}
+	path := filepath.Join(persistDir, lockFileName)
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0666)
+	if err != nil {
+		return nil, err
+	}
+	if err := lockFile(f); err != nil {
+		f.Close()
+		if err != errLockBusy {
+			return nil, fmt.Errorf("Could not lock %q. err=%v", path, err)
+		}
+		if info, ok := readLockInfo(path); ok {
+			common.Log.Error("LockStore: %q is locked by %s", persistDir, info)
+		}
+		return nil, ErrStoreLocked
+	}
+
+	l := &StoreLock{f: f, path: path}
+	if info, ok := readLockInfo(path); ok {
+		common.Log.Error("LockStore: Taking over the stale lock on %q held by %s", persistDir,
+			info)
+		l.Stale = &info
+	}
+	host, _ := os.Hostname()
+	info := LockInfo{PID: os.Getpid(), Host: host, Started: time.Now()}
+	if err := l.write(info); err != nil {
+		l.Unlock()
+		return nil, err
+	}
+	return l, nil
+}
+
+// Unlock releases `l`. The lock file is emptied first so that the next writer doesn't take the
+// lock for a stale one.
+func (l *StoreLock) Unlock() error {
+	if l == nil || l.f == nil {
+		return nil
+	}
+	err := l.f.Truncate(0)
+	if err2 := unlockFile(l.f); err == nil {
+		err = err2
+	}
+	if err2 := l.f.Close(); err == nil {
+		err = err2
+	}
+	l.f = nil
+	return err
+}
+
+// write records `info` in the lock file of `l`.
+func (l *StoreLock) write(info LockInfo) error {
+	b, err := json.Marshal(info)
+	if err != nil {
+		return err
+	}
+	if err := l.f.Truncate(0); err != nil {
+		return err
+	}
+	if _, err := l.f.WriteAt(b, 0); err != nil {
+		return err
+	}
+	return l.f.Sync()
+}
+
+// ReadStoreLock returns the process that holds the writer lock on the store in `persistDir`.
+// It returns false if the lock isn't held.
+func ReadStoreLock(persistDir string) (LockInfo, bool, error) {
+	path := filepath.Join(persistDir, lockFileName)
+	f, err := os.OpenFile(path, os.O_RDWR, 0666)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return LockInfo{}, false, nil
+		}
+		return LockInfo{}, false, err
+	}
+	defer f.Close()
+	switch err := lockFile(f); err {
+	case nil:
+		return LockInfo{}, false, unlockFile(f)
+	case errLockBusy:
+		info, _ := readLockInfo(path)
+		return info, true, nil
+	default:
+		return LockInfo{}, false, err
+	}
+}
+
+// readLockInfo returns the LockInfo in lock file `path`. It returns false if there isn't one.
+func readLockInfo(path string) (LockInfo, bool) {
+	var info LockInfo
+	b, err := ioutil.ReadFile(path)
+	if err != nil || len(b) == 0 {
+		return info, false
+	}
+	if err := json.Unmarshal(b, &info); err != nil {
+		common.Log.Error("readLockInfo: Bad lock file %q. err=%v", path, err)
+		return info, false
+	}
+	return info, true
+}
+
+// lockWriter takes the writer lock of persistent store `lState` unless this process already holds
+// it through `lState`. Each call must be matched by a call of unlockWriter.
+func (lState *Store) lockWriter() error {
+	if lState.isMem() {
+		return nil
+	}
+	lState.mu.Lock()
+	defer lState.mu.Unlock()
+	if lState.locks == 0 {
+		lock, err := LockStore(lState.root)
+		if err != nil {
+			return err
+		}
+		lState.lock = lock
+	}
+	lState.locks++
+	return nil
+}
+
+// unlockWriter releases the writer lock taken by lockWriter when it has no other users.
+func (lState *Store) unlockWriter() error {
+	if lState.isMem() {
+		return nil
+	}
+	lState.mu.Lock()
+	defer lState.mu.Unlock()
+	if lState.locks == 0 {
+		return nil
+	}
+	lState.locks--
+	if lState.locks > 0 {
+		return nil
+	}
+	err := lState.lock.Unlock()
+	lState.lock = nil
+	return err
+}
@@ -0,0 +1,112 @@
+//go:build !windows
+// +build !windows
+
+package doclib
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLockStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pdf-search-lock")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	if _, locked, err := ReadStoreLock(dir); err != nil || locked {
+		t.Fatalf("New store is locked. locked=%t err=%v", locked, err)
+	}
+	lock, err := LockStore(dir)
+	if err != nil {
+		t.Fatalf("LockStore failed. err=%v", err)
+	}
+	if lock.Stale != nil {
+		t.Errorf("New lock is stale %+v", *lock.Stale)
+	}
+	info, locked, err := ReadStoreLock(dir)
+	if err != nil || !locked || info.PID != os.Getpid() {
+		t.Fatalf("Bad lock info %+v locked=%t err=%v", info, locked, err)
+	}
+	if _, err := LockStore(dir); err != ErrStoreLocked {
+		t.Errorf("Second lock: expected ErrStoreLocked, got %v", err)
+	}
+	if err := lock.Unlock(); err != nil {
+		t.Fatalf("Unlock failed. err=%v", err)
+	}
+	if _, locked, err := ReadStoreLock(dir); err != nil || locked {
+		t.Fatalf("Unlocked store is locked. locked=%t err=%v", locked, err)
+	}
+
+	// A lock file that names a holder that doesn't hold the lock was left by a crashed writer.
+	stale := []byte(`{"PID": 12345, "Host": "crashed", "Started": "2019-01-02T03:04:05Z"}`)
+	if err := ioutil.WriteFile(filepath.Join(dir, lockFileName), stale, 0666); err != nil {
+		t.Fatal(err)
+	}
+	lock, err = LockStore(dir)
+	if err != nil {
+		t.Fatalf("LockStore of stale lock failed. err=%v", err)
+	}
+	defer lock.Unlock()
+	if lock.Stale == nil || lock.Stale.PID != 12345 || lock.Stale.Host != "crashed" {
+		t.Errorf("Bad stale lock %+v", lock.Stale)
+	}
+}
+
+// TestForceCreateLocked checks that a writer can force-create a store in a new directory that
+// LockStore has created.
+func TestForceCreateLocked(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pdf-search-lock")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	root := filepath.Join(dir, "x")
+	lock, err := LockStore(root)
+	if err != nil {
+		t.Fatalf("LockStore failed. err=%v", err)
+	}
+	lState, err := openPositionsState(root, true, lock)
+	if err != nil {
+		lock.Unlock()
+		t.Fatalf("Force create of %q failed. err=%v", root, err)
+	}
+	if err := lState.unlockWriter(); err != nil {
+		t.Errorf("unlockWriter failed. err=%v", err)
+	}
+}
+
+// TestMergeLocksSources checks that MergeStores doesn't merge a store that another writer holds
+// and that it releases the locks of the stores it merged.
+func TestMergeLocksSources(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pdf-search-lock")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	src, dst := filepath.Join(dir, "src"), filepath.Join(dir, "dst")
+	writeTestStore(t, src, [][]string{{"The pump is serviced every year."}})
+
+	lock, err := LockStore(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := MergeStores(dst, src); err != ErrStoreLocked {
+		t.Errorf("Merge of locked store: expected ErrStoreLocked, got %v", err)
+	}
+	if err := lock.Unlock(); err != nil {
+		t.Fatal(err)
+	}
+	if err := MergeStores(dst, src); err != nil {
+		t.Fatalf("MergeStores failed. err=%v", err)
+	}
+	for _, d := range []string{src, dst} {
+		if _, locked, err := ReadStoreLock(d); err != nil || locked {
+			t.Errorf("%q is locked after the merge. locked=%t err=%v", d, locked, err)
+		}
+	}
+}
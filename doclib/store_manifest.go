@@ -0,0 +1,235 @@
+package doclib
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+
+	"github.com/blevesearch/bleve"
+	"github.com/blevesearch/bleve/mapping"
+	"github.com/unidoc/unidoc/common"
+)
+
+// ErrMappingMismatch is returned when appending to a bleve index that was created with a different
+// index mapping from the one this version of the code uses. Pages added with the current mapping
+// would be indexed inconsistently with the existing pages so the index must be rebuilt.
+var ErrMappingMismatch = errors.New("bleve index mapping mismatch")
+
+// manifestFileName is the name of the file in a store directory that describes how the store was
+// built.
+const manifestFileName = "manifest.json"
+
+// mappingHashKey is the bleve internal key that the mapping hash of an index is stored under.
+var mappingHashKey = []byte("pdfsearch.mappingHash")
+
+// storeManifest describes how a store was built.
+type storeManifest struct {
+	MappingHash string        // Hash of the bleve index mapping. See mappingHash().
+	Features    StoreFeatures // Optional features of the store.
+	// Shards is the number of shards of the bleve index. The index isn't sharded if it is <= 1.
+	// See shardedIndex.
+	Shards int `json:",omitempty"`
+	// ColdDir is the DirStorage that frozen documents are kept in. See Store.SetColdDir.
+	ColdDir string `json:",omitempty"`
+	// LastBuild describes the last indexing run. It is nil for stores built before it was
+	// recorded.
+	LastBuild *BuildStats `json:",omitempty"`
+}
+
+// StoreFeatures are the optional features of a store. Clients check them before offering UI
+// features that need them, e.g. a thumbnail strip or "similar pages".
+type StoreFeatures struct {
+	OCRText       bool `json:"ocrText"`       // Some page texts were recognized by OCR.
+	Thumbnails    bool `json:"thumbnails"`    // Page thumbnails are stored.
+	Embeddings    bool `json:"embeddings"`    // Page embeddings are stored.
+	EncryptedText bool `json:"encryptedText"` // Page texts are encrypted on disk.
+}
+
+// StoreInfo describes a store to clients.
+type StoreInfo struct {
+	NumDocs     int           `json:"numDocs"`
+	MappingHash string        `json:"mappingHash"` // Empty for stores built before it was recorded.
+	Features    StoreFeatures `json:"features"`
+}
+
+// ReadStoreInfo returns the StoreInfo of the store in `persistDir`.
+func ReadStoreInfo(persistDir string) (StoreInfo, error) {
+	lState, err := OpenPositionsState(persistDir, false)
+	if err != nil {
+		return StoreInfo{}, err
+	}
+	return lState.StoreInfo()
+}
+
+// StoreInfo returns the StoreInfo of `lState`. In-memory stores have no manifest so only their
+// features that can be derived from the documents are reported.
+func (lState *Store) StoreInfo() (StoreInfo, error) {
+	info := StoreInfo{NumDocs: lState.Len()}
+	if !lState.isMem() {
+		m, err := loadManifest(lState.root)
+		if err != nil {
+			return info, err
+		}
+		info.MappingHash, info.Features = m.MappingHash, m.Features
+	}
+	info.Features.OCRText = lState.hasOCRText()
+	return info, nil
+}
+
+// hasOCRText returns true if some documents in `lState` have text that was recognized by OCR.
+func (lState *Store) hasOCRText() bool {
+	for _, fd := range lState.fileList {
+		for _, e := range fd.Extractors {
+			if isOCRExtractor(e.Name) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// updateManifestFeatures records the features of `lState` that depend on its documents in its
+// manifest. It is called after documents are added or removed. Features that are set by other
+// tools, e.g. thumbnail generators, are kept.
+func (lState *Store) updateManifestFeatures() error {
+	if lState.isMem() {
+		return nil
+	}
+	m, err := loadManifest(lState.root)
+	if err != nil {
+		return err
+	}
+	ocrText := lState.hasOCRText()
+	if m.Features.OCRText == ocrText {
+		return nil
+	}
+	m.Features.OCRText = ocrText
+	return saveManifest(lState.root, m)
+}
+
+// mappingHash returns a hash of the configuration of index mapping `m`.
+func mappingHash(m mapping.IndexMapping) (string, error) {
+	b, err := json.Marshal(m)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// currentMappingHash returns the hash of the mapping returned by newIndexMapping(`stopwords`).
+func currentMappingHash(stopwords StopwordConfig) (string, error) {
+	m, err := newIndexMapping(stopwords)
+	if err != nil {
+		return "", err
+	}
+	return mappingHash(m)
+}
+
+// setMappingHash records the hash of the current index mapping for stop words `stopwords` in new
+// bleve index `index`.
+func setMappingHash(index bleve.Index, stopwords StopwordConfig) error {
+	hash, err := currentMappingHash(stopwords)
+	if err != nil {
+		return err
+	}
+	return index.SetInternal(mappingHashKey, []byte(hash))
+}
+
+// checkMapping returns ErrMappingMismatch if existing bleve index `index` at `indexPath` was
+// created with a different index mapping to the current one for stop words `stopwords`.
+// Indexes created before mapping hashes were recorded are checked against the mapping stored in
+// the index.
+func checkMapping(index bleve.Index, indexPath string, stopwords StopwordConfig) error {
+	b, err := index.GetInternal(mappingHashKey)
+	if err != nil {
+		return err
+	}
+	hash := string(b)
+	if hash == "" {
+		if hash, err = mappingHash(index.Mapping()); err != nil {
+			return err
+		}
+	}
+	want, err := currentMappingHash(stopwords)
+	if err != nil {
+		return err
+	}
+	if hash != want {
+		common.Log.Error("Bleve index %q was created with a different index mapping. "+
+			"Rebuild it by indexing with forceCreate (-f). hash=%.12s want=%.12s",
+			indexPath, hash, want)
+		return ErrMappingMismatch
+	}
+	return nil
+}
+
+// manifestPath returns the path of the manifest of the store in `persistDir`.
+func manifestPath(persistDir string) string {
+	return filepath.Join(persistDir, manifestFileName)
+}
+
+// loadManifest returns the manifest of the store in `persistDir`. A zero storeManifest is returned
+// for stores that were created before manifests were written.
+func loadManifest(persistDir string) (storeManifest, error) {
+	var m storeManifest
+	filename := manifestPath(persistDir)
+	if !Exists(filename) {
+		return m, nil
+	}
+	b, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return m, err
+	}
+	if err := json.Unmarshal(b, &m); err != nil {
+		return m, fmt.Errorf("Could not parse manifest %q. err=%v", filename, err)
+	}
+	return m, nil
+}
+
+// saveManifest writes `m` as the manifest of the store in `persistDir`.
+func saveManifest(persistDir string, m storeManifest) error {
+	b, err := json.MarshalIndent(m, "", "\t")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(manifestPath(persistDir), b, 0666)
+}
+
+// checkManifest returns ErrMappingMismatch if the store in `persistDir` records a different index
+// mapping to the current one.
+func checkManifest(persistDir string) error {
+	m, err := loadManifest(persistDir)
+	if err != nil || m.MappingHash == "" {
+		return err
+	}
+	config, err := LoadStoreConfig(persistDir)
+	if err != nil {
+		return err
+	}
+	want, err := currentMappingHash(config.Stopwords)
+	if err != nil {
+		return err
+	}
+	if m.MappingHash != want {
+		common.Log.Error("Store %q was built with a different index mapping. "+
+			"Rebuild it by indexing with forceCreate (-f).", persistDir)
+		return ErrMappingMismatch
+	}
+	return nil
+}
+
+// mappingError returns an error for a mapping mismatch `err` in store `persistDir` that tells the
+// user how to fix it.
+func mappingError(persistDir string, err error) error {
+	if err != ErrMappingMismatch {
+		return err
+	}
+	return fmt.Errorf("Could not append to %q. It was built by a version of pdf-search with a "+
+		"different index mapping. Rebuild it by indexing with forceCreate (-f). err=%v",
+		persistDir, err)
+}
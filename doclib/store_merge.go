@@ -0,0 +1,277 @@
+package doclib
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"github.com/blevesearch/bleve"
+	"github.com/unidoc/unidoc/common"
+)
+
+// MergeStores adds the documents of the stores in directories `srcs` to the
+// store in directory `dst`, which is created if it doesn't exist. It is for combining stores that
+// were built independently, e.g. by different teams or by index workers.
+//   - Documents are deduplicated by content hash. The paths of a document that is already in `dst`
+//     are added to its aliases.
+//   - The documents of `srcs` get new document indexes in `dst`. Their positions data and page
+//     texts are copied to `dst` and their pages are indexed in the bleve index of `dst`, so `srcs`
+//     may have been built with a different index mapping or number of shards to `dst`.
+//   - The known bad PDFs of `srcs` are added to those of `dst`.
+// All the stores are locked while they are merged so that `srcs` don't change during the merge.
+// A new `dst` has the configuration of srcs[0]. All the stores must have the same text
+// normalization. Documents whose positions data can't be read are
+// skipped and reported in the returned error after the other documents have been merged.
+func MergeStores(dst string, srcs ...string) error {
+	dstAbs, err := filepath.Abs(dst)
+	if err != nil {
+		return err
+	}
+	for _, src := range srcs {
+		srcAbs, err := filepath.Abs(src)
+		if err != nil {
+			return err
+		}
+		if srcAbs == dstAbs {
+			return fmt.Errorf("Could not merge store %q into itself", src)
+		}
+		if !Exists(filepath.Join(src, "file_list.json")) {
+			return fmt.Errorf("Could not merge %q. err=%v", src, ErrNotStore)
+		}
+	}
+	common.Log.Info("MergeStores: %q <- %q", dst, srcs)
+
+	srcLocks := make([]*StoreLock, len(srcs))
+	defer func() {
+		for _, l := range srcLocks {
+			l.Unlock()
+		}
+	}()
+	for i, src := range srcs {
+		if srcLocks[i], err = LockStore(src); err != nil {
+			return err
+		}
+	}
+	lock, err := LockStore(dst)
+	if err != nil {
+		return err
+	}
+	lState, err := openPositionsState(dst, false, lock)
+	if err != nil {
+		lock.Unlock()
+		return fmt.Errorf("Could not open positions store %q. err=%v", dst, err)
+	}
+	defer lState.unlockWriter()
+	index, err := openMergeIndex(lState, srcs)
+	if err != nil {
+		return err
+	}
+	defer index.Close()
+
+	if err := lState.startWriter(); err != nil {
+		return err
+	}
+	t0 := time.Now()
+	var build BuildStats // The documents and pages added from `srcs`.
+	numBad := 0
+	for i, src := range srcs {
+		var bad int
+		if bad, err = lState.mergeStore(index, src, srcLocks[i], &build); err != nil {
+			break
+		}
+		numBad += bad
+	}
+	build.Finished = time.Now()
+	build.Duration = build.Finished.Sub(t0)
+	if err2 := lState.endWriter(build, err == nil); err == nil {
+		err = err2
+	}
+	if err != nil {
+		return err
+	}
+	common.Log.Info("MergeStores: Added %d documents, %d pages to %q", build.NumDocs,
+		build.NumPages, dst)
+	if numBad > 0 {
+		return fmt.Errorf("Could not merge %d damaged documents into %q. err=%v", numBad, dst,
+			ErrCorruptStore)
+	}
+	return nil
+}
+
+// openMergeIndex opens the bleve index of the store `lState` that MergeStores merges the stores
+// `srcs` into. If the store is new, it is given the configuration and number of shards of srcs[0].
+func openMergeIndex(lState *Store, srcs []string) (bleve.Index, error) {
+	dst := lState.root
+	indexPath := filepath.Join(dst, "bleve")
+	if err := checkManifest(dst); err != nil {
+		return nil, mappingError(dst, err)
+	}
+	manifest, err := loadManifest(dst)
+	if err != nil {
+		return nil, err
+	}
+	created := !Exists(indexPath)
+	if created && len(srcs) > 0 {
+		config, err := LoadStoreConfig(srcs[0])
+		if err != nil {
+			return nil, err
+		}
+		if err := SaveStoreConfig(dst, config); err != nil {
+			return nil, err
+		}
+		lState.normalization = config.Normalization
+		srcManifest, err := loadManifest(srcs[0])
+		if err != nil {
+			return nil, err
+		}
+		manifest.Shards = srcManifest.Shards
+	}
+	config, err := LoadStoreConfig(dst)
+	if err != nil {
+		return nil, err
+	}
+	index, err := createStoreIndex(indexPath, manifest.Shards, config.Stopwords, false, true)
+	if err == ErrMappingMismatch {
+		return nil, mappingError(dst, err)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Could not create Bleve index in %q", indexPath)
+	}
+	if manifest.MappingHash, err = currentMappingHash(config.Stopwords); err != nil {
+		index.Close()
+		return nil, err
+	}
+	if err := saveManifest(dst, manifest); err != nil {
+		index.Close()
+		return nil, err
+	}
+	return index, nil
+}
+
+// mergeStore adds the documents of the store in directory `src`, whose writer lock `srcLock` the
+// caller holds, to `lState` and `index` and adds the documents and pages it added to `build`. It
+// returns the number of documents that were skipped because their positions data couldn't be read.
+func (lState *Store) mergeStore(index bleve.Index, src string, srcLock *StoreLock,
+	build *BuildStats) (int, error) {
+
+	config, err := LoadStoreConfig(src)
+	if err != nil {
+		return 0, err
+	}
+	if config.Normalization != lState.normalization {
+		return 0, fmt.Errorf("Could not merge %q. Its text normalization %v is not %v",
+			src, config.Normalization, lState.normalization)
+	}
+	sState, err := openPositionsState(src, false, srcLock)
+	if err != nil {
+		return 0, fmt.Errorf("Could not open positions store %q. err=%v", src, err)
+	}
+	defer sState.closeDocs()
+	srcFilter, err := LoadCorpusFilter(src)
+	if err != nil {
+		return 0, err
+	}
+	known := lState.filter.BadHashes()
+	for hash, reason := range srcFilter.BadHashes() {
+		if _, ok := known[hash]; ok {
+			continue
+		}
+		if err := lState.filter.AddBadHash(hash, reason); err != nil {
+			return 0, err
+		}
+	}
+
+	numBad := 0
+	for i, fd := range sState.fileList {
+		lState.mu.Lock()
+		added, numPages, err := lState.mergeDoc(index, sState, uint64(i), fd)
+		lState.mu.Unlock()
+		if err == errMergeRead {
+			numBad++
+			continue
+		}
+		if err != nil {
+			return numBad, err
+		}
+		if added {
+			build.NumDocs++
+			build.NumPages += numPages
+		}
+	}
+	common.Log.Info("mergeStore: Merged %d documents from %q. %d damaged", len(sState.fileList),
+		src, numBad)
+	return numBad, nil
+}
+
+// errMergeRead is returned by mergeDoc when a document's positions data can't be read.
+var errMergeRead = errors.New("could not read document")
+
+// mergeDoc adds document `fd`, which has index `docIdx` in `sState`, to `lState` and `index`. It
+// returns true and the number of pages if the document was added. A document that is already in
+// `lState` isn't added again but its paths are added to the aliases of the copy in `lState`.
+// It must be called with lState.mu held.
+func (lState *Store) mergeDoc(index bleve.Index, sState *Store, docIdx uint64,
+	fd FileDesc) (bool, int, error) {
+
+	if dstIdx, ok := lState.hashIndex[fd.Hash]; ok {
+		lState.addAlias(dstIdx, fd.InPath)
+		for _, alias := range fd.Aliases {
+			lState.addAlias(dstIdx, alias)
+		}
+		return false, 0, nil
+	}
+	pages, err := sState.readDocPages(docIdx)
+	if err != nil {
+		common.Log.Error("mergeDoc: Could not read %q in %q. err=%v", fd.InPath, sState.root, err)
+		return false, 0, errMergeRead
+	}
+	// The positions data is written to the local disk of `lState`.
+	fd.Cold = false
+	if _, err := lState.addDocPagePositions(fd, pages); err != nil {
+		return false, 0, fmt.Errorf("Could not add %q to %q. err=%v", fd.InPath, lState.root, err)
+	}
+	newIdx := lState.hashIndex[fd.Hash]
+	b := newBatcher(index, maxBatchOps)
+	if err := lState.reindexDoc(b, newIdx, newIdx); err != nil {
+		return false, 0, err
+	}
+	if err := b.flush(); err != nil {
+		return false, 0, err
+	}
+	return true, len(pages), lState.journal.done(newIdx, lState.fileList[newIdx])
+}
+
+// readDocPages returns the pages of document `docIdx` in `lState` in the form they were extracted
+// in, so that they can be added to another store.
+func (lState *Store) readDocPages(docIdx uint64) ([]pageExtraction, error) {
+	lDoc, err := lState.OpenPositionsDoc(docIdx)
+	if err != nil {
+		return nil, err
+	}
+	defer lDoc.Close()
+	pages := make([]pageExtraction, lDoc.Len())
+	for i := range pages {
+		pageIdx := uint32(i)
+		pageNum, dpl, err := lDoc.ReadPagePositions(pageIdx)
+		if err != nil {
+			return nil, err
+		}
+		text, err := lDoc.ReadPageText(pageIdx)
+		if err != nil {
+			return nil, err
+		}
+		box, _ := lDoc.pageBox(pageIdx)
+		quality, _ := lDoc.PageQuality(pageIdx)
+		pages[i] = pageExtraction{
+			pageNum: pageNum,
+			text:    text,
+			dpl:     dpl,
+			box:     box,
+			quality: quality,
+			annots:  lDoc.PageAnnotations(pageIdx),
+			tables:  lDoc.PageTables(pageIdx),
+		}
+	}
+	return pages, nil
+}
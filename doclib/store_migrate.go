@@ -0,0 +1,275 @@
+package doclib
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io/ioutil"
+	"math"
+	"os"
+	"strconv"
+
+	"github.com/peterwilliams97/pdf-search/serial"
+	"github.com/unidoc/unidoc/common"
+)
+
+// StoreFormatVersion is the version of the on-disk format of the stores that this version of
+// pdf-search writes. It is recorded in file_list.json. Stores with older formats are migrated to
+// this format when they are opened. See storeMigrations.
+//  0: file_list.json is a list of FileDescs. Page spans may have no page numbers or have signed
+//     page numbers.
+//  1: file_list.json is a storeFileList. Every page span has a page number.
+//  2: The text locations of pages are word locations with their ends. See wordLocations.
+const StoreFormatVersion = 2
+
+// ErrStoreTooNew is returned when opening a store that was written by a newer version of
+// pdf-search with a format that this version doesn't understand.
+var ErrStoreTooNew = errors.New("store format is newer than this version of pdf-search")
+
+// storeFileList is the contents of file_list.json.
+type storeFileList struct {
+	Version int        // Format version of the store. See StoreFormatVersion.
+	Files   []FileDesc // The documents in the store.
+}
+
+// storeMigration upgrades the on-disk format of a store from version `from` to version `from`+1.
+type storeMigration struct {
+	from    int
+	summary string
+	migrate func(lState *Store) error
+}
+
+// storeMigrations are the migrations between successive store format versions in version order.
+// The file list is saved in the current format after the migrations so migrations that only
+// change file_list.json don't need to do anything.
+var storeMigrations = []storeMigration{
+	{0, "add page numbers to page spans", migrateSpanPageNums},
+	{1, "rebuild text locations as word locations", migrateWordLocations},
+}
+
+// migrate upgrades `lState`, whose on-disk format is `version`, to StoreFormatVersion.
+// The migrations must be safe to repeat because they are run again if the store isn't saved.
+func (lState *Store) migrate(version int) error {
+	if version > StoreFormatVersion {
+		return fmt.Errorf("Could not open %q. version=%d supported=%d err=%v", lState.root,
+			version, StoreFormatVersion, ErrStoreTooNew)
+	}
+	if err := lState.lockWriter(); err != nil {
+		return fmt.Errorf("Could not migrate %q. err=%v", lState.root, err)
+	}
+	defer lState.unlockWriter()
+	for _, m := range storeMigrations {
+		if m.from < version {
+			continue
+		}
+		common.Log.Info("migrate: %q version %d to %d: %s", lState.root, m.from, m.from+1,
+			m.summary)
+		if err := m.migrate(lState); err != nil {
+			return fmt.Errorf("Could not migrate %q from version %d. err=%v", lState.root, m.from,
+				err)
+		}
+	}
+	return saveFileList(lState.fileListPath(), lState.fileList)
+}
+
+// migrateSpanPageNums rewrites the page spans of the documents in `lState` that were written
+// without page numbers or with signed page numbers, e.g. by older tools that shared this store
+// format. The missing page numbers are read from the pages' positions data if they are recorded
+// there. Otherwise the pages are assumed to be numbered 1, 2, ...
+func migrateSpanPageNums(lState *Store) error {
+	for docIdx := range lState.fileList {
+		lDoc, err := lState.baseFields(uint64(docIdx))
+		if err != nil {
+			return err
+		}
+		b, err := ioutil.ReadFile(lDoc.spansPath)
+		if err != nil {
+			if os.IsNotExist(err) {
+				common.Log.Error("migrateSpanPageNums: No page spans %q", lDoc.spansPath)
+				continue
+			}
+			return err
+		}
+		spans, missing, err := decodeLegacySpans(b)
+		if err != nil {
+			return fmt.Errorf("Could not read %q. err=%v", lDoc.spansPath, err)
+		}
+		if len(missing) == 0 {
+			continue
+		}
+		if err := lState.thawDoc(lDoc); err != nil {
+			return err
+		}
+		data, err := ioutil.ReadFile(lDoc.dataPath)
+		if err != nil {
+			return err
+		}
+		for _, pageIdx := range missing {
+			spans[pageIdx].PageNum = dataPageNum(spans[pageIdx], data)
+			if spans[pageIdx].PageNum == 0 {
+				spans[pageIdx].PageNum = uint32(pageIdx + 1)
+			}
+		}
+		common.Log.Info("migrateSpanPageNums: %q: %d of %d page numbers", lDoc.inPath,
+			len(missing), len(spans))
+		lDoc.spans = spans
+		if err := lDoc.Save(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// migrateWordLocations rewrites the text locations of the pages of the documents in `lState` as
+// word locations with their ends. Older stores have character locations, and stores written
+// before the ends of locations were saved have word locations without ends, which GetPosition
+// can't tell from character locations. Both are converted by wordLocations, after their ends are
+// guessed by guessLocationEnds. Word locations with ends are unchanged, so the migration can be
+// repeated.
+func migrateWordLocations(lState *Store) error {
+	b := getBuilder()
+	defer putBuilder(b)
+	for docIdx := range lState.fileList {
+		lDoc, err := lState.baseFields(uint64(docIdx))
+		if err != nil {
+			return err
+		}
+		if err := lState.thawDoc(lDoc); err != nil {
+			return err
+		}
+		spansJSON, err := ioutil.ReadFile(lDoc.spansPath)
+		if err != nil {
+			if os.IsNotExist(err) {
+				common.Log.Error("migrateWordLocations: No page spans %q", lDoc.spansPath)
+				continue
+			}
+			return err
+		}
+		if err := json.Unmarshal(spansJSON, &lDoc.spans); err != nil {
+			return fmt.Errorf("Could not read %q. err=%v", lDoc.spansPath, err)
+		}
+		data, err := ioutil.ReadFile(lDoc.dataPath)
+		if err != nil {
+			return err
+		}
+		var out bytes.Buffer
+		for pageIdx, e := range lDoc.spans {
+			end := uint64(e.Offset) + uint64(e.Size)
+			if end > uint64(len(data)) || crc32.ChecksumIEEE(data[e.Offset:end]) != e.Check {
+				return fmt.Errorf("Could not read page %d of %q. err=%v", pageIdx, lDoc.dataPath,
+					ErrBadChecksum)
+			}
+			dpl, err := serial.ReadDocPageLocations(data[e.Offset:end])
+			if err != nil {
+				return err
+			}
+			text, err := lDoc.readPersistedPageText(uint32(pageIdx))
+			if err != nil {
+				return err
+			}
+			dpl.Locations = wordLocations(text, guessLocationEnds(text, dpl.Locations))
+			buf := serial.MakeDocPageLocations(b, dpl)
+			lDoc.spans[pageIdx].Offset = uint32(out.Len())
+			lDoc.spans[pageIdx].Size = uint32(len(buf))
+			lDoc.spans[pageIdx].Check = crc32.ChecksumIEEE(buf)
+			out.Write(buf)
+		}
+		if err := writeFileAtomic(lDoc.dataPath, &out); err != nil {
+			return err
+		}
+		if err := lDoc.Save(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// guessLocationEnds returns `locs`, the text locations of page text `text` with no ends, with the
+// locations that start words ending at the ends of those words, so that word locations don't
+// cover the punctuation after their words. The box of the first character of a word in character
+// locations is then spread over the word, but the other characters' boxes make up the rest of the
+// word's box in wordLocations. `locs` is returned unchanged if any location has an end.
+func guessLocationEnds(text string, locs []serial.TextLocation) []serial.TextLocation {
+	wordEnds := map[uint32]uint32{}
+	for _, tok := range wordTokenizer.Tokenize([]byte(text)) {
+		wordEnds[uint32(tok.Start)] = uint32(tok.End)
+	}
+	guessed := make([]serial.TextLocation, len(locs))
+	for i, loc := range locs {
+		if loc.End > loc.Start {
+			return locs
+		}
+		if end, ok := wordEnds[loc.Start]; ok {
+			loc.End = end
+		}
+		guessed[i] = loc
+	}
+	return guessed
+}
+
+// decodeLegacySpans returns the page spans in `b`, the contents of a .idx.json file, and the
+// indexes of the spans that have no valid page number. Page numbers that are missing, null,
+// non-positive or not integers are invalid. Their PageNum is 0.
+func decodeLegacySpans(b []byte) ([]byteSpan, []int, error) {
+	var raw []map[string]json.RawMessage
+	if err := json.Unmarshal(b, &raw); err != nil {
+		return nil, nil, err
+	}
+	spans := make([]byteSpan, len(raw))
+	var missing []int
+	for i, fields := range raw {
+		pageNum := legacyPageNum(fields["PageNum"])
+		delete(fields, "PageNum")
+		fb, err := json.Marshal(fields)
+		if err != nil {
+			return nil, nil, err
+		}
+		if err := json.Unmarshal(fb, &spans[i]); err != nil {
+			return nil, nil, fmt.Errorf("span %d: %v", i, err)
+		}
+		spans[i].PageNum = pageNum
+		if pageNum == 0 {
+			missing = append(missing, i)
+		}
+	}
+	return spans, missing, nil
+}
+
+// legacyPageNum returns the page number in JSON value `raw` or 0 if it isn't a valid page number.
+func legacyPageNum(raw json.RawMessage) uint32 {
+	s := string(bytes.TrimSpace(raw))
+	if s == "" || s == "null" {
+		return 0
+	}
+	if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+		if n <= 0 || n > math.MaxUint32 {
+			return 0
+		}
+		return uint32(n)
+	}
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil || f <= 0 || f > math.MaxUint32 || f != math.Trunc(f) {
+		return 0
+	}
+	return uint32(f)
+}
+
+// dataPageNum returns the page number recorded in the positions data of the page with span `e` in
+// `data`, the contents of a .dat file, or 0 if there isn't one.
+func dataPageNum(e byteSpan, data []byte) uint32 {
+	end := uint64(e.Offset) + uint64(e.Size)
+	if end > uint64(len(data)) {
+		return 0
+	}
+	buf := data[e.Offset:end]
+	if crc32.ChecksumIEEE(buf) != e.Check {
+		return 0
+	}
+	dpl, err := serial.ReadDocPageLocations(buf)
+	if err != nil {
+		return 0
+	}
+	return dpl.Page
+}
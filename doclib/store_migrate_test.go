@@ -0,0 +1,56 @@
+package doclib
+
+import "testing"
+
+func TestDecodeLegacySpans(t *testing.T) {
+	b := []byte(`[
+		{"Offset": 0, "Size": 10, "Check": 1},
+		{"Offset": 10, "Size": 20, "Check": 2, "PageNum": 3},
+		{"Offset": 30, "Size": 5, "Check": 3, "PageNum": -1},
+		{"Offset": 35, "Size": 5, "Check": 4, "PageNum": 7.0},
+		{"Offset": 40, "Size": 5, "Check": 5, "PageNum": null, "TextHash": "abc"}
+	]`)
+	spans, missing, err := decodeLegacySpans(b)
+	if err != nil {
+		t.Fatalf("decodeLegacySpans failed. err=%v", err)
+	}
+	if len(spans) != 5 {
+		t.Fatalf("Expected 5 spans, got %d", len(spans))
+	}
+	expected := []uint32{0, 3, 0, 7, 0}
+	for i, e := range spans {
+		if e.PageNum != expected[i] {
+			t.Errorf("span %d: PageNum=%d expected=%d", i, e.PageNum, expected[i])
+		}
+	}
+	if spans[1].Offset != 10 || spans[1].Size != 20 || spans[4].TextHash != "abc" {
+		t.Errorf("Bad spans %+v", spans)
+	}
+	if len(missing) != 3 || missing[0] != 0 || missing[1] != 2 || missing[2] != 4 {
+		t.Errorf("Bad missing page numbers %v", missing)
+	}
+
+	if _, _, err := decodeLegacySpans([]byte(`[{"Offset": -1}]`)); err == nil {
+		t.Errorf("Expected an error for a negative offset")
+	}
+}
+
+func TestLegacyPageNum(t *testing.T) {
+	tests := map[string]uint32{
+		"":           0,
+		"null":       0,
+		"0":          0,
+		"-2":         0,
+		"1.5":        0,
+		`"3"`:        0,
+		"4294967296": 0,
+		"12":         12,
+		" 12 ":       12,
+		"12.0":       12,
+	}
+	for raw, expected := range tests {
+		if got := legacyPageNum([]byte(raw)); got != expected {
+			t.Errorf("legacyPageNum(%q)=%d expected=%d", raw, got, expected)
+		}
+	}
+}
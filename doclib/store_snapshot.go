@@ -0,0 +1,314 @@
+package doclib
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/unidoc/unidoc/common"
+)
+
+/*
+   A snapshot is a store packaged as a single tar.gz archive, for backups and for copying prebuilt
+   stores to machines that can't build them, such as air-gapped ones. It has all the files of the
+   store except its lock file, with their paths relative to the store directory, followed by
+   snapshot.json, a SnapshotManifest that lists the files with their sizes and SHA-256 hashes.
+   ImportSnapshot checks the files against the manifest before it installs the store.
+*/
+
+// SnapshotVersion is the version of the snapshot format that ExportSnapshot writes.
+const SnapshotVersion = 1
+
+// snapshotManifestName is the name of the manifest in a snapshot archive.
+const snapshotManifestName = "snapshot.json"
+
+// ErrBadSnapshot is returned when a snapshot archive is damaged or doesn't match its manifest.
+var ErrBadSnapshot = errors.New("bad snapshot")
+
+// SnapshotManifest describes a snapshot of a store. See ExportSnapshot.
+type SnapshotManifest struct {
+	Version     int       // SnapshotVersion of the snapshot.
+	Created     time.Time // When the snapshot was made.
+	NumDocs     int       // Number of documents in the store.
+	MappingHash string    `json:",omitempty"` // Index mapping of the store's bleve index.
+	// NumFrozen is the number of documents whose positions data was in cold storage, and so isn't
+	// in the snapshot.
+	NumFrozen int `json:",omitempty"`
+	Files     []SnapshotFile
+}
+
+// SnapshotFile is a file in a snapshot.
+type SnapshotFile struct {
+	Path   string // Path relative to the store directory with / separators.
+	Size   int64
+	SHA256 string // Hex encoded SHA-256 digest of the contents.
+}
+
+// ExportSnapshot writes a snapshot of the store in `persistDir` to `w` as a tar.gz archive and
+// returns its manifest. The store is locked while it is exported so writers can't change it;
+// searchers can keep using it. The positions data of frozen documents is not included.
+// See Store.FreezeDocs.
+func ExportSnapshot(persistDir string, w io.Writer) (SnapshotManifest, error) {
+	m := SnapshotManifest{Version: SnapshotVersion, Created: time.Now()}
+	if !Exists(filepath.Join(persistDir, "file_list.json")) {
+		return m, fmt.Errorf("Could not export %q. err=%v", persistDir, ErrNotStore)
+	}
+	lock, err := LockStore(persistDir)
+	if err != nil {
+		return m, err
+	}
+	defer lock.Unlock()
+
+	lState, err := OpenPositionsState(persistDir, false)
+	if err != nil {
+		return m, fmt.Errorf("Could not open positions store %q. err=%v", persistDir, err)
+	}
+	info, err := lState.StoreInfo()
+	if err != nil {
+		return m, err
+	}
+	m.NumDocs = info.NumDocs
+	m.MappingHash = info.MappingHash
+	for _, fd := range lState.fileList {
+		if fd.Cold {
+			m.NumFrozen++
+		}
+	}
+	if m.NumFrozen > 0 {
+		common.Log.Error("ExportSnapshot: %d documents in %q are frozen. Their positions data "+
+			"isn't in the snapshot.", m.NumFrozen, persistDir)
+	}
+
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+	err = filepath.Walk(persistDir, func(filename string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(persistDir, filename)
+		if err != nil {
+			return err
+		}
+		if rel == "." || rel == lockFileName {
+			return nil
+		}
+		hdr, err := tar.FileInfoHeader(fi, "")
+		if err != nil {
+			return err
+		}
+		hdr.Name = filepath.ToSlash(rel)
+		if fi.IsDir() {
+			hdr.Name += "/"
+			return tw.WriteHeader(hdr)
+		}
+		if !fi.Mode().IsRegular() {
+			return fmt.Errorf("Could not export %q. It isn't a regular file", filename)
+		}
+		sf, err := writeSnapshotFile(tw, hdr, filename)
+		if err != nil {
+			return err
+		}
+		m.Files = append(m.Files, sf)
+		return nil
+	})
+	if err != nil {
+		return m, fmt.Errorf("Could not export %q. err=%v", persistDir, err)
+	}
+
+	b, err := json.MarshalIndent(m, "", "\t")
+	if err != nil {
+		return m, err
+	}
+	hdr := &tar.Header{Name: snapshotManifestName, Mode: 0666, Size: int64(len(b)),
+		ModTime: m.Created, Typeflag: tar.TypeReg}
+	if err := tw.WriteHeader(hdr); err != nil {
+		return m, err
+	}
+	if _, err := tw.Write(b); err != nil {
+		return m, err
+	}
+	if err := tw.Close(); err != nil {
+		return m, err
+	}
+	if err := gz.Close(); err != nil {
+		return m, err
+	}
+	common.Log.Info("ExportSnapshot: Exported %d files of %q", len(m.Files), persistDir)
+	return m, nil
+}
+
+// writeSnapshotFile writes the file `filename` to `tw` with header `hdr` and returns its
+// SnapshotFile.
+func writeSnapshotFile(tw *tar.Writer, hdr *tar.Header, filename string) (SnapshotFile, error) {
+	sf := SnapshotFile{Path: hdr.Name}
+	f, err := os.Open(filename)
+	if err != nil {
+		return sf, err
+	}
+	defer f.Close()
+	if err := tw.WriteHeader(hdr); err != nil {
+		return sf, err
+	}
+	hasher := sha256.New()
+	n, err := io.Copy(io.MultiWriter(tw, hasher), f)
+	if err != nil {
+		return sf, err
+	}
+	if n != hdr.Size {
+		return sf, fmt.Errorf("%q changed while it was exported", filename)
+	}
+	sf.Size = n
+	sf.SHA256 = hex.EncodeToString(hasher.Sum(nil))
+	return sf, nil
+}
+
+// ImportSnapshot installs the store in the snapshot archive read from `r` in directory
+// `persistDir`, which must not exist. It returns the manifest of the snapshot. The snapshot is
+// unpacked next to `persistDir` and is only moved to `persistDir` once all its files have been
+// checked against the manifest, so a damaged snapshot doesn't leave a partial store.
+func ImportSnapshot(r io.Reader, persistDir string) (SnapshotManifest, error) {
+	var m SnapshotManifest
+	if Exists(persistDir) {
+		return m, fmt.Errorf("Could not import snapshot to %q. %q exists", persistDir, persistDir)
+	}
+	abs, err := filepath.Abs(persistDir)
+	if err != nil {
+		return m, err
+	}
+	if err := MkDir(filepath.Dir(abs)); err != nil {
+		return m, err
+	}
+	tmpDir, err := ioutil.TempDir(filepath.Dir(abs), filepath.Base(abs)+".import")
+	if err != nil {
+		return m, err
+	}
+	installed := false
+	defer func() {
+		if !installed {
+			os.RemoveAll(tmpDir)
+		}
+	}()
+
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return m, fmt.Errorf("Could not read snapshot. err=%v", err)
+	}
+	tr := tar.NewReader(gz)
+	got := map[string]SnapshotFile{}
+	haveManifest := false
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return m, fmt.Errorf("Could not read snapshot. err=%v", err)
+		}
+		name, err := snapshotPath(hdr.Name)
+		if err != nil {
+			return m, err
+		}
+		switch {
+		case name == snapshotManifestName:
+			if err := json.NewDecoder(tr).Decode(&m); err != nil {
+				return m, fmt.Errorf("Could not parse snapshot manifest. err=%v", err)
+			}
+			haveManifest = true
+		case hdr.Typeflag == tar.TypeDir:
+			if err := MkDir(filepath.Join(tmpDir, filepath.FromSlash(name))); err != nil {
+				return m, err
+			}
+		case hdr.Typeflag == tar.TypeReg:
+			sf, err := readSnapshotFile(tr, tmpDir, name)
+			if err != nil {
+				return m, err
+			}
+			got[name] = sf
+		default:
+			return m, fmt.Errorf("Could not import %q. Unsupported type %q. err=%v", hdr.Name,
+				hdr.Typeflag, ErrBadSnapshot)
+		}
+	}
+	if !haveManifest {
+		return m, fmt.Errorf("Snapshot has no %s. err=%v", snapshotManifestName, ErrBadSnapshot)
+	}
+	if m.Version > SnapshotVersion {
+		return m, fmt.Errorf("Snapshot version %d is newer than %d. Upgrade pdf-search",
+			m.Version, SnapshotVersion)
+	}
+	if err := checkSnapshotFiles(m, got); err != nil {
+		return m, err
+	}
+	if err := os.Rename(tmpDir, abs); err != nil {
+		return m, fmt.Errorf("Could not install snapshot in %q. err=%v", persistDir, err)
+	}
+	installed = true
+	common.Log.Info("ImportSnapshot: Imported %d files, %d documents to %q", len(m.Files),
+		m.NumDocs, persistDir)
+	return m, nil
+}
+
+// readSnapshotFile writes the contents of the current file in `tr`, which has path `name` in the
+// snapshot, under directory `dir` and returns its SnapshotFile.
+func readSnapshotFile(tr *tar.Reader, dir, name string) (SnapshotFile, error) {
+	sf := SnapshotFile{Path: name}
+	filename := filepath.Join(dir, filepath.FromSlash(name))
+	if err := MkParentDir(filename); err != nil {
+		return sf, err
+	}
+	f, err := os.Create(filename)
+	if err != nil {
+		return sf, err
+	}
+	defer f.Close()
+	hasher := sha256.New()
+	n, err := io.Copy(io.MultiWriter(f, hasher), tr)
+	if err != nil {
+		return sf, fmt.Errorf("Could not read %q from snapshot. err=%v", name, err)
+	}
+	sf.Size = n
+	sf.SHA256 = hex.EncodeToString(hasher.Sum(nil))
+	return sf, f.Close()
+}
+
+// snapshotPath returns the cleaned form of path `name` of an entry in a snapshot archive. It
+// returns an error for paths that would be outside the store directory.
+func snapshotPath(name string) (string, error) {
+	clean := path.Clean(name)
+	if path.IsAbs(clean) || clean == "." || clean == ".." || strings.HasPrefix(clean, "../") {
+		return "", fmt.Errorf("Could not import %q. It is outside the store. err=%v", name,
+			ErrBadSnapshot)
+	}
+	return clean, nil
+}
+
+// checkSnapshotFiles returns an error if the files `got` that were read from a snapshot aren't the
+// files in the snapshot's manifest `m`.
+func checkSnapshotFiles(m SnapshotManifest, got map[string]SnapshotFile) error {
+	for _, want := range m.Files {
+		sf, ok := got[want.Path]
+		if !ok {
+			return fmt.Errorf("Snapshot is missing %q. err=%v", want.Path, ErrBadSnapshot)
+		}
+		if sf != want {
+			return fmt.Errorf("Snapshot file %q is damaged. size=%d want %d. err=%v",
+				want.Path, sf.Size, want.Size, ErrBadSnapshot)
+		}
+	}
+	if len(got) != len(m.Files) {
+		return fmt.Errorf("Snapshot has %d files that aren't in its manifest. err=%v",
+			len(got)-len(m.Files), ErrBadSnapshot)
+	}
+	return nil
+}
@@ -0,0 +1,51 @@
+package doclib
+
+import "testing"
+
+func TestSnapshotPath(t *testing.T) {
+	tests := map[string]string{
+		"file_list.json":         "file_list.json",
+		"positions/abc.dat":      "positions/abc.dat",
+		"bleve/":                 "bleve",
+		"./texts/ab/../ab/x.txt": "texts/ab/x.txt",
+	}
+	for name, expected := range tests {
+		got, err := snapshotPath(name)
+		if err != nil {
+			t.Errorf("snapshotPath(%q) failed. err=%v", name, err)
+		} else if got != expected {
+			t.Errorf("snapshotPath(%q)=%q expected=%q", name, got, expected)
+		}
+	}
+	for _, name := range []string{"/etc/passwd", "..", "../x", "a/../../x", "."} {
+		if _, err := snapshotPath(name); err == nil {
+			t.Errorf("snapshotPath(%q) should have failed", name)
+		}
+	}
+}
+
+func TestCheckSnapshotFiles(t *testing.T) {
+	a := SnapshotFile{Path: "file_list.json", Size: 10, SHA256: "aa"}
+	b := SnapshotFile{Path: "positions/x.dat", Size: 20, SHA256: "bb"}
+	m := SnapshotManifest{Files: []SnapshotFile{a, b}}
+	got := map[string]SnapshotFile{a.Path: a, b.Path: b}
+	if err := checkSnapshotFiles(m, got); err != nil {
+		t.Errorf("checkSnapshotFiles failed. err=%v", err)
+	}
+
+	damaged := b
+	damaged.SHA256 = "cc"
+	got[b.Path] = damaged
+	if err := checkSnapshotFiles(m, got); err == nil {
+		t.Errorf("Expected an error for a damaged file")
+	}
+	delete(got, b.Path)
+	if err := checkSnapshotFiles(m, got); err == nil {
+		t.Errorf("Expected an error for a missing file")
+	}
+	got[b.Path] = b
+	got["extra.txt"] = SnapshotFile{Path: "extra.txt"}
+	if err := checkSnapshotFiles(m, got); err == nil {
+		t.Errorf("Expected an error for a file that isn't in the manifest")
+	}
+}
@@ -0,0 +1,187 @@
+package doclib
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// statsLargestDocs is the number of documents in StoreStats.Largest.
+const statsLargestDocs = 10
+
+// BuildStats describes an indexing run.
+type BuildStats struct {
+	Finished time.Time     `json:"finished"`
+	Duration time.Duration `json:"duration"`
+	NumDocs  int           `json:"numDocs"`  // Number of documents that were added.
+	NumPages int           `json:"numPages"` // Number of pages that were added.
+}
+
+// PagesPerSec returns the indexing rate of the run described by `b`.
+func (b BuildStats) PagesPerSec() float64 {
+	if b.Duration <= 0 {
+		return 0.0
+	}
+	return float64(b.NumPages) / b.Duration.Seconds()
+}
+
+// StoreStats are the corpus level statistics of a store.
+type StoreStats struct {
+	NumDocs  int `json:"numDocs"`
+	NumPages int `json:"numPages"`
+	// LowQualityPages is the number of pages with a TextQuality below LowTextQuality.
+	LowQualityPages int `json:"lowQualityPages"`
+	// TextBytes is the total size of the extracted page texts in bytes. Pages with the same text
+	// are counted separately.
+	TextBytes int64 `json:"textBytes"`
+	// DiskUsage is {component: bytes on disk} for the components of the store: "bleve",
+	// "positions", "texts" and "other". It is empty for in-memory stores. Documents in cold storage
+	// are not counted.
+	DiskUsage map[string]int64 `json:"diskUsage"`
+	// LastBuild describes the last indexing run. It is nil for in-memory stores and stores that
+	// were built before indexing runs were recorded.
+	LastBuild *BuildStats `json:"lastBuild,omitempty"`
+	Largest   []DocInfo   `json:"largest"` // The documents with the most pages, largest first.
+}
+
+func (s StoreStats) String() string {
+	parts := []string{fmt.Sprintf("%d documents, %d pages (%d low quality), %.1f MB of text",
+		s.NumDocs, s.NumPages, s.LowQualityPages, float64(s.TextBytes)/1024.0/1024.0)}
+	var components []string
+	for c := range s.DiskUsage {
+		components = append(components, c)
+	}
+	sort.Strings(components)
+	for _, c := range components {
+		parts = append(parts, fmt.Sprintf("%10s: %8.1f MB", c,
+			float64(s.DiskUsage[c])/1024.0/1024.0))
+	}
+	if b := s.LastBuild; b != nil {
+		parts = append(parts, fmt.Sprintf("Last build %s: %d documents, %d pages in %.1f sec "+
+			"(%.1f pages/sec)", b.Finished.Format("2006-01-02 15:04"), b.NumDocs, b.NumPages,
+			b.Duration.Seconds(), b.PagesPerSec()))
+	}
+	for _, d := range s.Largest {
+		parts = append(parts, fmt.Sprintf("%4d: %4d pages %6.2f MB %q",
+			d.DocIdx, d.NumPages, d.SizeMB, d.InPath))
+	}
+	return strings.Join(parts, "\n")
+}
+
+// ReadStoreStats returns the StoreStats of the store in `persistDir`.
+func ReadStoreStats(persistDir string) (StoreStats, error) {
+	lState, err := OpenPositionsState(persistDir, false)
+	if err != nil {
+		return StoreStats{}, err
+	}
+	defer lState.Close()
+	return lState.Stats()
+}
+
+// Stats returns the corpus level statistics of `lState`. It reads the page spans of every
+// document so it takes a while on big stores.
+func (lState *Store) Stats() (StoreStats, error) {
+	stats := StoreStats{NumDocs: lState.Len(), DiskUsage: map[string]int64{}}
+	var docs []DocInfo
+	for i, fd := range lState.fileList {
+		docIdx := uint64(i)
+		info := lState.docInfo(docIdx, fd)
+		stats.NumPages += info.NumPages
+		stats.LowQualityPages += info.LowQualityPages
+		n, err := lState.docTextBytes(docIdx)
+		if err != nil {
+			return stats, err
+		}
+		stats.TextBytes += n
+		docs = append(docs, info)
+	}
+	sort.SliceStable(docs, func(i, j int) bool { return docs[i].NumPages > docs[j].NumPages })
+	if len(docs) > statsLargestDocs {
+		docs = docs[:statsLargestDocs]
+	}
+	stats.Largest = docs
+
+	if lState.isMem() {
+		return stats, nil
+	}
+	m, err := loadManifest(lState.root)
+	if err != nil {
+		return stats, err
+	}
+	stats.LastBuild = m.LastBuild
+	stats.DiskUsage, err = diskUsage(lState.root)
+	return stats, err
+}
+
+// docTextBytes returns the total size of the page texts of document `docIdx` in `lState`.
+func (lState *Store) docTextBytes(docIdx uint64) (int64, error) {
+	var n int64
+	if lState.isMem() {
+		lDoc, err := lState.OpenPositionsDoc(docIdx)
+		if err != nil {
+			return 0, err
+		}
+		for _, text := range lDoc.pageTexts {
+			n += int64(len(text))
+		}
+		return n, nil
+	}
+	lDoc, err := lState.openSpans(docIdx)
+	if err != nil {
+		return 0, nil // docInfo reports the document as quarantined.
+	}
+	for pageIdx := range lDoc.spans {
+		fi, err := os.Stat(lDoc.GetTextPath(uint32(pageIdx)))
+		if err != nil {
+			continue // The page text is in cold storage.
+		}
+		n += fi.Size()
+	}
+	return n, nil
+}
+
+// diskUsage returns {component: bytes} for the files in store directory `root`. See
+// StoreStats.DiskUsage.
+func diskUsage(root string) (map[string]int64, error) {
+	usage := map[string]int64{}
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		component := strings.Split(filepath.ToSlash(rel), "/")[0]
+		switch component {
+		case "bleve", "positions":
+		case textsDirName:
+			component = "texts"
+		default:
+			component = "other"
+		}
+		usage[component] += info.Size()
+		return nil
+	})
+	return usage, err
+}
+
+// recordBuild records `build` as the last indexing run of `lState` in its manifest. In-memory
+// stores have no manifest.
+func (lState *Store) recordBuild(build BuildStats) error {
+	if lState.isMem() {
+		return nil
+	}
+	m, err := loadManifest(lState.root)
+	if err != nil {
+		return err
+	}
+	m.LastBuild = &build
+	return saveManifest(lState.root, m)
+}
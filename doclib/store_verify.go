@@ -0,0 +1,377 @@
+package doclib
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/blevesearch/bleve"
+	"github.com/unidoc/unidoc/common"
+)
+
+// Kinds of VerifyProblem.
+const (
+	// ProblemDamagedDoc is a document whose positions files or page texts are missing or whose
+	// positions fail their checksums.
+	ProblemDamagedDoc = "damaged-doc"
+	// ProblemUnindexedDoc is a document with pages that has no pages in the bleve index.
+	ProblemUnindexedDoc = "unindexed-doc"
+	// ProblemOrphanFile is a positions file of a document that isn't in file_list.json.
+	ProblemOrphanFile = "orphan-file"
+	// ProblemOrphanText is a page text that no document refers to.
+	ProblemOrphanText = "orphan-text"
+	// ProblemOrphanID is a bleve document for a page that isn't in the store.
+	ProblemOrphanID = "orphan-id"
+	// ProblemTextRefs is a page text whose count in text_refs.json is wrong.
+	ProblemTextRefs = "text-refs"
+)
+
+// VerifyProblem is an inconsistency in a store that was found by VerifyStore.
+type VerifyProblem struct {
+	Kind   string // One of the Problem* kinds.
+	DocIdx int    // Index of the document with the problem. -1 if it isn't in the file list.
+	Path   string // PDF path, file path, page text hash or bleve ID.
+	Detail string `json:",omitempty"`
+}
+
+func (p VerifyProblem) String() string {
+	s := fmt.Sprintf("%-13s %q", p.Kind, p.Path)
+	if p.DocIdx >= 0 {
+		s = fmt.Sprintf("%s docIdx=%d", s, p.DocIdx)
+	}
+	if p.Detail != "" {
+		s = fmt.Sprintf("%s %s", s, p.Detail)
+	}
+	return s
+}
+
+// VerifyReport is the result of VerifyStore.
+type VerifyReport struct {
+	NumDocs  int // Number of documents in file_list.json.
+	NumPages int // Number of pages in the documents' positions files.
+	NumIDs   int // Number of documents in the bleve index.
+	Problems []VerifyProblem
+	// Repaired is true if the problems were repaired.
+	Repaired bool
+}
+
+// OK returns true if no problems were found.
+func (r VerifyReport) OK() bool {
+	return len(r.Problems) == 0
+}
+
+func (r VerifyReport) String() string {
+	parts := []string{fmt.Sprintf("%d documents, %d pages, %d bleve documents, %d problems",
+		r.NumDocs, r.NumPages, r.NumIDs, len(r.Problems))}
+	for _, p := range r.Problems {
+		parts = append(parts, "  "+p.String())
+	}
+	if r.Repaired {
+		parts = append(parts, "Repaired.")
+	}
+	return strings.Join(parts, "\n")
+}
+
+// verifyPageSize is the number of bleve documents that are listed per search by bleveIDs.
+const verifyPageSize = 10000
+
+// positionsSuffixes are the suffixes of the files that store a document's positions. See
+// docPersistPaths.
+var positionsSuffixes = []string{".dat", ".idx.json", ".dpl.json", ".pages"}
+
+// VerifyStore checks that the parts of the store in `persistDir` are consistent: every document in
+// file_list.json has readable positions files and page texts whose positions pass their CRC
+// checks, every positions file and page text belongs to a document, every bleve document is a page
+// of a document and text_refs.json has the right counts.
+// Documents in cold storage are not read. If `repair` is true then dangling entries are pruned:
+// damaged documents, orphan files and orphan bleve documents are deleted, unindexed documents are
+// indexed from their stored texts and the page text counts are rebuilt.
+func VerifyStore(persistDir string, repair bool) (VerifyReport, error) {
+	indexPath := filepath.Join(persistDir, "bleve")
+	index, err := OpenStoreIndex(persistDir)
+	if err != nil {
+		return VerifyReport{}, fmt.Errorf("Could not open Bleve index %q. err=%v", indexPath, err)
+	}
+	defer index.Close()
+
+	lState, err := OpenPositionsState(persistDir, false)
+	if err != nil {
+		return VerifyReport{}, fmt.Errorf("Could not open positions store %q. err=%v",
+			persistDir, err)
+	}
+	defer lState.Close()
+	return lState.Verify(index, repair)
+}
+
+// Verify checks that `lState` and `index` are consistent. See VerifyStore.
+func (lState *Store) Verify(index bleve.Index, repair bool) (VerifyReport, error) {
+	report := VerifyReport{NumDocs: len(lState.fileList)}
+	if lState.isMem() {
+		return report, errors.New("in-memory stores can't be verified")
+	}
+	ids, err := bleveIDs(index)
+	if err != nil {
+		return report, err
+	}
+	report.NumIDs = len(ids)
+
+	// docIDs is {docIdx: bleve IDs of the document's pages}.
+	docIDs := map[uint64][]string{}
+	idPages := map[string]uint32{}
+	var orphanIDs []string
+	for _, id := range ids {
+		docIdx, pageIdx, err := decodeID(id)
+		if err != nil || int(docIdx) >= len(lState.fileList) {
+			orphanIDs = append(orphanIDs, id)
+			continue
+		}
+		docIDs[docIdx] = append(docIDs[docIdx], id)
+		idPages[id] = pageIdx
+	}
+
+	var damaged, unindexed []uint64
+	textRefs := map[string]int{}
+	complete := true // All the documents' page spans were read.
+	for i, fd := range lState.fileList {
+		docIdx := uint64(i)
+		numPages, hashes, err := lState.verifyDoc(docIdx, fd.Cold)
+		if err != nil {
+			complete = false
+			damaged = append(damaged, docIdx)
+			report.Problems = append(report.Problems, VerifyProblem{Kind: ProblemDamagedDoc,
+				DocIdx: i, Path: fd.InPath, Detail: err.Error()})
+			continue
+		}
+		report.NumPages += numPages
+		for _, hash := range hashes {
+			textRefs[hash]++
+		}
+		for _, id := range docIDs[docIdx] {
+			if int(idPages[id]) >= numPages {
+				orphanIDs = append(orphanIDs, id)
+			}
+		}
+		if numPages > 0 && len(docIDs[docIdx]) == 0 {
+			unindexed = append(unindexed, docIdx)
+			report.Problems = append(report.Problems, VerifyProblem{Kind: ProblemUnindexedDoc,
+				DocIdx: i, Path: fd.InPath, Detail: fmt.Sprintf("%d pages", numPages)})
+		}
+	}
+	for _, id := range orphanIDs {
+		report.Problems = append(report.Problems, VerifyProblem{Kind: ProblemOrphanID,
+			DocIdx: -1, Path: id})
+	}
+
+	orphanFiles, err := lState.orphanPositionsFiles()
+	if err != nil {
+		return report, err
+	}
+	for _, path := range orphanFiles {
+		report.Problems = append(report.Problems, VerifyProblem{Kind: ProblemOrphanFile,
+			DocIdx: -1, Path: path})
+	}
+	// The page texts of damaged documents aren't known so orphan texts can't be found.
+	if complete {
+		problems, err := lState.verifyTexts(textRefs)
+		if err != nil {
+			return report, err
+		}
+		report.Problems = append(report.Problems, problems...)
+	}
+
+	common.Log.Info("Verify: %d docs %d pages %d ids %d problems", report.NumDocs,
+		report.NumPages, report.NumIDs, len(report.Problems))
+	if !repair || report.OK() {
+		return report, nil
+	}
+	err = lState.repair(index, orphanIDs, damaged, unindexed, docIDs, orphanFiles)
+	report.Repaired = err == nil
+	return report, err
+}
+
+// bleveIDs returns the IDs of all the documents in `index` in ID order.
+func bleveIDs(index bleve.Index) ([]string, error) {
+	var ids []string
+	for from := 0; ; from += verifyPageSize {
+		search := bleve.NewSearchRequestOptions(bleve.NewMatchAllQuery(), verifyPageSize, from,
+			false)
+		search.SortBy([]string{"_id"})
+		results, err := index.Search(search)
+		if err != nil {
+			return nil, err
+		}
+		for _, hit := range results.Hits {
+			ids = append(ids, hit.ID)
+		}
+		if len(results.Hits) < verifyPageSize {
+			break
+		}
+	}
+	return ids, nil
+}
+
+// verifyDoc returns the number of pages of document `docIdx` in `lState` and the hashes of its
+// content-addressed page texts. It returns an error if the document's positions or page texts
+// can't be read or its positions fail their checksums. Only the page spans of documents in cold
+// storage, `cold`, are read.
+func (lState *Store) verifyDoc(docIdx uint64, cold bool) (int, []string, error) {
+	var lDoc *Doc
+	var err error
+	if cold {
+		lDoc, err = lState.openSpans(docIdx)
+	} else {
+		lDoc, err = lState.OpenPositionsDoc(docIdx)
+		if err == nil {
+			defer lDoc.Close()
+		}
+	}
+	if err != nil {
+		return 0, nil, err
+	}
+	var hashes []string
+	for pageIdx, span := range lDoc.spans {
+		if span.TextHash != "" {
+			hashes = append(hashes, span.TextHash)
+		}
+		if cold {
+			continue
+		}
+		if _, _, err := lDoc.ReadPagePositions(uint32(pageIdx)); err != nil {
+			return 0, nil, fmt.Errorf("page %d positions: %v", pageIdx, err)
+		}
+		if _, err := lDoc.ReadPageText(uint32(pageIdx)); err != nil {
+			return 0, nil, fmt.Errorf("page %d text: %v", pageIdx, err)
+		}
+	}
+	return lDoc.Len(), hashes, nil
+}
+
+// orphanPositionsFiles returns the paths of the files in the positions directory of `lState`
+// that belong to documents that aren't in the file list.
+func (lState *Store) orphanPositionsFiles() ([]string, error) {
+	infos, err := ioutil.ReadDir(lState.positionsDir())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var orphans []string
+	for _, fi := range infos {
+		name := fi.Name()
+		for _, suffix := range positionsSuffixes {
+			if !strings.HasSuffix(name, suffix) {
+				continue
+			}
+			if _, ok := lState.hashIndex[strings.TrimSuffix(name, suffix)]; !ok {
+				orphans = append(orphans, filepath.Join(lState.positionsDir(), name))
+			}
+			break
+		}
+	}
+	return orphans, nil
+}
+
+// verifyTexts returns the problems with the page texts of `lState`. `textRefs` is {page text
+// hash: number of pages in the documents of `lState` with that text}.
+func (lState *Store) verifyTexts(textRefs map[string]int) ([]VerifyProblem, error) {
+	var problems []VerifyProblem
+	err := filepath.Walk(lState.textsDir(), func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+		if hash := strings.TrimSuffix(filepath.Base(path), ".txt"); textRefs[hash] == 0 {
+			problems = append(problems, VerifyProblem{Kind: ProblemOrphanText, DocIdx: -1,
+				Path: path})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := lState.loadTextRefs(); err != nil {
+		return nil, err
+	}
+	var hashes []string
+	for hash := range textRefs {
+		hashes = append(hashes, hash)
+	}
+	for hash := range lState.textRefs {
+		if _, ok := textRefs[hash]; !ok {
+			hashes = append(hashes, hash)
+		}
+	}
+	sort.Strings(hashes)
+	for _, hash := range hashes {
+		if n, expected := lState.textRefs[hash], textRefs[hash]; n != expected {
+			problems = append(problems, VerifyProblem{Kind: ProblemTextRefs, DocIdx: -1,
+				Path: hash, Detail: fmt.Sprintf("count=%d expected=%d", n, expected)})
+		}
+	}
+	return problems, nil
+}
+
+// repair fixes the problems found by Verify. The bleve documents `orphanIDs` and the positions
+// files `orphanFiles` are deleted. The documents `unindexed` are indexed from their stored texts.
+// The documents `damaged` are removed. `docIDs` is {docIdx: bleve IDs of the document's pages}.
+// Finally the page text counts are rebuilt and orphan page texts are deleted.
+func (lState *Store) repair(index bleve.Index, orphanIDs []string,
+	damaged, unindexed []uint64, docIDs map[uint64][]string, orphanFiles []string) error {
+
+	if err := lState.lockWriter(); err != nil {
+		return err
+	}
+	defer lState.unlockWriter()
+	b := newBatcher(index, maxBatchOps)
+	for _, id := range orphanIDs {
+		if err := b.delete(id); err != nil {
+			return err
+		}
+	}
+	for _, docIdx := range unindexed {
+		if err := lState.reindexDoc(b, docIdx, docIdx); err != nil {
+			return err
+		}
+	}
+	var hashes []string
+	for _, docIdx := range damaged {
+		for _, id := range docIDs[docIdx] {
+			if err := b.delete(id); err != nil {
+				return err
+			}
+		}
+		hashes = append(hashes, lState.fileList[docIdx].Hash)
+	}
+	if err := b.flush(); err != nil {
+		return err
+	}
+	if len(hashes) > 0 {
+		if err := lState.removeDocs(index, hashes, true); err != nil {
+			return err
+		}
+	}
+	for _, path := range orphanFiles {
+		if err := os.RemoveAll(path); err != nil {
+			return err
+		}
+	}
+	if err := lState.recountTextRefs(); err != nil {
+		return err
+	}
+	if err := lState.Flush(); err != nil {
+		return err
+	}
+	lState.bumpGeneration()
+	return nil
+}
@@ -0,0 +1,30 @@
+package doclib
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestVerifyReportString(t *testing.T) {
+	r := VerifyReport{NumDocs: 2, NumPages: 7, NumIDs: 8}
+	if !r.OK() {
+		t.Fatalf("Report with no problems is not OK %+v", r)
+	}
+	r.Problems = []VerifyProblem{
+		{Kind: ProblemDamagedDoc, DocIdx: 1, Path: "b.pdf", Detail: "page 3 positions: bad crc"},
+		{Kind: ProblemOrphanID, DocIdx: -1, Path: "0002.0"},
+	}
+	if r.OK() {
+		t.Fatalf("Report with problems is OK %+v", r)
+	}
+	lines := strings.Split(r.String(), "\n")
+	if len(lines) != 3 || !strings.HasPrefix(lines[0], "2 documents, 7 pages, 8 bleve documents") {
+		t.Fatalf("Bad report %q", r.String())
+	}
+	if !strings.Contains(lines[1], `"b.pdf" docIdx=1 page 3 positions`) {
+		t.Errorf("Bad damaged doc line %q", lines[1])
+	}
+	if strings.Contains(lines[2], "docIdx") {
+		t.Errorf("Orphan ID line has a docIdx %q", lines[2])
+	}
+}
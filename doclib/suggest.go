@@ -0,0 +1,69 @@
+package doclib
+
+import (
+	"strings"
+
+	"github.com/blevesearch/bleve"
+)
+
+// DefaultSuggestions is the number of suggestions that Suggest returns if it isn't told.
+const DefaultSuggestions = 10
+
+// Suggestion is a completion of a partly typed query.
+type Suggestion struct {
+	Text  string `json:"text"`  // The completed query.
+	Count uint64 `json:"count"` // Number of pages with the term that completes the query.
+}
+
+// Suggest returns up to `n` completions of the partly typed query `q` from the page text
+// dictionary of `index`, for search-as-you-type UIs. The last word of `q` is completed with the
+// terms that start with it, most frequent first, and the words before it are kept. e.g.
+// "portable docu" -> "portable document". `normalization` is the TextNormalization of the store.
+// The prefix is lower cased, as the page texts are when they are indexed. Nothing is suggested
+// for queries that end in a space since their last word is complete.
+func Suggest(index bleve.Index, normalization TextNormalization, q string, n int) (
+	[]Suggestion, error) {
+
+	head, prefix := splitSuggestQuery(normalization.normalizeQuery(q))
+	if prefix == "" {
+		return nil, nil
+	}
+	if n <= 0 {
+		n = DefaultSuggestions
+	}
+	top := newTopTerms(n)
+	err := walkTermDictPrefix(index, prefix, func(tc TermCount) error {
+		top.add(tc)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	var suggestions []Suggestion
+	for _, tc := range top.sorted() {
+		suggestions = append(suggestions, Suggestion{Text: head + tc.Term, Count: tc.Count})
+	}
+	return suggestions, nil
+}
+
+// Suggest returns up to `n` completions of query `q` from the page text dictionary of `x`. See
+// Suggest.
+func (x *PdfIndex) Suggest(q string, n int) ([]Suggestion, error) {
+	if err := x.refresh(); err != nil {
+		return nil, err
+	}
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+	if x.index == nil {
+		return nil, ErrClosed
+	}
+	return Suggest(x.index, x.lState.normalization, q, n)
+}
+
+// splitSuggestQuery splits query `q` into the words before its last word, with a trailing space,
+// and its lower cased last word. The last word is "" if `q` is empty or ends in a space.
+func splitSuggestQuery(q string) (head, prefix string) {
+	i := strings.LastIndexAny(q, " \t\n")
+	head, prefix = q[:i+1], q[i+1:]
+	return head, strings.ToLower(prefix)
+}
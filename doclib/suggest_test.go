@@ -0,0 +1,23 @@
+package doclib
+
+import "testing"
+
+func TestSplitSuggestQuery(t *testing.T) {
+	tests := []struct {
+		q, head, prefix string
+	}{
+		{"", "", ""},
+		{"ado", "", "ado"},
+		{"Ado", "", "ado"},
+		{"portable Docu", "portable ", "docu"},
+		{"portable ", "portable ", ""},
+		{"a  b\tc", "a  b\t", "c"},
+	}
+	for _, test := range tests {
+		head, prefix := splitSuggestQuery(test.q)
+		if head != test.head || prefix != test.prefix {
+			t.Errorf("splitSuggestQuery(%q)=%q, %q expected=%q, %q", test.q, head, prefix,
+				test.head, test.prefix)
+		}
+	}
+}
@@ -0,0 +1,227 @@
+package doclib
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode"
+
+	"github.com/blevesearch/bleve"
+	"github.com/blevesearch/bleve/search/query"
+	"github.com/unidoc/unidoc/common"
+)
+
+// synonymsFileName is the name of the synonym dictionary file in a store directory.
+const synonymsFileName = "synonyms.txt"
+
+// synonymBoost is the boost of matches of synonyms relative to matches of the query's words.
+const synonymBoost = 0.8
+
+// SynonymDict is a dictionary of words and phrases that mean the same thing, e.g. "colour" and
+// "color" or "POS" and "point of sale". Searches of a store with a SynonymDict also match the
+// synonyms of the words and phrases in their queries.
+// The phrases are lower cased and compared by their words, so "Point-of-Sale" is the same as
+// "point of sale".
+type SynonymDict struct {
+	groups       [][]string       // Groups of phrases that are synonyms of each other.
+	phraseGroups map[string][]int // {phrase: indexes of the groups that contain it}
+	maxWords     int              // Number of words in the longest phrase.
+}
+
+// ParseSynonyms returns the SynonymDict in `r`. Each line is a group of synonyms separated by
+// commas, e.g. "POS, point of sale". Blank lines and lines that start with # are ignored.
+func ParseSynonyms(r io.Reader) (*SynonymDict, error) {
+	var groups [][]string
+	scanner := bufio.NewScanner(r)
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		var group []string
+		for _, entry := range strings.Split(line, ",") {
+			if phrase := synonymPhrase(entry); phrase != "" {
+				group = append(group, phrase)
+			}
+		}
+		if len(group) < 2 {
+			return nil, fmt.Errorf("Synonyms line %d has fewer than 2 synonyms: %q", lineNum, line)
+		}
+		groups = append(groups, group)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return newSynonymDict(groups), nil
+}
+
+// LoadSynonyms returns the SynonymDict in text file `filename`. See ParseSynonyms.
+func LoadSynonyms(filename string) (*SynonymDict, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	d, err := ParseSynonyms(f)
+	if err != nil {
+		return nil, fmt.Errorf("Could not parse synonyms %q. err=%v", filename, err)
+	}
+	return d, nil
+}
+
+// loadStoreSynonyms returns the SynonymDict in store directory `persistDir` or nil if there isn't
+// one.
+func loadStoreSynonyms(persistDir string) (*SynonymDict, error) {
+	filename := filepath.Join(persistDir, synonymsFileName)
+	if persistDir == "" || !Exists(filename) {
+		return nil, nil
+	}
+	return LoadSynonyms(filename)
+}
+
+// save writes `d` to the synonym dictionary file of the store in `persistDir`.
+func (d *SynonymDict) save(persistDir string) error {
+	var sb strings.Builder
+	for _, group := range d.groups {
+		sb.WriteString(strings.Join(group, ", "))
+		sb.WriteString("\n")
+	}
+	return ioutil.WriteFile(filepath.Join(persistDir, synonymsFileName), []byte(sb.String()), 0666)
+}
+
+// newSynonymDict returns a SynonymDict for the synonym groups `groups`.
+func newSynonymDict(groups [][]string) *SynonymDict {
+	d := &SynonymDict{groups: groups, phraseGroups: map[string][]int{}}
+	for i, group := range groups {
+		for _, phrase := range group {
+			d.phraseGroups[phrase] = append(d.phraseGroups[phrase], i)
+			if n := len(strings.Fields(phrase)); n > d.maxWords {
+				d.maxWords = n
+			}
+		}
+	}
+	return d
+}
+
+// Len returns the number of synonym groups in `d`.
+func (d *SynonymDict) Len() int {
+	if d == nil {
+		return 0
+	}
+	return len(d.groups)
+}
+
+// synonymPhrase returns `s` lower cased with its words separated by single spaces.
+func synonymPhrase(s string) string {
+	return strings.Join(synonymWords(s), " ")
+}
+
+// synonymWords returns the lower cased words in `s`.
+func synonymWords(s string) []string {
+	return strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// synonymMatch is a phrase in a query that has synonyms.
+type synonymMatch struct {
+	phrase   string   // The phrase in the query.
+	synonyms []string // Its synonyms.
+}
+
+// match returns the phrases in query string `term` that have synonyms in `d` and the words in
+// `term` that aren't in those phrases. The longest phrase starting at each word is used.
+func (d *SynonymDict) match(term string) ([]synonymMatch, []string) {
+	if d.Len() == 0 {
+		return nil, nil
+	}
+	var matches []synonymMatch
+	var rest []string
+	words := synonymWords(term)
+	for i := 0; i < len(words); {
+		n := d.maxWords
+		if n > len(words)-i {
+			n = len(words) - i
+		}
+		for ; n > 0; n-- {
+			phrase := strings.Join(words[i:i+n], " ")
+			if synonyms := d.synonyms(phrase); len(synonyms) > 0 {
+				matches = append(matches, synonymMatch{phrase: phrase, synonyms: synonyms})
+				break
+			}
+		}
+		if n == 0 {
+			rest = append(rest, words[i])
+			n = 1
+		}
+		i += n
+	}
+	if len(matches) == 0 {
+		return nil, nil
+	}
+	return matches, rest
+}
+
+// synonyms returns the phrases in `d` that are synonyms of `phrase`.
+func (d *SynonymDict) synonyms(phrase string) []string {
+	seen := map[string]bool{phrase: true}
+	var synonyms []string
+	for _, i := range d.phraseGroups[phrase] {
+		for _, s := range d.groups[i] {
+			if !seen[s] {
+				seen[s] = true
+				synonyms = append(synonyms, s)
+			}
+		}
+	}
+	return synonyms
+}
+
+// synonymQuery returns `q`, the query for query string `term` over `index`, extended to also match
+// the synonyms in `d` of the words and phrases in `term`. Matches of synonyms score lower than
+// matches of the query's words. If `allTerms` is true then each word or phrase in `term`, or one
+// of its synonyms, must be on a page. Queries with field scopes are not changed.
+func (d *SynonymDict) synonymQuery(index bleve.Index, q query.Query, term string, allTerms bool,
+	fuzziness int) query.Query {
+
+	if hasFieldScope(term) {
+		return q
+	}
+	matches, rest := d.match(term)
+	if len(matches) == 0 {
+		return q
+	}
+	common.Log.Debug("synonymQuery: term=%q matches=%+v", term, matches)
+	var alternatives []query.Query
+	for _, m := range matches {
+		disjuncts := []query.Query{phraseQuery(m.phrase, 1)}
+		for _, s := range m.synonyms {
+			disjuncts = append(disjuncts, phraseQuery(s, synonymBoost))
+		}
+		alternatives = append(alternatives, bleve.NewDisjunctionQuery(disjuncts...))
+	}
+	if !allTerms {
+		return bleve.NewDisjunctionQuery(append([]query.Query{q}, alternatives...)...)
+	}
+	restTerm := strings.Join(rest, " ")
+	if len(analyzeTerms(index, restTerm)) > 0 {
+		alternatives = append(alternatives, allTermsQuery(index, restTerm, fuzziness))
+	}
+	return bleve.NewConjunctionQuery(alternatives...)
+}
+
+// phraseQuery returns a query with boost `boost` for the words in `phrase` in that order.
+func phraseQuery(phrase string, boost float64) query.Query {
+	if !strings.Contains(phrase, " ") {
+		q := bleve.NewMatchQuery(phrase)
+		q.SetBoost(boost)
+		return q
+	}
+	q := bleve.NewMatchPhraseQuery(phrase)
+	q.SetBoost(boost)
+	return q
+}
@@ -0,0 +1,47 @@
+package doclib
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseSynonyms(t *testing.T) {
+	text := `# Spellings
+colour, color
+POS, Point-of-Sale, point of sale terminal
+
+car,automobile,auto
+`
+	d, err := ParseSynonyms(strings.NewReader(text))
+	if err != nil {
+		t.Fatalf("ParseSynonyms failed. err=%v", err)
+	}
+	if d.Len() != 3 {
+		t.Errorf("Len=%d expected=3", d.Len())
+	}
+	if got := d.synonyms("auto"); !reflect.DeepEqual(got, []string{"car", "automobile"}) {
+		t.Errorf("synonyms(auto)=%q", got)
+	}
+
+	matches, rest := d.match("Point of Sale terminal colour charts")
+	expected := []synonymMatch{
+		{phrase: "point of sale terminal", synonyms: []string{"pos", "point of sale"}},
+		{phrase: "colour", synonyms: []string{"color"}},
+	}
+	if !reflect.DeepEqual(matches, expected) {
+		t.Errorf("matches=%+v expected=%+v", matches, expected)
+	}
+	if !reflect.DeepEqual(rest, []string{"charts"}) {
+		t.Errorf("rest=%q expected=[charts]", rest)
+	}
+	if matches, _ := d.match("bicycle"); matches != nil {
+		t.Errorf("bicycle: matches=%+v", matches)
+	}
+
+	for _, bad := range []string{"colour\n", "a,b\n, ,c\n"} {
+		if _, err := ParseSynonyms(strings.NewReader(bad)); err == nil {
+			t.Errorf("ParseSynonyms(%q) should have failed", bad)
+		}
+	}
+}
@@ -0,0 +1,284 @@
+package doclib
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+
+	"github.com/peterwilliams97/pdf-search/serial"
+)
+
+const (
+	// cellGapFactor is the smallest horizontal gap, as a multiple of the text height, between
+	// words on a line that separates table cells. Word spaces are about a third of the height.
+	cellGapFactor = 1.0
+	// rowGapFactor is the largest vertical gap, as a multiple of the text height, between the rows
+	// of a table.
+	rowGapFactor = 2.0
+	// minTableRows is the smallest number of rows in a table.
+	minTableRows = 3
+	// maxCellWords is the largest mean number of words in the cells of a table. Lines of text in
+	// multi-column layouts have more words and are not tables.
+	maxCellWords = 4.0
+)
+
+// TableCell is a cell of a table on a PDF page. The box is the bounding box of the cell's words in
+// PDF coordinates. Empty cells have no text and a zero box.
+type TableCell struct {
+	Text               string
+	Llx, Lly, Urx, Ury float32
+}
+
+// Table is a table on a PDF page. Tables are detected from the positions of the words on a page:
+// lines whose words are split by wide gaps into cells that line up in columns, like ruled tables
+// and tables laid out with white space. See detectTables.
+type Table struct {
+	Llx, Lly, Urx, Ury float32 // Bounding box of the table.
+	// Rows are the rows of the table from top to bottom. Rows[i][j] is the cell in row i and
+	// column j. All rows have the same number of cells.
+	Rows [][]TableCell
+}
+
+// TableMatch is the table cell that contains a match. It gives the context of matches in tables,
+// whose lines are runs of unrelated cells.
+type TableMatch struct {
+	Row, Column int       // Row and column of the cell in the table (0-offset).
+	Cell        TableCell // The cell that contains the match.
+	RowCells    []string  // Texts of the cells in the row.
+	// Header is the texts of the cells in the first row of the table, which is usually its
+	// header. It is nil if the match is in the first row.
+	Header []string
+}
+
+func (t TableMatch) String() string {
+	s := fmt.Sprintf("row %d column %d: %q | %s", t.Row+1, t.Column+1, t.Cell.Text,
+		strings.Join(t.RowCells, " | "))
+	if t.Header != nil {
+		s += fmt.Sprintf("\n\theader: %s", strings.Join(t.Header, " | "))
+	}
+	return s
+}
+
+// tableLine is a line of words on a page split into cells.
+type tableLine struct {
+	cells []TableCell
+	words int                 // Number of words on the line.
+	box   serial.TextLocation // Bounding box of the line.
+}
+
+// detectTables returns the tables on the page with text `text` and word locations `words`.
+// The words are grouped into lines by their vertical positions, so the order of the text doesn't
+// matter, and the lines are split into cells at gaps wider than cellGapFactor times the text
+// height. Runs of minTableRows or more adjacent lines with several cells are tables if their
+// cells line up in two or more columns and are short. See maxCellWords.
+func detectTables(text string, words []serial.TextLocation) []Table {
+	var boxes []serial.TextLocation
+	for _, w := range words {
+		if w.End > w.Start && w.End <= uint32(len(text)) && w.Ury > w.Lly {
+			boxes = append(boxes, w)
+		}
+	}
+	center := func(loc serial.TextLocation) float32 { return (loc.Lly + loc.Ury) / 2 }
+	sort.SliceStable(boxes, func(i, j int) bool {
+		if ci, cj := center(boxes[i]), center(boxes[j]); ci != cj {
+			return ci > cj
+		}
+		return boxes[i].Llx < boxes[j].Llx
+	})
+
+	// Group the words into lines from the top of the page down.
+	var lines []tableLine
+	for i := 0; i < len(boxes); {
+		lineY, height := center(boxes[i]), boxes[i].Ury-boxes[i].Lly
+		j := i + 1
+		for j < len(boxes) && lineY-center(boxes[j]) <= height/2 {
+			j++
+		}
+		lines = append(lines, makeTableLine(text, boxes[i:j]))
+		i = j
+	}
+
+	var tables []Table
+	for start := 0; start < len(lines); {
+		end := start + 1
+		if len(lines[start].cells) >= 2 {
+			for end < len(lines) && len(lines[end].cells) >= 2 &&
+				adjacentLines(lines[end-1], lines[end]) {
+				end++
+			}
+			if table, ok := makeTable(lines[start:end]); ok {
+				tables = append(tables, table)
+			}
+		}
+		start = end
+	}
+	return tables
+}
+
+// makeTableLine returns the line made of the words `words`, which have the same vertical position
+// on the page with text `text`.
+func makeTableLine(text string, words []serial.TextLocation) tableLine {
+	sorted := make([]serial.TextLocation, len(words))
+	copy(sorted, words)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Llx < sorted[j].Llx })
+
+	line := tableLine{words: len(sorted), box: sorted[0]}
+	var cell TableCell
+	for i, w := range sorted {
+		line.box = unionLocation(line.box, w)
+		if i > 0 {
+			prev := sorted[i-1]
+			height := math.Max(float64(w.Ury-w.Lly), float64(prev.Ury-prev.Lly))
+			if float64(w.Llx-prev.Urx) > cellGapFactor*height {
+				line.cells = append(line.cells, cell)
+				cell = TableCell{}
+			}
+		}
+		cell = cell.add(text[w.Start:w.End], w)
+	}
+	line.cells = append(line.cells, cell)
+	return line
+}
+
+// add returns `cell` with `text`, whose bounding box is `box`, appended to it.
+func (cell TableCell) add(text string, box serial.TextLocation) TableCell {
+	if cell.Text == "" {
+		return TableCell{Text: text, Llx: box.Llx, Lly: box.Lly, Urx: box.Urx, Ury: box.Ury}
+	}
+	return TableCell{
+		Text: cell.Text + " " + text,
+		Llx:  min(cell.Llx, box.Llx),
+		Lly:  min(cell.Lly, box.Lly),
+		Urx:  max(cell.Urx, box.Urx),
+		Ury:  max(cell.Ury, box.Ury),
+	}
+}
+
+// adjacentLines returns true if line `b`, which is below line `a`, is close enough to `a` to be
+// the next row of a table.
+func adjacentLines(a, b tableLine) bool {
+	height := math.Max(float64(a.box.Ury-a.box.Lly), float64(b.box.Ury-b.box.Lly))
+	return float64(a.box.Lly-b.box.Ury) <= rowGapFactor*height
+}
+
+// makeTable returns the table made of `lines` and true if they are a table.
+func makeTable(lines []tableLine) (Table, bool) {
+	if len(lines) < minTableRows {
+		return Table{}, false
+	}
+	// The columns are the clusters of the overlapping x ranges of the cells.
+	type xRange struct{ llx, urx float32 }
+	var ranges []xRange
+	numWords := 0
+	for _, line := range lines {
+		for _, cell := range line.cells {
+			ranges = append(ranges, xRange{cell.Llx, cell.Urx})
+		}
+		numWords += line.words
+	}
+	if float64(numWords)/float64(len(ranges)) > maxCellWords {
+		return Table{}, false
+	}
+	sort.Slice(ranges, func(i, j int) bool { return ranges[i].llx < ranges[j].llx })
+	var columns []xRange
+	for _, r := range ranges {
+		if n := len(columns); n > 0 && r.llx <= columns[n-1].urx {
+			if r.urx > columns[n-1].urx {
+				columns[n-1].urx = r.urx
+			}
+			continue
+		}
+		columns = append(columns, r)
+	}
+	if len(columns) < 2 {
+		return Table{}, false
+	}
+
+	table := Table{
+		Llx: lines[0].box.Llx,
+		Lly: lines[0].box.Lly,
+		Urx: lines[0].box.Urx,
+		Ury: lines[0].box.Ury,
+	}
+	for _, line := range lines {
+		row := make([]TableCell, len(columns))
+		for _, cell := range line.cells {
+			c := sort.Search(len(columns), func(c int) bool { return columns[c].urx >= cell.Llx })
+			// Cells of the line that are in the same column, because a wider cell in another row
+			// merged their columns, are joined.
+			row[c] = row[c].add(cell.Text, serial.TextLocation{Llx: cell.Llx, Lly: cell.Lly,
+				Urx: cell.Urx, Ury: cell.Ury})
+		}
+		table.Rows = append(table.Rows, row)
+		table.Llx = min(table.Llx, line.box.Llx)
+		table.Lly = min(table.Lly, line.box.Lly)
+		table.Urx = max(table.Urx, line.box.Urx)
+		table.Ury = max(table.Ury, line.box.Ury)
+	}
+	return table, true
+}
+
+// findTableCell returns the cell in `tables` that contains the center of `loc`, a match's
+// bounding box, or nil if it isn't in a table.
+func findTableCell(tables []Table, loc serial.TextLocation) *TableMatch {
+	if loc == (serial.TextLocation{}) {
+		return nil
+	}
+	x, y := (loc.Llx+loc.Urx)/2, (loc.Lly+loc.Ury)/2
+	for _, t := range tables {
+		if x < t.Llx || x > t.Urx || y < t.Lly || y > t.Ury {
+			continue
+		}
+		for i, row := range t.Rows {
+			for j, cell := range row {
+				if cell.Text == "" || x < cell.Llx || x > cell.Urx || y < cell.Lly || y > cell.Ury {
+					continue
+				}
+				m := TableMatch{Row: i, Column: j, Cell: cell, RowCells: cellTexts(row)}
+				if i > 0 {
+					m.Header = cellTexts(t.Rows[0])
+				}
+				return &m
+			}
+		}
+	}
+	return nil
+}
+
+// cellTexts returns the texts of `cells`.
+func cellTexts(cells []TableCell) []string {
+	texts := make([]string, len(cells))
+	for i, cell := range cells {
+		texts[i] = cell.Text
+	}
+	return texts
+}
+
+// setPageTables records that `tables` are the tables on the page with index `pageIdx` in `lDoc`.
+// It is called on documents that are being written, after AddDocPage.
+func (lDoc *Doc) setPageTables(pageIdx uint32, tables []Table) {
+	if lDoc.isMem() {
+		for uint32(len(lDoc.pageTables)) <= pageIdx {
+			lDoc.pageTables = append(lDoc.pageTables, nil)
+		}
+		lDoc.pageTables[pageIdx] = tables
+		return
+	}
+	lDoc.spans[pageIdx].Tables = tables
+}
+
+// PageTables returns the tables on the page with index `pageIdx` in `lDoc`. It is nil for pages
+// without tables and pages that were indexed before tables were detected.
+func (lDoc *Doc) PageTables(pageIdx uint32) []Table {
+	if lDoc.isMem() {
+		if pageIdx < uint32(len(lDoc.pageTables)) {
+			return lDoc.pageTables[pageIdx]
+		}
+		return nil
+	}
+	if pageIdx < uint32(len(lDoc.spans)) {
+		return lDoc.spans[pageIdx].Tables
+	}
+	return nil
+}
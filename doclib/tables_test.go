@@ -0,0 +1,55 @@
+package doclib
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDetectTables(t *testing.T) {
+	// A paragraph above a price table whose columns are separated by wide gaps.
+	text, locs := makePage([][]textRun{
+		{{"Prices for the year are listed in the table below", 50, 700}},
+		{{"Item", 50, 680}, {"Qty", 150, 680}, {"Price", 220, 680}},
+		{{"Red widget", 50, 668}, {"3", 150, 668}, {"1.50", 220, 668}},
+		{{"Blue widget", 50, 656}, {"12", 150, 656}, {"0.75", 220, 656}},
+	})
+	words := wordLocations(text, locs)
+	tables := detectTables(text, words)
+	if len(tables) != 1 {
+		t.Fatalf("tables=%d expected 1. %+v", len(tables), tables)
+	}
+	var rows [][]string
+	for _, row := range tables[0].Rows {
+		rows = append(rows, cellTexts(row))
+	}
+	expected := [][]string{
+		{"Item", "Qty", "Price"},
+		{"Red widget", "3", "1.50"},
+		{"Blue widget", "12", "0.75"},
+	}
+	if !reflect.DeepEqual(rows, expected) {
+		t.Fatalf("rows=%q expected %q", rows, expected)
+	}
+
+	// The "12" in the Qty column.
+	m := findTableCell(tables, words[len(words)-2])
+	if m == nil || m.Row != 2 || m.Column != 1 || m.Cell.Text != "12" ||
+		!reflect.DeepEqual(m.Header, expected[0]) || !reflect.DeepEqual(m.RowCells, expected[2]) {
+		t.Errorf("cell of 12: %+v", m)
+	}
+	if m := findTableCell(tables, words[0]); m != nil {
+		t.Errorf("paragraph word is in a table: %+v", m)
+	}
+}
+
+func TestDetectTablesColumns(t *testing.T) {
+	// Two columns of running text are not a table.
+	text, locs := makePage([][]textRun{
+		{{"the first line of the left column", 50, 680}, {"and this is the right column", 300, 680}},
+		{{"which goes on for a few lines", 50, 668}, {"with more words than a table", 300, 668}},
+		{{"so that it reads like a book", 50, 656}, {"until the end of the page", 300, 656}},
+	})
+	if tables := detectTables(text, wordLocations(text, locs)); len(tables) != 0 {
+		t.Errorf("text columns detected as tables: %+v", tables)
+	}
+}
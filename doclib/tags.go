@@ -0,0 +1,151 @@
+package doclib
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/blevesearch/bleve"
+	"github.com/blevesearch/bleve/search/query"
+)
+
+// Document tags are strings of the form key=value, e.g. "department=legal" and "year=2019", that
+// are attached to documents when they are indexed. Tags without a "=", e.g. "draft", are also
+// allowed. They have the empty key. A document's tags are its DocLabels.Tags, which are indexed as
+// keywords in the tag field of each of its pages so that searches can be filtered by them and can
+// count the matching pages with each tag.
+
+// maxTagFacets is the maximum number of tags counted in PdfMatchSet.TagCounts.
+const maxTagFacets = 100
+
+// ParseTags returns the tags in the comma separated list `list`, e.g.
+// "department=legal,year=2019". Spaces around keys and values are removed.
+func ParseTags(list string) ([]string, error) {
+	var tags []string
+	for _, s := range strings.Split(list, ",") {
+		if strings.TrimSpace(s) == "" {
+			continue
+		}
+		tag, err := cleanTag(s)
+		if err != nil {
+			return nil, err
+		}
+		tags = append(tags, tag)
+	}
+	return tags, nil
+}
+
+// cleanTag returns tag `s` with the spaces around its key and value removed.
+func cleanTag(s string) (string, error) {
+	key, value := SplitTag(s)
+	if value == "" {
+		return "", fmt.Errorf("Bad tag %q. Tags are key=value or a plain value.", s)
+	}
+	if key == "" {
+		return value, nil
+	}
+	return key + "=" + value, nil
+}
+
+// SplitTag returns the key and value of `tag`. The key of a tag without a "=" is "".
+func SplitTag(tag string) (key, value string) {
+	i := strings.IndexByte(tag, '=')
+	if i < 0 {
+		return "", strings.TrimSpace(tag)
+	}
+	return strings.TrimSpace(tag[:i]), strings.TrimSpace(tag[i+1:])
+}
+
+// mergeTags returns the tags in `tags` followed by the tags in `extra` that aren't in `tags`.
+func mergeTags(tags, extra []string) []string {
+	if len(extra) == 0 {
+		return tags
+	}
+	seen := map[string]bool{}
+	merged := make([]string, 0, len(tags)+len(extra))
+	for _, list := range [][]string{tags, extra} {
+		for _, tag := range list {
+			if !seen[tag] {
+				seen[tag] = true
+				merged = append(merged, tag)
+			}
+		}
+	}
+	return merged
+}
+
+// tagsQuery returns `q` restricted to pages of documents that have all the tags in `tags`.
+func tagsQuery(q query.Query, tags []string) query.Query {
+	conjuncts := []query.Query{q}
+	for _, tag := range tags {
+		if tag, err := cleanTag(tag); err == nil {
+			tagQ := bleve.NewTermQuery(tag)
+			tagQ.SetField(tagField)
+			conjuncts = append(conjuncts, tagQ)
+		}
+	}
+	return bleve.NewConjunctionQuery(conjuncts...)
+}
+
+// tagCounts returns the tag facet counts in `sr` or nil if there aren't any.
+func tagCounts(sr *bleve.SearchResult) map[string]int {
+	facet, ok := sr.Facets[tagField]
+	if !ok || facet.Terms == nil {
+		return nil
+	}
+	counts := map[string]int{}
+	for _, t := range facet.Terms {
+		counts[t.Term] = t.Count
+	}
+	return counts
+}
+
+// TagFacets returns s.TagCounts grouped by tag key for drill-down UIs. It is
+// {key: {value: number of matching pages}}. Tags without keys are under the key "".
+func (s PdfMatchSet) TagFacets() map[string]map[string]int {
+	if len(s.TagCounts) == 0 {
+		return nil
+	}
+	facets := map[string]map[string]int{}
+	for tag, count := range s.TagCounts {
+		key, value := SplitTag(tag)
+		if facets[key] == nil {
+			facets[key] = map[string]int{}
+		}
+		facets[key][value] = count
+	}
+	return facets
+}
+
+// TagFacetString returns a text rendering of s.TagFacets() with the keys in alphabetical order and
+// the values of each key in descending order of count.
+func (s PdfMatchSet) TagFacetString() string {
+	facets := s.TagFacets()
+	var keys []string
+	for key := range facets {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	var b strings.Builder
+	for _, key := range keys {
+		counts := facets[key]
+		var values []string
+		for value := range counts {
+			values = append(values, value)
+		}
+		sort.Slice(values, func(i, j int) bool {
+			if counts[values[i]] != counts[values[j]] {
+				return counts[values[i]] > counts[values[j]]
+			}
+			return values[i] < values[j]
+		})
+		if key == "" {
+			key = "(tags)"
+		}
+		fmt.Fprintf(&b, "%s\n", key)
+		for _, value := range values {
+			fmt.Fprintf(&b, "    %-30s %d\n", value, counts[value])
+		}
+	}
+	return b.String()
+}
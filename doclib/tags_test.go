@@ -0,0 +1,56 @@
+package doclib
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseTags(t *testing.T) {
+	tests := []struct {
+		list string
+		tags []string
+		ok   bool
+	}{
+		{"", nil, true},
+		{"department=legal", []string{"department=legal"}, true},
+		{" department = legal , year=2019,", []string{"department=legal", "year=2019"}, true},
+		{"draft", []string{"draft"}, true},
+		{"year=", nil, false},
+		{"=", nil, false},
+	}
+	for _, test := range tests {
+		tags, err := ParseTags(test.list)
+		if (err == nil) != test.ok {
+			t.Errorf("list=%q err=%v ok=%t", test.list, err, test.ok)
+			continue
+		}
+		if test.ok && !reflect.DeepEqual(tags, test.tags) {
+			t.Errorf("list=%q tags=%q expected=%q", test.list, tags, test.tags)
+		}
+	}
+}
+
+func TestMergeTags(t *testing.T) {
+	tags := mergeTags([]string{"a=1", "b=2"}, []string{"b=2", "c=3"})
+	expected := []string{"a=1", "b=2", "c=3"}
+	if !reflect.DeepEqual(tags, expected) {
+		t.Errorf("tags=%q expected=%q", tags, expected)
+	}
+}
+
+func TestTagFacets(t *testing.T) {
+	s := PdfMatchSet{TagCounts: map[string]int{
+		"department=legal": 5,
+		"department=sales": 2,
+		"year=2019":        7,
+		"draft":            1,
+	}}
+	expected := map[string]map[string]int{
+		"department": {"legal": 5, "sales": 2},
+		"year":       {"2019": 7},
+		"":           {"draft": 1},
+	}
+	if facets := s.TagFacets(); !reflect.DeepEqual(facets, expected) {
+		t.Errorf("facets=%v expected=%v", facets, expected)
+	}
+}
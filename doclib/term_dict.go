@@ -0,0 +1,308 @@
+package doclib
+
+import (
+	"bufio"
+	"container/heap"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/blevesearch/bleve"
+	bleveindex "github.com/blevesearch/bleve/index"
+)
+
+// Formats of ExportTermDict output.
+const (
+	TermDictTSV  = "tsv"  // One "<term>\t<count>" line per term.
+	TermDictJSON = "json" // A JSON array of TermCount objects.
+)
+
+// TermCount is a term in the page text dictionary of a bleve index and the number of pages it is
+// on. For the terms of a single document, see DocTerms, Count is the number of times the term
+// occurs in the document.
+type TermCount struct {
+	Term  string `json:"term"`
+	Count uint64 `json:"count"`
+}
+
+// walkTermDict calls `fn` on every term in the page text dictionary of `index` in term order.
+func walkTermDict(index bleve.Index, fn func(tc TermCount) error) error {
+	return walkTermDictPrefix(index, "", fn)
+}
+
+// walkTermDictPrefix calls `fn` on every term that starts with `prefix` in the page text
+// dictionary of `index` in term order.
+func walkTermDictPrefix(index bleve.Index, prefix string, fn func(tc TermCount) error) error {
+	if x, ok := index.(*shardedIndex); ok {
+		return walkShardedTermDict(x, prefix, fn)
+	}
+	var dict bleveindex.FieldDict
+	var err error
+	if prefix == "" {
+		dict, err = index.FieldDict(textField)
+	} else {
+		dict, err = index.FieldDictPrefix(textField, []byte(prefix))
+	}
+	if err != nil {
+		return err
+	}
+	defer dict.Close()
+	for {
+		entry, err := dict.Next()
+		if err != nil {
+			return err
+		}
+		if entry == nil {
+			return nil
+		}
+		if err := fn(TermCount{Term: entry.Term, Count: entry.Count}); err != nil {
+			return err
+		}
+	}
+}
+
+// walkShardedTermDict calls `fn` on every term that starts with `prefix` in the page text
+// dictionaries of the shards of `x` in term order. The counts of terms that are in several shards
+// are summed.
+func walkShardedTermDict(x *shardedIndex, prefix string, fn func(tc TermCount) error) error {
+	counts := map[string]uint64{}
+	for _, shard := range x.shards {
+		err := walkTermDictPrefix(shard, prefix, func(tc TermCount) error {
+			counts[tc.Term] += tc.Count
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	terms := make([]string, 0, len(counts))
+	for term := range counts {
+		terms = append(terms, term)
+	}
+	sort.Strings(terms)
+	for _, term := range terms {
+		if err := fn(TermCount{Term: term, Count: counts[term]}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ExportTermDict writes the terms in the page text dictionary of `index` that are on at least
+// `minCount` pages, with their page counts, to `w` in format `format`, TermDictTSV or
+// TermDictJSON. It is for keeping external services such as autocomplete in sync with the index.
+// The terms are written in term order.
+func ExportTermDict(index bleve.Index, w io.Writer, format string, minCount uint64) error {
+	bw := bufio.NewWriter(w)
+	n := 0
+	var write func(tc TermCount) error
+	switch format {
+	case TermDictTSV:
+		write = func(tc TermCount) error {
+			// Tabs and newlines aren't in analyzed terms but don't trust that.
+			term := strings.NewReplacer("\t", " ", "\n", " ").Replace(tc.Term)
+			_, err := fmt.Fprintf(bw, "%s\t%d\n", term, tc.Count)
+			return err
+		}
+	case TermDictJSON:
+		bw.WriteString("[")
+		write = func(tc TermCount) error {
+			b, err := json.Marshal(tc)
+			if err != nil {
+				return err
+			}
+			if n > 0 {
+				bw.WriteString(",")
+			}
+			bw.WriteString("\n")
+			_, err = bw.Write(b)
+			return err
+		}
+	default:
+		return fmt.Errorf("Unknown term dictionary format %q", format)
+	}
+
+	err := walkTermDict(index, func(tc TermCount) error {
+		if tc.Count < minCount {
+			return nil
+		}
+		if err := write(tc); err != nil {
+			return err
+		}
+		n++
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if format == TermDictJSON {
+		bw.WriteString("\n]\n")
+	}
+	return bw.Flush()
+}
+
+// ExportTermDictFile writes the page text dictionary of `index` to file `filename`. The format is
+// TermDictJSON if `filename` has a .json extension and TermDictTSV otherwise. See ExportTermDict.
+func ExportTermDictFile(index bleve.Index, filename string, minCount uint64) error {
+	format := TermDictTSV
+	if strings.ToLower(filepath.Ext(filename)) == ".json" {
+		format = TermDictJSON
+	}
+	f, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	if err := ExportTermDict(index, f, format, minCount); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// ExportTermDict writes the page text dictionary of `x` to `w`. See ExportTermDict.
+func (x *PdfIndex) ExportTermDict(w io.Writer, format string, minCount uint64) error {
+	if err := x.refresh(); err != nil {
+		return err
+	}
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+	if x.index == nil {
+		return ErrClosed
+	}
+	return ExportTermDict(x.index, w, format, minCount)
+}
+
+// TopTerms returns the `n` terms in the page text dictionary of `index` that are on the most pages,
+// most frequent first. Terms with the same count are in term order. All the terms are returned if
+// `n` <= 0. It is for corpus QA and for building query suggestions.
+func TopTerms(index bleve.Index, n int) ([]TermCount, error) {
+	top := newTopTerms(n)
+	if err := walkTermDict(index, func(tc TermCount) error {
+		top.add(tc)
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	return top.sorted(), nil
+}
+
+// DocTerms returns the `n` most frequent terms in the page texts of document `docIdx` in `lState`
+// with the number of times each occurs, most frequent first. All the terms are returned if `n` <=
+// 0. The page texts are analyzed with the analyzer of `index`'s page text dictionary, so the terms
+// are those that TopTerms returns.
+func DocTerms(index bleve.Index, lState *Store, docIdx uint64, n int) ([]TermCount,
+	error) {
+
+	m := index.Mapping()
+	name := m.AnalyzerNameForPath(textField)
+	analyzer := m.AnalyzerNamed(name)
+	if analyzer == nil {
+		return nil, fmt.Errorf("No %q analyzer", name)
+	}
+	lDoc, err := lState.OpenPositionsDoc(docIdx)
+	if err != nil {
+		return nil, err
+	}
+	defer lDoc.Close()
+	counts := map[string]uint64{}
+	for pageIdx := uint32(0); pageIdx < uint32(lDoc.Len()); pageIdx++ {
+		text, err := lDoc.ReadPageText(pageIdx)
+		if err != nil {
+			return nil, err
+		}
+		for _, token := range analyzer.Analyze([]byte(text)) {
+			counts[string(token.Term)]++
+		}
+	}
+	top := newTopTerms(n)
+	for term, count := range counts {
+		top.add(TermCount{Term: term, Count: count})
+	}
+	return top.sorted(), nil
+}
+
+// TopTerms returns the `n` most frequent terms in the page text dictionary of `x`. See TopTerms.
+func (x *PdfIndex) TopTerms(n int) ([]TermCount, error) {
+	if err := x.refresh(); err != nil {
+		return nil, err
+	}
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+	if x.index == nil {
+		return nil, ErrClosed
+	}
+	return TopTerms(x.index, n)
+}
+
+// DocTerms returns the `n` most frequent terms in document `docIdx` in `x`. See DocTerms.
+func (x *PdfIndex) DocTerms(docIdx uint64, n int) ([]TermCount, error) {
+	if err := x.refresh(); err != nil {
+		return nil, err
+	}
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+	if x.index == nil {
+		return nil, ErrClosed
+	}
+	return DocTerms(x.index, x.lState, docIdx, n)
+}
+
+// topTerms keeps the `n` TermCounts with the highest counts of those it is given, so that the most
+// frequent terms of a big dictionary can be found without holding the dictionary in memory.
+type topTerms struct {
+	n int // Number of terms to keep. All terms are kept if it is <= 0.
+	h termHeap
+}
+
+// newTopTerms returns a topTerms that keeps `n` terms.
+func newTopTerms(n int) *topTerms {
+	return &topTerms{n: n}
+}
+
+// add adds `tc` to `t` if it is one of the `t.n` most frequent terms so far.
+func (t *topTerms) add(tc TermCount) {
+	if t.n <= 0 || len(t.h) < t.n {
+		heap.Push(&t.h, tc)
+		return
+	}
+	if t.h.less(t.h[0], tc) {
+		t.h[0] = tc
+		heap.Fix(&t.h, 0)
+	}
+}
+
+// sorted returns the terms in `t`, most frequent first, with ties in term order.
+func (t *topTerms) sorted() []TermCount {
+	terms := make([]TermCount, len(t.h))
+	copy(terms, t.h)
+	sort.Slice(terms, func(i, j int) bool { return t.h.less(terms[j], terms[i]) })
+	return terms
+}
+
+// termHeap is a min-heap of TermCounts. Its top is the least frequent term, with ties broken by
+// putting later terms in term order first.
+type termHeap []TermCount
+
+// less returns true if `a` is less frequent than `b`, or as frequent and after it in term order.
+func (h termHeap) less(a, b TermCount) bool {
+	if a.Count != b.Count {
+		return a.Count < b.Count
+	}
+	return a.Term > b.Term
+}
+
+func (h termHeap) Len() int            { return len(h) }
+func (h termHeap) Less(i, j int) bool  { return h.less(h[i], h[j]) }
+func (h termHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *termHeap) Push(x interface{}) { *h = append(*h, x.(TermCount)) }
+func (h *termHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	x := old[n-1]
+	*h = old[:n-1]
+	return x
+}
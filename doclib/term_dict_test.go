@@ -0,0 +1,39 @@
+package doclib
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestTopTerms(t *testing.T) {
+	counts := []TermCount{
+		{"alpha", 3}, {"beta", 7}, {"gamma", 1}, {"delta", 7}, {"epsilon", 5}, {"zeta", 3},
+	}
+	top := newTopTerms(3)
+	for _, tc := range counts {
+		top.add(tc)
+	}
+	expected := []TermCount{{"beta", 7}, {"delta", 7}, {"epsilon", 5}}
+	if got := top.sorted(); !reflect.DeepEqual(got, expected) {
+		t.Errorf("top 3=%v expected=%v", got, expected)
+	}
+
+	// Ties at the cut off are broken in term order.
+	top = newTopTerms(4)
+	for _, tc := range counts {
+		top.add(tc)
+	}
+	expected = append(expected, TermCount{"alpha", 3})
+	if got := top.sorted(); !reflect.DeepEqual(got, expected) {
+		t.Errorf("top 4=%v expected=%v", got, expected)
+	}
+
+	top = newTopTerms(0)
+	for _, tc := range counts {
+		top.add(tc)
+	}
+	expected = append(expected, TermCount{"zeta", 3}, TermCount{"gamma", 1})
+	if got := top.sorted(); !reflect.DeepEqual(got, expected) {
+		t.Errorf("all=%v expected=%v", got, expected)
+	}
+}
@@ -0,0 +1,322 @@
+package doclib
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/peterwilliams97/pdf-search/serial"
+	"github.com/unidoc/unidoc/common"
+	pdf "github.com/unidoc/unidoc/pdf/model"
+)
+
+// TextExtractor extracts the text of PDF pages and the locations of its characters. UniDoc is used
+// by default. Set IndexOptions.TextExtractor or IndexOptions.FileExtractors to use another
+// extractor. Extractors can be registered by name with RegisterTextExtractor so that they can be
+// selected with command line options.
+// ExtractPage is called from several goroutines at once.
+type TextExtractor interface {
+	// ExtractPage returns the text on page number `pageNum` of PDF file `inPath` and the locations
+	// of its characters. `page` is the parsed page.
+	ExtractPage(inPath string, pageNum uint32, page *pdf.PdfPage) (string, []serial.TextLocation,
+		error)
+	// Extractor returns the name and version of the extractor. It is recorded for the documents
+	// whose text it extracted.
+	Extractor() Extractor
+}
+
+// FileExtractor is the TextExtractor for the PDF files that match a glob pattern.
+type FileExtractor struct {
+	// Pattern is a glob pattern that matches a file's path or base name. See filepath.Match.
+	Pattern   string
+	Extractor TextExtractor
+}
+
+// textExtractorEntry is a TextExtractor registered with RegisterTextExtractor.
+type textExtractorEntry struct {
+	create func() (TextExtractor, error)
+	ocr    bool
+}
+
+var (
+	textExtractorsMu sync.Mutex
+	// textExtractors are the registered TextExtractor constructors keyed by name.
+	textExtractors = map[string]textExtractorEntry{
+		unidocExtractor.Name: {
+			create: func() (TextExtractor, error) { return UnidocExtractor{}, nil },
+		},
+		"pdftotext": {
+			create: func() (TextExtractor, error) {
+				e, err := NewPdftotextExtractor()
+				if err != nil {
+					return nil, err
+				}
+				return e, nil
+			},
+		},
+		"tesseract": {
+			create: func() (TextExtractor, error) {
+				ocr, err := NewTesseractOCR("eng", 300)
+				if err != nil {
+					return nil, err
+				}
+				return OCRExtractor{OCR: ocr}, nil
+			},
+			ocr: true,
+		},
+	}
+)
+
+// RegisterTextExtractor makes the TextExtractor returned by `create` available to
+// NewTextExtractor as `name`, e.g. a wrapper for pdfium. `ocr` is true if the extractor recognizes
+// text in page images rather than reading the PDF's text. It replaces any extractor with the same
+// name.
+func RegisterTextExtractor(name string, ocr bool, create func() (TextExtractor, error)) {
+	textExtractorsMu.Lock()
+	defer textExtractorsMu.Unlock()
+	textExtractors[strings.ToLower(name)] = textExtractorEntry{create: create, ocr: ocr}
+}
+
+// NewTextExtractor returns a new instance of the TextExtractor registered as `name`.
+// See TextExtractorNames.
+func NewTextExtractor(name string) (TextExtractor, error) {
+	textExtractorsMu.Lock()
+	entry, ok := textExtractors[strings.ToLower(name)]
+	textExtractorsMu.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("Unknown text extractor %q. Known extractors: %s", name,
+			strings.Join(TextExtractorNames(), ", "))
+	}
+	return entry.create()
+}
+
+// TextExtractorNames returns the names of the registered TextExtractors in alphabetical order.
+func TextExtractorNames() []string {
+	textExtractorsMu.Lock()
+	defer textExtractorsMu.Unlock()
+	var names []string
+	for name := range textExtractors {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// isOCRExtractor returns true if the extractor named `name` recognizes text with OCR. Extractors
+// that aren't registered are assumed to be OCR engines because PageOCRs don't need to be
+// registered.
+func isOCRExtractor(name string) bool {
+	textExtractorsMu.Lock()
+	defer textExtractorsMu.Unlock()
+	entry, ok := textExtractors[strings.ToLower(name)]
+	return !ok || entry.ocr
+}
+
+// textExtractor returns the TextExtractor that `opts` selects for PDF file `inPath`.
+func (opts IndexOptions) textExtractor(inPath string) (TextExtractor, error) {
+	base := filepath.Base(inPath)
+	for _, fe := range opts.FileExtractors {
+		for _, name := range []string{inPath, base} {
+			matched, err := filepath.Match(fe.Pattern, name)
+			if err != nil {
+				return nil, fmt.Errorf("Bad extractor pattern %q. err=%v", fe.Pattern, err)
+			}
+			if matched {
+				return fe.Extractor, nil
+			}
+		}
+	}
+	if opts.TextExtractor != nil {
+		return opts.TextExtractor, nil
+	}
+	return UnidocExtractor{}, nil
+}
+
+// UnidocExtractor is the default TextExtractor. It extracts text with UniDoc.
+type UnidocExtractor struct{}
+
+// ExtractPage returns the text on `page` as extracted by UniDoc.
+func (UnidocExtractor) ExtractPage(inPath string, pageNum uint32, page *pdf.PdfPage) (string,
+	[]serial.TextLocation, error) {
+
+	text, locations, err := ExtractPageTextLocation(page)
+	if err != nil {
+		return "", nil, err
+	}
+	serialLocations := make([]serial.TextLocation, 0, len(locations))
+	for i, loc := range locations {
+		stl := ToSerialTextLocation(loc)
+		common.Log.Debug("%d: %s", i, stl)
+		serialLocations = append(serialLocations, stl)
+	}
+	return text, serialLocations, nil
+}
+
+// Extractor returns the name and version of UniDoc.
+func (UnidocExtractor) Extractor() Extractor {
+	return unidocExtractor
+}
+
+// OCRExtractor is a TextExtractor that recognizes the text of every page with a PageOCR. It is for
+// runs where the text layers of the PDFs are known to be useless. Use IndexOptions.OCR to only
+// recognize the pages without usable text.
+type OCRExtractor struct {
+	OCR PageOCR
+}
+
+// ExtractPage returns the text on `page` as recognized by e.OCR.
+func (e OCRExtractor) ExtractPage(inPath string, pageNum uint32, page *pdf.PdfPage) (string,
+	[]serial.TextLocation, error) {
+
+	return ocrPageText(e.OCR, &pdfFile{inPath: inPath}, pageNum, page)
+}
+
+// Extractor returns the name and version of the OCR engine.
+func (e OCRExtractor) Extractor() Extractor {
+	return e.OCR.Extractor()
+}
+
+// PdftotextExtractor is a TextExtractor that runs pdftotext from poppler-utils, which must be
+// installed. It reads the PDF file so it only works for files on disk. pdftotext's layout analysis
+// orders the text of multi-column pages better than UniDoc on some PDFs.
+type PdftotextExtractor struct {
+	version string
+}
+
+// pdftotextVersion matches the version in the output of `pdftotext -v`.
+var pdftotextVersion = regexp.MustCompile(`pdftotext version (\S+)`)
+
+// NewPdftotextExtractor returns a PdftotextExtractor or an error if pdftotext isn't installed.
+func NewPdftotextExtractor() (*PdftotextExtractor, error) {
+	if _, err := exec.LookPath("pdftotext"); err != nil {
+		return nil, fmt.Errorf("Could not find pdftotext. err=%v", err)
+	}
+	// pdftotext -v writes its version to stderr and may exit with a non-zero status.
+	out, _ := exec.Command("pdftotext", "-v").CombinedOutput()
+	e := &PdftotextExtractor{}
+	if m := pdftotextVersion.FindSubmatch(out); m != nil {
+		e.version = string(m[1])
+	}
+	return e, nil
+}
+
+// ExtractPage returns the text on page number `pageNum` of PDF file `inPath` as extracted by
+// pdftotext. Each word has the bounding box that pdftotext reports for it.
+func (e *PdftotextExtractor) ExtractPage(inPath string, pageNum uint32, page *pdf.PdfPage) (
+	string, []serial.TextLocation, error) {
+
+	mediaBox, err := page.GetMediaBox()
+	if err != nil {
+		return "", nil, err
+	}
+	num := strconv.Itoa(int(pageNum))
+	cmd := exec.Command("pdftotext", "-f", num, "-l", num, "-bbox-layout", "-enc", "UTF-8",
+		inPath, "-")
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return "", nil, fmt.Errorf("Could not extract %q:%d. err=%v\n%s", inPath, pageNum, err,
+			stderr.Bytes())
+	}
+	words, err := parseBBoxLayout(out, mediaBox.Llx, mediaBox.Ury)
+	if err != nil {
+		return "", nil, fmt.Errorf("Could not read pdftotext output for %q:%d. err=%v", inPath,
+			pageNum, err)
+	}
+	text, locations := ocrWordsText(words)
+	common.Log.Debug("PdftotextExtractor: inPath=%q pageNum=%d words=%d text=%d", inPath,
+		pageNum, len(words), len(text))
+	return text, locations, nil
+}
+
+// Extractor returns the name and version of pdftotext.
+func (e *PdftotextExtractor) Extractor() Extractor {
+	return Extractor{Name: "pdftotext", Version: e.version}
+}
+
+// parseBBoxLayout returns the words in `data`, the XHTML output of `pdftotext -bbox-layout`.
+// Words in the same <line> element are on the same line. The word bounding boxes are converted
+// from points with the origin at the top left to points with the origin at the bottom left of a
+// page whose top left corner is at (`llx`, `ury`).
+func parseBBoxLayout(data []byte, llx, ury float64) ([]OCRWord, error) {
+	d := xml.NewDecoder(bytes.NewReader(data))
+	d.Strict = false
+	d.AutoClose = xml.HTMLAutoClose
+	d.Entity = xml.HTMLEntity
+
+	var words []OCRWord
+	line := 0
+	for {
+		tok, err := d.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		start, ok := tok.(xml.StartElement)
+		if !ok {
+			continue
+		}
+		switch start.Name.Local {
+		case "line":
+			line++
+		case "word":
+			var w struct {
+				XMin float64 `xml:"xMin,attr"`
+				YMin float64 `xml:"yMin,attr"`
+				XMax float64 `xml:"xMax,attr"`
+				YMax float64 `xml:"yMax,attr"`
+				Text string  `xml:",chardata"`
+			}
+			if err := d.DecodeElement(&w, &start); err != nil {
+				return nil, err
+			}
+			words = append(words, OCRWord{
+				Text: strings.TrimSpace(w.Text),
+				Line: line,
+				Llx:  llx + w.XMin,
+				Lly:  ury - w.YMax,
+				Urx:  llx + w.XMax,
+				Ury:  ury - w.YMin,
+			})
+		}
+	}
+	return words, nil
+}
+
+// ParseFileExtractors parses `s`, a comma separated list of pattern=name pairs such as
+// "scans/*=tesseract,*.pdf=pdftotext", into FileExtractors. The names are the names of registered
+// TextExtractors.
+func ParseFileExtractors(s string) ([]FileExtractor, error) {
+	var extractors []FileExtractor
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		i := strings.LastIndex(part, "=")
+		if i <= 0 || i == len(part)-1 {
+			return nil, fmt.Errorf("Bad file extractor %q. Use pattern=name", part)
+		}
+		pattern, name := part[:i], part[i+1:]
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("Bad extractor pattern %q. err=%v", pattern, err)
+		}
+		te, err := NewTextExtractor(name)
+		if err != nil {
+			return nil, err
+		}
+		extractors = append(extractors, FileExtractor{Pattern: pattern, Extractor: te})
+	}
+	return extractors, nil
+}
@@ -0,0 +1,156 @@
+package doclib
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	pdf "github.com/unidoc/unidoc/pdf/model"
+)
+
+const testBBoxLayout = `<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE html PUBLIC "-//W3C//DTD XHTML 1.0 Transitional//EN"
+"http://www.w3.org/TR/xhtml1/DTD/xhtml1-transitional.dtd">
+<html xmlns="http://www.w3.org/1999/xhtml">
+<head>
+<title></title>
+<meta name="Producer" content="Test"/>
+</head>
+<body>
+<doc>
+  <page width="612.000000" height="792.000000">
+    <flow>
+      <block xMin="72.0" yMin="72.0" xMax="200.0" yMax="100.0">
+        <line xMin="72.0" yMin="72.0" xMax="200.0" yMax="84.0">
+          <word xMin="72.0" yMin="72.0" xMax="110.0" yMax="84.0">Hello</word>
+          <word xMin="115.0" yMin="72.0" xMax="200.0" yMax="84.0">R&amp;D</word>
+        </line>
+        <line xMin="72.0" yMin="88.0" xMax="120.0" yMax="100.0">
+          <word xMin="72.0" yMin="88.0" xMax="120.0" yMax="100.0">world</word>
+        </line>
+      </block>
+    </flow>
+  </page>
+</doc>
+</body>
+</html>
+`
+
+func TestParseBBoxLayout(t *testing.T) {
+	words, err := parseBBoxLayout([]byte(testBBoxLayout), 10, 792)
+	if err != nil {
+		t.Fatalf("parseBBoxLayout failed. err=%v", err)
+	}
+	expected := []OCRWord{
+		{Text: "Hello", Line: 1, Llx: 82, Lly: 708, Urx: 120, Ury: 720},
+		{Text: "R&D", Line: 1, Llx: 125, Lly: 708, Urx: 210, Ury: 720},
+		{Text: "world", Line: 2, Llx: 82, Lly: 692, Urx: 130, Ury: 704},
+	}
+	if len(words) != len(expected) {
+		t.Fatalf("words=%d expected=%d %+v", len(words), len(expected), words)
+	}
+	for i, w := range words {
+		if w != expected[i] {
+			t.Errorf("word %d: got %+v expected %+v", i, w, expected[i])
+		}
+	}
+	text, _ := ocrWordsText(words)
+	if text != "Hello R&D\nworld" {
+		t.Errorf("text=%q", text)
+	}
+}
+
+func TestParseFileExtractors(t *testing.T) {
+	extractors, err := ParseFileExtractors("scans/*.pdf=unidoc, *.PDF=UniDoc")
+	if err != nil {
+		t.Fatalf("ParseFileExtractors failed. err=%v", err)
+	}
+	if len(extractors) != 2 || extractors[0].Pattern != "scans/*.pdf" ||
+		extractors[1].Pattern != "*.PDF" {
+		t.Fatalf("extractors=%+v", extractors)
+	}
+	for _, fe := range extractors {
+		if fe.Extractor.Extractor() != unidocExtractor {
+			t.Errorf("%q: extractor=%s", fe.Pattern, fe.Extractor.Extractor())
+		}
+	}
+	if extractors, err := ParseFileExtractors(""); err != nil || len(extractors) != 0 {
+		t.Errorf("empty: extractors=%+v err=%v", extractors, err)
+	}
+	for _, s := range []string{"*.pdf", "=unidoc", "*.pdf=", "[=unidoc", "*.pdf=nosuch"} {
+		if _, err := ParseFileExtractors(s); err == nil {
+			t.Errorf("%q: expected an error", s)
+		}
+	}
+}
+
+func TestTextExtractorSelection(t *testing.T) {
+	ocr := OCRExtractor{OCR: &TesseractOCR{}}
+	opts := IndexOptions{FileExtractors: []FileExtractor{{Pattern: "scan*.pdf", Extractor: ocr}}}
+	for _, test := range []struct {
+		inPath   string
+		expected string
+	}{
+		{"/corpus/scan1.pdf", "tesseract"},
+		{"/corpus/report.pdf", "unidoc"},
+	} {
+		te, err := opts.textExtractor(test.inPath)
+		if err != nil {
+			t.Fatalf("%q: err=%v", test.inPath, err)
+		}
+		if name := te.Extractor().Name; name != test.expected {
+			t.Errorf("%q: extractor=%q expected=%q", test.inPath, name, test.expected)
+		}
+	}
+	if !isOCRExtractor("tesseract") || isOCRExtractor("unidoc") || isOCRExtractor("pdftotext") {
+		t.Errorf("isOCRExtractor wrong")
+	}
+}
+
+// fileOCR is a PageOCR that recognizes the contents of the file it is given as a single word.
+type fileOCR struct{}
+
+func (fileOCR) RecognizePage(inPath string, pageNum uint32, page *pdf.PdfPage) ([]OCRWord,
+	error) {
+
+	data, err := ioutil.ReadFile(inPath)
+	if err != nil {
+		return nil, err
+	}
+	return []OCRWord{{Text: string(data)}}, nil
+}
+
+func (fileOCR) Extractor() Extractor {
+	return Extractor{Name: "fileocr"}
+}
+
+func TestOCRFromReader(t *testing.T) {
+	const contents = "%PDF-1.4 scanned"
+	rs := bytes.NewReader([]byte(contents))
+	if _, err := rs.Seek(5, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	src := &pdfFile{inPath: "s3://bucket/scan.pdf", rs: rs}
+	for pageNum := uint32(1); pageNum <= 2; pageNum++ {
+		text, _, err := ocrPageText(fileOCR{}, src, pageNum, nil)
+		if err != nil {
+			t.Fatalf("pageNum=%d err=%v", pageNum, err)
+		}
+		if text != contents {
+			t.Errorf("pageNum=%d text=%q expected=%q", pageNum, text, contents)
+		}
+	}
+	if pos, _ := rs.Seek(0, io.SeekCurrent); pos != 5 {
+		t.Errorf("reader moved from 5 to %d", pos)
+	}
+	path := src.path
+	if _, err := os.Stat(path); err != nil {
+		t.Fatalf("no copy. err=%v", err)
+	}
+	src.close()
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("copy %q not removed. err=%v", path, err)
+	}
+}
@@ -0,0 +1,182 @@
+package doclib
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/blevesearch/bleve"
+	"github.com/blevesearch/bleve/analysis"
+	"github.com/blevesearch/bleve/analysis/analyzer/custom"
+	"github.com/blevesearch/bleve/analysis/token/lowercase"
+	bleveunicode "github.com/blevesearch/bleve/analysis/tokenizer/unicode"
+	"github.com/blevesearch/bleve/mapping"
+	"github.com/blevesearch/bleve/registry"
+	"github.com/blevesearch/bleve/search/query"
+	"golang.org/x/text/unicode/norm"
+)
+
+// The page text is indexed in these fields, as well as textField, so that searches can choose
+// whether case and diacritics are significant. textField is lower cased and keeps diacritics.
+const (
+	foldedTextField = "text_folded" // Lower cased with diacritics removed.
+	casedTextField  = "text_cased"  // Case kept with diacritics removed.
+	exactTextField  = "text_exact"  // Case and diacritics kept.
+)
+
+// pageTextFields are the bleve fields whose term locations are offsets in the page text.
+var pageTextFields = []string{textField, foldedTextField, casedTextField, exactTextField,
+	quantityTextField}
+
+// Names of the analyzers of the page text fields other than textField and of the token filter
+// that removes diacritics. foldedAnalyzer removes the store's stop words, like its page text
+// analyzer, as it is used by default. The case sensitive analyzers keep them so that e.g. "IT"
+// can be found.
+const (
+	foldedAnalyzer       = "pdfsearch_folded"
+	casedAnalyzer        = "pdfsearch_cased"
+	exactAnalyzer        = "pdfsearch_exact"
+	foldDiacriticsFilter = "pdfsearch_fold_diacritics"
+)
+
+func init() {
+	registry.RegisterTokenFilter(foldDiacriticsFilter,
+		func(config map[string]interface{}, cache *registry.Cache) (analysis.TokenFilter, error) {
+			return diacriticsFilter{}, nil
+		})
+}
+
+// diacriticsFilter is a bleve token filter that removes diacritics from terms.
+type diacriticsFilter struct{}
+
+// Filter removes the diacritics from the terms in `input`. The token offsets are unchanged so they
+// still refer to the page text.
+func (diacriticsFilter) Filter(input analysis.TokenStream) analysis.TokenStream {
+	for _, token := range input {
+		token.Term = []byte(removeDiacritics(string(token.Term)))
+	}
+	return input
+}
+
+// removeDiacritics returns `s` without the combining marks of its decomposed characters,
+// e.g. "résumé" -> "resume". Characters without decompositions, such as "ø" and "ß", are kept.
+func removeDiacritics(s string) string {
+	if isASCII(s) {
+		return s
+	}
+	decomposed := norm.NFD.String(s)
+	var sb strings.Builder
+	sb.Grow(len(decomposed))
+	for _, r := range decomposed {
+		if !unicode.Is(unicode.Mn, r) {
+			sb.WriteRune(r)
+		}
+	}
+	return norm.NFC.String(sb.String())
+}
+
+// isASCII returns true if `s` only has ASCII characters.
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= utf8.RuneSelf {
+			return false
+		}
+	}
+	return true
+}
+
+// addFoldAnalyzers adds the analyzers of the page text fields other than textField to
+// `indexMapping`. `stopFilter` is the name of the store's stop word filter or "" if it has none.
+func addFoldAnalyzers(indexMapping *mapping.IndexMappingImpl, stopFilter string) error {
+	folded := []string{lowercase.Name, foldDiacriticsFilter}
+	if stopFilter != "" {
+		folded = []string{lowercase.Name, stopFilter, foldDiacriticsFilter}
+	}
+	analyzers := []struct {
+		name    string
+		filters []string
+	}{
+		{foldedAnalyzer, folded},
+		{casedAnalyzer, []string{foldDiacriticsFilter}},
+		{exactAnalyzer, []string{}},
+	}
+	for _, a := range analyzers {
+		err := indexMapping.AddCustomAnalyzer(a.name, map[string]interface{}{
+			"type":          custom.Name,
+			"tokenizer":     bleveunicode.Name,
+			"token_filters": a.filters,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// foldTextMappings returns the field mappings of the page text fields other than textField. They
+// have term vectors so that their matches can be located on the page but aren't stored.
+func foldTextMappings() []*mapping.FieldMapping {
+	fields := []struct{ name, analyzer string }{
+		{foldedTextField, foldedAnalyzer},
+		{casedTextField, casedAnalyzer},
+		{exactTextField, exactAnalyzer},
+	}
+	var mappings []*mapping.FieldMapping
+	for _, f := range fields {
+		fm := bleve.NewTextFieldMapping()
+		fm.Name = f.name
+		fm.Analyzer = f.analyzer
+		fm.Store = false
+		fm.IncludeInAll = false
+		mappings = append(mappings, fm)
+	}
+	return mappings
+}
+
+// foldTextField returns the page text field and its analyzer for searches where case is
+// significant if `caseSensitive` is true and diacritics are significant if `diacriticSensitive`
+// is true. The analyzer of textField is the store's page text analyzer, which is returned as "".
+func foldTextField(caseSensitive, diacriticSensitive bool) (field, analyzer string) {
+	switch {
+	case caseSensitive && diacriticSensitive:
+		return exactTextField, exactAnalyzer
+	case caseSensitive:
+		return casedTextField, casedAnalyzer
+	case diacriticSensitive:
+		return textField, ""
+	}
+	return foldedTextField, foldedAnalyzer
+}
+
+// foldQuery returns `q`, the query for query string `term`, with the case and diacritic
+// sensitivity of `opts`.
+// By default pages whose text matches `term` when both are lower cased and have their diacritics
+// removed, e.g. "résumé" for "resume", also match. If opts.CaseSensitive or
+// opts.DiacriticSensitive is set then `term` is only matched against the page text field with that
+// sensitivity. Queries with field scopes are returned unchanged.
+func foldQuery(q query.Query, term string, opts SearchOptions) query.Query {
+	if hasFieldScope(term) {
+		return q
+	}
+	field, analyzer := foldTextField(opts.CaseSensitive, opts.DiacriticSensitive)
+	if field == textField {
+		return q
+	}
+	mq := bleve.NewMatchQuery(term)
+	mq.SetField(field)
+	mq.Analyzer = analyzer
+	if opts.AllTerms || opts.Within > 0 {
+		mq.SetOperator(query.MatchQueryOperatorAnd)
+	}
+	if !opts.CaseSensitive && !opts.DiacriticSensitive {
+		return bleve.NewDisjunctionQuery(q, mq)
+	}
+	if opts.Fuzziness > 0 {
+		fuzziness := opts.Fuzziness
+		if fuzziness > maxFuzziness {
+			fuzziness = maxFuzziness
+		}
+		mq.SetFuzziness(fuzziness)
+	}
+	return mq
+}
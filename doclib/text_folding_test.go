@@ -0,0 +1,42 @@
+package doclib
+
+import "testing"
+
+func TestRemoveDiacritics(t *testing.T) {
+	tests := map[string]string{
+		"resume":   "resume",
+		"résumé":   "resume",
+		"Résumé":   "Resume",
+		"naïve":    "naive",
+		"Ångström": "Angstrom",
+		"über":     "uber",
+		"façade":   "facade",
+		"ø":        "ø",
+		"straße":   "straße",
+		"":         "",
+	}
+	for s, expected := range tests {
+		if got := removeDiacritics(s); got != expected {
+			t.Errorf("removeDiacritics(%q)=%q expected=%q", s, got, expected)
+		}
+	}
+}
+
+func TestFoldTextField(t *testing.T) {
+	tests := []struct {
+		caseSensitive, diacriticSensitive bool
+		field                             string
+	}{
+		{false, false, foldedTextField},
+		{false, true, textField},
+		{true, false, casedTextField},
+		{true, true, exactTextField},
+	}
+	for _, test := range tests {
+		field, _ := foldTextField(test.caseSensitive, test.diacriticSensitive)
+		if field != test.field {
+			t.Errorf("foldTextField(%t, %t)=%q expected=%q", test.caseSensitive,
+				test.diacriticSensitive, field, test.field)
+		}
+	}
+}
@@ -0,0 +1,211 @@
+package doclib
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/peterwilliams97/pdf-search/serial"
+	"golang.org/x/text/unicode/norm"
+)
+
+// TextNormalization is a set of normalizations that are applied to page texts before they are
+// indexed and to queries before they are searched for, so that text and queries that are written
+// with different forms of the same characters match.
+// A store's normalization is fixed when it is created and recorded in its StoreConfig. Stores that
+// were created before normalization was recorded aren't normalized.
+type TextNormalization uint
+
+const (
+	// NormNFKC applies Unicode normalization form NFKC, which replaces compatibility characters,
+	// such as full width letters, ligatures and superscript digits, with their standard forms.
+	NormNFKC TextNormalization = 1 << iota
+	// NormLigatures expands the Latin ligatures ﬀ, ﬁ, ﬂ, ﬃ, ﬄ, ﬅ and ﬆ into their letters. NFKC
+	// also does this.
+	NormLigatures
+	// NormSoftHyphens removes soft hyphens (U+00AD), which PDFs use to mark where words may be
+	// broken.
+	NormSoftHyphens
+	// NormQuotes folds curly quotes and apostrophes into ' and ".
+	NormQuotes
+)
+
+// DefaultNormalization is the TextNormalization of new stores.
+const DefaultNormalization = NormNFKC | NormLigatures | NormSoftHyphens | NormQuotes
+
+// normalizationNames are the names of the TextNormalization flags.
+var normalizationNames = []struct {
+	flag TextNormalization
+	name string
+}{
+	{NormNFKC, "nfkc"},
+	{NormLigatures, "ligatures"},
+	{NormSoftHyphens, "softhyphens"},
+	{NormQuotes, "quotes"},
+}
+
+// ligatures are the expansions of the Latin ligatures.
+var ligatures = map[rune]string{
+	'ﬀ': "ff",
+	'ﬁ': "fi",
+	'ﬂ': "fl",
+	'ﬃ': "ffi",
+	'ﬄ': "ffl",
+	'ﬅ': "st",
+	'ﬆ': "st",
+}
+
+// quoteFolds are the ASCII quotes that curly quotes and similar characters are folded into.
+var quoteFolds = map[rune]rune{
+	'‘': '\'', // Left single quotation mark.
+	'’': '\'', // Right single quotation mark.
+	'‚': '\'', // Single low-9 quotation mark.
+	'‛': '\'', // Single high-reversed-9 quotation mark.
+	'′': '\'', // Prime.
+	'“': '"',  // Left double quotation mark.
+	'”': '"',  // Right double quotation mark.
+	'„': '"',  // Double low-9 quotation mark.
+	'‟': '"',  // Double high-reversed-9 quotation mark.
+	'″': '"',  // Double prime.
+	'«': '"',  // Left-pointing double angle quotation mark.
+	'»': '"',  // Right-pointing double angle quotation mark.
+}
+
+// ParseTextNormalization parses `s`, a comma separated list of the normalizations nfkc, ligatures,
+// softhyphens and quotes, or "default", "all" or "none".
+func ParseTextNormalization(s string) (TextNormalization, error) {
+	var n TextNormalization
+	for _, part := range strings.Split(s, ",") {
+		part = strings.ToLower(strings.TrimSpace(part))
+		switch part {
+		case "", "none":
+			continue
+		case "default", "all":
+			n |= DefaultNormalization
+			continue
+		}
+		found := false
+		for _, nn := range normalizationNames {
+			if nn.name == part {
+				n |= nn.flag
+				found = true
+			}
+		}
+		if !found {
+			return 0, fmt.Errorf("Unknown text normalization %q. Use a comma separated list of "+
+				"nfkc, ligatures, softhyphens and quotes, or none", part)
+		}
+	}
+	return n, nil
+}
+
+// String returns `n` in the format that ParseTextNormalization parses.
+func (n TextNormalization) String() string {
+	var parts []string
+	for _, nn := range normalizationNames {
+		if n&nn.flag != 0 {
+			parts = append(parts, nn.name)
+		}
+	}
+	if len(parts) == 0 {
+		return "none"
+	}
+	return strings.Join(parts, ",")
+}
+
+// MarshalText returns the String of `n` so that it is readable in config.json.
+func (n TextNormalization) MarshalText() ([]byte, error) {
+	return []byte(n.String()), nil
+}
+
+// UnmarshalText sets `n` from `text` which is in the format that ParseTextNormalization parses.
+func (n *TextNormalization) UnmarshalText(text []byte) error {
+	nn, err := ParseTextNormalization(string(text))
+	if err != nil {
+		return err
+	}
+	*n = nn
+	return nil
+}
+
+// normalizeQuery returns query string `q` with the normalizations in `n` applied to it.
+func (n TextNormalization) normalizeQuery(q string) string {
+	text, _ := n.normalizePage(q, nil)
+	return text
+}
+
+// normalizePage returns page text `text` and its text locations `locs` with the normalizations in
+// `n` applied. The locations of replaced characters are moved to the start of their replacements
+// and the locations of removed characters are dropped. See textRewriter.
+func (n TextNormalization) normalizePage(text string, locs []serial.TextLocation) (string,
+	[]serial.TextLocation) {
+
+	if n == 0 {
+		return text, locs
+	}
+	r := newTextRewriter(text)
+	changed := false
+	forEachChunk(text, n&NormNFKC != 0, func(start, end int) {
+		chunk := text[start:end]
+		out := n.normalizeChunk(chunk)
+		if out == chunk {
+			r.keep(uint32(end))
+			return
+		}
+		r.keep(uint32(start))
+		r.replace(uint32(end), out)
+		changed = true
+	})
+	if !changed {
+		return text, locs
+	}
+	normalized := r.text()
+	return normalized, r.mapLocations(locs)
+}
+
+// forEachChunk calls `f` on the offsets of successive chunks of `text`. The chunks are the
+// segments that NFKC normalizes independently if `nfkc` is true and characters otherwise.
+func forEachChunk(text string, nfkc bool, f func(start, end int)) {
+	if !nfkc {
+		for start := 0; start < len(text); {
+			_, size := utf8.DecodeRuneInString(text[start:])
+			f(start, start+size)
+			start += size
+		}
+		return
+	}
+	var it norm.Iter
+	it.InitString(norm.NFKC, text)
+	for !it.Done() {
+		start := it.Pos()
+		it.Next()
+		f(start, it.Pos())
+	}
+}
+
+// normalizeChunk returns `chunk` with the normalizations in `n` applied. Invalid UTF-8 is left for
+// canonicalPageText to replace.
+func (n TextNormalization) normalizeChunk(chunk string) string {
+	if len(chunk) == 1 && chunk[0] < utf8.RuneSelf || !utf8.ValidString(chunk) {
+		return chunk
+	}
+	var b strings.Builder
+	for _, c := range chunk {
+		if c == '\u00ad' && n&NormSoftHyphens != 0 {
+			continue
+		}
+		if s, ok := ligatures[c]; ok && n&NormLigatures != 0 {
+			b.WriteString(s)
+			continue
+		}
+		if q, ok := quoteFolds[c]; ok && n&NormQuotes != 0 {
+			c = q
+		}
+		b.WriteRune(c)
+	}
+	out := b.String()
+	if n&NormNFKC != 0 {
+		out = norm.NFKC.String(out)
+	}
+	return out
+}
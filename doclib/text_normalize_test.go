@@ -0,0 +1,73 @@
+package doclib
+
+import (
+	"testing"
+
+	"github.com/peterwilliams97/pdf-search/serial"
+)
+
+func TestNormalizePage(t *testing.T) {
+	for _, test := range []struct {
+		n              TextNormalization
+		text, expected string
+	}{
+		{DefaultNormalization, "ﬁnal ﬂow", "final flow"},
+		{NormLigatures, "ﬁnal ﬂow", "final flow"},
+		{NormSoftHyphens, "hy\u00adphen", "hyphen"},
+		{NormQuotes, "“it’s”", `"it's"`},
+		{NormNFKC, "ＡＢＣ x²", "ABC x2"},
+		{NormNFKC, "cafe\u0301", "caf\u00e9"},
+		{0, "ﬁnal “x”", "ﬁnal “x”"},
+		{DefaultNormalization, "bad \xff text", "bad \xff text"},
+	} {
+		text, _ := test.n.normalizePage(test.text, nil)
+		if text != test.expected {
+			t.Errorf("%s %q: got %q expected %q", test.n, test.text, text, test.expected)
+		}
+	}
+}
+
+func TestNormalizePageLocations(t *testing.T) {
+	text := "a ﬁ’s"
+	var locs []serial.TextLocation
+	for i := range text {
+		locs = append(locs, serial.TextLocation{Start: uint32(i), Llx: float32(i)})
+	}
+	normalized, normLocs := DefaultNormalization.normalizePage(text, locs)
+	if normalized != "a fi's" {
+		t.Fatalf("text=%q", normalized)
+	}
+	// The ligature's location moves to the "f" and the other locations move with their characters.
+	expected := map[uint32]float32{0: 0, 1: 1, 2: 2, 4: 5, 5: 8}
+	if len(normLocs) != len(expected) {
+		t.Fatalf("locations=%v", normLocs)
+	}
+	for _, loc := range normLocs {
+		if llx, ok := expected[loc.Start]; !ok || llx != loc.Llx {
+			t.Errorf("location %s expected Llx=%g", loc, llx)
+		}
+	}
+}
+
+func TestParseTextNormalization(t *testing.T) {
+	for _, test := range []struct {
+		s        string
+		expected TextNormalization
+	}{
+		{"", 0},
+		{"none", 0},
+		{"all", DefaultNormalization},
+		{"nfkc, Quotes", NormNFKC | NormQuotes},
+	} {
+		n, err := ParseTextNormalization(test.s)
+		if err != nil || n != test.expected {
+			t.Errorf("%q: got %s err=%v expected %s", test.s, n, err, test.expected)
+		}
+		if n2, err := ParseTextNormalization(n.String()); err != nil || n2 != n {
+			t.Errorf("%q: String %q doesn't round trip", test.s, n)
+		}
+	}
+	if _, err := ParseTextNormalization("nfkc,lowercase"); err == nil {
+		t.Errorf("expected an error for an unknown normalization")
+	}
+}
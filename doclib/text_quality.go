@@ -0,0 +1,86 @@
+package doclib
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// LowTextQuality is the TextQuality below which a page's text is counted as low quality in
+// DocInfo.LowQualityPages. Text extracted through a broken CMap usually scores well below it.
+const LowTextQuality = 0.5
+
+// commonWordRatio is the fraction of the words in typical English text that are in commonWords.
+// Text with this fraction of common words gets the full dictionary score.
+const commonWordRatio = 0.2
+
+// commonWords are frequent English words. Real English text has many of them and text from a bad
+// CMap has almost none.
+var commonWords = map[string]bool{}
+
+func init() {
+	for _, w := range strings.Fields(`a about after all also an and any are as at be been but by
+		can could do for from had has have he her his how if in into is it its may more most
+		new no not of on one or other our out over she so some such than that the their them
+		then there these they this to two up was we were what when which who will with would
+		you your`) {
+		commonWords[w] = true
+	}
+}
+
+// TextQuality returns a score in [0, 1] of how much `text` looks like real text rather than the
+// garbage that is extracted from PDFs with broken font encodings. It combines
+//   - the fraction of runes that are not control characters, replacement runes or private use
+//     runes,
+//   - the fraction of words that are word-like, i.e. mostly letters, and
+//   - the fraction of words that are common English words.
+// Non-English text gets no credit for common words so its scores are lower but good text still
+// scores above LowTextQuality.
+func TextQuality(text string) float64 {
+	numRunes, numBad := 0, 0
+	for _, r := range text {
+		if unicode.IsSpace(r) {
+			continue
+		}
+		numRunes++
+		if r == utf8.RuneError || unicode.IsControl(r) || unicode.Is(unicode.Co, r) {
+			numBad++
+		}
+	}
+	if numRunes == 0 {
+		return 0.0
+	}
+	words := strings.Fields(text)
+	numWordLike, numCommon := 0, 0
+	for _, w := range words {
+		w = strings.TrimFunc(w, unicode.IsPunct)
+		if isWordLike(w) {
+			numWordLike++
+		}
+		if commonWords[strings.ToLower(w)] {
+			numCommon++
+		}
+	}
+	runeScore := 1.0 - float64(numBad)/float64(numRunes)
+	wordScore := float64(numWordLike) / float64(len(words))
+	commonScore := float64(numCommon) / float64(len(words)) / commonWordRatio
+	if commonScore > 1.0 {
+		commonScore = 1.0
+	}
+	return runeScore * (0.6*wordScore + 0.4*commonScore)
+}
+
+// isWordLike returns true if `w` is mostly letters and not too long to be a word.
+func isWordLike(w string) bool {
+	n := utf8.RuneCountInString(w)
+	if n == 0 || n > 30 {
+		return false
+	}
+	numLetters := 0
+	for _, r := range w {
+		if unicode.IsLetter(r) {
+			numLetters++
+		}
+	}
+	return 2*numLetters > n
+}
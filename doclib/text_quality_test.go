@@ -0,0 +1,24 @@
+package doclib
+
+import "testing"
+
+func TestTextQuality(t *testing.T) {
+	tests := []struct {
+		text     string
+		min, max float64
+	}{
+		{"", 0.0, 0.0},
+		{"The quick brown fox jumps over the lazy dog and it was not amused by the dog.", 0.9, 1.0},
+		{"Der schnelle braune Fuchs springt über den faulen Hund.", LowTextQuality, 0.7},
+		{"\x01\x02 \x03\x04 \ufffd\ufffd \x05#$", 0.0, 0.1},
+		{"#$%& ()*+ ,-./ 0123 4567 89:; <=>?", 0.0, 0.1},
+		{"\ue001\ue002\ue003 \ue004\ue005 the", 0.0, LowTextQuality},
+	}
+	for _, test := range tests {
+		quality := TextQuality(test.text)
+		if quality < test.min || quality > test.max {
+			t.Errorf("text=%q quality=%.3f expected [%.3f, %.3f]",
+				test.text, quality, test.min, test.max)
+		}
+	}
+}
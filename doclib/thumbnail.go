@@ -0,0 +1,134 @@
+package doclib
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+
+	"github.com/unidoc/unidoc/common"
+)
+
+// thumbsDirName is the name of the directory in a store that rendered pages are cached in.
+const thumbsDirName = "thumbs"
+
+const (
+	defaultThumbnailDPI = 36  // Resolution of thumbnails when none is given.
+	maxThumbnailDPI     = 300 // Highest resolution thumbnails are rendered at.
+)
+
+// thumbnailMarkColor is the color of the match rectangles that are burned into thumbnails.
+var thumbnailMarkColor = color.NRGBA{R: 0xFF, G: 0xD0, B: 0x00, A: 0x70}
+
+// RenderPageThumbnail returns a PNG image of page `pageIdx` of document `docIdx` in `x` rendered
+// at `dpi` dots per inch. The rectangles `marks`, e.g. PdfMatch.Boxes, are drawn on the image as
+// translucent highlights. They are in CoordsView coordinates.
+// Pages are rendered with pdftoppm from poppler-utils, which must be installed, so the document's
+// PDF file must be on disk. Rendered pages are cached in the store's thumbs directory. The marks
+// are drawn on the cached image for each call.
+// If `dpi` <= 0 a thumbnail resolution of 36 dpi is used. `dpi` is capped at 300.
+func (x *PdfIndex) RenderPageThumbnail(docIdx uint64, pageIdx uint32, dpi int,
+	marks []ViewRect) ([]byte, error) {
+
+	if dpi <= 0 {
+		dpi = defaultThumbnailDPI
+	}
+	if dpi > maxThumbnailDPI {
+		dpi = maxThumbnailDPI
+	}
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+	if x.index == nil {
+		return nil, ErrClosed
+	}
+	if int(docIdx) >= len(x.lState.fileList) {
+		return nil, ErrNoDoc
+	}
+	fd := x.lState.fileList[docIdx]
+	lDoc, err := x.lState.OpenPositionsDoc(docIdx)
+	if err != nil {
+		return nil, err
+	}
+	defer lDoc.Close()
+	if pageIdx >= uint32(lDoc.Len()) {
+		return nil, ErrRange
+	}
+	pageNum, err := lDoc.PageNum(pageIdx)
+	if err != nil {
+		return nil, err
+	}
+	thumbPath := filepath.Join(x.persistDir, thumbsDirName,
+		fmt.Sprintf("%s.%d.%d.png", fd.Hash, pageNum, dpi))
+	if !Exists(thumbPath) {
+		if err := renderPagePng(fd.InPath, pageNum, dpi, thumbPath); err != nil {
+			return nil, err
+		}
+	}
+	data, err := ioutil.ReadFile(thumbPath)
+	if err != nil || len(marks) == 0 {
+		return data, err
+	}
+	return markPng(data, marks, float64(dpi)/72.0)
+}
+
+// RenderMatchThumbnail returns a PNG image of the page of match `m`, which was returned by a
+// search of `x`, rendered at `dpi` with the matched terms highlighted. See RenderPageThumbnail.
+func (x *PdfIndex) RenderMatchThumbnail(m PdfMatch, dpi int) ([]byte, error) {
+	return x.RenderPageThumbnail(m.docIdx, m.pageIdx, dpi, m.Boxes)
+}
+
+// renderPagePng renders the crop box of page number `pageNum` of PDF file `inPath` at `dpi` dots
+// per inch to PNG file `outPath`. The file is written atomically so concurrent renderings of the
+// same page don't see partial files.
+func renderPagePng(inPath string, pageNum uint32, dpi int, outPath string) error {
+	if err := MkDir(filepath.Dir(outPath)); err != nil {
+		return err
+	}
+	dir, err := ioutil.TempDir(filepath.Dir(outPath), "render")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+	prefix := filepath.Join(dir, "page")
+	page := strconv.Itoa(int(pageNum))
+	cmd := exec.Command("pdftoppm", "-f", page, "-l", page, "-r", strconv.Itoa(dpi), "-cropbox",
+		"-png", "-singlefile", inPath, prefix)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		common.Log.Error("renderPagePng: pdftoppm failed. inPath=%q pageNum=%d err=%v",
+			inPath, pageNum, err)
+		return fmt.Errorf("Could not render %q:%d. err=%v\n%s", inPath, pageNum, err, out)
+	}
+	return os.Rename(prefix+".png", outPath)
+}
+
+// markPng returns PNG image `data` with the rectangles `marks` drawn on it as translucent
+// highlights. `marks` are in points and `scale` is the number of pixels per point.
+func markPng(data []byte, marks []ViewRect, scale float64) ([]byte, error) {
+	src, err := png.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	img := image.NewNRGBA(src.Bounds())
+	draw.Draw(img, img.Bounds(), src, src.Bounds().Min, draw.Src)
+	fill := image.NewUniform(thumbnailMarkColor)
+	for _, r := range marks {
+		if r == (ViewRect{}) {
+			continue
+		}
+		rect := image.Rect(int(r.X*scale), int(r.Y*scale), int((r.X+r.W)*scale+0.5),
+			int((r.Y+r.H)*scale+0.5))
+		draw.Draw(img, rect.Add(img.Bounds().Min), fill, image.Point{}, draw.Over)
+	}
+	var b bytes.Buffer
+	if err := png.Encode(&b, img); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
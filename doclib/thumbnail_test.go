@@ -0,0 +1,38 @@
+package doclib
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"testing"
+)
+
+func TestMarkPng(t *testing.T) {
+	white := image.NewGray(image.Rect(0, 0, 100, 100))
+	for i := range white.Pix {
+		white.Pix[i] = 0xFF
+	}
+	var b bytes.Buffer
+	if err := png.Encode(&b, white); err != nil {
+		t.Fatal(err)
+	}
+	// A 10x10 point mark at (10, 20) is 20x20 pixels at (20, 40) with 2 pixels per point.
+	data, err := markPng(b.Bytes(), []ViewRect{{X: 10, Y: 20, W: 10, H: 10}}, 2.0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	img, err := png.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	isWhite := func(x, y int) bool {
+		return color.NRGBAModel.Convert(img.At(x, y)) == color.NRGBA{0xFF, 0xFF, 0xFF, 0xFF}
+	}
+	if isWhite(25, 45) {
+		t.Errorf("Pixel inside mark is white")
+	}
+	if !isWhite(5, 5) || !isWhite(45, 45) || !isWhite(25, 65) {
+		t.Errorf("Pixel outside mark is not white")
+	}
+}
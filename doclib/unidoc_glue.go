@@ -1,6 +1,7 @@
 package doclib
 
 import (
+	"context"
 	"errors"
 	"flag"
 	"fmt"
@@ -16,7 +17,8 @@ import (
 var (
 	Debug bool
 	Trace bool
-	// ExposeErrors can be set to true to not recover from errors in library functions.
+	// ExposeErrors can be set to true to not recover from panics in the PDF library so that they
+	// can be debugged. Library errors are always returned as errors otherwise.
 	ExposeErrors bool
 )
 
@@ -85,13 +87,11 @@ func PdfOpenReader(f io.ReadSeeker, lazy bool) (*pdf.PdfReader, error) {
 
 	isEncrypted, err := pdfReader.IsEncrypted()
 	if err != nil {
-		panic(err)
 		return nil, err
 	}
 	if isEncrypted {
 		_, err = pdfReader.Decrypt([]byte(""))
 		if err != nil {
-			panic(err)
 			return nil, err
 		}
 	}
@@ -218,19 +218,51 @@ func ProcessPDFPagesFile(inPath string, processPage func(pageNum uint32, page *p
 	return ProcessPDFPagesReader(inPath, rs, processPage)
 }
 
+// ProcessPDFPagesReader runs `processPage` on every page in the PDF file read from `rs`.
+// `inPath` is the name of the PDF file.
 func ProcessPDFPagesReader(inPath string, rs io.ReadSeeker,
 	processPage func(pageNum uint32, page *pdf.PdfPage) error) error {
+	return ProcessPDFPagesReaderContext(context.Background(), inPath, rs, processPage)
+}
+
+// ProcessPDFPagesReaderContext is ProcessPDFPagesReader with a context. It stops with `ctx`'s
+// error if `ctx` is cancelled or times out before all the pages have been processed. `ctx` is
+// checked between pages so pages that are being processed are completed.
+func ProcessPDFPagesReaderContext(ctx context.Context, inPath string, rs io.ReadSeeker,
+	processPage func(pageNum uint32, page *pdf.PdfPage) error) error {
+	return ProcessPDFReader(inPath, rs, func(pdfReader *pdf.PdfReader) error {
+		return processPDFPages(ctx, inPath, pdfReader, nil, processPage)
+	})
+}
+
+// ProcessPDFPageRangesReader runs `processPage` on the pages in `ranges` in the PDF file read from
+// `rs`. `inPath` is the name of the PDF file.
+func ProcessPDFPageRangesReader(inPath string, rs io.ReadSeeker, ranges PageRanges,
+	processPage func(pageNum uint32, page *pdf.PdfPage) error) error {
+	return ProcessPDFReader(inPath, rs, func(pdfReader *pdf.PdfReader) error {
+		return processPDFPages(context.Background(), inPath, pdfReader, ranges, processPage)
+	})
+}
+
+// ProcessPDFReader opens the PDF file read from `rs` and runs `process` on it. `inPath` is the
+// name of the PDF file.
+// It recovers from panics in the libraries it calls and returns them as errors unless ExposeErrors
+// is true. PDFs that cause panics when they are indexed into a store are quarantined. See
+// CorpusFilter.
+func ProcessPDFReader(inPath string, rs io.ReadSeeker, process func(*pdf.PdfReader) error) (
+	err error) {
 
-	var err error
 	if !ExposeErrors {
 		defer func() {
 			if r := recover(); r != nil {
 				common.Log.Error("Recover: %q r=%#v", inPath, r)
 				switch t := r.(type) {
 				case error:
-					err = t
+					err = panicError{t}
 				case string:
-					err = errors.New(t)
+					err = panicError{errors.New(t)}
+				default:
+					err = panicError{fmt.Errorf("panic: %v", t)}
 				}
 			}
 		}()
@@ -238,17 +270,27 @@ func ProcessPDFPagesReader(inPath string, rs io.ReadSeeker,
 
 	pdfReader, err := PdfOpenReader(rs, true)
 	if err != nil {
-		common.Log.Error("processPDFPages: Could not open inPath=%q. err=%v", inPath, err)
+		common.Log.Error("ProcessPDFReader: Could not open inPath=%q. err=%v", inPath, err)
 		return err
 	}
 
-	err = processPDFPages(inPath, pdfReader, processPage)
+	err = process(pdfReader)
 	return err
 }
 
-// processPDFPages runs `processPage` on every page in PDF file `inPath`.
-func processPDFPages(inPath string, pdfReader *pdf.PdfReader,
-	processPage func(pageNum uint32, page *pdf.PdfPage) error) error {
+// panicError is a panic in a library called by ProcessPDFReader returned as an error.
+type panicError struct {
+	err error
+}
+
+func (e panicError) Error() string {
+	return e.err.Error()
+}
+
+// processPDFPages runs `processPage` on the pages in `ranges` in PDF file `inPath`. It stops with
+// `ctx`'s error if `ctx` is done.
+func processPDFPages(ctx context.Context, inPath string, pdfReader *pdf.PdfReader,
+	ranges PageRanges, processPage func(pageNum uint32, page *pdf.PdfPage) error) error {
 
 	numPages, err := pdfReader.GetNumPages()
 	if err != nil {
@@ -257,7 +299,16 @@ func processPDFPages(inPath string, pdfReader *pdf.PdfReader,
 
 	common.Log.Debug("processPDFPages: inPath=%q numPages=%d", inPath, numPages)
 
-	for pageNum := uint32(1); pageNum < uint32(numPages); pageNum++ {
+	for pageNum := uint32(1); pageNum <= uint32(numPages); pageNum++ {
+		if ranges.After(pageNum) {
+			break
+		}
+		if !ranges.Contains(pageNum) {
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
 		page, err := pdfReader.GetPage(int(pageNum))
 		if err != nil {
 			return err
@@ -0,0 +1,170 @@
+package doclib
+
+import (
+	"sort"
+	"unicode"
+	"unicode/utf8"
+
+	bleveunicode "github.com/blevesearch/bleve/analysis/tokenizer/unicode"
+	"github.com/peterwilliams97/pdf-search/serial"
+)
+
+// maxRuneAspect is the largest width/height ratio of a character in a text location. Wider
+// locations are extractor errors, e.g. marks that span a whole line or column, and are ignored.
+const maxRuneAspect = 4.0
+
+// wordTokenizer splits page texts into the same tokens that the bleve analyzers of the page text
+// fields do so that word locations have the same offsets as the terms that bleve matches.
+var wordTokenizer = bleveunicode.NewUnicodeTokenizer()
+
+// wordLocations returns the locations of the words in page text `text` given `locs`, the text
+// locations reported by an extractor. Extractor locations may be for single characters, whole
+// words or runs of words, and each covers the text up to the next location. The returned
+// locations are one per word, with the word's offsets in Start and End and the union of the
+// boxes of the characters in the word. A location that covers several words is split between
+// them in proportion to their numbers of characters. Locations that are too wide to be real
+// characters are ignored and words with no usable locations have no location. Words that were
+// joined from two lines have the box of their part on the first line.
+// See GetPosition.
+func wordLocations(text string, locs []serial.TextLocation) []serial.TextLocation {
+	n := uint32(len(text))
+	var sorted []serial.TextLocation
+	for _, loc := range locs {
+		if loc.Start < n {
+			sorted = append(sorted, loc)
+		}
+	}
+	if len(sorted) == 0 {
+		return nil
+	}
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].Start < sorted[j].Start })
+
+	// ends[i] is the end of the text covered by sorted[i].
+	ends := make([]uint32, len(sorted))
+	next := n
+	for i := len(sorted) - 1; i >= 0; i-- {
+		if i+1 < len(sorted) && sorted[i+1].Start > sorted[i].Start {
+			next = sorted[i+1].Start
+		}
+		ends[i] = next
+		if end := sorted[i].End; end > sorted[i].Start && end < next {
+			ends[i] = end
+		}
+	}
+
+	var words []serial.TextLocation
+	j := 0 // Index of the first location that may overlap the current word.
+	for _, tok := range wordTokenizer.Tokenize([]byte(text)) {
+		start, end := uint32(tok.Start), uint32(tok.End)
+		for j < len(sorted) && ends[j] <= start {
+			j++
+		}
+		var word serial.TextLocation
+		found := false
+		for k := j; k < len(sorted) && sorted[k].Start < end; k++ {
+			part, ok := locationPart(text, sorted[k], ends[k], start, end)
+			if !ok {
+				continue
+			}
+			if found {
+				if part.Lly >= word.Ury || part.Ury <= word.Lly {
+					// A part of a word that was joined from two lines. See joinHyphenated.
+					continue
+				}
+				part = unionLocation(word, part)
+			}
+			word, found = part, true
+		}
+		if found {
+			word.Start, word.End = start, end
+			words = append(words, word)
+		}
+	}
+	return words
+}
+
+// locationPart returns the part of the box of `loc`, which covers the text from loc.Start to `end`,
+// that covers the text over [`start`, `end`). The box is divided horizontally in proportion to the
+// number of non-space characters. It returns false if `loc` doesn't cover any of the characters or
+// its box isn't usable.
+func locationPart(text string, loc serial.TextLocation, locEnd, start, end uint32) (
+	serial.TextLocation, bool) {
+
+	if start < loc.Start {
+		start = loc.Start
+	}
+	if end > locEnd {
+		end = locEnd
+	}
+	if start >= end {
+		return serial.TextLocation{}, false
+	}
+	width, height := loc.Urx-loc.Llx, loc.Ury-loc.Lly
+	if width < 0 || height <= 0 {
+		return serial.TextLocation{}, false
+	}
+	runes := nonSpaceRunes(text[loc.Start:locEnd])
+	if runes == 0 || width > maxRuneAspect*height*float32(runes) {
+		return serial.TextLocation{}, false
+	}
+	r0 := nonSpaceRunes(text[loc.Start:start])
+	r1 := nonSpaceRunes(text[loc.Start:end])
+	if r0 == r1 {
+		return serial.TextLocation{}, false
+	}
+	part := loc
+	part.Llx = loc.Llx + width*float32(r0)/float32(runes)
+	part.Urx = loc.Llx + width*float32(r1)/float32(runes)
+	return part, true
+}
+
+// nonSpaceRunes returns the number of characters in `s` that aren't white space.
+func nonSpaceRunes(s string) int {
+	n := 0
+	for len(s) > 0 {
+		r, size := utf8.DecodeRuneInString(s)
+		if !unicode.IsSpace(r) {
+			n++
+		}
+		s = s[size:]
+	}
+	return n
+}
+
+// unionLocation returns a location with the bounding box of the boxes of `a` and `b`.
+func unionLocation(a, b serial.TextLocation) serial.TextLocation {
+	return serial.TextLocation{
+		Start: a.Start,
+		End:   a.End,
+		Llx:   min(a.Llx, b.Llx),
+		Lly:   min(a.Lly, b.Lly),
+		Urx:   max(a.Urx, b.Urx),
+		Ury:   max(a.Ury, b.Ury),
+	}
+}
+
+// isWordLocations returns true if `positions` are word locations with their ends recorded, such as
+// the locations made by wordLocations, rather than the character locations of older stores.
+func isWordLocations(positions []serial.TextLocation) bool {
+	return len(positions) > 0 && positions[0].End > positions[0].Start
+}
+
+// wordsPosition returns the bounding box of the word locations `positions` that overlap the text
+// over [`start`, `end`).
+func wordsPosition(positions []serial.TextLocation, start, end uint32) serial.TextLocation {
+	i := sort.Search(len(positions), func(i int) bool { return positions[i].End > start })
+	var loc serial.TextLocation
+	found := false
+	for ; i < len(positions) && positions[i].Start < end; i++ {
+		if found {
+			loc = unionLocation(loc, positions[i])
+		} else {
+			loc, found = positions[i], true
+		}
+	}
+	if !found {
+		return serial.TextLocation{}
+	}
+	loc.Start, loc.End = start, end
+	return loc
+}
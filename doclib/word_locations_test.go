@@ -0,0 +1,165 @@
+package doclib
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/peterwilliams97/pdf-search/serial"
+)
+
+// charLocations returns character locations for `text` like those of UniDoc, with each character
+// 10 points wide and 12 points high on a line at the bottom of the page. Spaces have no location.
+func charLocations(text string) []serial.TextLocation {
+	var locs []serial.TextLocation
+	for i, c := range text {
+		if c == ' ' {
+			continue
+		}
+		x := float32(10 * i)
+		locs = append(locs, serial.TextLocation{Start: uint32(i), Llx: x, Lly: 0, Urx: x + 10,
+			Ury: 12})
+	}
+	return locs
+}
+
+func TestWordLocationsChars(t *testing.T) {
+	text := "Hello, big world"
+	words := wordLocations(text, charLocations(text))
+	expected := []serial.TextLocation{
+		{Start: 0, End: 5, Llx: 0, Lly: 0, Urx: 50, Ury: 12},
+		{Start: 7, End: 10, Llx: 70, Lly: 0, Urx: 100, Ury: 12},
+		{Start: 11, End: 16, Llx: 110, Lly: 0, Urx: 160, Ury: 12},
+	}
+	checkLocations(t, words, expected)
+}
+
+func TestWordLocationsSplit(t *testing.T) {
+	// One location for a run of two words, e.g. from a TJ operator with spacing.
+	text := "abcd ef"
+	locs := []serial.TextLocation{{Start: 0, Llx: 100, Lly: 0, Urx: 160, Ury: 12}}
+	words := wordLocations(text, locs)
+	expected := []serial.TextLocation{
+		{Start: 0, End: 4, Llx: 100, Lly: 0, Urx: 140, Ury: 12},
+		{Start: 5, End: 7, Llx: 140, Lly: 0, Urx: 160, Ury: 12},
+	}
+	checkLocations(t, words, expected)
+}
+
+func TestWordLocationsBadBox(t *testing.T) {
+	text := "ab cd"
+	locs := charLocations(text)
+	// A 'b' that spans most of the page and a 'd' with no box.
+	locs[1].Urx = 600
+	locs = append(locs[:3], serial.TextLocation{Start: 4})
+	words := wordLocations(text, locs)
+	expected := []serial.TextLocation{
+		{Start: 0, End: 2, Llx: 0, Lly: 0, Urx: 10, Ury: 12},
+		{Start: 3, End: 5, Llx: 30, Lly: 0, Urx: 40, Ury: 12},
+	}
+	checkLocations(t, words, expected)
+
+	if words := wordLocations(text, nil); len(words) != 0 {
+		t.Errorf("no locations: words=%v", words)
+	}
+}
+
+func TestWordLocationsOCR(t *testing.T) {
+	text, locs := ocrWordsText([]OCRWord{
+		{Text: "R&D", Line: 1, Llx: 0, Lly: 0, Urx: 30, Ury: 10},
+		{Text: "lab", Line: 1, Llx: 40, Lly: 0, Urx: 70, Ury: 10},
+	})
+	words := wordLocations(text, locs)
+	expected := []serial.TextLocation{
+		{Start: 0, End: 1, Llx: 0, Lly: 0, Urx: 10, Ury: 10},
+		{Start: 2, End: 3, Llx: 20, Lly: 0, Urx: 30, Ury: 10},
+		{Start: 4, End: 7, Llx: 40, Lly: 0, Urx: 70, Ury: 10},
+	}
+	checkLocations(t, words, expected)
+}
+
+func TestGetPosition(t *testing.T) {
+	text := "Hello, big world"
+	words := wordLocations(text, charLocations(text))
+	for _, test := range []struct {
+		start, end uint32
+		expected   serial.TextLocation
+	}{
+		{0, 5, serial.TextLocation{Start: 0, End: 5, Llx: 0, Lly: 0, Urx: 50, Ury: 12}},
+		{7, 16, serial.TextLocation{Start: 7, End: 16, Llx: 70, Lly: 0, Urx: 160, Ury: 12}},
+		{5, 7, serial.TextLocation{}},
+		{20, 25, serial.TextLocation{}},
+	} {
+		if loc := GetPosition(words, test.start, test.end); loc != test.expected {
+			t.Errorf("[%d:%d] got %s expected %s", test.start, test.end, loc, test.expected)
+		}
+	}
+	if loc := GetPosition(nil, 0, 5); loc != (serial.TextLocation{}) {
+		t.Errorf("no positions: got %s", loc)
+	}
+}
+
+// TestWordLocationsPersist checks that word locations, including their ends, are read back from
+// the .dat file of a persistent store, and that migrateWordLocations rebuilds the word locations
+// of pages that were saved without ends or with character locations.
+func TestWordLocationsPersist(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pdf-search-locations")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	lState, err := OpenPositionsState(dir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	text := "Hello, big world"
+	words := wordLocations(text, charLocations(text))
+	noEnds := make([]serial.TextLocation, len(words))
+	for i, loc := range words {
+		loc.End = 0
+		noEnds[i] = loc
+	}
+	hashes := []string{"a0123456789", "b0123456789", "c0123456789"}
+	for i, locs := range [][]serial.TextLocation{words, noEnds, charLocations(text)} {
+		lDoc, err := lState.CreatePositionsDoc(FileDesc{InPath: "doc.pdf", Hash: hashes[i]})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := lDoc.AddDocPage(1, serial.DocPageLocations{Page: 1, Locations: locs},
+			text); err != nil {
+			t.Fatal(err)
+		}
+		if err := lDoc.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	_, _, dpl, err := lState.ReadDocPagePositions(0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkLocations(t, dpl.Locations, words)
+
+	if err := migrateWordLocations(lState); err != nil {
+		t.Fatalf("migrateWordLocations failed. err=%v", err)
+	}
+	for docIdx := uint64(0); docIdx < 3; docIdx++ {
+		_, _, dpl, err := lState.ReadDocPagePositions(docIdx, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		checkLocations(t, dpl.Locations, words)
+	}
+}
+
+func checkLocations(t *testing.T, got, expected []serial.TextLocation) {
+	t.Helper()
+	if len(got) != len(expected) {
+		t.Fatalf("got %d locations expected %d. %v", len(got), len(expected), got)
+	}
+	for i, loc := range got {
+		if loc != expected[i] {
+			t.Errorf("%d: got %s expected %s", i, loc, expected[i])
+		}
+	}
+}
@@ -5,23 +5,32 @@ import (
 	"fmt"
 	"os"
 	"runtime"
+	"sync"
 
 	"github.com/peterwilliams97/pdf-search/doclib"
 )
 
 const usage = `Usage: go run concurrent_index_doc.go [OPTIONS] testdata/*.pdf
-Runs UniDoc PDF text extraction on PDF files in testdata and writes a Bleve index to
-store.concurrent.`
+Runs UniDoc PDF text extraction on PDF files in testdata concurrently and writes a bleve+positions
+index to store.concurrent.doc.
+The PDFs are extracted by worker goroutines. Each document's positions are committed before its
+pages are added to the bleve index so a crash can't leave the two out of step. Use position_index.go
+-r to resume an interrupted run.
+With -g, the PDFs are split between that many goroutines that add them to the same store at once.`
 
-var indexPath = "store.concurrent.doc"
+var persistDir = "store.concurrent.doc"
 
 func main() {
-	flag.StringVar(&indexPath, "s", indexPath, "Bleve store name. This is a directory.")
+	flag.StringVar(&persistDir, "s", persistDir, "Index store directory name.")
 	var forceCreate, allowAppend bool
-	flag.BoolVar(&forceCreate, "f", false, "Force creation of a new Bleve index.")
-	flag.BoolVar(&allowAppend, "a", false, "Allow existing an Bleve index to be appended to.")
-	numWorkers := -1
-	flag.IntVar(&numWorkers, "w", numWorkers, "Number of worker threads.")
+	flag.BoolVar(&forceCreate, "f", false, "Force creation of a new index store.")
+	flag.BoolVar(&allowAppend, "a", false, "Allow an existing index store to be appended to.")
+	opts := doclib.DefaultIndexOptions()
+	flag.IntVar(&opts.NumWorkers, "w", opts.NumWorkers, "Number of worker threads.")
+	flag.IntVar(&opts.BatchSize, "b", opts.BatchSize,
+		"Number of pages to add to the Bleve index in a batch.")
+	numWriters := 1
+	flag.IntVar(&numWriters, "g", numWriters, "Number of goroutines adding PDFs to the store.")
 	doclib.MakeUsage(usage)
 
 	fmt.Printf("GOMAXPROCS: %d\n", runtime.GOMAXPROCS(-1))
@@ -40,59 +49,53 @@ func main() {
 		fmt.Fprintf(os.Stderr, "PatternsToPaths failed. args=%#q err=%v\n", flag.Args(), err)
 		os.Exit(1)
 	}
-	pathList = doclib.CleanCorpus(pathList)
-	fmt.Printf("Indexing %d PDF files.\n", len(pathList))
+	fmt.Printf("Indexing %d PDF files. %d workers\n", len(pathList), opts.NumWorkers)
 
-	// Create a new index.
-	index, err := doclib.CreateBleveIndex(indexPath, forceCreate, allowAppend)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Could not create Bleve index %q.\n", indexPath)
-		panic(err)
+	report := func(msg string) { fmt.Println(msg) }
+	if numWriters <= 1 {
+		_, index, totalPages, err := doclib.IndexPdfFilesOpts(pathList, persistDir, forceCreate,
+			allowAppend, opts, report)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Could not index %q. err=%v\n", persistDir, err)
+			os.Exit(1)
+		}
+		defer index.Close()
+		fmt.Printf("Finished. %d pages\n", totalPages)
+		return
 	}
 
-	// Set a number of worker threads that won't overload the host computer.
-	if numWorkers < 0 {
-		numWorkers = runtime.NumCPU() - 1
-	}
-	if numWorkers <= 0 {
-		numWorkers = 1
+	// Open the store and then share it between `numWriters` goroutines.
+	lState, index, _, err := doclib.IndexPdfFilesOpts(nil, persistDir, forceCreate, allowAppend,
+		opts, report)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Could not open %q. err=%v\n", persistDir, err)
+		os.Exit(1)
 	}
-	fmt.Printf("%d workers\n", numWorkers)
-
-	// Create the processing queue.
-	queue := doclib.NewExtractDocQueue(numWorkers)
-	resultChan := make(chan *doclib.ExtractDocResult, len(pathList))
-
-	// Start a go routine to feed the processing queue.
-	go func() {
-		// Create processing instructions `w` for each file in pathList and add the processing
-		// instructions to the queue.
-		for i, inPath := range pathList {
-			w := doclib.NewExtractDocWork(i, inPath, resultChan)
-			queue.Queue(w)
+	defer index.Close()
+	var wg sync.WaitGroup
+	errs := make([]error, numWriters)
+	for g := 0; g < numWriters; g++ {
+		var part []string
+		for i := g; i < len(pathList); i += numWriters {
+			part = append(part, pathList[i])
 		}
-	}()
-
-	// Wait for extraction results here in the main thread.
-	for numDone := 0; numDone < len(pathList); numDone++ {
-		result := <-resultChan
-		for _, page := range result.DocPages {
-			err = index.Index(page.ID, page)
-			if err != nil {
-				fmt.Fprintf(os.Stderr, "Could not index %s.\n", result.DocID)
-				panic(err)
-			}
-		}
-		docCount, err := index.DocCount()
+		wg.Add(1)
+		go func(g int, part []string) {
+			defer wg.Done()
+			_, errs[g] = lState.IndexReaders(index, part, nil, opts, report)
+		}(g, part)
+	}
+	wg.Wait()
+	for _, err := range errs {
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "index.DocCount failed for %s. err=%v\n", result.DocID, err)
-			continue
+			fmt.Fprintf(os.Stderr, "Could not index %q. err=%v\n", persistDir, err)
+			os.Exit(1)
 		}
-		fmt.Printf("done=%d %s Total %d pages.\n", numDone, result.DocID, docCount)
 	}
-
-	// Shut down the processing queue workers.
-	queue.Close()
-
-	fmt.Println("Finished")
+	docCount, err := index.DocCount()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "index.DocCount failed. err=%v\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("Finished. %d pages\n", docCount)
 }
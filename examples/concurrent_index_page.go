@@ -22,6 +22,8 @@ func main() {
 	flag.BoolVar(&allowAppend, "a", false, "Allow existing an Bleve index to be appended to.")
 	numWorkers := -1
 	flag.IntVar(&numWorkers, "w", numWorkers, "Number of worker threads.")
+	batchSize := 100
+	flag.IntVar(&batchSize, "b", batchSize, "Number of pages to add to the Bleve index in a batch.")
 	doclib.MakeUsage(usage)
 
 	fmt.Printf("GOMAXPROCS: %d\n", runtime.GOMAXPROCS(-1))
@@ -40,7 +42,6 @@ func main() {
 		fmt.Fprintf(os.Stderr, "PatternsToPaths failed. args=%#q err=%v\n", flag.Args(), err)
 		os.Exit(1)
 	}
-	pathList = doclib.CleanCorpus(pathList)
 	fmt.Printf("Indexing %d PDF files.\n", len(pathList))
 
 	// Create a new index.
@@ -73,9 +74,15 @@ func main() {
 		}
 	}()
 
+	// Pages are added to the index in batches of `batchSize`.
+	batch := index.NewBatch()
 	completeJob := func(pageResult doclib.ExtractPageResult) error {
 		page := pageResult.Page
-		err := index.Index(page.ID, page)
+		err := batch.Index(page.ID, page)
+		if err == nil && batch.Size() >= batchSize {
+			err = index.Batch(batch)
+			batch.Reset()
+		}
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "Could not index %s.\n", pageResult.DocID)
 			panic(err)
@@ -85,6 +92,10 @@ func main() {
 
 	// Wait for extraction results here in the main thread.
 	queue.Complete(len(pathList), completeJob)
+	if err := index.Batch(batch); err != nil {
+		fmt.Fprintf(os.Stderr, "Could not index final batch. err=%v\n", err)
+		panic(err)
+	}
 
 	// Shut down the processing queue workers.
 	queue.Close()
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/peterwilliams97/pdf-search/doclib"
+)
+
+const usage = `Usage: go run delete_docs.go [OPTIONS] <query>
+Deletes the documents with pages that match <query> from the index store "store.position" that was
+created with position_index.go. Whole documents are deleted, not just the matching pages.
+<query> is a search query, e.g. "author:smith", or "path:<pattern>" to delete the documents whose
+paths match <pattern>, e.g. "path:/scans/2017/".
+Use -n to see which documents would be deleted without deleting them.
+Don't run this while other programs are using the store.`
+
+var persistDir = "store.position"
+
+func main() {
+	dryRun := false
+	flag.StringVar(&persistDir, "s", persistDir, "Index store directory name.")
+	flag.BoolVar(&dryRun, "n", dryRun, "Dry run. List the documents that would be deleted.")
+	doclib.MakeUsage(usage)
+	flag.Parse()
+	doclib.SetLogging()
+	if len(flag.Args()) < 1 {
+		flag.Usage()
+		os.Exit(1)
+	}
+	q := strings.Join(flag.Args(), " ")
+
+	report, err := doclib.DeleteByQuery(persistDir, q, dryRun)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Could not delete %q from %q. err=%v\n", q, persistDir, err)
+		os.Exit(1)
+	}
+	fmt.Println(report)
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/peterwilliams97/pdf-search/doclib"
+)
+
+const usage = `Usage: go run export_terms.go [OPTIONS] terms.tsv
+Writes the terms in the index "store.position" that was created with position_index.go, with the
+number of pages each term is on, to terms.tsv. The output is JSON if the file name ends in .json.
+This can be run after each index build to keep external autocomplete services in sync.`
+
+var persistDir = "store.position"
+
+func main() {
+	minCount := uint64(1)
+	flag.StringVar(&persistDir, "s", persistDir, "Index store directory name.")
+	flag.Uint64Var(&minCount, "m", minCount, "Only export terms that are on at least this many pages.")
+	doclib.MakeUsage(usage)
+	flag.Parse()
+	doclib.SetLogging()
+	if len(flag.Args()) < 1 {
+		flag.Usage()
+		os.Exit(1)
+	}
+	outPath := flag.Arg(0)
+
+	pdfIndex, err := doclib.OpenPdfIndex(persistDir)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Could not open %q. err=%v\n", persistDir, err)
+		os.Exit(1)
+	}
+	defer pdfIndex.Close()
+
+	format := doclib.TermDictTSV
+	if filepath.Ext(outPath) == ".json" {
+		format = doclib.TermDictJSON
+	}
+	f, err := os.Create(outPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Could not create %q. err=%v\n", outPath, err)
+		os.Exit(1)
+	}
+	defer f.Close()
+	if err := pdfIndex.ExportTermDict(f, format, minCount); err != nil {
+		fmt.Fprintf(os.Stderr, "Could not export terms. err=%v\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("Exported terms of %q to %q\n", persistDir, outPath)
+}
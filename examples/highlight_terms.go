@@ -0,0 +1,87 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/peterwilliams97/pdf-search/doclib"
+)
+
+const usage = `Usage: go run highlight_terms.go [OPTIONS] content stream dictionary
+Performs a full text search for "content stream dictionary" in the index "store.position" that was
+created with position_index.go and marks up every occurrence of every query term on the matching
+pages.`
+
+var persistDir = "store.position"
+
+func main() {
+	outPath := "highlight.results.pdf"
+	maxResults := 10
+	colors := ""
+	highlightDir := ""
+	style := doclib.DefaultMarkupStyle()
+	flag.StringVar(&persistDir, "s", persistDir, "Index store directory name.")
+	flag.StringVar(&outPath, "o", outPath, "Name of PDF file that will show marked up results.")
+	flag.IntVar(&maxResults, "n", maxResults, "Max number of results to return.")
+	flag.StringVar(&colors, "c", colors, "Comma separated hex colors of the query terms.")
+	flag.Float64Var(&style.BorderWidth, "w", style.BorderWidth, "Width of the rectangle borders.")
+	flag.BoolVar(&style.Annotate, "a", false,
+		"Mark up with annotations that show the matched terms in popups.")
+	flag.BoolVar(&style.Fill, "f", false, "Fill the annotations with the term colors.")
+	flag.Float64Var(&style.FillOpacity, "opacity", style.FillOpacity, "Opacity of the annotations.")
+	flag.StringVar(&highlightDir, "p", highlightDir,
+		"If set, write copies of the matching PDFs with highlight annotations to this directory.")
+	doclib.MakeUsage(usage)
+	flag.Parse()
+	doclib.SetLogging()
+	if len(flag.Args()) < 1 {
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	term := strings.Join(flag.Args(), " ")
+	results, err := doclib.SearchPdfIndex(persistDir, term, maxResults)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Could not search %q. err=%v\n", persistDir, err)
+		os.Exit(1)
+	}
+
+	// Show where each query term was found.
+	for t, pages := range results.TermLocations() {
+		fmt.Printf("term=%q %d pages\n", t, len(pages))
+		for _, page := range pages {
+			fmt.Printf("\t%q:%d %d matches\n", filepath.Base(page.InPath), page.PageNum,
+				len(page.Spans))
+		}
+	}
+
+	// Mark up every occurrence of every term on the matching pages.
+	extractions := doclib.CreateExtractList(maxResults)
+	if colors != "" {
+		style.Colors = strings.Split(colors, ",")
+	}
+	extractions.SetStyle(style)
+	for _, m := range results.Matches {
+		extractions.AddPdfMatch(m)
+	}
+	if err := extractions.SaveOutputPdf(outPath); err != nil {
+		fmt.Fprintf(os.Stderr, "Could not save %q. err=%v\n", outPath, err)
+		os.Exit(1)
+	}
+	fmt.Printf("term=%q\n", term)
+	fmt.Printf("Marked up %d pages in %q\n", extractions.NumPages(), outPath)
+
+	if highlightDir != "" {
+		outPaths, err := doclib.HighlightPdfMatchSet(results, highlightDir, style)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Could not highlight PDFs in %q. err=%v\n", highlightDir, err)
+			os.Exit(1)
+		}
+		for inPath, outPath := range outPaths {
+			fmt.Printf("Highlighted %q in %q\n", filepath.Base(inPath), outPath)
+		}
+	}
+}
@@ -7,7 +7,6 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
-	"time"
 
 	psearch "github.com/peterwilliams97/pdf-search"
 	"github.com/peterwilliams97/pdf-search/doclib"
@@ -50,7 +49,7 @@ func main() {
 	flag.BoolVar(&memory, "m", memory, "Serialize buffers to memory.")
 	flag.BoolVar(&persist, "p", persist, "Store index on disk (slower but allows more PDF files).")
 	flag.BoolVar(&reuse, "r", reuse, "Reused stored index on disk for the last -p run.")
-	flag.BoolVar(&nameOnly, "l", nameOnly, "Return matching file names only.")
+	flag.BoolVar(&nameOnly, "l", nameOnly, "Return matching files, hit counts and snippets only.")
 	flag.IntVar(&maxResults, "n", maxResults, "Max number of results to return.")
 	flag.BoolVar(&useReaderSeeker, "j", useReaderSeeker, "Exercise the io.ReaderSeeker API.")
 
@@ -84,7 +83,6 @@ func main() {
 			fmt.Fprintf(os.Stderr, "PatternsToPaths failed. args=%#q err=%v\n", flag.Args(), err)
 			os.Exit(1)
 		}
-		pathList = doclib.CleanCorpus(pathList)
 		if len(pathList) < 1 {
 			fmt.Fprintf(os.Stderr, "No files matching %q.\n", pathPattern)
 			os.Exit(1)
@@ -93,7 +91,6 @@ func main() {
 
 	term := strings.Join(flag.Args(), " ")
 
-	t0 := time.Now()
 	var pdfIndex psearch.PdfIndex
 	var data []byte
 	if reuse {
@@ -120,7 +117,6 @@ func main() {
 	}
 
 	var results doclib.PdfMatchSet
-	dtIndex := time.Since(t0)
 	if memory {
 		results, err = psearch.SearchMem(data, term, maxSearchResults)
 		if err != nil {
@@ -132,16 +128,16 @@ func main() {
 			panic(err)
 		}
 	}
-	dt := time.Since(t0)
-	dtSearch := dt - dtIndex
+	dt := results.Duration()
+	dtIndex := results.IndexDuration
 
 	if nameOnly {
-		files := results.Files()
-		if len(files) > maxResults {
-			files = files[:maxResults]
+		docs := results.ByDocument()
+		if len(docs) > maxResults {
+			docs = docs[:maxResults]
 		}
-		for i, fn := range files {
-			fmt.Printf("%4d: %q\n", i, fn)
+		for i, d := range docs {
+			fmt.Printf("%4d: %s\n", i, d)
 		}
 	} else {
 
@@ -176,7 +172,7 @@ func main() {
 	fmt.Fprintf(os.Stderr, "[%s index] Duration=%.1f sec (%.3f index + %.3f search) (%.1f pages/min) "+
 		"%d pages in %d files %+v\n"+
 		"Marked up search results in %q\n",
-		storage, dt.Seconds(), dtIndex.Seconds(), dtSearch.Seconds(),
+		storage, dt.Seconds(), dtIndex.Seconds(), (dt - dtIndex).Seconds(),
 		pagesSec*60.0, numPages, len(pathList), showList, outPath)
 }
 
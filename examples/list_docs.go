@@ -0,0 +1,52 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/peterwilliams97/pdf-search/doclib"
+)
+
+const usage = `Usage: go run list_docs.go [OPTIONS]
+Lists the documents in the index store "store.position" that was created with position_index.go`
+
+var persistDir = "store.position"
+
+func main() {
+	var filter doclib.DocFilter
+	var status string
+	flag.StringVar(&persistDir, "s", persistDir, "Index store directory name.")
+	flag.StringVar(&filter.PathPattern, "p", "", "Only list documents whose path matches this.")
+	flag.StringVar(&status, "t", "", "Only list documents with this status (ok, empty, quarantined).")
+	flag.IntVar(&filter.MinPages, "m", 0, "Only list documents with at least this many pages.")
+	flag.StringVar(&filter.Extractor, "e", "",
+		"Only list documents extracted by this extractor (e.g. unidoc, unidoc/3.0.0, unknown).")
+	flag.IntVar(&filter.Offset, "o", 0, "Number of documents to skip.")
+	flag.IntVar(&filter.Limit, "n", 100, "Max number of documents to list.")
+	doclib.MakeUsage(usage)
+	flag.Parse()
+	doclib.SetLogging()
+	filter.Status = doclib.DocStatus(status)
+
+	docs, total, err := doclib.ListDocs(persistDir, filter)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Could not list %q. err=%v\n", persistDir, err)
+		os.Exit(1)
+	}
+	for _, d := range docs {
+		fmt.Printf("%4d: %.12s %4d pages %6.2f MB %-11s %s %q\n", d.DocIdx, d.Hash, d.NumPages,
+			d.SizeMB, d.Status, d.Indexed.Format("2006-01-02 15:04"), d.InPath)
+		for _, alias := range d.Aliases {
+			fmt.Printf("%50s %q\n", "alias", alias)
+		}
+		for _, e := range d.Extractors {
+			fmt.Printf("%50s %s\n", "extractor", e)
+		}
+		if d.TextQuality > 0 {
+			fmt.Printf("%50s %.2f (%d low quality pages)\n", "text quality", d.TextQuality,
+				d.LowQualityPages)
+		}
+	}
+	fmt.Printf("Showing %d-%d of %d documents\n", filter.Offset+1, filter.Offset+len(docs), total)
+}
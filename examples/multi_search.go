@@ -0,0 +1,88 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/peterwilliams97/pdf-search/doclib"
+)
+
+const usage = `Usage: go run multi_search.go [OPTIONS] -s store1,store2,... Adobe PDF
+Performs a full text search for "Adobe PDF" over several index stores that were created with
+position_index.go and merges the results. Stores starting with http:// or https:// are searched on
+servers started with search_server.go.
+If all the stores are local and -norm is "none", they are searched as one bleve index alias and the
+results are ranked together.`
+
+func main() {
+	stores := "store.position"
+	norm := ""
+	maxResults := 10
+	flag.StringVar(&stores, "s", stores, "Comma separated list of index stores or server URLs.")
+	flag.StringVar(&norm, "norm", norm, "Score normalization: max, minmax or none. "+
+		"The default is none for local stores and max otherwise.")
+	flag.IntVar(&maxResults, "n", maxResults, "Max number of results to return.")
+	doclib.MakeUsage(usage)
+	flag.Parse()
+	doclib.SetLogging()
+	if len(flag.Args()) < 1 {
+		flag.Usage()
+		os.Exit(1)
+	}
+	term := strings.Join(flag.Args(), " ")
+
+	names := strings.Split(stores, ",")
+	remote := false
+	for _, store := range names {
+		if strings.HasPrefix(store, "http://") || strings.HasPrefix(store, "https://") {
+			remote = true
+		}
+	}
+	var multi *doclib.MultiIndex
+	var err error
+	if remote {
+		var searchers []doclib.Searcher
+		for _, store := range names {
+			if strings.HasPrefix(store, "http://") || strings.HasPrefix(store, "https://") {
+				searchers = append(searchers, doclib.NewRemoteIndex(store))
+				continue
+			}
+			pdfIndex, err := doclib.OpenPdfIndex(store)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Could not open %q. err=%v\n", store, err)
+				os.Exit(1)
+			}
+			defer pdfIndex.Close()
+			searchers = append(searchers, pdfIndex)
+		}
+		multi, err = doclib.NewMultiIndex(searchers, names)
+	} else {
+		multi, err = doclib.OpenMultiIndex(names)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Could not open %q. err=%v\n", stores, err)
+		os.Exit(1)
+	}
+	defer multi.Close()
+	switch norm {
+	case "":
+	case "max":
+		multi.Normalization = doclib.NormalizeMax
+	case "minmax":
+		multi.Normalization = doclib.NormalizeMinMax
+	case "none":
+		multi.Normalization = doclib.NormalizeNone
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown normalization %q\n", norm)
+		os.Exit(1)
+	}
+
+	results, err := multi.Search(term, doclib.SearchOptions{MaxResults: maxResults})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Could not search %q. err=%v\n", stores, err)
+		os.Exit(1)
+	}
+	fmt.Printf("%s\n", results)
+}
@@ -5,6 +5,7 @@ import (
 	"flag"
 	"fmt"
 	"os"
+	"strings"
 
 	"github.com/peterwilliams97/pdf-search/doclib"
 )
@@ -19,6 +20,26 @@ func main() {
 	var forceCreate, allowAppend bool
 	flag.BoolVar(&forceCreate, "f", false, "Force creation of a new Bleve index.")
 	flag.BoolVar(&allowAppend, "a", false, "Allow existing an Bleve index to be appended to.")
+	opts := doclib.DefaultIndexOptions()
+	flag.IntVar(&opts.NumWorkers, "w", opts.NumWorkers, "Number of text extraction worker threads.")
+	flag.IntVar(&opts.BatchSize, "b", opts.BatchSize, "Number of pages to add to the Bleve index in a batch.")
+	flag.BoolVar(&opts.Resume, "r", false, "Resume an indexing run that didn't complete.")
+	var useOCR bool
+	flag.BoolVar(&useOCR, "ocr", false, "OCR pages with no text. Needs a build with -tags ocr.")
+	flag.Float64Var(&opts.MinTextQuality, "q", 0,
+		"With -ocr, also OCR pages whose extracted text has a quality score below this (0-1).")
+	var reportPath string
+	flag.StringVar(&reportPath, "j", "", "Write a JSON report of the status of each file to this file.")
+	var termsPath string
+	flag.StringVar(&termsPath, "terms", "",
+		"Export the index terms to this file after indexing. JSON if it ends in .json else TSV.")
+	var pageRanges, exclude string
+	flag.StringVar(&pageRanges, "pages", "",
+		"Only index these pages of each file. e.g. 1-50,60,100- or 1-:2 for the odd pages.")
+	flag.Float64Var(&opts.MaxFileMB, "maxmb", 0, "Don't index files larger than this many MB.")
+	flag.StringVar(&exclude, "x", "", "Comma separated glob patterns of files that aren't indexed.")
+	flag.IntVar(&opts.Shards, "shards", 0,
+		"Split the bleve index of a new store into this many shards. For very big corpora.")
 
 	doclib.MakeUsage(usage)
 	flag.Parse()
@@ -28,6 +49,27 @@ func main() {
 		os.Exit(1)
 	}
 
+	if pageRanges != "" {
+		ranges, err := doclib.ParsePageRanges(pageRanges)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			os.Exit(1)
+		}
+		opts.PageRanges = ranges
+	}
+	if exclude != "" {
+		opts.Exclude = strings.Split(exclude, ",")
+	}
+
+	if useOCR {
+		ocr, err := doclib.NewTesseractOCR("eng", 300)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Could not start OCR. err=%v\n", err)
+			os.Exit(1)
+		}
+		opts.OCR = ocr
+	}
+
 	// Read the list of PDF files that will be processed.
 	pathList, err := doclib.PatternsToPaths(flag.Args(), true)
 	if err != nil {
@@ -35,11 +77,24 @@ func main() {
 		os.Exit(1)
 	}
 	fmt.Fprintf(os.Stderr, "Total of %d PDF files.\n", len(pathList))
-	pathList = doclib.CleanCorpus(pathList)
-	lState, index, totalPages, err := doclib.IndexPdfFiles(pathList, persistDir, forceCreate, allowAppend, report)
+	var indexReport doclib.IndexReport
+	opts.Report = &indexReport
+	lState, index, totalPages, err := doclib.IndexPdfFilesOpts(pathList, persistDir, forceCreate,
+		allowAppend, opts, report)
+	if reportPath != "" {
+		if err := indexReport.SaveJSON(reportPath); err != nil {
+			fmt.Fprintf(os.Stderr, "Could not write report %q. err=%v\n", reportPath, err)
+		}
+	}
 	if err != nil {
 		panic(err)
 	}
+	fmt.Fprintf(os.Stderr, "%s\n", indexReport)
+	if termsPath != "" {
+		if err := doclib.ExportTermDictFile(index, termsPath, 1); err != nil {
+			fmt.Fprintf(os.Stderr, "Could not export terms to %q. err=%v\n", termsPath, err)
+		}
+	}
 	fmt.Fprintf(os.Stderr, "lState=%+v\n", *lState)
 	fmt.Fprintf(os.Stderr, "index=%+v\n", index)
 	fmt.Fprintf(os.Stderr, "totalPages=%d\n", totalPages)
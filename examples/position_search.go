@@ -48,7 +48,7 @@ func main() {
 	}
 
 	// Open existing index.
-	index, err := bleve.Open(indexPath)
+	index, err := doclib.OpenStoreIndex(persistDir)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Could not open Bleve index %q.\n", indexPath)
 		panic(err)
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/peterwilliams97/pdf-search/doclib"
+)
+
+const usage = `Usage: go run remote_search.go [OPTIONS] Adobe PDF
+Performs a full text search for "Adobe PDF" on a server started with search_server.go.`
+
+func main() {
+	server := "http://localhost:8080"
+	outPath := ""
+	maxResults := 10
+	flag.StringVar(&server, "u", server, "URL of server.")
+	flag.StringVar(&outPath, "o", outPath,
+		"If set, the PDFs are fetched from the server and the results are marked up in this file.")
+	flag.IntVar(&maxResults, "n", maxResults, "Max number of results to return.")
+	doclib.MakeUsage(usage)
+	flag.Parse()
+	doclib.SetLogging()
+	if len(flag.Args()) < 1 {
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	term := strings.Join(flag.Args(), " ")
+	remote := doclib.NewRemoteIndex(server)
+	results, err := remote.Search(term, doclib.SearchOptions{MaxResults: maxResults})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Could not search %q. err=%v\n", server, err)
+		os.Exit(1)
+	}
+	fmt.Printf("%s\n", results)
+	if outPath == "" {
+		return
+	}
+
+	// Fetch the PDFs with matches so the results can be marked up locally.
+	dir, err := ioutil.TempDir("", "remote_search")
+	if err != nil {
+		panic(err)
+	}
+	defer os.RemoveAll(dir)
+	local := map[uint64]string{}
+	extractions := doclib.CreateExtractList(maxResults)
+	for _, m := range results.Matches {
+		path, ok := local[m.Doc]
+		if !ok {
+			path = filepath.Join(dir, fmt.Sprintf("%d.pdf", m.Doc))
+			if err := fetchPdf(remote, m.Doc, path); err != nil {
+				fmt.Fprintf(os.Stderr, "Could not fetch %q. err=%v\n", m.InPath, err)
+				os.Exit(1)
+			}
+			local[m.Doc] = path
+		}
+		m.InPath = path
+		extractions.AddPdfMatch(m)
+	}
+	if err := extractions.SaveOutputPdf(outPath); err != nil {
+		fmt.Fprintf(os.Stderr, "Could not save %q. err=%v\n", outPath, err)
+		os.Exit(1)
+	}
+	fmt.Printf("Marked up %d pages in %q\n", extractions.NumPages(), outPath)
+}
+
+// fetchPdf saves the PDF of document `docIdx` on `remote` in `path`.
+func fetchPdf(remote *doclib.RemoteIndex, docIdx uint64, path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return remote.FetchPdf(docIdx, f)
+}
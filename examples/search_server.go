@@ -0,0 +1,44 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+
+	"github.com/peterwilliams97/pdf-search/doclib"
+)
+
+const usage = `Usage: go run search_server.go [OPTIONS]
+Serves searches of the index "store.position" that was created with position_index.go over HTTP.
+Use remote_search.go to search it. See doclib/pdf_server.go for the protocol.`
+
+var persistDir = "store.position"
+
+func main() {
+	addr := ":8080"
+	var admin bool
+	openDocs := 64
+	flag.StringVar(&persistDir, "s", persistDir, "Index store directory name.")
+	flag.StringVar(&addr, "addr", addr, "Address to listen on.")
+	flag.BoolVar(&admin, "admin", false, "Serve the admin endpoints that modify the store.")
+	flag.IntVar(&openDocs, "docs", openDocs, "Max number of documents to keep open between searches.")
+	doclib.MakeUsage(usage)
+	flag.Parse()
+	doclib.SetLogging()
+
+	pdfIndex, err := doclib.OpenPdfIndex(persistDir)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Could not open %q. err=%v\n", persistDir, err)
+		os.Exit(1)
+	}
+	defer pdfIndex.Close()
+	pdfIndex.SetOpenDocs(openDocs)
+
+	fmt.Printf("Serving %q (%d documents) on %q admin=%t\n", persistDir, pdfIndex.NumDocs(), addr,
+		admin)
+	if err := http.ListenAndServe(addr, doclib.NewPdfServer(pdfIndex, admin)); err != nil {
+		fmt.Fprintf(os.Stderr, "Server failed. err=%v\n", err)
+		os.Exit(1)
+	}
+}
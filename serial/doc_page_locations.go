@@ -91,7 +91,7 @@ func ReadDocPageLocations(buf []byte) (DocPageLocations, error) {
 	// Vectors, such as `Locations`, have a method suffixed with 'Length' that can be used
 	// to query the length of the vector. You can index the vector by passing an index value
 	// into the accessor.
-	var locs []TextLocation
+	locs := make([]TextLocation, 0, dpl.LocationsLength())
 	for i := 0; i < dpl.LocationsLength(); i++ {
 		var loc locations.TextLocation
 		ok := dpl.Locations(&loc, i)
@@ -116,6 +116,7 @@ func ReadDocPageLocations(buf []byte) (DocPageLocations, error) {
 // 	lly: float32;
 // 	urx: float32;
 // 	ury: float32;
+// 	end: uint32;
 // }
 // TextLocation describes the location of text on a page.
 type TextLocation struct {
@@ -152,6 +153,7 @@ func addTextLocation(b *flatbuffers.Builder, loc TextLocation) flatbuffers.UOffs
 	locations.TextLocationAddLly(b, loc.Lly)
 	locations.TextLocationAddUrx(b, loc.Urx)
 	locations.TextLocationAddUry(b, loc.Ury)
+	locations.TextLocationAddEnd(b, loc.End)
 	return locations.TextLocationEnd(b)
 }
 
@@ -165,7 +167,7 @@ func getTextLocation(loc *locations.TextLocation) TextLocation {
 	// Copy the TextLocation's fields (since these are numbers).
 	return TextLocation{
 		loc.Offset(),
-		0,
+		loc.End(),
 		loc.Llx(),
 		loc.Lly(),
 		loc.Urx(),
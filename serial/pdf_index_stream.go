@@ -0,0 +1,86 @@
+package serial
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+
+	flatbuffers "github.com/google/flatbuffers/go"
+)
+
+// A SerialPdfIndex stream is a sequence of frames that can be written and read without holding
+// the whole index in memory. Each frame is
+//	kind  byte        One of the Frame* constants.
+//	size  uint64      Number of bytes of data.
+//	check uint32      CRC checksum of data.
+//	data  [size]byte
+// The size and checksum are framed the same way as the commented out WriteDocPageLocations.
+const (
+	FrameHeader byte = 'H' // A SerialPdfIndex with NumFiles and NumPages and no HIPDs.
+	FrameConfig byte = 'C' // The JSON configuration of the index, e.g. its stop words.
+	FrameHIPDs  byte = 'D' // A SerialPdfIndex with a chunk of the HIPDs.
+	FrameBleve  byte = 'B' // A chunk of the exported bleve memory index.
+	FrameEnd    byte = 'E' // The end of the stream. It has no data.
+)
+
+// maxFrameSize is the largest frame ReadFrame accepts. It stops a corrupt size from allocating a
+// huge buffer.
+const maxFrameSize = 1 << 30
+
+// ErrBadChecksum is returned when a frame's data doesn't match its checksum.
+var ErrBadChecksum = errors.New("bad checksum")
+
+// WriteFrame writes `buf` to `w` as a frame of kind `kind`.
+func WriteFrame(w io.Writer, kind byte, buf []byte) error {
+	check := crc32.ChecksumIEEE(buf) // uint32
+	size := uint64(len(buf))
+	if err := binary.Write(w, binary.LittleEndian, kind); err != nil {
+		return err
+	}
+	if err := binary.Write(w, binary.LittleEndian, size); err != nil {
+		return err
+	}
+	if err := binary.Write(w, binary.LittleEndian, check); err != nil {
+		return err
+	}
+	_, err := w.Write(buf)
+	return err
+}
+
+// ReadFrame reads the next frame from `r` and returns its kind and data.
+func ReadFrame(r io.Reader) (byte, []byte, error) {
+	var kind byte
+	var size uint64
+	var check uint32
+	if err := binary.Read(r, binary.LittleEndian, &kind); err != nil {
+		return 0, nil, err
+	}
+	if err := binary.Read(r, binary.LittleEndian, &size); err != nil {
+		return 0, nil, err
+	}
+	if err := binary.Read(r, binary.LittleEndian, &check); err != nil {
+		return 0, nil, err
+	}
+	if size > maxFrameSize {
+		return 0, nil, fmt.Errorf("frame %q is too big. size=%d", kind, size)
+	}
+	buf := make([]byte, size)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return 0, nil, err
+	}
+	if crc32.ChecksumIEEE(buf) != check {
+		return 0, nil, ErrBadChecksum
+	}
+	return kind, buf, nil
+}
+
+// WriteHIPDsFrame writes `hipds` to `w` as a FrameHIPDs frame. `b` is reused between calls.
+func WriteHIPDsFrame(w io.Writer, b *flatbuffers.Builder, hipds []HashIndexPathDoc) error {
+	spi := SerialPdfIndex{NumFiles: uint32(len(hipds)), HIPDs: hipds}
+	for _, hipd := range hipds {
+		spi.NumPages += uint32(len(hipd.Doc.PageNums))
+	}
+	return WriteFrame(w, FrameHIPDs, MakeSerialPdfIndex(b, spi))
+}